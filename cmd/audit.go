@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"miser/internal/audit"
+	"miser/internal/config"
+)
+
+var (
+	auditSince string
+	auditOut   output
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Show the log of administrative actions",
+	Long: `Lists the administrative actions a proxy has recorded, oldest first:
+session clears and moves, budget changes and resets, pricing reloads and
+exports, from the dashboard or the /miser/api/admin/ endpoints. API
+actions name the caller's address, whether it used the [api] token, and
+its User-Agent.`,
+	Example: `  miser audit
+  miser audit --since 7d --json`,
+	Args: cobra.NoArgs,
+	RunE: runAudit,
+}
+
+func init() {
+	auditCmd.Flags().StringVar(&auditSince, "since", "",
+		"only include actions after this (24h, 7d, 2026-01-31, RFC 3339)")
+	addOutputFlags(auditCmd, &auditOut)
+	rootCmd.AddCommand(auditCmd)
+}
+
+// auditPath returns where the audit log is kept.
+func auditPath(cfg config.Config) string {
+	if cfg.Audit.Path != "" {
+		return cfg.Audit.Path
+	}
+	return profilePath(cfg, audit.DefaultPath())
+}
+
+func runAudit(cmd *cobra.Command, _ []string) error {
+	cfg, err := resolveConfig(cmd)
+	if err != nil {
+		return err
+	}
+	since, err := parseSince(auditSince, time.Now())
+	if err != nil {
+		return fmt.Errorf("--since: %w", err)
+	}
+	path := auditPath(cfg)
+	all, err := audit.Load(path)
+	if err != nil {
+		return err
+	}
+	entries := []audit.Entry{}
+	for _, e := range all {
+		if !e.Time.Before(since) {
+			entries = append(entries, e)
+		}
+	}
+
+	switch {
+	case auditOut.json:
+		return writeJSON(os.Stdout, entries)
+	case auditOut.tsv:
+		rows := make([][]string, len(entries))
+		for i, e := range entries {
+			rows[i] = []string{e.Time.Format(time.RFC3339), e.Action, e.Source, e.Detail, e.Error}
+		}
+		return writeTSV(os.Stdout, []string{"time", "action", "source", "detail", "error"}, rows)
+	}
+	if len(entries) == 0 {
+		fmt.Printf("No administrative actions recorded in %s.\n", path)
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tACTION\tSOURCE\tDETAIL")
+	for _, e := range entries {
+		detail := e.Detail
+		if e.Error != "" {
+			detail = strings.TrimSpace(detail + " (failed: " + e.Error + ")")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", e.Time.Local().Format("2006-01-02 15:04:05"), e.Action, e.Source, detail)
+	}
+	return tw.Flush()
+}
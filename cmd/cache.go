@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"miser/internal/format"
+	"miser/internal/tracker"
+)
+
+var (
+	cacheSince       string
+	cacheMinRequests int
+	cacheTop         int
+	cacheOut         output
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Show how well prompt caching works, conversation by conversation",
+	Long: `Groups the requests in the history into conversations and shows for
+each how its prompt grew, how much of it cache reads covered, what caching
+saved, and where a cache breakpoint would help. The proxy does not need to
+be running.`,
+	Example: `  miser cache
+  miser cache --since 24h --top 5
+  miser cache --json`,
+	Args: cobra.NoArgs,
+	RunE: runCache,
+}
+
+func init() {
+	cacheCmd.Flags().StringVar(&cacheSince, "since", "",
+		"only include requests after this (24h, 7d, 2026-01-31, RFC 3339)")
+	cacheCmd.Flags().IntVar(&cacheMinRequests, "min-requests", 2, "leave out conversations with fewer requests")
+	cacheCmd.Flags().IntVar(&cacheTop, "top", 20, "number of most expensive conversations to list; 0 for all")
+	addOutputFlags(cacheCmd, &cacheOut)
+	rootCmd.AddCommand(cacheCmd)
+}
+
+type cacheConversation struct {
+	ID          string    `json:"id"`
+	Model       string    `json:"model"`
+	Project     string    `json:"project,omitempty"`
+	Client      string    `json:"client,omitempty"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Requests    int       `json:"requests"`
+	Messages    int       `json:"messages"`
+	FirstPrompt int       `json:"first_prompt_tokens"`
+	LastPrompt  int       `json:"last_prompt_tokens"`
+	CacheRead   int       `json:"cache_read"`
+	CacheWrite  int       `json:"cache_write"`
+	HitRate     float64   `json:"cache_hit_rate"` // percent of prompt tokens
+	Cost        float64   `json:"cost"`
+	Saved       float64   `json:"saved"`
+	Potential   float64   `json:"potential"` // savings with a breakpoint after each request's messages
+	Advice      string    `json:"advice"`
+}
+
+func runCache(cmd *cobra.Command, _ []string) error {
+	cfg, err := resolveConfig(cmd)
+	if err != nil {
+		return err
+	}
+	if err := applyFormat(cfg); err != nil {
+		return err
+	}
+	applyPricing(cfg)
+	since, err := parseSince(cacheSince, time.Now())
+	if err != nil {
+		return err
+	}
+	t, err := loadHistory(cfg)
+	if err != nil {
+		return err
+	}
+
+	convs := []cacheConversation{}
+	for _, c := range t.GetConversationStatsSince(since) {
+		if c.TotalRequests < cacheMinRequests {
+			continue
+		}
+		if cacheTop > 0 && len(convs) == cacheTop {
+			break
+		}
+		convs = append(convs, cacheConversation{
+			ID:          c.ID,
+			Model:       c.Model,
+			Project:     c.Project,
+			Client:      c.Client,
+			Start:       c.Start,
+			End:         c.End,
+			Requests:    c.TotalRequests,
+			Messages:    c.Messages,
+			FirstPrompt: c.FirstPrompt,
+			LastPrompt:  c.LastPrompt,
+			CacheRead:   c.TotalCacheR,
+			CacheWrite:  c.TotalCacheW,
+			HitRate:     c.CacheHitRate(),
+			Cost:        c.TotalCost,
+			Saved:       c.Saved,
+			Potential:   c.Potential,
+			Advice:      cacheAdvice(c),
+		})
+	}
+	switch {
+	case cacheOut.json:
+		return writeJSON(os.Stdout, convs)
+	case cacheOut.tsv:
+		return writeCacheTSV(os.Stdout, convs)
+	}
+	if len(convs) == 0 {
+		fmt.Printf("No conversations of %d or more requests in the history.\n", cacheMinRequests)
+		return nil
+	}
+	return printCache(os.Stdout, convs)
+}
+
+// cacheAdvice says, in a few words, whether a conversation's caching works
+// and where a breakpoint would help.
+func cacheAdvice(c tracker.ConversationStats) string {
+	missed := c.Potential - c.Saved
+	switch {
+	case c.LastPrompt < tracker.MinCacheable:
+		return "too small to cache"
+	case c.TotalCacheR == 0 && c.TotalCacheW == 0:
+		if c.Potential > 0 {
+			return "not cached; a breakpoint after the messages would save " + format.Cost(c.Potential)
+		}
+		return "not cached; pauses outlast the cache"
+	case c.TotalCacheR < c.TotalCacheW && c.MaxGap > tracker.CacheTTL:
+		return fmt.Sprintf("written, rarely read; pauses of up to %s outlast the cache",
+			strings.TrimSuffix(c.MaxGap.Round(time.Minute).String(), "0s"))
+	case c.TotalCacheR < c.TotalCacheW:
+		return "written, rarely read; the breakpoint is after content that changes each turn"
+	case missed > 0.25*c.Potential && missed >= 0.01:
+		return "partly cached; a breakpoint after the last message would save " + format.Cost(missed) + " more"
+	default:
+		return "cached well"
+	}
+}
+
+func writeCacheTSV(w io.Writer, convs []cacheConversation) error {
+	var rows [][]string
+	for _, c := range convs {
+		rows = append(rows, []string{c.ID, c.Model, c.Project, c.Client,
+			c.Start.Format(time.RFC3339), c.End.Format(time.RFC3339),
+			strconv.Itoa(c.Requests), strconv.Itoa(c.Messages),
+			strconv.Itoa(c.FirstPrompt), strconv.Itoa(c.LastPrompt),
+			strconv.Itoa(c.CacheRead), strconv.Itoa(c.CacheWrite),
+			tsvFloat(c.HitRate), tsvFloat(c.Cost), tsvFloat(c.Saved), tsvFloat(c.Potential), c.Advice,
+		})
+	}
+	return writeTSV(w, []string{"id", "model", "project", "client", "start", "end",
+		"requests", "messages", "first_prompt_tokens", "last_prompt_tokens",
+		"cache_read", "cache_write", "cache_hit_rate", "cost", "saved", "potential", "advice"}, rows)
+}
+
+func printCache(w io.Writer, convs []cacheConversation) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONVERSATION\tMODEL\tREQS\tPROMPT\tCACHE HIT\tCOST\tSAVED\tADVICE")
+	for _, c := range convs {
+		fmt.Fprintln(tw, strings.Join([]string{c.ID, c.Model, format.Int(c.Requests),
+			format.Tokens(c.FirstPrompt) + " → " + format.Tokens(c.LastPrompt),
+			fmt.Sprintf("%.0f%%", c.HitRate),
+			format.Cost(c.Cost), format.Cost(c.Saved), c.Advice,
+		}, "\t"))
+	}
+	return tw.Flush()
+}
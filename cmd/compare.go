@@ -0,0 +1,350 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"miser/internal/format"
+	"miser/internal/tracker"
+)
+
+var (
+	compareA   string
+	compareB   string
+	compareOut output
+)
+
+var compareCmd = &cobra.Command{
+	Use:   "compare",
+	Short: "Compare spend between two periods or conversations",
+	Long: `Reads the request history and prints the totals of two spans side by side,
+with the change from A to B, and per model, to show what switching models
+or enabling caching did. The proxy does not need to be running.
+
+A span is a period — today, yesterday, this-week, last-week, this-month,
+last-month, a date (2026-01-31), a range of dates (2026-01-01..2026-01-15,
+both days included) or a duration up to now (24h, 7d) — or a conversation,
+as conversation:<id> with the id "miser cache" shows, or its start.`,
+	Example: `  miser compare --a yesterday --b today
+  miser compare --a last-week --b this-week --json
+  miser compare --a conversation:9c1e04b27a3f --b conversation:51d2a8e0c6b9`,
+	Args: cobra.NoArgs,
+	RunE: runCompare,
+}
+
+func init() {
+	compareCmd.Flags().StringVar(&compareA, "a", "yesterday", "the span to compare from")
+	compareCmd.Flags().StringVar(&compareB, "b", "today", "the span to compare with it")
+	addOutputFlags(compareCmd, &compareOut)
+	rootCmd.AddCommand(compareCmd)
+}
+
+// span selects the requests of one side of a comparison: those started in
+// [from, to), or those of one conversation.
+type span struct {
+	label        string
+	from, to     time.Time // zero for no bound
+	conversation string    // an id, or the start of one
+}
+
+// parseSpan reads a --a or --b value; see compareCmd.
+func parseSpan(s string, now time.Time) (span, error) {
+	sp := span{label: s}
+	day := func(t time.Time) time.Time {
+		y, m, d := t.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	}
+	today := day(now)
+	week := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7)) // Monday
+	month := today.AddDate(0, 0, 1-today.Day())
+	switch strings.ToLower(s) {
+	case "today":
+		sp.from, sp.to = today, today.AddDate(0, 0, 1)
+	case "yesterday":
+		sp.from, sp.to = today.AddDate(0, 0, -1), today
+	case "this-week":
+		sp.from, sp.to = week, week.AddDate(0, 0, 7)
+	case "last-week":
+		sp.from, sp.to = week.AddDate(0, 0, -7), week
+	case "this-month":
+		sp.from, sp.to = month, month.AddDate(0, 1, 0)
+	case "last-month":
+		sp.from, sp.to = month.AddDate(0, -1, 0), month
+	default:
+		if id, ok := strings.CutPrefix(s, "conversation:"); ok {
+			if id == "" {
+				return span{}, fmt.Errorf("invalid span %q: no conversation id", s)
+			}
+			sp.conversation = id
+			return sp, nil
+		}
+		if a, b, ok := strings.Cut(s, ".."); ok {
+			from, err := time.ParseInLocation("2006-01-02", a, now.Location())
+			if err != nil {
+				return span{}, fmt.Errorf("invalid span %q: want a range of dates, e.g. 2026-01-01..2026-01-15", s)
+			}
+			to, err := time.ParseInLocation("2006-01-02", b, now.Location())
+			if err != nil || to.Before(from) {
+				return span{}, fmt.Errorf("invalid span %q: want a range of dates, e.g. 2026-01-01..2026-01-15", s)
+			}
+			sp.from, sp.to = from, to.AddDate(0, 0, 1)
+			return sp, nil
+		}
+		if d, err := time.ParseInLocation("2006-01-02", s, now.Location()); err == nil {
+			sp.from, sp.to = d, d.AddDate(0, 0, 1)
+			return sp, nil
+		}
+		from, err := parseSince(s, now)
+		if err != nil {
+			return span{}, fmt.Errorf("invalid span %q (want e.g. yesterday, last-week, 2026-01-31, 7d or conversation:<id>)", s)
+		}
+		sp.from = from
+	}
+	return sp, nil
+}
+
+func (sp span) match(r tracker.Request) bool {
+	if sp.conversation != "" {
+		return r.Conversation != "" && strings.HasPrefix(r.Conversation, sp.conversation)
+	}
+	return !r.Timestamp.Before(sp.from) && (sp.to.IsZero() || r.Timestamp.Before(sp.to))
+}
+
+type compareSide struct {
+	Span         string      `json:"span"`
+	From         *time.Time  `json:"from,omitempty"`
+	To           *time.Time  `json:"to,omitempty"`
+	Conversation string      `json:"conversation,omitempty"`
+	Summary      statsTotals `json:"summary"`
+}
+
+type compareModel struct {
+	Model string      `json:"model"`
+	A     statsTotals `json:"a"`
+	B     statsTotals `json:"b"`
+}
+
+type compareOutput struct {
+	A      compareSide    `json:"a"`
+	B      compareSide    `json:"b"`
+	Models []compareModel `json:"models"`
+}
+
+func runCompare(cmd *cobra.Command, _ []string) error {
+	cfg, err := resolveConfig(cmd)
+	if err != nil {
+		return err
+	}
+	if err := applyFormat(cfg); err != nil {
+		return err
+	}
+	now := time.Now()
+	a, err := parseSpan(compareA, now)
+	if err != nil {
+		return fmt.Errorf("--a: %w", err)
+	}
+	b, err := parseSpan(compareB, now)
+	if err != nil {
+		return fmt.Errorf("--b: %w", err)
+	}
+	t, err := loadHistory(cfg)
+	if err != nil {
+		return err
+	}
+
+	out := compareOutput{Models: []compareModel{}}
+	models := map[string]int{} // index in out.Models
+	for i, sp := range []span{a, b} {
+		side, ms := compareSpan(t, sp)
+		if i == 0 {
+			out.A = side
+		} else {
+			out.B = side
+		}
+		for _, m := range ms {
+			j, ok := models[m.Model]
+			if !ok {
+				j = len(out.Models)
+				models[m.Model] = j
+				out.Models = append(out.Models, compareModel{Model: m.Model})
+			}
+			cm := &out.Models[j]
+			if i == 0 {
+				cm.A = m.statsTotals
+			} else {
+				cm.B = m.statsTotals
+			}
+		}
+	}
+
+	switch {
+	case compareOut.json:
+		return writeJSON(os.Stdout, out)
+	case compareOut.tsv:
+		return writeCompareTSV(os.Stdout, out)
+	}
+	return printCompare(os.Stdout, out)
+}
+
+// compareSpan totals the requests in sp, overall and per model.
+func compareSpan(t *tracker.Tracker, sp span) (compareSide, []statsModel) {
+	sub := tracker.New()
+	var reqs []tracker.Request
+	for _, r := range t.GetRequests() {
+		if sp.match(r) {
+			reqs = append(reqs, r)
+		}
+	}
+	sub.Load(reqs)
+
+	side := compareSide{Span: sp.label, Conversation: sp.conversation, Summary: totalsOf(sub.GetSummary())}
+	if !sp.from.IsZero() {
+		side.From = &sp.from
+	}
+	if !sp.to.IsZero() {
+		side.To = &sp.to
+	}
+	var models []statsModel
+	for _, ms := range sub.GetModelStats() {
+		models = append(models, statsModel{Model: ms.Model, statsTotals: statsTotals{
+			Requests:   ms.Requests,
+			Errors:     ms.Errors,
+			Input:      ms.InputTokens,
+			Output:     ms.OutputTokens,
+			Tool:       ms.ToolTokens,
+			CacheRead:  ms.CacheRead,
+			CacheWrite: ms.CacheWrite,
+			Cost:       ms.TotalCost,
+		}})
+	}
+	return side, models
+}
+
+// writeCompareTSV writes the total and each model, with both sides' figures
+// in adjacent columns.
+func writeCompareTSV(w io.Writer, out compareOutput) error {
+	row := func(scope, label string, a, b statsTotals) []string {
+		return []string{scope, label,
+			strconv.Itoa(a.Requests), strconv.Itoa(b.Requests),
+			strconv.Itoa(a.Errors), strconv.Itoa(b.Errors),
+			strconv.Itoa(a.Input), strconv.Itoa(b.Input),
+			strconv.Itoa(a.Output), strconv.Itoa(b.Output),
+			strconv.Itoa(a.CacheRead), strconv.Itoa(b.CacheRead),
+			strconv.Itoa(a.CacheWrite), strconv.Itoa(b.CacheWrite),
+			tsvFloat(a.Cost), tsvFloat(b.Cost),
+		}
+	}
+	rows := [][]string{row("total", "", out.A.Summary, out.B.Summary)}
+	for _, m := range out.Models {
+		rows = append(rows, row("model", m.Model, m.A, m.B))
+	}
+	return writeTSV(w, []string{"scope", "label", "a_requests", "b_requests", "a_errors", "b_errors",
+		"a_input_tokens", "b_input_tokens", "a_output_tokens", "b_output_tokens",
+		"a_cache_read", "b_cache_read", "a_cache_write", "b_cache_write", "a_cost", "b_cost"}, rows)
+}
+
+func printCompare(w io.Writer, out compareOutput) error {
+	a, b := out.A.Summary, out.B.Summary
+	fmt.Fprintf(w, "A: %s\nB: %s\n\n", describeSide(out.A), describeSide(out.B))
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tA\tB\tCHANGE")
+	line := func(name, va, vb, change string) {
+		fmt.Fprintln(tw, strings.Join([]string{name, va, vb, change}, "\t"))
+	}
+	line("Cost", format.Cost(a.Cost), format.Cost(b.Cost), costChange(a.Cost, b.Cost))
+	line("Requests", format.Int(a.Requests), format.Int(b.Requests), percentChange(float64(a.Requests), float64(b.Requests)))
+	line("Cost/request", format.Cost(perRequest(a)), format.Cost(perRequest(b)), percentChange(perRequest(a), perRequest(b)))
+	line("Errors", format.Int(a.Errors), format.Int(b.Errors), percentChange(float64(a.Errors), float64(b.Errors)))
+	line("Input", format.Tokens(a.Input), format.Tokens(b.Input), percentChange(float64(a.Input), float64(b.Input)))
+	line("Output", format.Tokens(a.Output), format.Tokens(b.Output), percentChange(float64(a.Output), float64(b.Output)))
+	line("Cache read", format.Tokens(a.CacheRead), format.Tokens(b.CacheRead), percentChange(float64(a.CacheRead), float64(b.CacheRead)))
+	line("Cache write", format.Tokens(a.CacheWrite), format.Tokens(b.CacheWrite), percentChange(float64(a.CacheWrite), float64(b.CacheWrite)))
+	ha, hb := hitRate(a), hitRate(b)
+	line("Cache hit rate", fmt.Sprintf("%.0f%%", ha), fmt.Sprintf("%.0f%%", hb), fmt.Sprintf("%+.0f pts", hb-ha))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(out.Models) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODEL\tA REQS\tB REQS\tA COST\tB COST\tCHANGE")
+	for _, m := range out.Models {
+		fmt.Fprintln(tw, strings.Join([]string{m.Model,
+			format.Int(m.A.Requests), format.Int(m.B.Requests),
+			format.Cost(m.A.Cost), format.Cost(m.B.Cost), costChange(m.A.Cost, m.B.Cost),
+		}, "\t"))
+	}
+	return tw.Flush()
+}
+
+// describeSide names a span and what it covers, e.g. "yesterday (2026-01-30
+// 00:00 to 2026-01-31 00:00), $4.1820 over 312 requests".
+func describeSide(s compareSide) string {
+	text := s.Span
+	switch {
+	case s.Conversation != "":
+	case s.To != nil:
+		text += fmt.Sprintf(" (%s to %s)", s.From.Format("2006-01-02 15:04"), s.To.Format("2006-01-02 15:04"))
+	case s.From != nil:
+		text += " (since " + s.From.Format("2006-01-02 15:04") + ")"
+	}
+	if s.Summary.Requests == 0 {
+		return text + ", no requests"
+	}
+	return text + fmt.Sprintf(", %s over %s requests", format.Cost(s.Summary.Cost), format.Int(s.Summary.Requests))
+}
+
+// costChange shows the difference from a to b in money and percent, e.g.
+// "-$2.1000 (-50.0%)".
+func costChange(a, b float64) string {
+	d := format.Cost(b - a)
+	if b >= a {
+		d = "+" + d
+	}
+	return d + " (" + percentChange(a, b) + ")"
+}
+
+// percentChange shows the change from a to b as a percentage of a, or
+// "new" when a is zero and b isn't.
+func percentChange(a, b float64) string {
+	switch {
+	case a == b:
+		return "0%"
+	case a == 0:
+		return "new"
+	}
+	pct := (b - a) / a * 100
+	if math.Abs(pct) >= 100 {
+		return fmt.Sprintf("%+.0f%%", pct)
+	}
+	return fmt.Sprintf("%+.1f%%", pct)
+}
+
+func perRequest(s statsTotals) float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return s.Cost / float64(s.Requests)
+}
+
+// hitRate is the percentage of prompt tokens read from the cache, as in
+// ModelStats.CacheHitRate.
+func hitRate(s statsTotals) float64 {
+	prompt := s.Input + s.CacheRead + s.CacheWrite
+	if prompt == 0 {
+		return 0
+	}
+	return float64(s.CacheRead) / float64(prompt) * 100
+}
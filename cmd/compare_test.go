@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"miser/internal/tracker"
+)
+
+func TestParseSpan(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC) // a Wednesday
+	day := func(m time.Month, d int) time.Time { return time.Date(2026, m, d, 0, 0, 0, 0, time.UTC) }
+	cases := map[string][2]time.Time{
+		"today":                  {day(10, 14), day(10, 15)},
+		"Yesterday":              {day(10, 13), day(10, 14)},
+		"this-week":              {day(10, 12), day(10, 19)},
+		"last-week":              {day(10, 5), day(10, 12)},
+		"last-month":             {day(9, 1), day(10, 1)},
+		"2026-10-01":             {day(10, 1), day(10, 2)},
+		"2026-10-01..2026-10-07": {day(10, 1), day(10, 8)},
+		"7d":                     {now.AddDate(0, 0, -7), {}},
+	}
+	for in, want := range cases {
+		sp, err := parseSpan(in, now)
+		if err != nil || !sp.from.Equal(want[0]) || !sp.to.Equal(want[1]) {
+			t.Errorf("parseSpan(%q) = %v..%v, %v; want %v..%v", in, sp.from, sp.to, err, want[0], want[1])
+		}
+	}
+	for _, bad := range []string{"tomorrow", "2026-10-07..2026-10-01", "conversation:", "7x"} {
+		if _, err := parseSpan(bad, now); err == nil {
+			t.Errorf("parseSpan(%q) should fail", bad)
+		}
+	}
+
+	sp, err := parseSpan("conversation:9c1e", now)
+	if err != nil || !sp.match(tracker.Request{Conversation: "9c1e04b27a3f"}) || sp.match(tracker.Request{}) {
+		t.Errorf("conversation span = %+v, %v", sp, err)
+	}
+}
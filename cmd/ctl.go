@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"miser/internal/api"
+	"miser/internal/export"
+	"miser/internal/format"
+)
+
+const ctlTimeout = 30 * time.Second
+
+var (
+	ctlAddr  string
+	ctlToken string
+
+	ctlBudgetReset bool
+
+	ctlExportFormat string
+	ctlExportOutput string
+	ctlExportAll    bool
+)
+
+var ctlCmd = &cobra.Command{
+	Use:   "ctl",
+	Short: "Control a running miser proxy",
+	Long: `Sends a command to a running miser instance (for example one started with
+--daemon) through its API on the proxy port. Remote instances need their
+API token; see "miser watch".`,
+	Example: `  miser ctl clear
+  miser ctl session 9h
+  miser ctl budget 25 --reset
+  miser ctl reload-pricing
+  miser ctl export -o session.csv`,
+}
+
+var ctlClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Start a new session now",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return withCtl(cmd, func(ctx context.Context, c *api.Client) error {
+			s, err := c.Clear(ctx)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("New session started at %s\n", s.SessionStart.Format("15:04:05"))
+			return nil
+		})
+	},
+}
+
+var ctlSessionCmd = &cobra.Command{
+	Use:   "session <since>",
+	Short: "Move the session start, e.g. to resume an earlier session",
+	Long: `Sets the start of the running instance's session. <since> takes the same
+forms as --since elsewhere: a duration ago (90m, 24h), days or weeks (7d,
+2w), a date (2026-01-31) or an RFC 3339 timestamp.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		start, err := parseSince(args[0], time.Now())
+		if err != nil {
+			return err
+		}
+		return withCtl(cmd, func(ctx context.Context, c *api.Client) error {
+			if err := c.SetSessionStart(ctx, start); err != nil {
+				return err
+			}
+			fmt.Printf("Session now starts at %s\n", start.Format("2006-01-02 15:04:05"))
+			return nil
+		})
+	},
+}
+
+var ctlBudgetCmd = &cobra.Command{
+	Use:   "budget <amount>",
+	Short: "Set the spend limit in dollars (0 disables it)",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		amount, err := strconv.ParseFloat(args[0], 64)
+		if err != nil || amount < 0 {
+			return fmt.Errorf("invalid budget amount %q", args[0])
+		}
+		return withCtl(cmd, func(ctx context.Context, c *api.Client) error {
+			b, err := c.SetBudget(ctx, api.BudgetUpdate{Amount: amount, Reset: ctlBudgetReset})
+			if err != nil {
+				return err
+			}
+			if b.Limit == 0 {
+				fmt.Println("Budget disabled")
+				return nil
+			}
+			fmt.Printf("Budget %s, %s spent since %s", format.Cost(b.Limit), format.Cost(b.Spent), b.Since.Format("2006-01-02 15:04"))
+			if !b.Until.IsZero() {
+				fmt.Printf(", spend drops %s", b.Until.Local().Format("2006-01-02 15:04"))
+			}
+			fmt.Println()
+			return nil
+		})
+	},
+}
+
+var ctlReloadPricingCmd = &cobra.Command{
+	Use:   "reload-pricing",
+	Short: "Re-read model pricing from the instance's config file",
+	Long: `Makes the running instance re-read [models] and [fallback] from its config
+file. New prices apply to requests from now on; use "miser replay" to
+recompute past ones.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return withCtl(cmd, func(ctx context.Context, c *api.Client) error {
+			if err := c.ReloadPricing(ctx); err != nil {
+				return err
+			}
+			fmt.Println("Pricing reloaded")
+			return nil
+		})
+	},
+}
+
+var ctlExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the running session's requests",
+	Long: `Fetches the running instance's session (or, with --all, everything it has
+loaded) in an export format. Output goes to stdout unless --output is
+given; the format follows the output file's extension unless --format is
+set.`,
+	Args: cobra.NoArgs,
+	RunE: runCtlExport,
+}
+
+func init() {
+	pf := ctlCmd.PersistentFlags()
+	pf.StringVar(&ctlAddr, "addr", "",
+		"address of the running proxy (default: localhost:<configured port>)")
+	pf.StringVar(&ctlToken, "token", "",
+		"API token of the proxy (default: [api] token) [$MISER_API_TOKEN]")
+
+	ctlBudgetCmd.Flags().BoolVar(&ctlBudgetReset, "reset", false,
+		"also zero spend and start the budget window now")
+
+	f := ctlExportCmd.Flags()
+	f.StringVarP(&ctlExportFormat, "format", "f", "",
+		"csv, json, jsonl, ndjson, md or sqlite (default: from --output extension, else csv)")
+	f.StringVarP(&ctlExportOutput, "output", "o", "", "write to this file instead of stdout")
+	f.BoolVar(&ctlExportAll, "all", false, "include all loaded history, not just the session")
+
+	ctlCmd.AddCommand(ctlClearCmd, ctlSessionCmd, ctlBudgetCmd, ctlReloadPricingCmd, ctlExportCmd)
+	rootCmd.AddCommand(ctlCmd)
+}
+
+func runCtlExport(cmd *cobra.Command, _ []string) error {
+	name := ctlExportFormat
+	if name == "" {
+		name = filepath.Ext(ctlExportOutput)
+	}
+	f := export.CSV
+	if name != "" {
+		var err error
+		if f, err = export.ParseFormat(name); err != nil {
+			return err
+		}
+	}
+
+	if ctlExportOutput == "" {
+		if err := checkBinaryStdout(f); err != nil {
+			return err
+		}
+	}
+
+	return withCtl(cmd, func(ctx context.Context, c *api.Client) error {
+		if ctlExportOutput == "" {
+			return c.Export(ctx, os.Stdout, string(f), ctlExportAll)
+		}
+		out, err := os.Create(ctlExportOutput)
+		if err != nil {
+			return err
+		}
+		if err := c.Export(ctx, out, string(f), ctlExportAll); err != nil {
+			out.Close()
+			os.Remove(ctlExportOutput)
+			return err
+		}
+		return out.Close()
+	})
+}
+
+// withCtl calls fn with a client for the instance selected by --addr and
+// --token, or the configured port and token.
+func withCtl(cmd *cobra.Command, fn func(context.Context, *api.Client) error) error {
+	cfg, err := resolveConfig(cmd)
+	if err != nil {
+		return err
+	}
+	addr := ctlAddr
+	if addr == "" {
+		addr = fmt.Sprintf("localhost:%d", cfg.Proxy.Port)
+	}
+	c := api.NewClient(addr)
+	c.Token = cfg.API.Token
+	c.UserAgent = ctlUserAgent()
+	if cmd.Flags().Changed("token") {
+		c.Token = ctlToken
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ctlTimeout)
+	defer cancel()
+	return fn(ctx, c)
+}
+
+// ctlUserAgent names miser ctl and the local user, for the proxy's audit
+// log. The user is as claimed by this machine, not authenticated.
+func ctlUserAgent() string {
+	ua := "miser-ctl/" + Version
+	if u, err := user.Current(); err == nil {
+		ua += " (" + u.Username + ")"
+	}
+	return ua
+}
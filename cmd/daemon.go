@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"miser/internal/api"
+	"miser/internal/config"
+	"miser/internal/daemon"
+)
+
+const (
+	daemonStartTimeout = 5 * time.Second
+	daemonStopTimeout  = 10 * time.Second
+)
+
+var stopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop a miser proxy started with --daemon",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		cfg, err := resolveConfig(cmd)
+		if err != nil {
+			return err
+		}
+		pid, err := daemon.Stop(pidPath(cfg), daemonStopTimeout)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Stopped miser (pid %d)\n", pid)
+		return nil
+	},
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether a miser proxy started with --daemon is running",
+	Long: `Reports the background proxy's pid and, if it answers on the configured
+port, its version, uptime and request count. Exits non-zero when it is not
+running.`,
+	Args: cobra.NoArgs,
+	RunE: runStatus,
+}
+
+func init() {
+	statusCmd.Flags().IntVarP(&port, "port", "p", 0,
+		"port the proxy was started on [$MISER_PORT]")
+	addOutputFlags(statusCmd, &statusOut)
+	rootCmd.AddCommand(stopCmd)
+	rootCmd.AddCommand(statusCmd)
+}
+
+var statusOut output
+
+// statusOutput is the --json / --tsv form of "miser status". Fields after
+// Answering are zero unless the proxy answered.
+type statusOutput struct {
+	Running   bool       `json:"running"`
+	Pid       int        `json:"pid,omitempty"`
+	Addr      string     `json:"addr"`
+	Answering bool       `json:"answering"`
+	Target    string     `json:"target,omitempty"`
+	Version   string     `json:"version,omitempty"`
+	Started   *time.Time `json:"started,omitempty"`
+	UptimeSec int64      `json:"uptime_seconds,omitempty"`
+	Requests  int        `json:"requests"`
+	Log       string     `json:"log"`
+}
+
+func runStatus(cmd *cobra.Command, _ []string) error {
+	cfg, err := resolveConfig(cmd)
+	if err != nil {
+		return err
+	}
+	addr := fmt.Sprintf("localhost:%d", cfg.Proxy.Port)
+	out := statusOutput{Addr: addr, Log: logPath(cfg)}
+
+	pid, err := daemon.Running(pidPath(cfg))
+	if err != nil {
+		if statusOut.text() || !errors.Is(err, daemon.ErrNotRunning) {
+			return err
+		}
+		if werr := writeStatus(out); werr != nil {
+			return werr
+		}
+		return err
+	}
+	out.Running, out.Pid = true, pid
+
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	st, err := api.NewClient(addr).Status(ctx)
+	if err == nil {
+		out.Answering = true
+		out.Target, out.Version, out.Requests = st.Target, st.Version, st.Requests
+		out.Started = &st.Started
+		out.UptimeSec = int64(time.Since(st.Started).Seconds())
+	}
+	if !statusOut.text() {
+		return writeStatus(out)
+	}
+
+	fmt.Printf("miser is running (pid %d)\n", pid)
+	if err != nil {
+		fmt.Printf("  not answering on %s: %v\n", addr, err)
+		return nil
+	}
+	fmt.Printf("  listening  %s → %s\n", addr, st.Target)
+	fmt.Printf("  version    %s\n", st.Version)
+	fmt.Printf("  uptime     %s\n", time.Since(st.Started).Round(time.Second))
+	fmt.Printf("  requests   %d\n", st.Requests)
+	fmt.Printf("  log        %s\n", logPath(cfg))
+	return nil
+}
+
+func writeStatus(out statusOutput) error {
+	if statusOut.json {
+		return writeJSON(os.Stdout, out)
+	}
+	started := ""
+	if out.Started != nil {
+		started = out.Started.Format(time.RFC3339)
+	}
+	return writeTSV(os.Stdout,
+		[]string{"running", "pid", "addr", "answering", "target", "version", "started", "uptime_seconds", "requests", "log"},
+		[][]string{{strconv.FormatBool(out.Running), strconv.Itoa(out.Pid), out.Addr, strconv.FormatBool(out.Answering),
+			out.Target, out.Version, started, strconv.FormatInt(out.UptimeSec, 10), strconv.Itoa(out.Requests), out.Log}})
+}
+
+// startDaemon relaunches the current command in the background and waits
+// for it to answer on the configured port.
+func startDaemon(cfg config.Config) error {
+	addr := fmt.Sprintf("localhost:%d", cfg.Proxy.Port)
+	pid, err := daemon.Start(pidPath(cfg), logPath(cfg), daemonStartTimeout,
+		func() bool { return instanceRunning(addr) })
+	if err != nil {
+		return err
+	}
+	upstream := cfg.Proxy.Target
+	if cfg.Mock.Enabled {
+		upstream = mockTarget
+	}
+	fmt.Printf("miser started in the background (pid %d), listening on :%d → %s\n",
+		pid, cfg.Proxy.Port, upstream)
+	fmt.Printf("Logging to %s. Stop it with \"miser stop\".\n", logPath(cfg))
+	return nil
+}
+
+func pidPath(cfg config.Config) string {
+	if cfg.Daemon.PidFile != "" {
+		return cfg.Daemon.PidFile
+	}
+	return profilePath(cfg, daemon.DefaultPidPath())
+}
+
+func logPath(cfg config.Config) string {
+	if cfg.Daemon.LogFile != "" {
+		return cfg.Daemon.LogFile
+	}
+	return profilePath(cfg, daemon.DefaultLogPath())
+}
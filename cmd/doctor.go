@@ -0,0 +1,550 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/cobra"
+
+	"miser/internal/api"
+	"miser/internal/budget"
+	"miser/internal/config"
+	"miser/internal/format"
+	"miser/internal/notify"
+	"miser/internal/proxy"
+	"miser/internal/syslog"
+)
+
+const doctorTimeout = 10 * time.Second
+
+// doctorModel is used for the API key check; count_tokens is free.
+const doctorModel = "claude-haiku-4-5"
+
+var (
+	doctorAPIKey string
+	doctorOut    output
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check config, port, upstream connectivity and API key",
+	Long: `Runs a series of diagnostics and prints a suggested fix for anything
+that fails: config file and values, whether the proxy port is free, DNS and
+TLS for the upstream, upstream reachability, and (when a key is available)
+API key validity via a free count_tokens call.
+
+Please include the output when reporting an issue.`,
+	Args: cobra.NoArgs,
+	RunE: runDoctor,
+}
+
+func init() {
+	doctorCmd.Flags().StringVar(&doctorAPIKey, "api-key", "",
+		"Anthropic API key to verify [$ANTHROPIC_API_KEY]")
+	addOutputFlags(doctorCmd, &doctorOut)
+	rootCmd.AddCommand(doctorCmd)
+}
+
+type checkStatus int
+
+const (
+	checkOK checkStatus = iota
+	checkWarn
+	checkFail
+	checkSkip
+)
+
+func (s checkStatus) mark() string {
+	return [...]string{"✓", "!", "✗", "-"}[s]
+}
+
+func (s checkStatus) String() string {
+	return [...]string{"ok", "warn", "fail", "skip"}[s]
+}
+
+// doctorCheck is one line of --json / --tsv output.
+type doctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // ok, warn, fail or skip
+	Detail string `json:"detail"`
+	Fix    string `json:"fix,omitempty"`
+}
+
+type doctorOutput struct {
+	Version string        `json:"version"`
+	Commit  string        `json:"commit"`
+	Checks  []doctorCheck `json:"checks"`
+	Failed  int           `json:"failed"`
+}
+
+type checkResult struct {
+	status checkStatus
+	detail string
+	fix    string
+}
+
+func okResult(msg string, args ...any) checkResult {
+	return checkResult{status: checkOK, detail: fmt.Sprintf(msg, args...)}
+}
+
+func warnResult(detail, fix string) checkResult { return checkResult{checkWarn, detail, fix} }
+func failResult(detail, fix string) checkResult { return checkResult{checkFail, detail, fix} }
+func skipResult(detail string) checkResult      { return checkResult{status: checkSkip, detail: detail} }
+
+func runDoctor(cmd *cobra.Command, _ []string) (err error) {
+	out := doctorOutput{Version: Version, Commit: Commit, Checks: []doctorCheck{}}
+	if doctorOut.text() {
+		fmt.Printf("miser %s (%s)\n\n", Version, Commit)
+	} else {
+		defer func() {
+			var werr error
+			if doctorOut.json {
+				werr = writeJSON(os.Stdout, out)
+			} else {
+				rows := make([][]string, len(out.Checks))
+				for i, c := range out.Checks {
+					rows[i] = []string{c.Name, c.Status, c.Detail, c.Fix}
+				}
+				werr = writeTSV(os.Stdout, []string{"name", "status", "detail", "fix"}, rows)
+			}
+			if err == nil {
+				err = werr
+			}
+		}()
+	}
+
+	cfgFile := cfgPath
+	if cfgFile == "" {
+		cfgFile = os.Getenv("MISER_CONFIG")
+	}
+	if cfgFile == "" {
+		cfgFile = config.Discover()
+	}
+
+	failed := 0
+	report := func(name string, r checkResult) {
+		if r.status == checkFail {
+			failed++
+		}
+		out.Failed = failed
+		out.Checks = append(out.Checks, doctorCheck{name, r.status.String(), r.detail, r.fix})
+		if !doctorOut.text() {
+			return
+		}
+		fmt.Printf("%s %-12s %s\n", r.status.mark(), name, r.detail)
+		if r.fix != "" {
+			fmt.Printf("  %-12s → %s\n", "", r.fix)
+		}
+	}
+
+	cfg, err := resolveConfig(cmd)
+	if err != nil {
+		report("config", failResult(err.Error(), "fix the file, or regenerate one with `miser init --force`"))
+		return fmt.Errorf("config could not be loaded")
+	}
+	report("config", checkConfigFile(cfgFile))
+	if cfg.Profile != "" {
+		report("profile", okResult("%s", cfg.Profile))
+	}
+	for _, r := range checkConfigValues(cfg) {
+		report("config", r)
+	}
+	report("history", checkHistory(cfg))
+	if cfg.Syslog.Enabled {
+		report("syslog", checkSyslog(cfg))
+	}
+	report("port", checkPort(cfg.Proxy.Port))
+
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+
+	if cfg.Cluster.Aggregate && cfg.API.Token == "" {
+		report("cluster", failResult("aggregating, but without an [api] token no node can push",
+			"set [api] token and give it to nodes as [cluster] token"))
+	}
+	if cfg.API.Pprof && cfg.API.Token == "" {
+		report("pprof", failResult("profiles are on, but without an [api] token nothing can fetch them",
+			"set [api] token and send it as a bearer token"))
+	}
+	if cfg.Cluster.Aggregator != "" {
+		report("cluster", checkAggregator(ctx, cfg.Cluster))
+	}
+
+	u, err := url.Parse(cfg.Proxy.Target)
+	resolve, rerr := upstreamResolve(cfg)
+	switch {
+	case err != nil || u.Host == "":
+		report("target", failResult(fmt.Sprintf("invalid target URL %q", cfg.Proxy.Target),
+			"set [proxy] target to e.g. https://api.anthropic.com"))
+	case rerr != nil:
+		report("dns", failResult(rerr.Error(), "set [proxy] resolve to IP addresses, or resolver to a DNS server's host:port"))
+	default:
+		dns := checkDNS(ctx, resolve)
+		report("dns", dns)
+		if dns.status == checkFail {
+			return fmt.Errorf("%d check(s) failed; skipped upstream checks", failed)
+		}
+		client := http.DefaultClient
+		if resolve.Enabled() {
+			client = &http.Client{Transport: resolve.Transport()}
+		}
+		report("tls", checkTLS(ctx, u, resolve))
+		report("upstream", checkUpstream(ctx, client, cfg.Proxy.Target))
+		if ups, err := upstreams(cfg); err == nil && ups != nil {
+			for _, e := range ups.Endpoints()[1:] {
+				report("upstream", checkUpstream(ctx, client, e.URL))
+			}
+		}
+		key := doctorAPIKey
+		if key == "" {
+			key = os.Getenv("ANTHROPIC_API_KEY")
+		}
+		report("api key", checkAPIKey(ctx, client, cfg.Proxy.Target, key))
+	}
+
+	if failed > 0 {
+		if doctorOut.text() {
+			fmt.Println()
+		}
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	if doctorOut.text() {
+		fmt.Println("\nAll checks passed.")
+	}
+	return nil
+}
+
+func checkConfigFile(path string) checkResult {
+	if path == "" {
+		return okResult("no config file; using defaults (create one with `miser init`)")
+	}
+	var raw config.Config
+	md, err := toml.DecodeFile(path, &raw)
+	if err != nil {
+		return failResult(err.Error(), "fix the TOML syntax in "+path)
+	}
+	// Decode every profile too, so typos inside them are reported.
+	for name, p := range raw.Profiles {
+		if err := md.PrimitiveDecode(p, &config.Config{}); err != nil {
+			return failResult(fmt.Sprintf("profile %q: %v", name, err), "fix [profile."+name+"] in "+path)
+		}
+	}
+	if keys := md.Undecoded(); len(keys) > 0 {
+		names := make([]string, len(keys))
+		for i, k := range keys {
+			names[i] = k.String()
+		}
+		return warnResult(fmt.Sprintf("%s has unknown keys: %s", path, strings.Join(names, ", ")),
+			"check for typos; unknown keys are ignored")
+	}
+	return okResult("%s", path)
+}
+
+func checkConfigValues(cfg config.Config) []checkResult {
+	var out []checkResult
+	if p := cfg.Proxy.Port; p < 1 || p > 65535 {
+		out = append(out, failResult(fmt.Sprintf("port %d is out of range", p), "set [proxy] port between 1 and 65535"))
+	}
+	if _, err := time.ParseDuration(cfg.Proxy.Timeout); err != nil {
+		out = append(out, warnResult(fmt.Sprintf("timeout %q is not a duration; using 5m", cfg.Proxy.Timeout),
+			`set [proxy] timeout to e.g. "5m"`))
+	}
+	for _, t := range []struct{ key, value, def string }{
+		{"stall_timeout", cfg.Proxy.StallTimeout, "30s"},
+		{"read_header_timeout", cfg.Proxy.ReadHeaderTimeout, "10s"},
+		{"idle_timeout", cfg.Proxy.IdleTimeout, "2m"},
+	} {
+		if t.value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(t.value); err != nil || d < 0 {
+			out = append(out, warnResult(fmt.Sprintf("%s %q is not a duration; using %s", t.key, t.value, t.def),
+				fmt.Sprintf(`set [proxy] %s to e.g. %q, or "0" for no limit`, t.key, t.def)))
+		}
+	}
+	if cfg.Proxy.MaxConns < 0 || cfg.Proxy.MaxConnsPerIP < 0 {
+		out = append(out, warnResult("max_conns or max_conns_per_ip is negative; connections are not limited",
+			"set [proxy] max_conns and max_conns_per_ip to 0 (no limit) or a positive count"))
+	}
+	if _, err := format.ParseTokenUnit(cfg.Format.Tokens); err != nil {
+		out = append(out, failResult(err.Error(), `set [format] tokens to "abbrev" or "raw"`))
+	}
+	if _, _, err := displayCurrency(cfg); err != nil {
+		out = append(out, failResult(err.Error(), `set [format] currency to e.g. "EUR" with a rate or rate_url, or remove it for dollars`))
+	}
+	if cfg.Budget.Amount < 0 {
+		out = append(out, warnResult("budget amount is negative; the budget is disabled", "set [budget] amount to 0 or a positive dollar amount"))
+	}
+	if _, err := redactor(cfg); err != nil {
+		out = append(out, failResult(err.Error(), "fix [redact] builtin, or the regexes under [redact] patterns and [capture] redact (Go RE2 syntax)"))
+	} else if _, err := captureConfig(cfg); err != nil {
+		out = append(out, failResult(err.Error(), "set [capture] sample to a fraction such as 0.1, and min_cost to 0 or a dollar amount"))
+	}
+	if cfg.Capture.MaxBytes < 0 {
+		out = append(out, warnResult("capture max_bytes is negative; using 8 KB", "set [capture] max_bytes to 0 or a positive size"))
+	}
+	if _, err := budget.ParsePeriod(cfg.Budget.Period); err != nil {
+		out = append(out, failResult(err.Error(), `set [budget] period to "session", "day", "week", "month" or "rolling"`))
+	}
+	if _, err := budget.ParseAction(cfg.Budget.Action); err != nil {
+		out = append(out, failResult(err.Error(), `set [budget] action to "warn" or "block"`))
+	}
+	if _, err := retention(cfg); err != nil {
+		out = append(out, failResult(err.Error(), `set [history] max_age to e.g. "90d", or remove it`))
+	}
+	if _, err := time.ParseDuration(cfg.Mock.Latency); err != nil {
+		out = append(out, failResult(fmt.Sprintf("mock latency %q is not a duration", cfg.Mock.Latency),
+			`set [mock] latency to e.g. "500ms"`))
+	}
+	if _, err := notifyTargets(cfg); err != nil {
+		out = append(out, failResult(err.Error(), "fix the [notify] section; see the README for events and webhook settings"))
+	}
+	if cfg.Notify.Desktop.Enabled {
+		if _, err := exec.LookPath(notify.DesktopTool); err != nil {
+			out = append(out, warnResult(fmt.Sprintf("desktop notifications are enabled but %s is not installed", notify.DesktopTool),
+				"install it (libnotify on Linux), or set [notify.desktop] enabled = false"))
+		}
+	}
+	if _, err := errorRateConfig(cfg); err != nil {
+		out = append(out, failResult(err.Error(), `set [notify] error_window to e.g. "5m"`))
+	}
+	if _, err := shadowConfig(cfg); err != nil {
+		out = append(out, failResult(err.Error(), "fix the [shadow] section; see the README"))
+	}
+	if _, err := raceConfig(cfg); err != nil {
+		out = append(out, failResult(err.Error(), `set [race] models to globs such as "claude-opus-*"`))
+	}
+	if _, err := defaults(cfg); err != nil {
+		out = append(out, failResult(err.Error(), `fix the [defaults."<model>"] table; temperature and top_p run from 0 to 1`))
+	}
+	if _, err := sloObjectives(cfg); err != nil {
+		out = append(out, failResult(err.Error(), `fix the [slo."<model>"] table; latency is a duration such as "20s" and percentile runs up to 100`))
+	}
+	if _, err := injections(cfg); err != nil {
+		out = append(out, failResult(err.Error(), `fix the [[inject]] entry; position is "append" or "prepend", routes "messages" or "chat"`))
+	}
+	if _, err := aliases(cfg); err != nil {
+		out = append(out, failResult(err.Error(), `map each alias straight to an upstream model, e.g. fast = "claude-haiku-4-5"`))
+	}
+	if _, err := hooks(cfg); err != nil {
+		out = append(out, failResult(err.Error(), "fix the [[hook]] rule; see Hooks in the README for the expression language"))
+	}
+	if _, err := upstreams(cfg); err != nil {
+		out = append(out, failResult(err.Error(), `fix the [upstreams] section; policy is "fastest", "sticky" or "manual"`))
+	}
+	if _, err := costGate(cfg); err != nil {
+		out = append(out, failResult(err.Error(), `set [budget] max_request to 0 (off) or a dollar amount, and request_action to "warn" or "block"`))
+	}
+	if _, err := outputCap(cfg); err != nil {
+		out = append(out, failResult(err.Error(), `set [proxy] max_tokens to 0 (no cap) or a token count, and over_max_tokens to "clamp" or "reject"`))
+	}
+	if _, err := retryPolicy(cfg); err != nil {
+		out = append(out, failResult(err.Error(), `set [proxy] retries to 0 (off) or a count, retry_backoff to e.g. "1s", and overload_fallback to model globs and the models to fall back to`))
+	}
+	if _, err := tokenCheck(cfg); err != nil {
+		out = append(out, failResult(err.Error(), "set [tokens] tolerance to a fraction such as 0.25"))
+	}
+	if _, err := exportSinks(cfg); err != nil {
+		out = append(out, failResult(err.Error(), "set [[export.sink]] url to the collector's http(s) URL"))
+	}
+	for name, m := range cfg.Models {
+		if m.InputPerMTok == 0 && m.OutputPerMTok == 0 {
+			out = append(out, warnResult(fmt.Sprintf("model %q has no input or output price", name),
+				"add input_per_mtok and output_per_mtok, or remove the entry"))
+		}
+	}
+	if len(out) == 0 {
+		out = append(out, okResult("values look sane"))
+	}
+	return out
+}
+
+func checkSyslog(cfg config.Config) checkResult {
+	sc, err := syslogConfig(cfg)
+	if err != nil {
+		return failResult(err.Error(), "fix the [syslog] section, or set enabled = false")
+	}
+	w, err := syslog.Dial(sc)
+	if err != nil {
+		return failResult(err.Error(), "start a syslog daemon, or point [syslog] network and address at one")
+	}
+	w.Close()
+	if sc.Network == "" {
+		return okResult("local syslog daemon, facility %s", sc.Facility)
+	}
+	// UDP "connects" without a reply, so this only proves the address resolves.
+	return okResult("%s %s, facility %s", sc.Network, sc.Address, sc.Facility)
+}
+
+func checkHistory(cfg config.Config) checkResult {
+	if !cfg.History.Enabled {
+		return skipResult("persistent history is disabled")
+	}
+	path := historyPath(cfg)
+	fix := "set [history] path to a writable location"
+	if _, err := os.Stat(path); err == nil {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return failResult(err.Error(), fix)
+		}
+		f.Close()
+		return okResult("%s is writable", path)
+	}
+
+	// Not created yet: probe the nearest existing parent directory.
+	dir := filepath.Dir(path)
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	probe, err := os.CreateTemp(dir, ".miser-doctor-*")
+	if err != nil {
+		return failResult(err.Error(), fix)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return okResult("%s will be created on first request", path)
+}
+
+func checkPort(port int) checkResult {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err == nil {
+		ln.Close()
+		return okResult(":%d is free", port)
+	}
+	if instanceRunning(fmt.Sprintf("localhost:%d", port)) {
+		return okResult(":%d is served by a running miser (attach with `miser top`)", port)
+	}
+	return failResult(fmt.Sprintf(":%d is in use by another process", port),
+		"stop that process, or pick another port with --port / [proxy] port")
+}
+
+func checkAggregator(ctx context.Context, cc config.ClusterConfig) checkResult {
+	c := api.NewClient(cc.Aggregator)
+	c.Token = cc.Token
+	st, err := c.Status(ctx)
+	switch {
+	case err != nil:
+		return failResult(err.Error(), "check [cluster] aggregator, and that token matches its [api] token")
+	case !st.Aggregating:
+		return failResult(fmt.Sprintf("miser at %s does not aggregate", c.Base()), "set [cluster] aggregate = true on it")
+	}
+	return okResult("aggregator at %s (miser %s)", c.Base(), st.Version)
+}
+
+func checkDNS(ctx context.Context, r proxy.Resolve) checkResult {
+	if net.ParseIP(r.Host) != nil {
+		return skipResult(r.Host + " is an IP address")
+	}
+	if len(r.Addrs) > 0 {
+		return okResult("%s → %s (pinned by [proxy] resolve)", r.Host, strings.Join(r.Addrs, ", "))
+	}
+	addrs, err := r.Lookup(ctx)
+	if err != nil {
+		fix := "check your network or DNS settings (and any VPN)"
+		if r.Server != "" {
+			fix = "check that [proxy] resolver " + r.Server + " is reachable and answers for " + r.Host
+		}
+		return failResult(err.Error(), fix)
+	}
+	if r.Server != "" {
+		return okResult("%s → %s (via %s)", r.Host, strings.Join(addrs, ", "), r.Server)
+	}
+	return okResult("%s → %s", r.Host, strings.Join(addrs, ", "))
+}
+
+func checkTLS(ctx context.Context, u *url.URL, r proxy.Resolve) checkResult {
+	if u.Scheme != "https" {
+		return skipResult(u.Scheme + " target, no TLS")
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+	conn, err := r.DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err == nil {
+		tc := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err = tc.HandshakeContext(ctx); err != nil {
+			tc.Close()
+		}
+		conn = tc
+	}
+	if err != nil {
+		var certErr *tls.CertificateVerificationError
+		if errors.As(err, &certErr) {
+			return failResult(err.Error(), "a proxy or firewall may be intercepting TLS; install its CA or set HTTPS_PROXY correctly")
+		}
+		return failResult(err.Error(), "check that outbound HTTPS is allowed")
+	}
+	defer conn.Close()
+	state := conn.(*tls.Conn).ConnectionState()
+	cert := state.PeerCertificates[0]
+	days := int(time.Until(cert.NotAfter).Hours() / 24)
+	return okResult("%s, certificate by %q valid for %d more days", tls.VersionName(state.Version), cert.Issuer.CommonName, days)
+}
+
+func checkUpstream(ctx context.Context, client *http.Client, target string) checkResult {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(target, "/")+"/v1/models", nil)
+	if err != nil {
+		return failResult(err.Error(), "")
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return failResult(err.Error(), "check the [proxy] target URL and your network")
+	}
+	resp.Body.Close()
+	return okResult("%s answered %s in %s", target, resp.Status, time.Since(start).Round(time.Millisecond))
+}
+
+func checkAPIKey(ctx context.Context, client *http.Client, target, key string) checkResult {
+	if key == "" {
+		return skipResult("no key to test; set ANTHROPIC_API_KEY or pass --api-key")
+	}
+	body := fmt.Sprintf(`{"model":%q,"messages":[{"role":"user","content":"ping"}]}`, doctorModel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimRight(target, "/")+"/v1/messages/count_tokens", bytes.NewBufferString(body))
+	if err != nil {
+		return failResult(err.Error(), "")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", key)
+	req.Header.Set("anthropic-version", "2023-06-01")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return failResult(err.Error(), "check your network")
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return okResult("key accepted (count_tokens on %s)", doctorModel)
+	case resp.StatusCode == http.StatusUnauthorized:
+		return failResult("key rejected (401)", "create a new key in the Anthropic console and update your tool")
+	case resp.StatusCode == http.StatusForbidden:
+		return failResult("key lacks permission (403)", "check the key's workspace and permissions")
+	default:
+		return warnResult(fmt.Sprintf("unexpected %s: %s", resp.Status, strings.TrimSpace(string(msg))), "")
+	}
+}
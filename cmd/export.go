@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"miser/internal/export"
+	"miser/internal/tracker"
+)
+
+var (
+	exportSince      string
+	exportUntil      string
+	exportModels     []string
+	exportErrorsOnly bool
+	exportFormat     string
+	exportOutput     string
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export requests from the history without the TUI",
+	Long: `Writes requests from the persisted history as CSV, JSON, JSONL, full
+NDJSON, a Markdown table or a SQLite database, optionally filtered by
+time and model. Output goes to stdout unless --output is given; the format follows
+the output file's extension unless --format is set.`,
+	Example: `  miser export --since 24h --format csv > today.csv
+  miser export --since 7d --model claude-opus-4 -o opus.json
+  miser export --errors --format jsonl | jq .error
+  miser export --since 1h --format ndjson | jq .usage.output_tokens
+  miser export -o history.sqlite && sqlite3 history.sqlite 'SELECT * FROM days'`,
+	Args: cobra.NoArgs,
+	RunE: runExport,
+}
+
+func init() {
+	f := exportCmd.Flags()
+	f.StringVar(&exportSince, "since", "",
+		"only include requests after this (24h, 7d, 2026-01-31, RFC 3339)")
+	f.StringVar(&exportUntil, "until", "",
+		"only include requests before this (same forms as --since)")
+	f.StringSliceVarP(&exportModels, "model", "m", nil,
+		"only include models starting with this name (repeatable)")
+	f.BoolVar(&exportErrorsOnly, "errors", false, "only include failed requests")
+	f.StringVarP(&exportFormat, "format", "f", "",
+		"csv, json, jsonl, ndjson, md or sqlite (default: from --output extension, else csv)")
+	f.StringVarP(&exportOutput, "output", "o", "", "write to this file instead of stdout")
+	rootCmd.AddCommand(exportCmd)
+}
+
+func runExport(cmd *cobra.Command, _ []string) error {
+	cfg, err := resolveConfig(cmd)
+	if err != nil {
+		return err
+	}
+	if err := applyFormat(cfg); err != nil {
+		return err
+	}
+
+	name := exportFormat
+	if name == "" {
+		name = filepath.Ext(exportOutput)
+	}
+	f := export.CSV
+	if name != "" {
+		if f, err = export.ParseFormat(name); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	since, err := parseSince(exportSince, now)
+	if err != nil {
+		return err
+	}
+	until := now
+	if exportUntil != "" {
+		if until, err = parseSince(exportUntil, now); err != nil {
+			return err
+		}
+	}
+
+	if exportOutput == "" {
+		if err := checkBinaryStdout(f); err != nil {
+			return err
+		}
+	}
+
+	t, err := loadHistory(cfg)
+	if err != nil {
+		return err
+	}
+	reqs := filterExport(t.GetRequestsSince(since), until, exportModels, exportErrorsOnly)
+
+	var w io.Writer = os.Stdout
+	if exportOutput != "" {
+		file, err := os.Create(exportOutput)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := export.Write(w, f, reqs); err != nil {
+		return err
+	}
+	if exportOutput != "" {
+		fmt.Fprintf(os.Stderr, "exported %d requests → %s\n", len(reqs), exportOutput)
+	}
+	return nil
+}
+
+// checkBinaryStdout refuses to write a SQLite file to a terminal.
+func checkBinaryStdout(f export.Format) error {
+	if f != export.SQLite {
+		return nil
+	}
+	if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		return fmt.Errorf("%s output is binary; write it to a file with --output", f)
+	}
+	return nil
+}
+
+// filterExport keeps requests before until that match one of the model
+// prefixes (any model when none are given) and, if errorsOnly, failed.
+func filterExport(reqs []tracker.Request, until time.Time, models []string, errorsOnly bool) []tracker.Request {
+	out := reqs[:0]
+	for _, r := range reqs {
+		if !r.Timestamp.Before(until) || errorsOnly && !r.Failed() {
+			continue
+		}
+		if len(models) > 0 && !matchesModel(r.Model, models) {
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func matchesModel(model string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(model, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"miser/internal/tracker"
+)
+
+func TestFilterExport(t *testing.T) {
+	now := time.Now()
+	reqs := []tracker.Request{
+		{ID: 1, Timestamp: now.Add(-3 * time.Hour), Model: "claude-opus-4-20250514"},
+		{ID: 2, Timestamp: now.Add(-2 * time.Hour), Model: "claude-sonnet-4-6", StatusCode: 529},
+		{ID: 3, Timestamp: now.Add(-1 * time.Hour), Model: "claude-opus-4-6", Error: "timeout"},
+		{ID: 4, Timestamp: now, Model: "claude-opus-4-6"},
+	}
+	ids := func(rs []tracker.Request) []int {
+		var out []int
+		for _, r := range rs {
+			out = append(out, r.ID)
+		}
+		return out
+	}
+
+	got := ids(filterExport(append([]tracker.Request(nil), reqs...), now, []string{"claude-opus-4"}, false))
+	if len(got) != 2 || got[0] != 1 || got[1] != 3 {
+		t.Errorf("model filter = %v, want [1 3]", got)
+	}
+	got = ids(filterExport(append([]tracker.Request(nil), reqs...), now.Add(time.Second), nil, true))
+	if len(got) != 2 || got[0] != 2 || got[1] != 3 {
+		t.Errorf("errors filter = %v, want [2 3]", got)
+	}
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"miser/internal/config"
+	"miser/internal/store"
+	"miser/internal/tracker"
+)
+
+// historyPath returns the configured history file, or the default location.
+func historyPath(cfg config.Config) string {
+	if cfg.History.Path != "" {
+		return cfg.History.Path
+	}
+	return profilePath(cfg, store.DefaultPath())
+}
+
+// shadowPath is where shadow requests are kept: next to the history.
+func shadowPath(cfg config.Config) string {
+	return profilePath(cfg, filepath.Join(filepath.Dir(historyPath(cfg)), "shadow.jsonl"))
+}
+
+// loadHistory reads the persisted history into a fresh tracker for the
+// offline subcommands (stats, report, export, …). Nothing is written back.
+func loadHistory(cfg config.Config) (*tracker.Tracker, error) {
+	path := historyPath(cfg)
+	history, err := store.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 && !cfg.History.Enabled {
+		return nil, fmt.Errorf("history is disabled in config and %s is empty", path)
+	}
+	t := tracker.New()
+	t.Load(history)
+	return t, nil
+}
+
+// parseAge parses a retention age: a Go duration ("720h") or days or weeks
+// ("90d", "12w"). Empty means no limit.
+func parseAge(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return d, nil
+	}
+	if n, unit := s[:len(s)-1], s[len(s)-1]; unit == 'd' || unit == 'w' {
+		if v, err := strconv.Atoi(n); err == nil && v > 0 {
+			if unit == 'w' {
+				v *= 7
+			}
+			return time.Duration(v) * 24 * time.Hour, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid age %q (want e.g. 90d, 12w, 720h)", s)
+}
+
+// retention reads the [history] retention policy.
+func retention(cfg config.Config) (store.Retention, error) {
+	age, err := parseAge(cfg.History.MaxAge)
+	if err != nil {
+		return store.Retention{}, fmt.Errorf("history: max_age: %w", err)
+	}
+	return store.Retention{MaxAge: age, MaxRows: max(cfg.History.MaxRows, 0)}, nil
+}
+
+// parseSince turns a --since value into a cut-off time. It accepts Go
+// durations ("90m", "24h"), days or weeks ("7d", "2w"), a date
+// ("2026-01-31", local midnight) or an RFC 3339 timestamp. Empty means
+// no cut-off.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if n, unit := strings.TrimRight(s, "dw"), s[len(strings.TrimRight(s, "dw")):]; len(unit) == 1 {
+		if v, err := strconv.Atoi(n); err == nil {
+			days := v
+			if unit == "w" {
+				days *= 7
+			}
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, now.Location()); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q (want e.g. 24h, 7d, 2026-01-31)", s)
+}
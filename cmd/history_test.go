@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	cases := map[string]time.Time{
+		"":                     {},
+		"90m":                  now.Add(-90 * time.Minute),
+		"7d":                   now.AddDate(0, 0, -7),
+		"2w":                   now.AddDate(0, 0, -14),
+		"2026-10-01":           time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+		"2026-10-01T08:00:00Z": time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC),
+	}
+	for in, want := range cases {
+		got, err := parseSince(in, now)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("parseSince(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"yesterday", "7x", "d"} {
+		if _, err := parseSince(bad, now); err == nil {
+			t.Errorf("parseSince(%q) should fail", bad)
+		}
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":     0,
+		"720h": 720 * time.Hour,
+		"90d":  90 * 24 * time.Hour,
+		"2w":   14 * 24 * time.Hour,
+	}
+	for in, want := range cases {
+		if got, err := parseAge(in); err != nil || got != want {
+			t.Errorf("parseAge(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"d", "0d", "-1h", "2026-01-01", "ninety"} {
+		if _, err := parseAge(bad); err == nil {
+			t.Errorf("parseAge(%q) should fail", bad)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package cmd
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"miser/internal/audit"
+	"miser/internal/budget"
+	"miser/internal/config"
+	"miser/internal/format"
+	"miser/internal/keys"
+	"miser/internal/store"
+	"miser/internal/tracker"
+)
+
+var (
+	keysUpstream string
+	keysSince    string
+	keysOut      output
+
+	keysBudget float64
+	keysPeriod string
+	keysRPM    int
+)
+
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Issue, list and revoke virtual API keys",
+	Long: `Virtual keys are secrets you hand to people and tools in place of the real
+Anthropic key. The proxy swaps each for the real key ([proxy] api_key, or
+a named [keys.upstream] entry), records which key every request used,
+holds each key to its own budget and request rate, and refuses a key once
+it is revoked. Changes reach a running proxy on its next request.`,
+	Example: `  miser keys create alice --budget 20 --rpm 30
+  miser keys create ci --upstream batch
+  miser keys limit alice --budget 50 --period week
+  miser keys list --since 30d
+  miser keys revoke alice`,
+}
+
+var keysCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Issue a key and print its secret",
+	Long: `Issues a virtual key called <name> and prints its secret. Only a hash is
+kept, so the secret cannot be shown again; revoke the key and create
+another if it is lost.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runKeysCreate,
+}
+
+var keysListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List keys with their requests and cost",
+	Long: `Lists every virtual key, revoked ones included, with the requests, cost
+and last use recorded in the history under its name.`,
+	Args: cobra.NoArgs,
+	RunE: runKeysList,
+}
+
+var keysLimitCmd = &cobra.Command{
+	Use:   "limit <name>",
+	Short: "Change a key's budget or request rate",
+	Long: `Changes the limits of the virtual key called <name>; flags that are not
+given keep their current value, and 0 removes a limit. A key over its
+budget is refused with 402 until the period rolls over, and one over its
+rate with 429 and a Retry-After header.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runKeysLimit,
+}
+
+var keysRevokeCmd = &cobra.Command{
+	Use:   "revoke <name>",
+	Short: "Stop accepting a key",
+	Long: `Revokes the virtual key called <name>. Requests that present it are refused
+with 401; those it already made keep reporting under its name.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runKeysRevoke,
+}
+
+func init() {
+	keysCreateCmd.Flags().StringVar(&keysUpstream, "upstream", "",
+		"map the key to this [keys.upstream] entry instead of [proxy] api_key")
+	for _, c := range []*cobra.Command{keysCreateCmd, keysLimitCmd} {
+		c.Flags().Float64Var(&keysBudget, "budget", 0, "dollars the key may spend per --period; 0 for no limit")
+		c.Flags().StringVar(&keysPeriod, "period", "month", "budget period: day, week or month")
+		c.Flags().IntVar(&keysRPM, "rpm", 0, "requests per minute the key may send; 0 for no limit")
+	}
+	keysListCmd.Flags().StringVar(&keysSince, "since", "",
+		"only count requests after this (24h, 7d, 2026-01-31, RFC 3339)")
+	addOutputFlags(keysListCmd, &keysOut)
+	keysCmd.AddCommand(keysCreateCmd, keysListCmd, keysLimitCmd, keysRevokeCmd)
+	rootCmd.AddCommand(keysCmd)
+}
+
+// keysPath returns where virtual keys are kept.
+func keysPath(cfg config.Config) string {
+	if cfg.Keys.Path != "" {
+		return cfg.Keys.Path
+	}
+	return profilePath(cfg, keys.DefaultPath())
+}
+
+func runKeysCreate(cmd *cobra.Command, args []string) error {
+	cfg, err := resolveConfig(cmd)
+	if err != nil {
+		return err
+	}
+	switch {
+	case keysUpstream != "" && cfg.Keys.Upstream[keysUpstream] == "":
+		return fmt.Errorf("--upstream %s: no such entry in [keys.upstream]", keysUpstream)
+	case keysUpstream == "" && cfg.Proxy.APIKey == "":
+		return fmt.Errorf("virtual keys need a real key to map to: set [proxy] api_key, or use --upstream with a [keys.upstream] entry")
+	}
+	f, err := keys.Open(keysPath(cfg))
+	if err != nil {
+		return err
+	}
+	secret, k, err := f.Create(args[0], keysUpstream, keysLimits(keys.Limits{}, cmd))
+	recordKeysAudit(cfg, audit.KeyCreate, args[0], err)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created key %s (%s). Its secret is shown only once:\n\n  %s\n\n", k.Name, limitsLabel(k.Limits), secret)
+	fmt.Printf("Use it in place of the real key:\n\n")
+	fmt.Printf("  export ANTHROPIC_BASE_URL=http://localhost:%d\n", cfg.Proxy.Port)
+	fmt.Printf("  export ANTHROPIC_API_KEY=%s\n", secret)
+	return nil
+}
+
+func runKeysLimit(cmd *cobra.Command, args []string) error {
+	cfg, err := resolveConfig(cmd)
+	if err != nil {
+		return err
+	}
+	f, err := keys.Open(keysPath(cfg))
+	if err != nil {
+		return err
+	}
+	all, err := f.List()
+	if err != nil {
+		return err
+	}
+	var limits keys.Limits
+	for _, k := range all {
+		if k.Name == args[0] {
+			limits = k.Limits
+		}
+	}
+	limits = keysLimits(limits, cmd)
+	k, err := f.SetLimits(args[0], limits)
+	recordKeysAudit(cfg, audit.KeyLimits, args[0]+": "+limitsLabel(limits), err)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Key %s: %s\n", k.Name, limitsLabel(k.Limits))
+	return nil
+}
+
+// keysLimits applies the limit flags given on the command line to l.
+func keysLimits(l keys.Limits, cmd *cobra.Command) keys.Limits {
+	fl := cmd.Flags()
+	if fl.Changed("budget") {
+		l.Budget = keysBudget
+	}
+	if fl.Changed("period") {
+		l.Period = keysPeriod
+	}
+	if fl.Changed("rpm") {
+		l.RPM = keysRPM
+	}
+	return l
+}
+
+// limitsLabel describes a key's limits, e.g. "$20.00 a month, 30 rpm".
+func limitsLabel(l keys.Limits) string {
+	var parts []string
+	if l.Budget > 0 {
+		parts = append(parts, format.Cost(l.Budget)+" a "+cmp.Or(l.Period, "month"))
+	}
+	if l.RPM > 0 {
+		parts = append(parts, strconv.Itoa(l.RPM)+" rpm")
+	}
+	if len(parts) == 0 {
+		return "no limits"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func runKeysRevoke(cmd *cobra.Command, args []string) error {
+	cfg, err := resolveConfig(cmd)
+	if err != nil {
+		return err
+	}
+	f, err := keys.Open(keysPath(cfg))
+	if err != nil {
+		return err
+	}
+	_, err = f.Revoke(args[0])
+	recordKeysAudit(cfg, audit.KeyRevoke, args[0], err)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Revoked key %s\n", args[0])
+	return nil
+}
+
+// recordKeysAudit notes a key change in the audit log, if it is enabled.
+// The user is as claimed by this machine, as for miser ctl.
+func recordKeysAudit(cfg config.Config, action, name string, err error) {
+	if !cfg.Audit.Enabled {
+		return
+	}
+	l, oerr := audit.Open(auditPath(cfg))
+	if oerr != nil {
+		logWarning("audit log: %v", oerr)
+		return
+	}
+	defer l.Close()
+	source := "cli"
+	if u, uerr := user.Current(); uerr == nil {
+		source += " " + u.Username
+	}
+	e := audit.Entry{Action: action, Source: source, Detail: name}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if rerr := l.Record(e); rerr != nil {
+		logWarning("audit log: %v", rerr)
+	}
+}
+
+// keyOutput is one key in the --json / --tsv form of "miser keys list".
+type keyOutput struct {
+	Name     string     `json:"name"`
+	Key      string     `json:"key"` // masked
+	Upstream string     `json:"upstream,omitempty"`
+	Created  time.Time  `json:"created"`
+	Revoked  *time.Time `json:"revoked,omitempty"`
+	Requests int        `json:"requests"`
+	Cost     float64    `json:"cost"`
+	LastUsed *time.Time `json:"last_used,omitempty"`
+
+	Budget      float64 `json:"budget,omitempty"`
+	Period      string  `json:"period,omitempty"`       // with budget
+	BudgetSpent float64 `json:"budget_spent,omitempty"` // this period, with budget
+	RPM         int     `json:"rpm,omitempty"`
+}
+
+func runKeysList(cmd *cobra.Command, _ []string) error {
+	cfg, err := resolveConfig(cmd)
+	if err != nil {
+		return err
+	}
+	since, err := parseSince(keysSince, time.Now())
+	if err != nil {
+		return fmt.Errorf("--since: %w", err)
+	}
+	f, err := keys.Open(keysPath(cfg))
+	if err != nil {
+		return err
+	}
+	all, err := f.List()
+	if err != nil {
+		return err
+	}
+	history, err := store.Load(historyPath(cfg))
+	if err != nil {
+		return err
+	}
+	t := tracker.New()
+	t.Load(history)
+
+	byName := make(map[string]*keyOutput, len(all))
+	out := make([]keyOutput, len(all))
+	for i, k := range all {
+		out[i] = keyOutput{Name: k.Name, Key: k.Masked(), Upstream: k.Upstream, Created: k.Created, RPM: k.RPM}
+		if !k.Active() {
+			out[i].Revoked = &k.Revoked
+		}
+		if k.Budget > 0 {
+			out[i].Budget, out[i].Period = k.Budget, cmp.Or(k.Period, "month")
+			period, err := budget.ParsePeriod(out[i].Period)
+			if err != nil {
+				return err
+			}
+			name := k.Name
+			b := budget.New(budget.Config{Limit: k.Budget, Period: period,
+				Match: func(r tracker.Request) bool { return r.Key == name }}, t)
+			out[i].BudgetSpent = b.Status().Spent
+		}
+		byName[k.Name] = &out[i]
+	}
+	for _, r := range history {
+		o := byName[r.Key]
+		if o == nil || r.Timestamp.Before(since) {
+			continue
+		}
+		o.Requests++
+		o.Cost += r.Cost
+		if o.LastUsed == nil || r.Timestamp.After(*o.LastUsed) {
+			ts := r.Timestamp
+			o.LastUsed = &ts
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Cost > out[j].Cost })
+
+	switch {
+	case keysOut.json:
+		return writeJSON(os.Stdout, out)
+	case keysOut.tsv:
+		rows := make([][]string, len(out))
+		for i, o := range out {
+			rows[i] = []string{o.Name, o.Key, o.Upstream, o.Created.Format(time.RFC3339),
+				optTime(o.Revoked), strconv.Itoa(o.Requests), tsvFloat(o.Cost), optTime(o.LastUsed),
+				tsvFloat(o.Budget), o.Period, tsvFloat(o.BudgetSpent), strconv.Itoa(o.RPM)}
+		}
+		return writeTSV(os.Stdout, []string{"name", "key", "upstream", "created", "revoked", "requests", "cost", "last_used",
+			"budget", "period", "budget_spent", "rpm"}, rows)
+	}
+
+	if len(out) == 0 {
+		fmt.Printf("No virtual keys in %s. Issue one with \"miser keys create <name>\".\n", f.Path())
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tKEY\tUPSTREAM\tCREATED\tREQUESTS\tCOST\tLAST USED\tLIMITS")
+	for _, o := range out {
+		name, last, limits := o.Name, "-", "-"
+		if o.Revoked != nil {
+			name += " (revoked)"
+		}
+		if o.LastUsed != nil {
+			last = o.LastUsed.Local().Format("2006-01-02 15:04")
+		}
+		if l := (keys.Limits{Budget: o.Budget, Period: o.Period, RPM: o.RPM}); l != (keys.Limits{}) {
+			limits = limitsLabel(l)
+		}
+		if o.Budget > 0 {
+			limits = format.Cost(o.BudgetSpent) + " of " + limits
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", name, o.Key, cmp.Or(o.Upstream, "-"),
+			o.Created.Local().Format("2006-01-02"), format.Int(o.Requests), format.Cost(o.Cost), last, limits)
+	}
+	return tw.Flush()
+}
+
+// optTime formats t for TSV, or "" if it is unset.
+func optTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
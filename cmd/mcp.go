@@ -0,0 +1,303 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"miser/internal/api"
+	"miser/internal/budget"
+	"miser/internal/config"
+	"miser/internal/mcp"
+	"miser/internal/tracker"
+)
+
+var mcpAddr string
+
+var mcpCmd = &cobra.Command{
+	Use:   "mcp",
+	Short: "Serve usage data to MCP clients over stdio",
+	Long: `Runs a Model Context Protocol server on stdin/stdout so an assistant
+can answer "how much have I spent today, per model?" itself. Tools read
+the running proxy's live data when one answers on the configured port, and
+the history file otherwise.
+
+Register it with the client rather than running it by hand.`,
+	Example: `  claude mcp add miser -- miser mcp
+  claude mcp add miser-work -- miser mcp --profile work`,
+	Args: cobra.NoArgs,
+	RunE: runMCP,
+}
+
+func init() {
+	mcpCmd.Flags().StringVar(&mcpAddr, "addr", "",
+		"address of the running proxy (default: localhost:<configured port>)")
+	rootCmd.AddCommand(mcpCmd)
+}
+
+func runMCP(cmd *cobra.Command, _ []string) error {
+	cfg, err := resolveConfig(cmd)
+	if err != nil {
+		return err
+	}
+	applyPricing(cfg)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	addr := mcpAddr
+	if addr == "" {
+		addr = fmt.Sprintf("localhost:%d", cfg.Proxy.Port)
+	}
+	src := &usageSource{ctx: ctx, cfg: cfg, client: api.NewClient(addr)}
+	src.client.Token = cfg.API.Token
+	srv := &mcp.Server{Name: "miser", Version: Version, Tools: usageTools(src)}
+	return srv.Serve(ctx, os.Stdin, os.Stdout)
+}
+
+// usageSource finds the data for a tool call: a live mirror of the
+// running proxy once one answers, else a fresh read of the history file.
+type usageSource struct {
+	ctx    context.Context
+	cfg    config.Config
+	client *api.Client
+
+	mu     sync.Mutex
+	mirror *tracker.Tracker
+}
+
+func (u *usageSource) tracker() (t *tracker.Tracker, live bool, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.mirror != nil {
+		return u.mirror, true, nil
+	}
+	if instanceRunning(u.client.Base()) {
+		t := tracker.New()
+		if _, err := u.client.Mirror(u.ctx, t, nil); err == nil {
+			u.mirror = t
+			return t, true, nil
+		}
+	}
+	t, err = loadHistory(u.cfg)
+	return t, false, err
+}
+
+// budget reports the budget as the proxy sees it, or as the config
+// defines it when offline.
+func (u *usageSource) budget(t *tracker.Tracker, live bool) *api.Budget {
+	if live {
+		ctx, cancel := context.WithTimeout(u.ctx, probeTimeout)
+		defer cancel()
+		if s, err := u.client.Summary(ctx, ""); err == nil {
+			return s.Budget
+		}
+		return nil
+	}
+	bcfg, err := budgetConfig(u.cfg, nil)
+	// A session budget means nothing without a running session.
+	if err != nil || bcfg.Limit <= 0 || bcfg.Period == budget.Session {
+		return nil
+	}
+	st := budget.New(bcfg, t).Status()
+	return &api.Budget{Limit: st.Limit, Spent: st.Spent, Since: st.Since, Until: st.Until}
+}
+
+func (u *usageSource) sourceName(live bool) string {
+	if live {
+		return "live proxy at " + u.client.Base()
+	}
+	return "history file " + historyPath(u.cfg)
+}
+
+// mcpSince resolves a tool's "since" argument: "today" (the default),
+// "session", "all", or anything --since accepts.
+func mcpSince(s string, t *tracker.Tracker, now time.Time) (time.Time, error) {
+	switch s {
+	case "", "today":
+		return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()), nil
+	case "session":
+		return t.SessionStart(), nil
+	case "all":
+		return time.Time{}, nil
+	}
+	return parseSince(s, now)
+}
+
+var sinceSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"since": map[string]any{
+			"type": "string",
+			"description": `Start of the period: "today" (default), "session", "all", a duration ` +
+				`such as "24h" or "7d", a date such as "2026-01-31", or an RFC 3339 time.`,
+		},
+	},
+}
+
+type mcpSpend struct {
+	Source string    `json:"source"`
+	Since  time.Time `json:"since,omitzero"`
+	statsTotals
+	Budget *api.Budget `json:"budget,omitempty"`
+}
+
+type mcpModels struct {
+	Source string       `json:"source"`
+	Since  time.Time    `json:"since,omitzero"`
+	Models []statsModel `json:"models"` // most expensive first
+}
+
+type mcpDays struct {
+	Source string     `json:"source"`
+	Days   []statsDay `json:"days"`
+}
+
+type mcpRequest struct {
+	Time         time.Time `json:"time"`
+	Model        string    `json:"model"`
+	Status       int       `json:"status"`
+	Error        string    `json:"error,omitempty"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	CacheRead    int       `json:"cache_read"`
+	CacheWrite   int       `json:"cache_write"`
+	Cost         float64   `json:"cost"`
+	LatencyMs    int64     `json:"latency_ms"`
+}
+
+type mcpRequests struct {
+	Source   string       `json:"source"`
+	Requests []mcpRequest `json:"requests"` // newest first
+}
+
+func usageTools(u *usageSource) []mcp.Tool {
+	return []mcp.Tool{
+		{
+			Name:        "get_spend",
+			Description: "Total spend, requests, errors and tokens for a period, plus the budget if one is set.",
+			InputSchema: sinceSchema,
+			Call: func(_ context.Context, args json.RawMessage) (any, error) {
+				var in struct{ Since string }
+				if err := mcp.DecodeArgs(args, &in); err != nil {
+					return nil, err
+				}
+				t, live, err := u.tracker()
+				if err != nil {
+					return nil, err
+				}
+				since, err := mcpSince(in.Since, t, time.Now())
+				if err != nil {
+					return nil, err
+				}
+				return mcpSpend{
+					Source:      u.sourceName(live),
+					Since:       since,
+					statsTotals: totalsOf(t.GetSummarySince(since)),
+					Budget:      u.budget(t, live),
+				}, nil
+			},
+		},
+		{
+			Name:        "get_spend_by_model",
+			Description: "Spend, requests and tokens per model for a period, most expensive first.",
+			InputSchema: sinceSchema,
+			Call: func(_ context.Context, args json.RawMessage) (any, error) {
+				var in struct{ Since string }
+				if err := mcp.DecodeArgs(args, &in); err != nil {
+					return nil, err
+				}
+				t, live, err := u.tracker()
+				if err != nil {
+					return nil, err
+				}
+				since, err := mcpSince(in.Since, t, time.Now())
+				if err != nil {
+					return nil, err
+				}
+				out := mcpModels{Source: u.sourceName(live), Since: since, Models: []statsModel{}}
+				for _, m := range t.GetModelStatsSince(since) {
+					out.Models = append(out.Models, statsModel{Model: m.Model, statsTotals: statsTotals{
+						Requests:   m.Requests,
+						Errors:     m.Errors,
+						Input:      m.InputTokens,
+						Output:     m.OutputTokens,
+						CacheRead:  m.CacheRead,
+						CacheWrite: m.CacheWrite,
+						Cost:       m.TotalCost,
+					}})
+				}
+				return out, nil
+			},
+		},
+		{
+			Name:        "get_spend_by_day",
+			Description: "Spend per local calendar day, oldest first.",
+			InputSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"days": map[string]any{"type": "integer", "minimum": 1, "description": "How many days back, including today (default 7)."},
+				},
+			},
+			Call: func(_ context.Context, args json.RawMessage) (any, error) {
+				in := struct{ Days int }{Days: 7}
+				if err := mcp.DecodeArgs(args, &in); err != nil {
+					return nil, err
+				}
+				t, live, err := u.tracker()
+				if err != nil {
+					return nil, err
+				}
+				now := time.Now()
+				start := time.Date(now.Year(), now.Month(), now.Day()-max(in.Days, 1)+1, 0, 0, 0, 0, now.Location())
+				out := mcpDays{Source: u.sourceName(live), Days: []statsDay{}}
+				for _, d := range t.GetDailySince(start) {
+					out.Days = append(out.Days, statsDay{Date: d.Date.Format("2006-01-02"), statsTotals: totalsOf(d.Summary)})
+				}
+				return out, nil
+			},
+		},
+		{
+			Name:        "get_recent_requests",
+			Description: "The most recent requests with model, status, tokens, cost and latency, newest first.",
+			InputSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"limit": map[string]any{"type": "integer", "minimum": 1, "maximum": 100, "description": "How many (default 10)."},
+				},
+			},
+			Call: func(_ context.Context, args json.RawMessage) (any, error) {
+				in := struct{ Limit int }{Limit: 10}
+				if err := mcp.DecodeArgs(args, &in); err != nil {
+					return nil, err
+				}
+				t, live, err := u.tracker()
+				if err != nil {
+					return nil, err
+				}
+				out := mcpRequests{Source: u.sourceName(live), Requests: []mcpRequest{}}
+				for _, r := range t.GetRecentRequests(min(max(in.Limit, 1), 100)) {
+					out.Requests = append(out.Requests, mcpRequest{
+						Time:         r.Timestamp,
+						Model:        r.Model,
+						Status:       r.StatusCode,
+						Error:        r.Error,
+						InputTokens:  r.InputTokens,
+						OutputTokens: r.OutputTokens,
+						CacheRead:    r.CacheRead,
+						CacheWrite:   r.CacheWrite,
+						Cost:         r.Cost,
+						LatencyMs:    r.Latency.Milliseconds(),
+					})
+				}
+				return out, nil
+			},
+		},
+	}
+}
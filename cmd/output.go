@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// output is the --json / --tsv choice shared by the reporting commands.
+// Their schemas are documented in the README; fields and columns are only
+// ever added, at the end for TSV.
+type output struct {
+	json, tsv bool
+}
+
+func addOutputFlags(cmd *cobra.Command, o *output) {
+	cmd.Flags().BoolVar(&o.json, "json", false, "print JSON (stable schema; see README)")
+	cmd.Flags().BoolVar(&o.tsv, "tsv", false, "print tab-separated values with a header row (stable columns; see README)")
+	cmd.MarkFlagsMutuallyExclusive("json", "tsv")
+}
+
+func (o output) text() bool {
+	return !o.json && !o.tsv
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// writeTSV writes a header and rows, replacing tabs and newlines inside
+// fields with spaces so every record stays on one line.
+func writeTSV(w io.Writer, header []string, rows [][]string) error {
+	clean := strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+	for _, r := range append([][]string{header}, rows...) {
+		fields := make([]string, len(r))
+		for i, f := range r {
+			fields[i] = clean.Replace(f)
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(fields, "\t")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tsvFloat formats f without rounding or grouping, unlike format.Cost.
+func tsvFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
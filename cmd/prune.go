@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"miser/internal/format"
+	"miser/internal/store"
+)
+
+var (
+	pruneOlderThan string
+	pruneMaxRows   int
+	pruneDryRun    bool
+	pruneOut       output
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete old requests from the history",
+	Long: `Removes requests older than --older-than, or all but the newest
+--max-rows, and compacts the history file. Without either flag the
+[history] max_age and max_rows settings are used; a running proxy applies
+those itself at startup.
+
+Deleted requests are gone for good: no backup is kept. Stop any miser
+proxy that writes to the same file first.`,
+	Example: `  miser prune --older-than 90d
+  miser prune --max-rows 100000 --dry-run`,
+	Args: cobra.NoArgs,
+	RunE: runPrune,
+}
+
+func init() {
+	pruneCmd.Flags().StringVar(&pruneOlderThan, "older-than", "",
+		"delete requests older than this (90d, 12w, 720h)")
+	pruneCmd.Flags().IntVar(&pruneMaxRows, "max-rows", 0,
+		"keep only the newest this many requests")
+	pruneCmd.Flags().BoolVarP(&pruneDryRun, "dry-run", "n", false,
+		"report what would be deleted without changing the file")
+	addOutputFlags(pruneCmd, &pruneOut)
+	rootCmd.AddCommand(pruneCmd)
+}
+
+func runPrune(cmd *cobra.Command, _ []string) error {
+	cfg, err := resolveConfig(cmd)
+	if err != nil {
+		return err
+	}
+	policy, err := retention(cfg)
+	if err != nil {
+		return err
+	}
+	if cmd.Flags().Changed("older-than") || cmd.Flags().Changed("max-rows") {
+		age, err := parseAge(pruneOlderThan)
+		if err != nil {
+			return fmt.Errorf("--older-than: %w", err)
+		}
+		policy = store.Retention{MaxAge: age, MaxRows: max(pruneMaxRows, 0)}
+	}
+	if policy.IsZero() {
+		return fmt.Errorf("nothing to prune by: pass --older-than or --max-rows, or set [history] max_age or max_rows")
+	}
+
+	path := historyPath(cfg)
+	reqs, err := store.Load(path)
+	if err != nil {
+		return err
+	}
+	kept := policy.Apply(reqs, time.Now())
+	out := pruneOutput{History: path, Before: len(reqs), Removed: len(reqs) - len(kept), DryRun: pruneDryRun}
+	switch {
+	case out.Removed == 0:
+		if pruneOut.text() {
+			fmt.Printf("Nothing to prune: all %s requests in %s are kept.\n", format.Int(len(reqs)), path)
+			return nil
+		}
+	case pruneDryRun:
+		if pruneOut.text() {
+			fmt.Printf("Dry run: would delete %s of %s requests from %s.\n",
+				format.Int(out.Removed), format.Int(len(reqs)), path)
+			return nil
+		}
+	default:
+		if addr := fmt.Sprintf("localhost:%d", cfg.Proxy.Port); instanceRunning(addr) {
+			return fmt.Errorf("a miser proxy is running on %s; stop it first, or requests it records during the prune are lost", addr)
+		}
+		if err := store.Compact(path, kept); err != nil {
+			return err
+		}
+		if pruneOut.text() {
+			fmt.Printf("Deleted %s of %s requests from %s.\n", format.Int(out.Removed), format.Int(len(reqs)), path)
+			return nil
+		}
+	}
+
+	if pruneOut.json {
+		return writeJSON(os.Stdout, out)
+	}
+	return writeTSV(os.Stdout, []string{"history", "before", "removed", "dry_run"},
+		[][]string{{out.History, strconv.Itoa(out.Before), strconv.Itoa(out.Removed), strconv.FormatBool(out.DryRun)}})
+}
+
+type pruneOutput struct {
+	History string `json:"history"`
+	Before  int    `json:"before"`
+	Removed int    `json:"removed"` // would be removed, with --dry-run
+	DryRun  bool   `json:"dry_run"`
+}
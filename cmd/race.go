@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"miser/internal/format"
+)
+
+var (
+	raceSince string
+	raceOut   output
+)
+
+var raceCmd = &cobra.Command{
+	Use:   "race",
+	Short: "Show whether racing requests against a second model pays off",
+	Long: `Reads the requests raced with [race] from the history and shows, per pair
+of models, how often the challenger answered first, what the relayed
+answers cost and what the cancelled losers cost on top. The proxy does not
+need to be running.`,
+	Example: `  miser race
+  miser race --since 7d --json`,
+	Args: cobra.NoArgs,
+	RunE: runRace,
+}
+
+func init() {
+	raceCmd.Flags().StringVar(&raceSince, "since", "",
+		"only include requests after this (24h, 7d, 2026-01-31, RFC 3339)")
+	addOutputFlags(raceCmd, &raceOut)
+	rootCmd.AddCommand(raceCmd)
+}
+
+type racePair struct {
+	Model      string  `json:"model"`
+	Challenger string  `json:"challenger"`
+	Races      int     `json:"races"`
+	Wins       int     `json:"challenger_wins"`
+	Cost       float64 `json:"cost"`   // of the relayed answers
+	Wasted     float64 `json:"wasted"` // of the cancelled losers
+	LatencyMs  int64   `json:"avg_latency_ms"`
+}
+
+func runRace(cmd *cobra.Command, _ []string) error {
+	cfg, err := resolveConfig(cmd)
+	if err != nil {
+		return err
+	}
+	if err := applyFormat(cfg); err != nil {
+		return err
+	}
+	since, err := parseSince(raceSince, time.Now())
+	if err != nil {
+		return err
+	}
+	t, err := loadHistory(cfg)
+	if err != nil {
+		return err
+	}
+
+	pairs := []racePair{}
+	for _, st := range t.GetRaceStatsSince(since) {
+		pairs = append(pairs, racePair{
+			Model:      st.Model,
+			Challenger: st.Challenger,
+			Races:      st.Races,
+			Wins:       st.Wins,
+			Cost:       st.Cost,
+			Wasted:     st.Wasted,
+			LatencyMs:  st.Latency.Milliseconds(),
+		})
+	}
+	switch {
+	case raceOut.json:
+		return writeJSON(os.Stdout, pairs)
+	case raceOut.tsv:
+		return writeRaceTSV(os.Stdout, pairs)
+	}
+	if len(pairs) == 0 {
+		fmt.Println("No raced requests in the history; see [race] in the config.")
+		return nil
+	}
+	return printRace(os.Stdout, pairs)
+}
+
+func writeRaceTSV(w io.Writer, pairs []racePair) error {
+	var rows [][]string
+	for _, p := range pairs {
+		rows = append(rows, []string{p.Model, p.Challenger, strconv.Itoa(p.Races), strconv.Itoa(p.Wins),
+			tsvFloat(p.Cost), tsvFloat(p.Wasted), strconv.FormatInt(p.LatencyMs, 10),
+		})
+	}
+	return writeTSV(w, []string{"model", "challenger", "races", "challenger_wins",
+		"cost", "wasted", "avg_latency_ms"}, rows)
+}
+
+func printRace(w io.Writer, pairs []racePair) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODEL\tCHALLENGER\tRACES\tCHALLENGER WINS\tCOST\tWASTED\tLATENCY")
+	for _, p := range pairs {
+		wins, waste := "—", "—"
+		if p.Races > 0 {
+			wins = fmt.Sprintf("%s (%.0f%%)", format.Int(p.Wins), 100*float64(p.Wins)/float64(p.Races))
+		}
+		if p.Cost > 0 {
+			waste = fmt.Sprintf("%+.0f%%", 100*p.Wasted/p.Cost)
+		}
+		fmt.Fprintln(tw, strings.Join([]string{p.Model, p.Challenger, format.Int(p.Races), wins,
+			format.Cost(p.Cost), format.Cost(p.Wasted) + " (" + waste + ")",
+			fmtLat(time.Duration(p.LatencyMs) * time.Millisecond),
+		}, "\t"))
+	}
+	return tw.Flush()
+}
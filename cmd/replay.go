@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"miser/internal/config"
+	"miser/internal/format"
+	"miser/internal/store"
+	"miser/internal/tracker"
+)
+
+var (
+	replaySince   string
+	replayPricing string
+	replayWrite   bool
+	replayOut     output
+)
+
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Recompute stored request costs with current pricing",
+	Long: `Re-runs cost calculation over the request history using the pricing in
+the current config, or in another config file given with --pricing, and
+shows how totals change per model. Nothing is modified unless --write is
+given, in which case the history file is rewritten and the original kept
+as <history>.bak.
+
+Stop any running miser proxy before using --write: it appends to the same
+file.`,
+	Example: `  miser replay                          Preview with the current pricing
+  miser replay --pricing new-prices.toml
+  miser replay --since 2026-01-01 --write`,
+	Args: cobra.NoArgs,
+	RunE: runReplay,
+}
+
+func init() {
+	replayCmd.Flags().StringVar(&replaySince, "since", "",
+		"only recompute requests after this (24h, 7d, 2026-01-31, RFC 3339)")
+	replayCmd.Flags().StringVar(&replayPricing, "pricing", "",
+		"take [models] and [fallback] pricing from this config file")
+	replayCmd.Flags().BoolVar(&replayWrite, "write", false,
+		"rewrite the history file with the recomputed costs")
+	addOutputFlags(replayCmd, &replayOut)
+	rootCmd.AddCommand(replayCmd)
+}
+
+type replayDelta struct {
+	model    string
+	requests int
+	changed  int
+	old, new float64
+}
+
+func runReplay(cmd *cobra.Command, _ []string) error {
+	cfg, err := resolveConfig(cmd)
+	if err != nil {
+		return err
+	}
+	if err := applyFormat(cfg); err != nil {
+		return err
+	}
+	pricing := cfg
+	if replayPricing != "" {
+		if pricing, err = config.Load(replayPricing); err != nil {
+			return err
+		}
+	}
+	applyPricing(pricing)
+
+	since, err := parseSince(replaySince, time.Now())
+	if err != nil {
+		return err
+	}
+
+	path := historyPath(cfg)
+	reqs, err := store.Load(path)
+	if err != nil {
+		return err
+	}
+	if len(reqs) == 0 {
+		return fmt.Errorf("no requests in %s", path)
+	}
+
+	byModel := make(map[string]*replayDelta)
+	var total replayDelta
+	for i, r := range reqs {
+		if r.Timestamp.Before(since) {
+			continue
+		}
+		cost := tracker.CalculateCost(r.Model, r.InputTokens, r.OutputTokens, r.CacheRead, r.CacheWrite)
+		d, ok := byModel[r.Model]
+		if !ok {
+			d = &replayDelta{model: r.Model}
+			byModel[r.Model] = d
+		}
+		for _, d := range []*replayDelta{d, &total} {
+			d.requests++
+			d.old += r.Cost
+			d.new += cost
+			if cost != r.Cost {
+				d.changed++
+			}
+		}
+		reqs[i].Cost = cost
+	}
+
+	deltas := make([]*replayDelta, 0, len(byModel))
+	for _, d := range byModel {
+		deltas = append(deltas, d)
+	}
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i].new > deltas[j].new })
+
+	written := replayWrite && total.changed > 0
+	if written {
+		if err := store.Rewrite(path, reqs); err != nil {
+			return err
+		}
+	}
+	if !replayOut.text() {
+		return writeReplay(deltas, total, path, written)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODEL\tREQS\tCHANGED\tOLD COST\tNEW COST\tDIFF")
+	for _, d := range append(deltas, &replayDelta{model: "total", requests: total.requests, changed: total.changed, old: total.old, new: total.new}) {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", d.model,
+			format.Int(d.requests), format.Int(d.changed),
+			format.Cost(d.old), format.Cost(d.new), signedCost(d.new-d.old))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	switch {
+	case total.changed == 0:
+		fmt.Println("\nAll costs already match the pricing; nothing to do.")
+	case !replayWrite:
+		fmt.Printf("\nDry run: %s requests would change. Re-run with --write to update %s.\n",
+			format.Int(total.changed), path)
+	default:
+		fmt.Printf("\nUpdated %s requests in %s (backup: %s.bak).\n",
+			format.Int(total.changed), path, path)
+	}
+	return nil
+}
+
+type replayRow struct {
+	Model    string  `json:"model,omitempty"`
+	Requests int     `json:"requests"`
+	Changed  int     `json:"changed"`
+	OldCost  float64 `json:"old_cost"`
+	NewCost  float64 `json:"new_cost"`
+}
+
+type replayOutput struct {
+	History string      `json:"history"`
+	Written bool        `json:"written"` // --write was given and costs changed
+	Total   replayRow   `json:"total"`
+	Models  []replayRow `json:"models"`
+}
+
+func (d *replayDelta) row() replayRow {
+	return replayRow{Model: d.model, Requests: d.requests, Changed: d.changed, OldCost: d.old, NewCost: d.new}
+}
+
+func writeReplay(deltas []*replayDelta, total replayDelta, path string, written bool) error {
+	out := replayOutput{History: path, Written: written, Total: total.row(), Models: []replayRow{}}
+	for _, d := range deltas {
+		out.Models = append(out.Models, d.row())
+	}
+	if replayOut.json {
+		return writeJSON(os.Stdout, out)
+	}
+	row := func(scope, label string, r replayRow) []string {
+		return []string{scope, label, strconv.Itoa(r.Requests), strconv.Itoa(r.Changed),
+			tsvFloat(r.OldCost), tsvFloat(r.NewCost)}
+	}
+	rows := [][]string{row("total", "", out.Total)}
+	for _, r := range out.Models {
+		rows = append(rows, row("model", r.Model, r))
+	}
+	return writeTSV(os.Stdout, []string{"scope", "label", "requests", "changed", "old_cost", "new_cost"}, rows)
+}
+
+func signedCost(c float64) string {
+	if c > 0 {
+		return "+" + format.Cost(c)
+	}
+	return format.Cost(c)
+}
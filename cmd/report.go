@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"miser/internal/report"
+)
+
+var (
+	reportSince  string
+	reportFormat string
+	reportOutput string
+	reportTop    int
+)
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Render a Markdown or HTML cost report from the request history",
+	Long: `Builds a shareable report from the persisted request history: totals, a
+per-model table, daily spend, and the most expensive requests. HTML reports
+are a single self-contained file.`,
+	Example: `  miser report --since 7d > weekly.md
+  miser report --since 7d -o weekly.html
+  miser report --format html --top 25 -o report.html`,
+	Args: cobra.NoArgs,
+	RunE: runReport,
+}
+
+func init() {
+	reportCmd.Flags().StringVar(&reportSince, "since", "",
+		"only include requests after this (24h, 7d, 2026-01-31, RFC 3339)")
+	reportCmd.Flags().StringVarP(&reportFormat, "format", "f", "",
+		"md or html (default: from --output extension, else md)")
+	reportCmd.Flags().StringVarP(&reportOutput, "output", "o", "",
+		"write to this file instead of stdout")
+	reportCmd.Flags().IntVar(&reportTop, "top", 10, "number of most expensive requests to list")
+	rootCmd.AddCommand(reportCmd)
+}
+
+func runReport(cmd *cobra.Command, _ []string) error {
+	cfg, err := resolveConfig(cmd)
+	if err != nil {
+		return err
+	}
+	if err := applyFormat(cfg); err != nil {
+		return err
+	}
+
+	name := reportFormat
+	if name == "" {
+		name = filepath.Ext(reportOutput)
+	}
+	f := report.Markdown
+	if name != "" {
+		if f, err = report.ParseFormat(name); err != nil {
+			return err
+		}
+	}
+
+	since, err := parseSince(reportSince, time.Now())
+	if err != nil {
+		return err
+	}
+	t, err := loadHistory(cfg)
+	if err != nil {
+		return err
+	}
+	r := report.Build(t, since, reportTop)
+
+	var w io.Writer = os.Stdout
+	if reportOutput != "" {
+		file, err := os.Create(reportOutput)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := report.Write(w, f, r); err != nil {
+		return err
+	}
+	if reportOutput != "" {
+		fmt.Fprintf(os.Stderr, "wrote %s\n", reportOutput)
+	}
+	return nil
+}
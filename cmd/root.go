@@ -1,27 +1,52 @@
 package cmd
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"maps"
+	"net/http"
+	"net/mail"
+	"net/url"
 	"os"
-	"os/signal"
+	"path"
+	"path/filepath"
+	"slices"
 	"strconv"
-	"syscall"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
-	"miser/internal/compress"
+	"miser/internal/api"
+	"miser/internal/budget"
+	"miser/internal/capture"
 	"miser/internal/config"
+	"miser/internal/export"
+	"miser/internal/format"
+	"miser/internal/fxrate"
+	"miser/internal/hook"
+	"miser/internal/mock"
+	"miser/internal/notify"
 	"miser/internal/proxy"
+	"miser/internal/redact"
+	"miser/internal/schedule"
+	"miser/internal/sink"
+	"miser/internal/slo"
+	"miser/internal/store"
+	"miser/internal/syslog"
 	"miser/internal/tracker"
-	"miser/internal/tui"
+	"miser/internal/upload"
 )
 
+const probeTimeout = 500 * time.Millisecond
+
 var (
-	cfgPath  string
-	port     int
-	target   string
-	headless bool
+	cfgPath     string
+	profileName string
 )
 
 var rootCmd = &cobra.Command{
@@ -32,15 +57,22 @@ and the Anthropic API. It transparently forwards every request while tracking
 token usage and cost in a k9s-style terminal dashboard.
 
 Point your tool at http://localhost:8080 instead of api.anthropic.com and
-watch your spend in real time.`,
-	Example: `  miser                            Run proxy + TUI dashboard
+watch your spend in real time.
+
+Run without a subcommand, miser attaches the dashboard to the instance
+already serving on the configured port, or starts one (like "miser serve")
+if there is none.`,
+	Example: `  miser                            Attach to a running proxy, or run proxy + TUI
+  miser serve                      Always run the proxy (fails if the port is taken)
+  miser serve --headless           Run proxy only (no TUI, logs to stderr)
+  miser serve --daemon             Run proxy in the background (see "miser stop")
   miser --port 9090                Use a custom port
-  miser --headless                 Run proxy only (no TUI, logs to stderr)
   miser -c ~/.config/miser/my.toml Use a specific config file
+  miser --profile work             Apply the [profile.work] settings
   MISER_PORT=9090 miser            Configure via environment`,
 	SilenceUsage:  true,
 	SilenceErrors: true,
-	RunE:          runServe,
+	RunE:          runRoot,
 }
 
 func Execute() error {
@@ -50,75 +82,36 @@ func Execute() error {
 func init() {
 	rootCmd.PersistentFlags().StringVarP(&cfgPath, "config", "c", "",
 		"config file path [$MISER_CONFIG]")
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", "",
+		"apply the named [profile.<name>] from the config file [$MISER_PROFILE]")
 
-	rootCmd.Flags().IntVarP(&port, "port", "p", 0,
-		"proxy listen port [$MISER_PORT]")
-	rootCmd.Flags().StringVarP(&target, "target", "t", "",
-		"upstream API base URL [$MISER_TARGET]")
-	rootCmd.Flags().BoolVar(&headless, "headless", false,
-		"run proxy without TUI (daemon / CI mode)")
+	addServeFlags(rootCmd.Flags())
 }
 
-func runServe(cmd *cobra.Command, _ []string) error {
+// runRoot attaches to an instance already listening on the configured
+// port, so a second "miser" shows the same data instead of colliding on
+// the port; otherwise it serves.
+func runRoot(cmd *cobra.Command, args []string) error {
+	if headless || detach || sdMode || mockMode || recordPath != "" || playbackPath != "" {
+		return runServe(cmd, args)
+	}
 	cfg, err := resolveConfig(cmd)
 	if err != nil {
 		return err
 	}
-	applyPricing(cfg)
-
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-	defer stop()
-
-	t := tracker.New()
-
-	compCfg := compress.Config{
-		Whitespace:      cfg.Compression.Whitespace,
-		StackTruncation: cfg.Compression.StackTruncation,
-		Deduplication:   cfg.Compression.Deduplication,
-		MinBlockSize:    cfg.Compression.MinBlockSize,
-	}
-
-	if headless {
-		t.OnRecord = func(r tracker.Request) {
-			status := fmt.Sprintf("%d", r.StatusCode)
-			if r.Error != "" {
-				status = "ERR"
-			}
-			line := fmt.Sprintf("%s  %-22s  %6s in  %6s out  %8s  %6s  %s",
-				r.Timestamp.Format("15:04:05"),
-				r.Model,
-				fmtTok(r.InputTokens), fmtTok(r.OutputTokens),
-				fmtCost(r.Cost),
-				fmtLat(r.Latency),
-				status,
-			)
-			if r.OriginalSize > 0 && r.CompressedSize < r.OriginalSize {
-				pct := 100 - 100*r.CompressedSize/r.OriginalSize
-				line += fmt.Sprintf("  (compressed %d%%)", pct)
-			}
-			fmt.Fprintln(os.Stderr, line)
-		}
-	}
-
-	srv := proxy.NewServer(cfg.Proxy.Port, cfg.Proxy.Target, cfg.ProxyTimeout(), t, compCfg)
-
-	errCh := make(chan error, 1)
-	go func() { errCh <- srv.Start(ctx) }()
-
-	if headless {
-		fmt.Fprintf(os.Stderr, "miser proxy listening on :%d → %s (ctrl-c to stop)\n",
-			cfg.Proxy.Port, cfg.Proxy.Target)
-		select {
-		case err := <-errCh:
-			return err
-		case <-ctx.Done():
-			return nil
-		}
+	addr := fmt.Sprintf("localhost:%d", cfg.Proxy.Port)
+	if instanceRunning(addr) {
+		return attach(cfg, addr)
 	}
+	return runServe(cmd, args)
+}
 
-	proxyAddr := fmt.Sprintf("localhost:%d", cfg.Proxy.Port)
-	app := tui.New(t, proxyAddr, cfg.Proxy.Target)
-	return app.Run()
+// instanceRunning reports whether a miser instance answers at addr.
+func instanceRunning(addr string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	_, err := api.NewClient(addr).Status(ctx)
+	return err == nil
 }
 
 // resolveConfig merges: defaults → config file → env vars → CLI flags.
@@ -128,7 +121,12 @@ func resolveConfig(cmd *cobra.Command) (config.Config, error) {
 		path = os.Getenv("MISER_CONFIG")
 	}
 
-	cfg, err := config.Load(path)
+	profile := profileName
+	if profile == "" {
+		profile = os.Getenv("MISER_PROFILE")
+	}
+
+	cfg, err := config.LoadProfile(path, profile)
 	if err != nil {
 		return cfg, err
 	}
@@ -141,6 +139,36 @@ func resolveConfig(cmd *cobra.Command) (config.Config, error) {
 	if v := os.Getenv("MISER_TARGET"); v != "" && !cmd.Flags().Changed("target") {
 		cfg.Proxy.Target = v
 	}
+	if v := os.Getenv("MISER_API_TOKEN"); v != "" {
+		cfg.API.Token = v
+	}
+	if v := os.Getenv("MISER_PPROF"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.API.Pprof = b
+		}
+	}
+	if v := os.Getenv("MISER_CAPTURE_BODIES"); v != "" && !cmd.Flags().Changed("capture-bodies") {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.Capture.Enabled = b
+		}
+	}
+	if v := os.Getenv("MISER_MOCK"); v != "" && !cmd.Flags().Changed("mock") {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.Mock.Enabled = b
+		}
+	}
+	if v := os.Getenv("MISER_BUDGET"); v != "" && !cmd.Flags().Changed("budget") {
+		if b, err := strconv.ParseFloat(v, 64); err == nil {
+			cfg.Budget.Amount = b
+		}
+	}
+	if v := os.Getenv("MISER_SMTP_PASSWORD"); v != "" {
+		for i := range cfg.Notify.Email {
+			if cfg.Notify.Email[i].Password == "" {
+				cfg.Notify.Email[i].Password = v
+			}
+		}
+	}
 
 	if cmd.Flags().Changed("port") {
 		cfg.Proxy.Port = port
@@ -148,10 +176,65 @@ func resolveConfig(cmd *cobra.Command) (config.Config, error) {
 	if cmd.Flags().Changed("target") {
 		cfg.Proxy.Target = target
 	}
+	if cmd.Flags().Changed("budget") {
+		cfg.Budget.Amount = budgetAmount
+	}
+	if cmd.Flags().Changed("capture-bodies") {
+		cfg.Capture.Enabled = captureBodies
+	}
+	if cmd.Flags().Changed("mock") {
+		cfg.Mock.Enabled = mockMode
+	}
 
 	return cfg, nil
 }
 
+// profilePath returns def with "-<profile>" inserted before its extension
+// when a profile is active, so profiles don't share state files by default.
+func profilePath(cfg config.Config, def string) string {
+	if cfg.Profile == "" {
+		return def
+	}
+	ext := filepath.Ext(def)
+	return strings.TrimSuffix(def, ext) + "-" + cfg.Profile + ext
+}
+
+// openHistory loads persisted requests into t and subscribes the store so
+// every new request is appended to it. The [history] retention policy is
+// applied first, unless a proxy on the configured port still has the file
+// open; pruned is the number of requests it removed.
+func openHistory(cfg config.Config, t *tracker.Tracker) (st *store.Store, pruned int, err error) {
+	return openStore(cfg, historyPath(cfg), t)
+}
+
+// openStore is openHistory for the history file at path.
+func openStore(cfg config.Config, path string, t *tracker.Tracker) (st *store.Store, pruned int, err error) {
+	history, err := store.Load(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	policy, err := retention(cfg)
+	if err != nil {
+		return nil, 0, err
+	}
+	kept := policy.Apply(history, time.Now())
+	if len(kept) < len(history) && !instanceRunning(fmt.Sprintf("localhost:%d", cfg.Proxy.Port)) {
+		if err := store.Compact(path, kept); err != nil {
+			return nil, 0, fmt.Errorf("pruning history: %w", err)
+		}
+		pruned = len(history) - len(kept)
+		history = kept
+	}
+	t.Load(history)
+
+	st, err = store.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	t.Subscribe(func(r tracker.Request) { st.Append(r) })
+	return st, pruned, nil
+}
+
 func applyPricing(cfg config.Config) {
 	if len(cfg.Models) == 0 && cfg.Fallback == nil {
 		return
@@ -167,6 +250,7 @@ func applyPricing(cfg config.Config) {
 				OutputPerMTok:     mc.OutputPerMTok,
 				CacheReadPerMTok:  mc.CacheReadPerMTok,
 				CacheWritePerMTok: mc.CacheWritePerMTok,
+				ContextWindow:     mc.ContextWindow,
 			}
 		}
 	}
@@ -184,23 +268,791 @@ func applyPricing(cfg config.Config) {
 	tracker.ApplyPricing(models, fb)
 }
 
-// compact formatters for headless log line
-func fmtTok(n int) string {
+// applyFormat sets the process-wide number format and export style.
+func applyFormat(cfg config.Config) error {
+	unit, err := format.ParseTokenUnit(cfg.Format.Tokens)
+	if err != nil {
+		return fmt.Errorf("format: %w", err)
+	}
+	delim, err := export.ParseDelimiter(cfg.Export.Delimiter)
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	if err := export.CheckColumns(cfg.Export.Columns); err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	red, err := redactor(cfg)
+	if err != nil {
+		return err
+	}
+	currency, rate, err := displayCurrency(cfg)
+	if err != nil {
+		return err
+	}
+	format.Apply(format.Style{
+		Tokens:    unit,
+		Thousands: cfg.Format.Thousands,
+		Decimal:   cfg.Format.Decimal,
+		Currency:  currency,
+		Rate:      rate,
+	})
+	export.Apply(export.Style{
+		Filename:  cfg.Export.Filename,
+		Delimiter: delim,
+		Columns:   cfg.Export.Columns,
+		Redact:    red,
+	})
+	return nil
+}
+
+// displayCurrency validates the [format] currency settings and returns the
+// currency and rate to show amounts at: the rate last fetched from
+// rate_url if there is one, else the configured rate. It returns "" for
+// dollars, and a currency with a zero rate before a first fetch.
+func displayCurrency(cfg config.Config) (string, float64, error) {
+	f := cfg.Format
+	code := strings.ToUpper(f.Currency)
+	if code == "" || code == "USD" {
+		return "", 0, nil
+	}
+	if len(code) != 3 || strings.Trim(code, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return "", 0, fmt.Errorf("format: currency %q is not a three-letter ISO 4217 code", f.Currency)
+	}
+	if f.Rate < 0 {
+		return "", 0, fmt.Errorf("format: rate %g must be positive", f.Rate)
+	}
+	if f.RateURL == "" {
+		if f.Rate == 0 {
+			return "", 0, fmt.Errorf("format: currency %s needs a rate (units to the dollar) or a rate_url", code)
+		}
+		return code, f.Rate, nil
+	}
+	if u, err := url.Parse(f.RateURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", 0, fmt.Errorf("format: rate_url %q must be an http or https URL", f.RateURL)
+	}
+	if r, ok := fxrate.Load(fxrate.DefaultPath(), code); ok {
+		return code, r.Rate, nil
+	}
+	return code, f.Rate, nil
+}
+
+// redactor compiles [redact], with the older [capture] redact patterns.
+func redactor(cfg config.Config) (*redact.Redactor, error) {
+	r, err := redact.New(cfg.Redact.Builtin, append(slices.Clone(cfg.Redact.Patterns), cfg.Capture.Redact...))
+	if err != nil {
+		return nil, fmt.Errorf("redact: %w", err)
+	}
+	return r, nil
+}
+
+// captureConfig compiles the [capture] section.
+func captureConfig(cfg config.Config) (capture.Config, error) {
+	red, err := redactor(cfg)
+	if err != nil {
+		return capture.Config{}, err
+	}
+	if cfg.Capture.Sample <= 0 || cfg.Capture.Sample > 1 {
+		return capture.Config{}, fmt.Errorf("capture: sample %g is not above 0 and at most 1; set enabled = false to capture nothing", cfg.Capture.Sample)
+	}
+	if cfg.Capture.MinCost < 0 {
+		return capture.Config{}, fmt.Errorf("capture: min_cost %g is negative", cfg.Capture.MinCost)
+	}
+	return capture.Config{
+		Enabled:  cfg.Capture.Enabled,
+		MaxBytes: cfg.Capture.MaxBytes,
+		Redact:   red,
+		Sample:   cfg.Capture.Sample,
+		MinCost:  cfg.Capture.MinCost,
+	}, nil
+}
+
+// upstreamResolve validates [proxy] resolve and resolver for the target's
+// host.
+func upstreamResolve(cfg config.Config) (proxy.Resolve, error) {
+	if len(cfg.Proxy.Resolve) > 0 && cfg.Proxy.Resolver != "" {
+		return proxy.Resolve{}, errors.New("proxy: set resolve or resolver, not both")
+	}
+	u, err := url.Parse(cfg.Proxy.Target)
+	if err != nil {
+		return proxy.Resolve{}, fmt.Errorf("proxy: target: %w", err)
+	}
+	r, err := proxy.ParseResolve(u.Hostname(), cfg.Proxy.Resolve, cfg.Proxy.Resolver)
+	if err != nil {
+		return proxy.Resolve{}, fmt.Errorf("proxy: %w", err)
+	}
+	return r, nil
+}
+
+// tokenCheck validates [tokens]; the zero check is off.
+func tokenCheck(cfg config.Config) (proxy.TokenCheck, error) {
+	tc := cfg.Tokens
+	if !tc.Check {
+		return proxy.TokenCheck{}, nil
+	}
+	if tc.Tolerance <= 0 {
+		return proxy.TokenCheck{}, fmt.Errorf("tokens: tolerance %g must be above 0", tc.Tolerance)
+	}
+	if tc.MinTokens < 0 {
+		return proxy.TokenCheck{}, fmt.Errorf("tokens: min_tokens %d is negative", tc.MinTokens)
+	}
+	return proxy.TokenCheck{Tolerance: tc.Tolerance, MinTokens: tc.MinTokens}, nil
+}
+
+// costGate validates [budget] max_request and request_action.
+func costGate(cfg config.Config) (proxy.CostGate, error) {
+	bc := cfg.Budget
+	if bc.MaxRequest < 0 {
+		return proxy.CostGate{}, fmt.Errorf("budget: max_request %g is negative", bc.MaxRequest)
+	}
+	action, err := budget.ParseAction(bc.RequestAction)
+	if err != nil {
+		return proxy.CostGate{}, fmt.Errorf("budget: request_action: %w", err)
+	}
+	return proxy.CostGate{MaxCost: bc.MaxRequest, Block: action == budget.Block}, nil
+}
+
+// outputCap validates [proxy] max_tokens and over_max_tokens.
+func outputCap(cfg config.Config) (proxy.OutputCap, error) {
+	pc := cfg.Proxy
+	if pc.MaxTokens < 0 {
+		return proxy.OutputCap{}, fmt.Errorf("proxy: max_tokens %d is negative", pc.MaxTokens)
+	}
+	switch pc.OverMaxTokens {
+	case "", "clamp":
+		return proxy.OutputCap{MaxTokens: pc.MaxTokens}, nil
+	case "reject":
+		return proxy.OutputCap{MaxTokens: pc.MaxTokens, Reject: true}, nil
+	}
+	return proxy.OutputCap{}, fmt.Errorf("proxy: over_max_tokens = %q (want clamp or reject)", pc.OverMaxTokens)
+}
+
+// retryPolicy validates [proxy] retries, retry_backoff and
+// overload_fallback.
+func retryPolicy(cfg config.Config) (proxy.RetryPolicy, error) {
+	pc := cfg.Proxy
+	if pc.Retries < 0 {
+		return proxy.RetryPolicy{}, fmt.Errorf("proxy: retries %d is negative", pc.Retries)
+	}
+	backoff := time.Second
+	if pc.RetryBackoff != "" {
+		d, err := time.ParseDuration(pc.RetryBackoff)
+		if err != nil || d < 0 {
+			return proxy.RetryPolicy{}, fmt.Errorf("proxy: retry_backoff %q is not a duration", pc.RetryBackoff)
+		}
+		backoff = d
+	}
+	for pattern, to := range pc.OverloadFallback {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return proxy.RetryPolicy{}, fmt.Errorf("proxy: overload_fallback: bad pattern %q", pattern)
+		}
+		if to == "" {
+			return proxy.RetryPolicy{}, fmt.Errorf("proxy: overload_fallback: %q names no model", pattern)
+		}
+	}
+	return proxy.RetryPolicy{Attempts: pc.Retries, Backoff: backoff, Fallback: pc.OverloadFallback}, nil
+}
+
+// shadowConfig validates [shadow]; it returns nil when shadowing is off.
+func shadowConfig(cfg config.Config) (*proxy.Shadow, error) {
+	sc := cfg.Shadow
+	if sc.Target == "" && sc.Model == "" {
+		return nil, nil
+	}
+	if sc.Target != "" {
+		u, err := url.Parse(sc.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("shadow: target %q is not an http(s) URL", sc.Target)
+		}
+	}
+	for _, m := range sc.Models {
+		if _, err := path.Match(m, ""); err != nil {
+			return nil, fmt.Errorf("shadow: models: bad pattern %q", m)
+		}
+	}
+	if sc.Sample < 0 || sc.Sample > 1 {
+		return nil, fmt.Errorf("shadow: sample %g is not between 0 and 1", sc.Sample)
+	}
+	if sc.Keep && !cfg.Capture.Enabled {
+		return nil, errors.New("shadow: keep stores responses as previews and needs [capture] enabled")
+	}
+	return &proxy.Shadow{
+		Target: strings.TrimSuffix(sc.Target, "/"),
+		Model:  sc.Model,
+		Models: sc.Models,
+		Sample: sc.Sample,
+		Keep:   sc.Keep,
+	}, nil
+}
+
+// raceConfig validates [race]; it returns nil when racing is off.
+func raceConfig(cfg config.Config) (*proxy.Race, error) {
+	rc := cfg.Race
+	if rc.Model == "" {
+		return nil, nil
+	}
+	for _, m := range rc.Models {
+		if _, err := path.Match(m, ""); err != nil {
+			return nil, fmt.Errorf("race: models: bad pattern %q", m)
+		}
+	}
+	return &proxy.Race{Model: rc.Model, Models: rc.Models}, nil
+}
+
+// aliases validates [aliases]: each names an upstream model, not another
+// alias.
+func aliases(cfg config.Config) (map[string]string, error) {
+	for _, name := range slices.Sorted(maps.Keys(cfg.Aliases)) {
+		to := cfg.Aliases[name]
+		if strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("aliases: empty alias for %q", to)
+		}
+		if to == "" {
+			return nil, fmt.Errorf("aliases: %q names no model", name)
+		}
+		if _, ok := cfg.Aliases[to]; ok {
+			return nil, fmt.Errorf("aliases: %q names %q, which is itself an alias", name, to)
+		}
+	}
+	return cfg.Aliases, nil
+}
+
+// defaults validates the [defaults."<model glob>"] tables.
+func defaults(cfg config.Config) (map[string]proxy.Defaults, error) {
+	if len(cfg.Defaults) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]proxy.Defaults, len(cfg.Defaults))
+	for _, p := range slices.Sorted(maps.Keys(cfg.Defaults)) {
+		dc := cfg.Defaults[p]
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("defaults: bad model pattern %q", p)
+		}
+		if t := dc.Temperature; t != nil && (*t < 0 || *t > 1) {
+			return nil, fmt.Errorf("defaults: %s: temperature %g is not between 0 and 1", p, *t)
+		}
+		if t := dc.TopP; t != nil && (*t < 0 || *t > 1) {
+			return nil, fmt.Errorf("defaults: %s: top_p %g is not between 0 and 1", p, *t)
+		}
+		if dc.MaxTokens < 0 {
+			return nil, fmt.Errorf("defaults: %s: max_tokens %d is negative", p, dc.MaxTokens)
+		}
+		out[p] = proxy.Defaults{Temperature: dc.Temperature, TopP: dc.TopP,
+			StopSequences: dc.StopSequences, MaxTokens: dc.MaxTokens}
+	}
+	return out, nil
+}
+
+// sloObjectives validates the [slo."<model glob>"] tables.
+func sloObjectives(cfg config.Config) ([]slo.Objective, error) {
+	var out []slo.Objective
+	for _, p := range slices.Sorted(maps.Keys(cfg.SLO)) {
+		sc := cfg.SLO[p]
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("slo: bad model pattern %q", p)
+		}
+		o := slo.Objective{Pattern: p, Percentile: cmp.Or(sc.Percentile, 95), MinRequests: cmp.Or(sc.MinRequests, 5), Alert: sc.Alert}
+		var err error
+		if o.Latency, err = time.ParseDuration(sc.Latency); err != nil || o.Latency <= 0 {
+			return nil, fmt.Errorf("slo: %s: latency %q is not a positive duration", p, sc.Latency)
+		}
+		if o.Window, err = time.ParseDuration(cmp.Or(sc.Window, "1h")); err != nil || o.Window <= 0 {
+			return nil, fmt.Errorf("slo: %s: window %q is not a positive duration", p, sc.Window)
+		}
+		if o.Percentile <= 0 || o.Percentile > 100 {
+			return nil, fmt.Errorf("slo: %s: percentile %g is not between 0 and 100", p, sc.Percentile)
+		}
+		if o.MinRequests < 0 {
+			return nil, fmt.Errorf("slo: %s: min_requests %d is negative", p, sc.MinRequests)
+		}
+		out = append(out, o)
+	}
+	return out, nil
+}
+
+// injections validates the [[inject]] fragments. Fragments with no name
+// are named after their position, "inject 1" and so on.
+func injections(cfg config.Config) ([]proxy.Injection, error) {
+	var out []proxy.Injection
+	for i, ic := range cfg.Inject {
+		in := proxy.Injection{Name: cmp.Or(ic.Name, fmt.Sprintf("inject %d", i+1)), Text: ic.Text,
+			Routes: ic.Routes, Models: ic.Models, Projects: ic.Projects}
+		if strings.TrimSpace(ic.Text) == "" {
+			return nil, fmt.Errorf("inject: %s: no text", in.Name)
+		}
+		switch ic.Position {
+		case "", "append":
+		case "prepend":
+			in.Prepend = true
+		default:
+			return nil, fmt.Errorf("inject: %s: position = %q (want append or prepend)", in.Name, ic.Position)
+		}
+		for _, r := range ic.Routes {
+			if r != proxy.RouteMessages && r != proxy.RouteChat {
+				return nil, fmt.Errorf("inject: %s: route %q (want %s or %s)", in.Name, r, proxy.RouteMessages, proxy.RouteChat)
+			}
+		}
+		for _, m := range ic.Models {
+			if _, err := path.Match(m, ""); err != nil {
+				return nil, fmt.Errorf("inject: %s: models: bad pattern %q", in.Name, m)
+			}
+		}
+		out = append(out, in)
+	}
+	return out, nil
+}
+
+// hooks compiles the [[hook]] rules; it returns nil when there are none.
+func hooks(cfg config.Config) (*hook.Hooks, error) {
+	if len(cfg.Hooks) == 0 {
+		return nil, nil
+	}
+	rules := make([]hook.Rule, len(cfg.Hooks))
+	for i, hc := range cfg.Hooks {
+		rules[i] = hook.Rule{Name: hc.Name, On: hc.On, When: hc.When, Veto: hc.Veto,
+			Set: hc.Set, Drop: hc.Drop, Tags: hc.Tags}
+		for _, r := range hc.Replace {
+			rules[i].Replace = append(rules[i].Replace, hook.Replace{Pattern: r.Pattern, With: r.With})
+		}
+	}
+	h, err := hook.Compile(rules)
+	if err != nil {
+		return nil, fmt.Errorf("hook: %w", err)
+	}
+	return h, nil
+}
+
+// upstreams validates [upstreams]; it returns nil when there are no
+// endpoints besides [proxy] target.
+func upstreams(cfg config.Config) (*proxy.Upstreams, error) {
+	uc := cfg.Upstreams
+	if len(uc.Targets) == 0 {
+		return nil, nil
+	}
+	urls := []string{strings.TrimSuffix(cfg.Proxy.Target, "/")}
+	for _, t := range uc.Targets {
+		u, err := url.Parse(t)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("upstreams: target %q is not an http(s) URL", t)
+		}
+		if t = strings.TrimSuffix(t, "/"); !slices.Contains(urls, t) {
+			urls = append(urls, t)
+		}
+	}
+	policy, err := proxy.ParseUpstreamPolicy(uc.Policy)
+	if err != nil {
+		return nil, fmt.Errorf("upstreams: %w", err)
+	}
+	interval, err := time.ParseDuration(uc.ProbeInterval)
+	if err != nil || interval < 0 {
+		return nil, fmt.Errorf("upstreams: probe_interval %q is not a duration", uc.ProbeInterval)
+	}
+	return proxy.NewUpstreams(urls, policy, interval), nil
+}
+
+// mockConfig validates the [mock] section.
+func mockConfig(cfg config.Config) (mock.Config, error) {
+	latency, err := time.ParseDuration(cfg.Mock.Latency)
+	if err != nil {
+		return mock.Config{}, fmt.Errorf("mock: latency %q: %w", cfg.Mock.Latency, err)
+	}
+	return mock.Config{
+		Latency:          latency,
+		InputTokens:      cfg.Mock.InputTokens,
+		OutputTokens:     cfg.Mock.OutputTokens,
+		CacheReadTokens:  cfg.Mock.CacheReadTokens,
+		CacheWriteTokens: cfg.Mock.CacheWriteTokens,
+	}, nil
+}
+
+// budgetConfig validates the [budget] section. onExceeded may be nil.
+func budgetConfig(cfg config.Config, onExceeded func(budget.Status)) (budget.Config, error) {
+	period, err := budget.ParsePeriod(cfg.Budget.Period)
+	if err != nil {
+		return budget.Config{}, err
+	}
+	action, err := budget.ParseAction(cfg.Budget.Action)
+	if err != nil {
+		return budget.Config{}, err
+	}
+	return budget.Config{
+		Limit:      max(cfg.Budget.Amount, 0),
+		Period:     period,
+		Action:     action,
+		OnExceeded: onExceeded,
+	}, nil
+}
+
+// notifyTargets validates the [notify] section and builds its
+// destinations. It returns nil when none are configured.
+func notifyTargets(cfg config.Config) ([]notify.Target, error) {
+	for _, th := range cfg.Notify.BudgetThresholds {
+		if th <= 0 {
+			return nil, fmt.Errorf("notify: budget threshold %g must be above 0 (fractions: 0.8 is 80%%)", th)
+		}
+	}
+	if cfg.Notify.ExpensiveRequest < 0 {
+		return nil, fmt.Errorf("notify: expensive_request must be 0 (off) or a dollar amount")
+	}
+	if rl := cfg.Notify.RateLimit; rl < 0 || rl >= 1 {
+		return nil, fmt.Errorf("notify: rate_limit %g must be 0 (off) or a fraction below 1 (0.2 is 20%%)", rl)
+	}
+	if _, err := notifyDigests(cfg); err != nil {
+		return nil, err
+	}
+	var targets []notify.Target
+	add := func(kind string, i int, rawURL string, events []string, sender notify.Sender) error {
+		u, err := url.Parse(rawURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("notify: %s %d: url %q is not an http(s) URL", kind, i+1, rawURL)
+		}
+		if err := checkEvents(fmt.Sprintf("%s %d", kind, i+1), events); err != nil {
+			return err
+		}
+		targets = append(targets, notify.Target{Name: kind + " " + u.Host, Sender: sender, Events: events})
+		return nil
+	}
+	for i, w := range cfg.Notify.Webhooks {
+		header := make(http.Header)
+		for k, v := range w.Headers {
+			header.Set(k, v)
+		}
+		if err := add("webhook", i, w.URL, w.Events, &notify.Webhook{URL: w.URL, Header: header}); err != nil {
+			return nil, err
+		}
+	}
+	for i, c := range cfg.Notify.Slack {
+		if err := add("slack", i, c.URL, c.Events, &notify.Slack{URL: c.URL}); err != nil {
+			return nil, err
+		}
+	}
+	for i, c := range cfg.Notify.Discord {
+		if err := add("discord", i, c.URL, c.Events, &notify.Discord{URL: c.URL}); err != nil {
+			return nil, err
+		}
+	}
+	for i, sc := range cfg.Notify.Schedules {
+		if sc.URL == "" {
+			continue
+		}
+		sender, err := digestSender(i, sc)
+		if err != nil {
+			return nil, err
+		}
+		if err := add("schedule", i, sc.URL, nil, sender); err != nil {
+			return nil, err
+		}
+		// Only this schedule's digests, addressed by name.
+		targets[len(targets)-1].Name = scheduleTarget(i)
+		targets[len(targets)-1].Events = []string{}
+	}
+	for i, ec := range cfg.Notify.Email {
+		sender, err := emailSender(i, ec)
+		if err != nil {
+			return nil, err
+		}
+		events := ec.Events
+		if events == nil {
+			events = []string{} // only its reports
+		}
+		if err := checkEvents(emailTarget(i), events); err != nil {
+			return nil, err
+		}
+		targets = append(targets, notify.Target{Name: emailTarget(i), Sender: sender, Events: events})
+	}
+	if d := cfg.Notify.Desktop; d.Enabled {
+		if err := checkEvents("desktop", d.Events); err != nil {
+			return nil, err
+		}
+		targets = append(targets, notify.Target{Name: "desktop", Sender: notify.Desktop{}, Events: d.Events})
+	}
+	return targets, nil
+}
+
+func checkEvents(target string, events []string) error {
+	for _, e := range events {
+		if !slices.Contains(notify.EventTypes, e) {
+			return fmt.Errorf("notify: %s: unknown event %q (known: %s)",
+				target, e, strings.Join(notify.EventTypes, ", "))
+		}
+	}
+	return nil
+}
+
+func scheduleTarget(i int) string { return fmt.Sprintf("schedule %d", i+1) }
+
+// digestSender picks how a [[notify.schedule]] url is posted to: the
+// format setting, else the host's chat format, else plain JSON.
+func digestSender(i int, sc config.ScheduleConfig) (notify.Sender, error) {
+	format := sc.Format
+	if format == "" {
+		format = "json"
+		if u, err := url.Parse(sc.URL); err == nil {
+			switch {
+			case u.Host == "hooks.slack.com":
+				format = "slack"
+			case (u.Host == "discord.com" || u.Host == "discordapp.com") && strings.HasPrefix(u.Path, "/api/webhooks/"):
+				format = "discord"
+			}
+		}
+	}
+	switch format {
+	case "json":
+		header := make(http.Header)
+		for k, v := range sc.Headers {
+			header.Set(k, v)
+		}
+		return &notify.Webhook{URL: sc.URL, Header: header}, nil
+	case "slack":
+		return &notify.Slack{URL: sc.URL}, nil
+	case "discord":
+		return &notify.Discord{URL: sc.URL}, nil
+	}
+	return nil, fmt.Errorf("notify: schedule %d: format %q is not json, slack or discord", i+1, sc.Format)
+}
+
+func emailTarget(i int) string { return fmt.Sprintf("email %d", i+1) }
+
+// emailSender checks a [[notify.email]] entry.
+func emailSender(i int, ec config.EmailConfig) (*notify.Email, error) {
+	name := emailTarget(i)
+	if ec.Host == "" {
+		return nil, fmt.Errorf("notify: %s: host is required", name)
+	}
+	if ec.Port == 0 {
+		ec.Port = 587
+	}
+	if ec.Port < 0 || ec.Port > 65535 {
+		return nil, fmt.Errorf("notify: %s: port %d is out of range", name, ec.Port)
+	}
+	if len(ec.To) == 0 {
+		return nil, fmt.Errorf("notify: %s: to needs at least one address", name)
+	}
+	for _, addr := range append([]string{ec.From}, ec.To...) {
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return nil, fmt.Errorf("notify: %s: address %q: %w", name, addr, err)
+		}
+	}
+	return &notify.Email{Host: ec.Host, Port: ec.Port, Username: ec.Username, Password: ec.Password, From: ec.From, To: ec.To}, nil
+}
+
+// emailReport parses a [[notify.email]] report setting; nil means none.
+func emailReport(report string) (schedule.Schedule, error) {
+	switch report {
+	case "":
+		return nil, nil
+	case "daily":
+		report = "0 8 * * *"
+	case "weekly":
+		report = "0 8 * * 1"
+	}
+	c, err := schedule.ParseCron(report)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// notifyDigests parses daily_summary, the [[notify.schedule]] entries and
+// the [[notify.email]] reports.
+func notifyDigests(cfg config.Config) ([]notify.Digest, error) {
+	var digests []notify.Digest
+	if hour, minute, ok, err := dailySummaryTime(cfg); err != nil {
+		return nil, err
+	} else if ok {
+		when, err := schedule.ParseCron(fmt.Sprintf("%d %d * * *", minute, hour))
+		if err != nil {
+			return nil, err
+		}
+		digests = append(digests, notify.Digest{When: when, Type: notify.DailySummary, Top: defaultDigestTop})
+	}
+	for i, sc := range cfg.Notify.Schedules {
+		when, err := parseWhen(sc.Cron, sc.Every)
+		if err != nil {
+			return nil, fmt.Errorf("notify: schedule %d: %w", i+1, err)
+		}
+		g := notify.Digest{When: when, Type: notify.ScheduledSummary, Top: sc.Top}
+		if g.Top == 0 {
+			g.Top = defaultDigestTop
+		}
+		if sc.URL != "" {
+			g.To = scheduleTarget(i)
+		}
+		digests = append(digests, g)
+	}
+	for i, ec := range cfg.Notify.Email {
+		when, err := emailReport(ec.Report)
+		if err != nil {
+			return nil, fmt.Errorf("notify: %s: report: %w", emailTarget(i), err)
+		}
+		if when != nil {
+			// A report lists every model, not just the top few.
+			digests = append(digests, notify.Digest{When: when, Type: notify.ScheduledSummary,
+				Top: defaultDigestTop, To: emailTarget(i), AllModels: true})
+		}
+	}
+	return digests, nil
+}
+
+// parseWhen reads a schedule given as one of a cron expression or an
+// interval of at least a minute.
+func parseWhen(cron, every string) (schedule.Schedule, error) {
 	switch {
-	case n >= 1_000_000:
-		return fmt.Sprintf("%.1fM", float64(n)/1_000_000)
-	case n >= 1_000:
-		return fmt.Sprintf("%.1fK", float64(n)/1_000)
-	default:
-		return fmt.Sprintf("%d", n)
+	case (cron == "") == (every == ""):
+		return nil, fmt.Errorf("set one of cron or every")
+	case cron != "":
+		c, err := schedule.ParseCron(cron)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	d, err := time.ParseDuration(every)
+	if err != nil || d < time.Minute {
+		return nil, fmt.Errorf("every %q is not a duration of at least 1m", every)
+	}
+	return schedule.Every(d), nil
+}
+
+// exportUploads builds the [[export.upload]] jobs.
+func exportUploads(cfg config.Config) ([]*upload.Job, error) {
+	host, _ := os.Hostname()
+	var jobs []*upload.Job
+	for i, uc := range cfg.Export.Uploads {
+		name := fmt.Sprintf("export: upload %d", i+1)
+		when, err := parseWhen(uc.Cron, uc.Every)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		f := export.NDJSON
+		if uc.Format != "" {
+			if f, err = export.ParseFormat(uc.Format); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		if uc.Rows != "" && uc.Rows != "new" && uc.Rows != "all" {
+			return nil, fmt.Errorf("%s: rows %q is not new or all", name, uc.Rows)
+		}
+		u, err := url.Parse(uc.URL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("%s: url %q is not an s3:// or http(s):// URL", name, uc.URL)
+		}
+		j := &upload.Job{When: when, Format: f, All: uc.Rows == "all", Key: strings.TrimPrefix(u.Path, "/"), Host: host}
+		switch u.Scheme {
+		case "s3":
+			s3 := &upload.S3{
+				Bucket:       u.Host,
+				Endpoint:     uc.Endpoint,
+				Region:       cmp.Or(uc.Region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1"),
+				AccessKey:    cmp.Or(uc.AccessKey, os.Getenv("AWS_ACCESS_KEY_ID")),
+				SecretKey:    cmp.Or(uc.SecretKey, os.Getenv("AWS_SECRET_ACCESS_KEY")),
+				SessionToken: cmp.Or(uc.SessionToken, os.Getenv("AWS_SESSION_TOKEN")),
+			}
+			if s3.AccessKey == "" || s3.SecretKey == "" {
+				return nil, fmt.Errorf("%s: set access_key and secret_key, or $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY", name)
+			}
+			j.To = s3
+		case "http", "https":
+			header := make(http.Header)
+			for k, v := range uc.Headers {
+				header.Set(k, v)
+			}
+			j.To = &upload.HTTP{Base: u.Scheme + "://" + u.Host, Query: u.RawQuery, Header: header}
+		default:
+			return nil, fmt.Errorf("%s: url %q is not an s3:// or http(s):// URL", name, uc.URL)
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+// exportSink is one [[export.sink]], checked but not yet opened.
+type exportSink struct {
+	to    *sink.HTTP
+	name  string // the collector's host, for messages
+	spool string
+}
+
+// exportSinks checks the [[export.sink]] collectors. Unless set, each
+// sink's spool sits next to the history, named after its URL. Batches
+// carry the machine's name in X-Miser-Host.
+func exportSinks(cfg config.Config) ([]exportSink, error) {
+	host, _ := os.Hostname()
+	var sinks []exportSink
+	for i, sc := range cfg.Export.Sinks {
+		u, err := url.Parse(sc.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("export: sink %d: url %q is not an http(s) URL", i+1, sc.URL)
+		}
+		header := make(http.Header)
+		if host != "" {
+			header.Set("X-Miser-Host", host)
+		}
+		for k, v := range sc.Headers {
+			header.Set(k, v)
+		}
+		spool := sc.Spool
+		if spool == "" {
+			sum := sha256.Sum256([]byte(sc.URL))
+			spool = filepath.Join(filepath.Dir(historyPath(cfg)), "sink-"+hex.EncodeToString(sum[:4])+".jsonl")
+		}
+		sinks = append(sinks, exportSink{to: &sink.HTTP{URL: sc.URL, Header: header}, name: u.Host, spool: spool})
+	}
+	return sinks, nil
+}
+
+// defaultDigestTop is how many requests a digest lists unless told.
+const defaultDigestTop = 5
+
+// dailySummaryTime parses [notify] daily_summary. ok is false when it is
+// unset.
+func dailySummaryTime(cfg config.Config) (hour, minute int, ok bool, err error) {
+	if cfg.Notify.DailySummary == "" {
+		return 0, 0, false, nil
+	}
+	t, err := time.Parse("15:04", cfg.Notify.DailySummary)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("notify: daily_summary %q is not a time of day like \"18:00\"", cfg.Notify.DailySummary)
+	}
+	return t.Hour(), t.Minute(), true, nil
+}
+
+// syslogConfig validates [syslog].
+func syslogConfig(cfg config.Config) (syslog.Config, error) {
+	sc := syslog.Config{
+		Network:  cfg.Syslog.Network,
+		Address:  cfg.Syslog.Address,
+		Tag:      cfg.Syslog.Tag,
+		Facility: cfg.Syslog.Facility,
+	}
+	if err := syslog.Check(sc); err != nil {
+		return sc, err
+	}
+	return sc, checkEvents("syslog", cfg.Syslog.Events)
+}
+
+// errorRateConfig validates the [notify] error-rate settings.
+func errorRateConfig(cfg config.Config) (notify.ErrorRateConfig, error) {
+	window, err := time.ParseDuration(cfg.Notify.ErrorWindow)
+	if err != nil || window <= 0 {
+		return notify.ErrorRateConfig{}, fmt.Errorf("notify: error_window %q is not a positive duration", cfg.Notify.ErrorWindow)
 	}
+	return notify.ErrorRateConfig{
+		Rate:        cfg.Notify.ErrorRate,
+		Window:      window,
+		MinRequests: max(cfg.Notify.ErrorMinRequests, 1),
+	}, nil
+}
+
+// compact formatters for headless log line
+func fmtTok(n int) string {
+	return format.Tokens(n)
 }
 
 func fmtCost(c float64) string {
 	if c == 0 {
-		return "$0.00"
+		return format.Dollars(0, 2)
 	}
-	return fmt.Sprintf("$%.4f", c)
+	return format.Dollars(c, 4)
 }
 
 func fmtLat(d interface{ Seconds() float64 }) string {
@@ -0,0 +1,718 @@
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"miser/internal/api"
+	"miser/internal/audit"
+	"miser/internal/budget"
+	"miser/internal/cassette"
+	"miser/internal/cluster"
+	"miser/internal/compress"
+	"miser/internal/daemon"
+	"miser/internal/format"
+	"miser/internal/fxrate"
+	"miser/internal/keys"
+	"miser/internal/mock"
+	"miser/internal/notify"
+	"miser/internal/proxy"
+	"miser/internal/sink"
+	"miser/internal/syslog"
+	"miser/internal/systemd"
+	"miser/internal/tracker"
+	"miser/internal/tui"
+)
+
+var (
+	port     int
+	target   string
+	headless bool
+	detach   bool
+	sdMode   bool
+	debug    bool
+	debugLog string
+
+	budgetAmount  float64
+	captureBodies bool
+	mockMode      bool
+	recordPath    string
+	playbackPath  string
+
+	// sysLog, if set, also receives the headless log lines.
+	sysLog *syslog.Writer
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run the proxy (with the dashboard unless --headless)",
+	Long: `Starts the proxy on the configured port and, unless --headless is set,
+the dashboard. Use "miser top" from another terminal to attach a second
+dashboard to it.`,
+	Example: `  miser serve
+  miser serve --headless --port 9090
+  miser serve --daemon
+  miser serve --mock               Try a client's setup without spending anything
+  miser serve --record s.jsonl     Save upstream traffic; serve it later with --playback`,
+	Args: cobra.NoArgs,
+	RunE: runServe,
+}
+
+func init() {
+	addServeFlags(serveCmd.Flags())
+	rootCmd.AddCommand(serveCmd)
+}
+
+// addServeFlags registers the proxy flags. They are shared by serve and
+// the root command, which serves when no instance is running.
+func addServeFlags(f *pflag.FlagSet) {
+	f.IntVarP(&port, "port", "p", 0,
+		"proxy listen port [$MISER_PORT]")
+	f.StringVarP(&target, "target", "t", "",
+		"upstream API base URL [$MISER_TARGET]")
+	f.BoolVar(&headless, "headless", false,
+		"run proxy without TUI (daemon / CI mode)")
+	f.BoolVar(&detach, "daemon", false,
+		"run headless in the background; see \"miser stop\" and \"miser status\"")
+	f.BoolVar(&sdMode, "systemd", false,
+		"run headless with journal-friendly logs, for a systemd service")
+	f.Float64Var(&budgetAmount, "budget", 0,
+		"spend limit in dollars; see [budget] for period and action [$MISER_BUDGET]")
+	f.BoolVar(&mockMode, "mock", false,
+		"answer with canned responses instead of calling the API; see [mock] [$MISER_MOCK]")
+	f.StringVar(&recordPath, "record", "",
+		"append every upstream exchange to this cassette file (JSONL)")
+	f.StringVar(&playbackPath, "playback", "",
+		"answer from a cassette written by --record instead of calling the API")
+	f.BoolVar(&captureBodies, "capture-bodies", false,
+		"store redacted prompt/response previews; see [capture] [$MISER_CAPTURE_BODIES]")
+	f.BoolVar(&debug, "debug", false,
+		"log proxy internals: upstream URLs, headers, SSE and conversion fallbacks")
+	f.StringVar(&debugLog, "debug-log", "",
+		"where --debug writes while the dashboard is open (default: ~/.local/state/miser/debug.log)")
+}
+
+// notifyFlushTimeout bounds how long shutdown waits for webhooks.
+const notifyFlushTimeout = 5 * time.Second
+
+// mockTarget stands in for the upstream URL in --mock mode.
+const mockTarget = "mock (no API calls)"
+
+func runServe(cmd *cobra.Command, _ []string) error {
+	cfg, err := resolveConfig(cmd)
+	if err != nil {
+		return err
+	}
+	if detach && !daemon.IsChild() {
+		return startDaemon(cfg)
+	}
+	if daemon.IsChild() {
+		headless = true
+		defer daemon.Release(pidPath(cfg))
+	}
+	if sdMode {
+		headless = true
+	}
+	if n := countSet(cfg.Mock.Enabled, recordPath != "", playbackPath != ""); n > 1 {
+		return fmt.Errorf("--mock, --record and --playback are mutually exclusive")
+	}
+	applyPricing(cfg)
+	if err := applyFormat(cfg); err != nil {
+		return err
+	}
+
+	if cfg.Syslog.Enabled {
+		sc, err := syslogConfig(cfg)
+		if err != nil {
+			return err
+		}
+		if sysLog, err = syslog.Dial(sc); err != nil {
+			return fmt.Errorf("syslog: %w", err)
+		}
+		defer sysLog.Close()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if currency, _, _ := displayCurrency(cfg); currency != "" && cfg.Format.RateURL != "" {
+		go fxrate.Refresh(ctx, fxrate.Config{
+			URL:      cfg.Format.RateURL,
+			Currency: currency,
+			Set:      func(rate float64) { format.SetRate(currency, rate) },
+			Failed: func(err error) {
+				if headless {
+					logWarning("exchange rate: %v; keeping the last rate", err)
+				}
+			},
+			SaveFailed: func(err error) {
+				if headless {
+					logWarning("exchange rate: can't cache it: %v", err)
+				}
+			},
+		})
+	}
+
+	t := tracker.New()
+
+	// Mocked and replayed requests cost nothing and would only skew the
+	// real history.
+	if cfg.History.Enabled && !cfg.Mock.Enabled && playbackPath == "" {
+		st, pruned, err := openHistory(cfg, t)
+		if err != nil {
+			return err
+		}
+		defer st.Close()
+		if pruned > 0 && headless {
+			logInfo("pruned %s requests from %s per the [history] retention policy",
+				format.Int(pruned), st.Path())
+		}
+	}
+
+	var auditLog *audit.Log
+	if cfg.Audit.Enabled {
+		if auditLog, err = audit.Open(auditPath(cfg)); err != nil {
+			return err
+		}
+		defer auditLog.Close()
+	}
+
+	compCfg := compress.Config{
+		Whitespace:      cfg.Compression.Whitespace,
+		StackTruncation: cfg.Compression.StackTruncation,
+		Deduplication:   cfg.Compression.Deduplication,
+		MinBlockSize:    cfg.Compression.MinBlockSize,
+	}
+
+	if headless {
+		t.Subscribe(func(r tracker.Request) {
+			status := fmt.Sprintf("%d", r.StatusCode)
+			if r.Error != "" {
+				status = "ERR"
+			}
+			if r.Cancelled() {
+				status = "CANCEL"
+			} else if r.Partial() {
+				status = "PART"
+			} else if r.Retried() {
+				status = "RETRY"
+			}
+			if r.Race != nil && r.Race.Lost {
+				status = "LOST"
+			}
+			line := fmt.Sprintf("%-22s  %6s in  %6s out  %8s  %6s  %s",
+				r.Model,
+				fmtTok(r.InputTokens), fmtTok(r.OutputTokens),
+				fmtCost(r.Cost),
+				fmtLat(r.Latency),
+				status,
+			)
+			if r.OriginalSize > 0 && r.CompressedSize < r.OriginalSize {
+				pct := 100 - 100*r.CompressedSize/r.OriginalSize
+				line += fmt.Sprintf("  (compressed %d%%)", pct)
+			}
+			if r.Client != "" {
+				line += "  via " + r.Client
+			}
+			if r.Key != "" {
+				line += "  key " + r.Key
+			}
+			if r.From != "" {
+				line += "  from " + r.From
+			}
+			if r.Node != "" {
+				line += "  on " + r.Node
+			}
+			if r.Project != "" {
+				line += "  [" + r.Project + "]"
+			}
+			if r.EstimatedInput > 0 {
+				line += "  (estimated " + fmtTok(r.EstimatedInput) + " in)"
+			}
+			if r.EstimatedUsage {
+				line += "  (usage estimated)"
+			}
+			if r.Projected > 0 {
+				line += "  (projected " + format.Cost(r.Projected) + ")"
+			}
+			if r.CappedFrom > 0 {
+				line += "  (max_tokens capped from " + fmtTok(r.CappedFrom) + ")"
+			}
+			if r.Retry != nil {
+				line += fmt.Sprintf("  (attempt %d)", r.Retry.Attempt)
+			}
+			if r.FallbackFrom != "" {
+				line += "  (fell back from " + r.FallbackFrom + ")"
+			}
+			if r.Race != nil && r.Race.Lost {
+				line += "  (raced; " + r.Race.Rival + " won)"
+			} else if r.Race != nil {
+				line += "  (raced against " + r.Race.Rival + ")"
+			}
+			if len(r.Tags) > 0 {
+				line += "  #" + strings.Join(r.Tags, " #")
+			}
+			if sysLog != nil && cfg.Syslog.Requests {
+				if r.Failed() {
+					sysLog.Warning(line)
+				} else {
+					sysLog.Info(line)
+				}
+			}
+			// The journal timestamps lines itself.
+			switch {
+			case !sdMode:
+				line = r.Timestamp.Format("15:04:05") + "  " + line
+			case r.Failed():
+				line = systemd.Warning + line
+			default:
+				line = systemd.Info + line
+			}
+			fmt.Fprintln(os.Stderr, line)
+		})
+	}
+	if systemd.Supervised() {
+		t.Subscribe(func(tracker.Request) {
+			s := t.GetSummarySince(t.SessionStart())
+			systemd.Status(fmt.Sprintf("%d requests, %s this session", s.TotalRequests, fmtCost(s.TotalCost)))
+		})
+	}
+
+	upstream := cfg.Proxy.Target
+	resolve, err := upstreamResolve(cfg)
+	if err != nil {
+		return err
+	}
+	var transport http.RoundTripper = http.DefaultTransport
+	if cfg.Mock.Enabled {
+		mcfg, err := mockConfig(cfg)
+		if err != nil {
+			return err
+		}
+		if cfg.Proxy.Target, err = mock.Serve(ctx, mcfg); err != nil {
+			return fmt.Errorf("starting mock upstream: %w", err)
+		}
+		upstream = mockTarget
+	}
+
+	srv := proxy.NewServer(cfg.Proxy.Port, cfg.Proxy.Target, cfg.ProxyTimeout(), t, compCfg)
+	if srv.Capture, err = captureConfig(cfg); err != nil {
+		return err
+	}
+	if resolve.Enabled() && !cfg.Mock.Enabled {
+		transport = resolve.Transport()
+		srv.SetTransport(transport)
+		upstream += " (" + resolve.String() + ")"
+	}
+	switch {
+	case recordPath != "":
+		rec, err := cassette.NewRecorder(recordPath, transport)
+		if err != nil {
+			return err
+		}
+		defer rec.Close()
+		srv.SetTransport(rec)
+		upstream += " (recording to " + recordPath + ")"
+	case playbackPath != "":
+		player, err := cassette.Load(playbackPath)
+		if err != nil {
+			return err
+		}
+		srv.SetTransport(player)
+		upstream = fmt.Sprintf("playback of %s (%d exchanges)", playbackPath, player.Len())
+	}
+	if srv.Defaults, err = defaults(cfg); err != nil {
+		return err
+	}
+	if srv.Inject, err = injections(cfg); err != nil {
+		return err
+	}
+	if srv.Aliases, err = aliases(cfg); err != nil {
+		return err
+	}
+	if srv.Hooks, err = hooks(cfg); err != nil {
+		return err
+	}
+	if srv.Race, err = raceConfig(cfg); err != nil {
+		return err
+	}
+	// A cassette holds one response per request.
+	if srv.Race != nil && (recordPath != "" || playbackPath != "") {
+		srv.Race = nil
+	}
+	if srv.Upstreams, err = upstreams(cfg); err != nil {
+		return err
+	}
+	// A mock or cassette stands in for every endpoint, and probes would
+	// be recorded.
+	if srv.Upstreams != nil && (cfg.Mock.Enabled || recordPath != "" || playbackPath != "") {
+		srv.Upstreams = nil
+	}
+	if srv.Upstreams != nil {
+		upstream += fmt.Sprintf(" (%s of %d endpoints)", srv.Upstreams.Policy, len(srv.Upstreams.Endpoints()))
+	}
+	srv.Version = Version
+	if upstream != cfg.Proxy.Target {
+		srv.TargetName = upstream
+	}
+	srv.APIToken = cfg.API.Token
+	srv.Pprof = cfg.API.Pprof
+	srv.Audit = auditLog
+	srv.APIKey = cfg.Proxy.APIKey
+	srv.Project = cfg.Proxy.Project
+	srv.LookupClients = cfg.Proxy.LookupClients
+	srv.StallTimeout = cfg.ProxyStallTimeout()
+	srv.Chain = cfg.Proxy.Chain
+	if srv.CostGate, err = costGate(cfg); err != nil {
+		return err
+	}
+	if srv.OutputCap, err = outputCap(cfg); err != nil {
+		return err
+	}
+	if srv.Retry, err = retryPolicy(cfg); err != nil {
+		return err
+	}
+	if srv.Tokens, err = tokenCheck(cfg); err != nil {
+		return err
+	}
+	if srv.Shadow, err = shadowConfig(cfg); err != nil {
+		return err
+	}
+	// Like the history, shadows are for real traffic only, and a cassette
+	// holds just the real exchanges.
+	if srv.Shadow != nil && (cfg.Mock.Enabled || recordPath != "" || playbackPath != "") {
+		srv.Shadow = nil
+	}
+	if srv.Shadow != nil {
+		srv.Shadow.Tracker = tracker.New()
+		if cfg.History.Enabled {
+			st, _, err := openStore(cfg, shadowPath(cfg), srv.Shadow.Tracker)
+			if err != nil {
+				return fmt.Errorf("shadow: %w", err)
+			}
+			defer st.Close()
+		}
+		if headless {
+			srv.Shadow.Tracker.Subscribe(logShadow)
+		}
+	}
+	srv.Conns = proxy.ConnLimits{
+		ReadHeaderTimeout: cfg.ProxyReadHeaderTimeout(),
+		IdleTimeout:       cfg.ProxyIdleTimeout(),
+		MaxConns:          cfg.Proxy.MaxConns,
+		MaxConnsPerIP:     cfg.Proxy.MaxConnsPerIP,
+	}
+	if srv.Keys, err = keys.Open(keysPath(cfg)); err != nil {
+		return err
+	}
+	srv.UpstreamKeys = cfg.Keys.Upstream
+	srv.RequireKeys = cfg.Keys.Require
+	targets, err := notifyTargets(cfg)
+	if err != nil {
+		return err
+	}
+	if sysLog != nil {
+		targets = append(targets, notify.Target{Name: "syslog", Sender: notify.Syslog{W: sysLog}, Events: cfg.Syslog.Events})
+	}
+	erc, err := errorRateConfig(cfg)
+	if err != nil {
+		return err
+	}
+	objectives, err := sloObjectives(cfg)
+	if err != nil {
+		return err
+	}
+	uploads, err := exportUploads(cfg)
+	if err != nil {
+		return err
+	}
+	sinks, err := exportSinks(cfg)
+	if err != nil {
+		return err
+	}
+	bcfg, err := budgetConfig(cfg, func(st budget.Status) {
+		if headless {
+			logWarning("budget of %s %s reached (%s spent)%s", format.Cost(st.Limit),
+				st.Period.Label(), format.Cost(st.Spent), blockNote(st))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	srv.Budget = budget.New(bcfg, t)
+	if st := srv.Budget.Status(); headless && st.Exceeded() {
+		logWarning("budget of %s %s already reached (%s spent)%s", format.Cost(st.Limit),
+			st.Period.Label(), format.Cost(st.Spent), blockNote(st))
+	}
+	var listening atomic.Bool
+	if len(targets) > 0 {
+		var logf func(string, ...any)
+		if headless {
+			logf = logWarning
+		}
+		host, _ := os.Hostname()
+		d := notify.NewDispatcher(notify.Source{Version: Version, Host: host, Profile: cfg.Profile}, targets, logf)
+		notify.WatchBudget(t, srv.Budget, cfg.Notify.BudgetThresholds, d.Notify)
+		if erc.Rate > 0 {
+			notify.WatchErrors(t, erc, d.Notify)
+		}
+		if cfg.Notify.ExpensiveRequest > 0 {
+			notify.WatchCost(t, cfg.Notify.ExpensiveRequest, d.Notify)
+		}
+		if cfg.Notify.RateLimit > 0 {
+			notify.WatchRateLimit(t, cfg.Notify.RateLimit, d.Notify)
+		}
+		if len(objectives) > 0 {
+			notify.WatchSLO(t, objectives, d.Notify)
+		}
+		digests, _ := notifyDigests(cfg)
+		for _, g := range digests {
+			go g.Run(ctx, t, d.Notify)
+		}
+		defer func() {
+			// A proxy that never started has nothing to summarize.
+			if listening.Load() {
+				d.Notify(notify.Summary(t))
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), notifyFlushTimeout)
+			defer cancel()
+			if err := d.Close(ctx); err != nil && headless {
+				logWarning("notify: gave up on undelivered events at shutdown")
+			}
+		}()
+	}
+	// Like the history, remote exports are for real traffic only.
+	if !cfg.Mock.Enabled && playbackPath == "" {
+		var uploading sync.WaitGroup
+		for _, j := range uploads {
+			j.Done = func(n int, key string, err error) {
+				switch {
+				case !headless:
+				case err != nil:
+					logWarning("export upload: %v", err)
+				default:
+					logInfo("uploaded %s requests → %s", format.Int(n), key)
+				}
+			}
+			uploading.Add(1)
+			go func() {
+				defer uploading.Done()
+				j.Run(ctx, t)
+			}()
+		}
+		// Stopping ctx makes each job upload, and each sink send, what is
+		// left before exiting.
+		defer func() {
+			stop()
+			uploading.Wait()
+		}()
+		for _, es := range sinks {
+			fw, err := sink.NewForwarder(es.to, es.spool)
+			if err != nil {
+				return fmt.Errorf("export: sink %s: %w", es.name, err)
+			}
+			fw.Done = func(n int, err error, backlog int) {
+				if headless && err != nil {
+					logWarning("export sink %s: %v (%s requests waiting)", es.name, err, format.Int(backlog))
+				}
+			}
+			if n := fw.Backlog(); n > 0 && headless {
+				logInfo("export sink %s: %s requests from an earlier run waiting", es.name, format.Int(n))
+			}
+			t.Subscribe(fw.Add)
+			uploading.Add(1)
+			go func() {
+				defer uploading.Done()
+				fw.Run(ctx)
+			}()
+		}
+
+		if cfg.Cluster.Aggregator != "" {
+			host, _ := os.Hostname()
+			node := cmp.Or(cfg.Cluster.Node, host, "miser")
+			c := api.NewClient(cfg.Cluster.Aggregator)
+			c.Token = cfg.Cluster.Token
+			c.UserAgent = "miser/" + Version + " node " + node
+			pusher := cluster.NewPusher(c, node, func(err error, backlog int) {
+				if headless {
+					logWarning("cluster: %v (%s requests waiting)", err, format.Int(backlog))
+				}
+			})
+			t.Subscribe(pusher.Add)
+			pushed := make(chan struct{})
+			go func() {
+				defer close(pushed)
+				pusher.Run(ctx)
+			}()
+			defer func() {
+				stop()
+				<-pushed
+			}()
+			if headless {
+				logInfo("pushing requests to %s as node %q", c.Base(), node)
+			}
+		}
+	}
+	srv.Aggregate = cfg.Cluster.Aggregate
+	srv.ReloadPricing = func() error {
+		cfg, err := resolveConfig(cmd)
+		if err != nil {
+			return err
+		}
+		applyPricing(cfg)
+		return nil
+	}
+	srv.Debug = debug
+	switch {
+	case sdMode:
+		srv.SetLogger(log.New(systemd.LogWriter(os.Stderr), "[proxy] ", 0))
+	case !headless:
+		// Anything on stderr would draw over the dashboard.
+		w := io.Discard
+		if debug {
+			f, err := openDebugLog()
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		srv.SetLogger(log.New(w, "[proxy] ", log.LstdFlags|log.Lmicroseconds))
+	}
+	// Readiness and watchdog are no-ops unless systemd started us, so a
+	// Type=notify unit works with or without --systemd.
+	listenAddr := fmt.Sprintf("localhost:%d", cfg.Proxy.Port)
+	srv.Listening = func() {
+		listening.Store(true)
+		systemd.Ready(fmt.Sprintf("Listening on :%d → %s", cfg.Proxy.Port, upstream))
+		go systemd.Watchdog(ctx, func() bool { return instanceRunning(listenAddr) })
+	}
+	defer systemd.Stopping()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.Start(ctx) }()
+
+	if headless {
+		using := ""
+		if cfg.Profile != "" {
+			using = fmt.Sprintf(" [profile %s]", cfg.Profile)
+		}
+		if sdMode {
+			fmt.Fprintf(os.Stderr, "%smiser proxy listening on :%d → %s%s\n",
+				systemd.Info, cfg.Proxy.Port, upstream, using)
+		} else {
+			fmt.Fprintf(os.Stderr, "miser proxy listening on :%d → %s%s (ctrl-c to stop)\n",
+				cfg.Proxy.Port, upstream, using)
+		}
+		select {
+		case err := <-errCh:
+			return err
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	opts := tui.Options{
+		ProxyAddr:   listenAddr,
+		TargetAddr:  upstream,
+		SkipConfirm: !cfg.TUI.Confirm,
+		ExportDir:   cfg.Export.Dir,
+		Budget:      srv.Budget,
+		Audit:       auditLog,
+		SLO:         objectives,
+	}
+	if srv.Upstreams != nil {
+		opts.Upstreams = srv.Upstreams
+	}
+	app := tui.New(t, opts)
+	return app.Run()
+}
+
+// logInfo prints a headless status line, tagged for the journal in
+// --systemd mode and copied to [syslog] if enabled.
+// logShadow logs a shadow request next to the real one it duplicated.
+func logShadow(r tracker.Request) {
+	o := r.ShadowOf
+	switch {
+	case r.Failed():
+		logWarning("shadow %s failed: %s", r.Model, cmp.Or(r.Error, fmt.Sprintf("status %d", r.StatusCode)))
+	case o == nil:
+		logInfo("shadow %s: %s in %s", r.Model, fmtCost(r.Cost), fmtLat(r.Latency))
+	case o.Error != "" || o.StatusCode >= 400:
+		logInfo("shadow %s: %s in %s, where %s failed", r.Model, fmtCost(r.Cost), fmtLat(r.Latency), o.Model)
+	default:
+		logInfo("shadow %s: %s in %s, vs %s in %s for %s", r.Model, fmtCost(r.Cost), fmtLat(r.Latency),
+			fmtCost(o.Cost), fmtLat(o.Latency), o.Model)
+	}
+}
+
+func logInfo(msg string, args ...any) {
+	prefix := ""
+	if sdMode {
+		prefix = systemd.Info
+	}
+	fmt.Fprintf(os.Stderr, prefix+msg+"\n", args...)
+	if sysLog != nil {
+		sysLog.Info(fmt.Sprintf(msg, args...))
+	}
+}
+
+// logWarning prints a headless warning line, tagged for the journal in
+// --systemd mode and copied to [syslog] if enabled.
+func logWarning(msg string, args ...any) {
+	prefix := "warning: "
+	if sdMode {
+		prefix = systemd.Warning
+	}
+	fmt.Fprintf(os.Stderr, prefix+msg+"\n", args...)
+	if sysLog != nil {
+		sysLog.Warning(fmt.Sprintf(msg, args...))
+	}
+}
+
+func countSet(flags ...bool) int {
+	n := 0
+	for _, f := range flags {
+		if f {
+			n++
+		}
+	}
+	return n
+}
+
+func blockNote(st budget.Status) string {
+	if st.Action == budget.Block {
+		return "; blocking model requests"
+	}
+	return ""
+}
+
+func openDebugLog() (*os.File, error) {
+	path := debugLog
+	if path == "" {
+		path = filepath.Join(daemon.StateDir(), "debug.log")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening debug log: %w", err)
+	}
+	return f, nil
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"miser/internal/format"
+	"miser/internal/store"
+	"miser/internal/tracker"
+)
+
+var (
+	shadowSince string
+	shadowOut   output
+)
+
+var shadowCmd = &cobra.Command{
+	Use:   "shadow",
+	Short: "Compare shadow requests with the real ones they duplicated",
+	Long: `Reads the shadow requests recorded with [shadow] and compares them, per
+pair of models, with the real requests they duplicated: cost, errors,
+latency and output. The proxy does not need to be running.`,
+	Example: `  miser shadow
+  miser shadow --since 7d --json`,
+	Args: cobra.NoArgs,
+	RunE: runShadow,
+}
+
+func init() {
+	shadowCmd.Flags().StringVar(&shadowSince, "since", "",
+		"only include requests after this (24h, 7d, 2026-01-31, RFC 3339)")
+	addOutputFlags(shadowCmd, &shadowOut)
+	rootCmd.AddCommand(shadowCmd)
+}
+
+type shadowSide struct {
+	Errors    int     `json:"errors"`
+	Output    int     `json:"output_tokens"`
+	Cost      float64 `json:"cost"`
+	LatencyMs int64   `json:"avg_latency_ms"`
+}
+
+type shadowPair struct {
+	Model    string     `json:"model"`
+	Shadow   string     `json:"shadow"`
+	Requests int        `json:"requests"`
+	Real     shadowSide `json:"real"`
+	Mirror   shadowSide `json:"mirror"`
+}
+
+func runShadow(cmd *cobra.Command, _ []string) error {
+	cfg, err := resolveConfig(cmd)
+	if err != nil {
+		return err
+	}
+	if err := applyFormat(cfg); err != nil {
+		return err
+	}
+	since, err := parseSince(shadowSince, time.Now())
+	if err != nil {
+		return err
+	}
+	path := shadowPath(cfg)
+	reqs, err := store.Load(path)
+	if err != nil {
+		return err
+	}
+	t := tracker.New()
+	t.Load(reqs)
+
+	pairs := []shadowPair{}
+	for _, st := range t.GetShadowStatsSince(since) {
+		pairs = append(pairs, shadowPair{
+			Model:    st.Model,
+			Shadow:   st.Shadow,
+			Requests: st.TotalRequests,
+			Real:     shadowSide{st.Real.TotalErrors, st.Real.TotalOutput, st.Real.TotalCost, st.RealLatency.Milliseconds()},
+			Mirror:   shadowSide{st.TotalErrors, st.TotalOutput, st.TotalCost, st.Latency.Milliseconds()},
+		})
+	}
+	switch {
+	case shadowOut.json:
+		return writeJSON(os.Stdout, pairs)
+	case shadowOut.tsv:
+		return writeShadowTSV(os.Stdout, pairs)
+	}
+	if len(pairs) == 0 {
+		fmt.Printf("No shadow requests in %s; see [shadow] in the config.\n", path)
+		return nil
+	}
+	return printShadow(os.Stdout, pairs)
+}
+
+func writeShadowTSV(w io.Writer, pairs []shadowPair) error {
+	var rows [][]string
+	for _, p := range pairs {
+		rows = append(rows, []string{p.Model, p.Shadow, strconv.Itoa(p.Requests),
+			tsvFloat(p.Real.Cost), tsvFloat(p.Mirror.Cost),
+			strconv.Itoa(p.Real.Errors), strconv.Itoa(p.Mirror.Errors),
+			strconv.FormatInt(p.Real.LatencyMs, 10), strconv.FormatInt(p.Mirror.LatencyMs, 10),
+			strconv.Itoa(p.Real.Output), strconv.Itoa(p.Mirror.Output),
+		})
+	}
+	return writeTSV(w, []string{"model", "shadow", "requests", "cost", "shadow_cost",
+		"errors", "shadow_errors", "avg_latency_ms", "shadow_avg_latency_ms",
+		"output_tokens", "shadow_output_tokens"}, rows)
+}
+
+func printShadow(w io.Writer, pairs []shadowPair) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODEL\tSHADOW\tREQS\tCOST\tSHADOW COST\tERRS\tLATENCY\tOUTPUT")
+	for _, p := range pairs {
+		diff := "—"
+		if p.Real.Cost > 0 {
+			diff = fmt.Sprintf("%+.0f%%", (p.Mirror.Cost/p.Real.Cost-1)*100)
+		}
+		fmt.Fprintln(tw, strings.Join([]string{p.Model, p.Shadow, format.Int(p.Requests),
+			format.Cost(p.Real.Cost),
+			format.Cost(p.Mirror.Cost) + " (" + diff + ")",
+			format.Int(p.Real.Errors) + " → " + format.Int(p.Mirror.Errors),
+			fmtLat(time.Duration(p.Real.LatencyMs)*time.Millisecond) + " → " + fmtLat(time.Duration(p.Mirror.LatencyMs)*time.Millisecond),
+			format.Tokens(p.Real.Output) + " → " + format.Tokens(p.Mirror.Output),
+		}, "\t"))
+	}
+	return tw.Flush()
+}
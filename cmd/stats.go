@@ -0,0 +1,248 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"miser/internal/format"
+	"miser/internal/tracker"
+)
+
+var (
+	statsSince string
+	statsOut   output
+)
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Print spend from the request history",
+	Long: `Reads the persisted request history and prints totals and breakdowns
+per model, per client tool and per day. The proxy does not need to be running.`,
+	Example: `  miser stats                 Everything in the history file
+  miser stats --since 7d      The last week
+  miser stats --since 2026-01-01 --json
+  miser stats --tsv | awk -F'\t' '$1 == "model"'`,
+	Args: cobra.NoArgs,
+	RunE: runStats,
+}
+
+func init() {
+	statsCmd.Flags().StringVar(&statsSince, "since", "",
+		"only include requests after this (24h, 7d, 2026-01-31, RFC 3339)")
+	addOutputFlags(statsCmd, &statsOut)
+	rootCmd.AddCommand(statsCmd)
+}
+
+type statsTotals struct {
+	Requests   int     `json:"requests"`
+	Errors     int     `json:"errors"`
+	Cancelled  int     `json:"cancelled,omitempty"` // by the client; not in errors
+	Partial    int     `json:"partial,omitempty"`   // cut short; not in errors
+	Retries    int     `json:"retries,omitempty"`   // failed attempts resent; not in errors
+	Input      int     `json:"input_tokens"`
+	Output     int     `json:"output_tokens"`
+	Tool       int     `json:"tool_tokens"` // of output, in tool call arguments
+	CacheRead  int     `json:"cache_read"`
+	CacheWrite int     `json:"cache_write"`
+	Cost       float64 `json:"cost"`
+	RetryCost  float64 `json:"retry_cost,omitempty"` // of the retried attempts, within cost
+
+	Upstream     int     `json:"upstream,omitempty"`      // counted by an upstream miser; in no other total
+	UpstreamCost float64 `json:"upstream_cost,omitempty"` // of those
+}
+
+type statsModel struct {
+	Model string `json:"model"`
+	statsTotals
+}
+
+type statsClient struct {
+	Client string `json:"client"` // "" when unknown
+	statsTotals
+}
+
+type statsDay struct {
+	Date string `json:"date"`
+	statsTotals
+}
+
+type statsOutput struct {
+	Since   *time.Time    `json:"since,omitempty"`
+	Summary statsTotals   `json:"summary"`
+	Models  []statsModel  `json:"models"`
+	Clients []statsClient `json:"clients"`
+	Days    []statsDay    `json:"days"`
+}
+
+func totalsOf(s tracker.Summary) statsTotals {
+	return statsTotals{
+		Requests:   s.TotalRequests,
+		Errors:     s.TotalErrors,
+		Cancelled:  s.TotalCancelled,
+		Partial:    s.TotalPartial,
+		Retries:    s.TotalRetries,
+		RetryCost:  s.RetryCost,
+		Input:      s.TotalInput,
+		Output:     s.TotalOutput,
+		Tool:       s.TotalTool,
+		CacheRead:  s.TotalCacheR,
+		CacheWrite: s.TotalCacheW,
+		Cost:       s.TotalCost,
+
+		Upstream:     s.UpstreamRequests,
+		UpstreamCost: s.UpstreamCost,
+	}
+}
+
+func runStats(cmd *cobra.Command, _ []string) error {
+	cfg, err := resolveConfig(cmd)
+	if err != nil {
+		return err
+	}
+	if err := applyFormat(cfg); err != nil {
+		return err
+	}
+	since, err := parseSince(statsSince, time.Now())
+	if err != nil {
+		return err
+	}
+	t, err := loadHistory(cfg)
+	if err != nil {
+		return err
+	}
+
+	out := statsOutput{Summary: totalsOf(t.GetSummarySince(since))}
+	if !since.IsZero() {
+		out.Since = &since
+	}
+	out.Models = []statsModel{}
+	for _, ms := range t.GetModelStatsSince(since) {
+		out.Models = append(out.Models, statsModel{Model: ms.Model, statsTotals: statsTotals{
+			Requests:   ms.Requests,
+			Errors:     ms.Errors,
+			Input:      ms.InputTokens,
+			Output:     ms.OutputTokens,
+			Tool:       ms.ToolTokens,
+			CacheRead:  ms.CacheRead,
+			CacheWrite: ms.CacheWrite,
+			Cost:       ms.TotalCost,
+		}})
+	}
+	out.Clients = []statsClient{}
+	for _, cs := range t.GetClientStatsSince(since) {
+		out.Clients = append(out.Clients, statsClient{Client: cs.Client, statsTotals: totalsOf(cs.Summary)})
+	}
+	out.Days = []statsDay{}
+	for _, d := range t.GetDailySince(since) {
+		out.Days = append(out.Days, statsDay{Date: d.Date.Format("2006-01-02"), statsTotals: totalsOf(d.Summary)})
+	}
+
+	switch {
+	case statsOut.json:
+		return writeJSON(os.Stdout, out)
+	case statsOut.tsv:
+		return writeStatsTSV(os.Stdout, out)
+	}
+	return printStats(os.Stdout, out)
+}
+
+// writeStatsTSV writes one row per scope: the total, each model, each day
+// and each client, told apart by the first column.
+func writeStatsTSV(w io.Writer, out statsOutput) error {
+	row := func(scope, label string, s statsTotals) []string {
+		return []string{scope, label,
+			strconv.Itoa(s.Requests), strconv.Itoa(s.Errors),
+			strconv.Itoa(s.Input), strconv.Itoa(s.Output),
+			strconv.Itoa(s.CacheRead), strconv.Itoa(s.CacheWrite),
+			tsvFloat(s.Cost), strconv.Itoa(s.Tool),
+		}
+	}
+	rows := [][]string{row("total", "", out.Summary)}
+	for _, m := range out.Models {
+		rows = append(rows, row("model", m.Model, m.statsTotals))
+	}
+	for _, d := range out.Days {
+		rows = append(rows, row("day", d.Date, d.statsTotals))
+	}
+	for _, c := range out.Clients {
+		rows = append(rows, row("client", c.Client, c.statsTotals))
+	}
+	return writeTSV(w, []string{"scope", "label", "requests", "errors", "input_tokens",
+		"output_tokens", "cache_read", "cache_write", "cost", "tool_tokens"}, rows)
+}
+
+func printStats(w io.Writer, out statsOutput) error {
+	s := out.Summary
+	scope := "all history"
+	if out.Since != nil {
+		scope = "since " + out.Since.Format("2006-01-02 15:04")
+	}
+	fmt.Fprintf(w, "Total (%s): %s over %s requests, %s errors", scope,
+		format.Cost(s.Cost), format.Int(s.Requests), format.Int(s.Errors))
+	if s.Cancelled > 0 || s.Partial > 0 {
+		fmt.Fprintf(w, " (%s cancelled, %s partial)", format.Int(s.Cancelled), format.Int(s.Partial))
+	}
+	fmt.Fprintln(w)
+	if s.Retries > 0 {
+		fmt.Fprintf(w, "Retries: %s failed attempts resent, costing %s\n", format.Int(s.Retries), format.Cost(s.RetryCost))
+	}
+	if s.Upstream > 0 {
+		fmt.Fprintf(w, "Upstream: %s requests counted by an upstream miser, costing %s, left out above\n", format.Int(s.Upstream), format.Cost(s.UpstreamCost))
+	}
+	fmt.Fprintf(w, "Tokens: %s in, %s out (%s in tool calls), %s cache read, %s cache write\n\n",
+		format.Tokens(s.Input), format.Tokens(s.Output), format.Tokens(s.Tool),
+		format.Tokens(s.CacheRead), format.Tokens(s.CacheWrite))
+
+	models := make([][]string, len(out.Models))
+	for i, m := range out.Models {
+		models[i] = statsRow(m.Model, m.statsTotals)
+	}
+	if err := writeTable(w, "MODEL", models); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+
+	clients := make([][]string, len(out.Clients))
+	for i, c := range out.Clients {
+		label := c.Client
+		if label == "" {
+			label = "(unknown)"
+		}
+		clients[i] = statsRow(label, c.statsTotals)
+	}
+	if err := writeTable(w, "CLIENT", clients); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+
+	days := make([][]string, len(out.Days))
+	for i, d := range out.Days {
+		days[i] = statsRow(d.Date, d.statsTotals)
+	}
+	return writeTable(w, "DAY", days)
+}
+
+func statsRow(label string, s statsTotals) []string {
+	return []string{label,
+		format.Int(s.Requests), format.Int(s.Errors),
+		format.Tokens(s.Input), format.Tokens(s.Output), format.Tokens(s.Tool),
+		format.Tokens(s.CacheRead), format.Tokens(s.CacheWrite),
+		format.Cost(s.Cost),
+	}
+}
+
+func writeTable(w io.Writer, label string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tREQS\tERRS\tINPUT\tOUTPUT\tTOOL\tCACHE R\tCACHE W\tCOST\n", label)
+	for _, r := range rows {
+		fmt.Fprintln(tw, strings.Join(r, "\t"))
+	}
+	return tw.Flush()
+}
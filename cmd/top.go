@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"miser/internal/api"
+	"miser/internal/budget"
+	"miser/internal/config"
+	"miser/internal/tracker"
+	"miser/internal/tui"
+)
+
+var topAddr string
+
+var topCmd = &cobra.Command{
+	Use:     "top",
+	Aliases: []string{"dash"},
+	Short:   "Attach the dashboard to a running miser proxy",
+	Long: `Connects to a miser instance that is already running (for example with
+--headless) and shows its live dashboard. Quitting the dashboard leaves the
+proxy running. Clearing only affects this view.`,
+	Example: `  miser --headless &          Start the proxy in the background
+  miser top                   Attach to it on the configured port
+  miser top --addr localhost:9090`,
+	Args: cobra.NoArgs,
+	RunE: runTop,
+}
+
+func init() {
+	topCmd.Flags().StringVar(&topAddr, "addr", "",
+		"address of the running proxy (default: localhost:<configured port>)")
+	rootCmd.AddCommand(topCmd)
+}
+
+func runTop(cmd *cobra.Command, _ []string) error {
+	cfg, err := resolveConfig(cmd)
+	if err != nil {
+		return err
+	}
+	addr := topAddr
+	if addr == "" {
+		addr = fmt.Sprintf("localhost:%d", cfg.Proxy.Port)
+	}
+	return attach(cfg, addr)
+}
+
+// attach runs the dashboard against the miser instance at addr,
+// authenticating with the configured API token if there is one.
+func attach(cfg config.Config, addr string) error {
+	applyPricing(cfg)
+	if err := applyFormat(cfg); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	t := tracker.New()
+	client := api.NewClient(addr)
+	client.Token = cfg.API.Token
+	remote, err := client.Mirror(ctx, t, nil)
+	if err != nil {
+		return fmt.Errorf("attach to %s: %w (is miser running?)", client.Base(), err)
+	}
+
+	opts := tui.Options{
+		ProxyAddr:   addr,
+		TargetAddr:  remote.Target,
+		SkipConfirm: !cfg.TUI.Confirm,
+		ExportDir:   cfg.Export.Dir,
+		Attached:    true,
+	}
+	if opts.SLO, err = sloObjectives(cfg); err != nil {
+		return err
+	}
+	if cfg.Budget.Amount > 0 {
+		bcfg, err := budgetConfig(cfg, nil)
+		if err != nil {
+			return err
+		}
+		opts.Budget = budget.New(bcfg, t)
+	}
+	return tui.New(t, opts).Run()
+}
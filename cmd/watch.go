@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var (
+	watchAddr  string
+	watchToken string
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Show the live dashboard of a remote miser proxy",
+	Long: `Streams request events from a miser instance on another machine (typically
+"miser serve --headless" on a server) and renders them in the local
+dashboard. Quitting leaves the remote proxy running; clearing only affects
+this view.
+
+Remote instances only serve their API to clients presenting the token set
+in their [api] token config (or $MISER_API_TOKEN). Without --token, the
+local config's token is used.`,
+	Example: `  miser watch --addr build-box:8080 --token s3cret
+  MISER_API_TOKEN=s3cret miser watch --addr https://miser.example.com`,
+	Args: cobra.NoArgs,
+	RunE: runWatch,
+}
+
+func init() {
+	watchCmd.Flags().StringVar(&watchAddr, "addr", "",
+		"address of the remote proxy, as host:port or URL (required)")
+	watchCmd.Flags().StringVar(&watchToken, "token", "",
+		"API token of the remote proxy (default: [api] token) [$MISER_API_TOKEN]")
+	watchCmd.MarkFlagRequired("addr")
+	rootCmd.AddCommand(watchCmd)
+}
+
+func runWatch(cmd *cobra.Command, _ []string) error {
+	cfg, err := resolveConfig(cmd)
+	if err != nil {
+		return err
+	}
+	if cmd.Flags().Changed("token") {
+		cfg.API.Token = watchToken
+	}
+	return attach(cfg, watchAddr)
+}
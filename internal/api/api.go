@@ -0,0 +1,174 @@
+// Package api defines miser's own HTTP endpoints, served next to the proxy
+// under Prefix, and a small client for them.
+package api
+
+import (
+	"time"
+
+	"miser/internal/tracker"
+)
+
+// Prefix is reserved for miser's endpoints; every other path is proxied.
+// Unknown paths under it are answered with 404, never forwarded.
+const Prefix = "/miser/api/"
+
+// StatusPath reports that a miser instance is serving and what it proxies.
+const StatusPath = Prefix + "status"
+
+// Status is the body of a StatusPath response.
+type Status struct {
+	Version      string    `json:"version"`
+	Target       string    `json:"target"`
+	Started      time.Time `json:"started"`
+	SessionStart time.Time `json:"session_start"`
+	Requests     int       `json:"requests"`
+	Aggregating  bool      `json:"aggregating,omitempty"` // accepts cluster nodes on IngestPath
+}
+
+// RequestsPath returns tracked requests, oldest first. The optional
+// "after" query parameter returns only requests with a larger ID, so a
+// client can poll incrementally; "since" (see SummaryPath) returns only
+// requests that started at or after a time.
+const RequestsPath = Prefix + "requests"
+
+// PprofPath serves the net/http/pprof profiles, e.g. PprofPath+"heap",
+// when the proxy runs with [api] pprof enabled.
+const PprofPath = Prefix + "debug/pprof/"
+
+// SummaryPath returns a Summary of the requests since the "since" query
+// parameter: an RFC 3339 time, a duration such as "1h" meaning that long
+// ago, "session" (the default) or "all".
+const SummaryPath = Prefix + "summary"
+
+// ModelsPath returns per-model Models totals, most expensive first. It
+// takes "since" like SummaryPath.
+const ModelsPath = Prefix + "models"
+
+// Summary is the body of a SummaryPath response.
+type Summary struct {
+	Since          time.Time `json:"since"` // zero for "all"
+	Requests       int       `json:"requests"`
+	Errors         int       `json:"errors"`
+	Cancelled      int       `json:"cancelled"` // by the client; not in Errors
+	Partial        int       `json:"partial"`   // cut short; not in Errors
+	Retries        int       `json:"retries"`   // failed attempts resent; not in Errors
+	InputTokens    int       `json:"input_tokens"`
+	OutputTokens   int       `json:"output_tokens"`
+	CacheRead      int       `json:"cache_read"`
+	CacheWrite     int       `json:"cache_write"`
+	Cost           float64   `json:"cost"`
+	RetryCost      float64   `json:"retry_cost"`    // of the retried attempts, within Cost
+	OriginalSize   int       `json:"original_size"` // request bytes before compression
+	CompressedSize int       `json:"compressed_size"`
+	Budget         *Budget   `json:"budget,omitempty"` // when a limit is set
+}
+
+// Models is the body of a ModelsPath response.
+type Models struct {
+	Since  time.Time    `json:"since"`
+	Models []ModelStats `json:"models"`
+}
+
+// ModelStats is one model's totals. Latencies are in milliseconds.
+type ModelStats struct {
+	Model          string  `json:"model"`
+	Requests       int     `json:"requests"`
+	Errors         int     `json:"errors"`
+	InputTokens    int     `json:"input_tokens"`
+	OutputTokens   int     `json:"output_tokens"`
+	ToolTokens     int     `json:"tool_tokens"` // of OutputTokens, in tool call arguments
+	CacheRead      int     `json:"cache_read"`
+	CacheWrite     int     `json:"cache_write"`
+	Cost           float64 `json:"cost"`
+	CacheSavings   float64 `json:"cache_savings"`
+	CacheHitRate   float64 `json:"cache_hit_rate"` // percent of prompt tokens
+	AvgLatencyMs   int64   `json:"avg_latency_ms"`
+	P95LatencyMs   int64   `json:"p95_latency_ms"`
+	MaxLatencyMs   int64   `json:"max_latency_ms"`
+	OriginalSize   int     `json:"original_size"`
+	CompressedSize int     `json:"compressed_size"`
+}
+
+// EventsPath streams requests as server-sent events. Like RequestsPath it
+// takes an "after" ID; it sends the backlog after it, then new requests
+// as they are recorded. A WebSocket upgrade gets the same events as text
+// messages of the form {"event": name, "data": ...}.
+const EventsPath = Prefix + "events"
+
+// Event names on EventsPath. EventRequest data is a tracker.Request;
+// EventSession data is a Session, sent on connect and whenever the remote
+// session is cleared.
+const (
+	EventRequest = "request"
+	EventSession = "session"
+)
+
+// Session is the data of an EventSession event.
+type Session struct {
+	SessionStart time.Time `json:"session_start"`
+}
+
+// IngestPath (POST) takes an Ingest from a cluster node: an aggregator
+// records the requests as that node's and answers with an IngestResult.
+// Instances that don't aggregate answer 404.
+const IngestPath = Prefix + "cluster/ingest"
+
+// Ingest is the body of an IngestPath request. Run changes each time the
+// node starts; within a run, requests with an ID the aggregator already
+// has are skipped, so a node can resend a batch it isn't sure arrived.
+type Ingest struct {
+	Node     string            `json:"node"`
+	Run      string            `json:"run"`
+	Requests []tracker.Request `json:"requests"`
+}
+
+// IngestResult is the body of an IngestPath response.
+type IngestResult struct {
+	Recorded int `json:"recorded"` // requests new to the aggregator
+	Last     int `json:"last"`     // the highest of the run's IDs it has
+}
+
+// Requests is the body of a RequestsPath response.
+type Requests struct {
+	Target       string            `json:"target"`
+	SessionStart time.Time         `json:"session_start"`
+	Requests     []tracker.Request `json:"requests"`
+}
+
+// Admin endpoints change a running instance. They take POST, except
+// ExportPath, and are authorized like the rest of the API.
+const (
+	// ClearPath starts a new session now, like <c> in the dashboard.
+	ClearPath = Prefix + "admin/clear"
+
+	// SessionPath moves the session start to the time in a Session body,
+	// e.g. back to the start of the day.
+	SessionPath = Prefix + "admin/session"
+
+	// BudgetPath sets the spend limit from a BudgetUpdate body and returns
+	// a Budget.
+	BudgetPath = Prefix + "admin/budget"
+
+	// PricingReloadPath re-reads pricing from the instance's config file.
+	PricingReloadPath = Prefix + "admin/pricing/reload"
+
+	// ExportPath (GET) returns the session's requests in the export format
+	// named by the "format" query parameter; "all=1" includes the whole
+	// history.
+	ExportPath = Prefix + "admin/export"
+)
+
+// BudgetUpdate is the body of a BudgetPath request. A zero Amount
+// disables the budget.
+type BudgetUpdate struct {
+	Amount float64 `json:"amount"`
+	Reset  bool    `json:"reset"` // also zero spend and restart the window now
+}
+
+// Budget is the body of a BudgetPath response.
+type Budget struct {
+	Limit float64   `json:"limit"`
+	Spent float64   `json:"spent"`
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until,omitzero"` // when spend next drops
+}
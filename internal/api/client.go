@@ -0,0 +1,303 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"miser/internal/tracker"
+)
+
+// Client talks to a running miser instance.
+type Client struct {
+	// Token is sent as a bearer token; remote (non-loopback) instances
+	// only answer clients that present their configured API token.
+	Token string
+	// UserAgent, if set, names the client to the instance, which records
+	// it with admin actions.
+	UserAgent string
+
+	base   string
+	http   *http.Client
+	stream *http.Client // no overall timeout, for EventsPath
+}
+
+// NewClient returns a client for the instance at addr, which may be a
+// host:port or a full http(s) URL.
+func NewClient(addr string) *Client {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return &Client{
+		base:   strings.TrimRight(addr, "/"),
+		http:   &http.Client{Timeout: 10 * time.Second},
+		stream: &http.Client{},
+	}
+}
+
+// Base returns the instance URL.
+func (c *Client) Base() string { return c.base }
+
+// Status fetches the instance's status. It fails fast, so it doubles as a
+// check for whether an instance is running at all.
+func (c *Client) Status(ctx context.Context) (Status, error) {
+	var out Status
+	err := c.get(ctx, StatusPath, &out)
+	return out, err
+}
+
+// Requests fetches requests with an ID greater than after.
+func (c *Client) Requests(ctx context.Context, after int) (Requests, error) {
+	var out Requests
+	err := c.get(ctx, RequestsPath+"?after="+url.QueryEscape(strconv.Itoa(after)), &out)
+	return out, err
+}
+
+// Summary fetches totals since the given "since" value (see SummaryPath);
+// "" means the current session.
+func (c *Client) Summary(ctx context.Context, since string) (Summary, error) {
+	var out Summary
+	err := c.get(ctx, SummaryPath+sinceQuery(since), &out)
+	return out, err
+}
+
+// Models fetches per-model totals since the given "since" value.
+func (c *Client) Models(ctx context.Context, since string) (Models, error) {
+	var out Models
+	err := c.get(ctx, ModelsPath+sinceQuery(since), &out)
+	return out, err
+}
+
+func sinceQuery(since string) string {
+	if since == "" {
+		return ""
+	}
+	return "?since=" + url.QueryEscape(since)
+}
+
+// Clear starts a new session on the instance.
+func (c *Client) Clear(ctx context.Context) (Session, error) {
+	var out Session
+	err := c.post(ctx, ClearPath, nil, &out)
+	return out, err
+}
+
+// SetSessionStart moves the instance's session start to start.
+func (c *Client) SetSessionStart(ctx context.Context, start time.Time) error {
+	return c.post(ctx, SessionPath, Session{SessionStart: start}, nil)
+}
+
+// SetBudget changes the instance's spend limit.
+func (c *Client) SetBudget(ctx context.Context, u BudgetUpdate) (Budget, error) {
+	var out Budget
+	err := c.post(ctx, BudgetPath, u, &out)
+	return out, err
+}
+
+// ReloadPricing makes the instance re-read pricing from its config file.
+func (c *Client) ReloadPricing(ctx context.Context) error {
+	return c.post(ctx, PricingReloadPath, nil, nil)
+}
+
+// Push sends a cluster node's requests to an aggregator.
+func (c *Client) Push(ctx context.Context, in Ingest) (IngestResult, error) {
+	var out IngestResult
+	err := c.post(ctx, IngestPath, in, &out)
+	return out, err
+}
+
+// Export writes the instance's session requests (or, with all, its whole
+// history) to w in the named export format.
+func (c *Client) Export(ctx context.Context, w io.Writer, format string, all bool) error {
+	q := url.Values{"format": {format}}
+	if all {
+		q.Set("all", "1")
+	}
+	resp, err := c.do(ctx, c.http, http.MethodGet, ExportPath+"?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func (c *Client) get(ctx context.Context, path string, out any) error {
+	resp, err := c.do(ctx, c.http, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// post sends in (if not nil) as JSON and decodes the response into out
+// (if not nil).
+func (c *Client) post(ctx context.Context, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	resp, err := c.do(ctx, c.http, http.MethodPost, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) do(ctx context.Context, hc *http.Client, method, path string, body io.Reader) (*http.Response, error) {
+	u := c.base + path
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil || method == http.MethodPost {
+		req.Header.Set("Content-Type", "application/json") // the API refuses other POSTs
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		if m := strings.TrimSpace(string(msg)); m != "" {
+			return nil, fmt.Errorf("%s: %s: %s", u, resp.Status, m)
+		}
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	return resp, nil
+}
+
+// Events streams the instance's requests with an ID greater than after,
+// calling onRequest for each and onSession whenever the remote session
+// start is announced. It blocks until the stream ends, ctx is done or a
+// callback fails.
+func (c *Client) Events(ctx context.Context, after int, onSession func(Session), onRequest func(tracker.Request)) error {
+	resp, err := c.do(ctx, c.stream, http.MethodGet, EventsPath+"?after="+url.QueryEscape(strconv.Itoa(after)), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 64*1024), maxEventSize)
+	var event string
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data := []byte(strings.TrimPrefix(line, "data: "))
+			switch event {
+			case EventSession:
+				var s Session
+				if err := json.Unmarshal(data, &s); err != nil {
+					return fmt.Errorf("decoding %s event: %w", event, err)
+				}
+				onSession(s)
+			case EventRequest:
+				var r tracker.Request
+				if err := json.Unmarshal(data, &r); err != nil {
+					return fmt.Errorf("decoding %s event: %w", event, err)
+				}
+				onRequest(r)
+			}
+		case line == "":
+			event = ""
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// maxEventSize bounds one event line; requests with captured previews are
+// well under it.
+const maxEventSize = 4 << 20
+
+// Mirror backoff bounds between stream reconnects.
+const (
+	minReconnect = 500 * time.Millisecond
+	maxReconnect = 10 * time.Second
+)
+
+// Mirror copies the remote instance's requests into t: everything it has
+// now via Load, then new requests via Record as the instance streams them,
+// until ctx is done. The initial fetch must succeed; later stream failures
+// are passed to onErr (if set) and the stream is reopened after a backoff,
+// resuming from the last mirrored request.
+func (c *Client) Mirror(ctx context.Context, t *tracker.Tracker, onErr func(error)) (Requests, error) {
+	first, err := c.Requests(ctx, 0)
+	if err != nil {
+		return first, err
+	}
+	t.Load(first.Requests)
+	t.SetSessionStart(first.SessionStart)
+
+	last := 0
+	if n := len(first.Requests); n > 0 {
+		last = first.Requests[n-1].ID
+	}
+
+	go func() {
+		wait := minReconnect
+		for {
+			start := time.Now()
+			err := c.Events(ctx, last,
+				func(s Session) {
+					if !s.SessionStart.Equal(t.SessionStart()) {
+						t.SetSessionStart(s.SessionStart)
+					}
+				},
+				func(r tracker.Request) {
+					if r.ID > last {
+						t.Record(r)
+						last = r.ID
+					}
+				})
+			if ctx.Err() != nil {
+				return
+			}
+			if onErr != nil {
+				onErr(err)
+			}
+			// A stream that stayed up for a while was healthy; start the
+			// backoff over rather than punishing a later blip.
+			if time.Since(start) > maxReconnect {
+				wait = minReconnect
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			wait = min(wait*2, maxReconnect)
+		}
+	}()
+	return first, nil
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"miser/internal/tracker"
+)
+
+func TestMirror(t *testing.T) {
+	remote := tracker.New()
+	remote.Record(tracker.Request{Model: "a", Cost: 1})
+
+	live := make(chan tracker.Request, 1)
+	remote.Subscribe(func(r tracker.Request) { live <- r })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(RequestsPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		after, _ := strconv.Atoi(r.URL.Query().Get("after"))
+		json.NewEncoder(w).Encode(Requests{
+			Target:       "https://example.test",
+			SessionStart: remote.SessionStart(),
+			Requests:     remote.GetRequestsAfter(after),
+		})
+	})
+	mux.HandleFunc(EventsPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("after") != "1" {
+			t.Errorf("events after = %q, want 1", r.URL.Query().Get("after"))
+		}
+		fmt.Fprintf(w, ": ping\n\nevent: %s\ndata: {\"session_start\":%q}\n\n",
+			EventSession, remote.SessionStart().Format(time.RFC3339Nano))
+		w.(http.Flusher).Flush()
+		select {
+		case req := <-live:
+			data, _ := json.Marshal(req)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", EventRequest, data)
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+		}
+		<-r.Context().Done()
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local := tracker.New()
+	var mu sync.Mutex
+	var seen []tracker.Request
+	local.Subscribe(func(r tracker.Request) {
+		mu.Lock()
+		seen = append(seen, r)
+		mu.Unlock()
+	})
+
+	client := NewClient(srv.URL)
+	if _, err := client.Mirror(ctx, local, nil); err == nil {
+		t.Fatal("mirror without token succeeded")
+	}
+	client.Token = "tok"
+	first, err := client.Mirror(ctx, local, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Target != "https://example.test" || len(local.GetRequests()) != 1 {
+		t.Fatalf("initial mirror: target %q, %d requests", first.Target, len(local.GetRequests()))
+	}
+	if !local.SessionStart().Equal(remote.SessionStart()) {
+		t.Error("session start not mirrored")
+	}
+
+	remote.Record(tracker.Request{Model: "b", Cost: 2})
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(seen)
+		mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("new request was not mirrored")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := local.GetRequests(); len(got) != 2 || got[1].ID != 2 || got[1].Model != "b" {
+		t.Fatalf("local requests = %+v", got)
+	}
+}
+
+func TestSetBudget(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != BudgetPath {
+			t.Errorf("got %s %s", r.Method, r.URL.Path)
+		}
+		var u BudgetUpdate
+		json.NewDecoder(r.Body).Decode(&u)
+		if u.Amount < 0 {
+			http.Error(w, "budget amount must not be negative", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(Budget{Limit: u.Amount, Spent: 1})
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL)
+	b, err := c.SetBudget(context.Background(), BudgetUpdate{Amount: 25})
+	if err != nil || b.Limit != 25 || b.Spent != 1 {
+		t.Fatalf("SetBudget = %+v, %v", b, err)
+	}
+	_, err = c.SetBudget(context.Background(), BudgetUpdate{Amount: -1})
+	if err == nil || !strings.Contains(err.Error(), "must not be negative") {
+		t.Errorf("error = %v, want the server's message", err)
+	}
+}
@@ -0,0 +1,131 @@
+// Package audit keeps an append-only JSONL record of administrative
+// actions (clearing the session, budget changes, pricing reloads, …) so a
+// shared proxy can answer "who did that, and when".
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Actions.
+const (
+	Clear         = "clear"          // the session was cleared
+	Session       = "session"        // the session start was moved
+	Budget        = "budget"         // the limit was changed or spend reset
+	PricingReload = "pricing_reload" // pricing was re-read from the config
+	Export        = "export"         // requests were exported
+	KeyCreate     = "key_create"     // a virtual key was issued
+	KeyRevoke     = "key_revoke"     // a virtual key was revoked
+	KeyLimits     = "key_limits"     // a virtual key's budget or rate changed
+	Upstream      = "upstream"       // the active upstream endpoint was switched
+)
+
+// Entry is one action.
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Source string    `json:"source"` // e.g. "dashboard" or "api 10.0.0.7 (token) curl/8.5.0"
+	Detail string    `json:"detail,omitempty"`
+	Error  string    `json:"error,omitempty"` // set when the action failed
+}
+
+// Log appends entries to a file. A nil *Log records nothing.
+type Log struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+// DefaultPath returns $XDG_DATA_HOME/miser/audit.jsonl, falling back to
+// ~/.local/share/miser/audit.jsonl.
+func DefaultPath() string {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "miser-audit.jsonl"
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "miser", "audit.jsonl")
+}
+
+// Open opens (creating if necessary) the audit log at path for appending.
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("creating audit log directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log %s: %w", path, err)
+	}
+	return &Log{path: path, f: f}, nil
+}
+
+// Path returns the audit log location.
+func (l *Log) Path() string {
+	return l.path
+}
+
+// Record writes e as a single line, stamping it with the current time if
+// it has none.
+func (l *Log) Record(e Entry) error {
+	if l == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	// Entries are rare and should survive a crash right after the action.
+	return l.f.Sync()
+}
+
+// Close closes the file.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// Load reads every entry at path, oldest first. A missing file is an
+// empty log; malformed lines are skipped.
+func Load(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []Entry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		var e Entry
+		if json.Unmarshal(sc.Bytes(), &e) == nil && e.Action != "" {
+			entries = append(entries, e)
+		}
+	}
+	return entries, sc.Err()
+}
@@ -0,0 +1,39 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "audit.jsonl")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Record(Entry{Action: Clear, Source: "dashboard"})
+	l.Record(Entry{Action: PricingReload, Source: "api 127.0.0.1", Error: "bad toml"})
+	l.Close()
+
+	// A torn last line from a crash is skipped.
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(`{"time":"2026-`)
+	f.Close()
+
+	got, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Action != Clear || got[0].Time.IsZero() || got[1].Error != "bad toml" {
+		t.Fatalf("entries = %+v", got)
+	}
+
+	var none *Log
+	if err := none.Record(Entry{Action: Clear}); err != nil {
+		t.Errorf("nil log: %v", err)
+	}
+	if got, err := Load(filepath.Join(t.TempDir(), "missing.jsonl")); err != nil || len(got) != 0 {
+		t.Errorf("missing file = %v, %v", got, err)
+	}
+}
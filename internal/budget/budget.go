@@ -0,0 +1,350 @@
+// Package budget tracks spend against a configured limit.
+package budget
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"miser/internal/tracker"
+)
+
+// Period is the window a budget limit applies to.
+type Period string
+
+const (
+	Session Period = "session" // since the session started or the budget was reset
+	Day     Period = "day"     // since local midnight
+	Week    Period = "week"    // since Monday, local midnight
+	Month   Period = "month"   // since the 1st of the month, local midnight
+	Rolling Period = "rolling" // the last 24 hours
+)
+
+// rollingWindow is how far back a Rolling budget counts.
+const rollingWindow = 24 * time.Hour
+
+// ParsePeriod accepts a period name case-insensitively; "" means Session.
+func ParsePeriod(s string) (Period, error) {
+	switch p := Period(strings.ToLower(s)); p {
+	case "":
+		return Session, nil
+	case Session, Day, Week, Month, Rolling:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown budget period %q (want session, day, week, month or rolling)", s)
+}
+
+// start returns the beginning of the calendar window containing now, or
+// the zero time for Session and Rolling.
+func (p Period) start(now time.Time) time.Time {
+	y, m, d := now.Date()
+	switch p {
+	case Day:
+		return time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	case Week:
+		offset := (int(now.Weekday()) + 6) % 7 // days since Monday
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, now.Location())
+	case Month:
+		return time.Date(y, m, 1, 0, 0, 0, 0, now.Location())
+	}
+	return time.Time{}
+}
+
+// end returns when the window starting at start rolls over.
+func (p Period) end(start time.Time) time.Time {
+	switch p {
+	case Day:
+		return start.AddDate(0, 0, 1)
+	case Week:
+		return start.AddDate(0, 0, 7)
+	case Month:
+		return start.AddDate(0, 1, 0)
+	}
+	return time.Time{}
+}
+
+// Label describes the window for display, e.g. "today".
+func (p Period) Label() string {
+	switch p {
+	case Day:
+		return "today"
+	case Week:
+		return "this week"
+	case Month:
+		return "this month"
+	case Rolling:
+		return "in the last 24 hours"
+	}
+	return "this session"
+}
+
+// Action is what happens once spend reaches the limit.
+type Action string
+
+const (
+	Warn  Action = "warn"  // report it and keep proxying
+	Block Action = "block" // refuse model requests until the window rolls over or the budget changes
+)
+
+// ParseAction accepts an action name case-insensitively; "" means Warn.
+func ParseAction(s string) (Action, error) {
+	switch a := Action(strings.ToLower(s)); a {
+	case "":
+		return Warn, nil
+	case Warn, Block:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown budget action %q (want warn or block)", s)
+}
+
+// Config describes a budget.
+type Config struct {
+	Limit  float64 // dollars; zero disables the budget
+	Period Period
+	Action Action
+
+	// OnExceeded, if set, is called once per window when spend first
+	// reaches the limit.
+	OnExceeded func(Status)
+
+	// Match, if set, limits the budget to the requests it accepts, e.g.
+	// those of one virtual key.
+	Match func(tracker.Request) bool
+}
+
+// Budget keeps a running total of cost recorded since the budget window
+// started. It is safe for concurrent use.
+type Budget struct {
+	mu         sync.RWMutex
+	limit      float64
+	period     Period
+	action     Action
+	onExceeded func(Status)
+	match      func(tracker.Request) bool
+	stop       func()
+
+	spent    float64
+	since    time.Time
+	until    time.Time // window rollover; zero for Session and Rolling
+	recent   []spend   // Rolling: the spend in the window, oldest first
+	exceeded bool      // OnExceeded already ran for this window
+}
+
+// spend is the cost of one request counted by a Rolling budget.
+type spend struct {
+	at   time.Time
+	cost float64
+}
+
+// Status is a point-in-time snapshot of a budget.
+type Status struct {
+	Limit  float64
+	Spent  float64
+	Since  time.Time
+	Period Period
+	Action Action
+
+	// Until is when spend next drops: the window's rollover, or for Rolling
+	// when the oldest spend in it ages out. It is zero for Session, and for
+	// a Rolling budget with nothing spent.
+	Until time.Time
+}
+
+// Fraction returns spend as a fraction of the limit (may exceed 1).
+func (s Status) Fraction() float64 {
+	if s.Limit <= 0 {
+		return 0
+	}
+	return s.Spent / s.Limit
+}
+
+// Remaining returns how much of the limit is left (never negative).
+func (s Status) Remaining() float64 {
+	return max(s.Limit-s.Spent, 0)
+}
+
+// Exceeded reports whether a limit is set and spend has reached it.
+func (s Status) Exceeded() bool {
+	return s.Limit > 0 && s.Spent >= s.Limit
+}
+
+// New creates a budget whose window starts at the tracker's current
+// session (or, for calendar periods, the current day, week or month, and
+// for Rolling 24 hours ago), and keeps it up to date as requests are
+// recorded. Zero Period and Action
+// mean Session and Warn.
+func New(cfg Config, t *tracker.Tracker) *Budget {
+	if cfg.Period == "" {
+		cfg.Period = Session
+	}
+	if cfg.Action == "" {
+		cfg.Action = Warn
+	}
+	b := &Budget{
+		limit:      cfg.Limit,
+		period:     cfg.Period,
+		action:     cfg.Action,
+		onExceeded: cfg.OnExceeded,
+		match:      cfg.Match,
+		since:      t.SessionStart(),
+	}
+	switch cfg.Period {
+	case Session:
+	case Rolling:
+		b.since = time.Now().Add(-rollingWindow)
+	default:
+		b.since = cfg.Period.start(time.Now())
+		b.until = cfg.Period.end(b.since)
+	}
+	if b.match == nil && cfg.Period != Rolling {
+		b.spent = t.GetSummarySince(b.since).TotalCost
+	} else {
+		for _, r := range t.GetRequestsSince(b.since) {
+			if b.match == nil || b.match(r) {
+				b.count(r)
+			}
+		}
+	}
+	b.exceeded = b.status().Exceeded()
+	b.stop = t.Subscribe(b.add)
+	return b
+}
+
+// Close stops the budget following the tracker. Only budgets that are
+// replaced while the tracker lives on need it.
+func (b *Budget) Close() {
+	b.stop()
+}
+
+func (b *Budget) add(r tracker.Request) {
+	if b.match != nil && !b.match(r) {
+		return
+	}
+	b.mu.Lock()
+	b.rollover(time.Now())
+	if !r.Timestamp.Before(b.since) {
+		b.count(r)
+	}
+	notify := b.checkExceeded()
+	st := b.status()
+	b.mu.Unlock()
+
+	if notify {
+		b.onExceeded(st)
+	}
+}
+
+// count adds the cost of r, a request in the window, unless an upstream
+// miser counted it. b.mu must be held for writing, or b not yet shared.
+func (b *Budget) count(r tracker.Request) {
+	if r.CountedUpstream() {
+		return
+	}
+	b.spent += r.Cost
+	if b.period == Rolling {
+		i, _ := slices.BinarySearchFunc(b.recent, r.Timestamp, func(s spend, t time.Time) int {
+			return s.at.Compare(t)
+		})
+		b.recent = slices.Insert(b.recent, i, spend{r.Timestamp, r.Cost})
+	}
+}
+
+// rollover starts a new calendar window once the current one has ended,
+// and slides a Rolling one to end at now. b.mu must be held for writing.
+func (b *Budget) rollover(now time.Time) {
+	if b.period == Rolling {
+		b.slide(now)
+		return
+	}
+	if b.until.IsZero() || now.Before(b.until) {
+		return
+	}
+	b.since = b.period.start(now)
+	b.until = b.period.end(b.since)
+	b.spent = 0
+	b.exceeded = false
+}
+
+// slide drops the spend that is more than a day old from a Rolling
+// budget. Its window never starts before the budget was last reset.
+func (b *Budget) slide(now time.Time) {
+	if cutoff := now.Add(-rollingWindow); cutoff.After(b.since) {
+		b.since = cutoff
+	}
+	i := 0
+	for i < len(b.recent) && b.recent[i].at.Before(b.since) {
+		i++
+	}
+	if i == 0 {
+		return
+	}
+	b.recent = b.recent[i:]
+	b.spent = 0 // summed afresh, so rounding doesn't accumulate
+	for _, s := range b.recent {
+		b.spent += s.cost
+	}
+	if !b.status().Exceeded() {
+		b.exceeded = false
+	}
+}
+
+// checkExceeded reports whether OnExceeded should run now. b.mu must be
+// held for writing.
+func (b *Budget) checkExceeded() bool {
+	if !b.status().Exceeded() {
+		b.exceeded = false
+		return false
+	}
+	if b.exceeded {
+		return false
+	}
+	b.exceeded = true
+	return b.onExceeded != nil
+}
+
+func (b *Budget) status() Status {
+	st := Status{Limit: b.limit, Spent: b.spent, Since: b.since, Period: b.period, Action: b.action, Until: b.until}
+	if len(b.recent) > 0 {
+		st.Until = b.recent[0].at.Add(rollingWindow)
+	}
+	return st
+}
+
+func (b *Budget) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover(time.Now())
+	return b.status()
+}
+
+// Blocking reports whether model requests should be refused: the action
+// is Block and the limit has been reached.
+func (b *Budget) Blocking() bool {
+	st := b.Status()
+	return st.Action == Block && st.Exceeded()
+}
+
+// SetLimit changes the limit, keeping spend and the window. A zero limit
+// disables the budget.
+func (b *Budget) SetLimit(limit float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.limit = limit
+	if !b.status().Exceeded() {
+		b.exceeded = false
+	}
+}
+
+// Reset zeroes spend and starts a new budget window now. Calendar windows
+// still roll over at their usual boundary; a Rolling one counts from now
+// until it is 24 hours long again.
+func (b *Budget) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.spent = 0
+	b.recent = nil
+	b.since = time.Now()
+	b.exceeded = false
+}
@@ -0,0 +1,170 @@
+package budget
+
+import (
+	"testing"
+	"time"
+
+	"miser/internal/tracker"
+)
+
+func TestBudgetTracksSpend(t *testing.T) {
+	tr := tracker.New()
+	tr.Load([]tracker.Request{{Timestamp: time.Now().Add(-time.Hour), Cost: 10}})
+	tr.Record(tracker.Request{Timestamp: time.Now(), Cost: 1})
+
+	b := New(Config{Limit: 4}, tr)
+	if got := b.Status().Spent; got != 1 {
+		t.Fatalf("initial spent = %v, want 1 (history excluded)", got)
+	}
+
+	tr.Record(tracker.Request{Timestamp: time.Now(), Cost: 2})
+	s := b.Status()
+	if s.Spent != 3 || s.Remaining() != 1 || s.Fraction() != 0.75 {
+		t.Fatalf("status = %+v, fraction %v", s, s.Fraction())
+	}
+
+	b.Reset()
+	if got := b.Status().Spent; got != 0 {
+		t.Fatalf("spent after reset = %v", got)
+	}
+	tr.Record(tracker.Request{Timestamp: time.Now(), Cost: 5})
+	s = b.Status()
+	if s.Spent != 5 || s.Remaining() != 0 {
+		t.Fatalf("status after overspend = %+v", s)
+	}
+}
+
+func TestBudgetBlocksOnce(t *testing.T) {
+	tr := tracker.New()
+	var notified int
+	b := New(Config{Limit: 2, Action: Block, OnExceeded: func(Status) { notified++ }}, tr)
+
+	tr.Record(tracker.Request{Timestamp: time.Now(), Cost: 1})
+	if b.Blocking() || notified != 0 {
+		t.Fatalf("blocking under the limit (notified %d)", notified)
+	}
+	tr.Record(tracker.Request{Timestamp: time.Now(), Cost: 1})
+	tr.Record(tracker.Request{Timestamp: time.Now(), Cost: 1})
+	if !b.Blocking() || notified != 1 {
+		t.Fatalf("Blocking() = %v, notified %d; want true, 1", b.Blocking(), notified)
+	}
+
+	b.SetLimit(10)
+	if b.Blocking() {
+		t.Fatal("still blocking after raising the limit")
+	}
+}
+
+func TestPeriodStart(t *testing.T) {
+	loc := time.FixedZone("test", 2*60*60)
+	now := time.Date(2026, 1, 29, 15, 4, 5, 0, loc) // a Thursday
+	for _, tc := range []struct {
+		p    Period
+		want time.Time
+	}{
+		{Day, time.Date(2026, 1, 29, 0, 0, 0, 0, loc)},
+		{Week, time.Date(2026, 1, 26, 0, 0, 0, 0, loc)},
+		{Month, time.Date(2026, 1, 1, 0, 0, 0, 0, loc)},
+	} {
+		if got := tc.p.start(now); !got.Equal(tc.want) {
+			t.Errorf("%s start = %v, want %v", tc.p, got, tc.want)
+		}
+	}
+	if got := Week.start(time.Date(2026, 2, 1, 9, 0, 0, 0, loc)); got.Day() != 26 {
+		t.Errorf("week start for a Sunday = %v, want Monday the 26th", got)
+	}
+}
+
+func TestDayBudgetIncludesEarlierHistory(t *testing.T) {
+	tr := tracker.New()
+	now := time.Now()
+	midnight := Day.start(now)
+	tr.Load([]tracker.Request{
+		{Timestamp: midnight.Add(-time.Minute), Cost: 7},
+		{Timestamp: midnight.Add(time.Second), Cost: 3},
+	})
+	if got := New(Config{Limit: 5, Period: Day}, tr).Status().Spent; got != 3 {
+		t.Errorf("day budget spent = %v, want 3", got)
+	}
+}
+
+func TestBudgetSkipsCountedUpstream(t *testing.T) {
+	tr := tracker.New()
+	tr.Record(tracker.Request{Timestamp: time.Now(), Cost: 1})
+	tr.Record(tracker.Request{Timestamp: time.Now(), Cost: 3, CountedBy: "gateway:8080-1a2b3c4d"})
+	for _, p := range []Period{Day, Rolling} {
+		b := New(Config{Limit: 5, Period: p}, tr)
+		tr.Record(tracker.Request{Timestamp: time.Now(), Cost: 3, CountedBy: "gateway:8080-1a2b3c4d"})
+		if got := b.Status().Spent; got != 1 {
+			t.Errorf("%v budget spent = %v, want 1", p, got)
+		}
+		b.Close()
+	}
+}
+
+func TestBudgetMatch(t *testing.T) {
+	tr := tracker.New()
+	tr.Record(tracker.Request{Timestamp: time.Now(), Cost: 1, Key: "alice"})
+	tr.Record(tracker.Request{Timestamp: time.Now(), Cost: 5, Key: "bob"})
+
+	b := New(Config{Limit: 2, Period: Day, Action: Block, Match: func(r tracker.Request) bool { return r.Key == "alice" }}, tr)
+	tr.Record(tracker.Request{Timestamp: time.Now(), Cost: 5})
+	if st := b.Status(); st.Spent != 1 || b.Blocking() {
+		t.Fatalf("status = %+v; want only alice's $1", st)
+	}
+	tr.Record(tracker.Request{Timestamp: time.Now(), Cost: 1, Key: "alice"})
+	if !b.Blocking() {
+		t.Fatalf("not blocking at %+v", b.Status())
+	}
+
+	b.Close()
+	tr.Record(tracker.Request{Timestamp: time.Now(), Cost: 1, Key: "alice"})
+	if got := b.Status().Spent; got != 2 {
+		t.Errorf("spent after Close = %v, want 2", got)
+	}
+}
+
+func TestRollingBudget(t *testing.T) {
+	tr := tracker.New()
+	now := time.Now()
+	tr.Load([]tracker.Request{
+		{Timestamp: now.Add(-25 * time.Hour), Cost: 7},
+		{Timestamp: now.Add(-23 * time.Hour), Cost: 3},
+		{Timestamp: now.Add(-time.Hour), Cost: 1},
+	})
+	b := New(Config{Limit: 4, Period: Rolling, Action: Block}, tr)
+	st := b.Status()
+	if st.Spent != 4 || !b.Blocking() {
+		t.Fatalf("status = %+v; want the last day's $4, blocking", st)
+	}
+	if want := now.Add(time.Hour); !st.Until.Equal(want) {
+		t.Errorf("until = %v, want %v, when the $3 ages out", st.Until, want)
+	}
+
+	b.mu.Lock()
+	b.rollover(now.Add(90 * time.Minute))
+	st = b.status()
+	b.mu.Unlock()
+	if st.Spent != 1 || st.Exceeded() {
+		t.Fatalf("status after the $3 aged out = %+v", st)
+	}
+	if want := now.Add(23 * time.Hour); !st.Until.Equal(want) {
+		t.Errorf("until = %v, want %v", st.Until, want)
+	}
+
+	b.Reset()
+	if st := b.Status(); st.Spent != 0 || !st.Until.IsZero() {
+		t.Errorf("status after reset = %+v", st)
+	}
+}
+
+func TestCalendarUntil(t *testing.T) {
+	b := New(Config{Limit: 1, Period: Day}, tracker.New())
+	st := b.Status()
+	if want := Day.start(time.Now()).AddDate(0, 0, 1); !st.Until.Equal(want) {
+		t.Errorf("day budget until = %v, want %v", st.Until, want)
+	}
+	if st := New(Config{Limit: 1}, tracker.New()).Status(); !st.Until.IsZero() {
+		t.Errorf("session budget until = %v, want zero", st.Until)
+	}
+}
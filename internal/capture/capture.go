@@ -0,0 +1,172 @@
+// Package capture turns request and response bodies into short, redacted
+// text previews that can be stored alongside tracked requests.
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+
+	"miser/internal/redact"
+)
+
+// DefaultMaxBytes bounds each captured prompt or response.
+const DefaultMaxBytes = 8 * 1024
+
+// Config controls body capture. The zero value disables it.
+type Config struct {
+	Enabled  bool
+	MaxBytes int              // per body; 0 means DefaultMaxBytes
+	Redact   *redact.Redactor // nil masks API keys only
+
+	// Sample and MinCost bound how many requests keep their previews: a
+	// random fraction of them, 0 meaning all, of those that cost at least
+	// MinCost dollars.
+	Sample  float64
+	MinCost float64
+}
+
+// Sampled picks, at random, whether a request is in the Sample.
+func (c Config) Sampled() bool {
+	return c.Sample <= 0 || c.Sample >= 1 || rand.Float64() < c.Sample
+}
+
+// Keeps reports whether a sampled request that cost cost keeps its
+// previews.
+func (c Config) Keeps(cost float64) bool {
+	return cost >= c.MinCost
+}
+
+func (c Config) limit() int {
+	if c.MaxBytes > 0 {
+		return c.MaxBytes
+	}
+	return DefaultMaxBytes
+}
+
+// Text redacts secrets in s and truncates it to the configured size.
+func (c Config) Text(s string) string {
+	s = c.Redact.String(s)
+	if n := c.limit(); len(s) > n {
+		// Back up to a rune boundary so the preview stays valid UTF-8.
+		cut := n
+		for cut > 0 && !isRuneStart(s[cut]) {
+			cut--
+		}
+		s = s[:cut] + fmt.Sprintf("\n… [%d bytes truncated]", len(s)-cut)
+	}
+	return s
+}
+
+func isRuneStart(b byte) bool { return b&0xC0 != 0x80 }
+
+// Buffer accumulates streamed text, keeping at most a little more than the
+// configured limit so huge streams don't grow memory.
+type Buffer struct {
+	cfg     Config
+	b       strings.Builder
+	dropped int
+}
+
+func NewBuffer(cfg Config) *Buffer {
+	return &Buffer{cfg: cfg}
+}
+
+func (b *Buffer) WriteString(s string) {
+	if !b.cfg.Enabled {
+		return
+	}
+	if room := b.cfg.limit() + 256 - b.b.Len(); room < len(s) {
+		if room > 0 {
+			b.b.WriteString(s[:room])
+		}
+		b.dropped += len(s) - max(room, 0)
+		return
+	}
+	b.b.WriteString(s)
+}
+
+// Text returns the captured, redacted preview ("" when capture is off).
+func (b *Buffer) Text() string {
+	if !b.cfg.Enabled || b.b.Len() == 0 {
+		return ""
+	}
+	s := b.cfg.Text(b.b.String())
+	if b.dropped > 0 && !strings.HasSuffix(s, "truncated]") {
+		s += fmt.Sprintf("\n… [%d bytes truncated]", b.dropped)
+	}
+	return s
+}
+
+// Prompt renders the system prompt and messages of an Anthropic Messages
+// API request body as readable text. Non-text blocks are summarised.
+func (c Config) Prompt(body []byte) string {
+	if !c.Enabled {
+		return ""
+	}
+	var req struct {
+		System   json.RawMessage `json:"system"`
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	if json.Unmarshal(body, &req) != nil {
+		return ""
+	}
+
+	var b strings.Builder
+	if sys := contentText(req.System); sys != "" {
+		b.WriteString("[system]\n" + sys + "\n\n")
+	}
+	for _, m := range req.Messages {
+		b.WriteString("[" + m.Role + "]\n" + contentText(m.Content) + "\n\n")
+	}
+	return c.Text(strings.TrimSpace(b.String()))
+}
+
+// Response renders the content blocks of an Anthropic response body.
+func (c Config) Response(body []byte) string {
+	if !c.Enabled {
+		return ""
+	}
+	var resp struct {
+		Content json.RawMessage `json:"content"`
+	}
+	if json.Unmarshal(body, &resp) != nil {
+		return ""
+	}
+	return c.Text(contentText(resp.Content))
+}
+
+// contentText flattens a string or an array of content blocks.
+func contentText(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var blocks []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+		Name string `json:"name"`
+	}
+	if json.Unmarshal(raw, &blocks) != nil {
+		return ""
+	}
+	parts := make([]string, 0, len(blocks))
+	for _, bl := range blocks {
+		switch bl.Type {
+		case "text":
+			parts = append(parts, bl.Text)
+		case "tool_use":
+			parts = append(parts, "[tool_use: "+bl.Name+"]")
+		default:
+			parts = append(parts, "["+bl.Type+"]")
+		}
+	}
+	return strings.Join(parts, "\n")
+}
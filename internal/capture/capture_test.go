@@ -0,0 +1,75 @@
+package capture
+
+import (
+	"strings"
+	"testing"
+
+	"miser/internal/redact"
+)
+
+func TestText_RedactsKeys(t *testing.T) {
+	c := Config{Enabled: true}
+	got := c.Text("my key is sk-ant-api03-abcdefghijkl please")
+	if strings.Contains(got, "abcdefghijkl") || !strings.Contains(got, "[REDACTED]") {
+		t.Errorf("key not redacted: %q", got)
+	}
+}
+
+func TestText_CustomPatterns(t *testing.T) {
+	r, err := redact.New(nil, []string{`ghp_[A-Za-z0-9]{8,}`, `(?i)password=\S+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Config{Enabled: true, Redact: r}
+	got := c.Text("token ghp_abcdefgh12 and PASSWORD=hunter2 ok")
+	if got != "token [REDACTED] and [REDACTED] ok" {
+		t.Errorf("got %q", got)
+	}
+	if _, err := redact.New(nil, []string{"("}); err == nil || !strings.Contains(err.Error(), `"("`) {
+		t.Errorf("invalid pattern error = %v", err)
+	}
+}
+
+func TestText_TruncatesOnRuneBoundary(t *testing.T) {
+	c := Config{Enabled: true, MaxBytes: 4}
+	got := c.Text("ab€cd") // € is 3 bytes starting at offset 2
+	if !strings.HasPrefix(got, "ab\n") {
+		t.Errorf("expected cut before the multi-byte rune, got %q", got)
+	}
+	if !strings.Contains(got, "truncated") {
+		t.Errorf("expected truncation marker, got %q", got)
+	}
+}
+
+func TestPrompt(t *testing.T) {
+	c := Config{Enabled: true}
+	body := `{"system":"be brief","messages":[{"role":"user","content":"hi"},{"role":"assistant","content":[{"type":"text","text":"hello"},{"type":"tool_use","name":"grep"}]}]}`
+	got := c.Prompt([]byte(body))
+	for _, want := range []string{"[system]\nbe brief", "[user]\nhi", "[assistant]\nhello", "[tool_use: grep]"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("prompt missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	var c Config
+	if c.Prompt([]byte(`{"messages":[{"role":"user","content":"hi"}]}`)) != "" {
+		t.Error("disabled config should capture nothing")
+	}
+	b := NewBuffer(c)
+	b.WriteString("hello")
+	if b.Text() != "" {
+		t.Error("disabled buffer should capture nothing")
+	}
+}
+
+func TestBuffer_Bounded(t *testing.T) {
+	b := NewBuffer(Config{Enabled: true, MaxBytes: 10})
+	for i := 0; i < 1000; i++ {
+		b.WriteString("0123456789")
+	}
+	if got := b.Text(); len(got) > 64 {
+		t.Errorf("buffer grew unbounded: %d bytes", len(got))
+	}
+}
@@ -0,0 +1,271 @@
+// Package cassette records upstream exchanges to a JSONL file and plays
+// them back, so a session can be replayed offline, for demos, or as a
+// regression test for the request and response converters.
+package cassette
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Entry is one recorded exchange: a line of the cassette file.
+type Entry struct {
+	Key       string      `json:"key"`
+	Time      time.Time   `json:"time"`
+	Method    string      `json:"method"`
+	Path      string      `json:"path"`
+	Query     string      `json:"query,omitempty"`
+	Request   string      `json:"request,omitempty"` // body
+	Status    int         `json:"status"`
+	Header    http.Header `json:"header"`
+	Body      string      `json:"body"`
+	Base64    bool        `json:"base64,omitempty"` // Body is base64 (not UTF-8, e.g. gzip)
+	LatencyMS int64       `json:"latency_ms"`
+}
+
+// Key identifies a request for playback: method, path, query and a hash
+// of the body. JSON bodies are compacted first so formatting differences
+// don't matter.
+func Key(method, path, query string, body []byte) string {
+	var buf bytes.Buffer
+	if json.Compact(&buf, body) == nil {
+		body = buf.Bytes()
+	}
+	sum := sha256.Sum256(body)
+	k := method + " " + path
+	if query != "" {
+		k += "?" + query
+	}
+	return k + " " + hex.EncodeToString(sum[:8])
+}
+
+func requestKey(req *http.Request) (string, []byte, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return "", nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return Key(req.Method, req.URL.Path, req.URL.RawQuery, body), body, nil
+}
+
+// Recorder is an http.RoundTripper that forwards to Next and appends every
+// completed exchange to a cassette file. Responses stream through as
+// usual; an entry is written once the body has been read to the end.
+type Recorder struct {
+	Next http.RoundTripper
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewRecorder opens path for appending, creating it if needed.
+func NewRecorder(path string, next http.RoundTripper) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening cassette: %w", err)
+	}
+	return &Recorder{Next: next, f: f}, nil
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, body, err := requestKey(req)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := r.Next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	e := Entry{
+		Key:     key,
+		Time:    start,
+		Method:  req.Method,
+		Path:    req.URL.Path,
+		Query:   req.URL.RawQuery,
+		Request: string(body),
+		Status:  resp.StatusCode,
+		Header:  resp.Header.Clone(),
+	}
+	e.Header.Del("Content-Length")
+	resp.Body = &tee{ReadCloser: resp.Body, done: func(b []byte) {
+		e.LatencyMS = time.Since(start).Milliseconds()
+		e.setBody(b)
+		r.write(e)
+	}}
+	return resp, nil
+}
+
+func (e *Entry) setBody(b []byte) {
+	if utf8.Valid(b) {
+		e.Body = string(b)
+		return
+	}
+	e.Body = base64.StdEncoding.EncodeToString(b)
+	e.Base64 = true
+}
+
+func (e *Entry) body() ([]byte, error) {
+	if e.Base64 {
+		return base64.StdEncoding.DecodeString(e.Body)
+	}
+	return []byte(e.Body), nil
+}
+
+func (r *Recorder) write(e Entry) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.f.Write(append(line, '\n'))
+}
+
+// Close closes the cassette file.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+// tee copies a response body as it is read and hands the copy to done at
+// EOF. Bodies abandoned early are not recorded.
+type tee struct {
+	io.ReadCloser
+	buf  bytes.Buffer
+	done func([]byte)
+	once sync.Once
+}
+
+func (t *tee) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	t.buf.Write(p[:n])
+	if err == io.EOF {
+		t.once.Do(func() { t.done(t.buf.Bytes()) })
+	}
+	return n, err
+}
+
+// Player is an http.RoundTripper that answers from a cassette instead of
+// the network. Repeated identical requests get the recorded responses in
+// order; once those run out, the last one is repeated.
+type Player struct {
+	mu      sync.Mutex
+	entries map[string][]Entry
+	next    map[string]int
+	count   int
+}
+
+// Load reads a cassette written by Recorder.
+func Load(path string) (*Player, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening cassette: %w", err)
+	}
+	defer f.Close()
+
+	p := &Player{entries: make(map[string][]Entry), next: make(map[string]int)}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 64<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("cassette %s line %d: %w", path, line, err)
+		}
+		p.entries[e.Key] = append(p.entries[e.Key], e)
+		p.count++
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("reading cassette %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Len returns the number of recorded exchanges.
+func (p *Player) Len() int {
+	return p.count
+}
+
+// ErrNotRecorded is the cause reported for requests the cassette has no
+// response for.
+var ErrNotRecorded = errors.New("no recorded response")
+
+func (p *Player) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, _, err := requestKey(req)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	list := p.entries[key]
+	i := p.next[key]
+	if i < len(list)-1 {
+		p.next[key] = i + 1
+	}
+	p.mu.Unlock()
+
+	if len(list) == 0 {
+		return notRecorded(req, key), nil
+	}
+	e := list[min(i, len(list)-1)]
+	body, err := e.body()
+	if err != nil {
+		return nil, fmt.Errorf("cassette entry %s: %w", key, err)
+	}
+	header := e.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// notRecorded answers like the API would for an unknown route, so clients
+// show a readable error.
+func notRecorded(req *http.Request, key string) *http.Response {
+	msg, _ := json.Marshal(fmt.Sprintf("%v for %s", ErrNotRecorded, key))
+	body := `{"type":"error","error":{"type":"not_found_error","message":` + string(msg) + `}}`
+	return &http.Response{
+		Status:        "404 Not Found",
+		StatusCode:    http.StatusNotFound,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
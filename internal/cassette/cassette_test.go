@@ -0,0 +1,64 @@
+package cassette
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func get(t *testing.T, rt http.RoundTripper, url, body string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func TestRecordAndPlay(t *testing.T) {
+	var n atomic.Int64
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Request-Id", "req_1")
+		io.WriteString(w, "answer "+string(rune('0'+n.Add(1))))
+	}))
+	defer up.Close()
+
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	rec, err := NewRecorder(path, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get(t, rec, up.URL+"/v1/messages", `{"model": "a"}`)
+	get(t, rec, up.URL+"/v1/messages", `{"model": "a"}`)
+	get(t, rec, up.URL+"/v1/messages", `{"model": "b"}`)
+	rec.Close()
+
+	p, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Len() != 3 {
+		t.Fatalf("Len = %d", p.Len())
+	}
+	// Identical requests replay in recorded order, then repeat the last;
+	// JSON formatting does not affect matching.
+	for i, want := range []string{"answer 1", "answer 2", "answer 2"} {
+		if _, got := get(t, p, "http://offline/v1/messages", `{"model":"a"}`); got != want {
+			t.Errorf("replay %d = %q, want %q", i, got, want)
+		}
+	}
+	if _, got := get(t, p, "http://offline/v1/messages", `{"model":"b"}`); got != "answer 3" {
+		t.Errorf("model b = %q", got)
+	}
+	status, body := get(t, p, "http://offline/v1/messages", `{"model":"c"}`)
+	if status != http.StatusNotFound || !strings.Contains(body, "no recorded response") {
+		t.Errorf("miss = %d %s", status, body)
+	}
+}
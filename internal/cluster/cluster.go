@@ -0,0 +1,151 @@
+// Package cluster pushes a miser node's requests to an aggregator: another
+// miser, typically a team's, that records every node's traffic and shows
+// combined and per-node spend. Requests are batched and resent until the
+// aggregator has them, so a node keeps working through an outage and
+// catches up afterwards.
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"miser/internal/api"
+	"miser/internal/tracker"
+)
+
+// Push tuning. A node records a few requests a second at most, so a
+// batch rarely waits for the interval; the backlog covers a long outage.
+const (
+	pushInterval = time.Second
+	maxBatch     = 500
+	maxBacklog   = 50_000
+	maxBackoff   = time.Minute
+)
+
+// Pusher sends the requests given to Add to an aggregator.
+type Pusher struct {
+	client *api.Client
+	node   string
+	run    string
+	onErr  func(err error, backlog int) // may be nil
+	every  time.Duration                // pushInterval, shorter in tests
+
+	mu      sync.Mutex
+	pending []tracker.Request
+	dropped int // oldest requests discarded when the backlog was full
+	wake    chan struct{}
+}
+
+// NewPusher returns a pusher for the node name to the aggregator behind
+// c. onErr, if set, is called when a push fails, with the number of
+// requests waiting.
+func NewPusher(c *api.Client, node string, onErr func(err error, backlog int)) *Pusher {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return &Pusher{
+		client: c,
+		node:   node,
+		run:    hex.EncodeToString(b),
+		onErr:  onErr,
+		every:  pushInterval,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Add queues a request; it is a tracker subscriber. A request an
+// upstream miser counted is left to that miser to report.
+func (p *Pusher) Add(r tracker.Request) {
+	if r.CountedUpstream() {
+		return
+	}
+	p.mu.Lock()
+	if len(p.pending) >= maxBacklog {
+		p.pending = p.pending[1:]
+		p.dropped++
+	}
+	p.pending = append(p.pending, r)
+	full := len(p.pending) >= maxBatch
+	p.mu.Unlock()
+	if full {
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Dropped returns how many requests were discarded because the backlog was
+// full while the aggregator was unreachable.
+func (p *Pusher) Dropped() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.dropped
+}
+
+// Run pushes queued requests every interval until ctx is done, then makes
+// one last attempt to send what is left.
+func (p *Pusher) Run(ctx context.Context) {
+	wait := p.every
+	for {
+		select {
+		case <-ctx.Done():
+			final, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			for p.backlog() > 0 {
+				if p.push(final) != nil {
+					break
+				}
+			}
+			return
+		case <-time.After(wait):
+		case <-p.wake:
+		}
+		if err := p.push(ctx); err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			if p.onErr != nil {
+				p.onErr(err, p.backlog())
+			}
+			wait = min(wait*2, maxBackoff)
+			continue
+		}
+		wait = p.every
+		if p.backlog() >= maxBatch {
+			wait = 0 // catching up after an outage
+		}
+	}
+}
+
+func (p *Pusher) backlog() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.pending)
+}
+
+// push sends the oldest batch and drops what the aggregator confirmed.
+func (p *Pusher) push(ctx context.Context) error {
+	p.mu.Lock()
+	batch := p.pending[:min(len(p.pending), maxBatch)]
+	batch = append([]tracker.Request{}, batch...)
+	p.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	res, err := p.client.Push(ctx, api.Ingest{Node: p.node, Run: p.run, Requests: batch})
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	i := 0
+	for i < len(p.pending) && p.pending[i].ID <= res.Last {
+		i++
+	}
+	p.pending = p.pending[i:]
+	p.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,73 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"miser/internal/api"
+	"miser/internal/tracker"
+)
+
+func TestPusherResendsUntilAccepted(t *testing.T) {
+	var mu sync.Mutex
+	var got []int
+	fail := 1
+	agg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail > 0 {
+			fail--
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		var in api.Ingest
+		json.NewDecoder(r.Body).Decode(&in)
+		last := 0
+		for _, req := range in.Requests {
+			got = append(got, req.ID)
+			last = req.ID
+		}
+		json.NewEncoder(w).Encode(api.IngestResult{Recorded: len(in.Requests), Last: last})
+	}))
+	defer agg.Close()
+
+	errs := 0
+	p := NewPusher(api.NewClient(agg.URL), "alice", func(error, int) { errs++ })
+	p.every = 10 * time.Millisecond
+	for id := 1; id <= 3; id++ {
+		p.Add(tracker.Request{ID: id})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Run(ctx)
+	}()
+	deadline := time.Now().Add(10 * time.Second)
+	for p.backlog() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	p.Add(tracker.Request{ID: 4})
+	cancel()
+	<-done // the last request goes out at shutdown
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 4 || got[0] != 1 || got[3] != 4 || errs != 1 {
+		t.Errorf("aggregator got %v after %d failed pushes", got, errs)
+	}
+}
+
+func TestPusherSkipsCountedUpstream(t *testing.T) {
+	p := NewPusher(api.NewClient("http://127.0.0.1:0"), "alice", nil)
+	p.Add(tracker.Request{ID: 1, Timestamp: time.Now(), CountedBy: "gateway:8080-1a2b3c4d"})
+	p.Add(tracker.Request{ID: 2, Timestamp: time.Now()})
+	if n := p.backlog(); n != 1 {
+		t.Errorf("backlog = %d, want 1: the counted request is the gateway's to push", n)
+	}
+}
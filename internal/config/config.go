@@ -2,8 +2,11 @@ package config
 
 import (
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
@@ -12,8 +15,338 @@ import (
 type Config struct {
 	Proxy       ProxyConfig            `toml:"proxy"`
 	Models      map[string]ModelConfig `toml:"models"`
+	Aliases     map[string]string      `toml:"aliases"`
 	Fallback    *PricingConfig         `toml:"fallback"`
 	Compression CompressionConfig      `toml:"compression"`
+	History     HistoryConfig          `toml:"history"`
+	Capture     CaptureConfig          `toml:"capture"`
+	Budget      BudgetConfig           `toml:"budget"`
+	TUI         TUIConfig              `toml:"tui"`
+	Format      FormatConfig           `toml:"format"`
+	API         APIConfig              `toml:"api"`
+	Daemon      DaemonConfig           `toml:"daemon"`
+	Mock        MockConfig             `toml:"mock"`
+	Notify      NotifyConfig           `toml:"notify"`
+	Audit       AuditConfig            `toml:"audit"`
+	Syslog      SyslogConfig           `toml:"syslog"`
+	Export      ExportConfig           `toml:"export"`
+	Keys        KeysConfig             `toml:"keys"`
+	Cluster     ClusterConfig          `toml:"cluster"`
+	Shadow      ShadowConfig           `toml:"shadow"`
+	Redact      RedactConfig           `toml:"redact"`
+	Tokens      TokensConfig           `toml:"tokens"`
+	Upstreams   UpstreamsConfig        `toml:"upstreams"`
+	Race        RaceConfig             `toml:"race"`
+	Hooks       []HookConfig           `toml:"hook"`
+	Inject      []InjectConfig         `toml:"inject"`
+
+	// Defaults holds the [defaults."<model glob>"] tables.
+	Defaults map[string]DefaultsConfig `toml:"defaults"`
+
+	// SLO holds the [slo."<model glob>"] latency objectives.
+	SLO map[string]SLOConfig `toml:"slo"`
+
+	// Profiles holds the [profile.<name>] tables. Each one may contain any
+	// of the sections above and is overlaid on them by LoadProfile.
+	Profiles map[string]toml.Primitive `toml:"profile"`
+
+	// Profile is the name of the profile applied by LoadProfile, or "".
+	Profile string `toml:"-"`
+}
+
+// DaemonConfig locates the files used by --daemon, "miser stop" and
+// "miser status".
+type DaemonConfig struct {
+	PidFile string `toml:"pid_file"` // default: ~/.local/state/miser/miser.pid
+	LogFile string `toml:"log_file"` // default: ~/.local/state/miser/miser.log
+}
+
+// NotifyConfig sets when alert events fire and where they are sent.
+type NotifyConfig struct {
+	BudgetThresholds []float64        `toml:"budget_thresholds"`  // fractions of the [budget] amount
+	ErrorRate        float64          `toml:"error_rate"`         // alert when this fraction of recent requests fail; 0 disables
+	ErrorWindow      string           `toml:"error_window"`       // how far back "recent" goes
+	ErrorMinRequests int              `toml:"error_min_requests"` // ignore windows with fewer requests
+	DailySummary     string           `toml:"daily_summary"`      // "HH:MM" local time to send the last 24 hours; "" disables
+	ExpensiveRequest float64          `toml:"expensive_request"`  // alert on any single request costing this many dollars; 0 disables
+	RateLimit        float64          `toml:"rate_limit"`         // alert when less than this fraction of a rate limit is left; 0 disables
+	Desktop          DesktopConfig    `toml:"desktop"`
+	Webhooks         []WebhookConfig  `toml:"webhook"`
+	Slack            []ChatConfig     `toml:"slack"`
+	Discord          []ChatConfig     `toml:"discord"`
+	Schedules        []ScheduleConfig `toml:"schedule"`
+	Email            []EmailConfig    `toml:"email"`
+}
+
+// WebhookConfig is one [[notify.webhook]] destination.
+type WebhookConfig struct {
+	URL     string            `toml:"url"`
+	Events  []string          `toml:"events"`  // default: every event
+	Headers map[string]string `toml:"headers"` // e.g. Authorization
+}
+
+// DesktopConfig enables native desktop notifications.
+type DesktopConfig struct {
+	Enabled bool     `toml:"enabled"`
+	Events  []string `toml:"events"`
+}
+
+// ChatConfig is one [[notify.slack]] or [[notify.discord]] incoming
+// webhook. Events are formatted as chat messages.
+type ChatConfig struct {
+	URL    string   `toml:"url"`
+	Events []string `toml:"events"` // default: every event
+}
+
+// ScheduleConfig is one [[notify.schedule]] usage digest. Set one of
+// Cron or Every.
+type ScheduleConfig struct {
+	Cron    string            `toml:"cron"`    // e.g. "0 9 * * 1-5" or "@daily", local time
+	Every   string            `toml:"every"`   // e.g. "6h"
+	Top     int               `toml:"top"`     // most expensive requests to list; default 5, -1 for none
+	URL     string            `toml:"url"`     // send only here; default: every target that wants scheduled_summary
+	Format  string            `toml:"format"`  // for url: "json", "slack" or "discord"; default from the host
+	Headers map[string]string `toml:"headers"` // for a json url
+}
+
+// EmailConfig is one [[notify.email]] recipient list, reached through an
+// SMTP server.
+type EmailConfig struct {
+	Host     string   `toml:"host"`
+	Port     int      `toml:"port"`     // default 587; 465 uses implicit TLS
+	Username string   `toml:"username"` // "" sends without logging in
+	Password string   `toml:"password"` // or $MISER_SMTP_PASSWORD
+	From     string   `toml:"from"`
+	To       []string `toml:"to"`
+	Report   string   `toml:"report"` // "daily", "weekly" or a cron expression; "" sends none
+	Events   []string `toml:"events"` // alerts to email as well; default none
+}
+
+// ExportConfig holds the [export] settings.
+type ExportConfig struct {
+	Dir       string   `toml:"dir"`       // the TUI export dialog's directory; default "."
+	Filename  string   `toml:"filename"`  // name template with {date}, {time}, {host} and {ext}
+	Delimiter string   `toml:"delimiter"` // CSV field separator: one character, or "tab"
+	Columns   []string `toml:"columns"`   // CSV columns, in order; default the fixed set
+
+	Uploads []UploadConfig `toml:"upload"`
+	Sinks   []SinkConfig   `toml:"sink"`
+}
+
+// UploadConfig is one [[export.upload]]: an export made on a schedule by
+// the running proxy and stored remotely. Set one of Cron or Every.
+type UploadConfig struct {
+	Cron    string            `toml:"cron"`    // e.g. "0 * * * *", local time
+	Every   string            `toml:"every"`   // e.g. "15m"
+	Format  string            `toml:"format"`  // any export format; default ndjson
+	Rows    string            `toml:"rows"`    // "new" (since the last upload, the default) or "all"
+	URL     string            `toml:"url"`     // s3://bucket/key or http(s)://host/path, with {time}, {date}, {host} and {ext}
+	Headers map[string]string `toml:"headers"` // for http(s), e.g. Authorization
+
+	// For s3:// URLs.
+	Endpoint     string `toml:"endpoint"`      // an S3-compatible service; default AWS
+	Region       string `toml:"region"`        // default $AWS_REGION, else us-east-1
+	AccessKey    string `toml:"access_key"`    // default $AWS_ACCESS_KEY_ID
+	SecretKey    string `toml:"secret_key"`    // default $AWS_SECRET_ACCESS_KEY
+	SessionToken string `toml:"session_token"` // default $AWS_SESSION_TOKEN
+}
+
+// SinkConfig is one [[export.sink]]: a collector the running proxy sends
+// every request to as it is recorded.
+type SinkConfig struct {
+	URL     string            `toml:"url"`     // http(s) endpoint; batches are POSTed as NDJSON
+	Headers map[string]string `toml:"headers"` // e.g. Authorization
+	Spool   string            `toml:"spool"`   // where unsent requests wait; default next to the history
+}
+
+// ShadowConfig mirrors selected requests to a second upstream or model,
+// to try it on real traffic. It is on when Target or Model is set.
+type ShadowConfig struct {
+	Target string   `toml:"target"` // an Anthropic-compatible upstream; default [proxy] target
+	Model  string   `toml:"model"`  // e.g. "claude-haiku-4-5"; default the request's
+	Models []string `toml:"models"` // mirror only these models, e.g. "claude-sonnet-*"; default all
+	Sample float64  `toml:"sample"` // fraction of those requests mirrored, 0 to 1
+	Keep   bool     `toml:"keep"`   // store both responses, as [capture] previews
+}
+
+// TokensConfig checks the input tokens upstream reports against a local
+// estimate.
+type TokensConfig struct {
+	Check     bool    `toml:"check"`
+	Tolerance float64 `toml:"tolerance"`  // flag counts off the estimate by more than this fraction
+	MinTokens int     `toml:"min_tokens"` // skip smaller prompts
+}
+
+// UpstreamsConfig adds endpoints serving the same API as [proxy] target,
+// such as other regions or gateways, and how the active one is chosen.
+type UpstreamsConfig struct {
+	Targets       []string `toml:"targets"`        // tried along with [proxy] target, which comes first
+	Policy        string   `toml:"policy"`         // "fastest", "sticky" or "manual"
+	ProbeInterval string   `toml:"probe_interval"` // between health and latency probes; "0" probes once
+}
+
+// RaceConfig races selected requests against a second model and relays
+// whichever answers first. It is on when Model is set.
+type RaceConfig struct {
+	Model  string   `toml:"model"`  // e.g. "claude-haiku-4-5"
+	Models []string `toml:"models"` // race only these models, e.g. "claude-opus-*"; default all
+}
+
+// HookConfig is one [[hook]]: a condition over each request, or each
+// response, and what to do when it holds. See the hook package for the
+// expression language.
+type HookConfig struct {
+	Name    string         `toml:"name"`
+	On      string         `toml:"on"`      // "request" (default) or "response"
+	When    string         `toml:"when"`    // e.g. 'budget.fraction > 0.9'; "" always holds
+	Veto    string         `toml:"veto"`    // refuse the request with this message
+	Set     map[string]any `toml:"set"`     // request fields to set, e.g. {max_tokens = 4096}
+	Drop    []string       `toml:"drop"`    // request fields to remove
+	Replace []HookReplace  `toml:"replace"` // rewrites of the system prompt and message text
+	Tags    []string       `toml:"tags"`    // added to the request's record
+}
+
+// HookReplace rewrites prompt text matching Pattern, a regexp, with With,
+// which may refer to groups as $1.
+type HookReplace struct {
+	Pattern string `toml:"pattern"`
+	With    string `toml:"with"`
+}
+
+// DefaultsConfig is one [defaults."<model glob>"] table: parameters sent
+// for matching models when the client leaves them out.
+type DefaultsConfig struct {
+	Temperature   *float64 `toml:"temperature"`
+	TopP          *float64 `toml:"top_p"`
+	StopSequences []string `toml:"stop_sequences"`
+	MaxTokens     int      `toml:"max_tokens"`
+}
+
+// SLOConfig is one [slo."<model glob>"] table: a latency objective for
+// matching models.
+type SLOConfig struct {
+	Latency     string  `toml:"latency"`      // e.g. "20s"
+	Percentile  float64 `toml:"percentile"`   // percent of requests that must finish within it; default 95
+	Window      string  `toml:"window"`       // rolling window compliance is measured over; default "1h"
+	MinRequests int     `toml:"min_requests"` // windows with fewer aren't judged; default 5
+	Alert       bool    `toml:"alert"`        // send a latency_slo notification on breach
+}
+
+// InjectConfig is one [[inject]]: system prompt text added to the model
+// requests it selects.
+type InjectConfig struct {
+	Name     string   `toml:"name"`
+	Text     string   `toml:"text"`
+	Position string   `toml:"position"` // "append" (default) or "prepend"
+	Routes   []string `toml:"routes"`   // "messages", "chat"; default both
+	Models   []string `toml:"models"`   // e.g. "claude-opus-*"; default all
+	Projects []string `toml:"projects"` // default all
+}
+
+// MockConfig shapes the canned responses served with --mock instead of
+// calling the upstream.
+type MockConfig struct {
+	Enabled          bool   `toml:"enabled"`
+	Latency          string `toml:"latency"`       // per response, e.g. "800ms"
+	InputTokens      int    `toml:"input_tokens"`  // 0 estimates from the request size
+	OutputTokens     int    `toml:"output_tokens"` // capped by the request's max_tokens
+	CacheReadTokens  int    `toml:"cache_read_tokens"`
+	CacheWriteTokens int    `toml:"cache_write_tokens"`
+}
+
+// APIConfig controls the /miser/api/ endpoints used by "miser top" and
+// "miser watch".
+type APIConfig struct {
+	Token string `toml:"token"` // bearer token for remote clients; "" keeps the API local only
+	Pprof bool   `toml:"pprof"` // serve Go runtime profiles under /miser/api/debug/pprof/
+}
+
+// FormatConfig controls how numbers are displayed in the TUI, headless log
+// and Markdown exports.
+type FormatConfig struct {
+	Tokens    string `toml:"tokens"`    // "abbrev" (1.2K) or "raw" (1,234)
+	Thousands string `toml:"thousands"` // digit group separator; "" disables grouping
+	Decimal   string `toml:"decimal"`   // decimal mark
+
+	Currency string  `toml:"currency"` // ISO 4217 code to show amounts in; "" or "USD" for dollars
+	Rate     float64 `toml:"rate"`     // units of currency to the dollar, until one is fetched
+	RateURL  string  `toml:"rate_url"` // refresh the rate daily from here; "" keeps rate
+}
+
+// TUIConfig holds dashboard preferences.
+type TUIConfig struct {
+	Confirm bool `toml:"confirm"` // ask before clearing the session or resetting the budget
+}
+
+// BudgetConfig sets a spend limit in dollars. Zero disables the budget.
+type BudgetConfig struct {
+	Amount float64 `toml:"amount"`
+	Period string  `toml:"period"` // "session", "day", "week", "month" or "rolling"
+	Action string  `toml:"action"` // "warn" or "block" once the limit is reached
+
+	// MaxRequest is a limit on a single request's projected cost, its input
+	// plus max_tokens of output; 0 disables it. RequestAction is "warn" or
+	// "block" for requests over it.
+	MaxRequest    float64 `toml:"max_request"`
+	RequestAction string  `toml:"request_action"`
+}
+
+// CaptureConfig enables storing redacted, truncated prompt and response
+// previews with each tracked request.
+type CaptureConfig struct {
+	Enabled  bool     `toml:"enabled"`
+	MaxBytes int      `toml:"max_bytes"` // per prompt or response; 0 means 8 KB
+	Redact   []string `toml:"redact"`    // extra regexes to mask, like [redact] patterns
+	Sample   float64  `toml:"sample"`    // fraction of requests whose previews are kept
+	MinCost  float64  `toml:"min_cost"`  // keep previews only of requests costing at least this
+}
+
+// RedactConfig masks secrets and personal data in captured previews and
+// in exports.
+type RedactConfig struct {
+	Builtin  []string `toml:"builtin"`  // built-in sets: api_keys (always on), aws, emails
+	Patterns []string `toml:"patterns"` // extra regexes, Go RE2 syntax
+}
+
+// AuditConfig controls the log of administrative actions (clears, budget
+// changes, pricing reloads, session moves and exports).
+type AuditConfig struct {
+	Enabled bool   `toml:"enabled"`
+	Path    string `toml:"path"` // default: ~/.local/share/miser/audit.jsonl
+}
+
+// KeysConfig controls the virtual keys issued with "miser keys create".
+type KeysConfig struct {
+	Path     string            `toml:"path"`     // default: ~/.local/share/miser/keys.json
+	Require  bool              `toml:"require"`  // refuse model requests without a virtual key
+	Upstream map[string]string `toml:"upstream"` // named real keys a virtual key may map to instead of [proxy] api_key
+}
+
+// ClusterConfig joins instances into a cluster: nodes push their requests
+// to an aggregator, which shows combined and per-node spend.
+type ClusterConfig struct {
+	Aggregate  bool   `toml:"aggregate"`  // accept nodes' requests; remote nodes need the [api] token
+	Aggregator string `toml:"aggregator"` // push this instance's requests to the miser at this URL
+	Node       string `toml:"node"`       // this instance's name on the aggregator; default: the host name
+	Token      string `toml:"token"`      // the aggregator's [api] token
+}
+
+// SyslogConfig mirrors the headless log and alert events to syslog.
+type SyslogConfig struct {
+	Enabled  bool     `toml:"enabled"`
+	Network  string   `toml:"network"`  // "" for the local daemon, or "udp", "tcp"
+	Address  string   `toml:"address"`  // host:port for udp and tcp
+	Tag      string   `toml:"tag"`      // program name on each line
+	Facility string   `toml:"facility"` // daemon, user, local0 … local7, …
+	Requests bool     `toml:"requests"` // one line per request, as in the headless log
+	Events   []string `toml:"events"`   // alert events to send; default: every event
+}
+
+type HistoryConfig struct {
+	Enabled bool   `toml:"enabled"`
+	Path    string `toml:"path"`     // default: ~/.local/share/miser/history.jsonl
+	MaxAge  string `toml:"max_age"`  // prune requests older than this at startup, e.g. "90d"; "" keeps all
+	MaxRows int    `toml:"max_rows"` // prune all but the newest this many at startup; 0 keeps all
 }
 
 type CompressionConfig struct {
@@ -27,6 +360,52 @@ type ProxyConfig struct {
 	Port    int    `toml:"port"`
 	Target  string `toml:"target"`
 	Timeout string `toml:"timeout"`
+
+	// StallTimeout drops a client that stops reading a response for this
+	// long; "0" waits as long as the upstream timeout.
+	StallTimeout string `toml:"stall_timeout"`
+
+	// ReadHeaderTimeout and IdleTimeout bound how long a client may take
+	// to send a request's headers and keep an idle connection open, and
+	// MaxConns and MaxConnsPerIP cap the connections open at once; 0 means
+	// no limit.
+	ReadHeaderTimeout string `toml:"read_header_timeout"`
+	IdleTimeout       string `toml:"idle_timeout"`
+	MaxConns          int    `toml:"max_conns"`
+	MaxConnsPerIP     int    `toml:"max_conns_per_ip"`
+
+	// Resolve connects to the target's host at these IPs instead of
+	// looking it up; Resolver looks it up with this DNS server instead.
+	Resolve  []string `toml:"resolve"`
+	Resolver string   `toml:"resolver"`
+
+	APIKey  string `toml:"api_key"` // sent upstream in place of the client's key; "" forwards the client's
+	Project string `toml:"project"` // for requests without an X-Miser-Project header
+
+	// Chain passes projects and clients on to a target that is another
+	// miser, such as a team gateway.
+	Chain bool `toml:"chain"`
+
+	// LookupClients names the local process behind each connection for the
+	// request log's client column; X-Miser-Client overrides it. It scans
+	// /proc or runs lsof per connection, so it is off unless asked for.
+	LookupClients bool `toml:"lookup_clients"`
+
+	// MaxTokens caps the max_tokens of model requests; 0 means no cap.
+	// OverMaxTokens is what happens to a request over it.
+	MaxTokens     int    `toml:"max_tokens"`
+	OverMaxTokens string `toml:"over_max_tokens"` // "clamp", the default, or "reject"
+
+	// Retries resends a model request that failed with 429, 529 or
+	// another 5xx, or couldn't reach upstream, up to this many times, first
+	// after RetryBackoff, then twice as long each time; 0 never retries.
+	Retries      int    `toml:"retries"`
+	RetryBackoff string `toml:"retry_backoff"`
+
+	// OverloadFallback maps model globs to the model a request for one is
+	// resent to when upstream is still overloaded (529) after any retries,
+	// e.g. {"claude-opus-*" = "claude-sonnet-4-6"}.
+	OverloadFallback map[string]string `toml:"overload_fallback"`
 }
 
 type ModelConfig struct {
@@ -35,6 +414,7 @@ type ModelConfig struct {
 	OutputPerMTok     float64  `toml:"output_per_mtok"`
 	CacheReadPerMTok  float64  `toml:"cache_read_per_mtok"`
 	CacheWritePerMTok float64  `toml:"cache_write_per_mtok"`
+	ContextWindow     int      `toml:"context_window"` // tokens; default 200000
 }
 
 type PricingConfig struct {
@@ -50,6 +430,63 @@ func Default() Config {
 			Port:    8080,
 			Target:  "https://api.anthropic.com",
 			Timeout: "5m",
+
+			StallTimeout:      "30s",
+			ReadHeaderTimeout: "10s",
+			IdleTimeout:       "2m",
+		},
+		History: HistoryConfig{
+			Enabled: true,
+		},
+		Audit: AuditConfig{
+			Enabled: true,
+		},
+		Syslog: SyslogConfig{
+			Tag:      "miser",
+			Facility: "daemon",
+			Requests: true,
+		},
+		Budget: BudgetConfig{
+			Period: "session",
+			Action: "warn",
+		},
+		TUI: TUIConfig{
+			Confirm: true,
+		},
+		Notify: NotifyConfig{
+			BudgetThresholds: []float64{0.5, 0.8, 1},
+			ErrorRate:        0.5,
+			ErrorWindow:      "5m",
+			ErrorMinRequests: 5,
+			Desktop: DesktopConfig{
+				Events: []string{"budget_threshold", "error_rate", "expensive_request"},
+			},
+		},
+		Capture: CaptureConfig{
+			Sample: 1,
+		},
+		Redact: RedactConfig{
+			Builtin: []string{"api_keys", "aws", "emails"},
+		},
+		Shadow: ShadowConfig{
+			Sample: 1,
+		},
+		Tokens: TokensConfig{
+			Tolerance: 0.25,
+			MinTokens: 500,
+		},
+		Upstreams: UpstreamsConfig{
+			Policy:        "fastest",
+			ProbeInterval: "30s",
+		},
+		Mock: MockConfig{
+			Latency:      "500ms",
+			OutputTokens: 200,
+		},
+		Format: FormatConfig{
+			Tokens:    "abbrev",
+			Thousands: ",",
+			Decimal:   ".",
 		},
 	}
 }
@@ -58,12 +495,23 @@ func Default() Config {
 // ./miser.toml then ~/.config/miser/config.toml. If no file is found,
 // it returns defaults without error.
 func Load(path string) (Config, error) {
+	return LoadProfile(path, "")
+}
+
+// LoadProfile is like Load, then overlays the [profile.<profile>] table so
+// any setting it contains replaces the top-level one; model pricing entries
+// are merged by name. An empty profile applies none. ${VAR} references in
+// string settings are expanded last; see expandEnv.
+func LoadProfile(path, profile string) (Config, error) {
 	cfg := Default()
 
 	if path == "" {
-		path = discover()
+		path = Discover()
 	}
 	if path == "" {
+		if profile != "" {
+			return cfg, fmt.Errorf("profile %q: no config file found", profile)
+		}
 		return cfg, nil
 	}
 
@@ -72,9 +520,23 @@ func Load(path string) (Config, error) {
 		return cfg, fmt.Errorf("reading config %s: %w", path, err)
 	}
 
-	if err := toml.Unmarshal(data, &cfg); err != nil {
+	md, err := toml.Decode(string(data), &cfg)
+	if err != nil {
 		return cfg, fmt.Errorf("parsing config %s: %w", path, err)
 	}
+	if profile != "" {
+		p, ok := cfg.Profiles[profile]
+		if !ok {
+			return cfg, fmt.Errorf("config %s: unknown profile %q%s", path, profile, cfg.profileList())
+		}
+		if err := overlayProfile(md, p, &cfg); err != nil {
+			return cfg, fmt.Errorf("parsing config %s: profile %q: %w", path, profile, err)
+		}
+		cfg.Profile = profile
+	}
+	if err := expandEnv(&cfg); err != nil {
+		return cfg, fmt.Errorf("config %s: %w", path, err)
+	}
 
 	if cfg.Proxy.Port == 0 {
 		cfg.Proxy.Port = 8080
@@ -92,6 +554,63 @@ func Load(path string) (Config, error) {
 	return cfg, nil
 }
 
+// overlayProfile decodes a profile's table over cfg. Decoding replaces a
+// map entry whole, so the tables keyed by model are then decoded again one
+// by one, onto copies of the top-level entries: a profile that sets one
+// price of a model keeps the others.
+func overlayProfile(md toml.MetaData, p toml.Primitive, cfg *Config) error {
+	models, defaults, slo := maps.Clone(cfg.Models), maps.Clone(cfg.Defaults), maps.Clone(cfg.SLO)
+	if err := md.PrimitiveDecode(p, cfg); err != nil {
+		return err
+	}
+	var tables struct {
+		Models   map[string]toml.Primitive `toml:"models"`
+		Defaults map[string]toml.Primitive `toml:"defaults"`
+		SLO      map[string]toml.Primitive `toml:"slo"`
+	}
+	if err := md.PrimitiveDecode(p, &tables); err != nil {
+		return err
+	}
+	if err := mergeEntries(md, tables.Models, models, cfg.Models); err != nil {
+		return err
+	}
+	if err := mergeEntries(md, tables.Defaults, defaults, cfg.Defaults); err != nil {
+		return err
+	}
+	return mergeEntries(md, tables.SLO, slo, cfg.SLO)
+}
+
+// mergeEntries sets into[k] to base[k] with the profile's table for k
+// decoded over it.
+func mergeEntries[V any](md toml.MetaData, tables map[string]toml.Primitive, base, into map[string]V) error {
+	for k, t := range tables {
+		v := base[k]
+		if err := md.PrimitiveDecode(t, &v); err != nil {
+			return err
+		}
+		into[k] = v
+	}
+	return nil
+}
+
+// ProfileNames returns the names of the profiles defined in the file, sorted.
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (c *Config) profileList() string {
+	names := c.ProfileNames()
+	if len(names) == 0 {
+		return " (the file defines none)"
+	}
+	return " (defined: " + strings.Join(names, ", ") + ")"
+}
+
 func (c *Config) ProxyTimeout() time.Duration {
 	d, err := time.ParseDuration(c.Proxy.Timeout)
 	if err != nil {
@@ -100,7 +619,37 @@ func (c *Config) ProxyTimeout() time.Duration {
 	return d
 }
 
-func discover() string {
+// ProxyStallTimeout returns the stall timeout; 0 disables it.
+func (c *Config) ProxyStallTimeout() time.Duration {
+	return durationOr(c.Proxy.StallTimeout, 30*time.Second)
+}
+
+// ProxyReadHeaderTimeout returns the header timeout; 0 disables it.
+func (c *Config) ProxyReadHeaderTimeout() time.Duration {
+	return durationOr(c.Proxy.ReadHeaderTimeout, 10*time.Second)
+}
+
+// ProxyIdleTimeout returns the keep-alive idle timeout; 0 disables it.
+func (c *Config) ProxyIdleTimeout() time.Duration {
+	return durationOr(c.Proxy.IdleTimeout, 2*time.Minute)
+}
+
+// durationOr parses a non-negative duration, falling back to def when it
+// is unset or malformed.
+func durationOr(s string, def time.Duration) time.Duration {
+	if s == "" {
+		return def
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return def
+	}
+	return d
+}
+
+// Discover returns the config file Load would use when given no path, or
+// "" if there is none.
+func Discover() string {
 	if _, err := os.Stat("miser.toml"); err == nil {
 		return "miser.toml"
 	}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const profileTOML = `
+[proxy]
+port = 8080
+target = "https://api.anthropic.com"
+
+[models.claude-sonnet-4-6]
+input_per_mtok = 3.0
+output_per_mtok = 15.0
+
+[models.claude-opus-4-6]
+input_per_mtok = 5.0
+
+[profile.work.proxy]
+target = "https://gateway.example.com"
+api_key = "sk-work"
+
+[profile.work.models.claude-sonnet-4-6]
+input_per_mtok = 2.0
+
+[profile.work.history]
+path = "/tmp/work.jsonl"
+
+[profile.personal.proxy]
+port = 9090
+`
+
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "miser.toml")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadProfile(t *testing.T) {
+	path := writeConfig(t, profileTOML)
+
+	cfg, err := LoadProfile(path, "work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Profile != "work" {
+		t.Errorf("Profile = %q", cfg.Profile)
+	}
+	if cfg.Proxy.Target != "https://gateway.example.com" || cfg.Proxy.APIKey != "sk-work" {
+		t.Errorf("proxy = %+v", cfg.Proxy)
+	}
+	if cfg.Proxy.Port != 8080 {
+		t.Errorf("port = %d, want the top-level 8080", cfg.Proxy.Port)
+	}
+	if got := cfg.Models["claude-sonnet-4-6"].InputPerMTok; got != 2.0 {
+		t.Errorf("sonnet input = %v, want the profile's 2.0", got)
+	}
+	if got := cfg.Models["claude-sonnet-4-6"].OutputPerMTok; got != 15.0 {
+		t.Errorf("sonnet output = %v, want the top-level 15.0 the profile left alone", got)
+	}
+	if got := cfg.Models["claude-opus-4-6"].InputPerMTok; got != 5.0 {
+		t.Errorf("opus input = %v, want the top-level 5.0", got)
+	}
+	if cfg.History.Path != "/tmp/work.jsonl" || !cfg.History.Enabled {
+		t.Errorf("history = %+v", cfg.History)
+	}
+
+	cfg, err = Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Profile != "" || cfg.Proxy.APIKey != "" || cfg.Proxy.Port != 8080 {
+		t.Errorf("without a profile: %q %+v", cfg.Profile, cfg.Proxy)
+	}
+}
+
+func TestLoadProfile_Unknown(t *testing.T) {
+	path := writeConfig(t, profileTOML)
+	_, err := LoadProfile(path, "home")
+	if err == nil || !strings.Contains(err.Error(), "defined: personal, work") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestLoad_ExpandsEnv(t *testing.T) {
+	t.Setenv("MISER_TEST_KEY", "sk-secret")
+	t.Setenv("MISER_TEST_HOST", "gateway.example.com")
+	path := writeConfig(t, `
+[proxy]
+target = "https://${MISER_TEST_HOST}/anthropic"
+api_key = "${MISER_TEST_KEY}"
+
+[history]
+path = "${MISER_TEST_UNSET:-/tmp/history.jsonl}"
+
+[capture]
+redact = ['token=$${literal}', 'end$']
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Proxy.Target != "https://gateway.example.com/anthropic" {
+		t.Errorf("target = %q", cfg.Proxy.Target)
+	}
+	if cfg.Proxy.APIKey != "sk-secret" {
+		t.Errorf("api_key = %q", cfg.Proxy.APIKey)
+	}
+	if cfg.History.Path != "/tmp/history.jsonl" {
+		t.Errorf("history path = %q", cfg.History.Path)
+	}
+	if got := cfg.Capture.Redact; len(got) != 2 || got[0] != "token=${literal}" || got[1] != "end$" {
+		t.Errorf("redact = %q", got)
+	}
+}
+
+func TestLoad_ExpandUnset(t *testing.T) {
+	path := writeConfig(t, `
+[profile.work.proxy]
+api_key = "${MISER_TEST_UNSET}"
+`)
+	if _, err := Load(path); err != nil {
+		t.Fatalf("unused profile should not be expanded: %v", err)
+	}
+	_, err := LoadProfile(path, "work")
+	if err == nil || !strings.Contains(err.Error(), "proxy.api_key: ${MISER_TEST_UNSET} is not set") {
+		t.Errorf("err = %v", err)
+	}
+}
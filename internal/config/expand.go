@@ -0,0 +1,108 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// expandEnv replaces ${VAR} references in every string setting of cfg
+// with the variable's value, so secrets can live in the environment.
+// ${VAR:-default} falls back to default when VAR is unset or empty, and
+// $${ is a literal "${". Referencing an unset variable without a default
+// is an error naming the setting.
+func expandEnv(cfg *Config) error {
+	return expandValue(reflect.ValueOf(cfg).Elem(), "")
+}
+
+func expandValue(v reflect.Value, key string) error {
+	switch v.Kind() {
+	case reflect.String:
+		s, err := expand(v.String())
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		v.SetString(s)
+	case reflect.Pointer:
+		if !v.IsNil() {
+			return expandValue(v.Elem(), key)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := range t.NumField() {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("toml"), ",")
+			if !f.IsExported() || name == "" || name == "-" {
+				continue
+			}
+			if err := expandValue(v.Field(i), join(key, name)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for i := range v.Len() {
+			if err := expandValue(v.Index(i), fmt.Sprintf("%s[%d]", key, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		// Map elements aren't addressable: expand a copy and store it back.
+		for _, k := range v.MapKeys() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(k))
+			if err := expandValue(elem, join(key, fmt.Sprint(k))); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, elem)
+		}
+	}
+	return nil
+}
+
+func join(key, name string) string {
+	if key == "" {
+		return name
+	}
+	return key + "." + name
+}
+
+// expand substitutes the ${…} references in s.
+func expand(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1] + "${")
+			s = s[i+2:]
+			continue
+		}
+		b.WriteString(s[:i])
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated %q", s[i:])
+		}
+		ref := s[i+2 : i+end]
+		s = s[i+end+1:]
+
+		name, def, hasDef := strings.Cut(ref, ":-")
+		if name == "" {
+			return "", fmt.Errorf("empty variable name in ${%s}", ref)
+		}
+		val, ok := os.LookupEnv(name)
+		switch {
+		case hasDef && val == "":
+			val = def
+		case !ok:
+			return "", fmt.Errorf("${%s} is not set", name)
+		}
+		b.WriteString(val)
+	}
+}
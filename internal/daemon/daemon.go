@@ -0,0 +1,166 @@
+// Package daemon runs miser in the background and tracks the background
+// process through a pid file.
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// childEnv marks the re-executed background process, so it serves instead
+// of daemonizing again.
+const childEnv = "MISER_DAEMON_CHILD"
+
+// ErrNotRunning is returned when the pid file is missing or names a
+// process that has exited.
+var ErrNotRunning = errors.New("miser is not running")
+
+// IsChild reports whether this process was started by Start.
+func IsChild() bool {
+	return os.Getenv(childEnv) == "1"
+}
+
+// DefaultPidPath returns $XDG_STATE_HOME/miser/miser.pid, falling back to
+// ~/.local/state/miser/miser.pid.
+func DefaultPidPath() string {
+	return filepath.Join(StateDir(), "miser.pid")
+}
+
+// DefaultLogPath returns the log file next to DefaultPidPath.
+func DefaultLogPath() string {
+	return filepath.Join(StateDir(), "miser.log")
+}
+
+// StateDir returns the directory for miser's runtime files:
+// $XDG_STATE_HOME/miser, falling back to ~/.local/state/miser.
+func StateDir() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "."
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "miser")
+}
+
+// Running returns the pid recorded at pidPath if that process is alive.
+// A stale pid file is removed.
+func Running(pidPath string) (int, error) {
+	data, err := os.ReadFile(pidPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, ErrNotRunning
+	}
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("malformed pid file %s", pidPath)
+	}
+	if !alive(pid) {
+		os.Remove(pidPath)
+		return 0, ErrNotRunning
+	}
+	return pid, nil
+}
+
+// Start re-executes the current command line in a new session, detached
+// from the terminal, with output appended to logPath, and records its pid
+// at pidPath. It waits up to wait for ready to report true, and fails if
+// the process exits first.
+func Start(pidPath, logPath string, wait time.Duration, ready func() bool) (int, error) {
+	if pid, err := Running(pidPath); err == nil {
+		return 0, fmt.Errorf("miser is already running (pid %d)", pid)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range []string{pidPath, logPath} {
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			return 0, err
+		}
+	}
+	logf, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("opening log %s: %w", logPath, err)
+	}
+	defer logf.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), childEnv+"=1")
+	cmd.Stdout = logf
+	cmd.Stderr = logf
+	if err := detach(cmd); err != nil {
+		return 0, err
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	pid := cmd.Process.Pid
+	if err := os.WriteFile(pidPath, []byte(strconv.Itoa(pid)+"\n"), 0o644); err != nil {
+		cmd.Process.Kill()
+		return 0, fmt.Errorf("writing pid file: %w", err)
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	deadline := time.After(wait)
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case <-exited:
+			os.Remove(pidPath)
+			return 0, fmt.Errorf("miser exited during startup; see %s", logPath)
+		case <-deadline:
+			return pid, fmt.Errorf("miser (pid %d) did not become ready within %s; see %s", pid, wait, logPath)
+		case <-tick.C:
+			if ready() {
+				return pid, nil
+			}
+		}
+	}
+}
+
+// Stop asks the process recorded at pidPath to shut down and waits up to
+// timeout for it to exit.
+func Stop(pidPath string, timeout time.Duration) (int, error) {
+	pid, err := Running(pidPath)
+	if err != nil {
+		return 0, err
+	}
+	if err := terminate(pid); err != nil {
+		return pid, fmt.Errorf("stopping pid %d: %w", pid, err)
+	}
+	deadline := time.Now().Add(timeout)
+	for alive(pid) {
+		if time.Now().After(deadline) {
+			return pid, fmt.Errorf("pid %d did not exit within %s", pid, timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	os.Remove(pidPath)
+	return pid, nil
+}
+
+// Release removes pidPath if it still names this process. The background
+// process calls it on shutdown.
+func Release(pidPath string) {
+	data, err := os.ReadFile(pidPath)
+	if err == nil && strings.TrimSpace(string(data)) == strconv.Itoa(os.Getpid()) {
+		os.Remove(pidPath)
+	}
+}
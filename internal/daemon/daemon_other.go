@@ -0,0 +1,16 @@
+//go:build !unix
+
+package daemon
+
+import (
+	"errors"
+	"os/exec"
+)
+
+var errUnsupported = errors.New("daemon mode is not supported on this platform")
+
+func detach(*exec.Cmd) error { return errUnsupported }
+
+func alive(int) bool { return false }
+
+func terminate(int) error { return errUnsupported }
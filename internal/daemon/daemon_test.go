@@ -0,0 +1,49 @@
+//go:build unix
+
+package daemon
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestRunning(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "miser.pid")
+
+	if _, err := Running(path); !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("missing pid file: err = %v, want ErrNotRunning", err)
+	}
+
+	os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644)
+	if pid, err := Running(path); err != nil || pid != os.Getpid() {
+		t.Fatalf("Running = %d, %v; want %d", pid, err, os.Getpid())
+	}
+
+	// Pids are at most 2^22 on Linux, so this one cannot be alive.
+	os.WriteFile(path, []byte("99999999\n"), 0o644)
+	if _, err := Running(path); !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("stale pid file: err = %v, want ErrNotRunning", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Error("stale pid file was not removed")
+	}
+}
+
+func TestRelease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "miser.pid")
+
+	os.WriteFile(path, []byte("1\n"), 0o644)
+	Release(path)
+	if _, err := os.Stat(path); err != nil {
+		t.Error("Release removed another process's pid file")
+	}
+
+	os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644)
+	Release(path)
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Error("Release kept this process's pid file")
+	}
+}
@@ -0,0 +1,178 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"miser/internal/redact"
+	"miser/internal/tracker"
+)
+
+// Style shapes CSV files and the names WriteFile picks, and masks what
+// every format writes. Like the number format it is process-wide: set
+// once from [export] and [redact] via Apply and used by every export,
+// whether to the TUI, the CLI, the API or an upload.
+type Style struct {
+	Filename  string   // WriteFile's name template; see Filename
+	Delimiter rune     // CSV field separator
+	Columns   []string // CSV columns, in order; see Columns
+
+	// Redact masks the requests' free text before it is written; nil
+	// masks API keys only.
+	Redact *redact.Redactor
+}
+
+// DefaultStyle is the fixed layout CSV exports had before it was
+// configurable.
+func DefaultStyle() Style {
+	return Style{
+		Filename:  DefaultFilename,
+		Delimiter: ',',
+		Columns: []string{"time", "model", "input_tokens", "output_tokens", "cache_read", "cache_write",
+			"cost", "latency_s", "status", "original_bytes", "compressed_bytes"},
+	}
+}
+
+var current = struct {
+	mu sync.RWMutex
+	s  Style
+}{s: DefaultStyle()}
+
+// Apply replaces the current style. Zero fields keep their defaults.
+func Apply(s Style) {
+	d := DefaultStyle()
+	if s.Filename == "" {
+		s.Filename = d.Filename
+	}
+	if s.Delimiter == 0 {
+		s.Delimiter = d.Delimiter
+	}
+	if len(s.Columns) == 0 {
+		s.Columns = d.Columns
+	}
+	current.mu.Lock()
+	current.s = s
+	current.mu.Unlock()
+}
+
+// Current returns the style in effect.
+func Current() Style {
+	current.mu.RLock()
+	defer current.mu.RUnlock()
+	return current.s
+}
+
+// ParseDelimiter accepts a single character, or "tab"; empty means ','.
+func ParseDelimiter(s string) (rune, error) {
+	if s == "" {
+		return ',', nil
+	}
+	if strings.EqualFold(s, "tab") {
+		return '\t', nil
+	}
+	r, n := utf8.DecodeRuneInString(s)
+	if n != len(s) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("delimiter %q is not a single character other than a quote or newline", s)
+	}
+	return r, nil
+}
+
+// column is one field a CSV export can hold.
+type column struct {
+	name  string // as in the JSON export and the config
+	title string // header
+	value func(tracker.Request) string
+}
+
+var columns = []column{
+	{"id", "ID", func(r tracker.Request) string { return strconv.Itoa(r.ID) }},
+	{"time", "Time", func(r tracker.Request) string { return r.Timestamp.Format(time.RFC3339) }},
+	{"model", "Model", func(r tracker.Request) string { return r.Model }},
+	{"project", "Project", func(r tracker.Request) string { return r.Project }},
+	{"client", "Client", func(r tracker.Request) string { return r.Client }},
+	{"key", "Key", func(r tracker.Request) string { return r.Key }},
+	{"node", "Node", func(r tracker.Request) string { return r.Node }},
+	{"input_tokens", "Input Tokens", func(r tracker.Request) string { return strconv.Itoa(r.InputTokens) }},
+	{"output_tokens", "Output Tokens", func(r tracker.Request) string { return strconv.Itoa(r.OutputTokens) }},
+	{"tool_tokens", "Tool Tokens", func(r tracker.Request) string { return strconv.Itoa(r.ToolTokens) }},
+	{"cache_read", "Cache Read", func(r tracker.Request) string { return strconv.Itoa(r.CacheRead) }},
+	{"cache_write", "Cache Write", func(r tracker.Request) string { return strconv.Itoa(r.CacheWrite) }},
+	{"cost", "Cost", func(r tracker.Request) string { return fmt.Sprintf("%.6f", r.Cost) }},
+	{"latency_s", "Latency (s)", func(r tracker.Request) string { return fmt.Sprintf("%.3f", r.Latency.Seconds()) }},
+	{"status", "Status", func(r tracker.Request) string { return strconv.Itoa(r.StatusCode) }},
+	{"error", "Error", func(r tracker.Request) string { return r.Error }},
+	{"request_id", "Request ID", func(r tracker.Request) string { return r.RequestID }},
+	{"original_bytes", "Original Bytes", func(r tracker.Request) string { return strconv.Itoa(r.OriginalSize) }},
+	{"compressed_bytes", "Compressed Bytes", func(r tracker.Request) string { return strconv.Itoa(r.CompressedSize) }},
+}
+
+// Columns lists the names a Style may use, in their default order.
+func Columns() []string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.name
+	}
+	return names
+}
+
+// CheckColumns reports the first unknown or repeated column name.
+func CheckColumns(names []string) error {
+	seen := make(map[string]bool)
+	for _, n := range names {
+		if _, ok := lookupColumn(n); !ok {
+			return fmt.Errorf("unknown column %q (want %s)", n, strings.Join(Columns(), ", "))
+		}
+		if seen[n] {
+			return fmt.Errorf("column %q is listed twice", n)
+		}
+		seen[n] = true
+	}
+	return nil
+}
+
+func lookupColumn(name string) (column, bool) {
+	for _, c := range columns {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return column{}, false
+}
+
+func writeCSV(out io.Writer, requests []tracker.Request) error {
+	s := Current()
+	cols := make([]column, 0, len(s.Columns))
+	for _, n := range s.Columns {
+		c, ok := lookupColumn(n)
+		if !ok {
+			return fmt.Errorf("unknown CSV column %q", n)
+		}
+		cols = append(cols, c)
+	}
+
+	w := csv.NewWriter(out)
+	w.Comma = s.Delimiter
+	record := make([]string, len(cols))
+	for i, c := range cols {
+		record[i] = c.title
+	}
+	if err := w.Write(record); err != nil {
+		return err
+	}
+	for _, r := range requests {
+		for i, c := range cols {
+			record[i] = c.value(r)
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
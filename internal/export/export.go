@@ -0,0 +1,243 @@
+// Package export writes recorded requests to files in several formats.
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"miser/internal/format"
+	"miser/internal/tracker"
+)
+
+type Format string
+
+const (
+	CSV      Format = "csv"
+	JSON     Format = "json"
+	JSONL    Format = "jsonl"
+	NDJSON   Format = "ndjson"
+	Markdown Format = "md"
+	SQLite   Format = "sqlite"
+)
+
+// Formats lists every supported format in display order.
+var Formats = []Format{CSV, JSON, JSONL, NDJSON, Markdown, SQLite}
+
+// ParseFormat accepts a format name or file extension, case-insensitively.
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(s, ".")) {
+	case "csv":
+		return CSV, nil
+	case "json":
+		return JSON, nil
+	case "jsonl":
+		return JSONL, nil
+	case "ndjson":
+		return NDJSON, nil
+	case "md", "markdown":
+		return Markdown, nil
+	case "sqlite", "sqlite3", "db":
+		return SQLite, nil
+	}
+	return "", fmt.Errorf("unknown export format %q (want csv, json, jsonl, ndjson, md or sqlite)", s)
+}
+
+func (f Format) String() string {
+	switch f {
+	case CSV:
+		return "CSV"
+	case JSON:
+		return "JSON"
+	case JSONL:
+		return "JSONL"
+	case NDJSON:
+		return "NDJSON (full)"
+	case Markdown:
+		return "Markdown"
+	case SQLite:
+		return "SQLite"
+	}
+	return string(f)
+}
+
+// ContentType is the MIME type of the format's files.
+func (f Format) ContentType() string {
+	switch f {
+	case CSV:
+		return "text/csv"
+	case JSON:
+		return "application/json"
+	case JSONL, NDJSON:
+		return "application/x-ndjson"
+	case Markdown:
+		return "text/markdown"
+	case SQLite:
+		return "application/vnd.sqlite3"
+	}
+	return "application/octet-stream"
+}
+
+// DefaultFilename is the name WriteFile gives files unless the style sets
+// another.
+const DefaultFilename = "miser-export-{date}-{time}.{ext}"
+
+// Filename fills in a file name template: {date} is the local date as
+// 2006-01-02, {time} the local time as 150405, {host} the host name and
+// {ext} the format's extension.
+func Filename(template string, now time.Time, f Format) string {
+	host, _ := os.Hostname()
+	return strings.NewReplacer(
+		"{date}", now.Format("2006-01-02"),
+		"{time}", now.Format("150405"),
+		"{host}", host,
+		"{ext}", string(f),
+	).Replace(template)
+}
+
+// WriteFile writes requests to a file in dir named by the style's template
+// and returns its path.
+func WriteFile(dir string, f Format, requests []tracker.Request) (string, error) {
+	if dir == "" {
+		dir = "."
+	}
+	path := filepath.Join(dir, Filename(Current().Filename, time.Now(), f))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("creating export directory: %w", err)
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := Write(out, f, requests); err != nil {
+		out.Close()
+		os.Remove(path)
+		return "", err
+	}
+	return path, out.Close()
+}
+
+// Write encodes requests to w in the given format, masked by the
+// style's Redact.
+func Write(w io.Writer, f Format, requests []tracker.Request) error {
+	requests = Current().Redact.Requests(requests)
+	switch f {
+	case CSV:
+		return writeCSV(w, requests)
+	case JSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		rows := make([]row, len(requests))
+		for i, r := range requests {
+			rows[i] = newRow(r)
+		}
+		return enc.Encode(rows)
+	case JSONL:
+		enc := json.NewEncoder(w)
+		for _, r := range requests {
+			if err := enc.Encode(newRow(r)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case NDJSON:
+		return writeNDJSON(w, requests)
+	case Markdown:
+		return writeMarkdown(w, requests)
+	case SQLite:
+		return writeSQLite(w, requests)
+	}
+	return fmt.Errorf("unknown export format %q", f)
+}
+
+// row is the flat record written by the JSON-based formats.
+type row struct {
+	Time            time.Time `json:"time"`
+	Model           string    `json:"model"`
+	Project         string    `json:"project,omitempty"`
+	Client          string    `json:"client,omitempty"`
+	Key             string    `json:"key,omitempty"`
+	Node            string    `json:"node,omitempty"`
+	InputTokens     int       `json:"input_tokens"`
+	OutputTokens    int       `json:"output_tokens"`
+	ToolTokens      int       `json:"tool_tokens,omitempty"` // of the output, in tool call arguments
+	CacheRead       int       `json:"cache_read"`
+	CacheWrite      int       `json:"cache_write"`
+	Cost            float64   `json:"cost"`
+	LatencySeconds  float64   `json:"latency_s"`
+	Status          int       `json:"status"`
+	Error           string    `json:"error,omitempty"`
+	RequestID       string    `json:"request_id,omitempty"` // upstream's
+	OriginalBytes   int       `json:"original_bytes"`
+	CompressedBytes int       `json:"compressed_bytes"`
+}
+
+func newRow(r tracker.Request) row {
+	return row{
+		Time:            r.Timestamp,
+		Model:           r.Model,
+		Project:         r.Project,
+		Client:          r.Client,
+		Key:             r.Key,
+		Node:            r.Node,
+		InputTokens:     r.InputTokens,
+		OutputTokens:    r.OutputTokens,
+		ToolTokens:      r.ToolTokens,
+		CacheRead:       r.CacheRead,
+		CacheWrite:      r.CacheWrite,
+		Cost:            r.Cost,
+		LatencySeconds:  r.Latency.Seconds(),
+		Status:          r.StatusCode,
+		Error:           r.Error,
+		RequestID:       r.RequestID,
+		OriginalBytes:   r.OriginalSize,
+		CompressedBytes: r.CompressedSize,
+	}
+}
+
+func writeMarkdown(w io.Writer, requests []tracker.Request) error {
+	var b strings.Builder
+	b.WriteString("| Time | Model | Input | Output | Cache R | Cache W | Cost | Latency | Status |\n")
+	b.WriteString("|---|---|--:|--:|--:|--:|--:|--:|---|\n")
+	for _, r := range requests {
+		status := strconv.Itoa(r.StatusCode)
+		if r.Error != "" {
+			status = "ERR: " + strings.ReplaceAll(r.Error, "|", `\|`)
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s | %s | %.3fs | %s |\n",
+			r.Timestamp.Format(time.RFC3339), r.Model,
+			format.Int(r.InputTokens), format.Int(r.OutputTokens),
+			format.Int(r.CacheRead), format.Int(r.CacheWrite),
+			format.Dollars(r.Cost, 6), r.Latency.Seconds(), status)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// RequestJSON encodes a single request using the JSON export schema.
+func RequestJSON(r tracker.Request) ([]byte, error) {
+	return json.MarshalIndent(newRow(r), "", "  ")
+}
+
+// Summary renders r as one line of shell-safe key=value pairs, suitable
+// for pasting into a bug report or a terminal.
+func Summary(r tracker.Request) string {
+	status := strconv.Itoa(r.StatusCode)
+	if r.Error != "" {
+		status = strconv.Quote("ERR: " + r.Error)
+	}
+	line := fmt.Sprintf("time=%s model=%s input_tokens=%d output_tokens=%d cache_read=%d cache_write=%d cost=%.6f latency_s=%.3f status=%s",
+		r.Timestamp.Format(time.RFC3339), r.Model,
+		r.InputTokens, r.OutputTokens, r.CacheRead, r.CacheWrite,
+		r.Cost, r.Latency.Seconds(), status)
+	if r.RequestID != "" {
+		line += " request_id=" + strconv.Quote(r.RequestID)
+	}
+	return line
+}
@@ -0,0 +1,139 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"miser/internal/tracker"
+)
+
+var sample = []tracker.Request{
+	{Timestamp: time.Date(2025, 5, 1, 9, 0, 0, 0, time.UTC), Model: "claude-sonnet-4-6", InputTokens: 100, OutputTokens: 20, Cost: 0.0006, Latency: 1200 * time.Millisecond, StatusCode: 200, RequestID: "req_011CUa"},
+	{Timestamp: time.Date(2025, 5, 1, 9, 1, 0, 0, time.UTC), Model: "claude-opus-4-6", Error: "upstream | 529"},
+}
+
+func TestParseFormat(t *testing.T) {
+	for in, want := range map[string]Format{"csv": CSV, "JSON": JSON, ".jsonl": JSONL, "ndjson": NDJSON, "markdown": Markdown} {
+		if got, err := ParseFormat(in); err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseFormat("xlsx"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestWrite_JSONL(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, JSONL, sample); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+	var r row
+	if err := json.Unmarshal([]byte(lines[0]), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Model != "claude-sonnet-4-6" || r.LatencySeconds != 1.2 {
+		t.Errorf("unexpected row: %+v", r)
+	}
+}
+
+func TestWrite_NDJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, NDJSON, sample); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+	var r struct {
+		LatencyMs float64 `json:"latency_ms"`
+		Usage     struct {
+			InputTokens int `json:"input_tokens"`
+		}
+		Error     string
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.LatencyMs != 1200 || r.Usage.InputTokens != 100 || r.RequestID != "req_011CUa" {
+		t.Errorf("unexpected record: %s", lines[0])
+	}
+	if json.Unmarshal([]byte(lines[1]), &r); r.Error != "upstream | 529" {
+		t.Errorf("unexpected record: %s", lines[1])
+	}
+}
+
+func TestWrite_MarkdownEscapesPipes(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, Markdown, sample); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `upstream \| 529`) {
+		t.Errorf("pipe in error not escaped:\n%s", buf.String())
+	}
+}
+
+func TestWrite_SQLite(t *testing.T) {
+	if f, err := ParseFormat(".sqlite3"); err != nil || f != SQLite {
+		t.Fatalf("ParseFormat(.sqlite3) = %q, %v", f, err)
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, SQLite, sample); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("SQLite format 3\x00")) {
+		t.Fatal("not a SQLite database")
+	}
+	for _, want := range []string{"CREATE TABLE requests", "CREATE INDEX requests_time", "CREATE VIEW models", "upstream | 529", "req_011CUa"} {
+		if !bytes.Contains(buf.Bytes(), []byte(want)) {
+			t.Errorf("database lacks %q", want)
+		}
+	}
+}
+
+func TestWrite_CSVStyle(t *testing.T) {
+	Apply(Style{Delimiter: ';', Columns: []string{"model", "error", "cost"}})
+	defer Apply(Style{})
+
+	var buf bytes.Buffer
+	if err := Write(&buf, CSV, sample); err != nil {
+		t.Fatal(err)
+	}
+	want := "Model;Error;Cost\nclaude-sonnet-4-6;;0.000600\nclaude-opus-4-6;upstream | 529;0.000000\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+	if err := CheckColumns([]string{"model", "prompt"}); err == nil {
+		t.Error("expected error for unknown column")
+	}
+	if _, err := ParseDelimiter(`"`); err == nil {
+		t.Error("expected error for a quote delimiter")
+	}
+}
+
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestWrite_CSVReportsWriteErrors(t *testing.T) {
+	if err := Write(failWriter{}, CSV, sample); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("Write = %v, want the writer's error", err)
+	}
+}
+
+func TestFilename(t *testing.T) {
+	now := time.Date(2026, 1, 31, 9, 5, 7, 0, time.Local)
+	if got := Filename(DefaultFilename, now, CSV); got != "miser-export-2026-01-31-090507.csv" {
+		t.Errorf("Filename = %s", got)
+	}
+}
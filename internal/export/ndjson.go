@@ -0,0 +1,153 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"time"
+
+	"miser/internal/tracker"
+)
+
+// fullRecord is one NDJSON line: every recorded field, with latency in
+// milliseconds and token counts grouped as usage. Unlike row, nothing is
+// flattened or dropped, so a pipeline sees what the tracker saw.
+type fullRecord struct {
+	ID          int          `json:"id"`
+	Time        time.Time    `json:"time"`
+	Model       string       `json:"model"`
+	Project     string       `json:"project,omitempty"`
+	Client      string       `json:"client,omitempty"`
+	Key         string       `json:"key,omitempty"` // virtual key name
+	Status      int          `json:"status"`        // 0 when the request never reached upstream
+	Error       string       `json:"error,omitempty"`
+	RequestID   string       `json:"request_id,omitempty"` // upstream's request-id
+	LatencyMs   float64      `json:"latency_ms"`
+	Cost        float64      `json:"cost"`
+	Usage       usage        `json:"usage"`
+	Compression *compression `json:"compression,omitempty"`
+	Network     *network     `json:"network,omitempty"`  // upstream connection timings
+	Prompt      string       `json:"prompt,omitempty"`   // captured, redacted preview
+	Response    string       `json:"response,omitempty"` // captured, redacted preview
+	From        string       `json:"from,omitempty"`
+	CountedBy   string       `json:"counted_by,omitempty"`
+	Node        string       `json:"node,omitempty"`
+
+	// EstimatedInput is the local input estimate, on a request whose
+	// reported input was off it.
+	EstimatedInput int `json:"estimated_input,omitempty"`
+
+	// EstimatedUsage marks usage miser estimated because upstream
+	// reported none.
+	EstimatedUsage bool `json:"estimated_usage,omitempty"`
+
+	ContextUsed float64 `json:"context_used,omitempty"` // fraction of the context window
+
+	Conversation string `json:"conversation,omitempty"` // see miser cache
+	Messages     int    `json:"messages,omitempty"`
+
+	ToolTokens int `json:"tool_tokens,omitempty"` // of the output, in tool call arguments
+
+	Race *tracker.Race `json:"race,omitempty"` // see miser race
+	Tags []string      `json:"tags,omitempty"` // from [[hook]] rules
+
+	Degraded   []string `json:"degraded,omitempty"`    // by the OpenAI conversion
+	CappedFrom int      `json:"capped_from,omitempty"` // max_tokens asked for, before [proxy] max_tokens
+	Projected  float64  `json:"projected,omitempty"`   // projected cost, over [budget] max_request
+	Injected   []string `json:"injected,omitempty"`    // [[inject]] fragments
+
+	Truncated bool           `json:"truncated,omitempty"` // the stream ended before its final usage
+	Retry     *tracker.Retry `json:"retry,omitempty"`     // links the attempts of a retried request
+
+	FallbackFrom string `json:"fallback_from,omitempty"` // the model asked for, before an overload fallback
+
+	RateLimit *tracker.RateLimit `json:"rate_limit,omitempty"` // upstream's, as of the response
+}
+
+type usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	CacheRead    int `json:"cache_read"`
+	CacheWrite   int `json:"cache_write"`
+}
+
+// network is tracker.Network with durations in milliseconds.
+type network struct {
+	Reused      bool    `json:"reused"`
+	DNSMs       float64 `json:"dns_ms,omitempty"`
+	ConnectMs   float64 `json:"connect_ms,omitempty"`
+	TLSMs       float64 `json:"tls_ms,omitempty"`
+	FirstByteMs float64 `json:"first_byte_ms,omitempty"`
+}
+
+type compression struct {
+	OriginalBytes   int `json:"original_bytes"`
+	CompressedBytes int `json:"compressed_bytes"`
+}
+
+func newFullRecord(r tracker.Request) fullRecord {
+	rec := fullRecord{
+		ID:        r.ID,
+		Time:      r.Timestamp,
+		Model:     r.Model,
+		Project:   r.Project,
+		Client:    r.Client,
+		Key:       r.Key,
+		From:      r.From,
+		CountedBy: r.CountedBy,
+		Node:      r.Node,
+		Status:    r.StatusCode,
+		Error:     r.Error,
+		RequestID: r.RequestID,
+		LatencyMs: ms(r.Latency),
+		Cost:      r.Cost,
+		Usage: usage{
+			InputTokens:  r.InputTokens,
+			OutputTokens: r.OutputTokens,
+			CacheRead:    r.CacheRead,
+			CacheWrite:   r.CacheWrite,
+		},
+		Prompt:   r.Prompt,
+		Response: r.Response,
+
+		EstimatedInput: r.EstimatedInput,
+		EstimatedUsage: r.EstimatedUsage,
+		ContextUsed:    r.ContextUsed,
+		Conversation:   r.Conversation,
+		Messages:       r.Messages,
+		ToolTokens:     r.ToolTokens,
+		Race:           r.Race,
+		Tags:           r.Tags,
+		Degraded:       r.Degraded,
+		CappedFrom:     r.CappedFrom,
+		Projected:      r.Projected,
+		Injected:       r.Injected,
+		Truncated:      r.Truncated,
+		Retry:          r.Retry,
+		RateLimit:      r.RateLimit,
+		FallbackFrom:   r.FallbackFrom,
+	}
+	if r.OriginalSize > 0 {
+		rec.Compression = &compression{r.OriginalSize, r.CompressedSize}
+	}
+	if n := r.Network; n != (tracker.Network{}) {
+		rec.Network = &network{n.Reused, ms(n.DNS), ms(n.Connect), ms(n.TLS), ms(n.FirstByte)}
+	}
+	return rec
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// writeNDJSON streams one fullRecord per line.
+func writeNDJSON(w io.Writer, requests []tracker.Request) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, r := range requests {
+		if err := enc.Encode(newFullRecord(r)); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
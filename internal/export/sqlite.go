@@ -0,0 +1,121 @@
+package export
+
+import (
+	"io"
+
+	"miser/internal/sqlite"
+	"miser/internal/tracker"
+)
+
+// sqliteTime is how times are stored: UTC, fixed width so text order is
+// time order, and understood by SQLite's date functions.
+const sqliteTime = "2006-01-02T15:04:05.000Z"
+
+// writeSQLite writes a standalone database with a requests table using
+// the JSON column names, indexed by time and by model, plus models, days,
+// projects, clients, keys and nodes views for the usual questions.
+func writeSQLite(w io.Writer, requests []tracker.Request) error {
+	rows := make([][]any, len(requests))
+	for i, r := range requests {
+		var errText, requestID, project, client, key, node any
+		if r.Error != "" {
+			errText = r.Error
+		}
+		if r.RequestID != "" {
+			requestID = r.RequestID
+		}
+		if r.Project != "" {
+			project = r.Project
+		}
+		if r.Client != "" {
+			client = r.Client
+		}
+		if r.Key != "" {
+			key = r.Key
+		}
+		if r.Node != "" {
+			node = r.Node
+		}
+		rows[i] = []any{
+			r.ID,
+			r.Timestamp.UTC().Format(sqliteTime),
+			r.Model,
+			r.InputTokens,
+			r.OutputTokens,
+			r.CacheRead,
+			r.CacheWrite,
+			r.Cost,
+			r.Latency.Seconds(),
+			r.StatusCode,
+			errText,
+			r.OriginalSize,
+			r.CompressedSize,
+			project,
+			client,
+			key,
+			node,
+			requestID,
+		}
+	}
+	db := sqlite.Database{
+		Tables: []sqlite.Table{{
+			Name: "requests",
+			SQL: `CREATE TABLE requests (
+  id INTEGER NOT NULL,
+  time TEXT NOT NULL, -- UTC, e.g. 2026-01-31T09:00:00.000Z
+  model TEXT NOT NULL,
+  input_tokens INTEGER NOT NULL,
+  output_tokens INTEGER NOT NULL,
+  cache_read INTEGER NOT NULL,
+  cache_write INTEGER NOT NULL,
+  cost REAL NOT NULL, -- dollars
+  latency_s REAL NOT NULL,
+  status INTEGER NOT NULL, -- 0 when the request never reached upstream
+  error TEXT,
+  original_bytes INTEGER NOT NULL,
+  compressed_bytes INTEGER NOT NULL,
+  project TEXT, -- NULL when the request named none
+  client TEXT, -- the sending process or X-Miser-Client; NULL when unknown
+  key TEXT, -- name of the virtual key used; NULL for none
+  node TEXT, -- the cluster node that proxied it; NULL for this instance
+  request_id TEXT -- upstream's request-id; NULL when it sent none
+)`,
+			Rows: rows,
+			Indexes: []sqlite.Index{
+				{Name: "requests_time", SQL: "CREATE INDEX requests_time ON requests (time)", Columns: []int{1}},
+				{Name: "requests_model", SQL: "CREATE INDEX requests_model ON requests (model, time)", Columns: []int{2, 1}},
+			},
+		}},
+		Views: []sqlite.View{
+			{Name: "models", SQL: `CREATE VIEW models AS
+SELECT model, count(*) AS requests, sum(error IS NOT NULL OR status >= 400) AS errors,
+  sum(input_tokens) AS input_tokens, sum(output_tokens) AS output_tokens,
+  sum(cache_read) AS cache_read, sum(cache_write) AS cache_write, sum(cost) AS cost
+FROM requests GROUP BY model ORDER BY cost DESC`},
+			{Name: "days", SQL: `CREATE VIEW days AS
+SELECT date(time, 'localtime') AS day, count(*) AS requests,
+  sum(error IS NOT NULL OR status >= 400) AS errors, sum(cost) AS cost
+FROM requests GROUP BY day ORDER BY day`},
+			{Name: "projects", SQL: `CREATE VIEW projects AS
+SELECT project, count(*) AS requests, sum(error IS NOT NULL OR status >= 400) AS errors,
+  sum(input_tokens) AS input_tokens, sum(output_tokens) AS output_tokens, sum(cost) AS cost
+FROM requests GROUP BY project ORDER BY cost DESC`},
+			{Name: "clients", SQL: `CREATE VIEW clients AS
+SELECT client, count(*) AS requests, sum(error IS NOT NULL OR status >= 400) AS errors,
+  sum(input_tokens) AS input_tokens, sum(output_tokens) AS output_tokens, sum(cost) AS cost
+FROM requests GROUP BY client ORDER BY cost DESC`},
+			{Name: "keys", SQL: `CREATE VIEW keys AS
+SELECT key, count(*) AS requests, sum(error IS NOT NULL OR status >= 400) AS errors,
+  sum(input_tokens) AS input_tokens, sum(output_tokens) AS output_tokens, sum(cost) AS cost,
+  max(time) AS last_used
+FROM requests WHERE key IS NOT NULL GROUP BY key ORDER BY cost DESC`},
+			{Name: "nodes", SQL: `CREATE VIEW nodes AS
+SELECT node, count(*) AS requests, sum(error IS NOT NULL OR status >= 400) AS errors,
+  sum(input_tokens) AS input_tokens, sum(output_tokens) AS output_tokens, sum(cost) AS cost,
+  max(time) AS last_seen
+FROM requests GROUP BY node ORDER BY cost DESC`},
+		},
+	}
+	_, err := db.WriteTo(w)
+	return err
+}
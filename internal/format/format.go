@@ -0,0 +1,213 @@
+// Package format renders token counts and dollar amounts for display.
+//
+// The style is process-wide, like model pricing: it is set once from
+// config via Apply and read by the TUI, headless log and human-readable
+// exports. Machine-readable exports (CSV, JSON) always use plain numbers,
+// in dollars.
+package format
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TokenUnit selects how token counts are shown.
+type TokenUnit string
+
+const (
+	Abbrev TokenUnit = "abbrev" // 1.2K, 3.4M
+	Raw    TokenUnit = "raw"    // 1,234,567
+)
+
+// Style controls number rendering.
+type Style struct {
+	Tokens    TokenUnit
+	Thousands string // digit group separator; "" disables grouping
+	Decimal   string // decimal mark
+
+	// Currency is the ISO 4217 code amounts are shown in, converted at
+	// Rate units of it to the dollar; "" shows dollars.
+	Currency string
+	Rate     float64
+}
+
+// DefaultStyle abbreviates tokens and uses English separators.
+func DefaultStyle() Style {
+	return Style{Tokens: Abbrev, Thousands: ",", Decimal: "."}
+}
+
+var current = struct {
+	mu sync.RWMutex
+	s  Style
+}{s: DefaultStyle()}
+
+// ParseTokenUnit accepts "abbrev" or "raw"; empty means Abbrev.
+func ParseTokenUnit(s string) (TokenUnit, error) {
+	switch TokenUnit(strings.ToLower(s)) {
+	case "", Abbrev:
+		return Abbrev, nil
+	case Raw:
+		return Raw, nil
+	}
+	return "", fmt.Errorf("unknown token unit %q (want abbrev or raw)", s)
+}
+
+// Apply replaces the current style. An empty Decimal keeps ".", and a
+// Currency without a Rate shows dollars.
+func Apply(s Style) {
+	if s.Tokens == "" {
+		s.Tokens = Abbrev
+	}
+	if s.Decimal == "" {
+		s.Decimal = "."
+	}
+	if s.Rate <= 0 {
+		s.Currency, s.Rate = "", 0
+	}
+	current.mu.Lock()
+	current.s = s
+	current.mu.Unlock()
+}
+
+// Current returns the style in effect.
+func Current() Style {
+	current.mu.RLock()
+	defer current.mu.RUnlock()
+	return current.s
+}
+
+// SetTokenUnit switches only the token unit, e.g. from a TUI toggle.
+func SetTokenUnit(u TokenUnit) {
+	current.mu.Lock()
+	current.s.Tokens = u
+	current.mu.Unlock()
+}
+
+// SetRate switches the display currency and its rate, e.g. when a fresh
+// exchange rate is fetched.
+func SetRate(currency string, rate float64) {
+	if rate <= 0 {
+		return
+	}
+	current.mu.Lock()
+	current.s.Currency, current.s.Rate = currency, rate
+	current.mu.Unlock()
+}
+
+// Tokens renders a token count in the current unit.
+func Tokens(n int) string {
+	s := Current()
+	if s.Tokens == Raw {
+		return s.int(n)
+	}
+	switch {
+	case n >= 1_000_000:
+		return s.fixed(float64(n)/1_000_000, 1) + "M"
+	case n >= 1_000:
+		return s.fixed(float64(n)/1_000, 1) + "K"
+	default:
+		return strconv.Itoa(n)
+	}
+}
+
+// Int renders n with digit grouping.
+func Int(n int) string {
+	return Current().int(n)
+}
+
+// Cost renders a dollar amount, in the display currency, with precision
+// that grows as the amount shrinks, so sub-cent requests stay readable.
+func Cost(c float64) string {
+	s := Current()
+	v := s.convert(c)
+	if v < 0 {
+		return "-" + Cost(-c)
+	}
+	switch {
+	case v >= 10:
+		return s.money(v, 2)
+	case v >= 1:
+		return s.money(v, 3)
+	case v >= 0.01:
+		return s.money(v, 4)
+	case v == 0:
+		return s.money(0, 2)
+	default:
+		return s.money(v, 5)
+	}
+}
+
+// Dollars renders a dollar amount, in the display currency, with exactly
+// prec decimals.
+func Dollars(c float64, prec int) string {
+	if c < 0 {
+		return "-" + Dollars(-c, prec)
+	}
+	s := Current()
+	return s.money(s.convert(c), prec)
+}
+
+// convert turns dollars into the display currency.
+func (s Style) convert(c float64) float64 {
+	if s.Currency == "" {
+		return c
+	}
+	return c * s.Rate
+}
+
+// money renders v, already converted, with the currency's symbol, or its
+// code for one without a well-known symbol, e.g. "CHF 1.20".
+func (s Style) money(v float64, prec int) string {
+	switch strings.ToUpper(s.Currency) {
+	case "", "USD":
+		return "$" + s.fixed(v, prec)
+	case "EUR":
+		return "€" + s.fixed(v, prec)
+	case "GBP":
+		return "£" + s.fixed(v, prec)
+	case "JPY", "CNY":
+		return "¥" + s.fixed(v, prec)
+	case "INR":
+		return "₹" + s.fixed(v, prec)
+	}
+	return strings.ToUpper(s.Currency) + " " + s.fixed(v, prec)
+}
+
+func (s Style) fixed(v float64, prec int) string {
+	str := strconv.FormatFloat(v, 'f', prec, 64)
+	whole, frac, _ := strings.Cut(str, ".")
+	n, err := strconv.Atoi(whole)
+	if err != nil || math.IsInf(v, 0) {
+		return str
+	}
+	out := s.int(n)
+	if frac != "" {
+		out += s.Decimal + frac
+	}
+	return out
+}
+
+func (s Style) int(n int) string {
+	if n < 0 {
+		return "-" + s.int(-n)
+	}
+	digits := strconv.Itoa(n)
+	if s.Thousands == "" || len(digits) <= 3 {
+		return digits
+	}
+	var b strings.Builder
+	lead := len(digits) % 3
+	if lead > 0 {
+		b.WriteString(digits[:lead])
+	}
+	for i := lead; i < len(digits); i += 3 {
+		if b.Len() > 0 {
+			b.WriteString(s.Thousands)
+		}
+		b.WriteString(digits[i : i+3])
+	}
+	return b.String()
+}
@@ -0,0 +1,61 @@
+package format
+
+import "testing"
+
+func TestTokensAndCost(t *testing.T) {
+	defer Apply(DefaultStyle())
+
+	Apply(DefaultStyle())
+	for n, want := range map[int]string{999: "999", 1_234: "1.2K", 2_500_000: "2.5M"} {
+		if got := Tokens(n); got != want {
+			t.Errorf("Tokens(%d) = %q, want %q", n, got, want)
+		}
+	}
+	if got := Cost(1234.5); got != "$1,234.50" {
+		t.Errorf("Cost = %q", got)
+	}
+	if got := Cost(0.00123); got != "$0.00123" {
+		t.Errorf("Cost small = %q", got)
+	}
+
+	Apply(Style{Tokens: Raw, Thousands: ".", Decimal: ","})
+	if got := Tokens(1_234_567); got != "1.234.567" {
+		t.Errorf("raw Tokens = %q", got)
+	}
+	if got := Cost(-12.5); got != "-$12,50" {
+		t.Errorf("Cost de = %q", got)
+	}
+
+	Apply(Style{Tokens: Abbrev, Thousands: " ", Decimal: ","})
+	if got := Tokens(1_500); got != "1,5K" {
+		t.Errorf("abbrev Tokens = %q", got)
+	}
+	if got := Int(1000); got != "1 000" {
+		t.Errorf("Int = %q", got)
+	}
+
+	Apply(Style{Thousands: ",", Currency: "EUR", Rate: 0.5})
+	if got := Cost(30); got != "€15.00" {
+		t.Errorf("Cost EUR = %q", got)
+	}
+	if got := Cost(1.5); got != "€0.7500" { // precision follows the converted amount
+		t.Errorf("Cost EUR small = %q", got)
+	}
+	SetRate("CHF", 2)
+	if got := Dollars(-3, 2); got != "-CHF 6.00" {
+		t.Errorf("Dollars CHF = %q", got)
+	}
+	Apply(Style{Currency: "EUR"}) // no rate yet
+	if got := Cost(2); got != "$2.000" {
+		t.Errorf("Cost without a rate = %q", got)
+	}
+}
+
+func TestParseTokenUnit(t *testing.T) {
+	if u, err := ParseTokenUnit("RAW"); err != nil || u != Raw {
+		t.Errorf("ParseTokenUnit(RAW) = %q, %v", u, err)
+	}
+	if _, err := ParseTokenUnit("kilo"); err == nil {
+		t.Error("expected error for unknown unit")
+	}
+}
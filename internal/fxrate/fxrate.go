@@ -0,0 +1,151 @@
+// Package fxrate keeps the exchange rate of the display currency up to
+// date, so a session that runs for days shows amounts at the day's rate.
+//
+// Rates are fetched from a URL that answers with JSON holding a "rates"
+// object keyed by currency code, in units to the dollar, as
+// https://open.er-api.com/v6/latest/USD and
+// https://api.frankfurter.app/latest?from=USD do. The last rate fetched is
+// cached, and used while the URL can't be reached.
+package fxrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"miser/internal/daemon"
+)
+
+// Interval is how often the rate is refreshed.
+const Interval = 24 * time.Hour
+
+// retryInterval is how soon a failed refresh is tried again.
+const retryInterval = time.Hour
+
+// Rate is a fetched exchange rate, as cached.
+type Rate struct {
+	Currency string    `json:"currency"`
+	Rate     float64   `json:"rate"` // units of Currency to the dollar
+	Fetched  time.Time `json:"fetched"`
+}
+
+// DefaultPath returns exchange-rate.json in miser's state directory.
+func DefaultPath() string {
+	return filepath.Join(daemon.StateDir(), "exchange-rate.json")
+}
+
+// Load returns the rate cached at path for currency, if there is one.
+func Load(path, currency string) (Rate, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Rate{}, false
+	}
+	var r Rate
+	if json.Unmarshal(data, &r) != nil || !strings.EqualFold(r.Currency, currency) || r.Rate <= 0 {
+		return Rate{}, false
+	}
+	return r, true
+}
+
+func save(path string, r Rate) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Fetch asks url for the rate of currency.
+func Fetch(ctx context.Context, client *http.Client, url, currency string) (float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	var body struct {
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return 0, fmt.Errorf("%s: %w", url, err)
+	}
+	rate := body.Rates[strings.ToUpper(currency)]
+	if rate <= 0 {
+		return 0, fmt.Errorf("%s has no rate for %s", url, strings.ToUpper(currency))
+	}
+	return rate, nil
+}
+
+// Config describes what Refresh keeps up to date.
+type Config struct {
+	URL      string
+	Currency string
+	Path     string // the cache; "" is DefaultPath
+
+	// Set is called with each rate fetched, and Failed with each error;
+	// the rate in use stays until the next success.
+	Set    func(rate float64)
+	Failed func(error)
+
+	// SaveFailed, if set, is told when a fetched rate couldn't be cached.
+	// The rate is still used, and the next fetch is still a day off.
+	SaveFailed func(error)
+}
+
+// Refresh fetches the rate once the cached one is a day old, and every day
+// after, until ctx is done.
+func Refresh(ctx context.Context, cfg Config) {
+	if cfg.Path == "" {
+		cfg.Path = DefaultPath()
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	wait := time.Duration(0)
+	if r, ok := Load(cfg.Path, cfg.Currency); ok {
+		wait = max(time.Until(r.Fetched.Add(Interval)), 0)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		rate, err := Fetch(ctx, client, cfg.URL, cfg.Currency)
+		if err == nil {
+			cfg.Set(rate)
+			if err := save(cfg.Path, Rate{Currency: strings.ToUpper(cfg.Currency), Rate: rate, Fetched: time.Now()}); err != nil && cfg.SaveFailed != nil {
+				cfg.SaveFailed(err)
+			}
+		}
+		switch {
+		case errors.Is(err, context.Canceled) && ctx.Err() != nil:
+			return
+		case err != nil:
+			if cfg.Failed != nil {
+				cfg.Failed(err)
+			}
+			wait = retryInterval
+		default:
+			wait = Interval
+		}
+	}
+}
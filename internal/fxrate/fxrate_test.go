@@ -0,0 +1,95 @@
+package fxrate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"base":"USD","rates":{"EUR":0.92,"GBP":0.79}}`))
+	}))
+	defer srv.Close()
+
+	if rate, err := Fetch(context.Background(), srv.Client(), srv.URL, "eur"); err != nil || rate != 0.92 {
+		t.Errorf("Fetch(eur) = %v, %v", rate, err)
+	}
+	if _, err := Fetch(context.Background(), srv.Client(), srv.URL, "CHF"); err == nil {
+		t.Error("Fetch(CHF) succeeded without a rate for it")
+	}
+}
+
+func TestRefreshCaches(t *testing.T) {
+	var up atomic.Bool
+	up.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			http.Error(w, "down", http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"rates":{"EUR":0.9}}`))
+	}))
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "rate.json")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	set := make(chan float64, 1)
+	go Refresh(ctx, Config{URL: srv.URL, Currency: "EUR", Path: path, Set: func(r float64) { set <- r }})
+	select {
+	case r := <-set:
+		if r != 0.9 {
+			t.Fatalf("rate = %v", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no rate fetched")
+	}
+	cancel()
+
+	r, ok := Load(path, "eur")
+	if !ok || r.Rate != 0.9 || time.Since(r.Fetched) > time.Minute {
+		t.Fatalf("cached = %+v, %v", r, ok)
+	}
+	if _, ok := Load(path, "GBP"); ok {
+		t.Error("cached EUR rate loaded for GBP")
+	}
+
+	// A fresh cache isn't fetched again.
+	up.Store(false)
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var failed atomic.Bool
+	Refresh(ctx, Config{URL: srv.URL, Currency: "EUR", Path: path,
+		Set: func(float64) { t.Error("fetched despite a fresh cache") }, Failed: func(error) { failed.Store(true) }})
+	if failed.Load() {
+		t.Error("fetched despite a fresh cache")
+	}
+}
+
+func TestRefreshUncachable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"rates":{"EUR":0.9}}`))
+	}))
+	defer srv.Close()
+	// A file where the cache's directory should be.
+	blocker := filepath.Join(t.TempDir(), "state")
+	if err := os.WriteFile(blocker, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	var set, failed, unsaved atomic.Int32
+	Refresh(ctx, Config{URL: srv.URL, Currency: "EUR", Path: filepath.Join(blocker, "rate.json"),
+		Set:        func(float64) { set.Add(1) },
+		Failed:     func(error) { failed.Add(1) },
+		SaveFailed: func(error) { unsaved.Add(1) }})
+	if set.Load() != 1 || failed.Load() != 0 || unsaved.Load() != 1 {
+		t.Errorf("set %d, failed %d, unsaved %d; want the rate used and the cache failure reported apart", set.Load(), failed.Load(), unsaved.Load())
+	}
+}
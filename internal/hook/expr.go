@@ -0,0 +1,754 @@
+package hook
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Expr is a compiled condition in a small CEL-like language:
+//
+//	request.model.startsWith("claude-opus") && budget.fraction > 0.8
+//	size(request.messages) > 40 || estimate > 100000
+//	"temperature" in request && client != "ci"
+//
+// It has null, bools, numbers, strings, lists and maps; the operators
+// ! && || == != < <= > >= in + - * / % and ( ); fields with a.b and a["b"],
+// list items with a[0]; the functions size(x), has(x), int(x), string(x);
+// and the string methods startsWith, endsWith, contains, matches (an RE2
+// regexp), lower and upper. A missing field is null, which has() tests
+// for, and orders against nothing.
+type Expr struct {
+	src  string
+	root node
+}
+
+// Parse compiles src.
+func Parse(src string) (*Expr, error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, fmt.Errorf("%q: empty expression", src)
+	}
+	p := &parser{src: src}
+	if err := p.lex(); err != nil {
+		return nil, fmt.Errorf("%q: %w", src, err)
+	}
+	n, err := p.expr()
+	if err == nil && p.peek().kind != tokEOF {
+		err = p.errorf("unexpected %s", p.peek())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", src, err)
+	}
+	return &Expr{src: src, root: n}, nil
+}
+
+func (e *Expr) String() string { return e.src }
+
+// Eval evaluates the expression against env, the top-level names.
+func (e *Expr) Eval(env map[string]any) (any, error) {
+	return e.root.eval(env)
+}
+
+// Bool evaluates a condition, which must come out true or false.
+func (e *Expr) Bool(env map[string]any) (bool, error) {
+	v, err := e.Eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%q is %s, not a bool", e.src, typeName(v))
+	}
+	return b, nil
+}
+
+// ── lexing ──
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	text string  // identifier, operator, or decoded string
+	num  float64 // tokNumber
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+type parser struct {
+	src  string
+	toks []token
+	i    int
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("at %d: "+format, append([]any{p.peek().pos + 1}, args...)...)
+}
+
+var ops = []string{"&&", "||", "==", "!=", "<=", ">=", "!", "<", ">", "+", "-", "*", "/", "%", "(", ")", "[", "]", ".", ","}
+
+func (p *parser) lex() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(s) && (s[j] == '_' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			p.toks = append(p.toks, token{kind: tokIdent, text: s[i:j], pos: i})
+			i = j
+		case unicode.IsDigit(c):
+			j := i
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.' || s[j] == '_') {
+				j++
+			}
+			n, err := strconv.ParseFloat(strings.ReplaceAll(s[i:j], "_", ""), 64)
+			if err != nil {
+				return fmt.Errorf("at %d: bad number %q", i+1, s[i:j])
+			}
+			p.toks = append(p.toks, token{kind: tokNumber, num: n, pos: i})
+			i = j
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(s) && rune(s[j]) != c; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+					switch s[j] {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					default:
+						b.WriteByte(s[j])
+					}
+					continue
+				}
+				b.WriteByte(s[j])
+			}
+			if j == len(s) {
+				return fmt.Errorf("at %d: unterminated string", i+1)
+			}
+			p.toks = append(p.toks, token{kind: tokString, text: b.String(), pos: i})
+			i = j + 1
+		default:
+			op := ""
+			for _, o := range ops {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return fmt.Errorf("at %d: unexpected %q", i+1, c)
+			}
+			p.toks = append(p.toks, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	p.toks = append(p.toks, token{kind: tokEOF, pos: len(s)})
+	return nil
+}
+
+func (p *parser) peek() token { return p.toks[p.i] }
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// accept consumes the operator or keyword op if it is next.
+func (p *parser) accept(op string) bool {
+	if t := p.peek(); (t.kind == tokOp || t.kind == tokIdent) && t.text == op {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		return p.errorf("expected %q, found %s", op, p.peek())
+	}
+	return nil
+}
+
+// ── parsing, lowest precedence first ──
+
+func (p *parser) expr() (node, error) {
+	return p.binary(0)
+}
+
+var levels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">=", "in"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) binary(level int) (node, error) {
+	if level == len(levels) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		for _, o := range levels[level] {
+			if p.accept(o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+		if level == 2 { // comparisons don't chain
+			return left, nil
+		}
+	}
+}
+
+func (p *parser) unary() (node, error) {
+	for _, op := range []string{"!", "-"} {
+		if p.accept(op) {
+			n, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			return &unaryNode{op: op, x: n}, nil
+		}
+	}
+	return p.postfix()
+}
+
+func (p *parser) postfix() (node, error) {
+	n, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("."):
+			t := p.next()
+			if t.kind != tokIdent {
+				return nil, p.errorf("expected a field name after \".\"")
+			}
+			if p.accept("(") {
+				args, err := p.args(")")
+				if err != nil {
+					return nil, err
+				}
+				if _, ok := methods[t.text]; !ok {
+					return nil, fmt.Errorf("at %d: unknown method %s", t.pos+1, t.text)
+				}
+				n = &callNode{name: t.text, recv: n, args: args}
+			} else {
+				n = &indexNode{x: n, key: &literal{t.text}}
+			}
+		case p.accept("["):
+			key, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = &indexNode{x: n, key: key}
+		default:
+			return n, nil
+		}
+	}
+}
+
+func (p *parser) primary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		return &literal{t.num}, nil
+	case tokString:
+		return &literal{t.text}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return &literal{true}, nil
+		case "false":
+			return &literal{false}, nil
+		case "null":
+			return &literal{nil}, nil
+		}
+		if p.accept("(") {
+			args, err := p.args(")")
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := functions[t.text]; !ok {
+				return nil, fmt.Errorf("at %d: unknown function %s", t.pos+1, t.text)
+			}
+			if len(args) != 1 {
+				return nil, fmt.Errorf("at %d: %s takes one argument", t.pos+1, t.text)
+			}
+			return &callNode{name: t.text, args: args}, nil
+		}
+		return &nameNode{t.text}, nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			n, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		case "[":
+			items, err := p.args("]")
+			if err != nil {
+				return nil, err
+			}
+			return &listNode{items}, nil
+		}
+	}
+	if t.kind != tokEOF {
+		p.i-- // point the error at t
+	}
+	return nil, p.errorf("unexpected %s", t)
+}
+
+// args parses comma-separated expressions up to the closing bracket.
+func (p *parser) args(end string) ([]node, error) {
+	var out []node
+	if p.accept(end) {
+		return out, nil
+	}
+	for {
+		n, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+		if p.accept(end) {
+			return out, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ── evaluation ──
+
+type node interface {
+	eval(env map[string]any) (any, error)
+}
+
+type literal struct{ v any }
+
+func (n *literal) eval(map[string]any) (any, error) { return n.v, nil }
+
+type nameNode struct{ name string }
+
+func (n *nameNode) eval(env map[string]any) (any, error) {
+	v, ok := env[n.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown name %s", n.name)
+	}
+	return norm(v), nil
+}
+
+type listNode struct{ items []node }
+
+func (n *listNode) eval(env map[string]any) (any, error) {
+	out := make([]any, len(n.items))
+	for i, it := range n.items {
+		v, err := it.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+type indexNode struct{ x, key node }
+
+func (n *indexNode) eval(env map[string]any) (any, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	k, err := n.key.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case nil:
+		return nil, nil
+	case map[string]any:
+		s, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("map key is %s, not a string", typeName(k))
+		}
+		return norm(x[s]), nil
+	case []any:
+		f, ok := k.(float64)
+		if !ok || f != math.Trunc(f) {
+			return nil, fmt.Errorf("list index is %s, not a whole number", typeName(k))
+		}
+		if i := int(f); i >= 0 && i < len(x) {
+			return norm(x[i]), nil
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("can't index %s", typeName(x))
+}
+
+type unaryNode struct {
+	op string
+	x  node
+}
+
+func (n *unaryNode) eval(env map[string]any) (any, error) {
+	v, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("! of %s", typeName(v))
+		}
+		return !b, nil
+	}
+	f, ok := v.(float64)
+	if !ok {
+		return nil, fmt.Errorf("- of %s", typeName(v))
+	}
+	return -f, nil
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+func (n *binaryNode) eval(env map[string]any) (any, error) {
+	l, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "&&" || n.op == "||" {
+		lb, ok := l.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s of %s", n.op, typeName(l))
+		}
+		if lb == (n.op == "||") {
+			return lb, nil
+		}
+		r, err := n.right.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		rb, ok := r.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s of %s", n.op, typeName(r))
+		}
+		return rb, nil
+	}
+	r, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return equal(l, r), nil
+	case "!=":
+		return !equal(l, r), nil
+	case "in":
+		switch r := r.(type) {
+		case []any:
+			for _, v := range r {
+				if equal(l, norm(v)) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]any:
+			s, ok := l.(string)
+			_, has := r[s]
+			return ok && has, nil
+		case nil:
+			return false, nil
+		}
+		return nil, fmt.Errorf("in %s", typeName(r))
+	case "<", "<=", ">", ">=":
+		c, ok := compare(l, r)
+		if !ok {
+			if l == nil || r == nil {
+				return false, nil
+			}
+			return nil, fmt.Errorf("%s %s %s", typeName(l), n.op, typeName(r))
+		}
+		switch n.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	case "+":
+		if ls, ok := l.(string); ok {
+			if rs, ok := r.(string); ok {
+				return ls + rs, nil
+			}
+		}
+	}
+	lf, lok := l.(float64)
+	rf, rok := r.(float64)
+	if !lok || !rok {
+		return nil, fmt.Errorf("%s %s %s", typeName(l), n.op, typeName(r))
+	}
+	switch n.op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		if rf == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return lf / rf, nil
+	}
+	if rf == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	return math.Mod(lf, rf), nil
+}
+
+type callNode struct {
+	name string
+	recv node // nil for functions
+	args []node
+}
+
+var functions = map[string]func(any) (any, error){
+	"size": func(v any) (any, error) {
+		switch v := v.(type) {
+		case string:
+			return float64(len([]rune(v))), nil
+		case []any:
+			return float64(len(v)), nil
+		case map[string]any:
+			return float64(len(v)), nil
+		case nil:
+			return 0.0, nil
+		}
+		return nil, fmt.Errorf("size of %s", typeName(v))
+	},
+	"has": func(v any) (any, error) { return v != nil, nil },
+	"int": func(v any) (any, error) {
+		switch v := v.(type) {
+		case float64:
+			return math.Trunc(v), nil
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, fmt.Errorf("int(%q): not a number", v)
+			}
+			return math.Trunc(f), nil
+		}
+		return nil, fmt.Errorf("int of %s", typeName(v))
+	},
+	"string": func(v any) (any, error) {
+		switch v := v.(type) {
+		case string:
+			return v, nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		case nil:
+			return "", nil
+		}
+		return nil, fmt.Errorf("string of %s", typeName(v))
+	},
+}
+
+// methods take the receiver string and their arguments, already checked
+// to be strings.
+var methods = map[string]func(s string, args []string) (any, error){
+	"startsWith": func(s string, a []string) (any, error) { return strings.HasPrefix(s, a[0]), nil },
+	"endsWith":   func(s string, a []string) (any, error) { return strings.HasSuffix(s, a[0]), nil },
+	"contains":   func(s string, a []string) (any, error) { return strings.Contains(s, a[0]), nil },
+	"matches": func(s string, a []string) (any, error) {
+		re, err := compileRegexp(a[0])
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(s), nil
+	},
+	"lower": func(s string, _ []string) (any, error) { return strings.ToLower(s), nil },
+	"upper": func(s string, _ []string) (any, error) { return strings.ToUpper(s), nil },
+}
+
+// methodArgs is how many arguments each method takes.
+var methodArgs = map[string]int{"startsWith": 1, "endsWith": 1, "contains": 1, "matches": 1, "lower": 0, "upper": 0}
+
+func (n *callNode) eval(env map[string]any) (any, error) {
+	args := make([]any, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	if n.recv == nil {
+		return functions[n.name](args[0])
+	}
+	recv, err := n.recv.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != methodArgs[n.name] {
+		return nil, fmt.Errorf("%s takes %d argument(s)", n.name, methodArgs[n.name])
+	}
+	if recv == nil {
+		return false, nil // a missing field starts with nothing
+	}
+	s, ok := recv.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s on %s", n.name, typeName(recv))
+	}
+	strs := make([]string, len(args))
+	for i, a := range args {
+		if strs[i], ok = a.(string); !ok {
+			return nil, fmt.Errorf("%s of %s", n.name, typeName(a))
+		}
+	}
+	return methods[n.name](s, strs)
+}
+
+// regexps caches the patterns of matches calls; they are usually literals.
+var regexps = struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}{m: map[string]*regexp.Regexp{}}
+
+func compileRegexp(s string) (*regexp.Regexp, error) {
+	regexps.Lock()
+	defer regexps.Unlock()
+	if re, ok := regexps.m[s]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(regexps.m) < 256 {
+		regexps.m[s] = re
+	}
+	return re, nil
+}
+
+// norm turns the numbers of decoded JSON and TOML into float64.
+func norm(v any) any {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case []string:
+		out := make([]any, len(v))
+		for i, s := range v {
+			out[i] = s
+		}
+		return out
+	}
+	return v
+}
+
+func equal(a, b any) bool {
+	switch a := a.(type) {
+	case nil, bool, float64, string:
+		return a == b
+	}
+	return false
+}
+
+func compare(a, b any) (int, bool) {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			switch {
+			case a < b:
+				return -1, true
+			case a > b:
+				return 1, true
+			}
+			return 0, true
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	}
+	return 0, false
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "a bool"
+	case float64:
+		return "a number"
+	case string:
+		return "a string"
+	case []any:
+		return "a list"
+	case map[string]any:
+		return "a map"
+	}
+	return fmt.Sprintf("%T", v)
+}
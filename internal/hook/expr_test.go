@@ -0,0 +1,76 @@
+package hook
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestExpr(t *testing.T) {
+	var req map[string]any
+	json.Unmarshal([]byte(`{"model":"claude-opus-4","max_tokens":8192,"temperature":0.2,
+		"messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"}]}`), &req)
+	env := map[string]any{
+		"request": req,
+		"project": "web",
+		"budget":  map[string]any{"spent": 8.5, "limit": 10.0, "fraction": 0.85},
+		"tags":    []string{"a", "b"},
+	}
+	cases := []struct {
+		src  string
+		want any
+	}{
+		{`request.model.startsWith("claude-opus")`, true},
+		{`budget.fraction > 0.8 && project == 'web'`, true},
+		{`size(request.messages) >= 2`, true},
+		{`request.messages[1].role`, "assistant"},
+		{`request["max_tokens"] / 2 + 1`, 4097.0},
+		{`"temperature" in request && !("top_k" in request)`, true},
+		{`project in ["api", "web"]`, true},
+		{`"b" in tags`, true},
+		{`has(request.top_k)`, false},
+		{`request.top_k > 1`, false},
+		{`request.stop.contains("x")`, false},
+		{`request.model.matches("^claude-(opus|sonnet)")`, true},
+		{`request.model.upper() + "!"`, "CLAUDE-OPUS-4!"},
+		{`-request.temperature * 10 == -2`, true},
+		{`false || 1 % 2 == 1`, true},
+		{`false && nosuch`, false}, // short-circuits
+		{`int("42") == 42 && string(1.5) == "1.5"`, true},
+	}
+	for _, c := range cases {
+		e, err := Parse(c.src)
+		if err != nil {
+			t.Errorf("Parse(%s): %v", c.src, err)
+			continue
+		}
+		got, err := e.Eval(env)
+		if err != nil {
+			t.Errorf("%s: %v", c.src, err)
+		} else if got != c.want {
+			t.Errorf("%s = %v, want %v", c.src, got, c.want)
+		}
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	for _, src := range []string{``, `   `, "\t\n", `a ==`, `a &&   `, `(a`, `"open`, `a.b(`, `nope(1)`, `a.nope()`, `a $ b`, `1 < 2 < 3`} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("Parse(%s) did not fail", src)
+		}
+	}
+	env := map[string]any{"s": "x", "n": 1.0}
+	for _, src := range []string{`nosuch`, `s + n`, `n / 0`, `!s`, `s < n`, `n.lower()`} {
+		e, err := Parse(src)
+		if err != nil {
+			t.Fatalf("Parse(%s): %v", src, err)
+		}
+		if _, err := e.Eval(env); err == nil {
+			t.Errorf("%s did not fail", src)
+		}
+	}
+	e, _ := Parse(`s`)
+	if _, err := e.Bool(env); err == nil || !strings.Contains(err.Error(), "not a bool") {
+		t.Errorf("Bool of a string: %v", err)
+	}
+}
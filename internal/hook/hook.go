@@ -0,0 +1,264 @@
+// Package hook runs the [[hook]] rules of the config against the requests
+// miser relays: a condition over the request, its project and the budget,
+// and what to do when it holds — set or drop fields, rewrite prompt text,
+// tag the request, or veto it. Response hooks only tag.
+package hook
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Rule is one hook as configured.
+type Rule struct {
+	Name    string
+	On      string // "request", the default, or "response"
+	When    string // condition, see Expr; empty always holds
+	Veto    string // refuse the request with this message
+	Set     map[string]any
+	Drop    []string
+	Replace []Replace
+	Tags    []string
+}
+
+// Replace rewrites the text of the system prompt and every message.
+type Replace struct {
+	Pattern string // RE2 regexp
+	With    string // may refer to groups as $1
+}
+
+type hook struct {
+	name    string
+	when    *Expr
+	veto    string
+	set     map[string]any
+	drop    []string
+	replace []replace
+	tags    []string
+}
+
+type replace struct {
+	re   *regexp.Regexp
+	with string
+}
+
+// Hooks is a compiled set of rules, run in order. A nil Hooks does
+// nothing.
+type Hooks struct {
+	request, response []*hook
+}
+
+// Compile checks and compiles rules. Rules with no name are named after
+// their position, "hook 1" and so on.
+func Compile(rules []Rule) (*Hooks, error) {
+	h := &Hooks{}
+	for i, r := range rules {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("hook %d", i+1)
+		}
+		k := &hook{name: name, veto: r.Veto, set: r.Set, drop: r.Drop, tags: r.Tags}
+		if r.When != "" {
+			e, err := Parse(r.When)
+			if err != nil {
+				return nil, fmt.Errorf("%s: when %w", name, err)
+			}
+			k.when = e
+		}
+		for _, rp := range r.Replace {
+			re, err := regexp.Compile(rp.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%s: replace: %w", name, err)
+			}
+			k.replace = append(k.replace, replace{re, rp.With})
+		}
+		switch r.On {
+		case "", "request":
+			if k.veto == "" && len(k.set)+len(k.drop)+len(k.replace)+len(k.tags) == 0 {
+				return nil, fmt.Errorf("%s: does nothing; give it a veto, set, drop, replace or tags", name)
+			}
+			h.request = append(h.request, k)
+		case "response":
+			if k.veto != "" || len(k.set)+len(k.drop)+len(k.replace) > 0 {
+				return nil, fmt.Errorf("%s: response hooks can only tag", name)
+			}
+			if len(k.tags) == 0 {
+				return nil, fmt.Errorf("%s: does nothing; give it tags", name)
+			}
+			h.response = append(h.response, k)
+		default:
+			return nil, fmt.Errorf("%s: on = %q (want request or response)", name, r.On)
+		}
+		if k.set["model"] != nil {
+			if _, ok := k.set["model"].(string); !ok {
+				return nil, fmt.Errorf("%s: set model to a string", name)
+			}
+		}
+	}
+	return h, nil
+}
+
+// Len reports how many rules there are.
+func (h *Hooks) Len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.request) + len(h.response)
+}
+
+// Result is what the request hooks did.
+type Result struct {
+	Body    []byte   // the request body, rewritten if any hook changed it
+	Changed bool     // whether it was
+	Tags    []string // of the hooks that fired
+	Veto    string   // the message of the first veto, which ends the run
+	Vetoer  string   // the name of the hook that vetoed
+	Errs    []error  // hooks whose condition failed to evaluate, and were skipped
+}
+
+// Request runs the request hooks over body, a JSON object, with env's
+// names plus "request", the decoded body, which each hook sees as the
+// ones before it left it. env is kept for Response.
+func (h *Hooks) Request(body []byte, env map[string]any) Result {
+	res := Result{Body: body}
+	if h == nil || len(h.request) == 0 {
+		return res
+	}
+	var req map[string]any
+	if err := json.Unmarshal(body, &req); err != nil {
+		res.Errs = append(res.Errs, fmt.Errorf("request body is not a JSON object: %w", err))
+		return res
+	}
+	env["request"] = req
+	for _, k := range h.request {
+		ok, err := k.holds(env)
+		if err != nil {
+			res.Errs = append(res.Errs, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		res.Tags = addTags(res.Tags, k.tags)
+		if k.veto != "" {
+			res.Veto, res.Vetoer = k.veto, k.name
+			return res
+		}
+		for f, v := range k.set {
+			req[f] = v
+			res.Changed = true
+		}
+		for _, f := range k.drop {
+			if _, ok := req[f]; ok {
+				delete(req, f)
+				res.Changed = true
+			}
+		}
+		if len(k.replace) > 0 && k.rewrite(req) {
+			res.Changed = true
+		}
+	}
+	if res.Changed {
+		b, err := json.Marshal(req)
+		if err != nil {
+			res.Errs = append(res.Errs, err)
+			res.Changed = false
+			return res
+		}
+		res.Body = b
+	}
+	return res
+}
+
+// Response runs the response hooks with env, as left by Request, plus
+// "response", and returns the tags of those that fired.
+func (h *Hooks) Response(env map[string]any, response map[string]any) (tags []string, errs []error) {
+	if h == nil || len(h.response) == 0 {
+		return nil, nil
+	}
+	env["response"] = response
+	if _, ok := env["request"]; !ok {
+		env["request"] = map[string]any{}
+	}
+	for _, k := range h.response {
+		ok, err := k.holds(env)
+		if err != nil {
+			errs = append(errs, err)
+		} else if ok {
+			tags = addTags(tags, k.tags)
+		}
+	}
+	return tags, errs
+}
+
+// HasResponse reports whether there are response hooks to run.
+func (h *Hooks) HasResponse() bool {
+	return h != nil && len(h.response) > 0
+}
+
+func (k *hook) holds(env map[string]any) (bool, error) {
+	if k.when == nil {
+		return true, nil
+	}
+	ok, err := k.when.Bool(env)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", k.name, err)
+	}
+	return ok, nil
+}
+
+// rewrite applies the replacements to the system prompt and to the text of
+// every message, as a string or as text blocks.
+func (k *hook) rewrite(req map[string]any) bool {
+	changed := false
+	apply := func(s string) string {
+		out := s
+		for _, r := range k.replace {
+			out = r.re.ReplaceAllString(out, r.with)
+		}
+		if out != s {
+			changed = true
+		}
+		return out
+	}
+	req["system"] = rewriteContent(req["system"], apply)
+	if msgs, ok := req["messages"].([]any); ok {
+		for _, m := range msgs {
+			if m, ok := m.(map[string]any); ok {
+				m["content"] = rewriteContent(m["content"], apply)
+			}
+		}
+	}
+	if req["system"] == nil {
+		delete(req, "system")
+	}
+	return changed
+}
+
+func rewriteContent(c any, apply func(string) string) any {
+	switch c := c.(type) {
+	case string:
+		return apply(c)
+	case []any:
+		for _, b := range c {
+			if b, ok := b.(map[string]any); ok && b["type"] == "text" {
+				if s, ok := b["text"].(string); ok {
+					b["text"] = apply(s)
+				}
+			}
+		}
+	}
+	return c
+}
+
+func addTags(tags, more []string) []string {
+	for _, t := range more {
+		if t = strings.TrimSpace(t); t != "" && !slices.Contains(tags, t) {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,79 @@
+package hook
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestHooksRequest(t *testing.T) {
+	h, err := Compile([]Rule{
+		{Name: "no-temp", When: `client == "ci"`, Drop: []string{"temperature"}, Tags: []string{"ci"}},
+		{Name: "downgrade", When: `budget.fraction > 0.9 && request.model.startsWith("claude-opus")`,
+			Set: map[string]any{"model": "claude-sonnet-4"}, Tags: []string{"downgraded"}},
+		{Name: "scrub", Replace: []Replace{{Pattern: `ACME-(\d+)`, With: "TICKET-$1"}}},
+		{Name: "veto", When: `request.model == "claude-sonnet-4" && size(request.messages) > 3`, Veto: "too long"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(`{"model":"claude-opus-4","temperature":1,"system":"see ACME-12",
+		"messages":[{"role":"user","content":[{"type":"text","text":"fix ACME-7"}]}]}`)
+	env := map[string]any{"client": "ci", "budget": map[string]any{"fraction": 0.95}}
+	res := h.Request(body, env)
+	if len(res.Errs) > 0 || res.Veto != "" || !res.Changed {
+		t.Fatalf("result %+v", res)
+	}
+	if !slices.Equal(res.Tags, []string{"ci", "downgraded"}) {
+		t.Errorf("tags %v", res.Tags)
+	}
+	var got map[string]any
+	json.Unmarshal(res.Body, &got)
+	if got["model"] != "claude-sonnet-4" || got["temperature"] != nil || got["system"] != "see TICKET-12" {
+		t.Errorf("body %s", res.Body)
+	}
+	if text := got["messages"].([]any)[0].(map[string]any)["content"].([]any)[0].(map[string]any)["text"]; text != "fix TICKET-7" {
+		t.Errorf("message text %v", text)
+	}
+
+	long := []byte(`{"model":"claude-opus-4","messages":[{},{},{},{}]}`)
+	res = h.Request(long, map[string]any{"client": "", "budget": map[string]any{"fraction": 0.95}})
+	if res.Veto != "too long" || res.Vetoer != "veto" {
+		t.Errorf("veto %+v", res)
+	}
+
+	res = h.Request(body, map[string]any{"budget": map[string]any{"fraction": 0.1}})
+	if len(res.Errs) != 1 || res.Body == nil { // no client; the rest still ran
+		t.Errorf("errs %v", res.Errs)
+	}
+}
+
+func TestHooksResponse(t *testing.T) {
+	h, err := Compile([]Rule{{On: "response", When: `response.cost > 1 || response.status >= 500`, Tags: []string{"expensive"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]any{"request": map[string]any{"model": "m"}}
+	if tags, _ := h.Response(env, map[string]any{"cost": 2.5, "status": 200}); !slices.Equal(tags, []string{"expensive"}) {
+		t.Errorf("tags %v", tags)
+	}
+	if tags, _ := h.Response(env, map[string]any{"cost": 0.1, "status": 200}); tags != nil {
+		t.Errorf("tags %v", tags)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, r := range []Rule{
+		{Name: "idle"},
+		{When: "a ==", Tags: []string{"x"}},
+		{When: " ", Tags: []string{"x"}},
+		{On: "response", Veto: "no"},
+		{On: "sometimes", Tags: []string{"x"}},
+		{Replace: []Replace{{Pattern: "("}}},
+		{Set: map[string]any{"model": 3}},
+	} {
+		if _, err := Compile([]Rule{r}); err == nil {
+			t.Errorf("Compile(%+v) did not fail", r)
+		}
+	}
+}
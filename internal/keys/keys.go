@@ -0,0 +1,280 @@
+// Package keys issues virtual API keys: secrets that clients send in place
+// of the real Anthropic key, which miser swaps for the real one upstream.
+// Each request records the key's name, so usage can be reported per person
+// or tool and a key revoked without rotating the real secret.
+//
+// Only a hash of each secret is stored. The file is shared between the
+// running proxy and the keys subcommand; the proxy rereads it when it
+// changes, so a revocation takes effect on the next request.
+package keys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Prefix starts every virtual key, so the proxy can tell them from real
+// ones.
+const Prefix = "sk-miser-"
+
+// Key is one issued key.
+type Key struct {
+	Name     string    `json:"name"`
+	Hash     string    `json:"hash"`               // SHA-256 of the secret, hex
+	Hint     string    `json:"hint"`               // the secret's last four characters
+	Upstream string    `json:"upstream,omitempty"` // a [keys.upstream] name; "" means [proxy] api_key
+	Created  time.Time `json:"created"`
+	Revoked  time.Time `json:"revoked,omitzero"`
+	Limits
+}
+
+// Limits caps what a key may use. Zero values mean no limit.
+type Limits struct {
+	Budget float64 `json:"budget,omitempty"` // dollars per Period
+	Period string  `json:"period,omitempty"` // "day", "week" or "month"; "" means month
+	RPM    int     `json:"rpm,omitempty"`    // requests per minute
+}
+
+// Check reports a malformed limit.
+func (l Limits) Check() error {
+	switch {
+	case l.Budget < 0:
+		return fmt.Errorf("budget %v: must not be negative", l.Budget)
+	case l.RPM < 0:
+		return fmt.Errorf("rpm %d: must not be negative", l.RPM)
+	}
+	switch l.Period {
+	case "", "day", "week", "month":
+		return nil
+	}
+	return fmt.Errorf("period %q: want day, week or month", l.Period)
+}
+
+// Active reports whether the key is still accepted.
+func (k Key) Active() bool {
+	return k.Revoked.IsZero()
+}
+
+// Masked shows the key the way it can be recognised without revealing it.
+func (k Key) Masked() string {
+	return Prefix + "…" + k.Hint
+}
+
+// DefaultPath returns $XDG_DATA_HOME/miser/keys.json, falling back to
+// ~/.local/share/miser/keys.json.
+func DefaultPath() string {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "miser-keys.json"
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "miser", "keys.json")
+}
+
+// File is the set of keys kept at a path.
+type File struct {
+	path string
+
+	mu   sync.Mutex
+	keys []Key
+	info os.FileInfo // of the file when keys were read
+}
+
+// Open reads the keys at path. A missing file holds no keys.
+func Open(path string) (*File, error) {
+	f := &File{path: path}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f, f.reload()
+}
+
+// Path returns where the keys are kept.
+func (f *File) Path() string {
+	return f.path
+}
+
+// reload rereads the file if it changed since it was last read.
+func (f *File) reload() error {
+	info, err := os.Stat(f.path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		f.keys, f.info = nil, nil
+		return nil
+	case err != nil:
+		return err
+	case f.info != nil && os.SameFile(info, f.info) && info.ModTime().Equal(f.info.ModTime()) && info.Size() == f.info.Size():
+		return nil // save replaces the file, so an update is a new one
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	var keys []Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("reading keys %s: %w", f.path, err)
+	}
+	f.keys, f.info = keys, info
+	return nil
+}
+
+// List returns every key, including revoked ones, oldest first.
+func (f *File) List() ([]Key, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return append([]Key{}, f.keys...), nil
+}
+
+// Lookup finds the active key whose secret this is.
+func (f *File) Lookup(secret string) (Key, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.reload(); err != nil {
+		return Key{}, false, err
+	}
+	h := hash(secret)
+	for _, k := range f.keys {
+		if k.Hash == h && k.Active() {
+			return k, true, nil
+		}
+	}
+	return Key{}, false, nil
+}
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]*$`)
+
+// Create issues a key and returns its secret, which is not stored and
+// cannot be shown again.
+func (f *File) Create(name, upstream string, limits Limits) (string, Key, error) {
+	if !validName.MatchString(name) {
+		return "", Key{}, fmt.Errorf("key name %q: use letters, digits, '.', '_', '@' and '-'", name)
+	}
+	if err := limits.Check(); err != nil {
+		return "", Key{}, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.reload(); err != nil {
+		return "", Key{}, err
+	}
+	for _, k := range f.keys {
+		if k.Name == name {
+			return "", Key{}, fmt.Errorf("a key named %q already exists", name)
+		}
+	}
+
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", Key{}, err
+	}
+	secret := Prefix + base64.RawURLEncoding.EncodeToString(b)
+	k := Key{
+		Name:     name,
+		Hash:     hash(secret),
+		Hint:     secret[len(secret)-4:],
+		Upstream: upstream,
+		Created:  time.Now().UTC().Truncate(time.Second),
+		Limits:   limits,
+	}
+	if err := f.save(append(f.keys, k)); err != nil {
+		return "", Key{}, err
+	}
+	return secret, k, nil
+}
+
+// Revoke stops a key being accepted. Its record stays, so past requests
+// still report under its name.
+func (f *File) Revoke(name string) (Key, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.reload(); err != nil {
+		return Key{}, err
+	}
+	keys := append([]Key{}, f.keys...)
+	for i, k := range keys {
+		if k.Name != name {
+			continue
+		}
+		if !k.Active() {
+			return k, fmt.Errorf("key %q was already revoked on %s", name, k.Revoked.Local().Format("2006-01-02"))
+		}
+		keys[i].Revoked = time.Now().UTC().Truncate(time.Second)
+		return keys[i], f.save(keys)
+	}
+	return Key{}, fmt.Errorf("no key named %q", name)
+}
+
+// SetLimits replaces a key's limits. A running proxy applies them from its
+// next request.
+func (f *File) SetLimits(name string, limits Limits) (Key, error) {
+	if err := limits.Check(); err != nil {
+		return Key{}, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.reload(); err != nil {
+		return Key{}, err
+	}
+	keys := append([]Key{}, f.keys...)
+	for i, k := range keys {
+		if k.Name == name {
+			keys[i].Limits = limits
+			return keys[i], f.save(keys)
+		}
+	}
+	return Key{}, fmt.Errorf("no key named %q", name)
+}
+
+// save replaces the file, readable only by its owner.
+func (f *File) save(keys []Key) error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return fmt.Errorf("creating keys directory: %w", err)
+	}
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("writing keys: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return err
+	}
+	f.keys = keys
+	f.info, _ = os.Stat(f.path)
+	return nil
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
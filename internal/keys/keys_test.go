@@ -0,0 +1,82 @@
+package keys
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreateLookupRevoke(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	f, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, k, err := f.Create("alice", "", Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, Prefix) || !strings.HasSuffix(secret, k.Hint) {
+		t.Errorf("secret %q, hint %q", secret, k.Hint)
+	}
+	if _, _, err := f.Create("alice", "", Limits{}); err == nil {
+		t.Error("want an error for a duplicate name")
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), secret) {
+		t.Error("the secret was stored")
+	}
+
+	// A second reader, like the running proxy, sees the key and then its
+	// revocation.
+	proxy, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok, _ := proxy.Lookup(secret); !ok || got.Name != "alice" {
+		t.Fatalf("Lookup = %+v, %v", got, ok)
+	}
+	if _, ok, _ := proxy.Lookup(secret + "x"); ok {
+		t.Error("a wrong secret was accepted")
+	}
+	if _, err := f.Revoke("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := proxy.Lookup(secret); ok {
+		t.Error("a revoked key was accepted")
+	}
+	if _, err := f.Revoke("alice"); err == nil {
+		t.Error("want an error revoking twice")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("keys file mode %v", info.Mode())
+	}
+}
+
+func TestSetLimits(t *testing.T) {
+	f, err := Open(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := f.Create("bob", "", Limits{Period: "year"}); err == nil {
+		t.Error("want an error for an unknown period")
+	}
+	secret, _, err := f.Create("bob", "", Limits{RPM: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy, err := Open(f.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.SetLimits("bob", Limits{Budget: 5, Period: "day", RPM: 60}); err != nil {
+		t.Fatal(err)
+	}
+	if k, _, _ := proxy.Lookup(secret); k.Limits != (Limits{Budget: 5, Period: "day", RPM: 60}) {
+		t.Errorf("limits seen by the proxy = %+v", k.Limits)
+	}
+	if _, err := f.SetLimits("carol", Limits{}); err == nil {
+		t.Error("want an error for an unknown key")
+	}
+}
@@ -0,0 +1,183 @@
+// Package mcp is a minimal Model Context Protocol server: JSON-RPC 2.0 over
+// newline-delimited stdio, offering tools and nothing else.
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+)
+
+// protocolVersions are the MCP revisions this server speaks, newest first.
+var protocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// Tool is one callable tool. Call receives the raw "arguments" object
+// (or null) and returns a JSON-encodable result.
+type Tool struct {
+	Name        string
+	Description string
+	InputSchema map[string]any // JSON Schema of the arguments
+	Call        func(ctx context.Context, args json.RawMessage) (any, error)
+}
+
+// Server answers MCP requests with its tools.
+type Server struct {
+	Name    string
+	Version string
+	Tools   []Tool
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"` // absent for notifications
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// JSON-RPC error codes.
+const (
+	parseError     = -32700
+	invalidRequest = -32600
+	methodNotFound = -32601
+	invalidParams  = -32602
+)
+
+// maxMessage bounds one incoming line.
+const maxMessage = 4 << 20
+
+// Serve reads requests from r and writes responses to w until r ends or
+// ctx is done. Tool calls run one at a time, in order.
+func (s *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), maxMessage)
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	write := func(resp response) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return enc.Encode(resp)
+	}
+	for sc.Scan() {
+		if ctx.Err() != nil {
+			return nil
+		}
+		line := sc.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var req request
+		if err := json.Unmarshal(line, &req); err != nil {
+			if err := write(response{JSONRPC: "2.0", ID: json.RawMessage("null"),
+				Error: &rpcError{parseError, err.Error()}}); err != nil {
+				return err
+			}
+			continue
+		}
+		result, rerr := s.handle(ctx, req)
+		if req.ID == nil {
+			continue // notifications get no reply
+		}
+		if err := write(response{JSONRPC: "2.0", ID: req.ID, Result: result, Error: rerr}); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+func (s *Server) handle(ctx context.Context, req request) (any, *rpcError) {
+	if req.JSONRPC != "2.0" {
+		return nil, &rpcError{invalidRequest, `jsonrpc must be "2.0"`}
+	}
+	switch req.Method {
+	case "initialize":
+		var p struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		json.Unmarshal(req.Params, &p)
+		version := protocolVersions[0]
+		if slices.Contains(protocolVersions, p.ProtocolVersion) {
+			version = p.ProtocolVersion
+		}
+		return map[string]any{
+			"protocolVersion": version,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]any{"name": s.Name, "version": s.Version},
+		}, nil
+	case "ping":
+		return map[string]any{}, nil
+	case "tools/list":
+		tools := make([]map[string]any, len(s.Tools))
+		for i, t := range s.Tools {
+			schema := t.InputSchema
+			if schema == nil {
+				schema = map[string]any{"type": "object"}
+			}
+			tools[i] = map[string]any{"name": t.Name, "description": t.Description, "inputSchema": schema}
+		}
+		return map[string]any{"tools": tools}, nil
+	case "tools/call":
+		var p struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			return nil, &rpcError{invalidParams, err.Error()}
+		}
+		i := slices.IndexFunc(s.Tools, func(t Tool) bool { return t.Name == p.Name })
+		if i < 0 {
+			return nil, &rpcError{invalidParams, fmt.Sprintf("unknown tool %q", p.Name)}
+		}
+		return callResult(s.Tools[i].Call(ctx, p.Arguments)), nil
+	}
+	if req.ID == nil {
+		return nil, nil // e.g. notifications/initialized
+	}
+	return nil, &rpcError{methodNotFound, "method not found: " + req.Method}
+}
+
+// callResult reports tool failures in the result, as MCP asks, so the
+// model sees them.
+func callResult(v any, err error) map[string]any {
+	if err == nil {
+		var text []byte
+		if text, err = json.MarshalIndent(v, "", "  "); err == nil {
+			return map[string]any{
+				"content":           []any{map[string]any{"type": "text", "text": string(text)}},
+				"structuredContent": v,
+			}
+		}
+	}
+	return map[string]any{
+		"content": []any{map[string]any{"type": "text", "text": err.Error()}},
+		"isError": true,
+	}
+}
+
+// DecodeArgs unmarshals tool arguments into v, treating absent
+// arguments as an empty object.
+func DecodeArgs(args json.RawMessage, v any) error {
+	if len(args) == 0 || string(args) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(args, v); err != nil {
+		return errors.New("invalid arguments: " + err.Error())
+	}
+	return nil
+}
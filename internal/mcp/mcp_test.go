@@ -0,0 +1,80 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestServe(t *testing.T) {
+	srv := &Server{Name: "test", Version: "v1", Tools: []Tool{
+		{
+			Name: "echo",
+			Call: func(_ context.Context, args json.RawMessage) (any, error) {
+				var in struct{ Say string }
+				if err := DecodeArgs(args, &in); err != nil {
+					return nil, err
+				}
+				if in.Say == "" {
+					return nil, errors.New("nothing to say")
+				}
+				return map[string]string{"said": in.Say}, nil
+			},
+		},
+	}}
+	in := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26"}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"echo","arguments":{"say":"hi"}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"echo"}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"resources/list"}`,
+		`not json`,
+	}, "\n")
+	var out bytes.Buffer
+	if err := srv.Serve(context.Background(), strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	var resps []struct {
+		ID     json.RawMessage
+		Result map[string]any
+		Error  *rpcError
+	}
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var r struct {
+			ID     json.RawMessage
+			Result map[string]any
+			Error  *rpcError
+		}
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		resps = append(resps, r)
+	}
+	if len(resps) != 6 {
+		t.Fatalf("got %d responses, want 6 (no reply to the notification)", len(resps))
+	}
+	if v := resps[0].Result["protocolVersion"]; v != "2025-03-26" {
+		t.Errorf("negotiated version = %v", v)
+	}
+	if tools := resps[1].Result["tools"].([]any); len(tools) != 1 {
+		t.Errorf("tools = %v", tools)
+	}
+	if sc := resps[2].Result["structuredContent"].(map[string]any); sc["said"] != "hi" {
+		t.Errorf("call result = %v", resps[2].Result)
+	}
+	if resps[3].Result["isError"] != true {
+		t.Errorf("failed call = %v", resps[3].Result)
+	}
+	if resps[4].Error == nil || resps[4].Error.Code != methodNotFound {
+		t.Errorf("unknown method = %+v", resps[4])
+	}
+	if resps[5].Error == nil || resps[5].Error.Code != parseError || string(resps[5].ID) != "null" {
+		t.Errorf("bad json = %+v", resps[5])
+	}
+}
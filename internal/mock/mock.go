@@ -0,0 +1,228 @@
+// Package mock is a stand-in for the Anthropic API that answers with canned
+// messages, so a client's base-URL setup and the dashboard can be tried
+// without calling (or paying for) the real API.
+package mock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Reply is the text of every mocked message.
+const Reply = "This is a mock response from miser. No request was sent to the Anthropic API."
+
+// Config shapes the canned responses.
+type Config struct {
+	Latency time.Duration // total time per response; streams spread it over their chunks
+
+	InputTokens      int // 0 estimates from the request size
+	OutputTokens     int // capped by the request's max_tokens
+	CacheReadTokens  int
+	CacheWriteTokens int
+}
+
+// Models is what GET /v1/models lists.
+var Models = []string{"claude-opus-4-6", "claude-sonnet-4-6", "claude-haiku-4-5"}
+
+// Serve starts the mock on a loopback port and returns its base URL. It
+// stops when ctx is done.
+func Serve(ctx context.Context, cfg Config) (string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	srv := &http.Server{Handler: Handler(cfg)}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go srv.Serve(ln)
+	return "http://" + ln.Addr().String(), nil
+}
+
+// Handler serves POST /v1/messages (JSON and SSE), POST
+// /v1/messages/count_tokens and GET /v1/models.
+func Handler(cfg Config) http.Handler {
+	m := &mock{cfg: cfg}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/messages", m.messages)
+	mux.HandleFunc("POST /v1/messages/count_tokens", m.countTokens)
+	mux.HandleFunc("GET /v1/models", m.models)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "not_found_error",
+			fmt.Sprintf("%s %s is not mocked", r.Method, r.URL.Path))
+	})
+	return mux
+}
+
+type mock struct {
+	cfg Config
+	seq atomic.Int64
+}
+
+type messagesRequest struct {
+	Model     string `json:"model"`
+	MaxTokens int    `json:"max_tokens"`
+	Stream    bool   `json:"stream"`
+}
+
+type usage struct {
+	InputTokens      int `json:"input_tokens"`
+	OutputTokens     int `json:"output_tokens"`
+	CacheReadTokens  int `json:"cache_read_input_tokens"`
+	CacheWriteTokens int `json:"cache_creation_input_tokens"`
+}
+
+func (m *mock) messages(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	var req messagesRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid JSON: "+err.Error())
+		return
+	}
+	if req.Model == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "model: field required")
+		return
+	}
+
+	u := usage{
+		InputTokens:      m.inputTokens(body),
+		OutputTokens:     m.cfg.OutputTokens,
+		CacheReadTokens:  m.cfg.CacheReadTokens,
+		CacheWriteTokens: m.cfg.CacheWriteTokens,
+	}
+	stop := "end_turn"
+	if req.MaxTokens > 0 && u.OutputTokens > req.MaxTokens {
+		u.OutputTokens = req.MaxTokens
+		stop = "max_tokens"
+	}
+	n := m.seq.Add(1)
+	id := fmt.Sprintf("msg_mock_%d", n)
+	w.Header().Set("Request-Id", fmt.Sprintf("req_mock_%d", n))
+
+	if req.Stream {
+		m.stream(w, r, id, req.Model, stop, u)
+		return
+	}
+	if !sleep(r.Context(), m.cfg.Latency) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":            id,
+		"type":          "message",
+		"role":          "assistant",
+		"model":         req.Model,
+		"content":       []map[string]any{{"type": "text", "text": Reply}},
+		"stop_reason":   stop,
+		"stop_sequence": nil,
+		"usage":         u,
+	})
+}
+
+// stream sends the reply word by word, like the real API, with one equal
+// share of the latency before the first event and each chunk.
+func (m *mock) stream(w http.ResponseWriter, r *http.Request, id, model, stop string, u usage) {
+	chunks := strings.SplitAfter(Reply, " ")
+	step := m.cfg.Latency / time.Duration(len(chunks)+1)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+	send := func(event string, data any) {
+		b, _ := json.Marshal(data)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	if !sleep(r.Context(), step) {
+		return
+	}
+	start := u
+	start.OutputTokens = 1
+	send("message_start", map[string]any{"type": "message_start", "message": map[string]any{
+		"id": id, "type": "message", "role": "assistant", "model": model,
+		"content": []any{}, "stop_reason": nil, "stop_sequence": nil, "usage": start,
+	}})
+	send("content_block_start", map[string]any{"type": "content_block_start", "index": 0,
+		"content_block": map[string]any{"type": "text", "text": ""}})
+	for _, c := range chunks {
+		if !sleep(r.Context(), step) {
+			return
+		}
+		send("content_block_delta", map[string]any{"type": "content_block_delta", "index": 0,
+			"delta": map[string]any{"type": "text_delta", "text": c}})
+	}
+	send("content_block_stop", map[string]any{"type": "content_block_stop", "index": 0})
+	send("message_delta", map[string]any{"type": "message_delta",
+		"delta": map[string]any{"stop_reason": stop, "stop_sequence": nil},
+		"usage": map[string]int{"output_tokens": u.OutputTokens}})
+	send("message_stop", map[string]any{"type": "message_stop"})
+}
+
+func (m *mock) countTokens(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"input_tokens": m.inputTokens(body)})
+}
+
+func (m *mock) models(w http.ResponseWriter, r *http.Request) {
+	data := make([]map[string]string, len(Models))
+	for i, id := range Models {
+		data[i] = map[string]string{"type": "model", "id": id, "display_name": id}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"data": data, "has_more": false, "first_id": Models[0], "last_id": Models[len(Models)-1],
+	})
+}
+
+// inputTokens returns the configured count, or roughly four bytes of
+// request body per token.
+func (m *mock) inputTokens(body []byte) int {
+	if m.cfg.InputTokens > 0 {
+		return m.cfg.InputTokens
+	}
+	return max(len(body)/4, 1)
+}
+
+func writeError(w http.ResponseWriter, status int, typ, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"type":  "error",
+		"error": map[string]string{"type": typ, "message": msg},
+	})
+}
+
+// sleep waits for d, returning false if ctx ends first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,83 @@
+package mock
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func post(t *testing.T, h http.Handler, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	return rec
+}
+
+func TestMessages(t *testing.T) {
+	h := Handler(Config{InputTokens: 1200, OutputTokens: 300, CacheReadTokens: 50})
+	rec := post(t, h, "/v1/messages", `{"model":"claude-sonnet-4-6","max_tokens":100}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Model      string `json:"model"`
+		StopReason string `json:"stop_reason"`
+		Content    []struct {
+			Text string `json:"text"`
+		} `json:"content"`
+		Usage usage `json:"usage"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Model != "claude-sonnet-4-6" || len(resp.Content) != 1 || resp.Content[0].Text != Reply {
+		t.Errorf("resp = %+v", resp)
+	}
+	want := usage{InputTokens: 1200, OutputTokens: 100, CacheReadTokens: 50}
+	if resp.Usage != want || resp.StopReason != "max_tokens" {
+		t.Errorf("usage = %+v, stop = %q; want %+v capped by max_tokens", resp.Usage, resp.StopReason, want)
+	}
+}
+
+func TestMessagesStream(t *testing.T) {
+	h := Handler(Config{InputTokens: 10, OutputTokens: 20})
+	rec := post(t, h, "/v1/messages", `{"model":"claude-haiku-4-5","stream":true}`)
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	var events []string
+	var text strings.Builder
+	sc := bufio.NewScanner(rec.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		if ev, ok := strings.CutPrefix(line, "event: "); ok {
+			events = append(events, ev)
+		}
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			var d struct {
+				Delta struct {
+					Text string `json:"text"`
+				} `json:"delta"`
+			}
+			json.Unmarshal([]byte(data), &d)
+			text.WriteString(d.Delta.Text)
+		}
+	}
+	if events[0] != "message_start" || events[len(events)-1] != "message_stop" {
+		t.Errorf("events = %v", events)
+	}
+	if text.String() != Reply {
+		t.Errorf("text = %q", text.String())
+	}
+}
+
+func TestUnknownPath(t *testing.T) {
+	rec := post(t, Handler(Config{}), "/v1/complete", `{}`)
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "not_found_error") {
+		t.Errorf("got %d %s", rec.Code, rec.Body)
+	}
+}
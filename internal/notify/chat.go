@@ -0,0 +1,195 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"miser/internal/format"
+)
+
+// Alert colors, shared by Slack attachments and Discord embeds.
+const (
+	colorInfo    = 0x2eb67d
+	colorWarning = 0xecb22e
+	colorDanger  = 0xe01e5a
+)
+
+// Slack posts events to a Slack incoming webhook.
+type Slack struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s *Slack) Send(ctx context.Context, e Event) error {
+	title, color, fields := describe(e)
+	type slackField struct {
+		Title string `json:"title"`
+		Value string `json:"value"`
+		Short bool   `json:"short"`
+	}
+	att := struct {
+		Fallback string       `json:"fallback"`
+		Color    string       `json:"color"`
+		Title    string       `json:"title"`
+		Fields   []slackField `json:"fields,omitempty"`
+		Footer   string       `json:"footer"`
+		TS       int64        `json:"ts"`
+	}{e.Message, fmt.Sprintf("#%06x", color), title, nil, footer(e.Source), e.Time.Unix()}
+	for _, f := range fields {
+		att.Fields = append(att.Fields, slackField{f.name, f.value, !f.wide})
+	}
+	body, err := json.Marshal(map[string]any{"text": e.Message, "attachments": []any{att}})
+	if err != nil {
+		return Permanent{err}
+	}
+	return postJSON(ctx, s.Client, s.URL, nil, body)
+}
+
+// Discord posts events to a Discord channel webhook.
+type Discord struct {
+	URL    string
+	Client *http.Client
+}
+
+func (d *Discord) Send(ctx context.Context, e Event) error {
+	title, color, fields := describe(e)
+	type discordField struct {
+		Name   string `json:"name"`
+		Value  string `json:"value"`
+		Inline bool   `json:"inline"`
+	}
+	embed := struct {
+		Title       string         `json:"title"`
+		Description string         `json:"description"`
+		Color       int            `json:"color"`
+		Fields      []discordField `json:"fields,omitempty"`
+		Footer      struct {
+			Text string `json:"text"`
+		} `json:"footer"`
+		Timestamp string `json:"timestamp"`
+	}{Title: title, Description: e.Message, Color: color, Timestamp: e.Time.UTC().Format(time.RFC3339)}
+	embed.Footer.Text = footer(e.Source)
+	for _, f := range fields {
+		embed.Fields = append(embed.Fields, discordField{f.name, f.value, !f.wide})
+	}
+	body, err := json.Marshal(map[string]any{"username": "miser", "embeds": []any{embed}})
+	if err != nil {
+		return Permanent{err}
+	}
+	return postJSON(ctx, d.Client, d.URL, nil, body)
+}
+
+type field struct {
+	name, value string
+	wide        bool // gets a line of its own
+}
+
+// describe turns an event into a chat title, color and fields.
+func describe(e Event) (title string, color int, fields []field) {
+	switch d := e.Data.(type) {
+	case BudgetData:
+		title, color = fmt.Sprintf("Budget %.0f%% used", d.Threshold*100), colorWarning
+		if d.Threshold >= 1 {
+			title, color = "Budget reached", colorDanger
+		}
+		fields = []field{
+			{name: "Spent", value: format.Cost(d.Spent)},
+			{name: "Limit", value: format.Cost(d.Limit)},
+			{name: "Window", value: d.Period},
+			{name: "Action", value: d.Action},
+		}
+	case ErrorRateData:
+		title, color = "Error rate spike", colorDanger
+		fields = []field{
+			{name: "Failed", value: fmt.Sprintf("%d of %d (%.0f%%)", d.Errors, d.Requests, d.Rate*100)},
+			{name: "Window", value: (time.Duration(d.WindowSecs) * time.Second).String()},
+		}
+		if len(d.Statuses) > 0 {
+			codes := make([]string, len(d.Statuses))
+			for i, c := range d.Statuses {
+				codes[i] = fmt.Sprint(c)
+			}
+			fields = append(fields, field{name: "Statuses", value: strings.Join(codes, ", ")})
+		}
+		if len(d.Models) > 0 {
+			fields = append(fields, field{name: "Models", value: strings.Join(d.Models, ", ")})
+		}
+		if d.LastError != "" {
+			fields = append(fields, field{name: "Last error", value: d.LastError, wide: true})
+		}
+	case RequestData:
+		title, color = "Expensive request", colorWarning
+		fields = []field{
+			{name: "Cost", value: format.Cost(d.Cost)},
+			{name: "Model", value: d.Model},
+			{name: "Input", value: format.Tokens(d.InputTokens)},
+			{name: "Output", value: format.Tokens(d.OutputTokens)},
+		}
+	case RateLimitData:
+		title, color = "Rate limit running low", colorWarning
+		fields = []field{
+			{name: "Limit", value: d.Limit},
+			{name: "Remaining", value: fmt.Sprintf("%s of %s (%.0f%%)", format.Int(d.Remaining), format.Int(d.Max), d.Headroom*100)},
+			{name: "Model", value: d.Model},
+		}
+		if !d.Reset.IsZero() {
+			fields = append(fields, field{name: "Resets", value: d.Reset.Local().Format(time.TimeOnly)})
+		}
+	case SLOData:
+		title, color = "Latency objective missed", colorWarning
+		fields = []field{
+			{name: "Model", value: d.Model},
+			{name: "Objective", value: fmt.Sprintf("p%s < %s over %s", trimPercent(d.Percentile),
+				time.Duration(d.Latency*float64(time.Second)), time.Duration(d.WindowSecs)*time.Second)},
+			{name: "Within", value: fmt.Sprintf("%d of %d (%.0f%%)", d.Within, d.Requests, d.Compliance*100)},
+			{name: fmt.Sprintf("Observed p%s", trimPercent(d.Percentile)), value: time.Duration(d.Observed * float64(time.Second)).Round(time.Millisecond).String()},
+		}
+	case SummaryData:
+		title, color = "Session summary", colorInfo
+		switch e.Type {
+		case DailySummary:
+			title = "Daily summary"
+		case ScheduledSummary:
+			title = "Usage summary"
+		}
+		fields = []field{
+			{name: "Cost", value: format.Cost(d.Cost)},
+			{name: "Requests", value: fmt.Sprintf("%s (%s)", format.Int(d.Requests), plural(d.Errors, "error"))},
+			{name: "Input", value: format.Tokens(d.InputTokens)},
+			{name: "Output", value: format.Tokens(d.OutputTokens)},
+		}
+		if len(d.Models) > 0 {
+			lines := make([]string, len(d.Models))
+			for i, m := range d.Models {
+				lines[i] = fmt.Sprintf("%s: %s over %s requests", m.Model, format.Cost(m.Cost), format.Int(m.Requests))
+			}
+			fields = append(fields, field{name: "Top models", value: strings.Join(lines, "\n"), wide: true})
+		}
+		if len(d.TopRequests) > 0 {
+			lines := make([]string, len(d.TopRequests))
+			for i, r := range d.TopRequests {
+				lines[i] = fmt.Sprintf("#%d %s: %s (%s in, %s out)", r.ID, r.Model, format.Cost(r.Cost),
+					format.Tokens(r.InputTokens), format.Tokens(r.OutputTokens))
+			}
+			fields = append(fields, field{name: "Top requests", value: strings.Join(lines, "\n"), wide: true})
+		}
+	default:
+		title, color = e.Type, colorInfo
+	}
+	return title, color, fields
+}
+
+func footer(s Source) string {
+	f := "miser " + s.Version
+	if s.Host != "" {
+		f += " on " + s.Host
+	}
+	if s.Profile != "" {
+		f += " (profile " + s.Profile + ")"
+	}
+	return f
+}
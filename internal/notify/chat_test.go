@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func capture(t *testing.T, s func(url string) Sender, e Event) map[string]any {
+	t.Helper()
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+	if err := s(srv.URL).Send(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+var budgetEvent = Event{
+	Type:    BudgetThreshold,
+	Time:    time.Date(2026, 1, 24, 14, 0, 0, 0, time.UTC),
+	Message: "miser: 100% of the $5.00 budget used today ($5.10 spent)",
+	Data:    BudgetData{Threshold: 1, Limit: 5, Spent: 5.1, Period: "day", Action: "block"},
+	Source:  Source{Version: "v1", Host: "box", Profile: "work"},
+}
+
+func TestSlack(t *testing.T) {
+	got := capture(t, func(url string) Sender { return &Slack{URL: url} }, budgetEvent)
+	if got["text"] != budgetEvent.Message {
+		t.Errorf("text = %v", got["text"])
+	}
+	att := got["attachments"].([]any)[0].(map[string]any)
+	if att["title"] != "Budget reached" || att["color"] != "#e01e5a" || att["footer"] != "miser v1 on box (profile work)" {
+		t.Errorf("attachment = %v", att)
+	}
+	if f := att["fields"].([]any)[0].(map[string]any); f["title"] != "Spent" || f["value"] != "$5.100" {
+		t.Errorf("first field = %v", f)
+	}
+}
+
+func TestDiscord(t *testing.T) {
+	e := budgetEvent
+	e.Data = BudgetData{Threshold: 0.8, Limit: 5, Spent: 4, Period: "day", Action: "warn"}
+	got := capture(t, func(url string) Sender { return &Discord{URL: url} }, e)
+	embed := got["embeds"].([]any)[0].(map[string]any)
+	if embed["title"] != "Budget 80% used" || embed["color"] != float64(colorWarning) || embed["timestamp"] != "2026-01-24T14:00:00Z" {
+		t.Errorf("embed = %v", embed)
+	}
+	if n := len(embed["fields"].([]any)); n != 4 {
+		t.Errorf("fields = %d", n)
+	}
+}
@@ -0,0 +1,32 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Desktop shows events as native desktop notifications: notify-send on
+// Linux and the BSDs, osascript on macOS and a toast on Windows.
+type Desktop struct{}
+
+func (Desktop) Send(ctx context.Context, e Event) error {
+	title, color, _ := describe(e)
+	body := strings.TrimPrefix(e.Message, "miser: ")
+	if body != "" {
+		body = strings.ToUpper(body[:1]) + body[1:]
+	}
+	cmd := desktopCommand(ctx, "miser: "+title, body, color == colorDanger)
+	out, err := cmd.CombinedOutput()
+	switch {
+	case errors.Is(err, exec.ErrNotFound):
+		return Permanent{fmt.Errorf("%s is not installed", DesktopTool)}
+	case err != nil:
+		// Notifying twice is worse than not at all.
+		return Permanent{fmt.Errorf("%s: %v: %s", DesktopTool, err, bytes.TrimSpace(out))}
+	}
+	return nil
+}
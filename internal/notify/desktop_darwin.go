@@ -0,0 +1,18 @@
+package notify
+
+import (
+	"context"
+	"os/exec"
+)
+
+// DesktopTool is the program Desktop runs.
+const DesktopTool = "osascript"
+
+// Passing the text as arguments avoids quoting it as AppleScript.
+const displayScript = `on run argv
+	display notification (item 2 of argv) with title (item 1 of argv)
+end run`
+
+func desktopCommand(ctx context.Context, title, body string, urgent bool) *exec.Cmd {
+	return exec.CommandContext(ctx, DesktopTool, "-e", displayScript, title, body)
+}
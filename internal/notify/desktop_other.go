@@ -0,0 +1,19 @@
+//go:build !darwin && !windows
+
+package notify
+
+import (
+	"context"
+	"os/exec"
+)
+
+// DesktopTool is the program Desktop runs.
+const DesktopTool = "notify-send"
+
+func desktopCommand(ctx context.Context, title, body string, urgent bool) *exec.Cmd {
+	urgency := "normal"
+	if urgent {
+		urgency = "critical"
+	}
+	return exec.CommandContext(ctx, DesktopTool, "--app-name=miser", "--urgency="+urgency, "--", title, body)
+}
@@ -0,0 +1,27 @@
+package notify
+
+import (
+	"context"
+	"os"
+	"os/exec"
+)
+
+// DesktopTool is the program Desktop runs.
+const DesktopTool = "powershell"
+
+// toastScript reads the text from the environment so it never needs
+// quoting as PowerShell.
+const toastScript = `
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $xml.GetElementsByTagName('text')
+$text.Item(0).AppendChild($xml.CreateTextNode($env:MISER_TOAST_TITLE)) > $null
+$text.Item(1).AppendChild($xml.CreateTextNode($env:MISER_TOAST_BODY)) > $null
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('miser').Show([Windows.UI.Notifications.ToastNotification]::new($xml))
+`
+
+func desktopCommand(ctx context.Context, title, body string, urgent bool) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, DesktopTool, "-NoProfile", "-NonInteractive", "-Command", toastScript)
+	cmd.Env = append(os.Environ(), "MISER_TOAST_TITLE="+title, "MISER_TOAST_BODY="+body)
+	return cmd
+}
@@ -0,0 +1,243 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"miser/internal/format"
+)
+
+// Email sends events as HTML mail, with a plain-text alternative, through
+// an SMTP server.
+type Email struct {
+	Host     string
+	Port     int    // 465 uses implicit TLS; others upgrade with STARTTLS when offered
+	Username string // "" skips authentication
+	Password string
+	From     string   // e.g. "miser <miser@example.com>"
+	To       []string // addresses, with or without display names
+}
+
+func (m *Email) Send(ctx context.Context, e Event) error {
+	msg, err := m.message(e)
+	if err != nil {
+		return Permanent{err}
+	}
+	return smtpError(m.deliver(ctx, msg))
+}
+
+func (m *Email) deliver(ctx context.Context, msg []byte) error {
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	tlsConfig := &tls.Config{ServerName: m.Host}
+	var conn net.Conn
+	var err error
+	if m.Port == 465 {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok && m.Port != 465 {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if m.Username != "" {
+		// PlainAuth refuses to send the password unencrypted, except to
+		// localhost.
+		if err := c.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host)); err != nil {
+			return Permanent{fmt.Errorf("smtp auth: %w", err)}
+		}
+	}
+	if err := c.Mail(envelope(m.From)); err != nil {
+		return err
+	}
+	for _, to := range m.To {
+		if err := c.Rcpt(envelope(to)); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// envelope strips the display name from an address for MAIL and RCPT.
+func envelope(addr string) string {
+	if a, err := mail.ParseAddress(addr); err == nil {
+		return a.Address
+	}
+	return addr
+}
+
+// smtpError makes 5xx replies permanent; 4xx ones are worth retrying.
+func smtpError(err error) error {
+	var te *textproto.Error
+	if errors.As(err, &te) && te.Code >= 500 {
+		return Permanent{err}
+	}
+	return err
+}
+
+func (m *Email) message(e Event) ([]byte, error) {
+	var html, text bytes.Buffer
+	if err := emailPage.Execute(&html, emailView(e)); err != nil {
+		return nil, err
+	}
+	_, _, fields := describe(e)
+	text.WriteString(e.Message + "\n\n")
+	for _, f := range fields {
+		if f.wide {
+			fmt.Fprintf(&text, "%s:\n%s\n", f.name, f.value)
+		} else {
+			fmt.Fprintf(&text, "%s: %s\n", f.name, f.value)
+		}
+	}
+	text.WriteString("\n-- \n" + footer(e.Source) + "\n")
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		typ  string
+		data []byte
+	}{{"text/plain", text.Bytes()}, {"text/html", html.Bytes()}} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.typ + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(pw)
+		qp.Write(part.data)
+		qp.Close()
+	}
+	mw.Close()
+
+	id := make([]byte, 12)
+	rand.Read(id)
+	var msg bytes.Buffer
+	for _, h := range [][2]string{
+		{"From", m.From},
+		{"To", strings.Join(m.To, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", e.Message)},
+		{"Date", e.Time.Format(time.RFC1123Z)},
+		{"Message-ID", "<" + hex.EncodeToString(id) + "@miser>"},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + mw.Boundary()},
+	} {
+		fmt.Fprintf(&msg, "%s: %s\r\n", h[0], h[1])
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+type emailData struct {
+	Title, Message, Color, Footer string
+	Summary                       *SummaryData
+	Fields                        []field
+}
+
+func emailView(e Event) emailData {
+	title, color, fields := describe(e)
+	v := emailData{
+		Title:   title,
+		Message: strings.TrimPrefix(e.Message, "miser: "),
+		Color:   fmt.Sprintf("#%06x", color),
+		Footer:  footer(e.Source),
+		Fields:  fields,
+	}
+	if d, ok := e.Data.(SummaryData); ok {
+		v.Summary = &d
+	}
+	return v
+}
+
+// Mail clients drop <style> blocks, so the styles are inline.
+var emailPage = template.Must(template.New("email").Funcs(template.FuncMap{
+	"cost":   format.Cost,
+	"tokens": format.Tokens,
+	"int":    format.Int,
+	"share": func(part, total float64) string {
+		if total <= 0 {
+			return "–"
+		}
+		return fmt.Sprintf("%.1f%%", 100*part/total)
+	},
+	"name":  func(f field) string { return f.name },
+	"value": func(f field) string { return f.value },
+}).Parse(`<!DOCTYPE html>
+<html><body style="font:14px/1.45 -apple-system,'Segoe UI',sans-serif;color:#1d232b;max-width:720px">
+<h2 style="margin-bottom:.2em;border-left:4px solid {{.Color}};padding-left:.5em">{{.Title}}</h2>
+<p style="color:#6b7480;margin-top:0">{{.Message}}</p>
+{{- $td := "padding:.3em .6em;border-bottom:1px solid #e3e6ea;text-align:right;white-space:nowrap" }}
+{{- $th := "padding:.3em .6em;border-bottom:1px solid #e3e6ea;text-align:right;color:#6b7480" }}
+{{- with .Summary}}
+<table style="border-collapse:collapse;margin-bottom:1.5em">
+<tr><th style="{{$th}}">Cost</th><th style="{{$th}}">Requests</th><th style="{{$th}}">Errors</th><th style="{{$th}}">Input</th><th style="{{$th}}">Output</th><th style="{{$th}}">Cache read</th><th style="{{$th}}">Cache write</th></tr>
+<tr><td style="{{$td}}"><b>{{cost .Cost}}</b></td><td style="{{$td}}">{{int .Requests}}</td><td style="{{$td}}">{{int .Errors}}</td><td style="{{$td}}">{{tokens .InputTokens}}</td><td style="{{$td}}">{{tokens .OutputTokens}}</td><td style="{{$td}}">{{tokens .CacheRead}}</td><td style="{{$td}}">{{tokens .CacheWrite}}</td></tr>
+</table>
+{{- if .Models}}
+<h3>By model</h3>
+<table style="border-collapse:collapse;width:100%;margin-bottom:1.5em">
+<tr><th style="{{$th}};text-align:left">Model</th><th style="{{$th}}">Requests</th><th style="{{$th}}">Input</th><th style="{{$th}}">Output</th><th style="{{$th}}">Cost</th><th style="{{$th}}">Share</th></tr>
+{{- $total := .Cost}}{{range .Models}}
+<tr><td style="{{$td}};text-align:left">{{.Model}}</td><td style="{{$td}}">{{int .Requests}}</td><td style="{{$td}}">{{tokens .InputTokens}}</td><td style="{{$td}}">{{tokens .OutputTokens}}</td><td style="{{$td}}">{{cost .Cost}}</td><td style="{{$td}}">{{share .Cost $total}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .TopRequests}}
+<h3>Most expensive requests</h3>
+<table style="border-collapse:collapse;width:100%;margin-bottom:1.5em">
+<tr><th style="{{$th}};text-align:left">Time</th><th style="{{$th}};text-align:left">Model</th><th style="{{$th}}">Input</th><th style="{{$th}}">Output</th><th style="{{$th}}">Cost</th></tr>
+{{- range .TopRequests}}
+<tr><td style="{{$td}};text-align:left">{{.Time.Local.Format "2006-01-02 15:04"}}</td><td style="{{$td}};text-align:left">{{.Model}}</td><td style="{{$td}}">{{tokens .InputTokens}}</td><td style="{{$td}}">{{tokens .OutputTokens}}</td><td style="{{$td}}">{{cost .Cost}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- else}}
+<table style="border-collapse:collapse;margin-bottom:1.5em">
+{{- range .Fields}}
+<tr><th style="{{$th}};text-align:left;vertical-align:top">{{name .}}</th><td style="{{$td}};text-align:left;white-space:pre-wrap">{{value .}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+<p style="color:#6b7480;font-size:12px">{{.Footer}}</p>
+</body></html>
+`))
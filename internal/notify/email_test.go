@@ -0,0 +1,124 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP accepts one message and hands its DATA to got. rcpt is the
+// reply to RCPT TO.
+func fakeSMTP(t *testing.T, rcpt string, got chan<- string) (host string, port int) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 fake ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+			case "EHLO", "HELO":
+				tp.PrintfLine("250 fake")
+			case "RCPT":
+				tp.PrintfLine("%s", rcpt)
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				data, _ := tp.ReadDotBytes()
+				got <- string(data)
+				tp.PrintfLine("250 queued")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("250 ok")
+			}
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func TestEmailReport(t *testing.T) {
+	got := make(chan string, 1)
+	host, port := fakeSMTP(t, "250 ok", got)
+	m := &Email{Host: host, Port: port, From: "miser@example.com", To: []string{"a@example.com", "b@example.com"}}
+	e := Event{
+		Type:    ScheduledSummary,
+		Time:    time.Date(2026, 1, 19, 8, 0, 0, 0, time.UTC),
+		Message: "miser: $4.700 over 4 requests in the last 7 days (1 error)",
+		Data: SummaryData{Requests: 4, Errors: 1, Cost: 4.7, Models: []ModelCost{
+			{Model: "claude-opus-4", Requests: 1, Cost: 3.525},
+			{Model: "<haiku>", Requests: 3, Cost: 1.175},
+		}},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Send(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(<-got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject")); s != e.Message {
+		t.Errorf("subject = %q", s)
+	}
+	if to := msg.Header.Get("To"); to != "a@example.com, b@example.com" {
+		t.Errorf("to = %q", to)
+	}
+	_, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	parts := map[string]string{}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart() // decodes quoted-printable
+		if err != nil {
+			break
+		}
+		body, _ := io.ReadAll(p)
+		typ, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		parts[typ] = string(body)
+	}
+	html := parts["text/html"]
+	for _, want := range []string{"<b>$4.700</b>", "claude-opus-4", "&lt;haiku&gt;", "75.0%", "25.0%"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("HTML part lacks %q:\n%s", want, html)
+		}
+	}
+	if !strings.Contains(parts["text/plain"], e.Message) {
+		t.Errorf("text part = %q", parts["text/plain"])
+	}
+}
+
+func TestEmailRejectedIsPermanent(t *testing.T) {
+	host, port := fakeSMTP(t, "550 no such user", make(chan string, 1))
+	m := &Email{Host: host, Port: port, From: "miser@example.com", To: []string{"nobody@example.com"}}
+	err := m.Send(context.Background(), Event{Type: ErrorRate, Message: "miser: errors", Time: time.Now()})
+	var p Permanent
+	if !errors.As(err, &p) {
+		t.Fatalf("err = %v, want permanent", err)
+	}
+	if !strings.Contains(err.Error(), "550") {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+// Package notify delivers alert events (budget thresholds, error spikes,
+// session summaries) to external systems such as webhooks.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	BudgetThreshold  = "budget_threshold"  // spend crossed a configured fraction of the budget
+	ErrorRate        = "error_rate"        // too many recent requests failed
+	ExpensiveRequest = "expensive_request" // one request cost more than a set amount
+	RateLimitLow     = "rate_limit"        // little of upstream's rate limit is left
+	LatencySLO       = "latency_slo"       // a model is missing its [slo] latency objective
+	SessionSummary   = "session_summary"   // the proxy is shutting down
+	DailySummary     = "daily_summary"     // the last 24 hours, at a set time of day
+	ScheduledSummary = "scheduled_summary" // a [[notify.schedule]] digest
+)
+
+// EventTypes lists every event type, for validating config.
+var EventTypes = []string{BudgetThreshold, ErrorRate, ExpensiveRequest, RateLimitLow, LatencySLO, SessionSummary, DailySummary, ScheduledSummary}
+
+// Event is one notification. It is sent to webhooks as JSON.
+type Event struct {
+	Type    string    `json:"event"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"` // one line for humans
+	Data    any       `json:"data,omitempty"`
+	Source  Source    `json:"source"`
+	To      string    `json:"-"` // only this target, by name, if set
+}
+
+// Source identifies the miser instance an event came from.
+type Source struct {
+	Version string `json:"version"`
+	Host    string `json:"host"`
+	Profile string `json:"profile,omitempty"`
+}
+
+// Sender delivers an event to one destination.
+type Sender interface {
+	Send(ctx context.Context, e Event) error
+}
+
+// Target is a sender plus the event types it wants.
+type Target struct {
+	Name   string // for log messages
+	Sender Sender
+	Events []string // nil means every type; empty means only events sent To it
+}
+
+func (t Target) wants(e Event) bool {
+	if e.To != "" {
+		return e.To == t.Name
+	}
+	return t.Events == nil || slices.Contains(t.Events, e.Type)
+}
+
+// Permanent marks a delivery error that retrying cannot fix, such as a
+// 4xx response.
+type Permanent struct{ Err error }
+
+func (p Permanent) Error() string { return p.Err.Error() }
+func (p Permanent) Unwrap() error { return p.Err }
+
+const (
+	queueSize   = 64
+	sendTimeout = 10 * time.Second
+)
+
+// Dispatcher fans events out to targets in the background, retrying
+// failed deliveries with exponential backoff.
+type Dispatcher struct {
+	Attempts int           // per target and event, including the first
+	Backoff  time.Duration // before the first retry; doubles each time
+
+	source  Source
+	targets []Target
+	logf    func(format string, args ...any)
+	queue   chan Event
+	wg      sync.WaitGroup
+	once    sync.Once
+	closed  chan struct{}
+	drained chan struct{} // run has handed out every queued event
+}
+
+// NewDispatcher starts delivering to targets. logf reports deliveries that
+// failed for good; it may be nil.
+func NewDispatcher(source Source, targets []Target, logf func(string, ...any)) *Dispatcher {
+	if logf == nil {
+		logf = func(string, ...any) {}
+	}
+	d := &Dispatcher{
+		Attempts: 4,
+		Backoff:  time.Second,
+		source:   source,
+		targets:  targets,
+		logf:     logf,
+		queue:    make(chan Event, queueSize),
+		closed:   make(chan struct{}),
+		drained:  make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+// Notify queues e without blocking. Events are dropped (and logged) if
+// the queue is full or the dispatcher is closed.
+func (d *Dispatcher) Notify(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Source = d.source
+	select {
+	case <-d.closed:
+		return
+	default:
+	}
+	select {
+	case d.queue <- e:
+	default:
+		d.logf("notify: queue full, dropped %s event", e.Type)
+	}
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.drained)
+	for {
+		select {
+		case e := <-d.queue:
+			d.dispatch(e)
+		case <-d.closed:
+			// Drain what was queued before Close.
+			for {
+				select {
+				case e := <-d.queue:
+					d.dispatch(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(e Event) {
+	for _, t := range d.targets {
+		if !t.wants(e) {
+			continue
+		}
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			if err := d.deliver(t, e); err != nil {
+				d.logf("notify: %s: %s event not delivered: %v", t.Name, e.Type, err)
+			}
+		}()
+	}
+}
+
+func (d *Dispatcher) deliver(t Target, e Event) error {
+	wait := d.Backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		err = t.Sender.Send(ctx, e)
+		cancel()
+		if err == nil || errors.As(err, new(Permanent)) || attempt >= d.Attempts {
+			return err
+		}
+		select {
+		case <-time.After(wait):
+		case <-d.closed:
+			// Shutting down: one last try is all we have time for.
+			if attempt+1 < d.Attempts {
+				attempt = d.Attempts - 1
+			}
+		}
+		wait *= 2
+	}
+}
+
+// Close stops accepting events and waits until queued ones are delivered
+// or ctx ends. Retries are cut short once Close is called.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.once.Do(func() { close(d.closed) })
+	done := make(chan struct{})
+	go func() {
+		<-d.drained
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Webhook POSTs events as JSON to a URL.
+type Webhook struct {
+	URL    string
+	Header http.Header // extra request headers, e.g. Authorization
+	Client *http.Client
+}
+
+func (w *Webhook) Send(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return Permanent{err}
+	}
+	return postJSON(ctx, w.Client, w.URL, w.Header, body)
+}
+
+// postJSON sends body and maps the response status to an error; 4xx
+// other than 429 is permanent.
+func postJSON(ctx context.Context, hc *http.Client, url string, header http.Header, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return Permanent{err}
+	}
+	for k, vv := range header {
+		for _, v := range vv {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "miser")
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return Permanent{err}
+	}
+	return err
+}
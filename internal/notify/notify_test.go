@@ -0,0 +1,252 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"miser/internal/budget"
+	"miser/internal/schedule"
+	"miser/internal/slo"
+	"miser/internal/tracker"
+)
+
+func TestWebhookRetries(t *testing.T) {
+	var calls atomic.Int64
+	var mu sync.Mutex
+	var got Event
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	hook := &Webhook{URL: srv.URL, Header: http.Header{"Authorization": {"Bearer x"}}}
+	d := NewDispatcher(Source{Version: "test", Host: "box"}, []Target{{Name: "hook", Sender: hook}}, nil)
+	d.Backoff = time.Millisecond
+	d.Notify(Event{Type: ErrorRate, Message: "boom"})
+	time.Sleep(50 * time.Millisecond) // let the retries run before Close cuts them short
+	if err := d.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := calls.Load(); n != 3 {
+		t.Fatalf("calls = %d, want 3", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if got.Type != ErrorRate || got.Message != "boom" || got.Source.Host != "box" || got.Time.IsZero() {
+		t.Errorf("payload = %+v", got)
+	}
+	if auth != "Bearer x" {
+		t.Errorf("Authorization = %q", auth)
+	}
+}
+
+func TestWebhookClientErrorIsPermanent(t *testing.T) {
+	var calls atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "bad token", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	var logged []string
+	var mu sync.Mutex
+	logf := func(format string, args ...any) {
+		mu.Lock()
+		logged = append(logged, format)
+		mu.Unlock()
+	}
+	d := NewDispatcher(Source{}, []Target{
+		{Name: "hook", Sender: &Webhook{URL: srv.URL}},
+		{Name: "summaries only", Sender: &Webhook{URL: srv.URL}, Events: []string{SessionSummary}},
+	}, logf)
+	d.Backoff = time.Millisecond
+	d.Notify(Event{Type: BudgetThreshold})
+	d.Close(context.Background())
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("calls = %d, want 1 (no retry, filtered target skipped)", n)
+	}
+	if len(logged) != 1 || !strings.Contains(logged[0], "not delivered") {
+		t.Errorf("logged = %q", logged)
+	}
+}
+
+func TestWatchBudget(t *testing.T) {
+	tr := tracker.New()
+	b := budget.New(budget.Config{Limit: 10}, tr)
+	var got []float64
+	WatchBudget(tr, b, []float64{0.8, 0.5, 1}, func(e Event) {
+		got = append(got, e.Data.(BudgetData).Threshold)
+	})
+
+	for _, cost := range []float64{3, 3, 3, 0.5, 1, 1} {
+		tr.Record(tracker.Request{Timestamp: time.Now(), Cost: cost})
+	}
+	// 3 → nothing, 6 → 50%, 9 → 80%, 9.5 → nothing, 10.5 → 100%, then quiet.
+	if want := []float64{0.5, 0.8, 1}; !slices.Equal(got, want) {
+		t.Fatalf("thresholds = %v, want %v", got, want)
+	}
+
+	// A new window alerts afresh; a raised limit only for what it newly crosses.
+	b.Reset()
+	tr.Record(tracker.Request{Timestamp: time.Now(), Cost: 8})
+	b.SetLimit(100)
+	tr.Record(tracker.Request{Timestamp: time.Now(), Cost: 1})
+	tr.Record(tracker.Request{Timestamp: time.Now(), Cost: 50})
+	if want := []float64{0.5, 0.8, 1, 0.8, 0.5}; !slices.Equal(got, want) {
+		t.Fatalf("thresholds = %v, want %v", got, want)
+	}
+}
+
+func TestWatchErrors(t *testing.T) {
+	tr := tracker.New()
+	var events []ErrorRateData
+	WatchErrors(tr, ErrorRateConfig{Rate: 0.5, Window: time.Minute, MinRequests: 4}, func(e Event) {
+		events = append(events, e.Data.(ErrorRateData))
+	})
+	record := func(status int) {
+		tr.Record(tracker.Request{Timestamp: time.Now(), Model: "m", StatusCode: status})
+	}
+
+	record(529)
+	record(529) // 2 of 2 failed, but below MinRequests
+	record(200)
+	record(200) // 2 of 4: alert
+	record(529) // still high: no second alert
+	if len(events) != 1 || events[0].Errors != 2 || events[0].Requests != 4 {
+		t.Fatalf("events = %+v", events)
+	}
+	if s := events[0].Statuses; len(s) != 1 || s[0] != 529 {
+		t.Errorf("statuses = %v", s)
+	}
+
+	for range 3 {
+		record(200) // 3 of 8: recovered
+	}
+	for range 5 {
+		record(500)
+	}
+	if len(events) != 2 {
+		t.Fatalf("events after second spike = %d, want 2", len(events))
+	}
+}
+
+func TestWatchSLO(t *testing.T) {
+	tr := tracker.New()
+	var events []SLOData
+	objs := []slo.Objective{
+		{Pattern: "claude-opus-*", Latency: 20 * time.Second, Percentile: 90, Window: time.Hour, MinRequests: 4, Alert: true},
+		{Pattern: "claude-haiku-*", Latency: time.Second, Percentile: 90, Window: time.Hour, MinRequests: 1},
+	}
+	WatchSLO(tr, objs, func(e Event) {
+		events = append(events, e.Data.(SLOData))
+	})
+	record := func(model string, latency time.Duration) {
+		tr.Record(tracker.Request{Timestamp: time.Now(), Model: model, StatusCode: 200, Latency: latency})
+	}
+
+	record("claude-haiku-4-5", time.Minute) // breached, but no alert asked for
+	record("claude-opus-4-6", 30*time.Second)
+	record("claude-opus-4-6", 30*time.Second) // below MinRequests
+	tr.Record(tracker.Request{Timestamp: time.Now(), Model: "claude-opus-4-6", StatusCode: 529, Latency: time.Minute})
+	record("claude-opus-4-6", 5*time.Second)
+	record("claude-opus-4-6", 5*time.Second)  // 2 of 4 within: alert; the failure isn't counted
+	record("claude-opus-4-6", 30*time.Second) // still breached: no second alert
+	if len(events) != 1 || events[0].Requests != 4 || events[0].Within != 2 || events[0].Pattern != "claude-opus-*" {
+		t.Fatalf("events = %+v", events)
+	}
+
+	for range 40 {
+		record("claude-opus-4-6", time.Second) // 42 of 45: recovered
+	}
+	for range 10 {
+		record("claude-opus-4-6", time.Minute)
+	}
+	if len(events) != 2 {
+		t.Fatalf("events after second breach = %d, want 2", len(events))
+	}
+}
+
+func TestWatchRateLimit(t *testing.T) {
+	tr := tracker.New()
+	var events []RateLimitData
+	WatchRateLimit(tr, 0.2, func(e Event) {
+		events = append(events, e.Data.(RateLimitData))
+	})
+	record := func(remaining int) {
+		reset := time.Now().Add(time.Minute)
+		tr.Record(tracker.Request{Model: "m", RateLimit: &tracker.RateLimit{
+			Requests: tracker.Limit{Limit: 50, Remaining: 45, Reset: reset},
+			Tokens:   tracker.Limit{Limit: 1000, Remaining: remaining, Reset: reset},
+		}})
+	}
+
+	record(500)
+	tr.Record(tracker.Request{Model: "m"}) // no headers
+	record(150)                            // alert
+	record(100)                            // still low: no second alert
+	if len(events) != 1 || events[0].Limit != "tokens" || events[0].Remaining != 150 || events[0].Headroom != 0.15 {
+		t.Fatalf("events = %+v", events)
+	}
+	record(1000) // reset
+	record(50)
+	if len(events) != 2 {
+		t.Fatalf("events after the reset = %d, want 2", len(events))
+	}
+}
+
+func TestWatchCost(t *testing.T) {
+	tr := tracker.New()
+	var got []RequestData
+	WatchCost(tr, 0.5, func(e Event) { got = append(got, e.Data.(RequestData)) })
+	for _, cost := range []float64{0.1, 0.5, 2} {
+		tr.Record(tracker.Request{Timestamp: time.Now(), Model: "m", Cost: cost})
+	}
+	if len(got) != 2 || got[0].Cost != 0.5 || got[1].Cost != 2 || got[1].Threshold != 0.5 {
+		t.Fatalf("events = %+v", got)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	now := time.Date(2026, 1, 19, 9, 0, 0, 0, time.UTC) // a Monday
+	tr := tracker.New()
+	for i, cost := range []float64{0.2, 3, 1, 0.5} {
+		tr.Record(tracker.Request{Timestamp: now.Add(-time.Duration(i+1) * time.Hour), Model: "m", Cost: cost})
+	}
+	tr.Record(tracker.Request{Timestamp: now.AddDate(0, 0, -4), Model: "old", Cost: 9})
+
+	weekdays, _ := schedule.ParseCron("0 9 * * 1-5")
+	g := Digest{When: weekdays, Type: ScheduledSummary, Top: 2, To: "schedule 1"}
+	e := g.event(tr, weekdays.Prev(now), now)
+	d := e.Data.(SummaryData)
+	if e.Message != "miser: $4.700 over 4 requests in the last 3 days (0 errors)" {
+		t.Errorf("message = %q", e.Message)
+	}
+	if len(d.TopRequests) != 2 || d.TopRequests[0].Cost != 3 || d.TopRequests[1].Cost != 1 {
+		t.Errorf("top requests = %+v", d.TopRequests)
+	}
+
+	// Only the addressed target receives it, even one that wants every type.
+	all := Target{Name: "webhook a"}
+	own := Target{Name: "schedule 1", Events: []string{}}
+	if all.wants(e) || !own.wants(e) || own.wants(Event{Type: ScheduledSummary}) {
+		t.Error("digest routed to the wrong targets")
+	}
+}
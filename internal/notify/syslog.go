@@ -0,0 +1,28 @@
+package notify
+
+import (
+	"context"
+	"strings"
+
+	"miser/internal/syslog"
+)
+
+// Syslog writes each event's message as one syslog line: at error
+// severity for error spikes and a reached budget, warning for other
+// alerts, info for summaries.
+type Syslog struct {
+	W *syslog.Writer
+}
+
+func (s Syslog) Send(_ context.Context, e Event) error {
+	_, color, _ := describe(e)
+	// The syslog tag already names miser.
+	msg := "alert " + e.Type + ": " + strings.TrimPrefix(e.Message, "miser: ")
+	switch color {
+	case colorDanger:
+		return s.W.Err(msg)
+	case colorWarning:
+		return s.W.Warning(msg)
+	}
+	return s.W.Info(msg)
+}
@@ -0,0 +1,474 @@
+package notify
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"miser/internal/budget"
+	"miser/internal/format"
+	"miser/internal/schedule"
+	"miser/internal/slo"
+	"miser/internal/tracker"
+)
+
+// BudgetData is the payload of a BudgetThreshold event.
+type BudgetData struct {
+	Threshold float64   `json:"threshold"` // fraction of the limit, e.g. 0.8
+	Limit     float64   `json:"limit"`
+	Spent     float64   `json:"spent"`
+	Period    string    `json:"period"`
+	Since     time.Time `json:"since"`
+	Until     time.Time `json:"until,omitzero"` // when spend next drops
+	Action    string    `json:"action"`
+}
+
+// WatchBudget sends a BudgetThreshold event the first time spend reaches
+// each of thresholds (fractions of the limit) in a budget window, or for a
+// rolling budget, each time it climbs back to one. It must be called after
+// b is created so b sees each request first.
+func WatchBudget(t *tracker.Tracker, b *budget.Budget, thresholds []float64, notify func(Event)) (unsubscribe func()) {
+	thresholds = slices.Clone(thresholds)
+	slices.Sort(thresholds)
+
+	var mu sync.Mutex
+	var since time.Time
+	var limit float64
+	crossed := 0 // thresholds[:crossed] already reached this window
+	check := func(send bool) {
+		st := b.Status()
+		n := 0
+		for n < len(thresholds) && st.Fraction() >= thresholds[n] {
+			n++
+		}
+		mu.Lock()
+		switch {
+		case st.Period == budget.Rolling && st.Limit == limit:
+			// A rolling window never starts over, but spend ages out of
+			// it, and a threshold it drops back below alerts again.
+			crossed = min(crossed, n)
+		case st.Period != budget.Rolling && !st.Since.Equal(since):
+			since, limit, crossed = st.Since, st.Limit, 0
+		case st.Limit != limit:
+			// A new limit (e.g. from "miser ctl budget") only alerts for
+			// thresholds crossed after the change.
+			limit, crossed = st.Limit, n
+		}
+		// Only the highest newly reached threshold is worth an alert.
+		fire := n > crossed
+		crossed = max(crossed, n)
+		mu.Unlock()
+
+		if fire && send {
+			th := thresholds[n-1]
+			notify(Event{
+				Type: BudgetThreshold,
+				Message: fmt.Sprintf("miser: %.0f%% of the %s budget used %s (%s spent)",
+					th*100, format.Cost(st.Limit), st.Period.Label(), format.Cost(st.Spent)),
+				Data: BudgetData{
+					Threshold: th,
+					Limit:     st.Limit,
+					Spent:     st.Spent,
+					Period:    string(st.Period),
+					Since:     st.Since,
+					Until:     st.Until,
+					Action:    string(st.Action),
+				},
+			})
+		}
+	}
+	// Thresholds already behind us at startup (e.g. a day budget with
+	// earlier spend) were alerted by the previous run.
+	check(false)
+	return t.Subscribe(func(tracker.Request) { check(true) })
+}
+
+// ErrorRateConfig sets when an ErrorRate event fires: at least MinRequests
+// requests in the last Window and Rate or more of them failed.
+type ErrorRateConfig struct {
+	Rate        float64
+	Window      time.Duration
+	MinRequests int
+}
+
+// ErrorRateData is the payload of an ErrorRate event.
+type ErrorRateData struct {
+	Requests   int      `json:"requests"`
+	Errors     int      `json:"errors"`
+	Rate       float64  `json:"rate"`
+	WindowSecs int      `json:"window_seconds"`
+	LastError  string   `json:"last_error,omitempty"`
+	Statuses   []int    `json:"statuses,omitempty"` // distinct failing status codes
+	Models     []string `json:"models,omitempty"`   // distinct models that failed
+}
+
+// WatchErrors sends an ErrorRate event when the failure rate over the
+// sliding window reaches cfg.Rate. It then stays quiet until the rate has
+// dropped below the threshold again, so one outage is one alert.
+func WatchErrors(t *tracker.Tracker, cfg ErrorRateConfig, notify func(Event)) (unsubscribe func()) {
+	var mu sync.Mutex
+	var recent []tracker.Request
+	alerting := false
+	return t.Subscribe(func(r tracker.Request) {
+		mu.Lock()
+		cutoff := time.Now().Add(-cfg.Window)
+		recent = append(recent, r)
+		i := 0
+		for i < len(recent) && recent[i].Timestamp.Before(cutoff) {
+			i++
+		}
+		recent = recent[i:]
+
+		var d ErrorRateData
+		d.Requests = len(recent)
+		d.WindowSecs = int(cfg.Window.Seconds())
+		for _, q := range recent {
+			if !q.Failed() {
+				continue
+			}
+			d.Errors++
+			d.LastError = q.Error
+			if q.StatusCode != 0 && !slices.Contains(d.Statuses, q.StatusCode) {
+				d.Statuses = append(d.Statuses, q.StatusCode)
+			}
+			if !slices.Contains(d.Models, q.Model) {
+				d.Models = append(d.Models, q.Model)
+			}
+		}
+		if d.Requests > 0 {
+			d.Rate = float64(d.Errors) / float64(d.Requests)
+		}
+		high := d.Requests >= cfg.MinRequests && d.Rate >= cfg.Rate
+		fire := high && !alerting
+		alerting = high
+		mu.Unlock()
+
+		if fire {
+			notify(Event{
+				Type: ErrorRate,
+				Message: fmt.Sprintf("miser: %d of the last %d requests failed (%.0f%%) in %s",
+					d.Errors, d.Requests, d.Rate*100, cfg.Window),
+				Data: d,
+			})
+		}
+	})
+}
+
+// RequestData is the payload of an ExpensiveRequest event.
+type RequestData struct {
+	Threshold    float64 `json:"threshold"` // the configured amount it exceeded
+	ID           int     `json:"id"`
+	Model        string  `json:"model"`
+	Cost         float64 `json:"cost"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	CacheRead    int     `json:"cache_read"`
+	CacheWrite   int     `json:"cache_write"`
+}
+
+// WatchCost sends an ExpensiveRequest event for every request that costs
+// threshold dollars or more.
+func WatchCost(t *tracker.Tracker, threshold float64, notify func(Event)) (unsubscribe func()) {
+	return t.Subscribe(func(r tracker.Request) {
+		if r.Cost < threshold {
+			return
+		}
+		notify(Event{
+			Type: ExpensiveRequest,
+			Message: fmt.Sprintf("miser: one %s request cost %s (%s in, %s out)",
+				r.Model, format.Cost(r.Cost), format.Tokens(r.InputTokens), format.Tokens(r.OutputTokens)),
+			Data: RequestData{
+				Threshold:    threshold,
+				ID:           r.ID,
+				Model:        r.Model,
+				Cost:         r.Cost,
+				InputTokens:  r.InputTokens,
+				OutputTokens: r.OutputTokens,
+				CacheRead:    r.CacheRead,
+				CacheWrite:   r.CacheWrite,
+			},
+		})
+	})
+}
+
+// RateLimitData is the payload of a RateLimitLow event.
+type RateLimitData struct {
+	Threshold float64   `json:"threshold"` // the configured fraction
+	Limit     string    `json:"limit"`     // "requests", "tokens", "input tokens" or "output tokens"
+	Max       int       `json:"max"`
+	Remaining int       `json:"remaining"`
+	Headroom  float64   `json:"headroom"`
+	Reset     time.Time `json:"reset,omitzero"`
+	Model     string    `json:"model"` // of the request that reported it
+}
+
+// WatchRateLimit sends a RateLimitLow event when a response reports less
+// than threshold of a rate limit left. It stays quiet until every limit is
+// back above the threshold, as after a reset, so one squeeze is one alert.
+func WatchRateLimit(t *tracker.Tracker, threshold float64, notify func(Event)) (unsubscribe func()) {
+	var mu sync.Mutex
+	alerting := false
+	return t.Subscribe(func(r tracker.Request) {
+		if r.RateLimit == nil {
+			return
+		}
+		now := time.Now()
+		name, l := r.RateLimit.Tightest(now)
+		low := l.Headroom(now) < threshold
+		mu.Lock()
+		fire := low && !alerting
+		alerting = low
+		mu.Unlock()
+
+		if fire {
+			d := RateLimitData{
+				Threshold: threshold,
+				Limit:     name,
+				Max:       l.Limit,
+				Remaining: l.Remaining,
+				Headroom:  l.Headroom(now),
+				Reset:     l.Reset,
+				Model:     r.Model,
+			}
+			msg := fmt.Sprintf("miser: %s of %s %s left on the rate limit", format.Int(l.Remaining), format.Int(l.Limit), name)
+			if !l.Reset.IsZero() {
+				msg += fmt.Sprintf(" until it resets in %s", time.Until(l.Reset).Round(time.Second))
+			}
+			notify(Event{Type: RateLimitLow, Message: msg, Data: d})
+		}
+	})
+}
+
+// SLOData is the payload of a LatencySLO event.
+type SLOData struct {
+	Model      string  `json:"model"`
+	Pattern    string  `json:"pattern"`    // the [slo] glob it matched
+	Latency    float64 `json:"latency"`    // the objective, in seconds
+	Percentile float64 `json:"percentile"` // of requests that must meet it
+	WindowSecs int     `json:"window_seconds"`
+	Requests   int     `json:"requests"`
+	Within     int     `json:"within"`
+	Compliance float64 `json:"compliance"` // fraction of requests within the latency
+	Observed   float64 `json:"observed"`   // seconds, at the objective's percentile
+}
+
+// WatchSLO sends a LatencySLO event when a model breaches an objective
+// with Alert set. It stays quiet for that model until it is back within
+// the objective.
+func WatchSLO(t *tracker.Tracker, objs []slo.Objective, notify func(Event)) (unsubscribe func()) {
+	var longest time.Duration
+	for _, o := range objs {
+		longest = max(longest, o.Window)
+	}
+	var mu sync.Mutex
+	var recent []tracker.Request
+	alerting := make(map[string]bool)
+	return t.Subscribe(func(r tracker.Request) {
+		o, ok := slo.Match(objs, r.Model)
+		if !ok || !o.Alert || !slo.Counted(r) {
+			return
+		}
+		now := time.Now()
+		mu.Lock()
+		cutoff := now.Add(-longest)
+		recent = append(recent, r)
+		i := 0
+		for i < len(recent) && recent[i].Timestamp.Before(cutoff) {
+			i++
+		}
+		recent = recent[i:]
+		s := slo.Measure(o, r.Model, recent, now)
+		breached := s.Breached()
+		fire := breached && !alerting[r.Model]
+		alerting[r.Model] = breached
+		mu.Unlock()
+
+		if fire {
+			notify(Event{
+				Type: LatencySLO,
+				Message: fmt.Sprintf("miser: %s missed its latency objective of %s: %.0f%% of %d requests within %s (p%s %s)",
+					r.Model, o, s.Compliance()*100, s.Requests, o.Latency, trimPercent(o.Percentile), s.Observed.Round(time.Millisecond)),
+				Data: SLOData{
+					Model:      r.Model,
+					Pattern:    o.Pattern,
+					Latency:    o.Latency.Seconds(),
+					Percentile: o.Percentile,
+					WindowSecs: int(o.Window.Seconds()),
+					Requests:   s.Requests,
+					Within:     s.Within,
+					Compliance: s.Compliance(),
+					Observed:   s.Observed.Seconds(),
+				},
+			})
+		}
+	})
+}
+
+// trimPercent shows 95 as "95" and 99.9 as "99.9".
+func trimPercent(p float64) string {
+	return strconv.FormatFloat(p, 'f', -1, 64)
+}
+
+// SummaryData is the payload of SessionSummary, DailySummary and
+// ScheduledSummary events.
+type SummaryData struct {
+	Since        time.Time     `json:"since"`
+	Requests     int           `json:"requests"`
+	Errors       int           `json:"errors"`
+	InputTokens  int           `json:"input_tokens"`
+	OutputTokens int           `json:"output_tokens"`
+	CacheRead    int           `json:"cache_read"`
+	CacheWrite   int           `json:"cache_write"`
+	Cost         float64       `json:"cost"`
+	Models       []ModelCost   `json:"models,omitempty"`       // most expensive first, at most topModels unless a Digest asks for all
+	TopRequests  []RequestCost `json:"top_requests,omitempty"` // most expensive first
+}
+
+// ModelCost is one model's share of a summary.
+type ModelCost struct {
+	Model        string  `json:"model"`
+	Requests     int     `json:"requests"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	Cost         float64 `json:"cost"`
+}
+
+// RequestCost is one of the most expensive requests in a summary.
+type RequestCost struct {
+	ID           int       `json:"id"`
+	Time         time.Time `json:"time"`
+	Model        string    `json:"model"`
+	Cost         float64   `json:"cost"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+}
+
+const topModels = 5
+
+// summarize totals t since the given time, listing the most expensive
+// models (all of them if allModels) and up to top of the most expensive
+// requests.
+func summarize(t *tracker.Tracker, since time.Time, allModels bool, top int) SummaryData {
+	s := t.GetSummarySince(since)
+	d := SummaryData{
+		Since:        since,
+		Requests:     s.TotalRequests,
+		Errors:       s.TotalErrors,
+		InputTokens:  s.TotalInput,
+		OutputTokens: s.TotalOutput,
+		CacheRead:    s.TotalCacheR,
+		CacheWrite:   s.TotalCacheW,
+		Cost:         s.TotalCost,
+	}
+	for _, m := range t.GetModelStatsSince(since) {
+		if len(d.Models) == topModels && !allModels {
+			break
+		}
+		d.Models = append(d.Models, ModelCost{
+			Model:        m.Model,
+			Requests:     m.Requests,
+			InputTokens:  m.InputTokens,
+			OutputTokens: m.OutputTokens,
+			Cost:         m.TotalCost,
+		})
+	}
+	if top > 0 {
+		reqs := t.GetRequestsSince(since)
+		slices.SortStableFunc(reqs, func(a, b tracker.Request) int { return cmp.Compare(b.Cost, a.Cost) })
+		for _, r := range reqs[:min(top, len(reqs))] {
+			if r.Cost <= 0 {
+				break
+			}
+			d.TopRequests = append(d.TopRequests, RequestCost{
+				ID:           r.ID,
+				Time:         r.Timestamp,
+				Model:        r.Model,
+				Cost:         r.Cost,
+				InputTokens:  r.InputTokens,
+				OutputTokens: r.OutputTokens,
+			})
+		}
+	}
+	return d
+}
+
+// Summary builds the SessionSummary event for t's current session.
+func Summary(t *tracker.Tracker) Event {
+	d := summarize(t, t.SessionStart(), false, 0)
+	return Event{
+		Type: SessionSummary,
+		Message: fmt.Sprintf("miser: session ended after %s: %s over %d requests (%s)",
+			time.Since(d.Since).Round(time.Second), format.Cost(d.Cost), d.Requests, plural(d.Errors, "error")),
+		Data: d,
+	}
+}
+
+// Digest is a summary sent on a schedule.
+type Digest struct {
+	When schedule.Schedule
+	Type string // DailySummary or ScheduledSummary
+	Top  int    // how many of the most expensive requests to list
+	To   string // the target to send to; "" for every target that wants Type
+
+	AllModels bool // list every model, not just the most expensive few
+}
+
+// Run sends the digest at every time g.When yields, covering the time
+// since the run before it, until ctx ends.
+func (g Digest) Run(ctx context.Context, t *tracker.Tracker, notify func(Event)) {
+	for {
+		next := g.When.Next(time.Now())
+		if next.IsZero() {
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			notify(g.event(t, g.When.Prev(next), next))
+		}
+	}
+}
+
+func (g Digest) event(t *tracker.Tracker, since, now time.Time) Event {
+	if since.IsZero() {
+		since = now.AddDate(0, 0, -1)
+	}
+	d := summarize(t, since, g.AllModels, g.Top)
+	return Event{
+		Type: g.Type,
+		Time: now,
+		Message: fmt.Sprintf("miser: %s over %d requests in the last %s (%s)",
+			format.Cost(d.Cost), d.Requests, span(now.Sub(since)), plural(d.Errors, "error")),
+		Data: d,
+		To:   g.To,
+	}
+}
+
+// span phrases a digest's window: "7 days", "24 hours", "15 minutes".
+func span(d time.Duration) string {
+	const day = 24 * time.Hour
+	d = d.Round(time.Minute)
+	switch {
+	case d >= 2*day && d%day == 0:
+		return fmt.Sprintf("%d days", d/day)
+	case d >= 2*time.Hour && d%time.Hour == 0:
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	case d == time.Hour:
+		return "hour"
+	}
+	return plural(int(d/time.Minute), "minute")
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,32 @@
+// Package peer names the local process at the other end of a TCP
+// connection, so requests from tools on the same machine can be told apart
+// without their cooperation.
+package peer
+
+import (
+	"errors"
+	"net"
+)
+
+// ErrNotLocal is returned for connections from another machine, whose
+// processes cannot be seen.
+var ErrNotLocal = errors.New("peer: connection is not from this machine")
+
+// ErrUnsupported is returned where there is no way to look processes up.
+var ErrUnsupported = errors.New("peer: process lookup is not supported on this system")
+
+// Name returns the name of the process that owns the client end of a
+// connection accepted on local from remote, such as "claude" or "cursor".
+// It returns "" without an error when no visible process owns it, e.g. one
+// belonging to another user.
+func Name(local, remote net.Addr) (string, error) {
+	l, ok1 := local.(*net.TCPAddr)
+	r, ok2 := remote.(*net.TCPAddr)
+	if !ok1 || !ok2 {
+		return "", errors.New("peer: not a TCP connection")
+	}
+	if !r.IP.IsLoopback() {
+		return "", ErrNotLocal
+	}
+	return lookup(l, r)
+}
@@ -0,0 +1,39 @@
+package peer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lookup asks lsof for the process whose connection runs from remote to
+// local; the proxy's own end of it is listed the other way round.
+func lookup(local, remote *net.TCPAddr) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "lsof", "-nP", "-iTCP:"+strconv.Itoa(remote.Port), "-sTCP:ESTABLISHED", "-Fcn").Output()
+	var exit *exec.ExitError
+	if err != nil && !errors.As(err, &exit) {
+		return "", err
+	}
+	// lsof exits 1 when nothing matches; parse whatever it printed.
+	want := "n" + remote.String() + "->" + local.String()
+	var command string
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "c"):
+			command = line[1:]
+		case line == want:
+			return command, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,85 @@
+package peer
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// lookup finds the client socket in /proc/net/tcp{,6} by its ports, then
+// the process holding a descriptor for its inode.
+func lookup(local, remote *net.TCPAddr) (string, error) {
+	inode, uid, err := socketInode(remote.Port, local.Port)
+	if err != nil || inode == "" {
+		return "", err
+	}
+	target := "socket:[" + inode + "]"
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return "", err
+	}
+	for _, p := range procs {
+		if _, err := strconv.Atoi(p.Name()); err != nil {
+			continue
+		}
+		if info, err := p.Info(); err != nil || !ownedBy(info, uid) {
+			continue
+		}
+		dir := filepath.Join("/proc", p.Name())
+		fds, err := os.ReadDir(filepath.Join(dir, "fd"))
+		if err != nil {
+			continue // exited, or not ours to look at
+		}
+		for _, fd := range fds {
+			if link, _ := os.Readlink(filepath.Join(dir, "fd", fd.Name())); link == target {
+				comm, err := os.ReadFile(filepath.Join(dir, "comm"))
+				return strings.TrimSpace(string(comm)), err
+			}
+		}
+	}
+	return "", nil
+}
+
+// socketInode returns the inode and owner of the socket whose local port is
+// port and whose remote port is peerPort.
+func socketInode(port, peerPort int) (inode string, uid uint32, err error) {
+	for _, name := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		f, err := os.Open(name)
+		if err != nil {
+			continue
+		}
+		sc := bufio.NewScanner(f)
+		sc.Scan() // header
+		for sc.Scan() {
+			// sl local_address rem_address st tx:rx tr:when retrnsmt uid timeout inode
+			fields := strings.Fields(sc.Text())
+			if len(fields) < 10 || hexPort(fields[1]) != port || hexPort(fields[2]) != peerPort || fields[9] == "0" {
+				continue
+			}
+			u, _ := strconv.ParseUint(fields[7], 10, 32)
+			f.Close()
+			return fields[9], uint32(u), nil
+		}
+		f.Close()
+	}
+	return "", 0, nil
+}
+
+// hexPort parses the port of an address like "0100007F:1F90".
+func hexPort(addr string) int {
+	_, port, _ := strings.Cut(addr, ":")
+	n, err := strconv.ParseUint(port, 16, 16)
+	if err != nil {
+		return -1
+	}
+	return int(n)
+}
+
+func ownedBy(info os.FileInfo, uid uint32) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return !ok || st.Uid == uid
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package peer
+
+import "net"
+
+func lookup(local, remote *net.TCPAddr) (string, error) {
+	return "", ErrUnsupported
+}
@@ -0,0 +1,50 @@
+package peer
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestName(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("lookup via /proc")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	got, err := Name(server.LocalAddr(), server.RemoteAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// comm is the executable name cut to 15 bytes.
+	want := filepath.Base(os.Args[0])
+	if got == "" || !strings.HasPrefix(want, got) {
+		t.Errorf("Name = %q, want a prefix of %q", got, want)
+	}
+}
+
+func TestNameNotLocal(t *testing.T) {
+	local := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 8080}
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 50000}
+	if _, err := Name(local, remote); !errors.Is(err, ErrNotLocal) {
+		t.Errorf("err = %v, want ErrNotLocal", err)
+	}
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// alias resolves a client-facing model name to the upstream model it
+// stands for. ok is false when model is not an alias.
+func (s *Server) alias(model string) (_ string, ok bool) {
+	m, ok := s.Aliases[model]
+	return m, ok && m != ""
+}
+
+// resolveAlias replaces an aliased model in x's Messages body, and in x,
+// with its upstream model.
+func (s *Server) resolveAlias(x *exchange) {
+	to, ok := s.alias(x.model)
+	if !ok || !x.body.ok() {
+		return
+	}
+	x.body.set("model", to)
+	s.debugf("aliases: model %q is %q", x.model, to)
+	x.model = to
+}
+
+// handleModels answers GET /v1/models with upstream's list plus the
+// aliases, which come first on the first page. Clients that list models
+// before offering them, as IDEs do, can then pick an alias. An error from
+// upstream, such as a 401 for a missing key, is passed on unchanged; only
+// when upstream can't be reached are the aliases listed alone.
+func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
+	var data []any
+	if firstPage(r) {
+		data = s.aliasModels()
+	}
+	list := map[string]any{"object": "list", "has_more": false}
+
+	target := s.target()
+	upstreamURL := target + r.URL.Path
+	if r.URL.RawQuery != "" {
+		upstreamURL += "?" + r.URL.RawQuery
+	}
+	upReq, err := http.NewRequestWithContext(r.Context(), http.MethodGet, upstreamURL, nil)
+	if err != nil {
+		http.Error(w, "failed to create upstream request", http.StatusInternalServerError)
+		return
+	}
+	copyHeaders(upReq.Header, r.Header)
+	// The list is rewritten, so it must arrive decoded.
+	upReq.Header.Del("Accept-Encoding")
+	if s.upstreamKey(r.Context()) != "" {
+		s.setAPIKey(r.Context(), upReq.Header)
+	} else if auth := upReq.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") && upReq.Header.Get("x-api-key") == "" {
+		upReq.Header.Del("Authorization")
+		upReq.Header.Set("x-api-key", strings.TrimPrefix(auth, "Bearer "))
+	}
+	if upReq.Header.Get("anthropic-version") == "" {
+		upReq.Header.Set("anthropic-version", "2023-06-01")
+	}
+	s.debugf("upstream: GET %s", upstreamURL)
+
+	resp, err := s.client.Do(upReq)
+	if err != nil {
+		s.debugf("upstream: %v", err)
+		s.unreachable(r.Context(), target, err)
+	} else {
+		defer resp.Body.Close()
+		var upstream map[string]any
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || json.Unmarshal(body, &upstream) != nil {
+			s.debugf("models: upstream answered %s; passing it on", resp.Status)
+			copyHeaders(w.Header(), resp.Header)
+			w.Header().Del("Content-Length")
+			w.WriteHeader(resp.StatusCode)
+			w.Write(body)
+			return
+		}
+		maps.Copy(list, upstream)
+		if more, ok := upstream["data"].([]any); ok {
+			data = append(data, more...)
+		}
+	}
+	list["data"] = data
+	if len(data) > 0 {
+		list["first_id"] = data[0].(map[string]any)["id"]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// firstPage reports whether a models request asks for the start of the
+// list, with neither API's paging cursor.
+func firstPage(r *http.Request) bool {
+	q := r.URL.Query()
+	return !q.Has("after_id") && !q.Has("before_id") && !q.Has("after")
+}
+
+// aliasModels lists the aliases as model entries, in both Anthropic's
+// shape and OpenAI's.
+func (s *Server) aliasModels() []any {
+	var data []any
+	for _, name := range slices.Sorted(maps.Keys(s.Aliases)) {
+		to, ok := s.alias(name)
+		if !ok {
+			continue
+		}
+		data = append(data, map[string]any{
+			"type":         "model",
+			"object":       "model",
+			"id":           name,
+			"display_name": fmt.Sprintf("%s (%s)", name, to),
+			"created_at":   "1970-01-01T00:00:00Z",
+			"created":      0,
+			"owned_by":     "miser",
+		})
+	}
+	return data
+}
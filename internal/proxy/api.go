@@ -0,0 +1,566 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"miser/internal/api"
+	"miser/internal/audit"
+	"miser/internal/export"
+	"miser/internal/tracker"
+)
+
+// eventsHeartbeat keeps idle event streams alive through proxies and lets
+// the server notice clients that went away.
+const eventsHeartbeat = 15 * time.Second
+
+// eventsSessionCheck is how often an idle event stream looks for a session
+// change (e.g. from the clear endpoint) to forward.
+const eventsSessionCheck = time.Second
+
+// eventsBuffer is how many requests may queue for a slow event client
+// before its stream is closed; it reconnects and resumes from its last ID.
+const eventsBuffer = 256
+
+func (s *Server) registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET "+api.StatusPath, s.authorized(s.handleAPIStatus))
+	mux.HandleFunc("GET "+api.RequestsPath, s.authorized(s.handleAPIRequests))
+	mux.HandleFunc("GET "+api.EventsPath, s.authorized(s.handleAPIEvents))
+	mux.HandleFunc("GET "+api.SummaryPath, s.authorized(s.handleAPISummary))
+	mux.HandleFunc("GET "+api.ModelsPath, s.authorized(s.handleAPIModels))
+
+	mux.HandleFunc("POST "+api.ClearPath, s.authorized(s.handleAdminClear))
+	mux.HandleFunc("POST "+api.SessionPath, s.authorized(s.handleAdminSession))
+	mux.HandleFunc("POST "+api.BudgetPath, s.authorized(s.handleAdminBudget))
+	mux.HandleFunc("POST "+api.PricingReloadPath, s.authorized(s.handleAdminPricingReload))
+	mux.HandleFunc("GET "+api.ExportPath, s.authorized(s.handleAdminExport))
+	mux.HandleFunc("POST "+api.IngestPath, s.tokenRequired(s.authorized(s.handleClusterIngest)))
+	if s.Pprof {
+		s.registerPprof(mux)
+	}
+
+	// Anything else under the prefix is a typo or a newer client, not an
+	// upstream path: answer here rather than forwarding it.
+	mux.HandleFunc(api.Prefix, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unknown miser API endpoint: "+r.Method+" "+r.URL.Path, http.StatusNotFound)
+	})
+}
+
+// authorized admits loopback callers, and remote callers presenting the
+// configured API token. The proxy listens on all interfaces and tracked
+// requests can include captured prompts, so without a token the API is
+// local only. A loopback caller may still be a web page in a local
+// browser, so it must also name this machine in its Host, which a page
+// reached through DNS rebinding can't, and not be a cross-site browser
+// request. Requests that change state must be JSON, which a page can't
+// send to another origin without a preflight.
+func (s *Server) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.hasToken(r) {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+				msg := "miser API is only available from localhost"
+				if s.APIToken != "" {
+					msg = "miser API requires a valid bearer token"
+				}
+				http.Error(w, msg, http.StatusForbidden)
+				return
+			}
+			if msg := crossSite(r); msg != "" {
+				http.Error(w, msg, http.StatusForbidden)
+				return
+			}
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !isJSON(r) {
+			http.Error(w, "miser API: "+r.Method+" requests must be Content-Type: application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// tokenRequired admits only callers presenting the API token, local or
+// not, for endpoints no local process should reach without it.
+func (s *Server) tokenRequired(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.hasToken(r) {
+			msg := "miser API requires a valid bearer token here"
+			if s.APIToken == "" {
+				msg = "miser API: set [api] token to use " + r.URL.Path
+			}
+			http.Error(w, msg, http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// crossSite says why a loopback request may have been made by a web page
+// rather than a local program, or returns "".
+func crossSite(r *http.Request) string {
+	if !localHost(r.Host) {
+		return fmt.Sprintf("miser API: Host %q is not localhost", r.Host)
+	}
+	if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+		return "miser API: cross-site request refused"
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || !localHost(u.Host) {
+			return "miser API: cross-site request from " + origin + " refused"
+		}
+	}
+	return ""
+}
+
+// localHost reports whether host, as in a Host header, names the loopback
+// interface: localhost, or a loopback address such as 127.0.0.1 or [::1].
+func localHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// isJSON reports whether r's body is declared as JSON.
+func isJSON(r *http.Request) bool {
+	t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && t == "application/json"
+}
+
+func (s *Server) targetName() string {
+	if s.Upstreams != nil {
+		return s.Upstreams.Status()
+	}
+	if s.TargetName != "" {
+		return s.TargetName
+	}
+	return s.Target
+}
+
+func (s *Server) handleAPIStatus(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, api.Status{
+		Version:      s.Version,
+		Target:       s.targetName(),
+		Started:      s.started,
+		SessionStart: s.Tracker.SessionStart(),
+		Requests:     s.Tracker.Count(),
+		Aggregating:  s.Aggregate,
+	})
+}
+
+func afterParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("after")
+	if v == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		http.Error(w, "invalid after: "+v, http.StatusBadRequest)
+		return 0, false
+	}
+	return n, true
+}
+
+// sinceParam parses the "since" query parameter; see api.SummaryPath.
+// def is used when it is absent.
+func (s *Server) sinceParam(w http.ResponseWriter, r *http.Request, def time.Time) (time.Time, bool) {
+	v := r.URL.Query().Get("since")
+	switch v {
+	case "":
+		return def, true
+	case "session":
+		return s.Tracker.SessionStart(), true
+	case "all":
+		return time.Time{}, true
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, true
+	}
+	if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+		return time.Now().Add(-d), true
+	}
+	http.Error(w, "invalid since: "+v+` (want an RFC 3339 time, a duration like "1h", "session" or "all")`, http.StatusBadRequest)
+	return time.Time{}, false
+}
+
+func (s *Server) handleAPIRequests(w http.ResponseWriter, r *http.Request) {
+	after, ok := afterParam(w, r)
+	if !ok {
+		return
+	}
+	since, ok := s.sinceParam(w, r, time.Time{})
+	if !ok {
+		return
+	}
+	reqs := s.Tracker.GetRequestsAfter(after)
+	if !since.IsZero() {
+		kept := reqs[:0]
+		for _, req := range reqs {
+			if !req.Timestamp.Before(since) {
+				kept = append(kept, req)
+			}
+		}
+		reqs = kept
+	}
+	writeJSON(w, api.Requests{
+		Target:       s.targetName(),
+		SessionStart: s.Tracker.SessionStart(),
+		Requests:     reqs,
+	})
+}
+
+func (s *Server) handleAPISummary(w http.ResponseWriter, r *http.Request) {
+	since, ok := s.sinceParam(w, r, s.Tracker.SessionStart())
+	if !ok {
+		return
+	}
+	sum := s.Tracker.GetSummarySince(since)
+	out := api.Summary{
+		Since:          since,
+		Requests:       sum.TotalRequests,
+		Errors:         sum.TotalErrors,
+		Cancelled:      sum.TotalCancelled,
+		Partial:        sum.TotalPartial,
+		Retries:        sum.TotalRetries,
+		InputTokens:    sum.TotalInput,
+		OutputTokens:   sum.TotalOutput,
+		CacheRead:      sum.TotalCacheR,
+		CacheWrite:     sum.TotalCacheW,
+		Cost:           sum.TotalCost,
+		RetryCost:      sum.RetryCost,
+		OriginalSize:   sum.OriginalSize,
+		CompressedSize: sum.CompressedSize,
+	}
+	if s.Budget != nil {
+		if st := s.Budget.Status(); st.Limit > 0 {
+			out.Budget = &api.Budget{Limit: st.Limit, Spent: st.Spent, Since: st.Since, Until: st.Until}
+		}
+	}
+	writeJSON(w, out)
+}
+
+func (s *Server) handleAPIModels(w http.ResponseWriter, r *http.Request) {
+	since, ok := s.sinceParam(w, r, s.Tracker.SessionStart())
+	if !ok {
+		return
+	}
+	out := api.Models{Since: since, Models: []api.ModelStats{}}
+	for _, m := range s.Tracker.GetModelStatsSince(since) {
+		out.Models = append(out.Models, api.ModelStats{
+			Model:          m.Model,
+			Requests:       m.Requests,
+			Errors:         m.Errors,
+			InputTokens:    m.InputTokens,
+			OutputTokens:   m.OutputTokens,
+			ToolTokens:     m.ToolTokens,
+			CacheRead:      m.CacheRead,
+			CacheWrite:     m.CacheWrite,
+			Cost:           m.TotalCost,
+			CacheSavings:   m.CacheSavings,
+			CacheHitRate:   m.CacheHitRate(),
+			AvgLatencyMs:   m.AvgLatency.Milliseconds(),
+			P95LatencyMs:   m.P95Latency.Milliseconds(),
+			MaxLatencyMs:   m.MaxLatency.Milliseconds(),
+			OriginalSize:   m.OriginalSize,
+			CompressedSize: m.CompressedSize,
+		})
+	}
+	writeJSON(w, out)
+}
+
+// handleAPIEvents streams requests as server-sent events, or as WebSocket
+// text messages when the client asks for an upgrade: everything after the
+// "after" ID first, then each request as it is recorded. A "session" event
+// carrying the session start precedes them.
+func (s *Server) handleAPIEvents(w http.ResponseWriter, r *http.Request) {
+	after, ok := afterParam(w, r)
+	if !ok {
+		return
+	}
+	if isWebSocket(r) {
+		conn, err := acceptWebSocket(w, r)
+		if err != nil {
+			s.debugf("events websocket: %v", err)
+			return
+		}
+		defer conn.Close()
+		s.streamEvents(wsSink{conn}, after)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	s.streamEvents(sseSink{w, flusher, r.Context().Done()}, after)
+}
+
+// eventSink is one events client, over SSE or WebSocket.
+type eventSink interface {
+	send(event string, v any) error
+	flush()
+	ping() error
+	done() <-chan struct{}
+}
+
+type sseSink struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	gone    <-chan struct{}
+}
+
+func (s sseSink) send(event string, v any) error { return writeEvent(s.w, event, v) }
+func (s sseSink) flush()                         { s.flusher.Flush() }
+func (s sseSink) done() <-chan struct{}          { return s.gone }
+
+func (s sseSink) ping() error {
+	_, err := fmt.Fprint(s.w, ": ping\n\n")
+	return err
+}
+
+// wsSink sends each event as a {"event": ..., "data": ...} message.
+type wsSink struct{ conn *wsConn }
+
+func (s wsSink) send(event string, v any) error {
+	msg, err := json.Marshal(struct {
+		Event string `json:"event"`
+		Data  any    `json:"data"`
+	}{event, v})
+	if err != nil {
+		return err
+	}
+	return s.conn.WriteText(msg)
+}
+
+func (s wsSink) flush()                {}
+func (s wsSink) ping() error           { return s.conn.Ping() }
+func (s wsSink) done() <-chan struct{} { return s.conn.Done() }
+
+func (s *Server) streamEvents(sink eventSink, after int) {
+	// Subscribe before reading the backlog so nothing recorded in between
+	// is missed; duplicates are skipped by ID.
+	ch := make(chan tracker.Request, eventsBuffer)
+	overflow := make(chan struct{})
+	unsubscribe := s.Tracker.Subscribe(func(req tracker.Request) {
+		select {
+		case ch <- req:
+		default:
+			select {
+			case <-overflow:
+			default:
+				close(overflow)
+			}
+		}
+	})
+	defer unsubscribe()
+	backlog := s.Tracker.GetRequestsAfter(after)
+
+	lastSession := s.Tracker.SessionStart()
+	if sink.send(api.EventSession, api.Session{SessionStart: lastSession}) != nil {
+		return
+	}
+	syncSession := func() error {
+		ss := s.Tracker.SessionStart()
+		if ss.Equal(lastSession) {
+			return nil
+		}
+		lastSession = ss
+		return sink.send(api.EventSession, api.Session{SessionStart: ss})
+	}
+	last := after
+	send := func(req tracker.Request) error {
+		if req.ID <= last {
+			return nil
+		}
+		last = req.ID
+		if err := syncSession(); err != nil {
+			return err
+		}
+		return sink.send(api.EventRequest, req)
+	}
+	for _, req := range backlog {
+		if send(req) != nil {
+			return
+		}
+	}
+	sink.flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	sessionCheck := time.NewTicker(eventsSessionCheck)
+	defer sessionCheck.Stop()
+	for {
+		select {
+		case <-sink.done():
+			return
+		case <-overflow:
+			return
+		case req := <-ch:
+			if send(req) != nil {
+				return
+			}
+		case <-sessionCheck.C:
+			if syncSession() != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if sink.ping() != nil {
+				return
+			}
+		}
+		sink.flush()
+	}
+}
+
+// hasToken reports whether r carries the configured API token.
+func (s *Server) hasToken(r *http.Request) bool {
+	if s.APIToken == "" {
+		return false
+	}
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return found && subtle.ConstantTimeCompare([]byte(token), []byte(s.APIToken)) == 1
+}
+
+// audit records an admin action taken through the API, naming the caller
+// by address, whether it used the token, and its User-Agent.
+func (s *Server) audit(r *http.Request, action, detail string, err error) {
+	source := "api " + r.RemoteAddr
+	if host, _, e := net.SplitHostPort(r.RemoteAddr); e == nil {
+		source = "api " + host
+	}
+	if s.hasToken(r) {
+		source += " (token)"
+	}
+	if ua := r.UserAgent(); ua != "" {
+		source += " " + ua
+	}
+	e := audit.Entry{Action: action, Source: source, Detail: detail}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if err := s.Audit.Record(e); err != nil {
+		s.logger.Printf("[ADMIN] writing audit log: %v", err)
+	}
+}
+
+func (s *Server) handleAdminClear(w http.ResponseWriter, r *http.Request) {
+	s.Tracker.Clear()
+	s.logger.Printf("[ADMIN] session cleared")
+	s.audit(r, audit.Clear, "", nil)
+	writeJSON(w, api.Session{SessionStart: s.Tracker.SessionStart()})
+}
+
+func (s *Server) handleAdminSession(w http.ResponseWriter, r *http.Request) {
+	var body api.Session
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid session: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.SessionStart.IsZero() || body.SessionStart.After(time.Now()) {
+		http.Error(w, "session start must be in the past", http.StatusBadRequest)
+		return
+	}
+	s.Tracker.SetSessionStart(body.SessionStart)
+	s.logger.Printf("[ADMIN] session start set to %s", body.SessionStart.Format(time.RFC3339))
+	s.audit(r, audit.Session, "session start set to "+body.SessionStart.Format(time.RFC3339), nil)
+	writeJSON(w, body)
+}
+
+func (s *Server) handleAdminBudget(w http.ResponseWriter, r *http.Request) {
+	if s.Budget == nil {
+		http.Error(w, "this instance does not track a budget", http.StatusNotImplemented)
+		return
+	}
+	var body api.BudgetUpdate
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid budget: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Amount < 0 {
+		http.Error(w, "budget amount must not be negative", http.StatusBadRequest)
+		return
+	}
+	old := s.Budget.Status().Limit
+	s.Budget.SetLimit(body.Amount)
+	if body.Reset {
+		s.Budget.Reset()
+	}
+	st := s.Budget.Status()
+	s.logger.Printf("[ADMIN] budget set to $%.2f (reset %v)", st.Limit, body.Reset)
+	detail := fmt.Sprintf("limit $%.2f → $%.2f", old, st.Limit)
+	if body.Reset {
+		detail += ", spend reset"
+	}
+	s.audit(r, audit.Budget, detail, nil)
+	writeJSON(w, api.Budget{Limit: st.Limit, Spent: st.Spent, Since: st.Since, Until: st.Until})
+}
+
+func (s *Server) handleAdminPricingReload(w http.ResponseWriter, r *http.Request) {
+	if s.ReloadPricing == nil {
+		http.Error(w, "pricing reload is not available", http.StatusNotImplemented)
+		return
+	}
+	if err := s.ReloadPricing(); err != nil {
+		s.audit(r, audit.PricingReload, "", err)
+		http.Error(w, "reloading pricing: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.logger.Printf("[ADMIN] pricing reloaded")
+	s.audit(r, audit.PricingReload, "", nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAdminExport(w http.ResponseWriter, r *http.Request) {
+	f, err := export.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	reqs, rows := s.Tracker.GetRequests(), "all"
+	if r.URL.Query().Get("all") != "1" {
+		reqs, rows = s.Tracker.GetRequestsSince(s.Tracker.SessionStart()), "session"
+	}
+	// Encode first so a failure can still be reported as an error status.
+	var buf bytes.Buffer
+	err = export.Write(&buf, f, reqs)
+	s.audit(r, audit.Export, fmt.Sprintf("%s, %d requests (%s)", f, len(reqs), rows), err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", f.ContentType())
+	w.Write(buf.Bytes())
+}
+
+func writeEvent(w http.ResponseWriter, event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
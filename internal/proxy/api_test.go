@@ -0,0 +1,254 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"miser/internal/api"
+	"miser/internal/audit"
+	"miser/internal/budget"
+	"miser/internal/compress"
+	"miser/internal/tracker"
+)
+
+func TestStatsAPI(t *testing.T) {
+	tr := tracker.New()
+	now := time.Now()
+	tr.Load([]tracker.Request{{Timestamp: now.Add(-2 * time.Hour), Model: "old", Cost: 5}})
+	tr.SetSessionStart(now.Add(-time.Hour))
+	tr.Record(tracker.Request{Timestamp: now.Add(-time.Minute), Model: "a", Cost: 1, InputTokens: 10})
+	tr.Record(tracker.Request{Timestamp: now, Model: "b", Cost: 2, StatusCode: 500})
+
+	s := NewServer(0, "http://upstream.invalid", time.Second, tr, compress.Config{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("%s %s was forwarded upstream", r.Method, r.URL.Path)
+	})
+	s.registerAPI(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	c := api.NewClient(srv.URL)
+	ctx := context.Background()
+
+	sum, err := c.Summary(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Requests != 2 || sum.Errors != 1 || sum.Cost != 3 || sum.InputTokens != 10 {
+		t.Errorf("session summary = %+v", sum)
+	}
+	if sum, _ = c.Summary(ctx, "all"); sum.Requests != 3 || !sum.Since.IsZero() {
+		t.Errorf("all summary = %+v", sum)
+	}
+
+	models, err := c.Models(ctx, "30s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models.Models) != 1 || models.Models[0].Model != "b" || models.Models[0].Errors != 1 {
+		t.Errorf("models = %+v", models)
+	}
+
+	resp, err := http.Get(srv.URL + api.RequestsPath + "?since=" + now.Add(-90*time.Minute).Format(time.RFC3339))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("requests?since = %d", resp.StatusCode)
+	}
+
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodGet, api.SummaryPath + "?since=yesterday"},
+		{http.MethodGet, api.Prefix + "nope"},
+		{http.MethodPost, api.SummaryPath},
+	} {
+		req, _ := http.NewRequest(tc.method, srv.URL+tc.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest && resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s %s = %d", tc.method, tc.path, resp.StatusCode)
+		}
+	}
+}
+
+func TestPprof(t *testing.T) {
+	get := func(pprof bool, path, token string) int {
+		s := NewServer(0, "", time.Second, tracker.New(), compress.Config{})
+		s.Pprof = pprof
+		s.APIToken = "s3cret"
+		mux := http.NewServeMux()
+		s.registerAPI(mux)
+		srv := httptest.NewServer(mux)
+		defer srv.Close()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := get(false, api.PprofPath+"heap", "s3cret"); code != http.StatusNotFound {
+		t.Errorf("disabled: heap = %d", code)
+	}
+	// Loopback alone isn't enough.
+	for _, path := range []string{api.PprofPath, api.PprofPath + "heap", api.PprofPath + "cmdline"} {
+		if code := get(true, path, ""); code != http.StatusForbidden {
+			t.Errorf("%s without token = %d, want 403", path, code)
+		}
+	}
+	for path, want := range map[string]int{
+		api.PprofPath:             http.StatusOK,
+		api.PprofPath + "heap":    http.StatusOK,
+		api.PprofPath + "cmdline": http.StatusOK,
+		api.PprofPath + "nope":    http.StatusNotFound,
+	} {
+		if code := get(true, path, "s3cret"); code != want {
+			t.Errorf("%s = %d, want %d", path, code, want)
+		}
+	}
+}
+
+func TestAdminAudit(t *testing.T) {
+	log, err := audit.Open(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tracker.New()
+	s := NewServer(0, "", time.Second, tr, compress.Config{})
+	s.Audit = log
+	s.Budget = budget.New(budget.Config{Limit: 5}, tr)
+	s.ReloadPricing = func() error { return errors.New("bad toml") }
+	mux := http.NewServeMux()
+	s.registerAPI(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	c := api.NewClient(srv.URL)
+	c.UserAgent = "test-agent"
+	ctx := context.Background()
+
+	c.Clear(ctx)
+	c.SetBudget(ctx, api.BudgetUpdate{Amount: 10, Reset: true})
+	c.ReloadPricing(ctx)
+	c.Export(ctx, io.Discard, "csv", false)
+
+	got, err := audit.Load(log.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, e := range got {
+		actions = append(actions, e.Action)
+		if e.Source != "api 127.0.0.1 test-agent" {
+			t.Errorf("%s source = %q", e.Action, e.Source)
+		}
+	}
+	if want := []string{audit.Clear, audit.Budget, audit.PricingReload, audit.Export}; !slices.Equal(actions, want) {
+		t.Fatalf("actions = %v, want %v", actions, want)
+	}
+	if got[1].Detail != "limit $5.00 → $10.00, spend reset" || got[2].Error != "bad toml" {
+		t.Errorf("entries = %+v", got)
+	}
+}
+
+func TestClusterIngest(t *testing.T) {
+	tr := tracker.New()
+	s := NewServer(0, "", time.Second, tr, compress.Config{})
+	mux := http.NewServeMux()
+	s.registerAPI(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	c := api.NewClient(srv.URL)
+	ctx := context.Background()
+
+	batch := api.Ingest{Node: "alice", Run: "r1", Requests: []tracker.Request{
+		{ID: 1, Model: "m", Cost: 1},
+		{ID: 2, Model: "m", Cost: 2, Node: "laptop"},
+	}}
+	s.APIToken = "s3cret"
+	c.Token = s.APIToken
+	if _, err := c.Push(ctx, batch); err == nil {
+		t.Error("an instance that doesn't aggregate accepted a push")
+	}
+	s.Aggregate = true
+	c.Token = ""
+	if _, err := c.Push(ctx, batch); err == nil {
+		t.Error("a local push without the token was accepted")
+	}
+	c.Token = s.APIToken
+	for i, want := range []int{2, 0} { // the resend is skipped
+		res, err := c.Push(ctx, batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Recorded != want || res.Last != 2 {
+			t.Errorf("push %d: %+v, want %d recorded", i+1, res, want)
+		}
+	}
+	// A restarted node numbers its requests afresh.
+	if res, _ := c.Push(ctx, api.Ingest{Node: "alice", Run: "r2", Requests: []tracker.Request{{ID: 1, Cost: 4}}}); res.Recorded != 1 {
+		t.Errorf("new run: %+v", res)
+	}
+
+	got := tr.GetNodeStatsSince(time.Time{})
+	if len(got) != 2 || got[0].Node != "alice" || got[0].TotalCost != 5 || got[1].Node != "alice/laptop" {
+		t.Errorf("node stats = %+v", got)
+	}
+}
+
+func TestAuthorized(t *testing.T) {
+	s := NewServer(0, "", time.Second, tracker.New(), compress.Config{})
+	s.APIToken = "s3cret"
+	h := s.authorized(func(w http.ResponseWriter, r *http.Request) {})
+	send := func(method, remote, host string, header map[string]string) int {
+		r := httptest.NewRequest(method, "http://"+host+api.ClearPath, nil)
+		r.RemoteAddr = remote
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w.Code
+	}
+	local, remote := "127.0.0.1:5555", "192.0.2.1:5555"
+	asJSON := map[string]string{"Content-Type": "application/json"}
+	for _, tc := range []struct {
+		name, method, remote, host string
+		header                     map[string]string
+		want                       int
+	}{
+		{"local", http.MethodGet, local, "localhost:8080", nil, http.StatusOK},
+		{"local IPv6", http.MethodGet, "[::1]:5555", "[::1]:8080", nil, http.StatusOK},
+		{"local address", http.MethodGet, local, "127.0.0.1:8080", nil, http.StatusOK},
+		{"remote", http.MethodGet, remote, "localhost:8080", nil, http.StatusForbidden},
+		{"remote with token", http.MethodGet, remote, "miser.example.com", map[string]string{"Authorization": "Bearer s3cret"}, http.StatusOK},
+		{"rebound host", http.MethodGet, local, "evil.example:8080", nil, http.StatusForbidden},
+		{"cross-site origin", http.MethodGet, local, "localhost:8080", map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"localhost origin", http.MethodGet, local, "localhost:8080", map[string]string{"Origin": "http://localhost:3000"}, http.StatusOK},
+		{"cross-site fetch", http.MethodGet, local, "localhost:8080", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"same-origin fetch", http.MethodGet, local, "localhost:8080", map[string]string{"Sec-Fetch-Site": "same-origin"}, http.StatusOK},
+		{"post as JSON", http.MethodPost, local, "localhost:8080", asJSON, http.StatusOK},
+		{"post as text", http.MethodPost, local, "localhost:8080", map[string]string{"Content-Type": "text/plain"}, http.StatusUnsupportedMediaType},
+		{"post untyped", http.MethodPost, local, "localhost:8080", nil, http.StatusUnsupportedMediaType},
+		{"post with token as text", http.MethodPost, remote, "miser.example.com",
+			map[string]string{"Authorization": "Bearer s3cret", "Content-Type": "text/plain"}, http.StatusUnsupportedMediaType},
+	} {
+		if got := send(tc.method, tc.remote, tc.host, tc.header); got != tc.want {
+			t.Errorf("%s: %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
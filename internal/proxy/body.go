@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// maxHeldBody is how much of a non-streaming response is kept for parsing
+// and capture; past it only bodyTail bytes are, which is where Anthropic
+// puts usage.
+const (
+	maxHeldBody = 1 << 20
+	bodyTail    = 64 << 10
+)
+
+// bodyTap keeps what a relayed response body is parsed from: all of it up
+// to maxHeldBody, else its last bodyTail bytes. It is the write side of a
+// TeeReader.
+type bodyTap struct {
+	head []byte
+	tail []byte // once head overflowed
+	over bool
+	n    int64
+}
+
+func (b *bodyTap) Write(p []byte) (int, error) {
+	b.n += int64(len(p))
+	if !b.over && len(b.head)+len(p) <= maxHeldBody {
+		b.head = append(b.head, p...)
+		return len(p), nil
+	}
+	if !b.over {
+		b.over = true
+		p = append(b.head, p...)
+		b.head = nil
+	}
+	if len(p) >= bodyTail {
+		b.tail = append(b.tail[:0], p[len(p)-bodyTail:]...)
+		return len(p), nil
+	}
+	if keep := bodyTail - len(p); len(b.tail) > keep {
+		b.tail = append(b.tail[:0], b.tail[len(b.tail)-keep:]...)
+	}
+	b.tail = append(b.tail, p...)
+	return len(p), nil
+}
+
+// whole returns the body if it was all kept.
+func (b *bodyTap) whole() ([]byte, bool) {
+	return b.head, !b.over
+}
+
+// usage is the token usage of a Messages response.
+type usage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+// usage decodes the response's usage: from the whole body if it was kept,
+// else from the last "usage" key in its tail.
+func (b *bodyTap) usage() (usage, error) {
+	if body, ok := b.whole(); ok {
+		var msg struct {
+			Usage usage `json:"usage"`
+		}
+		err := json.Unmarshal(body, &msg)
+		return msg.Usage, err
+	}
+	var u usage
+	i := bytes.LastIndex(b.tail, []byte(`"usage"`))
+	if i < 0 {
+		return u, errNoUsage
+	}
+	rest := bytes.TrimLeft(b.tail[i+len(`"usage"`):], " \t\r\n")
+	rest, ok := bytes.CutPrefix(rest, []byte(":"))
+	if !ok {
+		return u, errNoUsage
+	}
+	// Decode just the object, ignoring whatever closes the body after it.
+	err := json.NewDecoder(bytes.NewReader(rest)).Decode(&u)
+	return u, err
+}
+
+var errNoUsage = errors.New("no usage near the end of the response")
@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestBodyTap(t *testing.T) {
+	small := `{"content":[{"type":"text","text":"hi"}],"usage":{"input_tokens":5,"output_tokens":1}}`
+	big := `{"content":[{"type":"text","text":"` + strings.Repeat("y", 3*maxHeldBody) +
+		`"}],"usage":{"input_tokens":50,"output_tokens":9,"cache_read_input_tokens":2}}`
+
+	for _, tc := range []struct {
+		name  string
+		body  string
+		whole bool
+		want  usage
+	}{
+		{"small", small, true, usage{InputTokens: 5, OutputTokens: 1}},
+		{"big", big, false, usage{InputTokens: 50, OutputTokens: 9, CacheReadInputTokens: 2}},
+	} {
+		var tap bodyTap
+		// Odd-sized reads exercise the tail's trimming.
+		n, err := io.Copy(io.Discard, io.TeeReader(iotest.HalfReader(strings.NewReader(tc.body)), &tap))
+		if err != nil || n != int64(len(tc.body)) {
+			t.Fatalf("%s: copied %d, %v", tc.name, n, err)
+		}
+		if _, whole := tap.whole(); whole != tc.whole {
+			t.Errorf("%s: whole = %v", tc.name, whole)
+		}
+		if len(tap.head)+len(tap.tail) > maxHeldBody {
+			t.Errorf("%s: held %d bytes", tc.name, len(tap.head)+len(tap.tail))
+		}
+		if got, err := tap.usage(); err != nil || got != tc.want {
+			t.Errorf("%s: usage = %+v, %v; want %+v", tc.name, got, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Chained deployments — a miser on a laptop whose target is a team's
+// miser gateway — pass these between hops. ViaHeader lists the misers a
+// request came through, so the gateway can attribute it and a hop can
+// tell a request that looped back to it. TrackedHeader on a response
+// names the miser that recorded it, so the hops before it can mark their
+// record as counted there.
+const (
+	ViaHeader     = "X-Miser-Via"
+	TrackedHeader = "X-Miser-Tracked"
+)
+
+// instance names this proxy to the others in a chain: its host and port,
+// for people to read, and a random suffix, so that containers sharing a
+// hostname and port aren't mistaken for one another as a loop.
+func (s *Server) instance() string {
+	s.instanceOnce.Do(func() {
+		host, err := os.Hostname()
+		if err != nil {
+			host = "miser"
+		}
+		b := make([]byte, 4)
+		rand.Read(b)
+		s.instanceName = fmt.Sprintf("%s:%d-%s", host, s.Port, hex.EncodeToString(b))
+	})
+	return s.instanceName
+}
+
+// via returns the misers a request came through, nearest last.
+func via(h http.Header) []string {
+	var hops []string
+	for _, v := range h.Values(ViaHeader) {
+		for hop := range strings.SplitSeq(v, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
+
+// looped refuses a request that already came through this proxy, which
+// means the chain's targets lead back to it.
+func (s *Server) looped(w http.ResponseWriter, r *http.Request, openAI bool) bool {
+	hops := via(r.Header)
+	if !slices.Contains(hops, s.instance()) {
+		return false
+	}
+	msg := fmt.Sprintf("miser: request looped back to %s through %s; check [proxy] target on each", s.instance(), strings.Join(hops, " → "))
+	s.logger.Printf("[PROXY] %s", msg)
+	writeError(w, http.StatusLoopDetected, openAI, "invalid_request_error", msg)
+	return true
+}
+
+// chainHeaders passes the request's attribution and the hops so far on to
+// an upstream miser. Without Chain they stay here, like every X-Miser-*
+// header.
+func (s *Server) chainHeaders(x *exchange, in, out http.Header) {
+	if !s.Chain {
+		return
+	}
+	if x.project != "" {
+		out.Set(ProjectHeader, x.project)
+	}
+	if x.client != "" {
+		out.Set(ClientHeader, x.client)
+	}
+	if x.conversation != "" {
+		out.Set(ConversationHeader, x.conversation)
+	}
+	out.Set(ViaHeader, strings.Join(append(via(in), s.instance()), ", "))
+}
+
+// counted notes the upstream miser that recorded a request too. When the
+// target turns out to be a miser without Chain set, that is logged once:
+// the requests reach it unattributed.
+func (s *Server) counted(x *exchange, resp *http.Response) {
+	x.counted = resp.Header.Get(TrackedHeader)
+	if x.counted != "" && !s.Chain && s.chainHinted.CompareAndSwap(false, true) {
+		s.logger.Printf("[PROXY] the target is another miser (%s); set [proxy] chain = true to pass projects and clients on to it", x.counted)
+	}
+}
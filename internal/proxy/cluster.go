@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sync"
+
+	"miser/internal/api"
+)
+
+// maxIngestBody bounds one pushed batch; a node sends far less.
+const maxIngestBody = 32 << 20
+
+// nodeRuns remembers, per cluster node, the run it last pushed from and
+// the highest request ID received from it, so resent batches are skipped.
+type nodeRuns struct {
+	mu   sync.Mutex
+	runs map[string]nodeRun
+}
+
+type nodeRun struct {
+	id   string
+	last int
+}
+
+var validNode = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@:/-]*$`)
+
+// handleClusterIngest records a node's requests as its own, tagged with
+// the node. A node that aggregates others passes theirs on as
+// "node/theirs". Pushes need the API token even from this host: what a
+// node sends is counted in the aggregator's totals and budget.
+func (s *Server) handleClusterIngest(w http.ResponseWriter, r *http.Request) {
+	if !s.Aggregate {
+		http.Error(w, "this miser does not aggregate a cluster; set [cluster] aggregate = true on it", http.StatusNotFound)
+		return
+	}
+	var in api.Ingest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxIngestBody)).Decode(&in); err != nil {
+		http.Error(w, "invalid ingest body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validNode.MatchString(in.Node) || in.Run == "" {
+		http.Error(w, "ingest needs a node name (letters, digits, '.', '_', '@', ':', '/', '-') and a run", http.StatusBadRequest)
+		return
+	}
+
+	s.nodes.mu.Lock()
+	defer s.nodes.mu.Unlock()
+	if s.nodes.runs == nil {
+		s.nodes.runs = make(map[string]nodeRun)
+	}
+	run := s.nodes.runs[in.Node]
+	if run.id != in.Run {
+		if run.id != "" {
+			s.debugf("cluster: node %s restarted", in.Node)
+		}
+		run = nodeRun{id: in.Run}
+	}
+	recorded := 0
+	for _, req := range in.Requests {
+		if req.ID <= run.last {
+			continue
+		}
+		run.last = req.ID
+		if req.Node != "" {
+			req.Node = in.Node + "/" + req.Node
+		} else {
+			req.Node = in.Node
+		}
+		s.Tracker.Record(req)
+		recorded++
+	}
+	s.nodes.runs[in.Node] = run
+	writeJSON(w, api.IngestResult{Recorded: recorded, Last: run.last})
+}
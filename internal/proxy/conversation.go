@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// maxConversation bounds a conversation ID taken from the client.
+const maxConversation = 128
+
+// conversation names the conversation an Anthropic request belongs to and
+// counts its messages. The name is, in order of preference: the client's
+// ConversationHeader; the session in the metadata.user_id Claude Code
+// sends ("…_session_<id>"); or a hash of the system prompt and first
+// message, which every later turn repeats. Cache breakpoints are left out
+// of the hash since clients move them from turn to turn. id is "" for a
+// body with no messages.
+func conversation(h http.Header, body []byte) (id string, messages int) {
+	var req struct {
+		System   json.RawMessage   `json:"system"`
+		Messages []json.RawMessage `json:"messages"`
+		Metadata struct {
+			UserID string `json:"user_id"`
+		} `json:"metadata"`
+	}
+	if json.Unmarshal(body, &req) != nil || len(req.Messages) == 0 {
+		return "", 0
+	}
+	if id := strings.TrimSpace(h.Get(ConversationHeader)); id != "" {
+		return id[:min(len(id), maxConversation)], len(req.Messages)
+	}
+	if _, session, ok := strings.Cut(req.Metadata.UserID, "_session_"); ok && session != "" {
+		return session[:min(len(session), maxConversation)], len(req.Messages)
+	}
+	sum := sha256.New()
+	for _, raw := range []json.RawMessage{req.System, req.Messages[0]} {
+		var v any
+		json.Unmarshal(raw, &v)
+		b, _ := json.Marshal(uncached(v))
+		sum.Write(b)
+		sum.Write([]byte{0})
+	}
+	return hex.EncodeToString(sum.Sum(nil)[:6]), len(req.Messages)
+}
+
+// uncached drops cache_control from decoded JSON.
+func uncached(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		delete(v, "cache_control")
+		for k, e := range v {
+			v[k] = uncached(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = uncached(e)
+		}
+	}
+	return v
+}
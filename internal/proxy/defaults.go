@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"cmp"
+	"path"
+	"slices"
+)
+
+// Defaults are sampling parameters sent for a model when the client does
+// not set them, so they can be tuned in one place for every tool.
+type Defaults struct {
+	Temperature   *float64
+	TopP          *float64
+	StopSequences []string
+	MaxTokens     int // 0 leaves it to the client
+}
+
+// defaults merges the Defaults whose model glob matches model; where
+// several set a parameter, the longest, most specific pattern wins.
+func (s *Server) defaults(model string) (d Defaults, ok bool) {
+	var patterns []string
+	for p := range s.Defaults {
+		if m, _ := path.Match(p, model); m {
+			patterns = append(patterns, p)
+		}
+	}
+	slices.SortFunc(patterns, func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(b), len(a)), cmp.Compare(a, b))
+	})
+	for _, p := range patterns {
+		e := s.Defaults[p]
+		if d.Temperature == nil {
+			d.Temperature = e.Temperature
+		}
+		if d.TopP == nil {
+			d.TopP = e.TopP
+		}
+		if d.StopSequences == nil {
+			d.StopSequences = e.StopSequences
+		}
+		if d.MaxTokens == 0 {
+			d.MaxTokens = e.MaxTokens
+		}
+	}
+	return d, len(patterns) > 0
+}
+
+// applyDefaults sets the model's Defaults that x's Messages body leaves
+// out.
+func (s *Server) applyDefaults(x *exchange) {
+	d, ok := s.defaults(x.model)
+	if !ok || !x.body.ok() {
+		return
+	}
+	var set []string
+	add := func(field string, v any) {
+		if !x.body.has(field) {
+			x.body.set(field, v)
+			set = append(set, field)
+		}
+	}
+	if d.Temperature != nil {
+		add("temperature", *d.Temperature)
+	}
+	if d.TopP != nil {
+		add("top_p", *d.TopP)
+	}
+	if d.StopSequences != nil {
+		add("stop_sequences", d.StopSequences)
+	}
+	if d.MaxTokens > 0 {
+		add("max_tokens", d.MaxTokens)
+		x.ownMaxTokens = slices.Contains(set, "max_tokens")
+	}
+	if len(set) > 0 {
+		s.debugf("defaults: %v set for %s", set, x.model)
+	}
+}
+
+// applyOAIDefaults sets the model's Defaults that an OpenAI-style request
+// leaves out, before it is converted.
+func (s *Server) applyOAIDefaults(req *oaiRequest) {
+	d, ok := s.defaults(req.Model)
+	if !ok {
+		return
+	}
+	var set []string
+	if req.Temperature == nil && d.Temperature != nil {
+		req.Temperature = d.Temperature
+		set = append(set, "temperature")
+	}
+	if req.TopP == nil && d.TopP != nil {
+		req.TopP = d.TopP
+		set = append(set, "top_p")
+	}
+	if req.Stop == nil && d.StopSequences != nil {
+		req.Stop = d.StopSequences
+		set = append(set, "stop")
+	}
+	if req.MaxTokens == nil && d.MaxTokens > 0 {
+		req.MaxTokens = &d.MaxTokens
+		set = append(set, "max_tokens")
+	}
+	if len(set) > 0 {
+		s.debugf("defaults: %v set for %s", set, req.Model)
+	}
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Resolve overrides how the upstream host is found: for locked-down
+// networks where it must be reached at a known address, or to compare
+// regions' latency. Other hosts, such as an HTTPS proxy, are dialled as
+// usual. TLS still verifies the certificate for Host.
+type Resolve struct {
+	Host   string   // the upstream host name
+	Addrs  []string // connect to these IPs, first that answers, instead of looking Host up
+	Server string   // look Host up with this DNS server, host:port; "" uses the system's
+}
+
+// ParseResolve checks a pinning for host: IP addresses and a DNS server,
+// whose port defaults to 53.
+func ParseResolve(host string, addrs []string, server string) (Resolve, error) {
+	r := Resolve{Host: host}
+	for _, a := range addrs {
+		if net.ParseIP(a) == nil {
+			return Resolve{}, fmt.Errorf("resolve %q: not an IP address", a)
+		}
+		r.Addrs = append(r.Addrs, a)
+	}
+	if server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+		}
+		r.Server = server
+	}
+	return r, nil
+}
+
+// Enabled reports whether anything is overridden.
+func (r Resolve) Enabled() bool {
+	return len(r.Addrs) > 0 || r.Server != ""
+}
+
+// String describes the override, e.g. "pinned to 160.79.104.10".
+func (r Resolve) String() string {
+	if len(r.Addrs) > 0 {
+		return "pinned to " + strings.Join(r.Addrs, ", ")
+	}
+	return "resolved by " + r.Server
+}
+
+func (r Resolve) dialer() *net.Dialer {
+	d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second} // as http.DefaultTransport
+	if r.Server != "" {
+		d.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var nd net.Dialer
+				return nd.DialContext(ctx, network, r.Server)
+			},
+		}
+	}
+	return d
+}
+
+// Lookup returns the addresses Host is reached at.
+func (r Resolve) Lookup(ctx context.Context) ([]string, error) {
+	if len(r.Addrs) > 0 {
+		return r.Addrs, nil
+	}
+	res := r.dialer().Resolver
+	if res == nil {
+		res = net.DefaultResolver
+	}
+	return res.LookupHost(ctx, r.Host)
+}
+
+// DialContext connects to addr, sending Host to its pinned addresses.
+func (r Resolve) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := r.dialer()
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || !strings.EqualFold(host, r.Host) {
+		return (&net.Dialer{Timeout: d.Timeout, KeepAlive: d.KeepAlive}).DialContext(ctx, network, addr)
+	}
+	if len(r.Addrs) == 0 {
+		return d.DialContext(ctx, network, addr)
+	}
+	var errs []error
+	for _, a := range r.Addrs {
+		conn, err := d.DialContext(ctx, network, net.JoinHostPort(a, port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Transport returns a copy of http.DefaultTransport that dials through r.
+func (r Resolve) Transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = r.DialContext
+	return t
+}
@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestResolvePinsUpstreamHost(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+
+	// ::1 refuses: the test server listens on IPv4 only.
+	r, err := ParseResolve("api.miser.invalid", []string{"::1", u.Hostname()}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: r.Transport()}
+	resp, err := client.Get("http://api.miser.invalid:" + u.Port() + "/")
+	if err != nil {
+		t.Fatalf("pinned request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("status %d", resp.StatusCode)
+	}
+
+	if _, err := ParseResolve("h", []string{"not-an-ip"}, ""); err == nil {
+		t.Error("accepted a non-IP pin")
+	}
+	if r, _ := ParseResolve("h", nil, "10.0.0.53"); r.Server != "10.0.0.53:53" {
+		t.Errorf("server = %q, want port 53 added", r.Server)
+	}
+	if r, _ := ParseResolve("h", nil, "[::1]:5353"); r.Server != "[::1]:5353" {
+		t.Errorf("server = %q", r.Server)
+	}
+}
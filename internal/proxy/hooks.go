@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"miser/internal/tokens"
+	"miser/internal/tracker"
+)
+
+// runHooks runs the request hooks over x's body, leaving it as they did.
+// ok is false when a hook vetoed the request, which has been answered with
+// 403 and its message.
+func (s *Server) runHooks(w http.ResponseWriter, x *exchange, openAI bool) (ok bool) {
+	if s.Hooks.Len() == 0 {
+		return true
+	}
+	body := x.body.bytes()
+	x.hookEnv = s.hookEnv(x, body)
+	res := s.Hooks.Request(body, x.hookEnv)
+	for _, err := range res.Errs {
+		s.logger.Printf("[HOOK] %v", err)
+	}
+	x.tags = res.Tags
+	if res.Veto != "" {
+		s.logger.Printf("[HOOK] %s vetoed a request for %s: %s", res.Vetoer, x.model, res.Veto)
+		writeError(w, http.StatusForbidden, openAI, "request_vetoed", "miser: "+res.Veto)
+		return false
+	}
+	if res.Changed {
+		x.body = parseBody(res.Body)
+		var m struct {
+			Model string `json:"model"`
+		}
+		json.Unmarshal(res.Body, &m)
+		if m.Model != x.model {
+			s.debugf("hooks: model %q is now %q", x.model, m.Model)
+			x.model = m.Model
+		}
+		s.debugf("hooks: request body rewritten, %d bytes to %d", len(body), len(res.Body))
+	}
+	return true
+}
+
+// hookEnv holds the names hook conditions see besides the request.
+func (s *Server) hookEnv(x *exchange, body []byte) map[string]any {
+	b := map[string]any{"spent": 0.0, "limit": 0.0, "fraction": 0.0}
+	if s.Budget != nil {
+		st := s.Budget.Status()
+		b["spent"], b["limit"], b["fraction"] = st.Spent, st.Limit, st.Fraction()
+	}
+	estimate, _ := tokens.Request(body)
+	return map[string]any{
+		"project":      x.project,
+		"client":       x.client,
+		"key":          x.key,
+		"conversation": x.conversation,
+		"budget":       b,
+		"estimate":     estimate,
+	}
+}
+
+// responseHooks adds the tags of the response hooks that hold for rec.
+func (s *Server) responseHooks(x *exchange, rec *tracker.Request) {
+	if x.hookEnv == nil || !s.Hooks.HasResponse() {
+		return
+	}
+	tags, errs := s.Hooks.Response(x.hookEnv, map[string]any{
+		"status":        rec.StatusCode,
+		"model":         rec.Model,
+		"input_tokens":  rec.InputTokens,
+		"output_tokens": rec.OutputTokens,
+		"cache_read":    rec.CacheRead,
+		"cache_write":   rec.CacheWrite,
+		"cost":          rec.Cost,
+		"latency_ms":    rec.Latency.Milliseconds(),
+		"context_used":  rec.ContextUsed,
+		"error":         rec.Error,
+	})
+	for _, err := range errs {
+		s.logger.Printf("[HOOK] %v", err)
+	}
+	for _, t := range tags {
+		if !slices.Contains(rec.Tags, t) {
+			rec.Tags = append(rec.Tags, t)
+		}
+	}
+}
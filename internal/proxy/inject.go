@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+// Routes an Injection can select.
+const (
+	RouteMessages = "messages" // the native /v1/messages
+	RouteChat     = "chat"     // the OpenAI-style /v1/chat/completions
+)
+
+// Injection is a system prompt fragment added to selected model requests,
+// such as "be concise" or an organisation's policy text.
+type Injection struct {
+	Name     string
+	Text     string
+	Prepend  bool     // put it before the system prompt instead of after
+	Routes   []string // RouteMessages, RouteChat; empty means both
+	Models   []string // globs; empty means all
+	Projects []string // empty means all
+}
+
+func (in *Injection) selects(route string, x *exchange) bool {
+	return (len(in.Routes) == 0 || slices.Contains(in.Routes, route)) &&
+		matchModel(in.Models, x.model) &&
+		(len(in.Projects) == 0 || slices.Contains(in.Projects, x.project))
+}
+
+// inject adds the selected injections to the system prompt of x's
+// Messages body, in order, and notes their names in x.
+func (s *Server) inject(x *exchange, route string) {
+	if len(s.Inject) == 0 || !x.body.ok() {
+		return
+	}
+	// A system prompt that was text, or absent, stays text; blocks stay
+	// blocks, keeping their cache_control.
+	var blocks []map[string]any
+	asText := true
+	if raw := x.body.get("system"); raw != nil {
+		var text string
+		if json.Unmarshal(raw, &text) == nil {
+			if text != "" {
+				blocks = append(blocks, map[string]any{"type": "text", "text": text})
+			}
+		} else if json.Unmarshal(raw, &blocks) == nil {
+			asText = false
+		} else {
+			s.debugf("inject: system prompt is neither text nor blocks; not injecting")
+			return
+		}
+	}
+	var names []string
+	for i := range s.Inject {
+		in := &s.Inject[i]
+		if !in.selects(route, x) {
+			continue
+		}
+		b := map[string]any{"type": "text", "text": in.Text}
+		if in.Prepend {
+			blocks = slices.Insert(blocks, 0, b)
+		} else {
+			blocks = append(blocks, b)
+		}
+		names = append(names, in.Name)
+	}
+	if len(names) == 0 {
+		return
+	}
+	var system any = blocks
+	if asText {
+		texts := make([]string, len(blocks))
+		for i, b := range blocks {
+			texts[i] = b["text"].(string)
+		}
+		system = strings.Join(texts, "\n\n")
+	}
+	x.body.set("system", system)
+	s.debugf("inject: %v added to the system prompt", names)
+	x.injected = names
+}
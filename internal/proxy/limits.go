@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"miser/internal/budget"
+	"miser/internal/format"
+	"miser/internal/keys"
+	"miser/internal/tracker"
+)
+
+// keyLimiter enforces each virtual key's budget and request rate. The
+// zero value is ready to use.
+type keyLimiter struct {
+	mu   sync.Mutex
+	keys map[string]*keyUsage
+}
+
+type keyUsage struct {
+	limits keys.Limits
+	budget *budget.Budget // nil without a budget limit
+	recent []time.Time    // starts of the requests in the last minute
+}
+
+// usage returns the state for a key, starting over when its limits have
+// changed since the last request.
+func (l *keyLimiter) usage(name string, limits keys.Limits, t *tracker.Tracker) *keyUsage {
+	if l.keys == nil {
+		l.keys = make(map[string]*keyUsage)
+	}
+	u := l.keys[name]
+	if u != nil && u.limits == limits {
+		return u
+	}
+	if u != nil && u.budget != nil {
+		u.budget.Close()
+	}
+	nu := &keyUsage{limits: limits}
+	if u != nil {
+		nu.recent = u.recent
+	}
+	if limits.Budget > 0 {
+		period, _ := budget.ParsePeriod(cmp.Or(limits.Period, "month")) // checked by keys
+		nu.budget = budget.New(budget.Config{
+			Limit:  limits.Budget,
+			Period: period,
+			Action: budget.Block,
+			Match:  func(r tracker.Request) bool { return r.Key == name },
+		}, t)
+	}
+	l.keys[name] = nu
+	return nu
+}
+
+// take counts a request starting at now against the rate limit, or
+// returns how long until one would be allowed.
+func (u *keyUsage) take(now time.Time) time.Duration {
+	if u.limits.RPM <= 0 {
+		return 0
+	}
+	cutoff := now.Add(-time.Minute)
+	i := 0
+	for i < len(u.recent) && !u.recent[i].After(cutoff) {
+		i++
+	}
+	u.recent = u.recent[i:]
+	if len(u.recent) >= u.limits.RPM {
+		return u.recent[0].Sub(cutoff)
+	}
+	u.recent = append(u.recent, now)
+	return 0
+}
+
+// keyLimited refuses a model request whose virtual key has spent its
+// budget (402) or sent its requests for the minute (429).
+func (s *Server) keyLimited(w http.ResponseWriter, r *http.Request, openAI bool) bool {
+	v, ok := r.Context().Value(keyCtx{}).(virtualKey)
+	if !ok {
+		return false
+	}
+	s.limits.mu.Lock()
+	defer s.limits.mu.Unlock()
+	if v.limits == (keys.Limits{}) && s.limits.keys[v.name] == nil {
+		return false
+	}
+	u := s.limits.usage(v.name, v.limits, s.Tracker)
+
+	if u.budget != nil && u.budget.Blocking() {
+		st := u.budget.Status()
+		msg := fmt.Sprintf("miser: key %q has used its budget of %s %s (%s spent); raise it with \"miser keys limit\" or wait for the next %s",
+			v.name, format.Cost(st.Limit), st.Period.Label(), format.Cost(st.Spent), st.Period)
+		s.logger.Printf("[KEYS] blocked request: %s", msg)
+		writeError(w, http.StatusPaymentRequired, openAI, "budget_exceeded", msg)
+		return true
+	}
+	if wait := u.take(time.Now()); wait > 0 {
+		secs := int(wait.Seconds()) + 1
+		msg := fmt.Sprintf("miser: key %q is limited to %d requests a minute; retry in %ds", v.name, u.limits.RPM, secs)
+		s.logger.Printf("[KEYS] blocked request: %s", msg)
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+		writeError(w, http.StatusTooManyRequests, openAI, "rate_limit_error", msg)
+		return true
+	}
+	return false
+}
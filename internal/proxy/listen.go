@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// ConnLimits bounds the listening server. Zero values mean no limit.
+type ConnLimits struct {
+	ReadHeaderTimeout time.Duration // for a client to send a request's headers
+	IdleTimeout       time.Duration // before an idle keep-alive connection is closed
+	MaxConns          int           // open connections
+	MaxConnsPerIP     int           // open connections from one address
+}
+
+// connLimiter closes connections accepted past ConnLimits straight away,
+// rather than leaving them queued, so one client holding its share open
+// doesn't stall the accept loop for the rest.
+type connLimiter struct {
+	net.Listener
+	s *Server
+
+	mu    sync.Mutex
+	open  int
+	perIP map[string]int
+	// refused counts connections closed since the last log line, which is
+	// written at most once a minute.
+	refused int
+	logged  time.Time
+}
+
+func (s *Server) limitListener(ln net.Listener) net.Listener {
+	if s.Conns.MaxConns <= 0 && s.Conns.MaxConnsPerIP <= 0 {
+		return ln
+	}
+	return &connLimiter{Listener: ln, s: s, perIP: make(map[string]int)}
+}
+
+func (l *connLimiter) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip := remoteIP(c)
+		if l.admit(ip) {
+			return &limitedConn{Conn: c, l: l, ip: ip}, nil
+		}
+		c.Close()
+	}
+}
+
+func (l *connLimiter) admit(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lim := l.s.Conns
+	reason := ""
+	switch {
+	case lim.MaxConns > 0 && l.open >= lim.MaxConns:
+		reason = "max_conns"
+	case lim.MaxConnsPerIP > 0 && l.perIP[ip] >= lim.MaxConnsPerIP:
+		reason = "max_conns_per_ip for " + ip
+	default:
+		l.open++
+		l.perIP[ip]++
+		return true
+	}
+	l.refused++
+	if now := time.Now(); now.Sub(l.logged) >= time.Minute {
+		l.s.logger.Printf("[PROXY] refused %d connection(s): %s reached", l.refused, reason)
+		l.refused, l.logged = 0, now
+	}
+	return false
+}
+
+func (l *connLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.open--
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+// limitedConn gives its slot back when closed.
+type limitedConn struct {
+	net.Conn
+	l    *connLimiter
+	ip   string
+	once sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	c.once.Do(func() { c.l.release(c.ip) })
+	return c.Conn.Close()
+}
+
+func remoteIP(c net.Conn) string {
+	host, _, err := net.SplitHostPort(c.RemoteAddr().String())
+	if err != nil {
+		return c.RemoteAddr().String()
+	}
+	return host
+}
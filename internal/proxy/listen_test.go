@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"miser/internal/compress"
+	"miser/internal/tracker"
+)
+
+func TestConnLimits(t *testing.T) {
+	s := NewServer(0, "", time.Second, tracker.New(), compress.Config{})
+	s.logger.SetOutput(io.Discard)
+	s.Conns.MaxConnsPerIP = 1
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := s.limitListener(raw)
+	defer ln.Close()
+	accepted := make(chan net.Conn)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+	dial := func() net.Conn {
+		c, err := net.Dial("tcp", raw.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	first := dial()
+	defer first.Close()
+	held := <-accepted
+
+	second := dial()
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := second.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("second connection from the same address: read %v, want EOF", err)
+	}
+
+	held.Close()
+	third := dial()
+	defer third.Close()
+	select {
+	case c := <-accepted:
+		c.Close()
+	case <-time.After(5 * time.Second):
+		t.Error("a closed connection's slot was not given back")
+	}
+}
@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"miser/internal/format"
+)
+
+// OutputCap is a ceiling on the max_tokens of model requests, so an agent
+// asking for 64K output tokens can't run up an outsized response. The
+// zero value allows any.
+type OutputCap struct {
+	MaxTokens int
+	Reject    bool // refuse requests over it with 400 instead of lowering it
+}
+
+// minThinkingBudget is the smallest thinking budget_tokens Anthropic takes.
+const minThinkingBudget = 1024
+
+// capOutput applies s.OutputCap to x's Messages body: it lowers
+// max_tokens, and a thinking budget that would no longer fit under it.
+// A cap too low for any thinking budget drops thinking instead. ok is
+// false when the cap rejects and the request, over it, was refused; the
+// refusal is recorded. A max_tokens miser filled in itself is only ever
+// lowered, and not noted as capped.
+func (s *Server) capOutput(w http.ResponseWriter, x *exchange, openAI bool) (ok bool) {
+	limit := s.OutputCap.MaxTokens
+	if limit <= 0 {
+		return true
+	}
+	var asked int
+	if err := json.Unmarshal(x.body.get("max_tokens"), &asked); err != nil || asked <= limit {
+		return true
+	}
+	if s.OutputCap.Reject && !x.ownMaxTokens {
+		msg := fmt.Sprintf("miser: max_tokens %d is over the cap of %d", asked, limit)
+		s.logger.Printf("[CAP] refused a request for %s: %s", x.model, msg)
+		writeError(w, http.StatusBadRequest, openAI, "invalid_request_error", msg)
+		x.cappedFrom = asked
+		rec := x.request()
+		rec.StatusCode = http.StatusBadRequest
+		rec.Error = msg
+		s.record(x, rec)
+		return false
+	}
+	x.body.set("max_tokens", limit)
+	var thinking map[string]any
+	if json.Unmarshal(x.body.get("thinking"), &thinking) == nil {
+		if b, ok := thinking["budget_tokens"].(float64); ok && int(b) >= limit {
+			if limit <= minThinkingBudget {
+				x.body.del("thinking")
+				s.debugf("cap: max_tokens %s leaves no room for a thinking budget; thinking dropped", format.Tokens(limit))
+			} else {
+				thinking["budget_tokens"] = limit - 1
+				x.body.set("thinking", thinking)
+			}
+		}
+	}
+	if x.ownMaxTokens {
+		s.debugf("cap: default max_tokens %s lowered to %s", format.Tokens(asked), format.Tokens(limit))
+		return true
+	}
+	s.debugf("cap: max_tokens %s lowered to %s", format.Tokens(asked), format.Tokens(limit))
+	x.cappedFrom = asked
+	return true
+}
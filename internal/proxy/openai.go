@@ -1,16 +1,20 @@
 package proxy
 
 import (
-	"bufio"
 	"bytes"
+	"cmp"
 	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
+	"miser/internal/capture"
 	"miser/internal/compress"
+	"miser/internal/tokens"
 	"miser/internal/tracker"
 )
 
@@ -24,6 +28,25 @@ type oaiRequest struct {
 	TopP        *float64     `json:"top_p,omitempty"`
 	Stream      bool         `json:"stream"`
 	Stop        any          `json:"stop,omitempty"`
+
+	// Parameters with no Anthropic counterpart: read only to report them
+	// as dropped, see convertRequest.
+	Tools            []any          `json:"tools,omitempty"`
+	Functions        []any          `json:"functions,omitempty"`
+	LogitBias        map[string]any `json:"logit_bias,omitempty"`
+	N                *int           `json:"n,omitempty"`
+	PresencePenalty  *float64       `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64       `json:"frequency_penalty,omitempty"`
+	Seed             *int64         `json:"seed,omitempty"`
+	ResponseFormat   map[string]any `json:"response_format,omitempty"`
+	Logprobs         bool           `json:"logprobs,omitempty"`
+
+	// Anthropic parameters with no OpenAI name, sent by OpenAI SDKs as
+	// extra body fields: top_k and thinking by name, and any others under
+	// "anthropic", copied into the Messages request as they are.
+	TopK      *int                       `json:"top_k,omitempty"`
+	Thinking  json.RawMessage            `json:"thinking,omitempty"`
+	Anthropic map[string]json.RawMessage `json:"anthropic,omitempty"`
 }
 
 type oaiMessage struct {
@@ -40,8 +63,30 @@ type anthropicRequest struct {
 	TopP        *float64     `json:"top_p,omitempty"`
 	Stream      bool         `json:"stream"`
 	StopSeqs    any          `json:"stop_sequences,omitempty"`
+
+	TopK     *int            `json:"top_k,omitempty"`
+	Thinking json.RawMessage `json:"thinking,omitempty"`
+
+	// extra are the "anthropic" fields, set over the encoded request's
+	// own fields of the same name.
+	extra map[string]json.RawMessage
+}
+
+// body encodes the request as a Messages body, with its extra fields in
+// place of any of its own they name.
+func (r anthropicRequest) body() *reqBody {
+	raw, _ := json.Marshal(r)
+	b := parseBody(raw)
+	for _, k := range slices.Sorted(maps.Keys(r.extra)) {
+		b.setRaw(k, r.extra[k])
+	}
+	return b
 }
 
+// reservedParams are the fields of a Messages request miser sets itself,
+// which "anthropic" extra fields can't replace.
+var reservedParams = []string{"model", "messages", "stream"}
+
 type anthropicResponse struct {
 	ID      string `json:"id"`
 	Type    string `json:"type"`
@@ -83,7 +128,7 @@ type oaiUsage struct {
 }
 
 func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
+	x := s.newExchange(r)
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -94,102 +139,164 @@ func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
 
 	var oaiReq oaiRequest
 	if err := json.Unmarshal(body, &oaiReq); err != nil {
+		s.debugf("chat: request body is not valid JSON: %v", err)
 		http.Error(w, `{"error":{"message":"invalid JSON"}}`, http.StatusBadRequest)
 		return
 	}
 
-	var compStats compress.Stats
+	if m, ok := s.alias(oaiReq.Model); ok {
+		s.debugf("aliases: model %q is %q", oaiReq.Model, m)
+		oaiReq.Model = m
+	}
+	x.model = oaiReq.Model
+	x.ownMaxTokens = oaiReq.MaxTokens == nil
+	s.applyOAIDefaults(&oaiReq)
+
 	if s.compressionEnabled() {
-		oaiReq.Messages, compStats = s.compressOAIMessages(oaiReq.Messages)
+		oaiReq.Messages, x.comp = s.compressOAIMessages(oaiReq.Messages)
 	}
 
-	antReq := convertRequest(oaiReq)
-	antBody, _ := json.Marshal(antReq)
+	s.debugf("chat: model=%q stream=%v messages=%d", oaiReq.Model, oaiReq.Stream, len(oaiReq.Messages))
+	antReq, degraded := convertRequest(oaiReq, s.debugf)
+	x.degraded = degraded
+	x.body = antReq.body()
+	x.conversation, x.messages = conversation(r.Header, x.body.bytes())
+	if !s.runHooks(w, x, true) || !s.capOutput(w, x, true) {
+		return
+	}
+	s.inject(x, RouteChat)
+	antBody := x.body.bytes()
+	x.prompt = s.Capture.Prompt(antBody)
+	if !s.gateCost(w, x, antBody, true) {
+		return
+	}
+	s.estimate(x, antBody)
 
-	upURL := s.Target + "/v1/messages"
-	upReq, err := http.NewRequestWithContext(r.Context(), http.MethodPost, upURL, bytes.NewReader(antBody))
+	target := s.target()
+	upURL := target + "/v1/messages"
+	upReq, err := http.NewRequestWithContext(x.net.with(r.Context()), http.MethodPost, upURL, bytes.NewReader(antBody))
 	if err != nil {
-		s.recordError(oaiReq.Model, start, err, compStats)
+		s.recordError(x, err)
 		http.Error(w, `{"error":{"message":"internal error"}}`, http.StatusInternalServerError)
 		return
 	}
 
 	apiKey := r.Header.Get("Authorization")
-	if strings.HasPrefix(apiKey, "Bearer ") {
+	if s.upstreamKey(r.Context()) != "" {
+		s.setAPIKey(r.Context(), upReq.Header)
+	} else if strings.HasPrefix(apiKey, "Bearer ") {
 		upReq.Header.Set("x-api-key", strings.TrimPrefix(apiKey, "Bearer "))
+		s.debugf("headers: Authorization bearer token sent as x-api-key")
+	} else {
+		s.debugf("headers: no Authorization bearer token; upstream will see no API key")
 	}
 	upReq.Header.Set("Content-Type", "application/json")
 	upReq.Header.Set("anthropic-version", "2023-06-01")
+	s.chainHeaders(x, r.Header, upReq.Header)
+	s.debugf("upstream: POST %s (other client headers are not forwarded)", upURL)
+	s.mirror(r.Context(), x, upReq.Header, antBody)
+	defer x.endShadow()
 
-	resp, err := s.client.Do(upReq)
+	resp, err := s.retry(r.Context(), x, upReq, antBody)
 	if err != nil {
-		s.recordError(oaiReq.Model, start, err, compStats)
+		s.debugf("upstream: %v", err)
+		s.unreachable(r.Context(), target, err)
+		s.recordError(x, err)
 		http.Error(w, fmt.Sprintf(`{"error":{"message":"%s"}}`, err.Error()), http.StatusBadGateway)
 		return
 	}
+	s.counted(x, resp)
+	x.requestID, x.rateLimit = requestID(resp.Header), rateLimit(resp.Header)
 	defer resp.Body.Close()
 
+	s.debugf("upstream: %s, Content-Type %q, request-id %q", resp.Status, resp.Header.Get("Content-Type"), x.requestID)
 	if resp.StatusCode >= 400 {
 		respBody, _ := io.ReadAll(resp.Body)
+		s.debugf("chat: upstream error: %.200s", respBody)
+		if ra := resp.Header.Get("Retry-After"); ra != "" {
+			w.Header().Set("Retry-After", ra)
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(resp.StatusCode)
-		w.Write(respBody)
-		s.recordError(oaiReq.Model, start, fmt.Errorf("upstream %d", resp.StatusCode), compStats)
+		json.NewEncoder(w).Encode(convertError(resp.StatusCode, respBody))
+		// Recorded as on /v1/messages: by status, with upstream's request
+		// ID and error.
+		rec := x.request()
+		rec.StatusCode = resp.StatusCode
+		rec.RequestID = x.requestID
+		rec.Error = fmt.Sprintf("upstream %d", resp.StatusCode)
+		var e struct {
+			Error streamError `json:"error"`
+		}
+		if json.Unmarshal(respBody, &e) == nil && e.Error != (streamError{}) {
+			rec.Error = e.Error.String()
+		}
+		rec.Response = s.Capture.Response(respBody)
+		s.record(x, rec)
 		return
 	}
 
 	ct := resp.Header.Get("Content-Type")
 	if oaiReq.Stream && strings.Contains(ct, "text/event-stream") {
-		s.handleOAIStreaming(w, resp, oaiReq.Model, start, compStats)
+		s.handleOAIStreaming(w, resp, x)
 	} else {
-		s.handleOAINonStreaming(w, resp, oaiReq.Model, start, compStats)
+		if oaiReq.Stream {
+			s.debugf("chat: stream requested but upstream sent %q; answering with one response", ct)
+		}
+		s.handleOAINonStreaming(w, resp, x)
 	}
 }
 
-func (s *Server) handleOAINonStreaming(w http.ResponseWriter, resp *http.Response, model string, start time.Time, cs compress.Stats) {
+func (s *Server) handleOAINonStreaming(w http.ResponseWriter, resp *http.Response, x *exchange) {
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		s.recordError(model, start, err)
+		s.recordError(x, err)
 		http.Error(w, `{"error":{"message":"failed to read upstream response"}}`, http.StatusBadGateway)
 		return
 	}
 
 	var antResp anthropicResponse
 	if err := json.Unmarshal(body, &antResp); err != nil {
+		s.debugf("chat: upstream response is not JSON (%v); relaying it unconverted: %.200s", err, body)
+		rec := x.request()
+		rec.StatusCode = resp.StatusCode
+		output, _ := responseTokens(body)
+		s.estimateUsage(x, &rec, false, false, output)
+		s.record(x, rec)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(resp.StatusCode)
 		w.Write(body)
 		return
 	}
 
-	oaiResp := convertResponse(antResp)
-
-	cost := tracker.CalculateCost(model,
-		antResp.Usage.InputTokens, antResp.Usage.OutputTokens,
-		antResp.Usage.CacheReadInputTokens, antResp.Usage.CacheCreationInputTokens)
-	s.Tracker.Record(tracker.Request{
-		Timestamp:      start,
-		Model:          model,
-		InputTokens:    antResp.Usage.InputTokens,
-		OutputTokens:   antResp.Usage.OutputTokens,
-		CacheRead:      antResp.Usage.CacheReadInputTokens,
-		CacheWrite:     antResp.Usage.CacheCreationInputTokens,
-		Cost:           cost,
-		Latency:        time.Since(start),
-		StatusCode:     resp.StatusCode,
-		OriginalSize:   cs.OriginalBytes,
-		CompressedSize: cs.CompressedBytes,
-	})
+	oaiResp := convertResponse(antResp, s.debugf)
+
+	rec := x.request()
+	rec.InputTokens = antResp.Usage.InputTokens
+	rec.OutputTokens = antResp.Usage.OutputTokens
+	rec.CacheRead = antResp.Usage.CacheReadInputTokens
+	rec.CacheWrite = antResp.Usage.CacheCreationInputTokens
+	rec.Cost = tracker.CalculateCost(x.model,
+		rec.InputTokens, rec.OutputTokens, rec.CacheRead, rec.CacheWrite)
+	rec.StatusCode = resp.StatusCode
+	rec.Response = s.Capture.Response(body)
+	output, tool := responseTokens(body)
+	if antResp.Usage.InputTokens+antResp.Usage.OutputTokens+antResp.Usage.CacheReadInputTokens+antResp.Usage.CacheCreationInputTokens == 0 {
+		s.estimateUsage(x, &rec, false, false, output)
+	}
+	toolTokens(&rec, tool, output)
+	s.record(x, rec)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(oaiResp)
 }
 
-func (s *Server) handleOAIStreaming(w http.ResponseWriter, resp *http.Response, model string, start time.Time, cs compress.Stats) {
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		s.handleOAINonStreaming(w, resp, model, start, cs)
+func (s *Server) handleOAIStreaming(w http.ResponseWriter, resp *http.Response, x *exchange) {
+	model := x.model
+	if _, ok := w.(http.Flusher); !ok {
+		s.debugf("chat: response writer cannot flush; answering with one response")
+		s.handleOAINonStreaming(w, resp, x)
 		return
 	}
 
@@ -197,24 +304,27 @@ func (s *Server) handleOAIStreaming(w http.ResponseWriter, resp *http.Response,
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 	w.WriteHeader(http.StatusOK)
+	cw := s.clientWriter(w, true)
+	defer cw.done()
 
 	var (
 		inputTokens, outputTokens, cacheRead, cacheWrite int
 		msgID                                             string
 		sentRole                                          bool
+		streamErr                                         string
 	)
-
-	scanner := bufio.NewScanner(resp.Body)
-	scanner.Buffer(make([]byte, 0, 1024*1024), 1024*1024)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		if !strings.HasPrefix(line, "data: ") {
-			continue
+	var started, finished bool // see estimateUsage
+	output, tool := 0, 0       // see toolTokens
+	captured := capture.NewBuffer(s.Capture)
+
+	events := newSSEReader(resp.Body)
+	var err error
+	for {
+		var data []byte
+		if data, err = events.next(); err != nil {
+			break
 		}
-		data := line[6:]
-		if data == "[DONE]" {
+		if bytes.Equal(data, []byte("[DONE]")) {
 			continue
 		}
 
@@ -231,63 +341,87 @@ func (s *Server) handleOAIStreaming(w http.ResponseWriter, resp *http.Response,
 			} `json:"message"`
 			Index int `json:"index"`
 			Delta struct {
-				Type       string `json:"type"`
-				Text       string `json:"text"`
-				StopReason string `json:"stop_reason"`
+				Type        string `json:"type"`
+				Text        string `json:"text"`
+				PartialJSON string `json:"partial_json"`
+				StopReason  string `json:"stop_reason"`
 			} `json:"delta"`
 			Usage struct {
 				OutputTokens int `json:"output_tokens"`
 			} `json:"usage"`
+			Error streamError `json:"error"`
 		}
-		if json.Unmarshal([]byte(data), &event) != nil {
+		if err := json.Unmarshal(data, &event); err != nil {
+			s.debugf("SSE: skipping unparseable event (%v): %.200s", err, data)
 			continue
 		}
 
 		switch event.Type {
 		case "message_start":
+			started = true
 			msgID = event.Message.ID
 			inputTokens = event.Message.Usage.InputTokens
 			cacheRead = event.Message.Usage.CacheReadInputTokens
 			cacheWrite = event.Message.Usage.CacheCreationInputTokens
 
 			if !sentRole {
-				writeOAIChunk(w, flusher, msgID, model, &oaiMessage{Role: "assistant", Content: ""}, nil)
+				writeOAIChunk(cw, msgID, model, &oaiMessage{Role: "assistant", Content: ""}, nil)
 				sentRole = true
 			}
 
 		case "content_block_delta":
+			tool += tokens.Text(event.Delta.PartialJSON)
+			output += tokens.Text(event.Delta.Text) + tokens.Text(event.Delta.PartialJSON)
 			if event.Delta.Type == "text_delta" && event.Delta.Text != "" {
-				writeOAIChunk(w, flusher, msgID, model, &oaiMessage{Content: event.Delta.Text}, nil)
+				captured.WriteString(event.Delta.Text)
+				writeOAIChunk(cw, msgID, model, &oaiMessage{Content: event.Delta.Text}, nil)
 			}
 
 		case "message_delta":
+			finished = true
 			outputTokens = event.Usage.OutputTokens
-			reason := mapStopReason(event.Delta.StopReason)
-			writeOAIChunk(w, flusher, msgID, model, nil, &reason)
+			reason := mapStopReason(event.Delta.StopReason, s.debugf)
+			writeOAIChunk(cw, msgID, model, nil, &reason)
 
 		case "message_stop":
-			fmt.Fprintf(w, "data: [DONE]\n\n")
-			flusher.Flush()
+			io.WriteString(cw, "data: [DONE]\n\n")
+
+		case "error":
+			// OpenAI clients raise a chunk holding an error; the stream
+			// ends there, without [DONE].
+			streamErr = event.Error.String()
+			chunk, _ := json.Marshal(convertError(http.StatusInternalServerError, data))
+			fmt.Fprintf(cw, "data: %s\n\n", chunk)
+		}
+		if cw.err != nil || streamErr != "" {
+			break
 		}
 	}
 
-	cost := tracker.CalculateCost(model, inputTokens, outputTokens, cacheRead, cacheWrite)
-	s.Tracker.Record(tracker.Request{
-		Timestamp:      start,
-		Model:          model,
-		InputTokens:    inputTokens,
-		OutputTokens:   outputTokens,
-		CacheRead:      cacheRead,
-		CacheWrite:     cacheWrite,
-		Cost:           cost,
-		Latency:        time.Since(start),
-		StatusCode:     resp.StatusCode,
-		OriginalSize:   cs.OriginalBytes,
-		CompressedSize: cs.CompressedBytes,
-	})
+	if err != nil && err != io.EOF {
+		s.debugf("SSE: stream ended early: %v", err)
+	}
+	rec := x.request()
+	rec.InputTokens = inputTokens
+	rec.OutputTokens = outputTokens
+	rec.CacheRead = cacheRead
+	rec.CacheWrite = cacheWrite
+	rec.Cost = tracker.CalculateCost(model, inputTokens, outputTokens, cacheRead, cacheWrite)
+	rec.StatusCode = resp.StatusCode
+	rec.Response = captured.Text()
+	rec.Error = streamErr
+	s.estimateUsage(x, &rec, started, finished, output)
+	toolTokens(&rec, tool, output)
+	rec.Truncated = truncated(rec, finished)
+	if cw.stalled() {
+		s.stalled(x, &rec)
+	} else if (cw.err != nil || (err != nil && err != io.EOF)) && x.gone() {
+		s.cancelled(x, &rec)
+	}
+	s.record(x, rec)
 }
 
-func writeOAIChunk(w http.ResponseWriter, f http.Flusher, id, model string, delta *oaiMessage, finishReason *string) {
+func writeOAIChunk(w io.Writer, id, model string, delta *oaiMessage, finishReason *string) {
 	chunk := oaiResponse{
 		ID:      "chatcmpl-" + id,
 		Object:  "chat.completion.chunk",
@@ -301,7 +435,6 @@ func writeOAIChunk(w http.ResponseWriter, f http.Flusher, id, model string, delt
 	}
 	data, _ := json.Marshal(chunk)
 	fmt.Fprintf(w, "data: %s\n\n", data)
-	f.Flush()
 }
 
 // compressOAIMessages extracts text from OpenAI-format messages, runs
@@ -372,45 +505,185 @@ func (s *Server) compressOAIMessages(msgs []oaiMessage) ([]oaiMessage, compress.
 
 // --- converters ---
 
-func convertRequest(oai oaiRequest) anthropicRequest {
-	ant := anthropicRequest{
+// The converters report lossy or defaulted conversions through note, so
+// --debug shows why a tool got an unexpected answer.
+
+// convertRequest translates a chat completion request. degraded lists,
+// for the request's record, what had to be dropped or approximated on the
+// way, such as "tools dropped (3)".
+func convertRequest(oai oaiRequest, note func(string, ...any)) (ant anthropicRequest, degraded []string) {
+	degrade := func(format string, args ...any) {
+		d := fmt.Sprintf(format, args...)
+		if !slices.Contains(degraded, d) {
+			degraded = append(degraded, d)
+			note("convert: " + d)
+		}
+	}
+	ant = anthropicRequest{
 		Model:       oai.Model,
 		Temperature: oai.Temperature,
 		TopP:        oai.TopP,
 		Stream:      oai.Stream,
 		StopSeqs:    oai.Stop,
+		TopK:        oai.TopK,
+		Thinking:    oai.Thinking,
+	}
+	for _, k := range slices.Sorted(maps.Keys(oai.Anthropic)) {
+		if slices.Contains(reservedParams, k) {
+			degrade("anthropic.%s ignored; miser sets it", k)
+			continue
+		}
+		if ant.extra == nil {
+			ant.extra = make(map[string]json.RawMessage)
+		}
+		ant.extra[k] = oai.Anthropic[k]
 	}
 
 	if oai.MaxTokens != nil {
 		ant.MaxTokens = *oai.MaxTokens
 	} else {
 		ant.MaxTokens = 8192
+		note("convert: no max_tokens; using %d", ant.MaxTokens)
 	}
 
+	// Newer OpenAI SDKs send the instructions of reasoning models as
+	// "developer" messages, which are system prompts to Anthropic.
+	var system []map[string]any
 	for _, m := range oai.Messages {
-		if m.Role == "system" {
-			ant.System = m.Content
+		if m.Role == "system" || m.Role == "developer" {
+			system = append(system, systemBlocks(m.Content, degrade)...)
 		} else {
+			m.Content = convertContent(m.Content, degrade)
 			ant.Messages = append(ant.Messages, m)
 		}
 	}
+	switch len(system) {
+	case 0:
+	case 1:
+		ant.System = system[0]["text"]
+	default:
+		ant.System = system
+	}
 
 	if len(ant.Messages) == 0 {
+		note("convert: no user or assistant messages; sending a placeholder")
 		ant.Messages = []oaiMessage{{Role: "user", Content: "Hello"}}
 	}
 
-	return ant
+	if n := len(oai.Tools) + len(oai.Functions); n > 0 {
+		degrade("tools dropped (%d)", n)
+	}
+	if len(oai.LogitBias) > 0 {
+		degrade("logit_bias dropped")
+	}
+	if oai.N != nil && *oai.N > 1 {
+		degrade("n=%d: one choice returned", *oai.N)
+	}
+	if oai.PresencePenalty != nil || oai.FrequencyPenalty != nil {
+		degrade("presence and frequency penalties dropped")
+	}
+	if oai.Seed != nil {
+		degrade("seed dropped; answers are not reproducible")
+	}
+	if t, _ := oai.ResponseFormat["type"].(string); t != "" && t != "text" {
+		degrade("response_format %s dropped", t)
+	}
+	if oai.Logprobs {
+		degrade("logprobs dropped")
+	}
+	return ant, degraded
+}
+
+// convertContent turns a message's OpenAI content parts into Anthropic
+// content blocks: text as is, image_url as an image, from its data URL or
+// its link. Other parts, such as audio and files, are dropped.
+func convertContent(content any, degrade func(string, ...any)) any {
+	parts, ok := content.([]any)
+	if !ok {
+		return content
+	}
+	out := make([]any, 0, len(parts))
+	for _, p := range parts {
+		part, _ := p.(map[string]any)
+		switch typ, _ := part["type"].(string); typ {
+		case "text":
+			out = append(out, part)
+		case "image_url":
+			img, _ := part["image_url"].(map[string]any)
+			url, _ := img["url"].(string)
+			if d, _ := img["detail"].(string); d != "" && d != "auto" {
+				degrade("image detail %q ignored", d)
+			}
+			if block := imageBlock(url); block != nil {
+				out = append(out, block)
+			} else {
+				degrade("image dropped: not a data URL or http(s) link")
+			}
+		default:
+			degrade("%s part dropped", cmp.Or(typ, "untyped"))
+		}
+	}
+	return out
+}
+
+// imageBlock is an Anthropic image block for an OpenAI image URL, or nil.
+func imageBlock(url string) map[string]any {
+	if rest, ok := strings.CutPrefix(url, "data:"); ok {
+		mediaType, data, ok := strings.Cut(rest, ";base64,")
+		if !ok {
+			return nil
+		}
+		return map[string]any{"type": "image", "source": map[string]any{
+			"type": "base64", "media_type": mediaType, "data": data,
+		}}
+	}
+	if strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://") {
+		return map[string]any{"type": "image", "source": map[string]any{"type": "url", "url": url}}
+	}
+	return nil
+}
+
+// systemBlocks turns a system message's content, a string or an array of
+// content parts, into Anthropic text blocks, in order. Parts other than
+// text have no place in a system prompt and are dropped.
+func systemBlocks(content any, degrade func(string, ...any)) []map[string]any {
+	var out []map[string]any
+	add := func(text string) {
+		if text != "" {
+			out = append(out, map[string]any{"type": "text", "text": text})
+		}
+	}
+	switch c := content.(type) {
+	case string:
+		add(c)
+	case []any:
+		for _, p := range c {
+			part, _ := p.(map[string]any)
+			if typ, _ := part["type"].(string); typ != "text" {
+				degrade("%s part of a system message dropped", cmp.Or(typ, "untyped"))
+				continue
+			}
+			text, _ := part["text"].(string)
+			add(text)
+		}
+	case nil:
+	default:
+		degrade("system message of %T dropped", c)
+	}
+	return out
 }
 
-func convertResponse(ant anthropicResponse) oaiResponse {
+func convertResponse(ant anthropicResponse, note func(string, ...any)) oaiResponse {
 	var text strings.Builder
 	for _, c := range ant.Content {
 		if c.Type == "text" {
 			text.WriteString(c.Text)
+		} else {
+			note("convert: dropping %q content block from the response", c.Type)
 		}
 	}
 
-	reason := mapStopReason(ant.StopReason)
+	reason := mapStopReason(ant.StopReason, note)
 
 	return oaiResponse{
 		ID:      "chatcmpl-" + ant.ID,
@@ -430,13 +703,62 @@ func convertResponse(ant anthropicResponse) oaiResponse {
 	}
 }
 
-func mapStopReason(antReason string) string {
+// oaiErrors maps Anthropic error types to OpenAI's type and code.
+var oaiErrors = map[string][2]string{
+	"invalid_request_error": {"invalid_request_error", "invalid_request_error"},
+	"authentication_error":  {"invalid_request_error", "invalid_api_key"},
+	"permission_error":      {"invalid_request_error", "permission_denied"},
+	"not_found_error":       {"invalid_request_error", "model_not_found"},
+	"request_too_large":     {"invalid_request_error", "request_too_large"},
+	"rate_limit_error":      {"requests", "rate_limit_exceeded"},
+	"api_error":             {"server_error", "api_error"},
+	"overloaded_error":      {"server_error", "overloaded"},
+}
+
+// convertError turns an Anthropic error response into OpenAI's shape, so
+// tools show its message instead of failing to parse it. A body that is
+// not an Anthropic error is passed on as the message.
+func convertError(status int, body []byte) map[string]any {
+	var ant struct {
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	json.Unmarshal(body, &ant)
+	msg := ant.Error.Message
+	if msg == "" {
+		msg = strings.TrimSpace(string(body))
+		if len(msg) > 500 {
+			msg = msg[:500]
+		}
+	}
+	if msg == "" {
+		msg = fmt.Sprintf("upstream answered %d %s", status, http.StatusText(status))
+	}
+	kind, ok := oaiErrors[ant.Error.Type]
+	switch {
+	case ok:
+	case status == http.StatusTooManyRequests:
+		kind = oaiErrors["rate_limit_error"]
+	case status >= 500:
+		kind = [2]string{"server_error", cmp.Or(ant.Error.Type, "api_error")}
+	default:
+		kind = [2]string{"invalid_request_error", cmp.Or(ant.Error.Type, "invalid_request_error")}
+	}
+	return map[string]any{"error": map[string]any{
+		"message": msg, "type": kind[0], "code": kind[1], "param": nil,
+	}}
+}
+
+func mapStopReason(antReason string, note func(string, ...any)) string {
 	switch antReason {
 	case "end_turn", "stop_sequence":
 		return "stop"
 	case "max_tokens":
 		return "length"
 	default:
+		note("convert: stop reason %q reported as \"stop\"", antReason)
 		return "stop"
 	}
 }
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestConvertRequestSystem(t *testing.T) {
+	oai := oaiRequest{Model: "claude-sonnet-4-6", Messages: []oaiMessage{
+		{Role: "developer", Content: "Answer briefly."},
+		{Role: "user", Content: "hi"},
+	}}
+	ant, _ := convertRequest(oai, func(string, ...any) {})
+	if ant.System != "Answer briefly." {
+		t.Errorf("system = %v", ant.System)
+	}
+	if len(ant.Messages) != 1 || ant.Messages[0].Role != "user" {
+		t.Errorf("messages = %+v", ant.Messages)
+	}
+}
+
+func TestConvertRequestSeveralSystems(t *testing.T) {
+	oai := oaiRequest{Model: "claude-sonnet-4-6", Messages: []oaiMessage{
+		{Role: "system", Content: "You are terse."},
+		{Role: "user", Content: "hi"},
+		{Role: "developer", Content: []any{
+			map[string]any{"type": "text", "text": "Use British spelling."},
+			map[string]any{"type": "image_url", "image_url": map[string]any{"url": "x"}},
+			map[string]any{"type": "text", "text": "Cite sources."},
+		}},
+	}}
+	ant, _ := convertRequest(oai, func(string, ...any) {})
+	blocks, ok := ant.System.([]map[string]any)
+	if !ok || len(blocks) != 3 {
+		t.Fatalf("system = %#v", ant.System)
+	}
+	for i, want := range []string{"You are terse.", "Use British spelling.", "Cite sources."} {
+		if blocks[i]["type"] != "text" || blocks[i]["text"] != want {
+			t.Errorf("block %d = %v, want %q", i, blocks[i], want)
+		}
+	}
+	if len(ant.Messages) != 1 {
+		t.Errorf("messages = %+v", ant.Messages)
+	}
+}
+
+func TestConvertRequestDegraded(t *testing.T) {
+	n := 3
+	oai := oaiRequest{Model: "claude-sonnet-4-6", N: &n,
+		Tools:     []any{map[string]any{"type": "function"}},
+		LogitBias: map[string]any{"50256": -100},
+		Messages: []oaiMessage{{Role: "user", Content: []any{
+			map[string]any{"type": "text", "text": "what is this?"},
+			map[string]any{"type": "image_url", "image_url": map[string]any{"url": "data:image/png;base64,iVBOR", "detail": "low"}},
+			map[string]any{"type": "input_audio"},
+		}}},
+	}
+	ant, degraded := convertRequest(oai, func(string, ...any) {})
+	want := []string{`image detail "low" ignored`, "input_audio part dropped", "tools dropped (1)", "logit_bias dropped", "n=3: one choice returned"}
+	if strings.Join(degraded, "|") != strings.Join(want, "|") {
+		t.Errorf("degraded = %q, want %q", degraded, want)
+	}
+	content := ant.Messages[0].Content.([]any)
+	if len(content) != 2 {
+		t.Fatalf("content = %v", content)
+	}
+	if img := content[1].(map[string]any); img["type"] != "image" ||
+		img["source"].(map[string]any)["media_type"] != "image/png" {
+		t.Errorf("image = %v", img)
+	}
+}
+
+func TestConvertError(t *testing.T) {
+	cases := []struct {
+		status         int
+		body           string
+		msg, typ, code string
+	}{
+		{429, `{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`, "slow down", "requests", "rate_limit_exceeded"},
+		{401, `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`, "invalid x-api-key", "invalid_request_error", "invalid_api_key"},
+		{502, `<html>bad gateway</html>`, "<html>bad gateway</html>", "server_error", "api_error"},
+		{400, ``, "upstream answered 400 Bad Request", "invalid_request_error", "invalid_request_error"},
+	}
+	for _, c := range cases {
+		e := convertError(c.status, []byte(c.body))["error"].(map[string]any)
+		if e["message"] != c.msg || e["type"] != c.typ || e["code"] != c.code {
+			t.Errorf("%d %s: %v", c.status, c.body, e)
+		}
+	}
+}
+
+func TestConvertRequestAnthropicParams(t *testing.T) {
+	var oai oaiRequest
+	err := json.Unmarshal([]byte(`{"model":"claude-opus-4-6","max_tokens":4096,"top_k":40,
+		"thinking":{"type":"enabled","budget_tokens":2048},
+		"anthropic":{"metadata":{"user_id":"u1"},"top_k":10,"stream":true},
+		"messages":[{"role":"user","content":"hi"}]}`), &oai)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ant, degraded := convertRequest(oai, func(string, ...any) {})
+	body := ant.body().bytes()
+	var got map[string]any
+	json.Unmarshal(body, &got)
+	if got["top_k"] != 10.0 || got["stream"] != false || got["metadata"] == nil || got["thinking"] == nil {
+		t.Errorf("sent %s", body)
+	}
+	if len(degraded) != 1 || !strings.Contains(degraded[0], "anthropic.stream ignored") {
+		t.Errorf("degraded = %q", degraded)
+	}
+}
@@ -0,0 +1,27 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"miser/internal/api"
+)
+
+// registerPprof serves the runtime profiles under api.PprofPath, only to
+// callers with the API token: they expose the command line and memory,
+// which loopback trust alone shouldn't hand to any local process. pprof's
+// own handlers assume /debug/pprof/, so named profiles are looked up here.
+func (s *Server) registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc(api.PprofPath, s.tokenRequired(s.authorized(func(w http.ResponseWriter, r *http.Request) {
+		if name := strings.TrimPrefix(r.URL.Path, api.PprofPath); name != "" {
+			pprof.Handler(name).ServeHTTP(w, r)
+			return
+		}
+		pprof.Index(w, r)
+	})))
+	mux.HandleFunc(api.PprofPath+"cmdline", s.tokenRequired(s.authorized(pprof.Cmdline)))
+	mux.HandleFunc(api.PprofPath+"profile", s.tokenRequired(s.authorized(pprof.Profile)))
+	mux.HandleFunc(api.PprofPath+"symbol", s.tokenRequired(s.authorized(pprof.Symbol)))
+	mux.HandleFunc(api.PprofPath+"trace", s.tokenRequired(s.authorized(pprof.Trace)))
+}
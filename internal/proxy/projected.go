@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"miser/internal/format"
+	"miser/internal/tokens"
+	"miser/internal/tracker"
+)
+
+// CostGate checks each model request's projected cost — its local input
+// estimate at the input price plus max_tokens at the output price, the
+// most it can cost uncached — against a per-request limit. The zero value
+// disables it.
+type CostGate struct {
+	MaxCost float64 // dollars
+	Block   bool    // refuse requests over it with 402 instead of warning
+}
+
+// projected returns the projected cost of body, a Messages request, and
+// whether it could be sized.
+func projected(model string, body []byte) (float64, bool) {
+	in, ok := tokens.Request(body)
+	if !ok {
+		return 0, false
+	}
+	var req struct {
+		MaxTokens int `json:"max_tokens"`
+	}
+	json.Unmarshal(body, &req)
+	return tracker.CalculateCost(model, in, req.MaxTokens, 0, 0), true
+}
+
+// gateCost warns about or refuses a request whose projected cost is over
+// s.CostGate's limit; either way the request is recorded with it. ok is
+// false when the gate blocks and the request was refused.
+func (s *Server) gateCost(w http.ResponseWriter, x *exchange, body []byte, openAI bool) (ok bool) {
+	if s.CostGate.MaxCost <= 0 {
+		return true
+	}
+	cost, sized := projected(x.model, body)
+	if !sized {
+		s.debugf("cost gate: request can't be sized locally; not gating it")
+		return true
+	}
+	if cost <= s.CostGate.MaxCost {
+		return true
+	}
+	x.projected = cost
+	msg := fmt.Sprintf("miser: request for %s projected to cost up to %s, over the per-request limit of %s",
+		x.model, format.Cost(cost), format.Cost(s.CostGate.MaxCost))
+	if !s.CostGate.Block {
+		s.logger.Printf("[COST] %s", msg)
+		return true
+	}
+	s.logger.Printf("[COST] refused: %s", msg)
+	writeError(w, http.StatusPaymentRequired, openAI, "projected_cost_exceeded", msg)
+	rec := x.request()
+	rec.StatusCode = http.StatusPaymentRequired
+	rec.Error = msg
+	s.record(x, rec)
+	return false
+}
@@ -1,19 +1,31 @@
 package proxy
 
 import (
-	"bufio"
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"miser/internal/audit"
+	"miser/internal/budget"
+	"miser/internal/capture"
 	"miser/internal/compress"
+	"miser/internal/format"
+	"miser/internal/hook"
+	"miser/internal/keys"
+	"miser/internal/peer"
 	"miser/internal/tracker"
 )
 
@@ -22,8 +34,236 @@ type Server struct {
 	Target         string
 	Tracker        *tracker.Tracker
 	CompressConfig compress.Config
-	client         *http.Client
-	logger         *log.Logger
+	Capture        capture.Config
+	Version        string // reported by the status API
+	TargetName     string // reported by the API in place of Target, if set
+	APIToken       string // lets remote clients use the API; see authorized
+	Pprof          bool   // serve net/http/pprof under api.PprofPath
+	APIKey         string // if set, replaces the client's Anthropic credentials upstream
+	Project        string // for requests without a ProjectHeader
+	LookupClients  bool   // name the local process behind each connection; see peer
+	Budget         *budget.Budget
+	Audit          *audit.Log // records admin API actions; may be nil
+	Debug          bool       // log upstream URLs, header handling and parse fallbacks
+
+	// StallTimeout gives up on a client that accepts no part of a response
+	// for this long, cancelling the request upstream; 0 waits indefinitely.
+	StallTimeout time.Duration
+
+	// Conns bounds the connections clients hold open, so slow or idle
+	// ones can't exhaust the listener.
+	Conns ConnLimits
+
+	// Chain is set when Target is another miser: requests carry their
+	// project, client and ViaHeader on to it. The requests are marked as
+	// counted there either way, from its TrackedHeader.
+	Chain bool
+
+	// Shadow, if set, mirrors selected requests to a second upstream or
+	// model.
+	Shadow *Shadow
+
+	// Upstreams, if set, replaces Target with the active one of several
+	// endpoints.
+	Upstreams *Upstreams
+
+	// Race, if set, races selected requests against a second model.
+	Race *Race
+
+	// Hooks, if set, inspect, rewrite, tag or veto model requests and tag
+	// their responses.
+	Hooks *hook.Hooks
+
+	// OutputCap limits the max_tokens of model requests.
+	OutputCap OutputCap
+
+	// Defaults, by model glob, are sent for the sampling parameters a
+	// request leaves out.
+	Defaults map[string]Defaults
+
+	// Inject adds system prompt fragments to selected requests, in order.
+	Inject []Injection
+
+	// CostGate warns about or refuses requests projected to cost too much.
+	CostGate CostGate
+
+	// Aliases maps client-facing model names to the upstream models they
+	// stand for. Requests are sent, priced and recorded as the real model,
+	// and GET /v1/models lists the aliases.
+	Aliases map[string]string
+
+	// Retry resends model requests that fail before their response is
+	// relayed.
+	Retry RetryPolicy
+
+	// Tokens checks the input tokens upstream reports against a local
+	// estimate.
+	Tokens TokenCheck
+
+	// Aggregate accepts the requests of cluster nodes on api.IngestPath,
+	// recording each as its node's.
+	Aggregate bool
+
+	// Keys holds the virtual keys clients may send instead of a real one;
+	// each is swapped for APIKey or its entry in UpstreamKeys and held to
+	// its own limits. RequireKeys refuses requests that don't present one.
+	Keys         *keys.File
+	UpstreamKeys map[string]string
+	RequireKeys  bool
+
+	// ReloadPricing re-reads pricing for the pricing reload endpoint; the
+	// endpoint fails when it is nil.
+	ReloadPricing func() error
+
+	// Listening, if set, is called once the port is open and requests
+	// will be accepted.
+	Listening func()
+
+	started time.Time
+	client  *http.Client
+	logger  *log.Logger
+	limits  keyLimiter
+	nodes   nodeRuns
+
+	instanceOnce sync.Once
+	instanceName string
+	chainHinted  atomic.Bool
+}
+
+// ProjectHeader attributes a request to a project, ClientHeader names the
+// tool that sent it, in place of a process lookup, and ConversationHeader
+// names its conversation, in place of the one miser derives. Like every
+// X-Miser-* header they are for miser alone and are not forwarded upstream.
+const (
+	ProjectHeader      = "X-Miser-Project"
+	ClientHeader       = "X-Miser-Client"
+	ConversationHeader = "X-Miser-Conversation"
+)
+
+// exchange carries per-request state from the inbound handler through to
+// the tracker record.
+type exchange struct {
+	ctx     context.Context // the inbound request's, done when the client goes away
+	model   string
+	project string
+	client  string
+	key     string // virtual key name
+	from    string // the miser that forwarded the request, if chained
+	counted string // the upstream miser that recorded it too
+	net     netTrace
+	start   time.Time
+	comp    compress.Stats
+	prompt  string
+	shadow  chan tracker.Request // the real request, for its shadow; see mirror
+	sampled bool                 // keeps its captured previews; see capture.Config
+
+	estimate int // local input token estimate; 0 when not checked
+
+	conversation string // see conversation
+	messages     int
+
+	race  *tracker.Race  // set by do when the request was raced
+	retry *tracker.Retry // set by retry when the request was resent
+
+	fallbackFrom string // the model asked for, when retry fell back from it
+
+	requestID string             // upstream's, from the response; see requestID
+	rateLimit *tracker.RateLimit // from the response's headers; see rateLimit
+
+	tags    []string       // from the request hooks
+	hookEnv map[string]any // what the request hooks saw, for the response hooks
+
+	degraded []string // what the OpenAI conversion dropped or approximated
+	sent     []byte   // the request body sent upstream, for estimateUsage
+
+	cappedFrom   int     // the max_tokens asked for, when OutputCap lowered it
+	ownMaxTokens bool    // max_tokens was filled in by miser, not sent by the client
+	projected    float64 // the projected cost, when over CostGate's limit
+
+	injected []string // names of the Inject fragments added to the system prompt
+
+	body *reqBody // the Messages request, as rewritten so far
+}
+
+// newExchange starts the record of a model request.
+func (s *Server) newExchange(r *http.Request) *exchange {
+	project := strings.TrimSpace(r.Header.Get(ProjectHeader))
+	if project == "" {
+		project = s.Project
+	}
+	// A tool's own name for itself beats its User-Agent, which beats the
+	// process, often just "node" or "python", unless the agent is unknown.
+	client := strings.TrimSpace(r.Header.Get(ClientHeader))
+	ua, known := userAgentClient(r.Header.Get("User-Agent"))
+	if client == "" && known {
+		client = ua
+	}
+	if c, ok := r.Context().Value(connKey{}).(*connPeer); ok && client == "" {
+		client = c.name(s)
+	}
+	if client == "" {
+		client = ua
+	}
+	x := &exchange{ctx: r.Context(), start: time.Now(), project: project, client: client, sampled: s.Capture.Sampled()}
+	if v, ok := r.Context().Value(keyCtx{}).(virtualKey); ok {
+		x.key = v.name
+	}
+	if hops := via(r.Header); len(hops) > 0 {
+		x.from = hops[len(hops)-1]
+	}
+	return x
+}
+
+type connKey struct{}
+
+// connPeer looks up a connection's client process once, on its first
+// model request, and remembers it for the rest of the connection.
+type connPeer struct {
+	conn   net.Conn
+	once   sync.Once
+	client string
+}
+
+func (c *connPeer) name(s *Server) string {
+	c.once.Do(func() {
+		var err error
+		c.client, err = peer.Name(c.conn.LocalAddr(), c.conn.RemoteAddr())
+		if err != nil {
+			s.debugf("client lookup for %s: %v", c.conn.RemoteAddr(), err)
+		}
+	})
+	return c.client
+}
+
+// request returns a tracker.Request pre-filled with the exchange's
+// metadata; callers add usage, status and the captured response.
+func (x *exchange) request() tracker.Request {
+	return tracker.Request{
+		Timestamp:      x.start,
+		Model:          x.model,
+		Latency:        time.Since(x.start),
+		RequestID:      x.requestID,
+		OriginalSize:   x.comp.OriginalBytes,
+		CompressedSize: x.comp.CompressedBytes,
+		Prompt:         x.prompt,
+		Project:        x.project,
+		Client:         x.client,
+		Key:            x.key,
+		Network:        x.net.network(),
+		From:           x.from,
+		CountedBy:      x.counted,
+		Conversation:   x.conversation,
+		Messages:       x.messages,
+		Race:           x.race,
+		Tags:           slices.Clone(x.tags),
+		Degraded:       x.degraded,
+		CappedFrom:     x.cappedFrom,
+		Projected:      x.projected,
+		Injected:       x.injected,
+		Retry:          x.retry,
+		RateLimit:      x.rateLimit,
+		FallbackFrom:   x.fallbackFrom,
+	}
 }
 
 func NewServer(port int, target string, timeout time.Duration, t *tracker.Tracker, cc compress.Config) *Server {
@@ -42,18 +282,79 @@ func NewServer(port int, target string, timeout time.Duration, t *tracker.Tracke
 	}
 }
 
+// SetTransport replaces how upstream requests are sent, e.g. to record
+// or play back traffic.
+func (s *Server) SetTransport(rt http.RoundTripper) {
+	s.client.Transport = rt
+}
+
+// SetLogger replaces the default stderr logger.
+func (s *Server) SetLogger(l *log.Logger) {
+	s.logger = l
+}
+
+func (s *Server) debugf(format string, args ...any) {
+	if s.Debug {
+		s.logger.Printf("[DEBUG] "+format, args...)
+	}
+}
+
+// debugHeaders logs which request headers go upstream (names only; values
+// can hold API keys) and which hop-by-hop ones were dropped.
+func (s *Server) debugHeaders(src, sent http.Header) {
+	if !s.Debug {
+		return
+	}
+	var kept, dropped []string
+	for k := range src {
+		if _, ok := sent[k]; ok {
+			kept = append(kept, k)
+		} else {
+			dropped = append(dropped, k)
+		}
+	}
+	for k := range sent {
+		if _, ok := src[k]; !ok {
+			kept = append(kept, k+" (set by miser)")
+		}
+	}
+	sort.Strings(kept)
+	sort.Strings(dropped)
+	s.debugf("headers: forwarded %v, dropped %v", kept, dropped)
+}
+
 func (s *Server) compressionEnabled() bool {
 	return s.CompressConfig.Whitespace || s.CompressConfig.StackTruncation || s.CompressConfig.Deduplication
 }
 
 // Start runs the HTTP server until ctx is cancelled, then shuts down gracefully.
 func (s *Server) Start(ctx context.Context) error {
+	s.started = time.Now()
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", s.handleRequest)
+	s.registerAPI(mux)
 
 	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", s.Port),
-		Handler: mux,
+		Addr:              fmt.Sprintf(":%d", s.Port),
+		Handler:           mux,
+		ReadHeaderTimeout: s.Conns.ReadHeaderTimeout,
+		IdleTimeout:       s.Conns.IdleTimeout,
+	}
+	if s.LookupClients {
+		srv.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, connKey{}, &connPeer{conn: c})
+		}
+	}
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	ln = s.limitListener(ln)
+	if s.Upstreams != nil {
+		go s.probeUpstreams(ctx)
+	}
+	if s.Listening != nil {
+		s.Listening()
 	}
 
 	go func() {
@@ -63,7 +364,7 @@ func (s *Server) Start(ctx context.Context) error {
 		srv.Shutdown(shutCtx)
 	}()
 
-	err := srv.ListenAndServe()
+	err = srv.Serve(ln)
 	if err == http.ErrServerClosed {
 		return nil
 	}
@@ -71,21 +372,46 @@ func (s *Server) Start(ctx context.Context) error {
 }
 
 func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
-	s.logger.Printf("[DEBUG] %s %s", r.Method, r.URL.Path)
-	if r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/v1/chat/completions") {
+	s.debugf("%s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+	r, ok := s.checkKey(w, r)
+	if !ok {
+		return
+	}
+	if r.Method == http.MethodPost && r.URL.Path == "/v1/chat/completions" {
+		if s.looped(w, r, true) || s.budgetBlocked(w, true) || s.keyLimited(w, r, true) {
+			return
+		}
+		w.Header().Set(TrackedHeader, s.instance())
 		s.handleChatCompletions(w, r)
 		return
 	}
-	if r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/v1/messages") {
+	// Only these two paths are model requests; others under /v1/messages,
+	// such as the batches API, pass through untracked.
+	if r.Method == http.MethodPost && (r.URL.Path == "/v1/messages" || r.URL.Path == "/v1/messages/count_tokens") {
+		// count_tokens is free, so it is never blocked.
+		if s.looped(w, r, false) {
+			return
+		}
+		counted := r.URL.Path == "/v1/messages"
+		if counted && (s.budgetBlocked(w, false) || s.keyLimited(w, r, false)) {
+			return
+		}
+		if counted {
+			w.Header().Set(TrackedHeader, s.instance())
+		}
 		s.handleMessages(w, r)
 		return
 	}
-	s.logger.Printf("[DEBUG] passthrough: %s %s", r.Method, r.URL.Path)
+	if r.Method == http.MethodGet && r.URL.Path == "/v1/models" && len(s.Aliases) > 0 {
+		s.handleModels(w, r)
+		return
+	}
+	s.debugf("passthrough: %s %s", r.Method, r.URL.Path)
 	s.passthrough(w, r)
 }
 
 func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
+	x := s.newExchange(r)
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -98,160 +424,224 @@ func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
 		Model  string `json:"model"`
 		Stream bool   `json:"stream"`
 	}
-	json.Unmarshal(body, &reqInfo)
-	s.logger.Printf("[DEBUG] handleMessages model=%q stream=%v bodyLen=%d", reqInfo.Model, reqInfo.Stream, len(body))
+	if err := json.Unmarshal(body, &reqInfo); err != nil {
+		s.debugf("messages: request body is not JSON (%v); forwarding as is", err)
+	}
+	s.debugf("messages: model=%q stream=%v bodyLen=%d", reqInfo.Model, reqInfo.Stream, len(body))
+	x.model = reqInfo.Model
 
-	var compStats compress.Stats
 	if s.compressionEnabled() {
-		body, compStats = s.compressAnthropicBody(body)
+		body, x.comp = s.compressAnthropicBody(body)
+	}
+	x.conversation, x.messages = conversation(r.Header, body)
+	x.body = parseBody(body)
+	s.resolveAlias(x)
+	countTokens := r.URL.Path == "/v1/messages/count_tokens"
+	if !countTokens {
+		s.applyDefaults(x)
+		if !s.runHooks(w, x, false) || !s.capOutput(w, x, false) {
+			return
+		}
+		s.inject(x, RouteMessages)
+	}
+	body = x.body.bytes()
+	x.prompt = s.Capture.Prompt(body)
+	if !countTokens {
+		if !s.gateCost(w, x, body, false) {
+			return
+		}
+		s.estimate(x, body)
 	}
 
-	upstreamURL := s.Target + r.URL.Path
+	target := s.target()
+	upstreamURL := target + r.URL.Path
 	if r.URL.RawQuery != "" {
 		upstreamURL += "?" + r.URL.RawQuery
 	}
 
-	upReq, err := http.NewRequestWithContext(r.Context(), http.MethodPost, upstreamURL, bytes.NewReader(body))
+	upReq, err := http.NewRequestWithContext(x.net.with(r.Context()), http.MethodPost, upstreamURL, bytes.NewReader(body))
 	if err != nil {
-		s.recordError(reqInfo.Model, start, err, compStats)
+		s.recordError(x, err)
 		http.Error(w, "failed to create upstream request", http.StatusInternalServerError)
 		return
 	}
 	copyHeaders(upReq.Header, r.Header)
-
-	resp, err := s.client.Do(upReq)
+	s.setAPIKey(r.Context(), upReq.Header)
+	s.chainHeaders(x, r.Header, upReq.Header)
+	s.debugf("upstream: POST %s", upstreamURL)
+	s.debugHeaders(r.Header, upReq.Header)
+	if !countTokens {
+		s.mirror(r.Context(), x, upReq.Header, body)
+		defer x.endShadow()
+	}
+
+	var resp *http.Response
+	if countTokens {
+		resp, err = s.client.Do(upReq)
+	} else {
+		resp, err = s.retry(r.Context(), x, upReq, body)
+	}
 	if err != nil {
-		s.recordError(reqInfo.Model, start, err, compStats)
+		s.debugf("upstream: %v", err)
+		s.unreachable(r.Context(), target, err)
+		s.recordError(x, err)
 		http.Error(w, fmt.Sprintf("upstream error: %v", err), http.StatusBadGateway)
 		return
 	}
 	defer resp.Body.Close()
+	s.counted(x, resp)
+	x.requestID, x.rateLimit = requestID(resp.Header), rateLimit(resp.Header)
 
 	ct := resp.Header.Get("Content-Type")
+	s.debugf("upstream: %s, Content-Type %q, request-id %q", resp.Status, ct, x.requestID)
 	if reqInfo.Stream && strings.Contains(ct, "text/event-stream") {
-		s.handleStreaming(w, resp, reqInfo.Model, start, compStats)
+		s.handleStreaming(w, resp, x)
 	} else {
-		s.handleNonStreaming(w, resp, reqInfo.Model, start, compStats)
+		if reqInfo.Stream {
+			s.debugf("messages: stream requested but upstream sent %q; relaying as one response", ct)
+		}
+		s.handleNonStreaming(w, resp, x)
 	}
 }
 
-func (s *Server) handleNonStreaming(w http.ResponseWriter, resp *http.Response, model string, start time.Time, cs compress.Stats) {
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		s.recordError(model, start, err)
-		http.Error(w, "failed to read upstream response", http.StatusBadGateway)
+func (s *Server) handleNonStreaming(w http.ResponseWriter, resp *http.Response, x *exchange) {
+	copyHeaders(w.Header(), resp.Header)
+	w.WriteHeader(resp.StatusCode)
+
+	// Forward the body as it arrives, keeping only what usage and capture
+	// are parsed from.
+	var tap bodyTap
+	cw := s.clientWriter(w, false)
+	defer cw.done()
+	if _, err := io.Copy(cw, io.TeeReader(resp.Body, &tap)); err != nil {
+		s.debugf("messages: relaying response: %v", err)
+		if cw.stalled() || x.gone() {
+			rec := x.request()
+			rec.StatusCode = resp.StatusCode
+			if cw.stalled() {
+				s.stalled(x, &rec)
+			} else {
+				s.cancelled(x, &rec)
+			}
+			s.record(x, rec)
+			return
+		}
+		s.recordError(x, err)
 		return
 	}
 
-	copyHeaders(w.Header(), resp.Header)
-	w.WriteHeader(resp.StatusCode)
-	w.Write(body)
-
-	var msg struct {
-		Usage struct {
-			InputTokens              int `json:"input_tokens"`
-			OutputTokens             int `json:"output_tokens"`
-			CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
-			CacheReadInputTokens     int `json:"cache_read_input_tokens"`
-		} `json:"usage"`
-	}
-	if json.Unmarshal(body, &msg) == nil {
-		cost := tracker.CalculateCost(model,
-			msg.Usage.InputTokens, msg.Usage.OutputTokens,
-			msg.Usage.CacheReadInputTokens, msg.Usage.CacheCreationInputTokens)
-		s.Tracker.Record(tracker.Request{
-			Timestamp:      start,
-			Model:          model,
-			InputTokens:    msg.Usage.InputTokens,
-			OutputTokens:   msg.Usage.OutputTokens,
-			CacheRead:      msg.Usage.CacheReadInputTokens,
-			CacheWrite:     msg.Usage.CacheCreationInputTokens,
-			Cost:           cost,
-			Latency:        time.Since(start),
-			StatusCode:     resp.StatusCode,
-			OriginalSize:   cs.OriginalBytes,
-			CompressedSize: cs.CompressedBytes,
-		})
-	}
-}
-
-func (s *Server) handleStreaming(w http.ResponseWriter, resp *http.Response, model string, start time.Time, cs compress.Stats) {
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		s.handleNonStreaming(w, resp, model, start, cs)
+	u, err := tap.usage()
+	if err != nil && resp.StatusCode >= 400 {
+		head, _ := tap.whole()
+		s.debugf("messages: error response is not JSON (%v): %.200s", err, head)
+		rec := x.request()
+		rec.StatusCode = resp.StatusCode
+		rec.Error = fmt.Sprintf("upstream %d (non-JSON body)", resp.StatusCode)
+		s.record(x, rec)
+		return
+	}
+	rec := x.request()
+	rec.InputTokens = u.InputTokens
+	rec.OutputTokens = u.OutputTokens
+	rec.CacheRead = u.CacheReadInputTokens
+	rec.CacheWrite = u.CacheCreationInputTokens
+	rec.Cost = tracker.CalculateCost(x.model,
+		rec.InputTokens, rec.OutputTokens, rec.CacheRead, rec.CacheWrite)
+	rec.StatusCode = resp.StatusCode
+	if body, ok := tap.whole(); ok {
+		rec.Response = s.Capture.Response(body)
+		output, tool := responseTokens(body)
+		if u == (usage{}) {
+			s.estimateUsage(x, &rec, false, false, output)
+		}
+		toolTokens(&rec, tool, output)
+	} else {
+		s.debugf("messages: %d-byte response is too large to capture", tap.n)
+		if u == (usage{}) {
+			s.estimateUsage(x, &rec, false, false, int(tap.n/bytesPerToken))
+		}
+	}
+	s.record(x, rec)
+}
+
+func (s *Server) handleStreaming(w http.ResponseWriter, resp *http.Response, x *exchange) {
+	if _, ok := w.(http.Flusher); !ok {
+		s.debugf("messages: response writer cannot flush; buffering the stream")
+		s.handleNonStreaming(w, resp, x)
 		return
 	}
 
 	copyHeaders(w.Header(), resp.Header)
 	w.WriteHeader(resp.StatusCode)
 
-	var inputTokens, outputTokens, cacheRead, cacheWrite int
+	cw := s.clientWriter(w, true)
+	defer cw.done()
+	ev := newEventStream(s)
+	err := relay(cw, resp.Body, ev, make([]byte, relayBufferSize))
+	ev.close()
 
-	scanner := bufio.NewScanner(resp.Body)
-	scanner.Buffer(make([]byte, 0, 1024*1024), 1024*1024)
+	s.debugf("SSE: done model=%q input=%d output=%d cacheR=%d cacheW=%d",
+		x.model, ev.inputTokens, ev.outputTokens, ev.cacheRead, ev.cacheWrite)
+	if err != nil {
+		s.debugf("SSE: stream ended early: %v", err)
+	}
+	rec := x.request()
+	rec.InputTokens = ev.inputTokens
+	rec.OutputTokens = ev.outputTokens
+	rec.CacheRead = ev.cacheRead
+	rec.CacheWrite = ev.cacheWrite
+	rec.Cost = tracker.CalculateCost(x.model, ev.inputTokens, ev.outputTokens, ev.cacheRead, ev.cacheWrite)
+	rec.StatusCode = resp.StatusCode
+	rec.Response = ev.captured.Text()
+	rec.Error = ev.err
+	s.estimateUsage(x, &rec, ev.started, ev.finished, ev.outputEstimate())
+	toolTokens(&rec, ev.toolEstimate(), ev.outputEstimate())
+	rec.Truncated = truncated(rec, ev.finished)
+	if cw.stalled() {
+		s.stalled(x, &rec)
+	} else if err != nil && x.gone() {
+		s.cancelled(x, &rec)
+	}
+	s.record(x, rec)
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		fmt.Fprintf(w, "%s\n", line)
-		flusher.Flush()
+// truncated reports whether a stream that came back ended before its final
+// usage, with no error event to say why.
+func truncated(rec tracker.Request, finished bool) bool {
+	return !finished && rec.StatusCode < 400 && rec.Error == ""
+}
 
-		if !strings.HasPrefix(line, "data: ") {
-			continue
-		}
-		data := line[6:]
-		if data == "[DONE]" {
-			continue
-		}
+// requestID returns the ID upstream gave a request, which Anthropic's
+// support asks for: its request-id header, or x-request-id from gateways
+// that send that instead.
+func requestID(h http.Header) string {
+	return cmp.Or(h.Get("Request-Id"), h.Get("X-Request-Id"))
+}
 
-		var event struct {
-			Type    string `json:"type"`
-			Message struct {
-				Usage struct {
-					InputTokens              int `json:"input_tokens"`
-					CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
-					CacheReadInputTokens     int `json:"cache_read_input_tokens"`
-				} `json:"usage"`
-			} `json:"message"`
-			Usage struct {
-				OutputTokens int `json:"output_tokens"`
-			} `json:"usage"`
-		}
-		if json.Unmarshal([]byte(data), &event) != nil {
-			continue
-		}
-		switch event.Type {
-		case "message_start":
-			inputTokens = event.Message.Usage.InputTokens
-			cacheRead = event.Message.Usage.CacheReadInputTokens
-			cacheWrite = event.Message.Usage.CacheCreationInputTokens
-		case "message_delta":
-			outputTokens = event.Usage.OutputTokens
-		}
-	}
+// gone reports whether the client went away, which also cancels the
+// request upstream: its context is the inbound request's.
+func (x *exchange) gone() bool {
+	return x.ctx.Err() != nil
+}
 
-	s.logger.Printf("[DEBUG] streaming done model=%q input=%d output=%d cacheR=%d cacheW=%d",
-		model, inputTokens, outputTokens, cacheRead, cacheWrite)
-	if err := scanner.Err(); err != nil {
-		s.logger.Printf("[DEBUG] scanner error: %v", err)
-	}
-	cost := tracker.CalculateCost(model, inputTokens, outputTokens, cacheRead, cacheWrite)
-	s.Tracker.Record(tracker.Request{
-		Timestamp:      start,
-		Model:          model,
-		InputTokens:    inputTokens,
-		OutputTokens:   outputTokens,
-		CacheRead:      cacheRead,
-		CacheWrite:     cacheWrite,
-		Cost:           cost,
-		Latency:        time.Since(start),
-		StatusCode:     resp.StatusCode,
-		OriginalSize:   cs.OriginalBytes,
-		CompressedSize: cs.CompressedBytes,
-	})
+// cancelled marks a request whose client went away.
+func (s *Server) cancelled(x *exchange, rec *tracker.Request) {
+	s.logger.Printf("[PROXY] %s cancelled the request for %s after %s",
+		cmp.Or(x.client, "a client"), cmp.Or(x.model, "a request"), time.Since(x.start).Round(time.Millisecond))
+	rec.Error = tracker.ClientCancelled
+}
+
+// stalled marks a request whose client stopped reading the response.
+// Returning closes the upstream body, which cancels the request there.
+func (s *Server) stalled(x *exchange, rec *tracker.Request) {
+	s.logger.Printf("[PROXY] %s stopped reading the response for %s; dropped it after %s",
+		cmp.Or(x.client, "a client"), cmp.Or(x.model, "a request"), s.StallTimeout)
+	rec.Error = tracker.ClientStalled
 }
 
 func (s *Server) passthrough(w http.ResponseWriter, r *http.Request) {
-	upstreamURL := s.Target + r.URL.Path
+	target := s.target()
+	upstreamURL := target + r.URL.Path
 	if r.URL.RawQuery != "" {
 		upstreamURL += "?" + r.URL.RawQuery
 	}
@@ -262,9 +652,14 @@ func (s *Server) passthrough(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	copyHeaders(upReq.Header, r.Header)
+	s.setAPIKey(r.Context(), upReq.Header)
+	s.debugf("upstream: %s %s", r.Method, upstreamURL)
+	s.debugHeaders(r.Header, upReq.Header)
 
 	resp, err := s.client.Do(upReq)
 	if err != nil {
+		s.debugf("upstream: %v", err)
+		s.unreachable(r.Context(), target, err)
 		http.Error(w, fmt.Sprintf("upstream error: %v", err), http.StatusBadGateway)
 		return
 	}
@@ -275,18 +670,76 @@ func (s *Server) passthrough(w http.ResponseWriter, r *http.Request) {
 	io.Copy(w, resp.Body)
 }
 
-func (s *Server) recordError(model string, start time.Time, err error, cs ...compress.Stats) {
-	r := tracker.Request{
-		Timestamp: start,
-		Model:     model,
-		Latency:   time.Since(start),
-		Error:     err.Error(),
+// budgetBlocked refuses a model request with 402 Payment Required when the
+// budget's action is block and its limit is reached, writing its error
+// through writeError.
+func (s *Server) budgetBlocked(w http.ResponseWriter, openAI bool) bool {
+	if s.Budget == nil || !s.Budget.Blocking() {
+		return false
+	}
+	st := s.Budget.Status()
+	msg := fmt.Sprintf("miser: budget of %s %s reached (%s spent); raise it with \"miser ctl budget\" or wait for the next %s",
+		format.Cost(st.Limit), st.Period.Label(), format.Cost(st.Spent), st.Period)
+	switch st.Period {
+	case budget.Session:
+		msg = fmt.Sprintf("miser: budget of %s this session reached (%s spent); raise or reset it with \"miser ctl budget\"",
+			format.Cost(st.Limit), format.Cost(st.Spent))
+	case budget.Rolling:
+		msg = fmt.Sprintf("miser: budget of %s in the last 24 hours reached (%s spent); raise it with \"miser ctl budget\" or wait for earlier spend to age out, the oldest at %s",
+			format.Cost(st.Limit), format.Cost(st.Spent), st.Until.Local().Format("15:04"))
+	}
+	s.logger.Printf("[BUDGET] blocked request: %s", msg)
+	writeError(w, http.StatusPaymentRequired, openAI, "budget_exceeded", msg)
+	return true
+}
+
+// writeError answers with an error body in the OpenAI shape for chat
+// completions and Anthropic's otherwise, so tools show the message rather
+// than a parse failure.
+func writeError(w http.ResponseWriter, status int, openAI bool, typ, msg string) {
+	var body any
+	if openAI {
+		body = map[string]any{"error": map[string]string{
+			"message": msg, "type": typ, "code": typ,
+		}}
+	} else {
+		body = map[string]any{"type": "error", "error": map[string]string{
+			"type": typ, "message": msg,
+		}}
 	}
-	if len(cs) > 0 {
-		r.OriginalSize = cs[0].OriginalBytes
-		r.CompressedSize = cs[0].CompressedBytes
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (s *Server) recordError(x *exchange, err error) {
+	r := x.request()
+	r.Error = err.Error()
+	if x.gone() {
+		s.cancelled(x, &r)
+	}
+	s.record(x, r)
+}
+
+// record tracks a finished model request, and passes it on to the
+// request's shadow, if there is one. Previews are dropped unless capture
+// sampling keeps them.
+func (s *Server) record(x *exchange, rec tracker.Request) {
+	s.checkTokens(x, &rec)
+	if prompt := rec.InputTokens + rec.CacheRead + rec.CacheWrite; prompt > 0 {
+		rec.ContextUsed = tracker.ContextUsed(rec.Model, prompt)
+	}
+	s.responseHooks(x, &rec)
+	if !x.sampled || !s.Capture.Keeps(rec.Cost) {
+		rec.Prompt, rec.Response = "", ""
+	}
+	s.Tracker.Record(rec)
+	if x.shadow != nil {
+		select {
+		case x.shadow <- rec:
+		default:
+		}
 	}
-	s.Tracker.Record(r)
 }
 
 // compressAnthropicBody extracts text from system and messages fields,
@@ -296,6 +749,7 @@ func (s *Server) recordError(model string, start time.Time, err error, cs ...com
 func (s *Server) compressAnthropicBody(body []byte) ([]byte, compress.Stats) {
 	var raw map[string]json.RawMessage
 	if err := json.Unmarshal(body, &raw); err != nil {
+		s.debugf("compress: body is not a JSON object (%v); sending uncompressed", err)
 		return body, compress.Stats{}
 	}
 
@@ -456,9 +910,91 @@ var hopHeaders = map[string]bool{
 	"Host":                true,
 }
 
+// setAPIKey swaps in the upstream key for the request, dropping any bearer
+// token the client sent so Anthropic doesn't see two credentials.
+func (s *Server) setAPIKey(ctx context.Context, h http.Header) {
+	key := s.upstreamKey(ctx)
+	if key == "" {
+		return
+	}
+	h.Del("Authorization")
+	h.Set("x-api-key", key)
+	if v, ok := ctx.Value(keyCtx{}).(virtualKey); ok {
+		s.debugf("headers: virtual key %q replaced by its upstream key", v.name)
+	} else {
+		s.debugf("headers: client credentials replaced by the configured api_key")
+	}
+}
+
+// upstreamKey is the key that replaces the client's: its virtual key's, or
+// the configured api_key. "" forwards the client's own.
+func (s *Server) upstreamKey(ctx context.Context) string {
+	if v, ok := ctx.Value(keyCtx{}).(virtualKey); ok {
+		return v.upstream
+	}
+	return s.APIKey
+}
+
+type keyCtx struct{}
+
+// virtualKey is a virtual key the request presented and its real key.
+type virtualKey struct {
+	name     string
+	upstream string
+	limits   keys.Limits
+}
+
+// checkKey resolves a virtual key the client sent, refusing an unknown or
+// revoked one, and any request without one when RequireKeys is set. The
+// returned request carries the key for setAPIKey and the tracker record.
+func (s *Server) checkKey(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if s.Keys == nil {
+		return r, true
+	}
+	openAI := r.URL.Path == "/v1/chat/completions"
+	secret := r.Header.Get("x-api-key")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && secret == "" {
+		secret = bearer
+	}
+	if !strings.HasPrefix(secret, keys.Prefix) {
+		if s.RequireKeys {
+			s.logger.Printf("[KEYS] refused %s %s from %s: no virtual key", r.Method, r.URL.Path, r.RemoteAddr)
+			writeError(w, http.StatusUnauthorized, openAI, "authentication_error",
+				"miser: this proxy requires a key issued with \"miser keys create\"")
+			return r, false
+		}
+		return r, true
+	}
+
+	k, ok, err := s.Keys.Lookup(secret)
+	if err != nil {
+		s.logger.Printf("[KEYS] %v", err)
+		writeError(w, http.StatusInternalServerError, openAI, "api_error", "miser: cannot read virtual keys")
+		return r, false
+	}
+	if !ok {
+		s.logger.Printf("[KEYS] refused %s %s from %s: unknown or revoked virtual key", r.Method, r.URL.Path, r.RemoteAddr)
+		writeError(w, http.StatusUnauthorized, openAI, "authentication_error", "miser: unknown or revoked virtual key")
+		return r, false
+	}
+	upstream := s.APIKey
+	if k.Upstream != "" {
+		upstream = s.UpstreamKeys[k.Upstream]
+	}
+	if upstream == "" {
+		s.logger.Printf("[KEYS] virtual key %q has no upstream key: set [proxy] api_key or [keys.upstream] %s", k.Name, k.Upstream)
+		writeError(w, http.StatusInternalServerError, openAI, "api_error",
+			fmt.Sprintf("miser: no upstream key is configured for virtual key %q", k.Name))
+		return r, false
+	}
+	ctx := context.WithValue(r.Context(), keyCtx{}, virtualKey{name: k.Name, upstream: upstream, limits: k.Limits})
+	return r.WithContext(ctx), true
+}
+
+// copyHeaders copies all but hop-by-hop headers and miser's own X-Miser-*.
 func copyHeaders(dst, src http.Header) {
 	for k, vv := range src {
-		if hopHeaders[k] {
+		if hopHeaders[k] || strings.HasPrefix(k, "X-Miser-") {
 			continue
 		}
 		for _, v := range vv {
@@ -0,0 +1,1052 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"miser/internal/capture"
+	"miser/internal/compress"
+	"miser/internal/hook"
+	"miser/internal/keys"
+	"miser/internal/tracker"
+)
+
+func TestAttributionHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, h := range []string{ProjectHeader, ClientHeader} {
+			if v := r.Header.Get(h); v != "" {
+				t.Errorf("%s: %q was forwarded upstream", h, v)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"usage":{"input_tokens":10,"output_tokens":2}}`))
+	}))
+	defer upstream.Close()
+
+	tr := tracker.New()
+	s := NewServer(0, upstream.URL, time.Second, tr, compress.Config{})
+	s.Project = "default"
+	for _, project := range []string{"web", ""} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"m"}`))
+		if project != "" {
+			req.Header.Set(ProjectHeader, project)
+			req.Header.Set(ClientHeader, "nightly-eval")
+		}
+		s.handleRequest(httptest.NewRecorder(), req)
+	}
+
+	got := tr.GetRequests()
+	if len(got) != 2 || got[0].Project != "web" || got[0].Client != "nightly-eval" || got[1].Project != "default" {
+		t.Errorf("projects = %+v", got)
+	}
+}
+
+func TestVirtualKeys(t *testing.T) {
+	var got []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("x-api-key")+"|"+r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"usage":{"input_tokens":10,"output_tokens":2}}`))
+	}))
+	defer upstream.Close()
+
+	f, err := keys.Open(t.TempDir() + "/keys.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, _, err := f.Create("alice", "", keys.Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ci, _, err := f.Create("ci", "batch", keys.Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := tracker.New()
+	s := NewServer(0, upstream.URL, time.Second, tr, compress.Config{})
+	s.APIKey = "sk-real"
+	s.Keys = f
+	s.UpstreamKeys = map[string]string{"batch": "sk-batch"}
+	s.RequireKeys = true
+	send := func(header, value string) int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"m"}`))
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		s.handleRequest(rec, req)
+		return rec.Code
+	}
+
+	if code := send("x-api-key", alice); code != http.StatusOK {
+		t.Errorf("alice: status %d", code)
+	}
+	if code := send("Authorization", "Bearer "+ci); code != http.StatusOK {
+		t.Errorf("ci: status %d", code)
+	}
+	if code := send("", ""); code != http.StatusUnauthorized {
+		t.Errorf("no key with RequireKeys: status %d, want 401", code)
+	}
+	if _, err := f.Revoke("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if code := send("x-api-key", alice); code != http.StatusUnauthorized {
+		t.Errorf("revoked: status %d, want 401", code)
+	}
+
+	if want := []string{"sk-real|", "sk-batch|"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("upstream credentials = %q, want %q", got, want)
+	}
+	reqs := tr.GetRequests()
+	if len(reqs) != 2 || reqs[0].Key != "alice" || reqs[1].Key != "ci" {
+		t.Errorf("recorded keys = %+v", reqs)
+	}
+}
+
+func TestKeyLimits(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"usage":{"input_tokens":1000000,"output_tokens":0}}`)) // $3 on sonnet
+	}))
+	defer upstream.Close()
+
+	f, err := keys.Open(t.TempDir() + "/keys.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rated, _, err := f.Create("rated", "", keys.Limits{RPM: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	capped, _, err := f.Create("capped", "", keys.Limits{Budget: 5, Period: "day"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(0, upstream.URL, time.Second, tracker.New(), compress.Config{})
+	s.APIKey = "sk-real"
+	s.Keys = f
+	send := func(secret string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-6"}`))
+		req.Header.Set("x-api-key", secret)
+		rec := httptest.NewRecorder()
+		s.handleRequest(rec, req)
+		return rec
+	}
+
+	for i, want := range []int{200, 200, 429} {
+		if rec := send(rated); rec.Code != want {
+			t.Errorf("rated request %d: status %d, want %d", i+1, rec.Code, want)
+		} else if want == 429 && rec.Header().Get("Retry-After") == "" {
+			t.Error("429 without Retry-After")
+		}
+	}
+	// $3, then $6 reaches the $5 budget; other keys are unaffected.
+	for i, want := range []int{200, 200, 402} {
+		if rec := send(capped); rec.Code != want {
+			t.Errorf("capped request %d: status %d, want %d", i+1, rec.Code, want)
+		}
+	}
+	if _, err := f.SetLimits("capped", keys.Limits{Budget: 10, Period: "day"}); err != nil {
+		t.Fatal(err)
+	}
+	if rec := send(capped); rec.Code != 200 {
+		t.Errorf("after raising the budget: status %d", rec.Code)
+	}
+}
+
+func TestNetworkTimings(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"usage":{"input_tokens":10,"output_tokens":2}}`))
+	}))
+	defer upstream.Close()
+
+	tr := tracker.New()
+	s := NewServer(0, upstream.URL, time.Second, tr, compress.Config{})
+	for range 2 {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"m"}`))
+		s.handleRequest(httptest.NewRecorder(), req)
+	}
+
+	got := tr.GetRequests()
+	if len(got) != 2 {
+		t.Fatalf("recorded %d requests", len(got))
+	}
+	if n := got[0].Network; n.Reused || n.Connect <= 0 || n.FirstByte <= 0 {
+		t.Errorf("first request: %+v, want a new connection with timings", n)
+	}
+	if n := got[1].Network; !n.Reused || n.Connect != 0 || n.FirstByte <= 0 {
+		t.Errorf("second request: %+v, want the connection reused", n)
+	}
+}
+
+func TestChainedMisers(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k := range r.Header {
+			if strings.HasPrefix(k, "X-Miser-") {
+				t.Errorf("%s reached the real upstream", k)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"usage":{"input_tokens":10,"output_tokens":2}}`))
+	}))
+	defer upstream.Close()
+
+	gwTracker, laptopTracker := tracker.New(), tracker.New()
+	gw := NewServer(9000, upstream.URL, time.Second, gwTracker, compress.Config{})
+	gwHTTP := httptest.NewServer(http.HandlerFunc(gw.handleRequest))
+	defer gwHTTP.Close()
+	laptop := NewServer(8080, gwHTTP.URL, time.Second, laptopTracker, compress.Config{})
+	laptop.Chain = true
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"m"}`))
+	req.Header.Set(ProjectHeader, "web")
+	req.Header.Set(ClientHeader, "claude")
+	rec := httptest.NewRecorder()
+	laptop.handleRequest(rec, req)
+	if rec.Header().Get(TrackedHeader) != laptop.instance() {
+		t.Errorf("%s = %q, want this miser's name", TrackedHeader, rec.Header().Get(TrackedHeader))
+	}
+
+	g, l := gwTracker.GetRequests(), laptopTracker.GetRequests()
+	if len(g) != 1 || g[0].Project != "web" || g[0].Client != "claude" || g[0].From != laptop.instance() {
+		t.Errorf("gateway recorded %+v", g)
+	}
+	if len(l) != 1 || l[0].CountedBy != gw.instance() || l[0].From != "" {
+		t.Errorf("laptop recorded %+v", l)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"m"}`))
+	req.Header.Set(ViaHeader, laptop.instance()+", "+gw.instance())
+	rec = httptest.NewRecorder()
+	gw.handleRequest(rec, req)
+	if rec.Code != http.StatusLoopDetected {
+		t.Errorf("looped request: status %d", rec.Code)
+	}
+}
+
+func TestCaptureSampling(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		out := 1
+		if strings.Contains(string(body), "essay") {
+			out = 100_000
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"content":[{"type":"text","text":"ok"}],"usage":{"input_tokens":10,"output_tokens":%d}}`, out)
+	}))
+	defer upstream.Close()
+
+	tr := tracker.New()
+	s := NewServer(0, upstream.URL, time.Second, tr, compress.Config{})
+	s.Capture = capture.Config{Enabled: true, MinCost: 0.01}
+	for _, ask := range []string{"hi", "write an essay"} {
+		body := `{"model":"claude-sonnet-4-6","messages":[{"role":"user","content":"` + ask + `"}]}`
+		s.handleRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+	}
+	got := tr.GetRequests()
+	if len(got) != 2 || got[0].Prompt != "" || got[0].Response != "" {
+		t.Fatalf("cheap request kept its previews: %+v", got)
+	}
+	if !strings.Contains(got[1].Prompt, "essay") || got[1].Response != "ok" {
+		t.Errorf("expensive request lost its previews: %+v", got[1])
+	}
+
+	s.Capture = capture.Config{Enabled: true, Sample: 0.5}
+	for range 200 {
+		s.handleRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/messages",
+			strings.NewReader(`{"model":"claude-sonnet-4-6","messages":[{"role":"user","content":"hi"}]}`)))
+	}
+	kept := 0
+	for _, r := range tr.GetRequests()[2:] {
+		if r.Prompt != "" {
+			kept++
+		}
+	}
+	if kept < 50 || kept > 150 {
+		t.Errorf("sample 0.5 kept %d of 200 previews", kept)
+	}
+}
+
+func TestTokenCheck(t *testing.T) {
+	reported := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"content":[{"type":"text","text":"ok"}],"usage":{"input_tokens":%d,"output_tokens":1}}`, reported)
+	}))
+	defer upstream.Close()
+
+	tr := tracker.New()
+	s := NewServer(0, upstream.URL, time.Second, tr, compress.Config{})
+	s.SetLogger(log.New(io.Discard, "", 0))
+	s.Tokens = TokenCheck{Tolerance: 0.25, MinTokens: 500}
+	body := `{"model":"claude-sonnet-4-6","messages":[{"role":"user","content":"` +
+		strings.Repeat("Please summarise the quarterly report and list the main risks. ", 50) + `"}]}`
+	for _, n := range []int{600, 2400, 0} {
+		reported = n
+		s.handleRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+	}
+	got := tr.GetRequests()
+	if len(got) != 3 || got[0].EstimatedInput != 0 {
+		t.Fatalf("a close count was flagged: %+v", got)
+	}
+	if got[1].EstimatedInput == 0 || got[2].EstimatedInput == 0 {
+		t.Errorf("counts far off the estimate were not flagged: %d, %d", got[1].EstimatedInput, got[2].EstimatedInput)
+	}
+}
+
+func TestConversation(t *testing.T) {
+	first := `{"model":"m","system":"terse","messages":[{"role":"user","content":[{"type":"text","text":"fix the bug","cache_control":{"type":"ephemeral"}}]}]}`
+	later := `{"model":"m","system":"terse","messages":[{"role":"user","content":[{"type":"text","text":"fix the bug"}]},` +
+		`{"role":"assistant","content":"done"},{"role":"user","content":"thanks","cache_control":{"type":"ephemeral"}}]}`
+	other := `{"model":"m","system":"terse","messages":[{"role":"user","content":"write a test"}]}`
+
+	a, n := conversation(http.Header{}, []byte(first))
+	b, m := conversation(http.Header{}, []byte(later))
+	c, _ := conversation(http.Header{}, []byte(other))
+	if a == "" || a != b || n != 1 || m != 3 {
+		t.Errorf("turns of one conversation: %q (%d messages), %q (%d)", a, n, b, m)
+	}
+	if c == a {
+		t.Error("another conversation got the same id")
+	}
+	if id, _ := conversation(http.Header{}, []byte(`{"model":"m"}`)); id != "" {
+		t.Errorf("a body without messages is conversation %q", id)
+	}
+
+	session := `{"model":"m","metadata":{"user_id":"user_ab12_account_cd34_session_5f6e"},"messages":[{"role":"user","content":"hi"}]}`
+	if id, _ := conversation(http.Header{}, []byte(session)); id != "5f6e" {
+		t.Errorf("Claude Code session: conversation %q", id)
+	}
+	h := http.Header{ConversationHeader: {"ticket-42"}}
+	if id, _ := conversation(h, []byte(session)); id != "ticket-42" {
+		t.Errorf("%s: conversation %q", ConversationHeader, id)
+	}
+}
+
+func TestUserAgentClient(t *testing.T) {
+	for _, c := range []struct {
+		ua, name string
+		known    bool
+	}{
+		{"claude-cli/1.0.58 (external, cli)", "claude", true},
+		{"Anthropic/Python 0.40.0", "anthropic-python", true},
+		{"aider/0.86 litellm/1.74 python-httpx/0.28", "aider", true},
+		{"curl/8.7.1", "curl", true},
+		{"Cursorial/1.0", "Cursorial", false},
+		{"(compatible)", "", false},
+		{"", "", false},
+	} {
+		if name, known := userAgentClient(c.ua); name != c.name || known != c.known {
+			t.Errorf("userAgentClient(%q) = %q, %v; want %q, %v", c.ua, name, known, c.name, c.known)
+		}
+	}
+}
+
+func TestUpstreams(t *testing.T) {
+	up := func(delay time.Duration) string {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(delay)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"usage":{"input_tokens":10,"output_tokens":2}}`))
+		}))
+		t.Cleanup(srv.Close)
+		return srv.URL
+	}
+	slow, fast := up(50*time.Millisecond), up(0)
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	dead := closed.URL
+
+	s := NewServer(0, dead, time.Second, tracker.New(), compress.Config{})
+	s.SetLogger(log.New(io.Discard, "", 0))
+	for _, tc := range []struct {
+		policy UpstreamPolicy
+		urls   []string
+		want   string
+	}{
+		{Fastest, []string{dead, slow, fast}, fast},
+		{Sticky, []string{slow, fast}, slow},
+		{Sticky, []string{dead, slow, fast}, fast},
+		{Manual, []string{dead, fast}, dead},
+	} {
+		s.Upstreams = NewUpstreams(tc.urls, tc.policy, 0)
+		s.probeUpstreams(t.Context())
+		if got := s.Upstreams.Active(); got != tc.want {
+			t.Errorf("%s of %v: active %s, want %s", tc.policy, tc.urls, got, tc.want)
+		}
+	}
+	if url, ok := s.Upstreams.Next(); !ok || url != fast {
+		t.Errorf("manual switch went to %q, %v", url, ok)
+	}
+
+	// A request that can't reach the active endpoint moves the next one on.
+	s.Upstreams = NewUpstreams([]string{dead, fast}, Sticky, 0)
+	body := `{"model":"claude-sonnet-4-6","messages":[{"role":"user","content":"hi"}]}`
+	s.handleRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+	if got := s.Upstreams.Active(); got != fast {
+		t.Errorf("after a failed request: active %s, want %s", got, fast)
+	}
+}
+
+func TestRace(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "claude-opus-4-6") {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(2 * time.Second):
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content":[{"type":"text","text":"ok"}],"usage":{"input_tokens":900,"output_tokens":5}}`))
+	}))
+	defer upstream.Close()
+
+	tr := tracker.New()
+	s := NewServer(0, upstream.URL, 5*time.Second, tr, compress.Config{})
+	s.SetLogger(log.New(io.Discard, "", 0))
+	s.Race = &Race{Model: "claude-haiku-4-5", Models: []string{"claude-opus-*"}}
+	body := `{"model":"claude-opus-4-6","messages":[{"role":"user","content":"` + strings.Repeat("word ", 200) + `"}]}`
+	start := time.Now()
+	w := httptest.NewRecorder()
+	s.handleRequest(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+	if w.Code != http.StatusOK || time.Since(start) > time.Second {
+		t.Fatalf("the faster model's answer was not relayed: %d after %s", w.Code, time.Since(start))
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for tr.Count() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	var won, lost *tracker.Request
+	for _, r := range tr.GetRequests() {
+		switch {
+		case r.Race == nil:
+		case r.Race.Lost:
+			lost = &r
+		default:
+			won = &r
+		}
+	}
+	if won == nil || won.Model != "claude-haiku-4-5" || won.InputTokens != 900 || won.Race.Requested != "claude-opus-4-6" {
+		t.Fatalf("winner: %+v", won)
+	}
+	if lost == nil || lost.Model != "claude-opus-4-6" || lost.InputTokens == 0 || lost.Cost == 0 {
+		t.Fatalf("the cancelled loser was not charged: %+v", lost)
+	}
+
+	// Other models are not raced.
+	s.handleRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/messages",
+		strings.NewReader(`{"model":"claude-sonnet-4-6","messages":[{"role":"user","content":"hi"}]}`)))
+	if got := tr.GetRequests(); len(got) != 3 || got[2].Race != nil {
+		t.Errorf("an unselected model was raced: %+v", got)
+	}
+}
+
+// failingRival fails requests for its model with a transport error of its
+// own, after the other side has had time to win, and sends the rest on.
+type failingRival struct {
+	model string
+	next  http.RoundTripper
+}
+
+func (f failingRival) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, _ := io.ReadAll(req.Body)
+	if strings.Contains(string(body), f.model) {
+		time.Sleep(200 * time.Millisecond)
+		return nil, errors.New("connection reset by peer")
+	}
+	req.Body = io.NopCloser(strings.NewReader(string(body)))
+	return f.next.RoundTrip(req)
+}
+
+func TestRaceFailedLoser(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content":[{"type":"text","text":"ok"}],"usage":{"input_tokens":900,"output_tokens":5}}`))
+	}))
+	defer upstream.Close()
+
+	tr := tracker.New()
+	s := NewServer(0, upstream.URL, 5*time.Second, tr, compress.Config{})
+	s.SetLogger(log.New(io.Discard, "", 0))
+	s.SetTransport(failingRival{model: "claude-opus-4-6", next: http.DefaultTransport})
+	s.Race = &Race{Model: "claude-haiku-4-5", Models: []string{"claude-opus-*"}}
+	body := `{"model":"claude-opus-4-6","messages":[{"role":"user","content":"` + strings.Repeat("word ", 200) + `"}]}`
+	w := httptest.NewRecorder()
+	s.handleRequest(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("the rival's answer was not relayed: %d", w.Code)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for tr.Count() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	var lost *tracker.Request
+	for _, r := range tr.GetRequests() {
+		if r.Race != nil && r.Race.Lost {
+			lost = &r
+		}
+	}
+	if lost == nil || lost.Model != "claude-opus-4-6" || lost.Error == "" {
+		t.Fatalf("the failed loser was not recorded with its error: %+v", lost)
+	}
+	if lost.InputTokens != 0 || lost.Cost != 0 {
+		t.Errorf("a loser that failed on its own was charged: %d tokens, $%f", lost.InputTokens, lost.Cost)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	var sent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sent = string(body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content":[{"type":"text","text":"ok"}],"usage":{"input_tokens":10,"output_tokens":5}}`))
+	}))
+	defer upstream.Close()
+
+	tr := tracker.New()
+	s := NewServer(0, upstream.URL, 5*time.Second, tr, compress.Config{})
+	s.SetLogger(log.New(io.Discard, "", 0))
+	var err error
+	s.Hooks, err = hook.Compile([]hook.Rule{
+		{When: `project == "ci"`, Set: map[string]any{"model": "claude-haiku-4-5"}, Drop: []string{"temperature"}, Tags: []string{"ci"}},
+		{When: `size(request.messages) > 2`, Veto: "conversation too long"},
+		{On: "response", When: `response.output_tokens >= 5`, Tags: []string{"answered"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages",
+		strings.NewReader(`{"model":"claude-opus-4-6","temperature":1,"messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set(ProjectHeader, "ci")
+	s.handleRequest(httptest.NewRecorder(), req)
+	if !strings.Contains(sent, `"claude-haiku-4-5"`) || strings.Contains(sent, "temperature") {
+		t.Errorf("upstream got %s", sent)
+	}
+	got := tr.GetRequests()
+	if len(got) != 1 || got[0].Model != "claude-haiku-4-5" || strings.Join(got[0].Tags, ",") != "ci,answered" {
+		t.Fatalf("recorded %+v", got)
+	}
+
+	sent = ""
+	w := httptest.NewRecorder()
+	s.handleRequest(w, httptest.NewRequest(http.MethodPost, "/v1/messages",
+		strings.NewReader(`{"model":"claude-opus-4-6","messages":[{},{},{}]}`)))
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "conversation too long") || sent != "" {
+		t.Errorf("veto: %d %s, upstream got %q", w.Code, w.Body, sent)
+	}
+}
+
+func TestBatchesPassThrough(t *testing.T) {
+	var path, sent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		path, sent = r.URL.Path, string(body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"msgbatch_1","type":"message_batch","processing_status":"in_progress"}`))
+	}))
+	defer upstream.Close()
+
+	tr := tracker.New()
+	s := NewServer(0, upstream.URL, 5*time.Second, tr, compress.Config{})
+	s.SetLogger(log.New(io.Discard, "", 0))
+	s.OutputCap = OutputCap{MaxTokens: 10}
+
+	body := `{"requests":[{"custom_id":"a","params":{"model":"claude-opus-4-6","max_tokens":64000,"messages":[]}}],"max_tokens":64000}`
+	w := httptest.NewRecorder()
+	s.handleRequest(w, httptest.NewRequest(http.MethodPost, "/v1/messages/batches", strings.NewReader(body)))
+	if w.Code != http.StatusOK || path != "/v1/messages/batches" || sent != body {
+		t.Errorf("%d; upstream got %s %s", w.Code, path, sent)
+	}
+	if got := tr.GetRequests(); len(got) != 0 || w.Header().Get(TrackedHeader) != "" {
+		t.Errorf("batch create tracked as a model request: %+v", got)
+	}
+}
+
+func TestAliases(t *testing.T) {
+	var sent, key string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/v1/models" {
+			key = r.Header.Get("x-api-key")
+			switch {
+			case key == "":
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"type":"error","error":{"type":"authentication_error","message":"x-api-key header is required"}}`))
+			case r.URL.Query().Has("after_id"):
+				w.Write([]byte(`{"data":[{"type":"model","id":"claude-sonnet-4-6"}],"has_more":false}`))
+			default:
+				w.Write([]byte(`{"data":[{"type":"model","id":"claude-opus-4-6"}],"has_more":true}`))
+			}
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		sent = string(body)
+		w.Write([]byte(`{"content":[{"type":"text","text":"ok"}],"usage":{"input_tokens":10,"output_tokens":5}}`))
+	}))
+	defer upstream.Close()
+
+	tr := tracker.New()
+	s := NewServer(0, upstream.URL, 5*time.Second, tr, compress.Config{})
+	s.SetLogger(log.New(io.Discard, "", 0))
+	s.Aliases = map[string]string{"fast": "claude-haiku-4-5"}
+
+	s.handleRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/chat/completions",
+		strings.NewReader(`{"model":"fast","messages":[{"role":"user","content":"hi"}]}`)))
+	if !strings.Contains(sent, `"model":"claude-haiku-4-5"`) {
+		t.Errorf("upstream got %s", sent)
+	}
+	if got := tr.GetRequests(); len(got) != 1 || got[0].Model != "claude-haiku-4-5" {
+		t.Fatalf("recorded %+v", got)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+	req.Header.Set("Authorization", "Bearer sk-test")
+	w := httptest.NewRecorder()
+	s.handleRequest(w, req)
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list.Data) != 2 || list.Data[0].ID != "fast" || list.Data[1].ID != "claude-opus-4-6" {
+		t.Errorf("models: %s", w.Body)
+	}
+	if key != "sk-test" {
+		t.Errorf("upstream got key %q", key)
+	}
+
+	// Later pages are upstream's alone.
+	req = httptest.NewRequest(http.MethodGet, "/v1/models?after_id=claude-opus-4-6", nil)
+	req.Header.Set("x-api-key", "sk-test")
+	w = httptest.NewRecorder()
+	s.handleRequest(w, req)
+	list.Data = nil
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list.Data) != 1 || list.Data[0].ID != "claude-sonnet-4-6" {
+		t.Errorf("second page: %s", w.Body)
+	}
+
+	// Upstream's refusal reaches the client as it was.
+	w = httptest.NewRecorder()
+	s.handleRequest(w, httptest.NewRequest(http.MethodGet, "/v1/models", nil))
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "authentication_error") {
+		t.Errorf("no key: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestOutputCap(t *testing.T) {
+	var sent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sent = string(body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content":[{"type":"text","text":"ok"}],"usage":{"input_tokens":10,"output_tokens":5}}`))
+	}))
+	defer upstream.Close()
+
+	tr := tracker.New()
+	s := NewServer(0, upstream.URL, 5*time.Second, tr, compress.Config{})
+	s.SetLogger(log.New(io.Discard, "", 0))
+	s.OutputCap = OutputCap{MaxTokens: 8192}
+
+	s.handleRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/messages",
+		strings.NewReader(`{"model":"claude-opus-4-6","max_tokens":64000,"thinking":{"type":"enabled","budget_tokens":32000},"messages":[{"role":"user","content":"hi"}]}`)))
+	if !strings.Contains(sent, `"max_tokens":8192`) || !strings.Contains(sent, `"budget_tokens":8191`) {
+		t.Errorf("upstream got %s", sent)
+	}
+	if got := tr.GetRequests(); len(got) != 1 || got[0].CappedFrom != 64000 {
+		t.Fatalf("recorded %+v", got)
+	}
+
+	sent = ""
+	s.handleRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/messages",
+		strings.NewReader(`{"model":"claude-opus-4-6","max_tokens":1024,"messages":[{"role":"user","content":"hi"}]}`)))
+	if !strings.Contains(sent, `"max_tokens":1024`) {
+		t.Errorf("under the cap: upstream got %s", sent)
+	}
+
+	sent = ""
+	s.OutputCap.Reject = true
+	w := httptest.NewRecorder()
+	s.handleRequest(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions",
+		strings.NewReader(`{"model":"claude-opus-4-6","max_tokens":64000,"messages":[{"role":"user","content":"hi"}]}`)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "over the cap") || sent != "" {
+		t.Errorf("reject: %d %s, upstream got %q", w.Code, w.Body, sent)
+	}
+	if got := tr.GetRecentRequests(1)[0]; got.StatusCode != http.StatusBadRequest || got.CappedFrom != 64000 || !strings.Contains(got.Error, "over the cap") {
+		t.Errorf("reject recorded %+v", got)
+	}
+
+	// A cap under the smallest thinking budget drops thinking.
+	sent = ""
+	s.OutputCap = OutputCap{MaxTokens: 1024}
+	s.handleRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/messages",
+		strings.NewReader(`{"model":"claude-opus-4-6","max_tokens":4096,"thinking":{"type":"enabled","budget_tokens":2048},"messages":[{"role":"user","content":"hi"}]}`)))
+	if !strings.Contains(sent, `"max_tokens":1024`) || strings.Contains(sent, `"thinking"`) {
+		t.Errorf("low cap: upstream got %s", sent)
+	}
+	// miser's own default for a chat request without max_tokens is only
+	// lowered, never refused or noted as capped.
+	sent = ""
+	s.OutputCap = OutputCap{MaxTokens: 1000, Reject: true}
+	w = httptest.NewRecorder()
+	s.handleRequest(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions",
+		strings.NewReader(`{"model":"claude-opus-4-6","messages":[{"role":"user","content":"hi"}]}`)))
+	if w.Code != http.StatusOK || !strings.Contains(sent, `"max_tokens":1000`) {
+		t.Errorf("default max_tokens: %d %s, upstream got %s", w.Code, w.Body, sent)
+	}
+	if got := tr.GetRecentRequests(1)[0]; got.CappedFrom != 0 || got.StatusCode != http.StatusOK {
+		t.Errorf("default max_tokens recorded %+v", got)
+	}
+}
+
+func TestCostGate(t *testing.T) {
+	upstreamHits := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHits++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content":[{"type":"text","text":"ok"}],"usage":{"input_tokens":10,"output_tokens":5}}`))
+	}))
+	defer upstream.Close()
+
+	tr := tracker.New()
+	s := NewServer(0, upstream.URL, 5*time.Second, tr, compress.Config{})
+	s.SetLogger(log.New(io.Discard, "", 0))
+	s.CostGate = CostGate{MaxCost: 1}
+
+	send := func(maxTokens int) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handleRequest(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(
+			fmt.Sprintf(`{"model":"claude-opus-4-6","max_tokens":%d,"messages":[{"role":"user","content":"hi"}]}`, maxTokens))))
+		return w
+	}
+	send(1000)
+	send(64000) // $1.60 of output at $25/MTok
+	got := tr.GetRequests()
+	if upstreamHits != 2 || len(got) != 2 {
+		t.Fatalf("warn: %d upstream hits, recorded %+v", upstreamHits, got)
+	}
+	if got[0].Projected != 0 || got[1].Projected < 1.6 {
+		t.Errorf("warn: projected %g and %g", got[0].Projected, got[1].Projected)
+	}
+
+	s.CostGate.Block = true
+	w := send(64000)
+	if w.Code != http.StatusPaymentRequired || !strings.Contains(w.Body.String(), "projected_cost_exceeded") || upstreamHits != 2 {
+		t.Errorf("block: %d %s, %d upstream hits", w.Code, w.Body, upstreamHits)
+	}
+	got = tr.GetRequests()
+	if len(got) != 3 || got[2].StatusCode != http.StatusPaymentRequired || got[2].Projected == 0 {
+		t.Errorf("block: recorded %+v", got)
+	}
+}
+
+func TestInject(t *testing.T) {
+	s := NewServer(0, "http://unused", time.Second, tracker.New(), compress.Config{})
+	s.SetLogger(log.New(io.Discard, "", 0))
+	s.Inject = []Injection{
+		{Name: "policy", Text: "POLICY", Prepend: true},
+		{Name: "concise", Text: "Be concise.", Routes: []string{RouteChat}},
+		{Name: "opus", Text: "OPUS", Models: []string{"claude-opus-*"}},
+	}
+	tests := []struct {
+		route, body, system string
+		injected            string
+	}{
+		{RouteMessages, `{"model":"claude-haiku-4-5","system":"You help."}`,
+			`"POLICY\n\nYou help."`, "policy"},
+		{RouteChat, `{"model":"claude-opus-4-6"}`,
+			`"POLICY\n\nBe concise.\n\nOPUS"`, "policy,concise,opus"},
+		{RouteMessages, `{"model":"claude-haiku-4-5","system":[{"type":"text","text":"You help.","cache_control":{"type":"ephemeral"}}]}`,
+			`[{"text":"POLICY","type":"text"},{"cache_control":{"type":"ephemeral"},"text":"You help.","type":"text"}]`, "policy"},
+	}
+	for _, tt := range tests {
+		var req struct{ Model string }
+		json.Unmarshal([]byte(tt.body), &req)
+		x := &exchange{model: req.Model, body: parseBody([]byte(tt.body))}
+		s.inject(x, tt.route)
+		var got map[string]json.RawMessage
+		json.Unmarshal(x.body.bytes(), &got)
+		if string(got["system"]) != tt.system || strings.Join(x.injected, ",") != tt.injected {
+			t.Errorf("%s %s: system %s, injected %v", tt.route, tt.body, got["system"], x.injected)
+		}
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	s := NewServer(0, "http://unused", time.Second, tracker.New(), compress.Config{})
+	s.SetLogger(log.New(io.Discard, "", 0))
+	low, high := 0.2, 0.7
+	s.Defaults = map[string]Defaults{
+		"claude-*":        {Temperature: &high, MaxTokens: 1024},
+		"claude-opus-4-6": {Temperature: &low, StopSequences: []string{"<END>"}},
+	}
+
+	x := &exchange{model: "claude-opus-4-6", body: parseBody([]byte(`{"model":"claude-opus-4-6","max_tokens":64}`))}
+	s.applyDefaults(x)
+	var got map[string]any
+	json.Unmarshal(x.body.bytes(), &got)
+	if got["temperature"] != 0.2 || got["max_tokens"] != 64.0 || fmt.Sprint(got["stop_sequences"]) != "[<END>]" {
+		t.Errorf("native: %v", got)
+	}
+
+	req := oaiRequest{Model: "claude-haiku-4-5", Temperature: &low}
+	s.applyOAIDefaults(&req)
+	if *req.Temperature != 0.2 || req.MaxTokens == nil || *req.MaxTokens != 1024 || req.Stop != nil {
+		t.Errorf("chat: %+v", req)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var failures int // left before upstream answers
+	var header http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if failures > 0 {
+			failures--
+			maps.Copy(w.Header(), header)
+			w.WriteHeader(529)
+			w.Write([]byte(`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`))
+			return
+		}
+		w.Write([]byte(`{"content":[{"type":"text","text":"ok"}],"usage":{"input_tokens":10,"output_tokens":5}}`))
+	}))
+	defer upstream.Close()
+
+	for _, tc := range []struct {
+		name     string
+		failures int
+		header   http.Header
+		want     []int // status of each attempt recorded
+	}{
+		{"recovers", 2, nil, []int{529, 529, 200}},
+		{"gives up", 5, nil, []int{529, 529, 529, 529}},
+		{"told not to", 2, http.Header{"X-Should-Retry": {"false"}}, []int{529}},
+		{"told to wait too long", 2, http.Header{"Retry-After": {"120"}}, []int{529}},
+	} {
+		failures, header = tc.failures, tc.header
+		tr := tracker.New()
+		s := NewServer(0, upstream.URL, 5*time.Second, tr, compress.Config{})
+		s.SetLogger(log.New(io.Discard, "", 0))
+		s.Retry = RetryPolicy{Attempts: 3, Backoff: time.Millisecond}
+		w := httptest.NewRecorder()
+		s.handleRequest(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(
+			`{"model":"claude-opus-4-6","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`)))
+		if w.Code != tc.want[len(tc.want)-1] {
+			t.Errorf("%s: answered %d", tc.name, w.Code)
+		}
+		got := tr.GetRequests()
+		if len(got) != len(tc.want) {
+			t.Fatalf("%s: recorded %d attempts, want %d", tc.name, len(got), len(tc.want))
+		}
+		for i, r := range got {
+			last := i == len(got)-1
+			if r.StatusCode != tc.want[i] || r.Retried() == last {
+				t.Errorf("%s: attempt %d: %+v", tc.name, i+1, r)
+			}
+			if len(got) > 1 && (r.Retry == nil || r.Retry.ID != got[0].Retry.ID || r.Retry.Attempt != i+1) {
+				t.Errorf("%s: attempt %d not linked: %+v", tc.name, i+1, r.Retry)
+			}
+		}
+		if got[0].Retried() && got[0].Error != "overloaded_error: Overloaded" {
+			t.Errorf("%s: retried attempt error %q", tc.name, got[0].Error)
+		}
+		if sum := tr.GetSummary(); sum.TotalRetries != len(tc.want)-1 {
+			t.Errorf("%s: summary %+v", tc.name, sum)
+		}
+	}
+}
+
+func TestRetryEndsOnNonJSONError(t *testing.T) {
+	calls := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(529)
+			w.Write([]byte(`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(`<html><body>502 Bad Gateway</body></html>`))
+	}))
+	defer upstream.Close()
+
+	tr := tracker.New()
+	s := NewServer(0, upstream.URL, 5*time.Second, tr, compress.Config{})
+	s.SetLogger(log.New(io.Discard, "", 0))
+	s.Retry = RetryPolicy{Attempts: 1, Backoff: time.Millisecond}
+	w := httptest.NewRecorder()
+	s.handleRequest(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(
+		`{"model":"claude-opus-4-6","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`)))
+	got := tr.GetRequests()
+	if w.Code != http.StatusBadGateway || len(got) != 2 {
+		t.Fatalf("answered %d, recorded %+v", w.Code, got)
+	}
+	last := got[1]
+	if last.StatusCode != http.StatusBadGateway || !last.Failed() || last.Retried() || last.Error != "upstream 502 (non-JSON body)" {
+		t.Errorf("final attempt %+v", last)
+	}
+	if last.Retry == nil || last.Retry.ID != got[0].Retry.ID || !last.Retry.Final {
+		t.Errorf("final attempt doesn't close the chain: %+v", last.Retry)
+	}
+	if sum := tr.GetSummary(); sum.TotalErrors != 1 || sum.TotalRetries != 1 {
+		t.Errorf("summary %+v", sum)
+	}
+}
+
+func TestOverloadFallback(t *testing.T) {
+	var models []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Model string }
+		json.NewDecoder(r.Body).Decode(&req)
+		models = append(models, req.Model)
+		w.Header().Set("Content-Type", "application/json")
+		if req.Model == "claude-opus-4-6" {
+			w.WriteHeader(529)
+			w.Write([]byte(`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`))
+			return
+		}
+		w.Write([]byte(`{"content":[{"type":"text","text":"ok"}],"usage":{"input_tokens":10,"output_tokens":5}}`))
+	}))
+	defer upstream.Close()
+
+	tr := tracker.New()
+	s := NewServer(0, upstream.URL, 5*time.Second, tr, compress.Config{})
+	s.SetLogger(log.New(io.Discard, "", 0))
+	s.Retry = RetryPolicy{Attempts: 1, Backoff: time.Millisecond, Fallback: map[string]string{
+		"claude-*":      "claude-haiku-4-5",
+		"claude-opus-*": "claude-sonnet-4-6",
+	}}
+	w := httptest.NewRecorder()
+	s.handleRequest(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(
+		`{"model":"claude-opus-4-6","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("answered %d", w.Code)
+	}
+	if want := []string{"claude-opus-4-6", "claude-opus-4-6", "claude-sonnet-4-6"}; !slices.Equal(models, want) {
+		t.Errorf("sent %v, want %v", models, want)
+	}
+	got := tr.GetRequests()
+	if len(got) != 3 || !got[1].Retried() || got[1].FallbackFrom != "" {
+		t.Fatalf("recorded %+v", got)
+	}
+	if r := got[2]; r.Model != "claude-sonnet-4-6" || r.FallbackFrom != "claude-opus-4-6" || r.Retry == nil || r.Retry.Attempt != 3 || r.Failed() {
+		t.Errorf("fallback recorded as %+v", r)
+	}
+	if sum := tr.GetSummary(); sum.TotalFallbacks != 1 || sum.TotalRetries != 2 || sum.TotalErrors != 0 {
+		t.Errorf("summary %+v", sum)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	header := "Request-Id"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(header, "req_011CUa")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content":[{"type":"text","text":"ok"}],"usage":{"input_tokens":10,"output_tokens":5}}`))
+	}))
+	defer upstream.Close()
+
+	tr := tracker.New()
+	s := NewServer(0, upstream.URL, 5*time.Second, tr, compress.Config{})
+	for _, path := range []string{"/v1/messages", "/v1/chat/completions"} {
+		s.handleRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, strings.NewReader(
+			`{"model":"claude-opus-4-6","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`)))
+		header = "X-Request-Id" // as some gateways send it
+	}
+	got := tr.GetRequests()
+	if len(got) != 2 {
+		t.Fatalf("recorded %d requests", len(got))
+	}
+	for _, r := range got {
+		if r.RequestID != "req_011CUa" {
+			t.Errorf("request ID %q recorded", r.RequestID)
+		}
+	}
+}
+
+func TestChatUpstreamError(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Request-Id", "req_011CUb")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"type":"error","error":{"type":"invalid_request_error","message":"messages: at least one message is required"}}`))
+	}))
+	defer upstream.Close()
+
+	tr := tracker.New()
+	s := NewServer(0, upstream.URL, 5*time.Second, tr, compress.Config{})
+	s.SetLogger(log.New(io.Discard, "", 0))
+	w := httptest.NewRecorder()
+	s.handleRequest(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(
+		`{"model":"claude-opus-4-6","messages":[]}`)))
+	got := tr.GetRequests()
+	if w.Code != http.StatusBadRequest || len(got) != 1 {
+		t.Fatalf("answered %d, recorded %+v", w.Code, got)
+	}
+	r := got[0]
+	if r.StatusCode != http.StatusBadRequest || r.RequestID != "req_011CUb" || !r.Failed() ||
+		r.Error != "invalid_request_error: messages: at least one message is required" {
+		t.Errorf("recorded %+v", r)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	reset := time.Now().Add(30 * time.Second).UTC().Truncate(time.Second)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Anthropic-Ratelimit-Requests-Limit", "50")
+		h.Set("Anthropic-Ratelimit-Requests-Remaining", "49")
+		h.Set("Anthropic-Ratelimit-Requests-Reset", reset.Format(time.RFC3339))
+		h.Set("Anthropic-Ratelimit-Output-Tokens-Limit", "8000")
+		h.Set("Anthropic-Ratelimit-Output-Tokens-Remaining", "400")
+		h.Set("Anthropic-Ratelimit-Output-Tokens-Reset", reset.Format(time.RFC3339))
+		h.Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content":[{"type":"text","text":"ok"}],"usage":{"input_tokens":10,"output_tokens":5}}`))
+	}))
+	defer upstream.Close()
+
+	tr := tracker.New()
+	s := NewServer(0, upstream.URL, 5*time.Second, tr, compress.Config{})
+	s.handleRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(
+		`{"model":"claude-opus-4-6","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`)))
+	rl, ok := tr.RateLimit()
+	if !ok {
+		t.Fatal("no rate limit recorded")
+	}
+	if rl.Requests != (tracker.Limit{Limit: 50, Remaining: 49, Reset: reset}) || rl.Tokens != (tracker.Limit{}) {
+		t.Errorf("rate limit = %+v", rl)
+	}
+	now := time.Now()
+	if name, l := rl.Tightest(now); name != "output tokens" || l.Headroom(now) != 0.05 {
+		t.Errorf("tightest = %s at %g", name, l.Headroom(now))
+	}
+	if h := rl.OutputTokens.Headroom(reset); h != 1 {
+		t.Errorf("headroom after the reset = %g, want 1", h)
+	}
+	if rateLimit(http.Header{}) != nil {
+		t.Error("rate limit read from a response without the headers")
+	}
+}
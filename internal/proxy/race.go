@@ -0,0 +1,213 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"time"
+
+	"miser/internal/tokens"
+	"miser/internal/tracker"
+)
+
+// Race sends selected requests to a second model as well as their own and
+// relays whichever starts answering first, cancelling the other. Both are
+// billed, so the loser is recorded too, as the cost of racing.
+type Race struct {
+	Model  string   // the model raced against each selected request's own
+	Models []string // race only requests for these models, globs allowed; empty means all
+}
+
+// selects reports whether a request for model is raced.
+func (rc *Race) selects(model string) bool {
+	return model != "" && model != rc.Model && matchModel(rc.Models, model)
+}
+
+// matchModel reports whether model matches any of patterns, or patterns
+// is empty.
+func matchModel(patterns []string, model string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, m := range patterns {
+		if ok, _ := path.Match(m, model); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// racer is one side of a race.
+type racer struct {
+	model  string
+	body   []byte
+	resp   *http.Response
+	err    error
+	cancel context.CancelFunc
+}
+
+// failed reports whether the side lost by failing rather than by time.
+func (r *racer) failed() bool {
+	return r.err != nil || r.resp.StatusCode >= 400
+}
+
+// failure says how a failed side failed.
+func (r *racer) failure() string {
+	if r.err != nil {
+		return r.err.Error()
+	}
+	return r.resp.Status
+}
+
+// release drops a side that is not relayed.
+func (r *racer) release() {
+	if r.err == nil {
+		r.resp.Body.Close()
+	}
+	r.cancel()
+}
+
+// do sends req, whose body is body, upstream: as is, or raced against
+// s.Race.Model. A raced request's winner is the first side to send a byte
+// of a successful response; x.model becomes its model. If both fail, the
+// request's own result is returned. ctx is the inbound request's, without
+// the network trace that only req's own side records.
+func (s *Server) do(ctx context.Context, x *exchange, req *http.Request, body []byte) (*http.Response, error) {
+	if s.Race == nil || !s.Race.selects(x.model) {
+		return s.client.Do(req)
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(body, &m); err != nil {
+		s.debugf("race: request body is not a JSON object (%v); not racing", err)
+		return s.client.Do(req)
+	}
+	m["model"], _ = json.Marshal(s.Race.Model)
+	rivalBody, _ := json.Marshal(m)
+
+	own := &racer{model: x.model, body: body}
+	rival := &racer{model: s.Race.Model, body: rivalBody}
+	ownCtx, cancel := context.WithCancel(req.Context())
+	own.cancel = cancel
+	rivalCtx, cancel := context.WithCancel(ctx)
+	rival.cancel = cancel
+	rivalReq := req.Clone(rivalCtx)
+	rivalReq.Body = io.NopCloser(bytes.NewReader(rivalBody))
+	rivalReq.ContentLength = int64(len(rivalBody))
+
+	start := time.Now()
+	done := make(chan *racer, 2)
+	go s.send(req.WithContext(ownCtx), own, done)
+	go s.send(rivalReq, rival, done)
+
+	if first := <-done; !first.failed() {
+		loser := rival
+		if first == rival {
+			loser = own
+		}
+		loser.cancel()
+		base := x.request()
+		go func() {
+			<-done
+			s.lost(base, loser, own.model, first.model, time.Since(start))
+		}()
+		return s.won(x, first, &tracker.Race{Requested: own.model, Rival: loser.model})
+	}
+
+	// One side failed, which costs nothing, so the other's result is
+	// relayed if it succeeds, else the request's own.
+	<-done
+	if rival.failed() {
+		s.logger.Printf("[RACE] %s failed: %s", rival.model, rival.failure())
+	}
+	if own.failed() && !rival.failed() {
+		own.release()
+		return s.won(x, rival, &tracker.Race{Requested: own.model, Rival: own.model})
+	}
+	rival.release()
+	return s.won(x, own, nil)
+}
+
+// send sends one side's request and waits for the first byte of its body.
+func (s *Server) send(req *http.Request, r *racer, done chan<- *racer) {
+	r.resp, r.err = s.client.Do(req)
+	if r.err == nil && r.resp.StatusCode < 400 {
+		br := bufio.NewReader(r.resp.Body)
+		if _, err := br.Peek(1); err != nil && err != io.EOF {
+			r.resp.Body.Close()
+			r.resp, r.err = nil, err
+		} else {
+			r.resp.Body = readCloser{br, r.resp.Body}
+		}
+	}
+	done <- r
+}
+
+// won hands the winning side back to the request handler; closing its
+// body releases its context.
+func (s *Server) won(x *exchange, r *racer, race *tracker.Race) (*http.Response, error) {
+	if r.err != nil {
+		r.cancel()
+		return nil, r.err
+	}
+	if race != nil {
+		s.debugf("race: %s answered first; cancelled %s", r.model, race.Rival)
+		x.model, x.race = r.model, race
+	}
+	body := r.resp.Body
+	r.resp.Body = readCloser{body, closerFunc(func() error {
+		err := body.Close()
+		r.cancel()
+		return err
+	})}
+	return r.resp, nil
+}
+
+// lost records a cancelled side, with whatever usage it reported before
+// the cancellation reached it or else the local input estimate. A side
+// that failed on its own, with an error response or a transport error
+// rather than our cancellation, is free and recorded without usage.
+func (s *Server) lost(rec tracker.Request, r *racer, requested, winner string, latency time.Duration) {
+	rec.Model, rec.Latency = r.model, latency
+	rec.Prompt, rec.Network = "", tracker.Network{}
+	rec.Race = &tracker.Race{Requested: requested, Rival: winner, Lost: true}
+	var u usage
+	if r.err == nil {
+		rec.StatusCode = r.resp.StatusCode
+		rec.RequestID = requestID(r.resp.Header)
+		rec.RateLimit = rateLimit(r.resp.Header)
+		var tap bodyTap
+		io.Copy(&tap, r.resp.Body)
+		r.resp.Body.Close()
+		if r.failed() {
+			s.Tracker.Record(rec)
+			return
+		}
+		u, _ = tap.usage()
+	} else if !errors.Is(r.err, context.Canceled) {
+		rec.Error = r.err.Error()
+		s.Tracker.Record(rec)
+		return
+	}
+	if u.InputTokens+u.CacheReadInputTokens+u.CacheCreationInputTokens == 0 {
+		u = usage{}
+		u.InputTokens, _ = tokens.Request(r.body)
+	}
+	rec.InputTokens, rec.OutputTokens = u.InputTokens, u.OutputTokens
+	rec.CacheRead, rec.CacheWrite = u.CacheReadInputTokens, u.CacheCreationInputTokens
+	rec.Cost = tracker.CalculateCost(rec.Model, rec.InputTokens, rec.OutputTokens, rec.CacheRead, rec.CacheWrite)
+	s.Tracker.Record(rec)
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"miser/internal/tracker"
+)
+
+// rateLimit reads the anthropic-ratelimit-* headers of a response, or
+// returns nil if it has none, as from an upstream other than Anthropic.
+func rateLimit(h http.Header) *tracker.RateLimit {
+	var rl tracker.RateLimit
+	found := false
+	limit := func(name string) tracker.Limit {
+		prefix := "Anthropic-Ratelimit-" + name + "-"
+		var l tracker.Limit
+		n, err := strconv.Atoi(h.Get(prefix + "Limit"))
+		if err != nil {
+			return l
+		}
+		l.Limit = n
+		l.Remaining, _ = strconv.Atoi(h.Get(prefix + "Remaining"))
+		l.Reset, _ = time.Parse(time.RFC3339, h.Get(prefix+"Reset"))
+		found = true
+		return l
+	}
+	rl.Requests = limit("Requests")
+	rl.Tokens = limit("Tokens")
+	rl.InputTokens = limit("Input-Tokens")
+	rl.OutputTokens = limit("Output-Tokens")
+	if !found {
+		return nil
+	}
+	return &rl
+}
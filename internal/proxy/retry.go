@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"time"
+
+	"miser/internal/tracker"
+)
+
+// RetryPolicy resends a model request that failed before any of its
+// response was relayed: upstream couldn't be reached, or answered 429,
+// 529 or another 5xx. A request still overloaded after its retries can be
+// sent once more, to its Fallback model. The zero value never retries.
+type RetryPolicy struct {
+	Attempts int           // retries after the first try
+	Backoff  time.Duration // before the first retry, doubling after each
+
+	// Fallback maps model globs to the model to send a request for one
+	// to when upstream stays overloaded; the longest matching glob wins.
+	Fallback map[string]string
+}
+
+// maxRetryWait is the longest Retry-After that is waited out. A request
+// told to wait longer is answered with the error, for the client to decide.
+const maxRetryWait = 30 * time.Second
+
+// retry sends req with do, resending it while it fails in a way worth
+// retrying, then, if it is still overloaded, for its fallback model. Each
+// failed attempt is recorded as it fails, with what its error cost,
+// usually nothing; the last is returned to be relayed and recorded with
+// x.retry.
+func (s *Server) retry(ctx context.Context, x *exchange, req *http.Request, body []byte) (*http.Response, error) {
+	wait := s.Retry.Backoff
+	var id string
+	for attempt, tries := 1, 0; ; attempt, tries = attempt+1, tries+1 {
+		start := time.Now()
+		resp, err := s.do(ctx, x, req, body)
+		d, ok := retriable(ctx, resp, err, wait)
+		var to string
+		if !ok || tries >= s.Retry.Attempts {
+			if to = s.fallback(ctx, x, resp, err); to == "" {
+				if id != "" {
+					x.retry = &tracker.Retry{ID: id, Attempt: attempt, Final: true}
+				}
+				return resp, err
+			}
+			d = 0
+		}
+		if id == "" {
+			id = retryID()
+		}
+		rec := x.request()
+		rec.Timestamp, rec.Latency = start, time.Since(start)
+		rec.Retry = &tracker.Retry{ID: id, Attempt: attempt}
+		if err != nil {
+			rec.Error = err.Error()
+			s.unreachable(ctx, s.target(), err)
+		} else {
+			s.attemptFailed(&rec, resp)
+		}
+		// Not s.record: response hooks, the token check and the shadow are
+		// for the answer the client gets, and the last attempt carries the
+		// preview.
+		rec.Prompt = ""
+		s.Tracker.Record(rec)
+		if to != "" {
+			s.logger.Printf("[FALLBACK] %s overloaded (%s); sending to %s", x.model, rec.Error, to)
+			if body, err = withModel(body, to); err != nil {
+				return nil, err
+			}
+			x.fallbackFrom, x.model = x.model, to
+			tries, wait = -1, s.Retry.Backoff // the fallback model gets its own retries
+		} else {
+			s.logger.Printf("[RETRY] %s failed (%s); retry %d of %d in %s",
+				x.model, rec.Error, tries+1, s.Retry.Attempts, d)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(d):
+		}
+		if to == "" {
+			wait *= 2
+		}
+		next := req.Clone(req.Context())
+		next.Body, next.ContentLength = io.NopCloser(bytes.NewReader(body)), int64(len(body))
+		req = next
+	}
+}
+
+// fallback returns the model to send a request that came back as resp or
+// err to instead, or "" for none: only an overloaded request is, once.
+func (s *Server) fallback(ctx context.Context, x *exchange, resp *http.Response, err error) string {
+	if err != nil || ctx.Err() != nil || resp.StatusCode != statusOverloaded || x.fallbackFrom != "" {
+		return ""
+	}
+	var patterns []string
+	for p := range s.Retry.Fallback {
+		if m, _ := path.Match(p, x.model); m {
+			patterns = append(patterns, p)
+		}
+	}
+	if len(patterns) == 0 {
+		return ""
+	}
+	p := slices.MaxFunc(patterns, func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(a), len(b)), cmp.Compare(b, a))
+	})
+	if to := s.Retry.Fallback[p]; to != x.model {
+		return to
+	}
+	return ""
+}
+
+// statusOverloaded is Anthropic's status for a model with no capacity to
+// spare.
+const statusOverloaded = 529
+
+// withModel returns body, a Messages request, asking for model instead.
+func withModel(body []byte, model string) ([]byte, error) {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("fallback: request body is not a JSON object: %w", err)
+	}
+	m["model"], _ = json.Marshal(model)
+	return json.Marshal(m)
+}
+
+// retriable reports whether a try that ended with resp or err is worth
+// sending again, and after how long: the Retry-After upstream asked for,
+// else wait.
+func retriable(ctx context.Context, resp *http.Response, err error, wait time.Duration) (time.Duration, bool) {
+	if ctx.Err() != nil {
+		return 0, false
+	}
+	if err != nil {
+		return wait, true
+	}
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 ||
+		resp.Header.Get("X-Should-Retry") == "false" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs >= 0 {
+		d := time.Duration(secs) * time.Second
+		return d, d <= maxRetryWait
+	}
+	return wait, true
+}
+
+// attemptFailed fills in rec from the error response of a failed attempt,
+// and closes it.
+func (s *Server) attemptFailed(rec *tracker.Request, resp *http.Response) {
+	defer resp.Body.Close()
+	rec.StatusCode = resp.StatusCode
+	rec.RequestID = requestID(resp.Header)
+	rec.RateLimit = rateLimit(resp.Header)
+	rec.Error = fmt.Sprintf("upstream %d", resp.StatusCode)
+	var e struct {
+		Error streamError `json:"error"`
+		Usage usage       `json:"usage"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(body, &e) != nil {
+		return
+	}
+	if e.Error != (streamError{}) {
+		rec.Error = e.Error.String()
+	}
+	rec.InputTokens, rec.OutputTokens = e.Usage.InputTokens, e.Usage.OutputTokens
+	rec.CacheRead, rec.CacheWrite = e.Usage.CacheReadInputTokens, e.Usage.CacheCreationInputTokens
+	rec.Cost = tracker.CalculateCost(rec.Model, rec.InputTokens, rec.OutputTokens, rec.CacheRead, rec.CacheWrite)
+}
+
+// retryID links the attempts of one request.
+func retryID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
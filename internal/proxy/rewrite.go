@@ -0,0 +1,74 @@
+package proxy
+
+import "encoding/json"
+
+// reqBody is a model request's body, parsed once so that each rewrite
+// before it is sent — alias, defaults, output cap, injection — edits its
+// fields in place, and encoded again only when it is needed as bytes.
+type reqBody struct {
+	raw    []byte
+	fields map[string]json.RawMessage // nil when raw isn't a JSON object
+	dirty  bool                       // fields changed since raw
+}
+
+func parseBody(raw []byte) *reqBody {
+	b := &reqBody{raw: raw}
+	if json.Unmarshal(raw, &b.fields) != nil {
+		b.fields = nil
+	}
+	return b
+}
+
+// ok reports whether the body is a JSON object, which the rewrites can
+// edit; they leave any other body alone.
+func (b *reqBody) ok() bool {
+	return b.fields != nil
+}
+
+// get returns a field's JSON, nil when it is absent.
+func (b *reqBody) get(field string) json.RawMessage {
+	return b.fields[field]
+}
+
+func (b *reqBody) has(field string) bool {
+	_, ok := b.fields[field]
+	return ok
+}
+
+// set replaces a field with v, encoded.
+func (b *reqBody) set(field string, v any) {
+	if b.fields == nil {
+		return
+	}
+	if raw, err := json.Marshal(v); err == nil {
+		b.fields[field] = raw
+		b.dirty = true
+	}
+}
+
+// setRaw replaces a field with JSON as it is.
+func (b *reqBody) setRaw(field string, raw json.RawMessage) {
+	if b.fields == nil {
+		return
+	}
+	b.fields[field] = raw
+	b.dirty = true
+}
+
+func (b *reqBody) del(field string) {
+	if _, ok := b.fields[field]; ok {
+		delete(b.fields, field)
+		b.dirty = true
+	}
+}
+
+// bytes returns the body as the rewrites so far left it.
+func (b *reqBody) bytes() []byte {
+	if b.dirty {
+		if out, err := json.Marshal(b.fields); err == nil {
+			b.raw = out
+		}
+		b.dirty = false
+	}
+	return b.raw
+}
@@ -0,0 +1,26 @@
+package proxy
+
+import "testing"
+
+func TestReqBody(t *testing.T) {
+	// Untouched, a body is sent byte for byte, key order and spacing kept.
+	raw := `{"model": "m", "max_tokens": 10}`
+	if b := parseBody([]byte(raw)); string(b.bytes()) != raw {
+		t.Errorf("untouched body became %s", b.bytes())
+	}
+
+	b := parseBody([]byte(raw))
+	b.set("max_tokens", 5)
+	b.set("system", "be brief")
+	b.del("model")
+	if got := string(b.bytes()); got != `{"max_tokens":5,"system":"be brief"}` {
+		t.Errorf("rewritten body = %s", got)
+	}
+
+	// A body that isn't an object is left alone.
+	b = parseBody([]byte(`[1]`))
+	b.set("model", "m")
+	if b.ok() || string(b.bytes()) != `[1]` {
+		t.Errorf("non-object body became %s", b.bytes())
+	}
+}
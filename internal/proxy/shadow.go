@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"miser/internal/tracker"
+)
+
+// maxShadows bounds the shadow requests in flight; past it requests are
+// not mirrored, so a slow shadow upstream can't pile up.
+const maxShadows = 8
+
+// Shadow duplicates selected requests to a second upstream or model, to
+// try it on real traffic. The client only ever gets the real response;
+// the shadow one is discarded and its request recorded in Tracker with
+// ShadowOf set, apart from the real spend, budgets and limits.
+type Shadow struct {
+	Target  string           // "" sends shadows to the proxy's target
+	Model   string           // the model shadows ask for; "" keeps the request's
+	Models  []string         // mirror only requests for these models, globs allowed; empty means all
+	Sample  float64          // the fraction of those mirrored, 0 to 1
+	Keep    bool             // keep both responses, as captured, to compare them
+	Tracker *tracker.Tracker // where shadow requests are recorded
+
+	inflight atomic.Int32
+}
+
+// selects reports whether a request for model is mirrored this time.
+func (sh *Shadow) selects(model string) bool {
+	if !matchModel(sh.Models, model) {
+		return false
+	}
+	return sh.Sample >= 1 || rand.Float64() < sh.Sample
+}
+
+// mirror sends a shadow of the Anthropic request body, with the headers
+// that go upstream, if the request is selected. The shadow is recorded
+// once x.endShadow says the real request is done.
+func (s *Server) mirror(ctx context.Context, x *exchange, h http.Header, body []byte) {
+	sh := s.Shadow
+	if sh == nil || sh.Tracker == nil || !sh.selects(x.model) {
+		return
+	}
+	if sh.inflight.Add(1) > maxShadows {
+		sh.inflight.Add(-1)
+		s.debugf("shadow: %d shadow requests in flight; not mirroring this one", maxShadows)
+		return
+	}
+	var req map[string]json.RawMessage
+	if err := json.Unmarshal(body, &req); err != nil {
+		sh.inflight.Add(-1)
+		s.debugf("shadow: request body is not a JSON object (%v); not mirroring", err)
+		return
+	}
+	model := cmp.Or(sh.Model, x.model)
+	req["model"], _ = json.Marshal(model)
+	delete(req, "stream") // the shadow response is only read for usage
+	body, _ = json.Marshal(req)
+
+	x.shadow = make(chan tracker.Request, 1)
+	go func(real <-chan tracker.Request) {
+		defer sh.inflight.Add(-1)
+		rec := s.sendShadow(context.WithoutCancel(ctx), h.Clone(), model, body)
+		rec.Latency = time.Since(rec.Timestamp)
+		rec.Project, rec.Client, rec.Key = x.project, x.client, x.key
+		if !sh.Keep {
+			rec.Response = ""
+		}
+		if r, ok := <-real; ok {
+			rec.ShadowOf = &tracker.Shadowed{
+				Model:        r.Model,
+				InputTokens:  r.InputTokens,
+				OutputTokens: r.OutputTokens,
+				Cost:         r.Cost,
+				Latency:      r.Latency,
+				StatusCode:   r.StatusCode,
+				Error:        r.Error,
+			}
+			if sh.Keep {
+				rec.ShadowOf.Response = r.Response
+			}
+		}
+		sh.Tracker.Record(rec)
+	}(x.shadow)
+}
+
+// sendShadow sends a shadow request and records how it went.
+func (s *Server) sendShadow(ctx context.Context, h http.Header, model string, body []byte) tracker.Request {
+	rec := tracker.Request{Timestamp: time.Now(), Model: model}
+	target := cmp.Or(s.Shadow.Target, s.target()) + "/v1/messages"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		rec.Error = err.Error()
+		return rec
+	}
+	req.Header = h
+	req.Header.Del("Content-Length")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.debugf("shadow: %v", err)
+		rec.Error = err.Error()
+		return rec
+	}
+	defer resp.Body.Close()
+	rec.StatusCode = resp.StatusCode
+	rec.RequestID = requestID(resp.Header)
+	rec.RateLimit = rateLimit(resp.Header)
+
+	var tap bodyTap
+	if _, err := io.Copy(&tap, resp.Body); err != nil {
+		rec.Error = err.Error()
+		return rec
+	}
+	u, err := tap.usage()
+	if err != nil {
+		s.debugf("shadow: response has no usage (%v)", err)
+		return rec
+	}
+	rec.InputTokens = u.InputTokens
+	rec.OutputTokens = u.OutputTokens
+	rec.CacheRead = u.CacheReadInputTokens
+	rec.CacheWrite = u.CacheCreationInputTokens
+	rec.Cost = tracker.CalculateCost(model, rec.InputTokens, rec.OutputTokens, rec.CacheRead, rec.CacheWrite)
+	if whole, ok := tap.whole(); ok {
+		rec.Response = s.Capture.Response(whole)
+	}
+	return rec
+}
+
+// endShadow hands the real request, if it was recorded, to its shadow.
+func (x *exchange) endShadow() {
+	if x.shadow != nil {
+		close(x.shadow)
+	}
+}
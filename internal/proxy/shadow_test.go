@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"miser/internal/compress"
+	"miser/internal/tracker"
+)
+
+func TestShadowMirrorsToAnotherModel(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req struct {
+			Model  string `json:"model"`
+			Stream bool   `json:"stream"`
+		}
+		json.Unmarshal(body, &req)
+		w.Header().Set("Content-Type", "application/json")
+		if req.Model == "claude-haiku-4-5" {
+			if req.Stream {
+				t.Error("shadow request asked for a stream")
+			}
+			w.Write([]byte(`{"content":[{"type":"text","text":"cheap"}],"usage":{"input_tokens":100,"output_tokens":5}}`))
+			return
+		}
+		w.Write([]byte(`{"content":[{"type":"text","text":"real"}],"usage":{"input_tokens":100,"output_tokens":20}}`))
+	}))
+	defer upstream.Close()
+
+	tr, shadows := tracker.New(), tracker.New()
+	s := NewServer(0, upstream.URL, time.Second, tr, compress.Config{})
+	s.Shadow = &Shadow{Model: "claude-haiku-4-5", Models: []string{"claude-sonnet-*"}, Sample: 1, Tracker: shadows}
+	for _, model := range []string{"claude-sonnet-4-6", "claude-opus-4-6"} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"`+model+`","max_tokens":10}`))
+		rec := httptest.NewRecorder()
+		s.handleRequest(rec, req)
+		if !strings.Contains(rec.Body.String(), "real") {
+			t.Errorf("client got %s", rec.Body)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for shadows.Count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := tr.Count(); n != 2 {
+		t.Errorf("%d real requests recorded, want 2", n)
+	}
+	got := shadows.GetRequests()
+	if len(got) != 1 {
+		t.Fatalf("%d shadow requests, want 1 (only sonnet is selected)", len(got))
+	}
+	sh := got[0]
+	if sh.Model != "claude-haiku-4-5" || sh.OutputTokens != 5 || sh.ShadowOf == nil ||
+		sh.ShadowOf.Model != "claude-sonnet-4-6" || sh.ShadowOf.OutputTokens != 20 {
+		t.Errorf("shadow = %+v, of %+v", sh, sh.ShadowOf)
+	}
+	if sh.Cost == 0 || sh.Cost >= sh.ShadowOf.Cost {
+		t.Errorf("shadow cost %g, real cost %g", sh.Cost, sh.ShadowOf.Cost)
+	}
+	if st := shadows.GetShadowStatsSince(time.Time{}); len(st) != 1 || st[0].TotalRequests != 1 || st[0].Real.TotalOutput != 20 {
+		t.Errorf("shadow stats = %+v", st)
+	}
+}
+
+func TestShadowAfterRetry(t *testing.T) {
+	failures := 1
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Model string }
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case req.Model == "claude-haiku-4-5":
+			w.Write([]byte(`{"content":[{"type":"text","text":"cheap"}],"usage":{"input_tokens":100,"output_tokens":5}}`))
+		case failures > 0:
+			failures--
+			w.WriteHeader(529)
+			w.Write([]byte(`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`))
+		default:
+			w.Write([]byte(`{"content":[{"type":"text","text":"real"}],"usage":{"input_tokens":100,"output_tokens":20}}`))
+		}
+	}))
+	defer upstream.Close()
+
+	tr, shadows := tracker.New(), tracker.New()
+	s := NewServer(0, upstream.URL, time.Second, tr, compress.Config{})
+	s.SetLogger(log.New(io.Discard, "", 0))
+	s.Retry = RetryPolicy{Attempts: 2, Backoff: time.Millisecond}
+	s.Shadow = &Shadow{Model: "claude-haiku-4-5", Sample: 1, Tracker: shadows}
+	rec := httptest.NewRecorder()
+	s.handleRequest(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-6","max_tokens":10}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("client got %d", rec.Code)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for shadows.Count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := tr.Count(); n != 2 {
+		t.Errorf("%d real requests recorded, want the failed attempt and the answer", n)
+	}
+	got := shadows.GetRequests()
+	if len(got) != 1 || got[0].ShadowOf == nil {
+		t.Fatalf("shadows = %+v", got)
+	}
+	if of := got[0].ShadowOf; of.StatusCode != http.StatusOK || of.OutputTokens != 20 {
+		t.Errorf("shadow paired with %+v, want the answer", of)
+	}
+}
@@ -0,0 +1,389 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"miser/internal/capture"
+	"miser/internal/tokens"
+)
+
+// relayBufferSize is the read size of the streaming relay: big enough for
+// many events per read, small enough to forward each promptly.
+const relayBufferSize = 32 << 10
+
+// eventStream follows an Anthropic event stream as it is relayed, keeping
+// the usage and, with capture on, the response text. It is the write side
+// of a TeeReader: lines are split in place in the relayed bytes, and only
+// one cut across two reads, and the data of an event that is parsed, are
+// copied — neither, whatever its length, for an event that isn't.
+type eventStream struct {
+	debugf   func(string, ...any)
+	captured *capture.Buffer
+	wantText bool // parse text deltas for capture
+
+	inputTokens, outputTokens, cacheRead, cacheWrite int
+	err                                              string // from an error event, which ends the stream
+
+	// For estimateUsage: whether the events with the input and output
+	// counts came, and the output seen, as tokens of the deltas decoded
+	// and bytes of those that weren't; for toolTokens, the same for the
+	// deltas of tool_use blocks.
+	started, finished bool
+	deltaTokens       int
+	deltaBytes        int
+	toolTokens        int
+	toolBytes         int
+	inTool            bool // the current content block is a tool call
+
+	event    []byte // name from the event's "event:" line
+	pending  []byte // the event's data so far; see sseData
+	partial  []byte // start of a line the last write cut off
+	dropping bool   // the cut-off line is data of an ignored event and isn't kept
+}
+
+func newEventStream(s *Server) *eventStream {
+	return &eventStream{
+		debugf:   s.debugf,
+		captured: capture.NewBuffer(s.Capture),
+		wantText: s.Capture.Enabled,
+	}
+}
+
+func (e *eventStream) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			e.hold(p)
+			break
+		}
+		line := p[:i]
+		p = p[i+1:]
+		if len(e.partial) == 0 && !e.dropping {
+			e.line(line)
+			continue
+		}
+		e.hold(line)
+		if !e.dropping {
+			e.line(e.partial)
+		}
+		e.partial, e.dropping = e.partial[:0], false
+	}
+	return n, nil
+}
+
+// hold keeps the start of a line until its end arrives. A line of an
+// ignored event, such as a multi-megabyte tool input delta, is not kept.
+func (e *eventStream) hold(p []byte) {
+	if e.dropping {
+		e.skipped(len(p))
+		return
+	}
+	e.partial = append(e.partial, p...)
+	if e.ignored() && bytes.HasPrefix(e.partial, []byte("data:")) {
+		e.skipped(len(e.partial) - len("data:") - deltaOverhead)
+		e.partial, e.dropping = e.partial[:0], true
+	}
+}
+
+// close parses a last line, and event, the stream didn't end.
+func (e *eventStream) close() {
+	if len(e.partial) > 0 && !e.dropping {
+		e.line(e.partial)
+	}
+	e.partial = e.partial[:0]
+	e.dispatch()
+}
+
+func (e *eventStream) line(l []byte) {
+	l = bytes.TrimSuffix(l, []byte("\r"))
+	switch {
+	case len(l) == 0:
+		e.dispatch()
+		e.event = e.event[:0]
+	case bytes.HasPrefix(l, []byte("event:")):
+		e.dispatch()
+		e.event = append(e.event[:0], bytes.TrimSpace(l[len("event:"):])...)
+	case bytes.HasPrefix(l, []byte("data:")):
+		data := sseData(l)
+		if e.ignored() {
+			e.skipped(len(data) - deltaOverhead)
+			return
+		}
+		if ended(e.pending) {
+			e.dispatch()
+		}
+		e.pending = append(e.pending, data...)
+	}
+}
+
+// dispatch parses the data of the event that just ended.
+func (e *eventStream) dispatch() {
+	if len(e.pending) > 0 {
+		e.data(e.pending)
+		e.pending = e.pending[:0]
+	}
+}
+
+// ended reports whether data already holds a whole event, which a data
+// line that follows with no blank line between must not be joined to.
+func ended(data []byte) bool {
+	return len(data) > 0 && json.Valid(data)
+}
+
+// sseData returns the payload of a data line. An event's payload may be
+// split over several data lines, which the SSE standard joins with
+// newlines; for JSON, where a newline can only be whitespace between
+// tokens, joining them with nothing is the same, and also mends a line an
+// intermediary broke in two.
+func sseData(line []byte) []byte {
+	data := line[len("data:"):]
+	if len(data) > 0 && data[0] == ' ' {
+		data = data[1:]
+	}
+	return data
+}
+
+// ignored reports whether the current event carries nothing the stream is
+// parsed for. The event name says so, so the bulk of a stream — deltas,
+// when nothing is captured — is never decoded or held.
+func (e *eventStream) ignored() bool {
+	switch string(e.event) {
+	case "ping", "content_block_stop", "message_stop":
+		return true
+	case "content_block_delta":
+		return !e.wantText
+	}
+	return false
+}
+
+func (e *eventStream) data(data []byte) {
+	if bytes.Equal(data, []byte("[DONE]")) {
+		return
+	}
+
+	var event struct {
+		Type    string `json:"type"`
+		Message struct {
+			Usage struct {
+				InputTokens              int `json:"input_tokens"`
+				CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+				CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+			} `json:"usage"`
+		} `json:"message"`
+		ContentBlock struct {
+			Type string `json:"type"`
+		} `json:"content_block"`
+		Delta struct {
+			Type        string `json:"type"`
+			Text        string `json:"text"`
+			Thinking    string `json:"thinking"`
+			PartialJSON string `json:"partial_json"`
+		} `json:"delta"`
+		Usage struct {
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+		Error streamError `json:"error"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil {
+		e.debugf("SSE: skipping unparseable event (%v): %.200s", err, data)
+		return
+	}
+	switch event.Type {
+	case "error":
+		e.err = event.Error.String()
+	case "message_start":
+		e.started = true
+		e.inputTokens = event.Message.Usage.InputTokens
+		e.cacheRead = event.Message.Usage.CacheReadInputTokens
+		e.cacheWrite = event.Message.Usage.CacheCreationInputTokens
+	case "content_block_start":
+		e.inTool = strings.HasSuffix(event.ContentBlock.Type, "tool_use") // also server_tool_use
+	case "content_block_delta":
+		if event.Delta.Type == "text_delta" {
+			e.captured.WriteString(event.Delta.Text)
+		}
+		e.toolTokens += tokens.Text(event.Delta.PartialJSON)
+		e.deltaTokens += tokens.Text(event.Delta.Text) + tokens.Text(event.Delta.Thinking) + tokens.Text(event.Delta.PartialJSON)
+	case "message_delta":
+		e.finished = true
+		e.outputTokens = event.Usage.OutputTokens
+	}
+}
+
+// deltaOverhead is the JSON around the text of a content_block_delta.
+const deltaOverhead = len(`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":""}}`)
+
+// skipped counts n bytes of text in an event's data that was not decoded,
+// if the event is a delta.
+func (e *eventStream) skipped(n int) {
+	if string(e.event) == "content_block_delta" {
+		e.deltaBytes += max(n, 0)
+		if e.inTool {
+			e.toolBytes += max(n, 0)
+		}
+	}
+}
+
+// outputEstimate sizes the output relayed so far.
+func (e *eventStream) outputEstimate() int {
+	return e.deltaTokens + e.deltaBytes/bytesPerToken
+}
+
+// toolEstimate sizes the part of it that was tool call arguments.
+func (e *eventStream) toolEstimate() int {
+	return e.toolTokens + e.toolBytes/bytesPerToken
+}
+
+// streamError is the error of an Anthropic error event, sent in place of
+// the rest of a stream that fails after it started, e.g. when overloaded.
+type streamError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// String is how a failed stream is recorded, e.g. "overloaded_error:
+// Overloaded".
+func (e streamError) String() string {
+	switch {
+	case e.Type == "":
+		return "stream_error: " + e.Message
+	case e.Message == "":
+		return e.Type
+	}
+	return e.Type + ": " + e.Message
+}
+
+// clientWriter writes a response to the client, flushing every write if
+// flush is set, so each read from upstream reaches it at once. A client
+// that takes no write within the stall timeout is given up on; after a
+// failed write every later one fails too.
+type clientWriter struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	timeout time.Duration
+	flush   bool
+	err     error
+}
+
+func (s *Server) clientWriter(w http.ResponseWriter, flush bool) *clientWriter {
+	return &clientWriter{w: w, rc: http.NewResponseController(w), timeout: s.StallTimeout, flush: flush}
+}
+
+func (cw *clientWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	if cw.timeout > 0 {
+		cw.rc.SetWriteDeadline(time.Now().Add(cw.timeout)) // unsupported without a real connection
+	}
+	n, err := cw.w.Write(p)
+	if err == nil && cw.flush {
+		err = cw.rc.Flush()
+	}
+	cw.err = err
+	return n, err
+}
+
+// stalled reports whether the client stopped reading.
+func (cw *clientWriter) stalled() bool {
+	return errors.Is(cw.err, os.ErrDeadlineExceeded)
+}
+
+// done clears the deadline so it can't fire on the connection's next
+// request.
+func (cw *clientWriter) done() {
+	if cw.timeout > 0 && cw.err == nil {
+		cw.rc.SetWriteDeadline(time.Time{})
+	}
+}
+
+// lineReader reads lines of any length, unlike bufio.Scanner, which gives
+// up on one longer than its buffer.
+type lineReader struct {
+	r    *bufio.Reader
+	long []byte // assembles a line longer than r's buffer
+}
+
+func newLineReader(r io.Reader) *lineReader {
+	return &lineReader{r: bufio.NewReaderSize(r, relayBufferSize)}
+}
+
+// next returns the next line without its line ending, valid until the
+// following call, or io.EOF after the last.
+func (l *lineReader) next() ([]byte, error) {
+	line, err := l.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		l.long = append(l.long[:0], line...)
+		for errors.Is(err, bufio.ErrBufferFull) {
+			line, err = l.r.ReadSlice('\n')
+			l.long = append(l.long, line...)
+		}
+		line = l.long
+	}
+	if len(line) > 0 && err == io.EOF {
+		err = nil // return the unterminated last line first
+	}
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	return bytes.TrimSuffix(line, []byte("\r")), err
+}
+
+// sseReader reads the events of a stream, assembling each one's data from
+// its data lines. An event ends at a blank line, as the standard has it,
+// or at an "event:" line or a data line after a whole JSON value, which
+// some upstreams send without one.
+type sseReader struct {
+	lines *lineReader
+	data  []byte
+	carry []byte // a data line that began the next event
+	err   error
+}
+
+func newSSEReader(r io.Reader) *sseReader {
+	return &sseReader{lines: newLineReader(r)}
+}
+
+// next returns the data of the next event with any, valid until the
+// following call, or the error that ended the stream after the last.
+func (r *sseReader) next() ([]byte, error) {
+	r.data = append(r.data[:0], r.carry...)
+	r.carry = r.carry[:0]
+	for r.err == nil {
+		var line []byte
+		line, r.err = r.lines.next()
+		switch {
+		case r.err != nil:
+		case len(line) == 0, bytes.HasPrefix(line, []byte("event:")):
+			if len(r.data) > 0 {
+				return r.data, nil
+			}
+		case bytes.HasPrefix(line, []byte("data:")):
+			if ended(r.data) {
+				r.carry = append(r.carry, sseData(line)...)
+				return r.data, nil
+			}
+			r.data = append(r.data, sseData(line)...)
+		}
+	}
+	if len(r.data) > 0 {
+		return r.data, nil
+	}
+	return nil, r.err
+}
+
+// relay copies an upstream stream to the client through buf, feeding the
+// bytes to parse as they pass. It stops at the first failed write. The
+// stream is never re-encoded: strict SSE clients get upstream's framing,
+// fields and comments byte for byte.
+func relay(cw *clientWriter, body io.Reader, parse io.Writer, buf []byte) error {
+	_, err := io.CopyBuffer(cw, io.TeeReader(body, parse), buf)
+	return err
+}
@@ -0,0 +1,439 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"miser/internal/capture"
+	"miser/internal/compress"
+	"miser/internal/tracker"
+)
+
+const testStream = "event: message_start\r\n" +
+	`data: {"type":"message_start","message":{"usage":{"input_tokens":12,"cache_read_input_tokens":3,"cache_creation_input_tokens":4}}}` + "\r\n\r\n" +
+	"event: ping\ndata: {\"type\": \"ping\"}\n\n" +
+	"event: content_block_delta\n" +
+	`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}` + "\n\n" +
+	"event: content_block_delta\n" +
+	`data:{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":", world"}}` + "\n\n" +
+	"event: message_delta\n" +
+	`data: {"type":"message_delta","usage":{"output_tokens":7}}` + "\n\n" +
+	"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+
+func TestRelayParsesWhilePassingThrough(t *testing.T) {
+	s := NewServer(0, "", time.Second, tracker.New(), compress.Config{})
+	s.Capture = capture.Config{Enabled: true}
+
+	// One byte per read splits every line across writes to the parser.
+	for _, oneByte := range []bool{false, true} {
+		body := strings.NewReader(testStream)
+		rec := httptest.NewRecorder()
+		ev := newEventStream(s)
+		var err error
+		if oneByte {
+			err = relay(s.clientWriter(rec, true), iotest.OneByteReader(body), ev, make([]byte, 16))
+		} else {
+			err = relay(s.clientWriter(rec, true), body, ev, make([]byte, relayBufferSize))
+		}
+		ev.close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rec.Body.String(); got != testStream {
+			t.Errorf("oneByte=%v: relayed stream altered:\n%q", oneByte, got)
+		}
+		if ev.inputTokens != 12 || ev.cacheRead != 3 || ev.cacheWrite != 4 || ev.outputTokens != 7 {
+			t.Errorf("oneByte=%v: usage = %+v", oneByte, *ev)
+		}
+		if got := ev.captured.Text(); got != "Hello, world" {
+			t.Errorf("oneByte=%v: captured %q", oneByte, got)
+		}
+	}
+}
+
+func TestRelayLongLines(t *testing.T) {
+	s := NewServer(0, "", time.Second, tracker.New(), compress.Config{})
+	s.Capture = capture.Config{Enabled: true, MaxBytes: 16}
+	long := strings.Repeat("x", 3<<20)
+	stream := "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"" + long + "\"}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"" + long + "\"}}\n\n" +
+		"event: message_delta\ndata: {\"type\":\"message_delta\",\"usage\":{\"output_tokens\":9}}\n\n"
+
+	for _, capturing := range []bool{false, true} {
+		s.Capture.Enabled = capturing
+		rec := httptest.NewRecorder()
+		ev := newEventStream(s)
+		if err := relay(s.clientWriter(rec, true), strings.NewReader(stream), ev, make([]byte, relayBufferSize)); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Body.Len() != len(stream) {
+			t.Errorf("capturing=%v: relayed %d bytes, want %d", capturing, rec.Body.Len(), len(stream))
+		}
+		if ev.outputTokens != 9 {
+			t.Errorf("capturing=%v: usage after long lines lost: %d", capturing, ev.outputTokens)
+		}
+		if got := ev.captured.Text(); capturing != strings.HasPrefix(got, "xxxx") {
+			t.Errorf("capturing=%v: captured %.20q", capturing, got)
+		}
+		if !capturing && cap(ev.partial) > relayBufferSize {
+			t.Errorf("held %d bytes of ignored deltas", cap(ev.partial))
+		}
+	}
+}
+
+func TestChatStreamLongLines(t *testing.T) {
+	long := strings.Repeat("y", 2<<20)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"m1\",\"usage\":{\"input_tokens\":4}}}\n\n"+
+			"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\""+long+"\"}}\n\n"+
+			"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"},\"usage\":{\"output_tokens\":6}}\n\n"+
+			"event: message_stop\ndata: {\"type\":\"message_stop\"}")
+	}))
+	defer upstream.Close()
+
+	tr := tracker.New()
+	s := NewServer(0, upstream.URL, time.Second, tr, compress.Config{})
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions",
+		strings.NewReader(`{"model":"claude-sonnet-4-6","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	rec := httptest.NewRecorder()
+	s.handleRequest(rec, req)
+
+	body := rec.Body.String()
+	if !strings.Contains(body, long) || !strings.HasSuffix(body, "data: [DONE]\n\n") {
+		t.Errorf("stream cut short: %d bytes, ends %q", len(body), body[max(len(body)-40, 0):])
+	}
+	if got := tr.GetRequests(); len(got) != 1 || got[0].InputTokens != 4 || got[0].OutputTokens != 6 {
+		t.Errorf("recorded %+v", got)
+	}
+}
+
+func TestStreamErrorEvent(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"m1\",\"usage\":{\"input_tokens\":4}}}\n\n"+
+			"event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n")
+	}))
+	defer upstream.Close()
+
+	tr := tracker.New()
+	s := NewServer(0, upstream.URL, time.Second, tr, compress.Config{})
+	for _, path := range []string{"/v1/messages", "/v1/chat/completions"} {
+		req := httptest.NewRequest(http.MethodPost, path,
+			strings.NewReader(`{"model":"claude-sonnet-4-6","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+		rec := httptest.NewRecorder()
+		s.handleRequest(rec, req)
+		if path == "/v1/chat/completions" {
+			if body := rec.Body.String(); !strings.Contains(body, `data: {"error":{"code":"overloaded","message":"Overloaded"`) ||
+				strings.Contains(body, "[DONE]") {
+				t.Errorf("chat stream did not end in an OpenAI error chunk: %q", body)
+			}
+		}
+	}
+	got := tr.GetRequests()
+	if len(got) != 2 {
+		t.Fatalf("recorded %d requests", len(got))
+	}
+	for _, r := range got {
+		if !r.Failed() || r.Error != "overloaded_error: Overloaded" || r.InputTokens != 4 {
+			t.Errorf("recorded %+v", r)
+		}
+	}
+}
+
+func TestEstimatedUsage(t *testing.T) {
+	text := strings.Repeat("lorem ipsum dolor sit amet ", 40)
+	responses := map[string]string{
+		// Cut off before message_delta and its output count.
+		"stream": "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":30}}}\n\n" +
+			"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"" + text + "\"}}\n\n",
+		"json": `{"content":[{"type":"text","text":"` + text + `"}]}`,
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), `"stream":true`) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, responses["stream"])
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, responses["json"])
+	}))
+	defer upstream.Close()
+
+	for _, capturing := range []bool{false, true} {
+		tr := tracker.New()
+		s := NewServer(0, upstream.URL, time.Second, tr, compress.Config{})
+		s.Capture = capture.Config{Enabled: capturing}
+		for _, stream := range []string{"true", "false"} {
+			s.handleRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/messages",
+				strings.NewReader(`{"model":"claude-sonnet-4-6","stream":`+stream+`,"messages":[{"role":"user","content":"`+text+`"}]}`)))
+		}
+		got := tr.GetRequests()
+		if len(got) != 2 {
+			t.Fatalf("capturing=%v: recorded %d requests", capturing, len(got))
+		}
+		for i, r := range got {
+			// The text is some 200 tokens.
+			if !r.EstimatedUsage || r.OutputTokens < 100 || r.OutputTokens > 400 || r.Cost == 0 {
+				t.Errorf("capturing=%v, request %d: %+v", capturing, i, r)
+			}
+		}
+		if !got[0].Partial() || got[1].Partial() {
+			t.Errorf("capturing=%v: cut-off stream not partial, or whole response partial", capturing)
+		}
+		if got[0].InputTokens != 30 || got[1].InputTokens < 100 {
+			t.Errorf("capturing=%v: reported input not kept, or missing input not estimated: %d, %d",
+				capturing, got[0].InputTokens, got[1].InputTokens)
+		}
+	}
+}
+
+func TestStalledClient(t *testing.T) {
+	cancelled := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":8}}}\n\n")
+		delta := "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"" +
+			strings.Repeat("z", 64<<10) + "\"}}\n\n"
+		for {
+			if _, err := io.WriteString(w, delta); err != nil {
+				break
+			}
+			w.(http.Flusher).Flush()
+			if r.Context().Err() != nil {
+				break
+			}
+		}
+		close(cancelled)
+	}))
+	defer upstream.Close()
+
+	tr := tracker.New()
+	recorded := make(chan tracker.Request, 1)
+	tr.Subscribe(func(r tracker.Request) { recorded <- r })
+	s := NewServer(0, upstream.URL, time.Minute, tr, compress.Config{})
+	s.StallTimeout = 100 * time.Millisecond
+	s.logger.SetOutput(io.Discard)
+	front := httptest.NewServer(http.HandlerFunc(s.handleRequest))
+	defer front.Close()
+
+	// Send a streaming request and never read the response.
+	conn, err := net.Dial("tcp", front.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	body := `{"model":"claude-sonnet-4-6","stream":true}`
+	fmt.Fprintf(conn, "POST /v1/messages HTTP/1.1\r\nHost: x\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+
+	select {
+	case r := <-recorded:
+		if r.Error != tracker.ClientStalled || r.InputTokens != 8 {
+			t.Errorf("recorded %+v", r)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("a stalled client was not dropped")
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Error("the upstream request was not cancelled")
+	}
+}
+
+// awkwardStream splits an event's JSON over data lines, runs events
+// together without blank lines, and leaves the last one unterminated.
+const awkwardStream = "event: message_start\n" +
+	`data: {"type":"message_start","message":{"usage":` + "\n" +
+	`data: {"input_tokens":12,"cache_read_input_tokens":3}}}` + "\n" +
+	"event: content_block_delta\n" +
+	`data: {"type":"content_block_delta","delta":{"type":"text_delta","text":"Hel` + "\n" +
+	`data:lo"}}` + "\n\n" +
+	`data: {"type":"content_block_delta","delta":{"type":"text_delta","text":", world"}}` + "\n" +
+	`data: {"type":"message_delta",` + "\n" +
+	`data: "usage":{"output_tokens":7}}`
+
+func TestSSEReassembly(t *testing.T) {
+	s := NewServer(0, "", time.Second, tracker.New(), compress.Config{})
+	s.Capture = capture.Config{Enabled: true}
+	for _, oneByte := range []bool{false, true} {
+		var body io.Reader = strings.NewReader(awkwardStream)
+		if oneByte {
+			body = iotest.OneByteReader(body)
+		}
+		ev := newEventStream(s)
+		if err := relay(s.clientWriter(httptest.NewRecorder(), true), body, ev, make([]byte, 16)); err != nil {
+			t.Fatal(err)
+		}
+		ev.close()
+		if ev.inputTokens != 12 || ev.cacheRead != 3 || ev.outputTokens != 7 {
+			t.Errorf("oneByte=%v: usage = %+v", oneByte, *ev)
+		}
+		if got := ev.captured.Text(); got != "Hello, world" {
+			t.Errorf("oneByte=%v: captured %q", oneByte, got)
+		}
+	}
+
+	r := newSSEReader(iotest.OneByteReader(strings.NewReader(awkwardStream)))
+	var events []string
+	for {
+		data, err := r.next()
+		if err != nil {
+			break
+		}
+		events = append(events, string(data))
+	}
+	if len(events) != 4 || events[1] != `{"type":"content_block_delta","delta":{"type":"text_delta","text":"Hello"}}` ||
+		events[3] != `{"type":"message_delta","usage":{"output_tokens":7}}` {
+		t.Errorf("events = %q", events)
+	}
+}
+
+func TestStreamPassthrough(t *testing.T) {
+	// What strict SSE clients notice: comments, id and retry fields,
+	// unknown events, CRLF and extra blank lines, and a last event with
+	// no blank line after it.
+	stream := ": stream opened\r\n" +
+		"retry: 3000\r\n\r\n" +
+		"event: message_start\r\nid: 1\r\n" +
+		`data: {"type":"message_start","message":{"usage":{"input_tokens":12}}}` + "\r\n\r\n\r\n" +
+		"event: vendor_extension\ndata: {\"anything\": [1, 2]}\n\n" +
+		": keep-alive\n\n" +
+		"event: content_block_delta\nid: 2\n" +
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}` + "\n\n" +
+		"event: message_delta\n" +
+		`data:  {"type":"message_delta","usage":{"output_tokens":3}}` + "\n\n" +
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		for rest := stream; rest != ""; {
+			n := min(len(rest), 37) // cut lines and CRLFs across writes
+			io.WriteString(w, rest[:n])
+			w.(http.Flusher).Flush()
+			rest = rest[n:]
+		}
+	}))
+	defer upstream.Close()
+
+	tr := tracker.New()
+	s := NewServer(0, upstream.URL, time.Second, tr, compress.Config{})
+	rec := httptest.NewRecorder()
+	s.handleRequest(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(
+		`{"model":"claude-sonnet-4-6","stream":true,"max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`)))
+
+	if got := rec.Body.String(); got != stream {
+		t.Errorf("relayed stream altered:\n got %q\nwant %q", got, stream)
+	}
+	for h, want := range map[string]string{"Content-Type": "text/event-stream; charset=utf-8", "Cache-Control": "no-cache"} {
+		if got := rec.Header().Get(h); got != want {
+			t.Errorf("%s = %q, want %q", h, got, want)
+		}
+	}
+	if got := tr.GetRequests(); len(got) != 1 || got[0].InputTokens != 12 || got[0].OutputTokens != 3 || got[0].Truncated {
+		t.Errorf("recorded %+v", got)
+	}
+}
+
+func TestToolTokens(t *testing.T) {
+	args := strings.Repeat(`{\"path\": \"src/main.go\"} `, 30)
+	stream := "event: message_start\n" +
+		`data: {"type":"message_start","message":{"usage":{"input_tokens":50}}}` + "\n\n" +
+		"event: content_block_start\n" +
+		`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}` + "\n\n" +
+		"event: content_block_delta\n" +
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"` + strings.Repeat("Let me look. ", 10) + `"}}` + "\n\n" +
+		"event: content_block_start\n" +
+		`data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"t1","name":"read","input":{}}}` + "\n\n" +
+		"event: content_block_delta\n" +
+		`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"` + args + `"}}` + "\n\n" +
+		"event: message_delta\n" +
+		`data: {"type":"message_delta","usage":{"output_tokens":200}}` + "\n\n"
+
+	for _, capturing := range []bool{false, true} {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, stream)
+		}))
+		tr := tracker.New()
+		s := NewServer(0, upstream.URL, time.Second, tr, compress.Config{})
+		s.Capture = capture.Config{Enabled: capturing}
+		s.handleRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(
+			`{"model":"claude-sonnet-4-6","stream":true,"max_tokens":1000,"messages":[{"role":"user","content":"hi"}]}`)))
+		upstream.Close()
+
+		got := tr.GetRequests()
+		if len(got) != 1 || got[0].OutputTokens != 200 {
+			t.Fatalf("capturing=%v: recorded %+v", capturing, got)
+		}
+		// Most of the output was the tool call's arguments.
+		if tool := got[0].ToolTokens; tool < 120 || tool >= 200 {
+			t.Errorf("capturing=%v: %d of 200 output tokens attributed to tools", capturing, tool)
+		}
+		if ms := tr.GetModelStats(); len(ms) != 1 || ms[0].ToolTokens != got[0].ToolTokens {
+			t.Errorf("capturing=%v: model stats %+v", capturing, ms)
+		}
+	}
+
+	body := []byte(`{"content":[{"type":"text","text":"Done."},{"type":"tool_use","id":"t1","name":"read","input":{"path":"src/main.go"}}],"usage":{"output_tokens":20}}`)
+	if n, tool := responseTokens(body); tool == 0 || tool >= n {
+		t.Errorf("responseTokens = %d, %d tool", n, tool)
+	}
+}
+
+func TestClientCancelsStream(t *testing.T) {
+	cancelled := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "event: message_start\n"+
+			`data: {"type":"message_start","message":{"id":"m1","usage":{"input_tokens":12,"output_tokens":1}}}`+"\n\n"+
+			"event: content_block_delta\n"+
+			`data: {"type":"content_block_delta","delta":{"type":"text_delta","text":"`+strings.Repeat("Hello there. ", 20)+`"}}`+"\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	defer upstream.Close()
+
+	for _, path := range []string{"/v1/messages", "/v1/chat/completions"} {
+		cancelled = make(chan struct{})
+		tr := tracker.New()
+		s := NewServer(0, upstream.URL, 5*time.Second, tr, compress.Config{})
+		s.SetLogger(log.New(io.Discard, "", 0))
+		ctx, cancel := context.WithCancel(context.Background())
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(
+			`{"model":"claude-opus-4-6","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"hi"}]}`)).WithContext(ctx)
+		s.handleRequest(cancelOnWrite{httptest.NewRecorder(), cancel}, req)
+		select {
+		case <-cancelled:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: upstream request not cancelled", path)
+		}
+		got := tr.GetRequests()
+		if len(got) != 1 || !got[0].Cancelled() || got[0].InputTokens != 12 || got[0].OutputTokens == 0 || !got[0].EstimatedUsage {
+			t.Errorf("%s: recorded %+v", path, got)
+		}
+	}
+}
+
+// cancelOnWrite goes away, as a client would, once it has been sent
+// something.
+type cancelOnWrite struct {
+	*httptest.ResponseRecorder
+	cancel context.CancelFunc
+}
+
+func (c cancelOnWrite) Write(p []byte) (int, error) {
+	defer c.cancel()
+	return c.ResponseRecorder.Write(p)
+}
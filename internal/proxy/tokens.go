@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"encoding/json"
+	"math"
+
+	"miser/internal/format"
+	"miser/internal/tokens"
+	"miser/internal/tracker"
+)
+
+// TokenCheck compares the input tokens upstream reports for each request
+// with a local estimate, to catch billing surprises and requests mangled
+// on the way, e.g. by the OpenAI conversion. The zero value disables it.
+type TokenCheck struct {
+	Tolerance float64 // flag counts off the estimate by more than this fraction
+	MinTokens int     // skip smaller prompts, where the fixed overheads dominate
+}
+
+// estimate sizes the request body that goes upstream, for checkTokens,
+// and keeps it for estimateUsage.
+func (s *Server) estimate(x *exchange, body []byte) {
+	x.sent = body
+	if s.Tokens.Tolerance <= 0 {
+		return
+	}
+	n, ok := tokens.Request(body)
+	if !ok {
+		s.debugf("tokens: request can't be estimated locally; not checking its usage")
+		return
+	}
+	x.estimate = n
+}
+
+// checkTokens flags rec, setting its EstimatedInput, when the input upstream
+// reported is off the estimate by more than the tolerance.
+func (s *Server) checkTokens(x *exchange, rec *tracker.Request) {
+	if x.estimate == 0 || rec.Failed() || rec.EstimatedUsage {
+		return
+	}
+	reported := rec.InputTokens + rec.CacheRead + rec.CacheWrite
+	if max(reported, x.estimate) < s.Tokens.MinTokens {
+		return
+	}
+	off := float64(reported-x.estimate) / float64(x.estimate)
+	if math.Abs(off) <= s.Tokens.Tolerance {
+		return
+	}
+	rec.EstimatedInput = x.estimate
+	s.logger.Printf("[TOKENS] %s reported %s input tokens, %+.0f%% off the local estimate of %s",
+		x.model, format.Tokens(reported), 100*off, format.Tokens(x.estimate))
+}
+
+// estimateUsage fills in the usage a successful response did not report,
+// such as a stream cut off before its final counts or an upstream that
+// sends none: the input from the request sent, the output from what was
+// relayed, which the caller sizes. haveIn and haveOut say which
+// counts upstream did report. The request is flagged as estimated, and
+// priced again. count_tokens calls, which report no usage and cost
+// nothing, are never sized, so are left alone.
+func (s *Server) estimateUsage(x *exchange, rec *tracker.Request, haveIn, haveOut bool, output int) {
+	if (haveIn && haveOut) || rec.StatusCode >= 400 || x.sent == nil {
+		return
+	}
+	if !haveIn {
+		rec.InputTokens, _ = tokens.Request(x.sent)
+	}
+	if !haveOut {
+		rec.OutputTokens = output
+	}
+	rec.EstimatedUsage = true
+	rec.Cost = tracker.CalculateCost(rec.Model, rec.InputTokens, rec.OutputTokens, rec.CacheRead, rec.CacheWrite)
+	s.debugf("tokens: no usage reported for %s; estimated %s in, %s out",
+		x.model, format.Tokens(rec.InputTokens), format.Tokens(rec.OutputTokens))
+}
+
+// bytesPerToken sizes output seen only as bytes of JSON-escaped deltas.
+const bytesPerToken = 4
+
+// responseTokens estimates the output of a non-streaming Messages
+// response: its text and tool inputs, or the whole body if it is not one,
+// and of that, the tool inputs.
+func responseTokens(body []byte) (n, tool int) {
+	var msg struct {
+		Content []struct {
+			Text     string          `json:"text"`
+			Thinking string          `json:"thinking"`
+			Input    json.RawMessage `json:"input"`
+		} `json:"content"`
+	}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return tokens.Text(string(body)), 0
+	}
+	for _, c := range msg.Content {
+		tool += tokens.Text(string(c.Input))
+		n += tokens.Text(c.Text) + tokens.Text(c.Thinking)
+	}
+	return n + tool, tool
+}
+
+// toolTokens apportions the output tokens of rec between text and tool
+// call arguments by the local estimate of each, since upstream reports
+// only their sum.
+func toolTokens(rec *tracker.Request, tool, estimate int) {
+	if tool > 0 && estimate > 0 {
+		rec.ToolTokens = min(int(math.Round(float64(rec.OutputTokens)*float64(tool)/float64(estimate))), rec.OutputTokens)
+	}
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"miser/internal/tracker"
+)
+
+// netTrace collects an upstream request's connection timings through
+// httptrace. Its hooks may run on other goroutines.
+type netTrace struct {
+	mu                            sync.Mutex
+	dns, connect, handshake, sent time.Time
+	n                             tracker.Network
+}
+
+// with returns ctx set to report to t.
+func (t *netTrace) with(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.n.Reused = info.Reused
+			t.mu.Unlock()
+		},
+		DNSStart: func(httptrace.DNSStartInfo) { t.mark(&t.dns) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { t.since(&t.dns, &t.n.DNS) },
+		ConnectStart: func(string, string) {
+			// With several addresses, time from the first attempt.
+			t.mu.Lock()
+			if t.connect.IsZero() {
+				t.connect = time.Now()
+			}
+			t.mu.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				t.since(&t.connect, &t.n.Connect)
+			}
+		},
+		TLSHandshakeStart: func() { t.mark(&t.handshake) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.since(&t.handshake, &t.n.TLS)
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.mark(&t.sent) },
+		GotFirstResponseByte: func() { t.since(&t.sent, &t.n.FirstByte) },
+	})
+}
+
+func (t *netTrace) mark(at *time.Time) {
+	t.mu.Lock()
+	*at = time.Now()
+	t.mu.Unlock()
+}
+
+func (t *netTrace) since(start *time.Time, d *time.Duration) {
+	t.mu.Lock()
+	if !start.IsZero() {
+		*d = time.Since(*start)
+	}
+	t.mu.Unlock()
+}
+
+func (t *netTrace) network() tracker.Network {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.n
+}
@@ -0,0 +1,242 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UpstreamPolicy chooses which of several upstream endpoints is active.
+type UpstreamPolicy string
+
+const (
+	Fastest UpstreamPolicy = "fastest" // the healthy endpoint that answered its probe fastest
+	Sticky  UpstreamPolicy = "sticky"  // the active endpoint until it fails, then the fastest
+	Manual  UpstreamPolicy = "manual"  // the first, or the one switched to; never changed for health
+)
+
+// ParseUpstreamPolicy checks a policy name.
+func ParseUpstreamPolicy(s string) (UpstreamPolicy, error) {
+	switch p := UpstreamPolicy(s); p {
+	case Fastest, Sticky, Manual:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown policy %q (want fastest, sticky or manual)", s)
+}
+
+// probeTimeout bounds one probe; an endpoint slower than this is down.
+const probeTimeout = 10 * time.Second
+
+// Upstreams holds several endpoints serving the same API, such as regional
+// gateways, and sends model requests to one of them. Each is probed every
+// Interval, and a request that can't reach one marks it down until its
+// next probe answers. The zero Interval probes only once, at start.
+type Upstreams struct {
+	Policy   UpstreamPolicy
+	Interval time.Duration
+
+	mu        sync.Mutex
+	endpoints []Endpoint
+	active    int
+}
+
+// Endpoint is one upstream and how it last answered.
+type Endpoint struct {
+	URL     string
+	Healthy bool          // true until a probe or request fails
+	Latency time.Duration // of its last answered probe; 0 before one
+	Err     string        // why it is down
+}
+
+// NewUpstreams starts with the first of urls active.
+func NewUpstreams(urls []string, policy UpstreamPolicy, interval time.Duration) *Upstreams {
+	u := &Upstreams{Policy: policy, Interval: interval}
+	for _, url := range urls {
+		u.endpoints = append(u.endpoints, Endpoint{URL: url, Healthy: true})
+	}
+	return u
+}
+
+// Active returns the URL requests go to.
+func (u *Upstreams) Active() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.endpoints[u.active].URL
+}
+
+// Endpoints returns a copy of every endpoint's state.
+func (u *Upstreams) Endpoints() []Endpoint {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]Endpoint(nil), u.endpoints...)
+}
+
+// Status describes the active endpoint for the dashboard header, e.g.
+// "https://eu.example.com (fastest, 84ms; 2 of 3 up)".
+func (u *Upstreams) Status() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	e := u.endpoints[u.active]
+	up := 0
+	for _, e := range u.endpoints {
+		if e.Healthy {
+			up++
+		}
+	}
+	detail := string(u.Policy)
+	switch {
+	case !e.Healthy:
+		detail += ", down"
+	case e.Latency > 0:
+		detail += ", " + e.Latency.Round(time.Millisecond).String()
+	}
+	return fmt.Sprintf("%s (%s; %d of %d up)", e.URL, detail, up, len(u.endpoints))
+}
+
+// Next switches to the endpoint after the active one, for the manual
+// policy; ok is false under the others, which choose for themselves.
+func (u *Upstreams) Next() (url string, ok bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.Policy != Manual {
+		return "", false
+	}
+	u.active = (u.active + 1) % len(u.endpoints)
+	return u.endpoints[u.active].URL, true
+}
+
+// failed marks url down after a request could not reach it, and reports
+// the endpoint switched to, if any.
+func (u *Upstreams) failed(url string, err error) (switched string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for i := range u.endpoints {
+		if u.endpoints[i].URL == url {
+			u.endpoints[i].Healthy = false
+			u.endpoints[i].Err = err.Error()
+		}
+	}
+	return u.choose()
+}
+
+// choose applies the policy, returning the new active URL when it changed.
+// The fastest policy only moves for an endpoint at least a fifth faster,
+// so that probe jitter doesn't flip between two close ones. Called with mu
+// held.
+func (u *Upstreams) choose() string {
+	cur := u.endpoints[u.active]
+	if u.Policy == Manual || (u.Policy == Sticky && cur.Healthy) {
+		return ""
+	}
+	best := -1
+	for i, e := range u.endpoints {
+		if e.Healthy && (best < 0 || e.Latency < u.endpoints[best].Latency) {
+			best = i
+		}
+	}
+	if best < 0 || best == u.active {
+		return ""
+	}
+	if cur.Healthy && u.endpoints[best].Latency*5 > cur.Latency*4 {
+		return ""
+	}
+	u.active = best
+	return u.endpoints[best].URL
+}
+
+// probeUpstreams probes every endpoint now and then each Interval until
+// ctx is done, logging when the active one changes or goes down.
+func (s *Server) probeUpstreams(ctx context.Context) {
+	u := s.Upstreams
+	for {
+		var wg sync.WaitGroup
+		endpoints := u.Endpoints()
+		results := make([]Endpoint, len(endpoints))
+		for i, e := range endpoints {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i] = s.probe(ctx, e.URL)
+			}()
+		}
+		wg.Wait()
+		if ctx.Err() != nil {
+			return
+		}
+
+		u.mu.Lock()
+		wasDown := !u.endpoints[u.active].Healthy
+		copy(u.endpoints, results)
+		switched := u.choose()
+		active := u.endpoints[u.active]
+		u.mu.Unlock()
+		switch {
+		case switched != "":
+			s.logger.Printf("[UPSTREAM] switched to %s (%s policy)", switched, u.Policy)
+		case !active.Healthy && !wasDown:
+			s.logger.Printf("[UPSTREAM] %s is down: %s", active.URL, active.Err)
+		}
+
+		if u.Interval <= 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(u.Interval):
+		}
+	}
+}
+
+// probe times a GET of url's model list. Any answer but a server error
+// counts as healthy; without credentials it is a quick 401.
+func (s *Server) probe(ctx context.Context, url string) Endpoint {
+	e := Endpoint{URL: url}
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(url, "/")+"/v1/models", nil)
+	if err != nil {
+		e.Err = err.Error()
+		return e
+	}
+	rt := s.client.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	start := time.Now()
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		e.Err = err.Error()
+		return e
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		e.Err = "probe answered " + resp.Status
+		return e
+	}
+	e.Healthy, e.Latency = true, time.Since(start)
+	return e
+}
+
+// target returns the upstream base URL for the next request.
+func (s *Server) target() string {
+	if s.Upstreams != nil {
+		return s.Upstreams.Active()
+	}
+	return s.Target
+}
+
+// unreachable notes that a request could not reach target, switching to
+// another endpoint if the policy allows. Requests the client gave up on
+// say nothing about the endpoint.
+func (s *Server) unreachable(ctx context.Context, target string, err error) {
+	if s.Upstreams == nil || ctx.Err() != nil {
+		return
+	}
+	if to := s.Upstreams.failed(target, err); to != "" {
+		s.logger.Printf("[UPSTREAM] %s unreachable; switched to %s", target, to)
+	}
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"strings"
+	"unicode"
+)
+
+// userAgents maps User-Agent products, matched case-insensitively against
+// each product in the header, to the tools they belong to. Tools come
+// before the SDKs and HTTP libraries they are built on: the first product
+// listed that matches wins.
+var userAgents = []struct{ product, client string }{
+	{"claude-cli", "claude"},
+	{"claude-code", "claude"},
+	{"cursor", "cursor"},
+	{"aider", "aider"},
+	{"cline", "cline"},
+	{"roo-code", "roo-code"},
+	{"continue", "continue"},
+	{"zed", "zed"},
+	{"opencode", "opencode"},
+	{"windsurf", "windsurf"},
+	{"goose", "goose"},
+	{"litellm", "litellm"},
+	{"langchain", "langchain"},
+	{"anthropic/python", "anthropic-python"},
+	{"anthropic/js", "anthropic-js"},
+	{"openai/python", "openai-python"},
+	{"openai/js", "openai-js"},
+	{"curl", "curl"},
+	{"wget", "wget"},
+	{"httpie", "httpie"},
+	{"python-requests", "python-requests"},
+	{"python-httpx", "httpx"},
+	{"go-http-client", "go"},
+	{"node-fetch", "node"},
+	{"undici", "node"},
+	{"axios", "node"},
+}
+
+// maxUserAgentClient bounds a client named after an unknown product.
+const maxUserAgentClient = 32
+
+// userAgentClient names the tool behind a User-Agent header. known is false
+// when it is none of userAgents; name is then the header's first product,
+// such as "mytool" for "mytool/2.1 (linux)", or "" if there is none.
+func userAgentClient(ua string) (name string, known bool) {
+	ua = strings.TrimSpace(ua)
+	if ua == "" {
+		return "", false
+	}
+	products := strings.Fields(strings.ToLower(ua))
+	for _, u := range userAgents {
+		for _, p := range products {
+			if strings.HasPrefix(p, u.product) && boundary(p[len(u.product):]) {
+				return u.client, true
+			}
+		}
+	}
+	first, _, _ := strings.Cut(strings.Fields(ua)[0], "/")
+	if strings.HasPrefix(first, "(") {
+		return "", false // a comment, not a product
+	}
+	first = strings.TrimFunc(first, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	if len(first) > maxUserAgentClient {
+		first = first[:maxUserAgentClient]
+	}
+	return first, false
+}
+
+// boundary reports whether rest, what follows a matched product name, ends
+// the name: "claude-cli/1.0" and "claude-cli" do, "cursorial" does not.
+func boundary(rest string) bool {
+	return rest == "" || strings.ContainsRune("/;( ", rune(rest[0]))
+}
@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal RFC 6455 server: enough to push text messages to a client and
+// answer its pings and close. Client messages are read and ignored.
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xa
+)
+
+// wsMaxMessage bounds what a client may send; nothing it sends is used.
+const wsMaxMessage = 64 << 10
+
+const wsWriteTimeout = 10 * time.Second
+
+func isWebSocket(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		headerHasToken(r.Header, "Connection", "upgrade")
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sameOrigin reports whether a browser Origin, if any, is localhost, the
+// proxy's own included. WebSockets are exempt from CORS, so without this
+// any web page could read the event stream through a loopback browser.
+// Matching Origin against Host is not enough: a page that rebound its own
+// hostname to 127.0.0.1 sends that name as both.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true // not a browser
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return localHost(u.Host)
+}
+
+type wsConn struct {
+	conn   net.Conn
+	rw     *bufio.ReadWriter
+	mu     sync.Mutex // serializes frame writes
+	closed chan struct{}
+	once   sync.Once
+}
+
+// acceptWebSocket completes the handshake and starts reading client
+// frames. It has written an error response if it fails.
+func acceptWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("bad websocket handshake")
+	}
+	if !sameOrigin(r) {
+		http.Error(w, "cross-origin WebSocket connections are not allowed", http.StatusForbidden)
+		return nil, errors.New("cross-origin websocket")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket unsupported", http.StatusInternalServerError)
+		return nil, errors.New("response cannot be hijacked")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c := &wsConn{conn: conn, rw: rw, closed: make(chan struct{})}
+	go c.readLoop()
+	return c, nil
+}
+
+// Done is closed once the client has gone or closed the connection.
+func (c *wsConn) Done() <-chan struct{} { return c.closed }
+
+func (c *wsConn) Close() error {
+	c.writeFrame(wsClose, nil)
+	c.shutdown()
+	return nil
+}
+
+func (c *wsConn) shutdown() {
+	c.once.Do(func() {
+		close(c.closed)
+		c.conn.Close()
+	})
+}
+
+func (c *wsConn) WriteText(p []byte) error { return c.writeFrame(wsText, p) }
+func (c *wsConn) Ping() error              { return c.writeFrame(wsPing, nil) }
+
+func (c *wsConn) writeFrame(op byte, p []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var hdr [10]byte
+	hdr[0] = 0x80 | op // FIN; server frames are never masked
+	n := 2
+	switch l := len(p); {
+	case l < 126:
+		hdr[1] = byte(l)
+	case l <= 0xffff:
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:], uint16(l))
+		n = 4
+	default:
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(l))
+		n = 10
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := c.rw.Write(hdr[:n]); err != nil {
+		return err
+	}
+	if _, err := c.rw.Write(p); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+func (c *wsConn) readLoop() {
+	defer c.shutdown()
+	for {
+		op, payload, err := c.readFrame()
+		if err != nil {
+			return
+		}
+		switch op {
+		case wsClose:
+			c.writeFrame(wsClose, nil)
+			return
+		case wsPing:
+			if c.writeFrame(wsPong, payload) != nil {
+				return
+			}
+		}
+	}
+}
+
+// readFrame reads one client frame. Fragments are returned one by one,
+// which is fine since their contents are ignored.
+func (c *wsConn) readFrame() (op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.rw, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	op = hdr[0] & 0x0f
+	if hdr[1]&0x80 == 0 {
+		return 0, nil, errors.New("unmasked client frame")
+	}
+	length := uint64(hdr[1] & 0x7f)
+	switch length {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(c.rw, b[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(c.rw, b[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(b[:])
+	}
+	if length > wsMaxMessage {
+		return 0, nil, errors.New("client frame too large")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.rw, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.rw, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return op, payload, nil
+}
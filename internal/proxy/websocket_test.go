@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"miser/internal/api"
+	"miser/internal/compress"
+	"miser/internal/tracker"
+)
+
+func dialEvents(t *testing.T, addr, origin string) (net.Conn, *bufio.Reader, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := "GET " + api.EventsPath + " HTTP/1.1\r\nHost: " + addr +
+		"\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\nSec-WebSocket-Version: 13" +
+		"\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+	if origin != "" {
+		req += "Origin: " + origin + "\r\n"
+	}
+	io.WriteString(conn, req+"\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, br, resp.Status + " " + resp.Header.Get("Sec-WebSocket-Accept")
+}
+
+func readMessage(t *testing.T, br *bufio.Reader) (event string, data json.RawMessage) {
+	t.Helper()
+	var hdr [2]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		t.Fatal(err)
+	}
+	if hdr[0] != 0x81 || hdr[1] > 126 {
+		t.Fatalf("frame header %x", hdr)
+	}
+	n := int(hdr[1])
+	if n == 126 {
+		var ext [2]byte
+		io.ReadFull(br, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, n)
+	io.ReadFull(br, payload)
+	var msg struct {
+		Event string          `json:"event"`
+		Data  json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		t.Fatalf("%v: %s", err, payload)
+	}
+	return msg.Event, msg.Data
+}
+
+func TestEventsWebSocket(t *testing.T) {
+	tr := tracker.New()
+	tr.Record(tracker.Request{Timestamp: time.Now(), Model: "a"})
+	s := NewServer(0, "", time.Second, tr, compress.Config{})
+	mux := http.NewServeMux()
+	s.registerAPI(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	evil, _, status := dialEvents(t, addr, "https://evil.example")
+	evil.Close()
+	if !strings.HasPrefix(status, "403") {
+		t.Errorf("cross-origin handshake = %s", status)
+	}
+
+	conn, br, status := dialEvents(t, addr, "")
+	defer conn.Close()
+	// The accept value for this key is given in RFC 6455 section 1.3.
+	if status != "101 Switching Protocols s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake = %s", status)
+	}
+	if ev, _ := readMessage(t, br); ev != api.EventSession {
+		t.Errorf("first event = %s", ev)
+	}
+	if ev, data := readMessage(t, br); ev != api.EventRequest || !strings.Contains(string(data), `"model":"a"`) {
+		t.Errorf("backlog event = %s %s", ev, data)
+	}
+	tr.Record(tracker.Request{Timestamp: time.Now(), Model: "b"})
+	if _, data := readMessage(t, br); !strings.Contains(string(data), `"model":"b"`) {
+		t.Errorf("live event = %s", data)
+	}
+
+	// A masked close frame is echoed before the server hangs up.
+	conn.Write([]byte{0x88, 0x80, 1, 2, 3, 4})
+	var hdr [2]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil || hdr[0] != 0x88 {
+		t.Errorf("close reply = %x, %v", hdr, err)
+	}
+}
+
+func TestSameOrigin(t *testing.T) {
+	for _, tc := range []struct {
+		host, origin string
+		want         bool
+	}{
+		{"localhost:8080", "", true},
+		{"localhost:8080", "http://localhost:8080", true},
+		{"127.0.0.1:8080", "http://localhost:3000", true},
+		{"[::1]:8080", "http://[::1]:8080", true},
+		{"localhost:8080", "https://evil.example", false},
+		{"evil.example:8080", "http://evil.example:8080", false}, // DNS rebinding
+	} {
+		r := httptest.NewRequest(http.MethodGet, "http://"+tc.host+api.EventsPath, nil)
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		if got := sameOrigin(r); got != tc.want {
+			t.Errorf("Host %s, Origin %q: %v, want %v", tc.host, tc.origin, got, tc.want)
+		}
+	}
+}
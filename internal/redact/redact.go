@@ -0,0 +1,107 @@
+// Package redact masks secrets and personal data in text before miser
+// stores it: in captured previews and in every export.
+package redact
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"miser/internal/tracker"
+)
+
+// Mask replaces each match.
+const Mask = "[REDACTED]"
+
+// builtins are the pattern sets enabled by name. API keys are always
+// masked; the others can be turned off.
+var builtins = map[string][]*regexp.Regexp{
+	"api_keys": {
+		regexp.MustCompile(`sk-ant-[A-Za-z0-9_\-]{8,}`),
+		regexp.MustCompile(`sk-[A-Za-z0-9_\-]{20,}`),
+		regexp.MustCompile(`\b(?:ghp|gho|ghu|ghs|ghr)_[A-Za-z0-9]{30,}`),
+		regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{40,}`),
+		regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9\-]{10,}`),
+		regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/\-]{20,}=*`),
+	},
+	"aws": {
+		regexp.MustCompile(`\b(?:AKIA|ASIA)[A-Z0-9]{16}\b`),
+		regexp.MustCompile(`(?i)\baws_?(?:secret_?access_?key|session_?token)["']?\s*[:=]\s*["']?[A-Za-z0-9/+=]{16,}`),
+	},
+	"emails": {
+		regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`),
+	},
+}
+
+// Builtins names the built-in pattern sets, all on by default.
+var Builtins = []string{"api_keys", "aws", "emails"}
+
+// Redactor masks what its patterns match. A nil Redactor masks API keys.
+type Redactor struct {
+	patterns []*regexp.Regexp
+}
+
+var apiKeys = &Redactor{patterns: builtins["api_keys"]}
+
+// New returns a redactor for the named built-in sets, plus API keys, and
+// the extra regexes (Go RE2 syntax) in patterns.
+func New(builtin, patterns []string) (*Redactor, error) {
+	r := &Redactor{patterns: slices.Clone(builtins["api_keys"])}
+	for _, name := range builtin {
+		set, ok := builtins[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown built-in pattern set %q (have %s)", name, strings.Join(Builtins, ", "))
+		}
+		if name != "api_keys" {
+			r.patterns = append(r.patterns, set...)
+		}
+	}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("redaction pattern %q: %w", p, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// String masks s.
+func (r *Redactor) String(s string) string {
+	if r == nil {
+		r = apiKeys
+	}
+	if s == "" {
+		return s
+	}
+	for _, re := range r.patterns {
+		s = re.ReplaceAllString(s, Mask)
+	}
+	return s
+}
+
+// Request masks the free text of a request: its previews, error,
+// project and client.
+func (r *Redactor) Request(req tracker.Request) tracker.Request {
+	req.Prompt = r.String(req.Prompt)
+	req.Response = r.String(req.Response)
+	req.Error = r.String(req.Error)
+	req.Project = r.String(req.Project)
+	req.Client = r.String(req.Client)
+	if o := req.ShadowOf; o != nil && o.Response != "" {
+		masked := *o
+		masked.Response = r.String(o.Response)
+		req.ShadowOf = &masked
+	}
+	return req
+}
+
+// Requests masks each of reqs, leaving reqs itself unchanged.
+func (r *Redactor) Requests(reqs []tracker.Request) []tracker.Request {
+	out := make([]tracker.Request, len(reqs))
+	for i, req := range reqs {
+		out[i] = r.Request(req)
+	}
+	return out
+}
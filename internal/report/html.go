@@ -0,0 +1,84 @@
+package report
+
+import (
+	"html/template"
+	"io"
+
+	"miser/internal/format"
+	"miser/internal/tracker"
+)
+
+// The HTML report is a single file with inline styles and CSS bar charts,
+// so it can be mailed or attached to a ticket as-is.
+var page = template.Must(template.New("report").Funcs(template.FuncMap{
+	"cost":   format.Cost,
+	"tokens": format.Tokens,
+	"int":    format.Int,
+	"status": status,
+	"share":  share,
+	"secs":   func(r tracker.Request) float64 { return r.Latency.Seconds() },
+	"heat":   func(cost, peak float64) float64 { return share(cost, peak) / 100 },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>miser cost report — {{.Period}}</title>
+<style>
+body { font: 14px/1.45 -apple-system, "Segoe UI", sans-serif; color: #1d232b; max-width: 960px; margin: 2em auto; padding: 0 1em; }
+h1 { margin-bottom: 0; }
+.meta { color: #6b7480; margin-top: .2em; }
+.cards { display: flex; gap: 1em; flex-wrap: wrap; margin: 1.5em 0; }
+.card { background: #f3f5f7; border-radius: 6px; padding: .7em 1.1em; min-width: 8em; }
+.card b { display: block; font-size: 1.4em; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+th, td { padding: .35em .6em; border-bottom: 1px solid #e3e6ea; text-align: right; white-space: nowrap; }
+th:first-child, td:first-child, td.l { text-align: left; }
+th { color: #6b7480; font-weight: 600; }
+.bar { background: #2f9e6e; height: 1em; border-radius: 2px; }
+.err { color: #c0392b; }
+.heat th, .heat td { padding: .25em .2em; font-size: .8em; text-align: center; border-bottom: 1px solid #fff; }
+</style>
+</head>
+<body>
+<h1>miser cost report</h1>
+<p class="meta">{{.Period}} · generated {{.Generated.Format "2006-01-02 15:04"}}</p>
+
+{{with .Summary}}<div class="cards">
+<div class="card">Cost<b>{{cost .TotalCost}}</b></div>
+<div class="card">Requests<b>{{int .TotalRequests}}</b></div>
+<div class="card">Errors<b>{{int .TotalErrors}}</b></div>
+<div class="card">Input<b>{{tokens .TotalInput}}</b></div>
+<div class="card">Output<b>{{tokens .TotalOutput}}</b></div>
+<div class="card">Cache read<b>{{tokens .TotalCacheR}}</b></div>
+</div>{{end}}
+
+<h2>By model</h2>
+<table>
+<tr><th>Model</th><th>Requests</th><th>Input</th><th>Output</th><th>Cache hit</th><th>Cost</th><th>Share</th></tr>
+{{range .Models}}<tr><td>{{.Model}}</td><td>{{int .Requests}}</td><td>{{tokens .InputTokens}}</td><td>{{tokens .OutputTokens}}</td><td>{{printf "%.1f%%" .CacheHitRate}}</td><td>{{cost .TotalCost}}</td><td>{{printf "%.1f%%" (share .TotalCost $.Summary.TotalCost)}}</td></tr>
+{{end}}</table>
+
+<h2>Daily spend</h2>
+<table>
+{{$peak := .PeakDay}}{{range .Days}}<tr><td>{{.Date.Format "2006-01-02 Mon"}}</td><td style="width:70%"><div class="bar" style="width:{{printf "%.1f" (share .TotalCost $peak)}}%"></div></td><td>{{cost .TotalCost}}</td><td>{{int .TotalRequests}} req</td></tr>
+{{end}}</table>
+
+<h2>Spend by hour</h2>
+<table class="heat">
+<tr><th></th>{{range $h, $_ := (index .HeatRows 0).Hours}}<th>{{printf "%02d" $h}}</th>{{end}}<th>Cost</th><th>Requests</th></tr>
+{{$peakHour := .PeakHour}}{{range .HeatRows}}{{$day := .Day}}<tr><td>{{$day}}</td>{{range $h, $s := .Hours}}<td title="{{$day}} {{printf "%02d:00" $h}}: {{cost $s.TotalCost}}, {{int $s.TotalRequests}} req" style="background:rgba(47,158,110,{{printf "%.2f" (heat $s.TotalCost $peakHour)}})">{{if $s.TotalRequests}}{{int $s.TotalRequests}}{{end}}</td>{{end}}<td>{{cost .Total.TotalCost}}</td><td>{{int .Total.TotalRequests}}</td></tr>
+{{end}}</table>
+{{with .Busiest}}<p class="meta">Local time; cells show requests, shaded by cost against the busiest hour: {{.}}.</p>{{end}}
+
+<h2>Top {{len .Top}} most expensive requests</h2>
+<table>
+<tr><th>Time</th><th>Model</th><th>Input</th><th>Output</th><th>Latency</th><th>Status</th><th>Cost</th></tr>
+{{range .Top}}<tr><td>{{.Timestamp.Format "2006-01-02 15:04:05"}}</td><td class="l">{{.Model}}</td><td>{{tokens .InputTokens}}</td><td>{{tokens .OutputTokens}}</td><td>{{printf "%.1fs" (secs .)}}</td><td{{if .Failed}} class="err"{{end}}>{{status .}}</td><td>{{cost .Cost}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func writeHTML(w io.Writer, r Report) error {
+	return page.Execute(w, r)
+}
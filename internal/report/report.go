@@ -0,0 +1,227 @@
+// Package report renders a shareable spend report from tracked requests.
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"miser/internal/format"
+	"miser/internal/tracker"
+)
+
+// Format is a report output format.
+type Format string
+
+const (
+	Markdown Format = "md"
+	HTML     Format = "html"
+)
+
+// ParseFormat accepts md/markdown and html/htm.
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(s, ".")) {
+	case "md", "markdown":
+		return Markdown, nil
+	case "html", "htm":
+		return HTML, nil
+	}
+	return "", fmt.Errorf("unknown report format %q (want md or html)", s)
+}
+
+// Report is the data behind a rendered report.
+type Report struct {
+	Generated time.Time
+	Since     time.Time // zero for all history
+	Summary   tracker.Summary
+	Models    []tracker.ModelStats
+	Days      []tracker.Day
+	Heatmap   tracker.Heatmap
+	Top       []tracker.Request // most expensive first
+}
+
+// Build collects a report over requests at or after since, listing the
+// top n most expensive requests.
+func Build(t *tracker.Tracker, since time.Time, n int) Report {
+	reqs := t.GetRequestsSince(since)
+	sort.SliceStable(reqs, func(i, j int) bool { return reqs[i].Cost > reqs[j].Cost })
+	if len(reqs) > n {
+		reqs = reqs[:n]
+	}
+	return Report{
+		Generated: time.Now(),
+		Since:     since,
+		Summary:   t.GetSummarySince(since),
+		Models:    t.GetModelStatsSince(since),
+		Days:      t.GetDailySince(since),
+		Heatmap:   t.GetHeatmapSince(since),
+		Top:       reqs,
+	}
+}
+
+// Write renders r in the given format.
+func Write(w io.Writer, f Format, r Report) error {
+	switch f {
+	case Markdown:
+		return writeMarkdown(w, r)
+	case HTML:
+		return writeHTML(w, r)
+	}
+	return fmt.Errorf("unknown report format %q", f)
+}
+
+// Period describes the time range covered by the report.
+func (r Report) Period() string {
+	if r.Since.IsZero() {
+		return "all history"
+	}
+	return r.Since.Format("2006-01-02 15:04") + " – " + r.Generated.Format("2006-01-02 15:04")
+}
+
+// PeakDay returns the highest daily cost, for scaling charts.
+func (r Report) PeakDay() float64 {
+	var peak float64
+	for _, d := range r.Days {
+		peak = max(peak, d.TotalCost)
+	}
+	return peak
+}
+
+// HeatRow is one weekday of the hour-of-day heatmap.
+type HeatRow struct {
+	Day   string // "Mon"
+	Hours [24]tracker.Summary
+	Total tracker.Summary
+}
+
+// HeatRows lays out the heatmap a weekday per row, Monday first.
+func (r Report) HeatRows() []HeatRow {
+	rows := make([]HeatRow, 7)
+	for d := range rows {
+		rows[d].Day = time.Weekday((d + 1) % 7).String()[:3]
+		rows[d].Hours = r.Heatmap[d]
+		for _, s := range r.Heatmap[d] {
+			rows[d].Total.TotalRequests += s.TotalRequests
+			rows[d].Total.TotalCost += s.TotalCost
+		}
+	}
+	return rows
+}
+
+// PeakHour returns the highest cost of any hour of the week, for shading.
+func (r Report) PeakHour() float64 {
+	return r.Heatmap.Peak()
+}
+
+// Busiest describes the most expensive hour of the week, e.g. "Tue 14:00,
+// $1.2000 over 34 requests", or "" with no spend.
+func (r Report) Busiest() string {
+	peak := r.PeakHour()
+	if peak <= 0 {
+		return ""
+	}
+	for _, row := range r.HeatRows() {
+		for h, s := range row.Hours {
+			if s.TotalCost == peak {
+				return fmt.Sprintf("%s %02d:00, %s over %s requests", row.Day, h, format.Cost(s.TotalCost), format.Int(s.TotalRequests))
+			}
+		}
+	}
+	return ""
+}
+
+// heatShades are the Markdown heatmap's cells, by cost against the peak
+// hour: none, then up to a quarter, half, three quarters and more.
+var heatShades = []string{"··", "░░", "▒▒", "▓▓", "██"}
+
+func heatShade(cost, peak float64) string {
+	if cost <= 0 || peak <= 0 {
+		return heatShades[0]
+	}
+	return heatShades[1+min(int(cost/peak*4-1e-9), 3)]
+}
+
+func share(part, total float64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return part / total * 100
+}
+
+func status(req tracker.Request) string {
+	if req.Error != "" {
+		return "ERR"
+	}
+	return fmt.Sprint(req.StatusCode)
+}
+
+const mdBarWidth = 30
+
+func writeMarkdown(w io.Writer, r Report) error {
+	var b strings.Builder
+	s := r.Summary
+	fmt.Fprintf(&b, "# miser cost report\n\n")
+	fmt.Fprintf(&b, "_%s · generated %s_\n\n", r.Period(), r.Generated.Format("2006-01-02 15:04"))
+
+	b.WriteString("## Totals\n\n")
+	b.WriteString("| Cost | Requests | Errors | Input | Output | Cache R | Cache W |\n")
+	b.WriteString("|--:|--:|--:|--:|--:|--:|--:|\n")
+	fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s | %s |\n\n",
+		format.Cost(s.TotalCost), format.Int(s.TotalRequests), format.Int(s.TotalErrors),
+		format.Tokens(s.TotalInput), format.Tokens(s.TotalOutput),
+		format.Tokens(s.TotalCacheR), format.Tokens(s.TotalCacheW))
+
+	b.WriteString("## By model\n\n")
+	b.WriteString("| Model | Requests | Input | Output | Cache hit | Cost | Share |\n")
+	b.WriteString("|---|--:|--:|--:|--:|--:|--:|\n")
+	for _, m := range r.Models {
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %.1f%% | %s | %.1f%% |\n",
+			m.Model, format.Int(m.Requests), format.Tokens(m.InputTokens), format.Tokens(m.OutputTokens),
+			m.CacheHitRate(), format.Cost(m.TotalCost), share(m.TotalCost, s.TotalCost))
+	}
+
+	b.WriteString("\n## Daily spend\n\n```\n")
+	peak := r.PeakDay()
+	for _, d := range r.Days {
+		n := 0
+		if peak > 0 {
+			n = int(d.TotalCost / peak * mdBarWidth)
+		}
+		fmt.Fprintf(&b, "%s  %-*s  %s\n", d.Date.Format("2006-01-02 Mon"), mdBarWidth, strings.Repeat("█", n), format.Cost(d.TotalCost))
+	}
+	b.WriteString("```\n\n")
+
+	b.WriteString("## Spend by hour\n\n```\n")
+	hours := "    "
+	for h := 0; h < 24; h += 3 {
+		hours += fmt.Sprintf("%02d    ", h)
+	}
+	b.WriteString(strings.TrimRight(hours, " ") + "\n")
+	peakHour := r.PeakHour()
+	for _, row := range r.HeatRows() {
+		fmt.Fprintf(&b, "%s ", row.Day)
+		for _, s := range row.Hours {
+			b.WriteString(heatShade(s.TotalCost, peakHour))
+		}
+		fmt.Fprintf(&b, "  %s  %s req\n", format.Cost(row.Total.TotalCost), format.Int(row.Total.TotalRequests))
+	}
+	b.WriteString("```\n\n")
+	if busiest := r.Busiest(); busiest != "" {
+		fmt.Fprintf(&b, "_Local time, shaded by cost against the busiest hour: %s._\n\n", busiest)
+	}
+
+	fmt.Fprintf(&b, "## Top %d most expensive requests\n\n", len(r.Top))
+	b.WriteString("| Time | Model | Input | Output | Latency | Status | Cost |\n")
+	b.WriteString("|---|---|--:|--:|--:|---|--:|\n")
+	for _, req := range r.Top {
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %.1fs | %s | %s |\n",
+			req.Timestamp.Format("2006-01-02 15:04:05"), req.Model,
+			format.Tokens(req.InputTokens), format.Tokens(req.OutputTokens),
+			req.Latency.Seconds(), status(req), format.Cost(req.Cost))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,60 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"miser/internal/tracker"
+)
+
+func sampleTracker() *tracker.Tracker {
+	t := tracker.New()
+	base := time.Date(2026, 10, 12, 10, 0, 0, 0, time.Local)
+	t.Load([]tracker.Request{
+		{Timestamp: base, Model: "claude-sonnet-4-6", Cost: 0.05, StatusCode: 200},
+		{Timestamp: base.Add(25 * time.Hour), Model: "claude-opus-4-6", Cost: 0.50, StatusCode: 200},
+		{Timestamp: base.Add(26 * time.Hour), Model: "<script>", Cost: 0.10, Error: "boom"},
+	})
+	return t
+}
+
+func TestBuildTopRequests(t *testing.T) {
+	r := Build(sampleTracker(), time.Time{}, 2)
+	if len(r.Top) != 2 || r.Top[0].Cost != 0.50 || r.Top[1].Cost != 0.10 {
+		t.Fatalf("top = %+v", r.Top)
+	}
+	if len(r.Days) != 2 || r.Summary.TotalRequests != 3 {
+		t.Fatalf("days = %d, summary = %+v", len(r.Days), r.Summary)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	r := Build(sampleTracker(), time.Time{}, 10)
+
+	var md bytes.Buffer
+	if err := Write(&md, Markdown, r); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"## By model", "| claude-opus-4-6 |", "2026-10-13 Tue", "| ERR |",
+		"Tue " + strings.Repeat("··", 11) + "██░░", "busiest hour: Tue 11:00, $0.5000 over 1 requests"} {
+		if !strings.Contains(md.String(), want) {
+			t.Errorf("markdown missing %q", want)
+		}
+	}
+
+	var html bytes.Buffer
+	if err := Write(&html, HTML, r); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(html.String(), "<script>") {
+		t.Error("model name was not escaped")
+	}
+	if !strings.Contains(html.String(), `style="width:100.0%"`) {
+		t.Error("daily chart bar missing")
+	}
+	if !strings.Contains(html.String(), `title="Tue 11:00: $0.5000, 1 req" style="background:rgba(47,158,110,1.00)"`) {
+		t.Error("heatmap peak cell missing")
+	}
+}
@@ -0,0 +1,208 @@
+// Package schedule computes when recurring jobs run: five-field cron
+// expressions or fixed intervals.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule yields run times.
+type Schedule interface {
+	// Next returns the first run time strictly after t, or the zero time
+	// if there is none within five years.
+	Next(t time.Time) time.Time
+	// Prev returns the last run time strictly before t, or the zero time.
+	Prev(t time.Time) time.Time
+}
+
+// Every runs at a fixed interval.
+type Every time.Duration
+
+func (e Every) Next(t time.Time) time.Time { return t.Add(time.Duration(e)) }
+func (e Every) Prev(t time.Time) time.Time { return t.Add(-time.Duration(e)) }
+
+// searchYears bounds the search for a matching time, so expressions such
+// as "0 0 30 2 *" (February 30th) end instead of looping.
+const searchYears = 5
+
+var shortcuts = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Cron is a parsed cron expression, evaluated in the location of the
+// times passed to it.
+type Cron struct {
+	minute, hour, dom, month, dow uint64 // bit sets
+	domAny, dowAny                bool
+}
+
+// ParseCron parses "minute hour day-of-month month day-of-week" with *,
+// lists, ranges and steps (e.g. "*/15 9-17 * * 1-5"), or a shortcut such
+// as @daily or @hourly. Day of week 0 and 7 are Sunday. When both day
+// fields are restricted, a day matching either runs, as in cron(8).
+func ParseCron(expr string) (*Cron, error) {
+	if s, ok := shortcuts[strings.TrimSpace(expr)]; ok {
+		expr = s
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron %q: want 5 fields (minute hour day month weekday), got %d", expr, len(fields))
+	}
+	var c Cron
+	var err error
+	parse := func(i int, lo, hi int) uint64 {
+		if err != nil {
+			return 0
+		}
+		var bits uint64
+		bits, err = parseField(fields[i], lo, hi)
+		if err != nil {
+			err = fmt.Errorf("cron %q: field %d: %w", expr, i+1, err)
+		}
+		return bits
+	}
+	c.minute = parse(0, 0, 59)
+	c.hour = parse(1, 0, 23)
+	c.dom = parse(2, 1, 31)
+	c.month = parse(3, 1, 12)
+	c.dow = parse(4, 0, 7)
+	if err != nil {
+		return nil, err
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1 // 7 is Sunday too
+	}
+	c.domAny = fields[2] == "*"
+	c.dowAny = fields[4] == "*"
+	return &c, nil
+}
+
+func parseField(f string, lo, hi int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(f, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("bad step %q", stepStr)
+			}
+			step = n
+		}
+		start, end := lo, hi
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if start, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("bad value %q", a)
+			}
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("bad value %q", b)
+				}
+			} else if hasStep {
+				end = hi // "5/15" means from 5 on
+			}
+		}
+		if start < lo || end > hi || start > end {
+			return 0, fmt.Errorf("%q is outside %d-%d", part, lo, hi)
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (c *Cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(searchYears, 0, 0)
+	for t.Before(limit) {
+		y, m, d := t.Date()
+		var next time.Time
+		switch {
+		case c.month&(1<<int(m)) == 0:
+			next = date(y, m+1, 1, loc)
+		case !c.dayMatches(t):
+			next = date(y, m, d+1, loc)
+		case c.hour&(1<<t.Hour()) == 0:
+			// Add rather than time.Date: across a DST change the next
+			// hour's wall time may not exist, or exist twice.
+			next = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case c.minute&(1<<t.Minute()) == 0:
+			next = t.Add(time.Minute)
+		default:
+			return t
+		}
+		if !next.After(t) {
+			next = t.Add(time.Minute) // never stall, whatever the zone does
+		}
+		t = next
+	}
+	return time.Time{}
+}
+
+func (c *Cron) Prev(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Add(-1).Truncate(time.Minute)
+	limit := t.AddDate(-searchYears, 0, 0)
+	for t.After(limit) {
+		y, m, d := t.Date()
+		var prev time.Time
+		switch {
+		case c.month&(1<<int(m)) == 0:
+			prev = date(y, m, 1, loc).Add(-time.Minute)
+		case !c.dayMatches(t):
+			prev = date(y, m, d, loc).Add(-time.Minute)
+		case c.hour&(1<<t.Hour()) == 0:
+			prev = t.Add(-time.Duration(t.Minute()+1) * time.Minute)
+		case c.minute&(1<<t.Minute()) == 0:
+			prev = t.Add(-time.Minute)
+		default:
+			return t
+		}
+		if !prev.Before(t) {
+			prev = t.Add(-time.Minute)
+		}
+		t = prev
+	}
+	return time.Time{}
+}
+
+// date returns the start of day d of month m. Where a DST change skips
+// midnight, that is the end of the gap; time.Date would move it back an
+// hour, into the day before.
+func date(y int, m time.Month, d int, loc *time.Location) time.Time {
+	t := time.Date(y, m, d, 0, 0, 0, 0, loc)
+	want := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	for wall(t).Before(want) {
+		t = t.Add(time.Minute)
+	}
+	return t
+}
+
+// wall is t's wall-clock time, as if in UTC.
+func wall(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
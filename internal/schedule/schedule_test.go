@@ -0,0 +1,99 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCron(t *testing.T) {
+	// Wednesday.
+	at := time.Date(2026, 1, 14, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		expr       string
+		next, prev string
+	}{
+		{"0 9 * * *", "2026-01-15 09:00", "2026-01-14 09:00"},
+		{"@hourly", "2026-01-14 11:00", "2026-01-14 10:00"},
+		{"*/15 * * * *", "2026-01-14 10:45", "2026-01-14 10:15"},
+		{"30 10 * * *", "2026-01-15 10:30", "2026-01-13 10:30"},
+		{"0 9 * * 1-5", "2026-01-15 09:00", "2026-01-14 09:00"},
+		{"0 9 * * 1", "2026-01-19 09:00", "2026-01-12 09:00"},
+		{"0 9 * * 7", "2026-01-18 09:00", "2026-01-11 09:00"},
+		{"0 0 1 * *", "2026-02-01 00:00", "2026-01-01 00:00"},
+		{"0 0 29 2 *", "2028-02-29 00:00", "2024-02-29 00:00"},
+		// Either day field matches when both are restricted.
+		{"0 0 20 * 5", "2026-01-16 00:00", "2026-01-09 00:00"},
+		{"5/20 8,12 * * *", "2026-01-14 12:05", "2026-01-14 08:45"},
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if got := c.Next(at).Format("2006-01-02 15:04"); got != tt.next {
+			t.Errorf("%s: Next = %s, want %s", tt.expr, got, tt.next)
+		}
+		if got := c.Prev(at).Format("2006-01-02 15:04"); got != tt.prev {
+			t.Errorf("%s: Prev = %s, want %s", tt.expr, got, tt.prev)
+		}
+	}
+
+	c, _ := ParseCron("0 0 30 2 *")
+	if !c.Next(at).IsZero() {
+		t.Error("February 30th should never run")
+	}
+	for _, bad := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseCron(bad); err == nil {
+			t.Errorf("%q: want error", bad)
+		}
+	}
+}
+
+func TestCronDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	santiago, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		expr string
+		from time.Time
+		next bool
+		want string
+	}{
+		// Spring forward: 02:00 to 03:00 on March 8th.
+		{"0 9 * * *", time.Date(2026, 3, 7, 9, 0, 0, 0, ny), true, "2026-03-08 09:00 EDT"},
+		{"0 9 * * *", time.Date(2026, 3, 9, 8, 0, 0, 0, ny), false, "2026-03-08 09:00 EDT"},
+		{"30 2 * * *", time.Date(2026, 3, 8, 0, 0, 0, 0, ny), true, "2026-03-09 02:30 EDT"},
+		{"@hourly", time.Date(2026, 3, 8, 1, 30, 0, 0, ny), true, "2026-03-08 03:00 EDT"},
+		{"@hourly", time.Date(2026, 3, 8, 3, 0, 0, 0, ny), false, "2026-03-08 01:00 EST"},
+		// Fall back: 02:00 to 01:00 on November 1st.
+		{"0 9 * * *", time.Date(2026, 10, 31, 9, 0, 0, 0, ny), true, "2026-11-01 09:00 EST"},
+		{"0 9 * * *", time.Date(2026, 11, 2, 8, 0, 0, 0, ny), false, "2026-11-01 09:00 EST"},
+		{"0 0 * * *", time.Date(2026, 11, 1, 1, 30, 0, 0, ny).Add(time.Hour), false, "2026-11-01 00:00 EDT"},
+		{"0 3 * * *", time.Date(2026, 11, 1, 1, 30, 0, 0, ny), true, "2026-11-01 03:00 EST"},
+		// Midnight skipped: 00:00 to 01:00 on September 6th.
+		{"0 0 * * *", time.Date(2026, 9, 5, 12, 0, 0, 0, santiago), true, "2026-09-07 00:00 -03"},
+		{"0 12 * * *", time.Date(2026, 9, 5, 13, 0, 0, 0, santiago), true, "2026-09-06 12:00 -03"},
+		{"0 23 * * *", time.Date(2026, 9, 6, 12, 0, 0, 0, santiago), false, "2026-09-05 23:00 -04"},
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got time.Time
+		if tt.next {
+			got = c.Next(tt.from)
+		} else {
+			got = c.Prev(tt.from)
+		}
+		if s := got.Format("2006-01-02 15:04 MST"); s != tt.want {
+			t.Errorf("%s from %s: next=%v got %s, want %s", tt.expr, tt.from, tt.next, s, tt.want)
+		}
+	}
+}
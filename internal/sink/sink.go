@@ -0,0 +1,316 @@
+// Package sink forwards every recorded request to a central collector as
+// it happens. Requests wait in a spool file until the collector accepts
+// them, so tracking keeps working offline, and across restarts, and the
+// collector catches up once it is reachable again.
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"miser/internal/export"
+	"miser/internal/tracker"
+)
+
+// Forwarding tuning. Batches go out about once a second; the backlog
+// covers a long outage, after which the oldest requests are dropped.
+const (
+	sendInterval = time.Second
+	maxBatch     = 500
+	maxBacklog   = 100_000
+	maxBackoff   = time.Minute
+)
+
+// FlushTimeout bounds the last send a Forwarder makes when it is stopped.
+const FlushTimeout = 5 * time.Second
+
+// Sink delivers a batch of requests to a collector. A batch that fails is
+// sent again, so a collector should tolerate seeing one twice; the
+// requests' IDs and times identify them.
+type Sink interface {
+	Send(ctx context.Context, batch []tracker.Request) error
+}
+
+// HTTP POSTs each batch to URL as NDJSON, one full request per line.
+type HTTP struct {
+	URL    string
+	Header http.Header // extra request headers, e.g. Authorization
+	Client *http.Client
+}
+
+func (h *HTTP) Send(ctx context.Context, batch []tracker.Request) error {
+	var buf bytes.Buffer
+	if err := export.Write(&buf, export.NDJSON, batch); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, &buf)
+	if err != nil {
+		return err
+	}
+	for k, vv := range h.Header {
+		for _, v := range vv {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set("Content-Type", export.NDJSON.ContentType())
+	req.Header.Set("User-Agent", "miser")
+	hc := h.Client
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if m := strings.TrimSpace(string(msg)); m != "" {
+		return fmt.Errorf("POST %s: %s: %s", req.URL.Redacted(), resp.Status, m)
+	}
+	return fmt.Errorf("POST %s: %s", req.URL.Redacted(), resp.Status)
+}
+
+// Forwarder sends the requests given to Add to a sink, oldest first,
+// retrying with backoff while it fails.
+type Forwarder struct {
+	to    Sink
+	spool string        // "" keeps the backlog in memory only
+	every time.Duration // sendInterval, shorter in tests
+	// Done, if set, is told about each send: how many requests went, or
+	// why they didn't and how many are waiting.
+	Done func(n int, err error, backlog int)
+
+	mu      sync.Mutex
+	pending []tracker.Request
+	file    *os.File // the spool, appended to by Add
+	stale   int      // lines at the head of the spool already sent or dropped
+	dropped int      // oldest requests discarded when the backlog was full
+	backoff bool     // the last send failed; Add doesn't hurry the next
+	wake    chan struct{}
+}
+
+// NewForwarder returns a forwarder to to whose backlog is kept in the
+// file at spool, picking up whatever an earlier run left there.
+func NewForwarder(to Sink, spool string) (*Forwarder, error) {
+	f := &Forwarder{to: to, spool: spool, every: sendInterval, wake: make(chan struct{}, 1)}
+	if spool == "" {
+		return f, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(spool), 0o700); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(spool)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, 64<<20)
+	for sc.Scan() {
+		var r tracker.Request
+		if json.Unmarshal(sc.Bytes(), &r) == nil {
+			f.pending = append(f.pending, r) // a torn last line is skipped
+		}
+	}
+	if len(f.pending) > maxBacklog {
+		f.dropped = len(f.pending) - maxBacklog
+		f.pending = f.pending[f.dropped:]
+	}
+	if err := f.rewrite(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Backlog returns how many requests are waiting to be sent.
+func (f *Forwarder) Backlog() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.pending)
+}
+
+// Dropped returns how many requests were discarded because the backlog was
+// full while the sink was unreachable.
+func (f *Forwarder) Dropped() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.dropped
+}
+
+// Add queues a request; it is a tracker subscriber. A request an
+// upstream miser counted is left to that miser to report.
+func (f *Forwarder) Add(r tracker.Request) {
+	if r.CountedUpstream() {
+		return
+	}
+	f.mu.Lock()
+	if len(f.pending) >= maxBacklog {
+		f.pending = f.pending[1:]
+		f.dropped++
+		f.stale++
+	}
+	f.pending = append(f.pending, r)
+	if f.file != nil {
+		if line, err := json.Marshal(r); err == nil {
+			f.file.Write(append(line, '\n'))
+		}
+	}
+	full := len(f.pending) >= maxBatch && !f.backoff
+	f.mu.Unlock()
+	if full {
+		select {
+		case f.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run sends queued requests every interval until ctx is done, then makes
+// one last attempt to send what is left. What still isn't sent stays in
+// the spool for the next run.
+func (f *Forwarder) Run(ctx context.Context) {
+	defer f.close()
+	wait := f.every
+	for {
+		select {
+		case <-ctx.Done():
+			final, cancel := context.WithTimeout(context.Background(), FlushTimeout)
+			defer cancel()
+			for f.Backlog() > 0 {
+				if f.send(final) != nil {
+					break
+				}
+			}
+			return
+		case <-time.After(wait):
+		case <-f.wake:
+		}
+		if err := f.send(ctx); err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			wait = min(wait*2, maxBackoff)
+			continue
+		}
+		wait = f.every
+		if f.Backlog() >= maxBatch {
+			wait = 0 // catching up after an outage
+		}
+	}
+}
+
+// send delivers the oldest batch and drops it from the backlog.
+func (f *Forwarder) send(ctx context.Context) error {
+	f.mu.Lock()
+	batch := append([]tracker.Request{}, f.pending[:min(len(f.pending), maxBatch)]...)
+	dropped := f.dropped
+	f.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	if err := f.to.Send(ctx, batch); err != nil {
+		f.mu.Lock()
+		f.backoff = ctx.Err() == nil
+		backlog := len(f.pending)
+		f.mu.Unlock()
+		if ctx.Err() == nil && f.Done != nil {
+			f.Done(0, err, backlog)
+		}
+		return err
+	}
+	f.mu.Lock()
+	f.backoff = false
+	// Add may have dropped some of the batch from the front meanwhile.
+	sent := max(len(batch)-(f.dropped-dropped), 0)
+	f.pending = f.pending[sent:]
+	f.stale += sent
+	err := f.compact()
+	backlog := len(f.pending)
+	f.mu.Unlock()
+	if err != nil {
+		err = fmt.Errorf("spool: %w", err)
+	}
+	if f.Done != nil {
+		f.Done(len(batch), err, backlog)
+	}
+	return nil
+}
+
+// compact drops the sent requests from the spool once they outnumber the
+// waiting ones, so each line is rewritten a bounded number of times; an
+// emptied spool is just truncated. It is called with mu held.
+func (f *Forwarder) compact() error {
+	if f.file == nil || f.stale <= len(f.pending) {
+		return nil
+	}
+	if len(f.pending) == 0 {
+		if err := f.file.Truncate(0); err != nil {
+			return err
+		}
+		f.stale = 0
+		return nil
+	}
+	return f.rewrite()
+}
+
+// rewrite replaces the spool with the backlog, leaving it open for Add. On
+// failure the old spool stays open as it was, sent lines and all. It is
+// called with mu held, or before the forwarder is shared.
+func (f *Forwarder) rewrite() error {
+	if f.spool == "" {
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range f.pending {
+		enc.Encode(r)
+	}
+	tmp := f.spool + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, f.spool); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file, f.stale = file, 0
+	return nil
+}
+
+// close compacts the spool, so the next run doesn't resend what this one
+// sent, and closes it.
+func (f *Forwarder) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stale > 0 {
+		f.rewrite()
+	}
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
@@ -0,0 +1,187 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"miser/internal/tracker"
+)
+
+func TestForwarderSpoolsUntilDelivered(t *testing.T) {
+	var mu sync.Mutex
+	var got []int
+	down := true
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if down {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		sc := bufio.NewScanner(r.Body)
+		for sc.Scan() {
+			var row struct {
+				ID int `json:"id"`
+			}
+			json.Unmarshal(sc.Bytes(), &row)
+			got = append(got, row.ID)
+		}
+	}))
+	defer collector.Close()
+	to := &HTTP{URL: collector.URL}
+	spool := filepath.Join(t.TempDir(), "sink.jsonl")
+
+	// The collector is down for the whole first run.
+	f, err := NewForwarder(to, spool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.every = 10 * time.Millisecond
+	failed := 0
+	f.Done = func(_ int, err error, _ int) {
+		if err != nil {
+			failed++
+		}
+	}
+	for id := 1; id <= 3; id++ {
+		f.Add(tracker.Request{ID: id, Timestamp: time.Now()})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	f.Run(ctx)
+	cancel()
+	if failed == 0 || f.Backlog() != 3 {
+		t.Fatalf("first run: %d failed sends, %d waiting", failed, f.Backlog())
+	}
+
+	// The next run picks the backlog up from the spool and delivers it.
+	mu.Lock()
+	down = false
+	mu.Unlock()
+	f, err = NewForwarder(to, spool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.every = 10 * time.Millisecond
+	if f.Backlog() != 3 {
+		t.Fatalf("reopened spool holds %d requests, want 3", f.Backlog())
+	}
+	f.Add(tracker.Request{ID: 1, Timestamp: time.Now()}) // IDs restart with the new run
+	ctx, cancel = context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f.Run(ctx)
+	}()
+	deadline := time.Now().Add(10 * time.Second)
+	for f.Backlog() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 4 || got[0] != 1 || got[2] != 3 || got[3] != 1 {
+		t.Errorf("collector got %v", got)
+	}
+	if f, _ := NewForwarder(to, spool); f.Backlog() != 0 {
+		t.Errorf("spool still holds %d requests after delivery", f.Backlog())
+	}
+}
+
+func TestForwarderSkipsCountedUpstream(t *testing.T) {
+	f, err := NewForwarder(&HTTP{URL: "http://127.0.0.1:0"}, filepath.Join(t.TempDir(), "sink.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Add(tracker.Request{ID: 1, Timestamp: time.Now(), CountedBy: "gateway:8080-1a2b3c4d"})
+	f.Add(tracker.Request{ID: 2, Timestamp: time.Now()})
+	if n := f.Backlog(); n != 1 {
+		t.Errorf("backlog = %d, want 1: the counted request is the gateway's to forward", n)
+	}
+}
+
+// sinkFunc adapts a function to Sink.
+type sinkFunc func(context.Context, []tracker.Request) error
+
+func (fn sinkFunc) Send(ctx context.Context, batch []tracker.Request) error { return fn(ctx, batch) }
+
+func TestForwarderBacksOffWhileFull(t *testing.T) {
+	var mu sync.Mutex
+	sends := 0
+	f, err := NewForwarder(sinkFunc(func(context.Context, []tracker.Request) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sends++
+		return errors.New("down")
+	}), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.every = 20 * time.Millisecond
+	for id := range maxBatch {
+		f.Add(tracker.Request{ID: id, Timestamp: time.Now()})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f.Run(ctx)
+	}()
+	// A full backlog keeps growing, but mustn't hurry the retries.
+	for id := range 100 {
+		f.Add(tracker.Request{ID: maxBatch + id, Timestamp: time.Now()})
+		time.Sleep(time.Millisecond)
+	}
+	mu.Lock()
+	n := sends
+	mu.Unlock()
+	cancel()
+	<-done
+	if n > 4 {
+		t.Errorf("%d sends in about 100ms of backoff, want at most 4", n)
+	}
+}
+
+func TestForwarderKeepsSpoolingWhenCompactionFails(t *testing.T) {
+	spool := filepath.Join(t.TempDir(), "sink.jsonl")
+	f, err := NewForwarder(sinkFunc(func(context.Context, []tracker.Request) error { return nil }), spool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spoolErr error
+	f.Done = func(_ int, err error, _ int) { spoolErr = err }
+	for id := range maxBatch + 1 {
+		f.Add(tracker.Request{ID: id, Timestamp: time.Now()})
+	}
+	// A directory in the way of the temporary file makes the rewrite fail.
+	if err := os.Mkdir(spool+".tmp", 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.send(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if spoolErr == nil {
+		t.Error("failed compaction not reported")
+	}
+	f.Add(tracker.Request{ID: maxBatch + 1, Timestamp: time.Now()})
+	os.Remove(spool + ".tmp")
+	f.close()
+
+	f, err = NewForwarder(sinkFunc(func(context.Context, []tracker.Request) error { return nil }), spool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := f.Backlog(); n != 2 {
+		t.Errorf("reopened spool holds %d requests, want the 2 unsent", n)
+	}
+}
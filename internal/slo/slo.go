@@ -0,0 +1,129 @@
+// Package slo checks per-model latency objectives, such as "95% of
+// requests to claude-opus-* finish within 20s", over a rolling window.
+package slo
+
+import (
+	"cmp"
+	"math"
+	"path"
+	"slices"
+	"strconv"
+	"time"
+
+	"miser/internal/tracker"
+)
+
+// Objective is the latency target for the models matching Pattern.
+type Objective struct {
+	Pattern     string        // model glob
+	Latency     time.Duration // a request meets the objective if it finishes within this
+	Percentile  float64       // the percent of requests that must
+	Window      time.Duration // how far back compliance is measured
+	MinRequests int           // a window with fewer isn't judged
+	Alert       bool          // notify when breached
+}
+
+// String describes o, e.g. "p95 < 20s over 1h0m0s".
+func (o Objective) String() string {
+	return "p" + strconv.FormatFloat(o.Percentile, 'f', -1, 64) + " < " + o.Latency.String() + " over " + o.Window.String()
+}
+
+// Match returns the objective for model; the longest matching glob wins.
+func Match(objs []Objective, model string) (Objective, bool) {
+	var best Objective
+	found := false
+	for _, o := range objs {
+		if m, _ := path.Match(o.Pattern, model); !m {
+			continue
+		}
+		if !found || cmp.Or(cmp.Compare(len(o.Pattern), len(best.Pattern)), cmp.Compare(best.Pattern, o.Pattern)) > 0 {
+			best, found = o, true
+		}
+	}
+	return best, found
+}
+
+// Status is how a model is doing against its objective.
+type Status struct {
+	Model     string
+	Objective Objective
+	Requests  int           // counted in the window
+	Within    int           // of those, how many met the latency
+	Observed  time.Duration // the latency at the objective's percentile
+}
+
+// Compliance is the fraction of requests that met the latency, 1 with none.
+func (s Status) Compliance() float64 {
+	if s.Requests == 0 {
+		return 1
+	}
+	return float64(s.Within) / float64(s.Requests)
+}
+
+// Judged reports whether the window held enough requests to tell.
+func (s Status) Judged() bool {
+	return s.Requests > 0 && s.Requests >= s.Objective.MinRequests
+}
+
+// Breached reports whether too few requests met the latency.
+func (s Status) Breached() bool {
+	return s.Judged() && s.Compliance()*100 < s.Objective.Percentile
+}
+
+// Counted reports whether r is measured against its model's objective.
+// Failed, cancelled, cut-short and retried requests say nothing about how
+// long upstream takes to answer, and are left out.
+func Counted(r tracker.Request) bool {
+	return r.Latency > 0 && !r.Failed() && !r.Cancelled() && !r.Partial() && !r.Retried()
+}
+
+// Measure checks the requests for model in reqs that fall in o's window
+// ending at now.
+func Measure(o Objective, model string, reqs []tracker.Request, now time.Time) Status {
+	s := Status{Model: model, Objective: o}
+	cutoff := now.Add(-o.Window)
+	var ds []time.Duration
+	for _, r := range reqs {
+		if r.Model != model || r.Timestamp.Before(cutoff) || r.Timestamp.After(now) || !Counted(r) {
+			continue
+		}
+		s.Requests++
+		if r.Latency <= o.Latency {
+			s.Within++
+		}
+		ds = append(ds, r.Latency)
+	}
+	if len(ds) > 0 {
+		slices.Sort(ds)
+		rank := int(math.Ceil(o.Percentile / 100 * float64(len(ds)))) // nearest rank
+		s.Observed = ds[min(max(rank, 1), len(ds))-1]
+	}
+	return s
+}
+
+// Evaluate checks every model with an objective that t has requests for in
+// its window, keyed by model.
+func Evaluate(t *tracker.Tracker, objs []Objective, now time.Time) map[string]Status {
+	if len(objs) == 0 {
+		return nil
+	}
+	var longest time.Duration
+	for _, o := range objs {
+		longest = max(longest, o.Window)
+	}
+	reqs := t.GetRequestsSince(now.Add(-longest))
+	out := make(map[string]Status)
+	seen := make(map[string]bool)
+	for _, r := range reqs {
+		if seen[r.Model] {
+			continue
+		}
+		seen[r.Model] = true
+		if o, ok := Match(objs, r.Model); ok {
+			if s := Measure(o, r.Model, reqs, now); s.Requests > 0 {
+				out[r.Model] = s
+			}
+		}
+	}
+	return out
+}
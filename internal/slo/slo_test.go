@@ -0,0 +1,62 @@
+package slo
+
+import (
+	"testing"
+	"time"
+
+	"miser/internal/tracker"
+)
+
+func TestMatch(t *testing.T) {
+	objs := []Objective{{Pattern: "claude-*"}, {Pattern: "claude-opus-*"}, {Pattern: "gpt-*"}}
+	if o, ok := Match(objs, "claude-opus-4-6"); !ok || o.Pattern != "claude-opus-*" {
+		t.Errorf("Match(claude-opus-4-6) = %q, %v", o.Pattern, ok)
+	}
+	if o, ok := Match(objs, "claude-haiku-4-5"); !ok || o.Pattern != "claude-*" {
+		t.Errorf("Match(claude-haiku-4-5) = %q, %v", o.Pattern, ok)
+	}
+	if _, ok := Match(objs, "gemini-2.5-pro"); ok {
+		t.Error("Match(gemini-2.5-pro) matched")
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	now := time.Now()
+	tr := tracker.New()
+	record := func(model string, ago, latency time.Duration, status int) {
+		tr.Record(tracker.Request{Timestamp: now.Add(-ago), Model: model, Latency: latency, StatusCode: status})
+	}
+	record("claude-opus-4-6", 2*time.Hour, time.Minute, 200) // outside the window
+	for i := range 9 {
+		record("claude-opus-4-6", time.Duration(i)*time.Minute, time.Duration(i+1)*time.Second, 200)
+	}
+	record("claude-opus-4-6", time.Minute, 40*time.Second, 200)
+	record("claude-opus-4-6", time.Minute, 90*time.Second, 529) // failed: not counted
+	record("claude-haiku-4-5", time.Minute, time.Second, 200)
+	record("gpt-5", time.Minute, time.Hour, 200)
+
+	objs := []Objective{
+		{Pattern: "claude-opus-*", Latency: 10 * time.Second, Percentile: 95, Window: time.Hour, MinRequests: 5},
+		{Pattern: "claude-haiku-*", Latency: 5 * time.Second, Percentile: 95, Window: time.Hour, MinRequests: 5},
+	}
+	got := Evaluate(tr, objs, now)
+	if len(got) != 2 {
+		t.Fatalf("statuses = %+v", got)
+	}
+	opus := got["claude-opus-4-6"]
+	if opus.Requests != 10 || opus.Within != 9 || opus.Observed != 40*time.Second {
+		t.Errorf("opus = %+v", opus)
+	}
+	if !opus.Breached() { // 90% within, p95 wanted
+		t.Error("opus not breached")
+	}
+	haiku := got["claude-haiku-4-5"]
+	if haiku.Judged() || haiku.Breached() {
+		t.Errorf("haiku with 1 request judged: %+v", haiku)
+	}
+
+	objs[0].Percentile = 90
+	if s := Evaluate(tr, objs, now)["claude-opus-4-6"]; s.Breached() || s.Observed != 9*time.Second {
+		t.Errorf("at p90: %+v", s)
+	}
+}
@@ -0,0 +1,281 @@
+package sqlite
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+const (
+	pageSize = 4096
+
+	// Page types.
+	indexInterior = 0x02
+	tableInterior = 0x05
+	indexLeaf     = 0x0a
+	tableLeaf     = 0x0d
+
+	// How much of a payload stays on its b-tree page before the rest
+	// spills into overflow pages; see "Cell Payload Overflow Pages" in
+	// the file format documentation.
+	tableMaxLocal = pageSize - 35
+	indexMaxLocal = (pageSize-12)*64/255 - 23
+	minLocal      = (pageSize-12)*32/255 - 23
+)
+
+// builder lays out pages; pages[0] is page 1.
+type builder struct {
+	pages [][]byte
+}
+
+func (b *builder) alloc() (int, []byte) {
+	p := make([]byte, pageSize)
+	b.pages = append(b.pages, p)
+	return len(b.pages), p
+}
+
+// spill returns the part of payload kept in the cell, moving the rest to
+// a chain of overflow pages whose first page number ends the cell.
+func (b *builder) spill(payload []byte, maxLocal int) []byte {
+	if len(payload) <= maxLocal {
+		return payload
+	}
+	local := minLocal + (len(payload)-minLocal)%(pageSize-4)
+	if local > maxLocal {
+		local = minLocal
+	}
+	cell := append([]byte(nil), payload[:local]...)
+	var prev []byte
+	for rest := payload[local:]; len(rest) > 0; {
+		n, p := b.alloc()
+		if prev == nil {
+			cell = binary.BigEndian.AppendUint32(cell, uint32(n))
+		} else {
+			binary.BigEndian.PutUint32(prev, uint32(n))
+		}
+		rest = rest[copy(p[4:], rest):]
+		prev = p[:4]
+	}
+	return cell
+}
+
+// fill writes a b-tree page: the header at off (100 on page 1), the cell
+// pointers after it, and the cells packed against the end of the page.
+func fill(page []byte, off int, kind byte, cells [][]byte, right int) {
+	h := page[off:]
+	h[0] = kind
+	binary.BigEndian.PutUint16(h[3:], uint16(len(cells)))
+	ptr := off + 8
+	if kind == tableInterior || kind == indexInterior {
+		binary.BigEndian.PutUint32(h[8:], uint32(right))
+		ptr += 4
+	}
+	end := pageSize
+	for _, c := range cells {
+		end -= len(c)
+		copy(page[end:], c)
+		binary.BigEndian.PutUint16(page[ptr:], uint16(end))
+		ptr += 2
+	}
+	binary.BigEndian.PutUint16(h[5:], uint16(end))
+}
+
+func headerSize(kind byte) int {
+	if kind == tableInterior || kind == indexInterior {
+		return 12
+	}
+	return 8
+}
+
+func (b *builder) emit(kind byte, cells [][]byte, right int) int {
+	n, p := b.alloc()
+	fill(p, 0, kind, cells, right)
+	return n
+}
+
+// table writes t's rows and returns its root page.
+func (b *builder) table(t Table) (int, error) {
+	type node struct {
+		page   int
+		maxKey int64
+	}
+	var nodes []node
+	var cells [][]byte
+	used := headerSize(tableLeaf)
+	var last int64
+	flush := func() {
+		nodes = append(nodes, node{b.emit(tableLeaf, cells, 0), last})
+		cells, used = nil, headerSize(tableLeaf)
+	}
+	for i, row := range t.Rows {
+		rowid := int64(i + 1)
+		payload, err := record(row)
+		if err != nil {
+			return 0, fmt.Errorf("row %d: %w", i+1, err)
+		}
+		cell := appendVarint(nil, uint64(len(payload)))
+		cell = appendVarint(cell, uint64(rowid))
+		cell = append(cell, b.spill(payload, tableMaxLocal)...)
+		if used+2+len(cell) > pageSize {
+			flush()
+		}
+		cells = append(cells, cell)
+		used += 2 + len(cell)
+		last = rowid
+	}
+	flush()
+
+	for len(nodes) > 1 {
+		var up []node
+		cells, used = nil, headerSize(tableInterior)
+		closePage := func(right node) {
+			up = append(up, node{b.emit(tableInterior, cells, right.page), right.maxKey})
+			cells, used = nil, headerSize(tableInterior)
+		}
+		cellOf := func(n node) []byte {
+			return appendVarint(binary.BigEndian.AppendUint32(nil, uint32(n.page)), uint64(n.maxKey))
+		}
+		for j := 0; j < len(nodes)-1; j++ {
+			c := cellOf(nodes[j])
+			if used+2+len(c) <= pageSize {
+				cells = append(cells, c)
+				used += 2 + len(c)
+				continue
+			}
+			if j == len(nodes)-2 {
+				// Closing with nodes[j] would leave a page holding only
+				// the last child, so close one child early instead.
+				cells = cells[:len(cells)-1]
+				closePage(nodes[j-1])
+				cells, used = [][]byte{c}, headerSize(tableInterior)+2+len(c)
+				continue
+			}
+			closePage(nodes[j])
+		}
+		closePage(nodes[len(nodes)-1])
+		nodes = up
+	}
+	return nodes[0].page, nil
+}
+
+// index writes the entries of ix on t, sorted, and returns the root page.
+// Unlike a table, an index b-tree keeps entries on its interior pages: each
+// separates the children on either side of it.
+func (b *builder) index(t Table, ix Index) (int, error) {
+	entries := make([][]any, len(t.Rows))
+	for i, row := range t.Rows {
+		e := make([]any, 0, len(ix.Columns)+1)
+		for _, c := range ix.Columns {
+			if c < 0 || c >= len(row) {
+				return 0, fmt.Errorf("column %d is out of range", c)
+			}
+			e = append(e, row[c])
+		}
+		entries[i] = append(e, int64(i+1)) // the rowid
+	}
+	slices.SortFunc(entries, compareRows)
+
+	cells := make([][]byte, len(entries))
+	for i, e := range entries {
+		payload, err := record(e)
+		if err != nil {
+			return 0, err
+		}
+		cells[i] = append(appendVarint(nil, uint64(len(payload))), b.spill(payload, indexMaxLocal)...)
+	}
+	pages, seps := b.indexLevel(indexLeaf, cells, nil)
+	for len(pages) > 1 {
+		for i, s := range seps {
+			seps[i] = append(binary.BigEndian.AppendUint32(nil, uint32(pages[i])), s...)
+		}
+		pages, seps = b.indexLevel(indexInterior, seps, pages[len(pages)-1:])
+	}
+	return pages[0], nil
+}
+
+// indexLevel packs cells into pages of kind, in order, and returns the
+// pages with the cells that separate them, which move up a level. For
+// interior pages, right holds the last child, which closes the last page.
+func (b *builder) indexLevel(kind byte, cells [][]byte, right []int) (pages []int, seps [][]byte) {
+	var cur [][]byte
+	used := headerSize(kind)
+	// rightOf is the child a page ends with when cells[j] is promoted
+	// out of it: the left child of that cell.
+	rightOf := func(j int) int {
+		if kind == indexLeaf {
+			return 0
+		}
+		return int(binary.BigEndian.Uint32(cells[j]))
+	}
+	sep := func(j int) []byte {
+		if kind == indexLeaf {
+			return cells[j]
+		}
+		return cells[j][4:]
+	}
+	for j, c := range cells {
+		if used+2+len(c) <= pageSize {
+			cur = append(cur, c)
+			used += 2 + len(c)
+			continue
+		}
+		if j == len(cells)-1 {
+			// Promoting cells[j] would leave the last page empty, so
+			// promote the one before it.
+			cur = cur[:len(cur)-1]
+			pages = append(pages, b.emit(kind, cur, rightOf(j-1)))
+			seps = append(seps, sep(j-1))
+			cur, used = [][]byte{c}, headerSize(kind)+2+len(c)
+			continue
+		}
+		pages = append(pages, b.emit(kind, cur, rightOf(j)))
+		seps = append(seps, sep(j))
+		cur, used = nil, headerSize(kind)
+	}
+	last := 0
+	if kind == indexInterior {
+		last = right[0]
+	}
+	pages = append(pages, b.emit(kind, cur, last))
+	return pages, seps
+}
+
+// schema writes the sqlite_schema rows into page 1.
+func (b *builder) schema(rows [][]any) error {
+	var cells [][]byte
+	used := 100 + headerSize(tableLeaf)
+	for i, row := range rows {
+		payload, err := record(row)
+		if err != nil {
+			return err
+		}
+		cell := appendVarint(nil, uint64(len(payload)))
+		cell = appendVarint(cell, uint64(i+1))
+		cell = append(cell, payload...)
+		used += 2 + len(cell)
+		cells = append(cells, cell)
+	}
+	if used > pageSize {
+		return errors.New("sqlite: schema does not fit on the first page")
+	}
+	fill(b.pages[0], 100, tableLeaf, cells, 0)
+	return nil
+}
+
+// header writes the 100-byte database header at the start of page 1.
+func (b *builder) header() {
+	h := b.pages[0][:100]
+	copy(h, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(h[16:], pageSize)
+	h[18], h[19] = 1, 1 // legacy (rollback journal) read and write versions
+	h[20] = 0           // reserved bytes per page
+	h[21], h[22], h[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(h[24:], 1) // file change counter
+	binary.BigEndian.PutUint32(h[28:], uint32(len(b.pages)))
+	binary.BigEndian.PutUint32(h[40:], 1) // schema cookie
+	binary.BigEndian.PutUint32(h[44:], 4) // schema format
+	binary.BigEndian.PutUint32(h[56:], 1) // UTF-8
+	binary.BigEndian.PutUint32(h[92:], 1) // version-valid-for, matching the change counter
+	binary.BigEndian.PutUint32(h[96:], 3046000)
+}
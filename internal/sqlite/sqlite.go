@@ -0,0 +1,249 @@
+// Package sqlite writes SQLite 3 database files from scratch: tables
+// filled once, with their indexes and views. There is no query engine or
+// update path, so exports need neither cgo nor a driver; anything that
+// reads SQLite (the sqlite3 shell, Python, DuckDB, …) can open the result.
+package sqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Database is the content of a file.
+type Database struct {
+	Tables []Table
+	Views  []View
+}
+
+// Table is one table and its rows, which get rowids 1, 2, 3, … in order.
+// Values are nil, int, int64, float64, bool, string or []byte; each row's
+// values line up with the columns of SQL, which must not declare an
+// INTEGER PRIMARY KEY.
+type Table struct {
+	Name    string
+	SQL     string // the CREATE TABLE statement, stored verbatim
+	Rows    [][]any
+	Indexes []Index
+}
+
+// Index is a CREATE INDEX on a table.
+type Index struct {
+	Name    string
+	SQL     string
+	Columns []int // positions in the table's rows, in index order
+}
+
+// View is a CREATE VIEW; it takes no space beyond its definition.
+type View struct {
+	Name string
+	SQL  string
+}
+
+// WriteTo encodes the whole database in memory and then writes it to w.
+func (db *Database) WriteTo(w io.Writer) (int64, error) {
+	b := &builder{}
+	b.alloc() // page 1: the header and the schema table.
+
+	var schema [][]any
+	for _, t := range db.Tables {
+		root, err := b.table(t)
+		if err != nil {
+			return 0, fmt.Errorf("sqlite: table %s: %w", t.Name, err)
+		}
+		schema = append(schema, []any{"table", t.Name, t.Name, root, t.SQL})
+		for _, ix := range t.Indexes {
+			root, err := b.index(t, ix)
+			if err != nil {
+				return 0, fmt.Errorf("sqlite: index %s: %w", ix.Name, err)
+			}
+			schema = append(schema, []any{"index", ix.Name, t.Name, root, ix.SQL})
+		}
+	}
+	for _, v := range db.Views {
+		schema = append(schema, []any{"view", v.Name, v.Name, 0, v.SQL})
+	}
+	if err := b.schema(schema); err != nil {
+		return 0, err
+	}
+	b.header()
+
+	var n int64
+	for _, p := range b.pages {
+		k, err := w.Write(p)
+		n += int64(k)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// record encodes values in the SQLite record format: a header of serial
+// types followed by the values themselves.
+func record(values []any) ([]byte, error) {
+	var types, body []byte
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+			types = appendVarint(types, 0)
+		case int:
+			types, body = appendInt(types, body, int64(v))
+		case int64:
+			types, body = appendInt(types, body, v)
+		case bool:
+			types = appendVarint(types, map[bool]uint64{false: 8, true: 9}[v])
+		case float64:
+			types = appendVarint(types, 7)
+			body = binary.BigEndian.AppendUint64(body, math.Float64bits(v))
+		case string:
+			types = appendVarint(types, uint64(2*len(v)+13))
+			body = append(body, v...)
+		case []byte:
+			types = appendVarint(types, uint64(2*len(v)+12))
+			body = append(body, v...)
+		default:
+			return nil, fmt.Errorf("unsupported value type %T", v)
+		}
+	}
+	// The header size counts its own varint.
+	size := len(types) + 1
+	for varintLen(uint64(size)) != size-len(types) {
+		size = len(types) + varintLen(uint64(size))
+	}
+	rec := appendVarint(make([]byte, 0, size+len(body)), uint64(size))
+	return append(append(rec, types...), body...), nil
+}
+
+// appendInt uses the smallest serial type that holds v.
+func appendInt(types, body []byte, v int64) ([]byte, []byte) {
+	switch {
+	case v == 0:
+		return appendVarint(types, 8), body
+	case v == 1:
+		return appendVarint(types, 9), body
+	}
+	for _, s := range []struct {
+		typ   uint64
+		bytes int
+	}{{1, 1}, {2, 2}, {3, 3}, {4, 4}, {5, 6}} {
+		bits := 8 * s.bytes
+		if v >= -1<<(bits-1) && v < 1<<(bits-1) {
+			for i := s.bytes - 1; i >= 0; i-- {
+				body = append(body, byte(v>>(8*i)))
+			}
+			return appendVarint(types, s.typ), body
+		}
+	}
+	return appendVarint(types, 6), binary.BigEndian.AppendUint64(body, uint64(v))
+}
+
+// appendVarint writes SQLite's big-endian varint: 7 bits per byte, with
+// a 9th byte carrying a full 8.
+func appendVarint(b []byte, v uint64) []byte {
+	if v >= 1<<56 {
+		var buf [9]byte
+		buf[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			buf[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(b, buf[:]...)
+	}
+	var buf [8]byte
+	i := len(buf) - 1
+	buf[i] = byte(v & 0x7f)
+	for v >>= 7; v > 0; v >>= 7 {
+		i--
+		buf[i] = byte(v&0x7f) | 0x80
+	}
+	return append(b, buf[i:]...)
+}
+
+func varintLen(v uint64) int {
+	return len(appendVarint(nil, v))
+}
+
+// compare orders two values the way SQLite's BINARY collation does:
+// NULL, then numbers, then text, then blobs.
+func compare(a, b any) int {
+	ca, cb := class(a), class(b)
+	if ca != cb {
+		return ca - cb
+	}
+	switch ca {
+	case 1:
+		x, xi := number(a)
+		y, yi := number(b)
+		if xi && yi {
+			return cmpInt(toInt(a), toInt(b))
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case 2:
+		return bytes.Compare([]byte(a.(string)), []byte(b.(string)))
+	case 3:
+		return bytes.Compare(a.([]byte), b.([]byte))
+	}
+	return 0
+}
+
+func class(v any) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case string:
+		return 2
+	case []byte:
+		return 3
+	}
+	return 1
+}
+
+func number(v any) (f float64, isInt bool) {
+	if x, ok := v.(float64); ok {
+		return x, false
+	}
+	return float64(toInt(v)), true
+}
+
+func toInt(v any) int64 {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+	}
+	return 0
+}
+
+func cmpInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareRows(a, b []any) int {
+	for i := range min(len(a), len(b)) {
+		if c := compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
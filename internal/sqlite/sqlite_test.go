@@ -0,0 +1,159 @@
+package sqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestVarint(t *testing.T) {
+	for v, want := range map[uint64][]byte{
+		0:       {0x00},
+		0x7f:    {0x7f},
+		0x80:    {0x81, 0x00},
+		0x3fff:  {0xff, 0x7f},
+		1 << 56: {0x80, 0xc0, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00},
+	} {
+		if got := appendVarint(nil, v); !bytes.Equal(got, want) {
+			t.Errorf("varint(%#x) = % x, want % x", v, got, want)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	got, err := record([]any{nil, 1, 300, "hi", 2.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{6, 0, 9, 2, 17, 7, 0x01, 0x2c, 'h', 'i', 0x40, 0x04, 0, 0, 0, 0, 0, 0}
+	if !bytes.Equal(got, want) {
+		t.Errorf("record = % x, want % x", got, want)
+	}
+	if _, err := record([]any{struct{}{}}); err == nil {
+		t.Error("want error for an unsupported type")
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	// Enough rows for interior pages, with some long enough to overflow.
+	var rows [][]any
+	for i := range 5000 {
+		note := any(nil)
+		if i%100 == 0 {
+			note = strings.Repeat("x", 10000)
+		}
+		rows = append(rows, []any{fmt.Sprintf("m%d", i%3), note})
+	}
+	db := Database{
+		Tables: []Table{{
+			Name:    "t",
+			SQL:     "CREATE TABLE t (model TEXT, note TEXT)",
+			Rows:    rows,
+			Indexes: []Index{{Name: "t_model", SQL: "CREATE INDEX t_model ON t (model)", Columns: []int{0}}},
+		}},
+		Views: []View{{Name: "v", SQL: "CREATE VIEW v AS SELECT model FROM t"}},
+	}
+	var buf bytes.Buffer
+	if _, err := db.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+	if !bytes.HasPrefix(file, []byte("SQLite format 3\x00")) || len(file)%pageSize != 0 {
+		t.Fatal("not a database file")
+	}
+	if n := int(binary.BigEndian.Uint32(file[28:])); n != len(file)/pageSize {
+		t.Errorf("header says %d pages, file has %d", n, len(file)/pageSize)
+	}
+	page := func(n int) []byte { return file[(n-1)*pageSize : n*pageSize] }
+
+	// Schema rows: the table, its index and the view.
+	if n := binary.BigEndian.Uint16(page(1)[103:]); n != 3 {
+		t.Errorf("schema has %d rows, want 3", n)
+	}
+
+	// Walk the table b-tree from its root and check the rowids come back
+	// in order.
+	var rowids []int64
+	var walk func(n int)
+	walk = func(n int) {
+		p := page(n)
+		cells := int(binary.BigEndian.Uint16(p[3:]))
+		switch p[0] {
+		case tableLeaf:
+			for i := range cells {
+				off := int(binary.BigEndian.Uint16(p[8+2*i:]))
+				_, k := readVarint(p[off:])
+				rowid, _ := readVarint(p[off+k:])
+				rowids = append(rowids, int64(rowid))
+			}
+		case tableInterior:
+			for i := range cells {
+				off := int(binary.BigEndian.Uint16(p[12+2*i:]))
+				walk(int(binary.BigEndian.Uint32(p[off:])))
+			}
+			walk(int(binary.BigEndian.Uint32(p[8:])))
+		default:
+			t.Fatalf("page %d has type %#x", n, p[0])
+		}
+	}
+	root := schemaRoot(t, page(1))
+	walk(root)
+	if len(rowids) != len(rows) {
+		t.Fatalf("walked %d rows, want %d", len(rowids), len(rows))
+	}
+	for i, id := range rowids {
+		if id != int64(i+1) {
+			t.Fatalf("row %d has rowid %d", i, id)
+		}
+	}
+	if page(root)[0] != tableInterior {
+		t.Error("5000 rows should need an interior page")
+	}
+
+	db.Tables[0].Rows = append(rows, []any{"m0", 1i})
+	if _, err := db.WriteTo(&bytes.Buffer{}); err == nil {
+		t.Error("want error for an unsupported value")
+	}
+}
+
+func readVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := range 8 {
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return v<<8 | uint64(b[8]), 9
+}
+
+// schemaRoot returns the rootpage column of the first schema row.
+func schemaRoot(t *testing.T, p []byte) int {
+	t.Helper()
+	off := int(binary.BigEndian.Uint16(p[108:]))
+	_, k := readVarint(p[off:]) // payload size
+	off += k
+	_, k = readVarint(p[off:]) // rowid
+	rec := p[off+k:]
+	hdr, k := readVarint(rec)
+	var types []uint64
+	for i := k; i < int(hdr); {
+		st, n := readVarint(rec[i:])
+		types = append(types, st)
+		i += n
+	}
+	body := rec[hdr:]
+	for _, st := range types[:3] { // type, name, tbl_name
+		body = body[(st-13)/2:]
+	}
+	if types[3] < 1 || types[3] > 4 {
+		t.Fatalf("unexpected rootpage serial type %d", types[3])
+	}
+	root := 0
+	for _, c := range body[:types[3]] {
+		root = root<<8 | int(c)
+	}
+	return root
+}
@@ -0,0 +1,183 @@
+// Package store persists recorded requests to an append-only JSONL file so
+// history survives restarts.
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"miser/internal/tracker"
+)
+
+// Store appends requests to a history file, one JSON object per line.
+type Store struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+// DefaultPath returns $XDG_DATA_HOME/miser/history.jsonl, falling back to
+// ~/.local/share/miser/history.jsonl.
+func DefaultPath() string {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "miser-history.jsonl"
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "miser", "history.jsonl")
+}
+
+// Open opens (creating if necessary) the history file at path for appending.
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("creating history directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening history %s: %w", path, err)
+	}
+	return &Store{path: path, f: f}, nil
+}
+
+// Path returns the history file location.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Append writes r as a single line.
+func (s *Store) Append(r tracker.Request) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.f.Write(line)
+	return err
+}
+
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
+
+// Rewrite atomically replaces the history file at path with reqs. The
+// previous file is kept alongside as path+".bak". It must not be called
+// while a Store has the same file open for appending.
+func Rewrite(path string, reqs []tracker.Request) error {
+	return replace(path, reqs, true)
+}
+
+// Compact is like Rewrite but keeps no backup, so space held by dropped
+// requests is actually freed.
+func Compact(path string, reqs []tracker.Request) error {
+	return replace(path, reqs, false)
+}
+
+func replace(path string, reqs []tracker.Request, backup bool) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("rewriting history: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, r := range reqs {
+		if err := enc.Encode(r); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if backup {
+		if err := os.Rename(path, path+".bak"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("backing up history: %w", err)
+		}
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Retention bounds how much history is kept. Zero fields mean no limit.
+type Retention struct {
+	MaxAge  time.Duration // drop requests older than this
+	MaxRows int           // keep only the newest this many
+}
+
+// IsZero reports whether r keeps everything.
+func (r Retention) IsZero() bool {
+	return r.MaxAge <= 0 && r.MaxRows <= 0
+}
+
+// Apply returns the requests r keeps, in their original order. reqs is
+// expected oldest first, as Load returns it.
+func (r Retention) Apply(reqs []tracker.Request, now time.Time) []tracker.Request {
+	kept := reqs
+	if r.MaxAge > 0 {
+		cutoff := now.Add(-r.MaxAge)
+		kept = make([]tracker.Request, 0, len(reqs))
+		for _, req := range reqs {
+			if !req.Timestamp.Before(cutoff) {
+				kept = append(kept, req)
+			}
+		}
+	}
+	if r.MaxRows > 0 && len(kept) > r.MaxRows {
+		kept = kept[len(kept)-r.MaxRows:]
+	}
+	return kept
+}
+
+// Load reads every request in the history file at path, oldest first.
+// A missing file yields no requests and no error; malformed lines (e.g. a
+// partial write from a crash) are skipped.
+func Load(path string) ([]tracker.Request, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading history %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var out []tracker.Request
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			var req tracker.Request
+			if json.Unmarshal(line, &req) == nil {
+				out = append(out, req)
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"miser/internal/tracker"
+)
+
+func TestLoad_MissingFile(t *testing.T) {
+	reqs, err := Load(filepath.Join(t.TempDir(), "nope.jsonl"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(reqs) != 0 {
+		t.Errorf("expected no requests, got %d", len(reqs))
+	}
+}
+
+func TestAppendLoad_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "history.jsonl")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	want := tracker.Request{
+		Timestamp:    ts,
+		Model:        "claude-sonnet-4-6",
+		InputTokens:  1200,
+		OutputTokens: 300,
+		Cost:         0.0081,
+		Latency:      1500 * time.Millisecond,
+		StatusCode:   200,
+	}
+	if err := s.Append(want); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append(tracker.Request{Timestamp: ts, Model: "x", Error: "boom"}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	got, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d requests, want 2", len(got))
+	}
+	if got[0].Model != want.Model || got[0].Latency != want.Latency || !got[0].Timestamp.Equal(ts) {
+		t.Errorf("round trip mismatch: got %+v", got[0])
+	}
+	if got[1].Error != "boom" {
+		t.Errorf("error not preserved: %+v", got[1])
+	}
+}
+
+func TestLoad_SkipsTruncatedLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	data := `{"model":"a","cost":1}` + "\n" + `{"model":"b","co`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Model != "a" {
+		t.Errorf("expected only the complete line, got %+v", got)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	st, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Append(tracker.Request{ID: 1, Model: "a", Cost: 1})
+	st.Close()
+
+	if err := Rewrite(path, []tracker.Request{{ID: 1, Model: "a", Cost: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(path)
+	if err != nil || len(got) != 1 || got[0].Cost != 2 {
+		t.Fatalf("Load after rewrite = %+v, %v", got, err)
+	}
+	bak, err := Load(path + ".bak")
+	if err != nil || len(bak) != 1 || bak[0].Cost != 1 {
+		t.Fatalf("backup = %+v, %v", bak, err)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	var reqs []tracker.Request
+	for _, daysAgo := range []int{40, 31, 29, 10, 1, 0} {
+		reqs = append(reqs, tracker.Request{Timestamp: now.AddDate(0, 0, -daysAgo), Model: fmt.Sprint(daysAgo)})
+	}
+	models := func(rs []tracker.Request) string {
+		var out []string
+		for _, r := range rs {
+			out = append(out, r.Model)
+		}
+		return strings.Join(out, ",")
+	}
+
+	tests := []struct {
+		r    Retention
+		want string
+	}{
+		{Retention{}, "40,31,29,10,1,0"},
+		{Retention{MaxAge: 30 * 24 * time.Hour}, "29,10,1,0"},
+		{Retention{MaxRows: 2}, "1,0"},
+		{Retention{MaxAge: 30 * 24 * time.Hour, MaxRows: 3}, "10,1,0"},
+		{Retention{MaxRows: 10}, "40,31,29,10,1,0"},
+	}
+	for _, tt := range tests {
+		if got := models(tt.r.Apply(reqs, now)); got != tt.want {
+			t.Errorf("%+v: kept %s, want %s", tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestCompact_NoBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	if err := os.WriteFile(path, []byte(`{"model":"a"}`+"\n"+`{"model":"b"}`+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Compact(path, []tracker.Request{{Model: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := Load(path)
+	if len(got) != 1 || got[0].Model != "b" {
+		t.Errorf("got %+v", got)
+	}
+	if _, err := os.Stat(path + ".bak"); !os.IsNotExist(err) {
+		t.Errorf("backup left behind: %v", err)
+	}
+}
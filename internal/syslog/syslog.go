@@ -0,0 +1,55 @@
+// Package syslog sends miser's headless log lines and alerts to a local
+// or remote syslog daemon.
+package syslog
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Config says where to send messages.
+type Config struct {
+	Network  string // "" for the local daemon, or "udp", "tcp"
+	Address  string // host:port when Network is set
+	Tag      string // program name on each line
+	Facility string // e.g. "daemon", "user", "local0"
+}
+
+// facilities maps names to RFC 5424 facility codes.
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Check validates cfg without connecting.
+func Check(cfg Config) error {
+	if _, err := facility(cfg.Facility); err != nil {
+		return err
+	}
+	switch cfg.Network {
+	case "":
+		if cfg.Address != "" {
+			return fmt.Errorf("syslog: address %q needs network = \"udp\" or \"tcp\"", cfg.Address)
+		}
+	case "udp", "tcp":
+		if cfg.Address == "" {
+			return fmt.Errorf("syslog: network %q needs an address such as \"logs.example.com:514\"", cfg.Network)
+		}
+	default:
+		return fmt.Errorf("syslog: network %q is not \"\", \"udp\" or \"tcp\"", cfg.Network)
+	}
+	return nil
+}
+
+func facility(name string) (int, error) {
+	if name == "" {
+		name = "daemon"
+	}
+	code, ok := facilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("syslog: unknown facility %q (daemon, user, local0 … local7, …)", name)
+	}
+	return code, nil
+}
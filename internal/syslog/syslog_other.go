@@ -0,0 +1,21 @@
+//go:build !unix
+
+package syslog
+
+import "errors"
+
+// Writer sends messages at a chosen severity.
+type Writer struct{}
+
+// Dial fails: syslog is only supported on Unix.
+func Dial(cfg Config) (*Writer, error) {
+	if err := Check(cfg); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("syslog is not supported on this platform")
+}
+
+func (w *Writer) Info(string) error    { return nil }
+func (w *Writer) Warning(string) error { return nil }
+func (w *Writer) Err(string) error     { return nil }
+func (w *Writer) Close() error         { return nil }
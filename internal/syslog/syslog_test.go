@@ -0,0 +1,56 @@
+package syslog
+
+import (
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	for _, tc := range []struct {
+		cfg Config
+		ok  bool
+	}{
+		{Config{}, true},
+		{Config{Network: "udp", Address: "logs:514", Facility: "LOCAL3"}, true},
+		{Config{Address: "logs:514"}, false},
+		{Config{Network: "tcp"}, false},
+		{Config{Network: "unix", Address: "/dev/log"}, false},
+		{Config{Facility: "local8"}, false},
+	} {
+		if err := Check(tc.cfg); (err == nil) != tc.ok {
+			t.Errorf("Check(%+v) = %v", tc.cfg, err)
+		}
+	}
+}
+
+func TestDialUDP(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("no syslog on " + runtime.GOOS)
+	}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	w, err := Dial(Config{Network: "udp", Address: pc.LocalAddr().String(), Facility: "local0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.Warning("budget reached")
+
+	pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1024)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// local0 (16) * 8 + warning (4)
+	got := string(buf[:n])
+	if !strings.HasPrefix(got, "<132>") || !strings.Contains(got, " miser[") || !strings.HasSuffix(strings.TrimSpace(got), ": budget reached") {
+		t.Errorf("message = %q", got)
+	}
+}
@@ -0,0 +1,35 @@
+//go:build unix
+
+package syslog
+
+import (
+	"log/syslog"
+)
+
+// Writer sends messages at a chosen severity. Its methods are safe for
+// concurrent use and reconnect after a dropped connection.
+type Writer struct {
+	w *syslog.Writer
+}
+
+// Dial connects to the daemon cfg names.
+func Dial(cfg Config) (*Writer, error) {
+	if err := Check(cfg); err != nil {
+		return nil, err
+	}
+	code, _ := facility(cfg.Facility)
+	tag := cfg.Tag
+	if tag == "" {
+		tag = "miser"
+	}
+	w, err := syslog.Dial(cfg.Network, cfg.Address, syslog.Priority(code<<3)|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{w: w}, nil
+}
+
+func (w *Writer) Info(msg string) error    { return w.w.Info(msg) }
+func (w *Writer) Warning(msg string) error { return w.w.Warning(msg) }
+func (w *Writer) Err(msg string) error     { return w.w.Err(msg) }
+func (w *Writer) Close() error             { return w.w.Close() }
@@ -0,0 +1,121 @@
+// Package systemd implements the parts of the sd_notify protocol and
+// journal logging conventions miser needs to run as a systemd service,
+// without linking libsystemd.
+package systemd
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Notify sends state (e.g. "READY=1") to the service manager. It does
+// nothing and returns false when miser was not started by systemd with
+// NotifyAccess, i.e. when $NOTIFY_SOCKET is unset.
+func Notify(state string) (bool, error) {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return false, nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Supervised reports whether a service manager is listening for
+// notifications.
+func Supervised() bool {
+	return os.Getenv("NOTIFY_SOCKET") != ""
+}
+
+// Ready tells systemd the service has finished starting, with status as
+// the human-readable text shown by "systemctl status".
+func Ready(status string) {
+	Notify("READY=1\nSTATUS=" + status)
+}
+
+// Status updates the text shown by "systemctl status".
+func Status(status string) {
+	Notify("STATUS=" + status)
+}
+
+// Stopping tells systemd the service is shutting down.
+func Stopping() {
+	Notify("STOPPING=1")
+}
+
+// WatchdogInterval returns the unit's WatchdogSec, if it is set and
+// meant for this process.
+func WatchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+	return time.Duration(usec) * time.Microsecond, true
+}
+
+// Watchdog pings the systemd watchdog at half its interval while healthy
+// reports true, until ctx is done. If healthy stops reporting true, the
+// pings stop and systemd restarts the service once the interval passes.
+// It returns at once when no watchdog is configured.
+func Watchdog(ctx context.Context, healthy func() bool) {
+	interval, ok := WatchdogInterval()
+	if !ok {
+		return
+	}
+	tick := time.NewTicker(interval / 2)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			if healthy() {
+				Notify("WATCHDOG=1")
+			}
+		}
+	}
+}
+
+// Journal priority prefixes from sd-daemon(3). A line written to stderr
+// under systemd that starts with one is logged at that level.
+const (
+	Err     = "<3>"
+	Warning = "<4>"
+	Info    = "<6>"
+	Debug   = "<7>"
+)
+
+// LogWriter returns a writer for a log.Logger that prefixes each line
+// with a journal priority: Debug for "[DEBUG]" lines, Info otherwise.
+func LogWriter(w io.Writer) io.Writer {
+	return logWriter{w}
+}
+
+type logWriter struct{ w io.Writer }
+
+var debugTag = []byte("[DEBUG]")
+
+func (l logWriter) Write(p []byte) (int, error) {
+	level := Info
+	if bytes.Contains(p, debugTag) {
+		level = Debug
+	}
+	if _, err := io.WriteString(l.w, level); err != nil {
+		return 0, err
+	}
+	return l.w.Write(p)
+}
@@ -0,0 +1,61 @@
+//go:build unix
+
+package systemd
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify("READY=1"); sent || err != nil {
+		t.Fatalf("without NOTIFY_SOCKET: sent %v, err %v", sent, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skip("unixgram sockets unavailable:", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	Ready("listening")
+	buf := make([]byte, 256)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "READY=1\nSTATUS=listening" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", "")
+	if d, ok := WatchdogInterval(); !ok || d != 30*time.Second {
+		t.Errorf("got %v, %v", d, ok)
+	}
+	t.Setenv("WATCHDOG_PID", "1")
+	if _, ok := WatchdogInterval(); ok {
+		t.Error("watchdog meant for another pid was accepted")
+	}
+}
+
+func TestLogWriter(t *testing.T) {
+	var buf bytes.Buffer
+	l := log.New(LogWriter(&buf), "[proxy] ", 0)
+	l.Printf("[DEBUG] request")
+	l.Printf("[ADMIN] session cleared")
+	want := "<7>[proxy] [DEBUG] request\n<6>[proxy] [ADMIN] session cleared\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,219 @@
+// Package tokens estimates the input tokens of a Messages request without
+// calling the API, so the counts upstream reports can be checked. Claude's
+// tokenizer is not public: the estimate approximates it from the words,
+// numbers and symbols in the text, and is good to ten or twenty percent on
+// ordinary prompts, not exact.
+package tokens
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Fixed costs, in tokens, of the parts of a request beyond its text.
+const (
+	requestOverhead = 4   // the start of the prompt and the assistant's turn
+	messageOverhead = 4   // the role markers around each message and system prompt
+	toolsOverhead   = 300 // the system prompt upstream adds when tools are offered
+	toolOverhead    = 10  // the framing of each tool definition
+)
+
+// Images are scaled to fit these bounds before they are tokenized, at
+// about one token per 750 pixels.
+const (
+	maxImageEdge   = 1568
+	maxImagePixels = 1_150_000
+	pixelsPerToken = 750
+)
+
+// Text estimates the tokens in s.
+func Text(s string) int {
+	n := 0
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		switch {
+		case cjk(r):
+			n++
+			s = s[size:]
+		case unicode.IsLetter(r):
+			word, ascii := 0, true
+			for len(s) > 0 {
+				r, size := utf8.DecodeRuneInString(s)
+				if !unicode.IsLetter(r) || cjk(r) {
+					break
+				}
+				ascii = ascii && r < utf8.RuneSelf
+				word++
+				s = s[size:]
+			}
+			// Common English words are one token; long and accented ones
+			// split into pieces.
+			if ascii {
+				n += 1 + (word-1)/6
+			} else {
+				n += 1 + (word-1)/3
+			}
+		case unicode.IsDigit(r):
+			run := span(&s, unicode.IsDigit)
+			n += (run + 2) / 3
+		case unicode.IsSpace(r):
+			lead := s
+			run := span(&s, unicode.IsSpace)
+			switch {
+			case strings.ContainsRune(lead[:len(lead)-len(s)], '\n'):
+				n++
+			case run > 1:
+				n += (run + 3) / 4
+			}
+			// A single space joins the word after it.
+		default:
+			run := span(&s, func(r rune) bool {
+				return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r)
+			})
+			n += (run + 1) / 2
+		}
+	}
+	return n
+}
+
+// span consumes the leading runes of *s that match f and returns how many
+// there were.
+func span(s *string, f func(rune) bool) int {
+	n := 0
+	for len(*s) > 0 {
+		r, size := utf8.DecodeRuneInString(*s)
+		if !f(r) {
+			break
+		}
+		n++
+		*s = (*s)[size:]
+	}
+	return n
+}
+
+func cjk(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+type request struct {
+	System   json.RawMessage `json:"system"`
+	Messages []struct {
+		Content json.RawMessage `json:"content"`
+	} `json:"messages"`
+	Tools []struct {
+		Type        string          `json:"type"`
+		Name        string          `json:"name"`
+		Description string          `json:"description"`
+		InputSchema json.RawMessage `json:"input_schema"`
+	} `json:"tools"`
+}
+
+type block struct {
+	Type    string          `json:"type"`
+	Text    string          `json:"text"`
+	Name    string          `json:"name"`
+	Input   json.RawMessage `json:"input"`
+	Content json.RawMessage `json:"content"`
+	Source  struct {
+		Type string `json:"type"`
+		Data string `json:"data"`
+	} `json:"source"`
+}
+
+// Request estimates the input tokens of an Anthropic Messages request
+// body. ok is false when the body is not a request, or holds something
+// that can't be sized locally: documents, thinking, server tools, or
+// images that are linked or in a format other than PNG, JPEG or GIF.
+func Request(body []byte) (n int, ok bool) {
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil || len(req.Messages) == 0 {
+		return 0, false
+	}
+	n = requestOverhead
+	if len(req.System) > 0 {
+		s, ok := content(req.System)
+		if !ok {
+			return 0, false
+		}
+		n += messageOverhead + s
+	}
+	for _, m := range req.Messages {
+		c, ok := content(m.Content)
+		if !ok {
+			return 0, false
+		}
+		n += messageOverhead + c
+	}
+	if len(req.Tools) > 0 {
+		n += toolsOverhead
+	}
+	for _, t := range req.Tools {
+		if t.Type != "" && t.Type != "custom" {
+			return 0, false
+		}
+		n += toolOverhead + Text(t.Name) + Text(t.Description) + Text(string(t.InputSchema))
+	}
+	return n, true
+}
+
+// content sizes a message's content: a string or a list of blocks.
+func content(raw json.RawMessage) (int, bool) {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return Text(s), true
+	}
+	var blocks []block
+	if json.Unmarshal(raw, &blocks) != nil {
+		return 0, false
+	}
+	n := 0
+	for _, b := range blocks {
+		switch b.Type {
+		case "text":
+			n += Text(b.Text)
+		case "tool_use":
+			n += Text(b.Name) + Text(string(b.Input))
+		case "tool_result":
+			if len(b.Content) > 0 {
+				c, ok := content(b.Content)
+				if !ok {
+					return 0, false
+				}
+				n += c
+			}
+		case "image":
+			if b.Source.Type != "base64" {
+				return 0, false
+			}
+			cfg, _, err := image.DecodeConfig(base64.NewDecoder(base64.StdEncoding, bytes.NewReader([]byte(b.Source.Data))))
+			if err != nil {
+				return 0, false
+			}
+			n += imageTokens(cfg.Width, cfg.Height)
+		default:
+			return 0, false
+		}
+	}
+	return n, true
+}
+
+// imageTokens sizes an image of w by h pixels after upstream scales it.
+func imageTokens(w, h int) int {
+	if long := max(w, h); long > maxImageEdge {
+		w, h = w*maxImageEdge/long, h*maxImageEdge/long
+	}
+	if px := w * h; px > maxImagePixels {
+		f := math.Sqrt(float64(maxImagePixels) / float64(px))
+		w, h = int(float64(w)*f), int(float64(h)*f)
+	}
+	return max(w*h/pixelsPerToken, 1)
+}
@@ -0,0 +1,54 @@
+package tokens
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	for _, c := range []struct {
+		text     string
+		min, max int
+	}{
+		{"The quick brown fox jumps over the lazy dog.", 9, 12},
+		{"func main() {\n\tfmt.Println(\"hello, world\")\n}\n", 12, 24},
+		{"1234567890", 3, 5},
+		{"東京は日本の首都です", 8, 14},
+		{"", 0, 0},
+	} {
+		if n := Text(c.text); n < c.min || n > c.max {
+			t.Errorf("Text(%q) = %d, want %d to %d", c.text, n, c.min, c.max)
+		}
+	}
+}
+
+func TestRequest(t *testing.T) {
+	prose := strings.Repeat("Please summarise the quarterly report and list the main risks. ", 50)
+	body := `{"model":"claude-sonnet-4-6","system":"You are terse.","messages":[{"role":"user","content":"` + prose + `"}]}`
+	n, ok := Request([]byte(body))
+	if !ok || n < 500 || n > 700 {
+		t.Errorf("prose request: %d, %v; want about 600", n, ok)
+	}
+
+	var img bytes.Buffer
+	png.Encode(&img, image.NewGray(image.Rect(0, 0, 1000, 750)))
+	body = `{"messages":[{"role":"user","content":[{"type":"image","source":{"type":"base64","media_type":"image/png","data":"` +
+		base64.StdEncoding.EncodeToString(img.Bytes()) + `"}},{"type":"text","text":"what is this?"}]}]}`
+	if n, ok := Request([]byte(body)); !ok || n < 1000 || n > 1020 {
+		t.Errorf("image request: %d, %v; want about 1000", n, ok)
+	}
+
+	for _, body := range []string{
+		`not json`,
+		`{"messages":[{"role":"user","content":[{"type":"document","source":{"type":"base64","data":"JVBERi0="}}]}]}`,
+		`{"messages":[{"role":"user","content":"hi"}],"tools":[{"type":"web_search_20250305","name":"web_search"}]}`,
+	} {
+		if _, ok := Request([]byte(body)); ok {
+			t.Errorf("estimated %s", body)
+		}
+	}
+}
@@ -12,13 +12,17 @@ type Pricing struct {
 	CacheWritePerMTok float64
 }
 
-// pricingStore is the runtime pricing state, safe for concurrent reads after
-// initial setup (writes only happen once at startup via ApplyPricing).
+// pricingStore is the runtime pricing state, safe for concurrent use. Writes
+// happen via ApplyPricing at startup and on a pricing reload.
 var pricingStore = struct {
 	mu       sync.RWMutex
 	models   map[string]Pricing
 	aliases  map[string]string
 	fallback Pricing
+
+	// windows holds the context windows that differ from
+	// DefaultContextWindow.
+	windows map[string]int
 }{
 	models: map[string]Pricing{
 		// Current generation
@@ -54,6 +58,10 @@ var pricingStore = struct {
 	fallback: Pricing{3.00, 15.00, 0.30, 3.75},
 }
 
+// DefaultContextWindow is the context window, in tokens, of the models
+// with none configured: all of them, at the standard tier.
+const DefaultContextWindow = 200_000
+
 // ModelPricingEntry is the external representation used by config loading.
 type ModelPricingEntry struct {
 	Aliases           []string
@@ -61,6 +69,7 @@ type ModelPricingEntry struct {
 	OutputPerMTok     float64
 	CacheReadPerMTok  float64
 	CacheWritePerMTok float64
+	ContextWindow     int // tokens; 0 means DefaultContextWindow
 }
 
 // ApplyPricing replaces the pricing tables with values from config.
@@ -72,6 +81,7 @@ func ApplyPricing(models map[string]ModelPricingEntry, fallback *Pricing) {
 	if models != nil {
 		pricingStore.models = make(map[string]Pricing, len(models))
 		pricingStore.aliases = make(map[string]string)
+		pricingStore.windows = make(map[string]int)
 		for name, entry := range models {
 			pricingStore.models[name] = Pricing{
 				InputPerMTok:      entry.InputPerMTok,
@@ -82,6 +92,9 @@ func ApplyPricing(models map[string]ModelPricingEntry, fallback *Pricing) {
 			for _, alias := range entry.Aliases {
 				pricingStore.aliases[alias] = name
 			}
+			if entry.ContextWindow > 0 {
+				pricingStore.windows[name] = entry.ContextWindow
+			}
 		}
 	}
 	if fallback != nil {
@@ -93,22 +106,51 @@ func GetPricing(model string) Pricing {
 	pricingStore.mu.RLock()
 	defer pricingStore.mu.RUnlock()
 
-	if p, ok := pricingStore.models[model]; ok {
-		return p
+	if name, ok := resolveModel(model); ok {
+		return pricingStore.models[name]
+	}
+	return pricingStore.fallback
+}
+
+// ContextWindow returns the context window of model, in tokens.
+func ContextWindow(model string) int {
+	pricingStore.mu.RLock()
+	defer pricingStore.mu.RUnlock()
+
+	if name, ok := resolveModel(model); ok {
+		if w := pricingStore.windows[name]; w > 0 {
+			return w
+		}
+	}
+	return DefaultContextWindow
+}
+
+// ContextUsed is the fraction of model's context window a prompt of
+// prompt tokens, cached or not, fills.
+func ContextUsed(model string, prompt int) float64 {
+	return float64(prompt) / float64(ContextWindow(model))
+}
+
+// resolveModel finds the pricing table entry for model: its own, its
+// alias's, or that of an alias it extends, such as a dated snapshot. Called
+// with mu held.
+func resolveModel(model string) (string, bool) {
+	if _, ok := pricingStore.models[model]; ok {
+		return model, true
 	}
 	if resolved, ok := pricingStore.aliases[model]; ok {
-		if p, ok := pricingStore.models[resolved]; ok {
-			return p
+		if _, ok := pricingStore.models[resolved]; ok {
+			return resolved, true
 		}
 	}
 	for prefix, full := range pricingStore.aliases {
 		if strings.HasPrefix(model, prefix) {
-			if p, ok := pricingStore.models[full]; ok {
-				return p
+			if _, ok := pricingStore.models[full]; ok {
+				return full, true
 			}
 		}
 	}
-	return pricingStore.fallback
+	return "", false
 }
 
 func CalculateCost(model string, inputTokens, outputTokens, cacheRead, cacheWrite int) float64 {
@@ -119,3 +161,13 @@ func CalculateCost(model string, inputTokens, outputTokens, cacheRead, cacheWrit
 	cost += float64(cacheWrite) * p.CacheWritePerMTok / 1_000_000
 	return cost
 }
+
+// CacheSavings estimates the net amount prompt caching saved: cache reads
+// billed below the input price minus the premium paid for cache writes.
+// The result is negative when writes were never read back enough to pay off.
+func CacheSavings(model string, cacheRead, cacheWrite int) float64 {
+	p := GetPricing(model)
+	saved := float64(cacheRead) * (p.InputPerMTok - p.CacheReadPerMTok) / 1_000_000
+	saved -= float64(cacheWrite) * (p.CacheWritePerMTok - p.InputPerMTok) / 1_000_000
+	return saved
+}
@@ -7,56 +7,389 @@ import (
 )
 
 type Request struct {
-	ID             int
-	Timestamp      time.Time
-	Model          string
-	InputTokens    int
-	OutputTokens   int
-	CacheRead      int
-	CacheWrite     int
-	Cost           float64
-	Latency        time.Duration
-	StatusCode     int
-	Error          string
-	OriginalSize   int // prompt bytes before compression
-	CompressedSize int // prompt bytes after compression
+	ID             int           `json:"id"`
+	Timestamp      time.Time     `json:"timestamp"`
+	Model          string        `json:"model"`
+	InputTokens    int           `json:"input_tokens"`
+	OutputTokens   int           `json:"output_tokens"`
+	CacheRead      int           `json:"cache_read"`
+	CacheWrite     int           `json:"cache_write"`
+	Cost           float64       `json:"cost"`
+	Latency        time.Duration `json:"latency"` // nanoseconds when encoded
+	StatusCode     int           `json:"status_code"`
+	Error          string        `json:"error,omitempty"`
+	RequestID      string        `json:"request_id,omitempty"`      // upstream's request-id, for its support
+	OriginalSize   int           `json:"original_size,omitempty"`   // prompt bytes before compression
+	CompressedSize int           `json:"compressed_size,omitempty"` // prompt bytes after compression
+	Prompt         string        `json:"prompt,omitempty"`          // captured, redacted preview
+	Response       string        `json:"response,omitempty"`        // captured, redacted preview
+	Project        string        `json:"project,omitempty"`         // from X-Miser-Project or the configured default
+	Client         string        `json:"client,omitempty"`          // the local process, or X-Miser-Client
+	Key            string        `json:"key,omitempty"`             // name of the virtual key used, if any
+	Network        Network       `json:"network,omitzero"`          // how the upstream connection went
+	From           string        `json:"from,omitempty"`            // the miser that forwarded it here, in a chain
+	CountedBy      string        `json:"counted_by,omitempty"`      // the upstream miser that recorded it too
+	Node           string        `json:"node,omitempty"`            // the cluster node that proxied it; "" for this instance
+	EstimatedInput int           `json:"estimated_input,omitempty"` // local input estimate, set when the reported count is off it
+	EstimatedUsage bool          `json:"estimated_usage,omitempty"` // upstream reported no usage; the counts are local estimates
+	ContextUsed    float64       `json:"context_used,omitempty"`    // fraction of the model's context window the prompt filled
+	Conversation   string        `json:"conversation,omitempty"`    // from X-Miser-Conversation, the client's session, or the prompt prefix
+	Messages       int           `json:"messages,omitempty"`        // in the request, which grows turn by turn
+	ToolTokens     int           `json:"tool_tokens,omitempty"`     // of the output, the share spent on tool call arguments
+
+	// ShadowOf, on a shadow request, is the real request it duplicated.
+	ShadowOf *Shadowed `json:"shadow_of,omitempty"`
+
+	// Race, on a request raced against a second model, says how it went.
+	Race *Race `json:"race,omitempty"`
+
+	// Tags are added by the [[hook]] rules that held for the request.
+	Tags []string `json:"tags,omitempty"`
+
+	// Degraded lists what converting an OpenAI-style request dropped or
+	// approximated, such as its tools.
+	Degraded []string `json:"degraded,omitempty"`
+
+	// CappedFrom is the max_tokens the client asked for, when it was over
+	// [proxy] max_tokens: lowered to it or, with reject, refused with 400.
+	CappedFrom int `json:"capped_from,omitempty"`
+
+	// Projected is the request's projected cost — its input estimate plus
+	// max_tokens of output — when it was over [budget] max_request. A
+	// request refused for it failed with 402.
+	Projected float64 `json:"projected,omitempty"`
+
+	// Injected names the [[inject]] fragments added to the system prompt.
+	Injected []string `json:"injected,omitempty"`
+
+	// Truncated marks a stream that ended before its final usage with no
+	// error event, cut off upstream or on the way; its output is estimated.
+	Truncated bool `json:"truncated,omitempty"`
+
+	// Retry, on a request miser sent more than once, links its attempts.
+	Retry *Retry `json:"retry,omitempty"`
+
+	// FallbackFrom is the model the client asked for, when upstream was
+	// overloaded and the request was sent to a fallback model instead.
+	FallbackFrom string `json:"fallback_from,omitempty"`
+
+	// RateLimit is upstream's rate-limit state as of the response.
+	RateLimit *RateLimit `json:"rate_limit,omitempty"`
+}
+
+// RateLimit is what an Anthropic response's anthropic-ratelimit-* headers
+// said was left of the key's limits. Limits it didn't report are zero.
+type RateLimit struct {
+	Requests     Limit `json:"requests,omitzero"`
+	Tokens       Limit `json:"tokens,omitzero"`
+	InputTokens  Limit `json:"input_tokens,omitzero"`
+	OutputTokens Limit `json:"output_tokens,omitzero"`
+}
+
+// Limit is one rate limit: how much of it remains until it next resets.
+type Limit struct {
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset,omitzero"`
+}
+
+// Headroom returns the fraction of the limit remaining as of now: all of
+// it once the reset has passed, or for a limit that wasn't reported.
+func (l Limit) Headroom(now time.Time) float64 {
+	if l.Limit <= 0 || !l.Reset.IsZero() && !now.Before(l.Reset) {
+		return 1
+	}
+	return min(max(float64(l.Remaining)/float64(l.Limit), 0), 1)
+}
+
+// Tightest returns the limit with the least headroom as of now, and its
+// name: "requests", "tokens", "input tokens" or "output tokens".
+func (r RateLimit) Tightest(now time.Time) (name string, l Limit) {
+	name, l = "requests", r.Requests
+	for _, o := range []struct {
+		name string
+		l    Limit
+	}{{"tokens", r.Tokens}, {"input tokens", r.InputTokens}, {"output tokens", r.OutputTokens}} {
+		if o.l.Headroom(now) < l.Headroom(now) {
+			name, l = o.name, o.l
+		}
+	}
+	return name, l
+}
+
+// Retry is one attempt of a request miser resent after it failed. Every
+// attempt carries the same ID; each failed one is recorded as it fails,
+// and the last, whose response was relayed, is Final.
+type Retry struct {
+	ID      string `json:"id"`
+	Attempt int    `json:"attempt"` // 1 for the first
+	Final   bool   `json:"final,omitempty"`
+}
+
+// Race is one side of a race between the model a request asked for and a
+// second one: both were sent, the first to answer was relayed and the
+// other cancelled. The loser is recorded too, with Lost set; if it was
+// cancelled before reporting usage, its input tokens are the local
+// estimate at the uncached price.
+type Race struct {
+	Requested string `json:"requested"` // the model the client asked for
+	Rival     string `json:"rival"`     // the other model in the race
+	Lost      bool   `json:"lost,omitempty"`
+}
+
+// Shadowed is what a shadow request is compared with: the real request's
+// outcome. Response is set only when shadow responses are kept.
+type Shadowed struct {
+	Model        string        `json:"model"`
+	InputTokens  int           `json:"input_tokens"`
+	OutputTokens int           `json:"output_tokens"`
+	Cost         float64       `json:"cost"`
+	Latency      time.Duration `json:"latency"`
+	StatusCode   int           `json:"status_code"`
+	Error        string        `json:"error,omitempty"`
+	Response     string        `json:"response,omitempty"`
+}
+
+// Network times the upstream connection of a request, to tell a slow
+// network from a slow model. Phases that didn't happen, such as all of
+// them on a reused connection, are zero.
+type Network struct {
+	Reused    bool          `json:"reused,omitempty"` // an idle keep-alive connection was used
+	DNS       time.Duration `json:"dns,omitempty"`
+	Connect   time.Duration `json:"connect,omitempty"`
+	TLS       time.Duration `json:"tls,omitempty"`
+	FirstByte time.Duration `json:"first_byte,omitempty"` // from the request being sent to the first response byte
+}
+
+// ClientStalled is the Error of a request whose client stopped reading the
+// response, which was then abandoned.
+const ClientStalled = "client_stalled"
+
+// ClientCancelled is the Error of a request whose client went away before
+// its response was complete, cancelling it upstream too. Its usage is what
+// upstream reported until then, estimated where it hadn't.
+const ClientCancelled = "client_cancelled"
+
+// Cancelled reports whether the client abandoned the request.
+func (r Request) Cancelled() bool {
+	return r.Error == ClientCancelled
+}
+
+// Partial reports whether the response was cut short other than by the
+// client cancelling: the client stalled, or the stream was truncated.
+// What it got through is counted.
+func (r Request) Partial() bool {
+	return r.Error == ClientStalled || r.Truncated && r.Error == ""
+}
+
+// Retried reports whether the request is an attempt that failed and was
+// sent again. What it cost is retry overhead.
+func (r Request) Retried() bool {
+	return r.Retry != nil && !r.Retry.Final
+}
+
+// CountedUpstream reports whether an upstream miser in a chain recorded the
+// request too. It is that miser's to count: totals and budgets here leave
+// it out, so adding up every hop counts it once.
+func (r Request) CountedUpstream() bool {
+	return r.CountedBy != ""
+}
+
+// Failed reports whether the request errored before reaching upstream or
+// came back with a 4xx/5xx status. Cancelled and partial requests, which
+// agents leave behind all the time, and retried attempts are counted
+// apart, not as failures.
+func (r Request) Failed() bool {
+	if r.Cancelled() || r.Partial() || r.Retried() {
+		return false
+	}
+	return r.Error != "" || r.StatusCode >= 400
 }
 
 type ModelStats struct {
 	Model          string
 	Requests       int
+	Errors         int
 	InputTokens    int
 	OutputTokens   int
+	ToolTokens     int // of OutputTokens, in tool call arguments
 	CacheRead      int
 	CacheWrite     int
 	TotalCost      float64
 	OriginalSize   int
 	CompressedSize int
+	AvgLatency     time.Duration
+	P95Latency     time.Duration
+	MaxLatency     time.Duration
+	CacheSavings   float64 // vs. paying the full input price for cached tokens
+}
+
+// ToolShare returns the percentage of output tokens spent on tool call
+// arguments rather than text.
+func (ms ModelStats) ToolShare() float64 {
+	if ms.OutputTokens == 0 {
+		return 0
+	}
+	return float64(ms.ToolTokens) / float64(ms.OutputTokens) * 100
+}
+
+// CacheHitRate returns the percentage of prompt tokens that were served
+// from the prompt cache.
+func (ms ModelStats) CacheHitRate() float64 {
+	prompt := ms.InputTokens + ms.CacheRead + ms.CacheWrite
+	if prompt == 0 {
+		return 0
+	}
+	return float64(ms.CacheRead) / float64(prompt) * 100
 }
 
 type Summary struct {
 	TotalCost      float64
 	TotalRequests  int
+	TotalErrors    int
+	TotalCancelled int // by the client; not errors
+	TotalPartial   int // cut short; not errors
+	TotalRetries   int // failed attempts that were resent; not errors
+	TotalFallbacks int // sent to a fallback model when overloaded
 	TotalInput     int
 	TotalOutput    int
+	TotalTool      int // of TotalOutput, in tool call arguments
 	TotalCacheR    int
 	TotalCacheW    int
 	OriginalSize   int
 	CompressedSize int
+
+	// RetryCost is what the retried attempts cost, within TotalCost.
+	RetryCost float64
+
+	// UpstreamRequests and UpstreamCost are the requests an upstream miser
+	// counted, outside every other total.
+	UpstreamRequests int
+	UpstreamCost     float64
+}
+
+// add counts r into s.
+func (s *Summary) add(r Request) {
+	if r.CountedUpstream() {
+		s.UpstreamRequests++
+		s.UpstreamCost += r.Cost
+		return
+	}
+	s.TotalRequests++
+	switch {
+	case r.Retried():
+		s.TotalRetries++
+		s.RetryCost += r.Cost
+	case r.Cancelled():
+		s.TotalCancelled++
+	case r.Partial():
+		s.TotalPartial++
+	case r.Failed():
+		s.TotalErrors++
+	}
+	if r.FallbackFrom != "" {
+		s.TotalFallbacks++
+	}
+	s.TotalCost += r.Cost
+	s.TotalInput += r.InputTokens
+	s.TotalOutput += r.OutputTokens
+	s.TotalTool += r.ToolTokens
+	s.TotalCacheR += r.CacheRead
+	s.TotalCacheW += r.CacheWrite
+	s.OriginalSize += r.OriginalSize
+	s.CompressedSize += r.CompressedSize
+}
+
+// ProjectStats is the Summary of one project's requests. Project is ""
+// for requests that named none.
+type ProjectStats struct {
+	Project string
+	Summary
+}
+
+// ClientStats is the Summary of one client tool's requests. Client is ""
+// for requests whose client is unknown.
+type ClientStats struct {
+	Client string
+	Summary
+}
+
+// NodeStats is the Summary of one cluster node's requests. Node is "" for
+// the instance's own.
+type NodeStats struct {
+	Node string
+	Summary
+}
+
+// Day is the Summary of one local calendar day.
+type Day struct {
+	Date time.Time // local midnight
+	Summary
+}
+
+// Heatmap is the Summary of each local hour of the week, indexed by
+// weekday, Monday first, and hour of day.
+type Heatmap [7][24]Summary
+
+// Peak returns the highest cost of any hour, for shading.
+func (h *Heatmap) Peak() float64 {
+	var peak float64
+	for d := range h {
+		for _, s := range h[d] {
+			peak = max(peak, s.TotalCost)
+		}
+	}
+	return peak
+}
+
+// Bucket aggregates the requests that started within one time slice.
+type Bucket struct {
+	Start      time.Time
+	Requests   int
+	Cost       float64
+	CacheRead  int
+	CacheWrite int
 }
 
 type Tracker struct {
-	mu       sync.RWMutex
-	requests []Request
-	nextID   int
+	mu           sync.RWMutex
+	requests     []Request
+	nextID       int
+	sessionStart time.Time
+	subscribers  []subscriber
+	nextSubID    int
+}
 
-	// OnRecord is called (outside the lock) after every successful Record.
-	// Useful for headless logging. May be nil.
-	OnRecord func(Request)
+type subscriber struct {
+	id int
+	fn func(Request)
 }
 
 func New() *Tracker {
-	return &Tracker{}
+	return &Tracker{sessionStart: time.Now()}
+}
+
+// Subscribe registers fn to be called (outside the lock) after every
+// Record, in registration order. Used for headless logging and persistence.
+// The returned func unsubscribes; long-lived subscribers can ignore it.
+func (t *Tracker) Subscribe(fn func(Request)) (unsubscribe func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextSubID++
+	id := t.nextSubID
+	t.subscribers = append(t.subscribers, subscriber{id, fn})
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		// Copy so a Record iterating the old slice is unaffected.
+		subs := make([]subscriber, 0, len(t.subscribers))
+		for _, s := range t.subscribers {
+			if s.id != id {
+				subs = append(subs, s)
+			}
+		}
+		t.subscribers = subs
+	}
 }
 
 func (t *Tracker) Record(r Request) {
@@ -64,14 +397,47 @@ func (t *Tracker) Record(r Request) {
 	t.nextID++
 	r.ID = t.nextID
 	t.requests = append(t.requests, r)
-	cb := t.OnRecord
+	subs := t.subscribers
 	t.mu.Unlock()
 
-	if cb != nil {
-		cb(r)
+	for _, s := range subs {
+		s.fn(r)
 	}
 }
 
+// Load seeds the tracker with historical requests (e.g. from the persisted
+// store) ahead of anything recorded this session. IDs are reassigned and
+// subscribers are not notified.
+func (t *Tracker) Load(history []Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	all := make([]Request, 0, len(history)+len(t.requests))
+	all = append(all, history...)
+	all = append(all, t.requests...)
+	for i := range all {
+		all[i].ID = i + 1
+	}
+	t.requests = all
+	t.nextID = len(all)
+}
+
+// SetSessionStart moves the session boundary, e.g. to mirror a remote
+// instance's session.
+func (t *Tracker) SetSessionStart(ts time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sessionStart = ts
+}
+
+// SessionStart reports when the current session began: either tracker
+// creation or the last Clear.
+func (t *Tracker) SessionStart() time.Time {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.sessionStart
+}
+
 func (t *Tracker) GetRequests() []Request {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
@@ -80,36 +446,124 @@ func (t *Tracker) GetRequests() []Request {
 	return out
 }
 
+// RateLimit returns the rate-limit state reported with the latest request
+// that had one.
+func (t *Tracker) RateLimit() (RateLimit, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for i := len(t.requests) - 1; i >= 0; i-- {
+		if rl := t.requests[i].RateLimit; rl != nil {
+			return *rl, true
+		}
+	}
+	return RateLimit{}, false
+}
+
+// GetRequest looks up a request by ID.
+func (t *Tracker) GetRequest(id int) (Request, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	// IDs are assigned sequentially, so the request usually sits at id-1.
+	if i := id - 1; i >= 0 && i < len(t.requests) && t.requests[i].ID == id {
+		return t.requests[i], true
+	}
+	for _, r := range t.requests {
+		if r.ID == id {
+			return r, true
+		}
+	}
+	return Request{}, false
+}
+
+// GetRequestsSince returns the requests that started at or after since,
+// oldest first.
+func (t *Tracker) GetRequestsSince(since time.Time) []Request {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var out []Request
+	for _, r := range t.requests {
+		if !r.Timestamp.Before(since) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// Count returns the number of tracked requests, including loaded history.
+func (t *Tracker) Count() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.requests)
+}
+
+// GetRequestsAfter returns requests with an ID greater than id, oldest
+// first. IDs are assigned sequentially from 1, so this is a cheap way to
+// fetch everything recorded since a previous call.
+func (t *Tracker) GetRequestsAfter(id int) []Request {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	id = max(id, 0)
+	if id >= len(t.requests) {
+		return []Request{}
+	}
+	out := make([]Request, len(t.requests)-id)
+	copy(out, t.requests[id:])
+	return out
+}
+
 // GetRecentRequests returns the last n requests, newest first.
 func (t *Tracker) GetRecentRequests(n int) []Request {
+	return t.GetRecentRequestsSince(time.Time{}, n)
+}
+
+// GetRecentRequestsSince returns the last n requests that started at or
+// after since, newest first.
+func (t *Tracker) GetRecentRequestsSince(since time.Time, n int) []Request {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 
-	total := len(t.requests)
-	if n > total {
-		n = total
-	}
-	out := make([]Request, n)
-	for i := 0; i < n; i++ {
-		out[i] = t.requests[total-1-i]
+	out := make([]Request, 0, min(n, len(t.requests)))
+	for i := len(t.requests) - 1; i >= 0 && len(out) < n; i-- {
+		if t.requests[i].Timestamp.Before(since) {
+			continue
+		}
+		out = append(out, t.requests[i])
 	}
 	return out
 }
 
 func (t *Tracker) GetModelStats() []ModelStats {
+	return t.GetModelStatsSince(time.Time{})
+}
+
+// GetModelStatsSince aggregates per-model stats over requests that started
+// at or after since, most expensive first.
+func (t *Tracker) GetModelStatsSince(since time.Time) []ModelStats {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 
 	byModel := make(map[string]*ModelStats)
+	latencies := make(map[string][]time.Duration)
 	for _, r := range t.requests {
+		if r.Timestamp.Before(since) || r.CountedUpstream() {
+			continue
+		}
+		if r.Latency > 0 {
+			latencies[r.Model] = append(latencies[r.Model], r.Latency)
+		}
 		s, ok := byModel[r.Model]
 		if !ok {
 			s = &ModelStats{Model: r.Model}
 			byModel[r.Model] = s
 		}
 		s.Requests++
+		if r.Failed() {
+			s.Errors++
+		}
 		s.InputTokens += r.InputTokens
 		s.OutputTokens += r.OutputTokens
+		s.ToolTokens += r.ToolTokens
 		s.CacheRead += r.CacheRead
 		s.CacheWrite += r.CacheWrite
 		s.TotalCost += r.Cost
@@ -118,7 +572,9 @@ func (t *Tracker) GetModelStats() []ModelStats {
 	}
 
 	stats := make([]ModelStats, 0, len(byModel))
-	for _, s := range byModel {
+	for model, s := range byModel {
+		s.AvgLatency, s.P95Latency, s.MaxLatency = latencyStats(latencies[model])
+		s.CacheSavings = CacheSavings(model, s.CacheRead, s.CacheWrite)
 		stats = append(stats, *s)
 	}
 	sort.Slice(stats, func(i, j int) bool {
@@ -127,27 +583,395 @@ func (t *Tracker) GetModelStats() []ModelStats {
 	return stats
 }
 
+// latencyStats returns the mean, 95th percentile (nearest rank) and maximum
+// of ds. It sorts ds in place.
+func latencyStats(ds []time.Duration) (avg, p95, peak time.Duration) {
+	if len(ds) == 0 {
+		return 0, 0, 0
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+
+	var total time.Duration
+	for _, d := range ds {
+		total += d
+	}
+	rank := (len(ds)*95 + 99) / 100 // ceil(0.95 * n)
+	return total / time.Duration(len(ds)), ds[rank-1], ds[len(ds)-1]
+}
+
 func (t *Tracker) GetSummary() Summary {
+	return t.GetSummarySince(time.Time{})
+}
+
+// GetSummarySince totals requests that started at or after since.
+func (t *Tracker) GetSummarySince(since time.Time) Summary {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 
 	var s Summary
-	s.TotalRequests = len(t.requests)
 	for _, r := range t.requests {
-		s.TotalCost += r.Cost
-		s.TotalInput += r.InputTokens
-		s.TotalOutput += r.OutputTokens
-		s.TotalCacheR += r.CacheRead
-		s.TotalCacheW += r.CacheWrite
-		s.OriginalSize += r.OriginalSize
-		s.CompressedSize += r.CompressedSize
+		if r.Timestamp.Before(since) {
+			continue
+		}
+		s.add(r)
 	}
 	return s
 }
 
+// GetDailySince totals requests per local calendar day, oldest day first.
+// Days without requests are omitted.
+func (t *Tracker) GetDailySince(since time.Time) []Day {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var days []Day
+	index := make(map[time.Time]int)
+	for _, r := range t.requests {
+		if r.Timestamp.Before(since) {
+			continue
+		}
+		y, m, d := r.Timestamp.Date()
+		key := time.Date(y, m, d, 0, 0, 0, 0, r.Timestamp.Location())
+		i, ok := index[key]
+		if !ok {
+			i = len(days)
+			index[key] = i
+			days = append(days, Day{Date: key})
+		}
+		days[i].add(r)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Date.Before(days[j].Date) })
+	return days
+}
+
+// GetHeatmapSince totals requests that started at or after since per
+// local weekday and hour, showing when in the week spend happens.
+func (t *Tracker) GetHeatmapSince(since time.Time) Heatmap {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var h Heatmap
+	for _, r := range t.requests {
+		if r.Timestamp.Before(since) {
+			continue
+		}
+		ts := r.Timestamp.Local()
+		h[(int(ts.Weekday())+6)%7][ts.Hour()].add(r)
+	}
+	return h
+}
+
+// GetProjectStatsSince totals requests that started at or after since per
+// project, most expensive first.
+func (t *Tracker) GetProjectStatsSince(since time.Time) []ProjectStats {
+	var stats []ProjectStats
+	for _, g := range t.groupSince(since, func(r Request) string { return r.Project }) {
+		stats = append(stats, ProjectStats{g.key, g.Summary})
+	}
+	return stats
+}
+
+// GetClientStatsSince totals requests that started at or after since per
+// client tool, most expensive first.
+func (t *Tracker) GetClientStatsSince(since time.Time) []ClientStats {
+	var stats []ClientStats
+	for _, g := range t.groupSince(since, func(r Request) string { return r.Client }) {
+		stats = append(stats, ClientStats{g.key, g.Summary})
+	}
+	return stats
+}
+
+// GetNodeStatsSince totals requests that started at or after since per
+// cluster node, most expensive first.
+func (t *Tracker) GetNodeStatsSince(since time.Time) []NodeStats {
+	var stats []NodeStats
+	for _, g := range t.groupSince(since, func(r Request) string { return r.Node }) {
+		stats = append(stats, NodeStats{g.key, g.Summary})
+	}
+	return stats
+}
+
+// ShadowStats compares the shadow requests of one real model and shadow
+// model with the real requests they duplicated.
+type ShadowStats struct {
+	Model  string // the real request's
+	Shadow string // the shadow request's
+	Real   Summary
+	Summary
+	RealLatency time.Duration // average
+	Latency     time.Duration // average
+}
+
+// GetShadowStatsSince compares shadow requests that started at or after
+// since with their real ones, by pair of models, most requests first.
+// Shadow requests whose real one went unrecorded are left out.
+func (t *Tracker) GetShadowStatsSince(since time.Time) []ShadowStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var stats []ShadowStats
+	var real, shadow [][]time.Duration
+	index := make(map[[2]string]int)
+	for _, r := range t.requests {
+		o := r.ShadowOf
+		if o == nil || r.Timestamp.Before(since) {
+			continue
+		}
+		k := [2]string{o.Model, r.Model}
+		i, ok := index[k]
+		if !ok {
+			i = len(stats)
+			index[k] = i
+			stats = append(stats, ShadowStats{Model: o.Model, Shadow: r.Model})
+			real, shadow = append(real, nil), append(shadow, nil)
+		}
+		stats[i].add(r)
+		stats[i].Real.add(Request{InputTokens: o.InputTokens, OutputTokens: o.OutputTokens,
+			Cost: o.Cost, StatusCode: o.StatusCode, Error: o.Error})
+		real[i] = append(real[i], o.Latency)
+		shadow[i] = append(shadow[i], r.Latency)
+	}
+	for i := range stats {
+		stats[i].RealLatency, _, _ = latencyStats(real[i])
+		stats[i].Latency, _, _ = latencyStats(shadow[i])
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].TotalRequests > stats[j].TotalRequests })
+	return stats
+}
+
+// RaceStats sums the races between a requested model and the model raced
+// against it.
+type RaceStats struct {
+	Model      string
+	Challenger string
+	Races      int
+	Wins       int           // by the challenger
+	Cost       float64       // of the winners
+	Wasted     float64       // of the cancelled losers
+	Latency    time.Duration // average, of the winners
+}
+
+// GetRaceStatsSince sums races that started at or after since by pair of
+// models, most races first.
+func (t *Tracker) GetRaceStatsSince(since time.Time) []RaceStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var stats []RaceStats
+	var latencies [][]time.Duration
+	index := make(map[[2]string]int)
+	for _, r := range t.requests {
+		rc := r.Race
+		if rc == nil || r.Timestamp.Before(since) {
+			continue
+		}
+		challenger := rc.Rival
+		if r.Model != rc.Requested {
+			challenger = r.Model
+		}
+		k := [2]string{rc.Requested, challenger}
+		i, ok := index[k]
+		if !ok {
+			i = len(stats)
+			index[k] = i
+			stats = append(stats, RaceStats{Model: rc.Requested, Challenger: challenger})
+			latencies = append(latencies, nil)
+		}
+		switch {
+		case rc.Lost:
+			stats[i].Wasted += r.Cost
+		default:
+			stats[i].Races++
+			stats[i].Cost += r.Cost
+			if r.Model == challenger {
+				stats[i].Wins++
+			}
+			latencies[i] = append(latencies[i], r.Latency)
+		}
+	}
+	for i := range stats {
+		stats[i].Latency, _, _ = latencyStats(latencies[i])
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].Races > stats[j].Races })
+	return stats
+}
+
+// The prompt cache keeps a prefix for CacheTTL after its last read, and
+// only caches prefixes of at least MinCacheable tokens (more on some
+// models).
+const (
+	CacheTTL     = 5 * time.Minute
+	MinCacheable = 1024
+)
+
+// ConversationStats follows one conversation's requests: how its prompt
+// grows turn by turn and how much of it cache reads cover.
+type ConversationStats struct {
+	ID         string
+	Model      string // of the last request
+	Project    string
+	Client     string
+	Start, End time.Time
+	Messages   int // in the last request
+	Summary
+
+	// FirstPrompt and LastPrompt are the input tokens, cache included, of
+	// the first and last successful requests.
+	FirstPrompt int
+	LastPrompt  int
+
+	Saved     float64       // what cache reads saved, net of cache writes
+	Potential float64       // what a breakpoint after each request's messages would save
+	MaxGap    time.Duration // the longest pause between requests
+}
+
+// CacheHitRate returns the percentage of prompt tokens that were served
+// from the prompt cache.
+func (c ConversationStats) CacheHitRate() float64 {
+	prompt := c.TotalInput + c.TotalCacheR + c.TotalCacheW
+	if prompt == 0 {
+		return 0
+	}
+	return float64(c.TotalCacheR) / float64(prompt) * 100
+}
+
+// GetConversationStatsSince follows the conversations of requests that
+// started at or after since, most expensive first. Requests the proxy
+// could not place in a conversation are left out.
+//
+// Potential assumes each request of at least MinCacheable tokens could
+// read the previous one's prompt from the cache, if it came within
+// CacheTTL, and wrote the rest.
+func (t *Tracker) GetConversationStatsSince(since time.Time) []ConversationStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var stats []ConversationStats
+	prev := make(map[string]Request) // each conversation's last successful request
+	index := make(map[string]int)
+	for _, r := range t.requests {
+		if r.Conversation == "" || r.Timestamp.Before(since) {
+			continue
+		}
+		i, ok := index[r.Conversation]
+		if !ok {
+			i = len(stats)
+			index[r.Conversation] = i
+			stats = append(stats, ConversationStats{ID: r.Conversation, Start: r.Timestamp})
+		}
+		c := &stats[i]
+		if c.TotalRequests > 0 {
+			c.MaxGap = max(c.MaxGap, r.Timestamp.Sub(c.End))
+		}
+		c.add(r)
+		c.Model, c.Project, c.Client = r.Model, r.Project, r.Client
+		c.End = r.Timestamp
+		c.Messages = r.Messages
+		if r.Failed() {
+			continue
+		}
+		prompt := r.InputTokens + r.CacheRead + r.CacheWrite
+		c.Saved += CacheSavings(r.Model, r.CacheRead, r.CacheWrite)
+		read := 0
+		if p, ok := prev[r.Conversation]; ok {
+			if r.Timestamp.Sub(p.Timestamp) <= CacheTTL {
+				read = min(p.InputTokens+p.CacheRead+p.CacheWrite, prompt)
+			}
+		} else {
+			c.FirstPrompt = prompt
+		}
+		if prompt >= MinCacheable {
+			c.Potential += CacheSavings(r.Model, read, prompt-read)
+		}
+		c.LastPrompt = prompt
+		prev[r.Conversation] = r
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].TotalCost > stats[j].TotalCost })
+	return stats
+}
+
+type group struct {
+	key string
+	Summary
+}
+
+// groupSince totals requests that started at or after since by key, most
+// expensive first.
+func (t *Tracker) groupSince(since time.Time, key func(Request) string) []group {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var groups []group
+	index := make(map[string]int)
+	for _, r := range t.requests {
+		if r.Timestamp.Before(since) {
+			continue
+		}
+		k := key(r)
+		i, ok := index[k]
+		if !ok {
+			i = len(groups)
+			index[k] = i
+			groups = append(groups, group{key: k})
+		}
+		groups[i].add(r)
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].TotalCost > groups[j].TotalCost })
+	return groups
+}
+
+// GetTimeline splits the n*width window ending at now into n buckets of the
+// given width, oldest first. Requests outside the window are ignored.
+func (t *Tracker) GetTimeline(now time.Time, width time.Duration, n int) []Bucket {
+	if n <= 0 || width <= 0 {
+		return nil
+	}
+	end := now.Truncate(width).Add(width)
+	start := end.Add(-time.Duration(n) * width)
+
+	buckets := make([]Bucket, n)
+	for i := range buckets {
+		buckets[i].Start = start.Add(time.Duration(i) * width)
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, r := range t.requests {
+		if r.Timestamp.Before(start) || !r.Timestamp.Before(end) {
+			continue
+		}
+		i := int(r.Timestamp.Sub(start) / width)
+		buckets[i].Requests++
+		if !r.CountedUpstream() {
+			buckets[i].Cost += r.Cost
+		}
+		buckets[i].CacheRead += r.CacheRead
+		buckets[i].CacheWrite += r.CacheWrite
+	}
+	return buckets
+}
+
+// RetryOverhead returns the percentage of the cost spent on attempts that
+// failed and were resent.
+func (s Summary) RetryOverhead() float64 {
+	if s.TotalCost == 0 {
+		return 0
+	}
+	return s.RetryCost / s.TotalCost * 100
+}
+
+// ErrorRate returns the percentage of requests that failed.
+func (s Summary) ErrorRate() float64 {
+	if s.TotalRequests == 0 {
+		return 0
+	}
+	return float64(s.TotalErrors) / float64(s.TotalRequests) * 100
+}
+
+// Clear starts a new session. Earlier requests are kept for the wider
+// time-range views but no longer count towards the session.
 func (t *Tracker) Clear() {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	t.requests = nil
-	t.nextID = 0
+	t.sessionStart = time.Now()
 }
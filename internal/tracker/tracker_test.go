@@ -0,0 +1,316 @@
+package tracker
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLoad_PrependsHistory(t *testing.T) {
+	tr := New()
+	now := time.Now()
+	tr.Record(Request{Timestamp: now, Model: "new", Cost: 1})
+	tr.Load([]Request{
+		{Timestamp: now.Add(-48 * time.Hour), Model: "old", Cost: 2},
+	})
+
+	all := tr.GetRequests()
+	if len(all) != 2 || all[0].Model != "old" || all[1].Model != "new" {
+		t.Fatalf("unexpected order: %+v", all)
+	}
+	if all[0].ID != 1 || all[1].ID != 2 {
+		t.Errorf("IDs not reassigned: %d, %d", all[0].ID, all[1].ID)
+	}
+	tr.Record(Request{Timestamp: now, Model: "next"})
+	if got := tr.GetRecentRequests(1)[0].ID; got != 3 {
+		t.Errorf("next ID = %d, want 3", got)
+	}
+}
+
+func TestSummarySince(t *testing.T) {
+	tr := New()
+	now := time.Now()
+	tr.Load([]Request{
+		{Timestamp: now.Add(-2 * time.Hour), Model: "a", Cost: 1, InputTokens: 10},
+		{Timestamp: now.Add(-30 * time.Minute), Model: "a", Cost: 2, InputTokens: 20},
+		{Timestamp: now.Add(-time.Minute), Model: "b", Cost: 4, InputTokens: 40},
+	})
+
+	s := tr.GetSummarySince(now.Add(-time.Hour))
+	if s.TotalRequests != 2 || s.TotalCost != 6 || s.TotalInput != 60 {
+		t.Errorf("last hour summary wrong: %+v", s)
+	}
+	if all := tr.GetSummary(); all.TotalRequests != 3 {
+		t.Errorf("all-time summary should include everything, got %d", all.TotalRequests)
+	}
+
+	ms := tr.GetModelStatsSince(now.Add(-time.Hour))
+	if len(ms) != 2 || ms[0].Model != "b" {
+		t.Errorf("model stats should be sorted by cost: %+v", ms)
+	}
+}
+
+func TestSummary_CancelledAndPartial(t *testing.T) {
+	tr := New()
+	now := time.Now()
+	tr.Load([]Request{
+		{Timestamp: now, StatusCode: 200},
+		{Timestamp: now, StatusCode: 500},
+		{Timestamp: now, StatusCode: 200, Error: ClientCancelled},
+		{Timestamp: now, Error: ClientCancelled},
+		{Timestamp: now, StatusCode: 200, Error: ClientStalled},
+		{Timestamp: now, StatusCode: 200, Truncated: true},
+		{Timestamp: now, StatusCode: 200, Truncated: true, Error: "overloaded_error: Overloaded"},
+	})
+	s := tr.GetSummary()
+	if s.TotalErrors != 2 || s.TotalCancelled != 2 || s.TotalPartial != 2 {
+		t.Errorf("summary = %+v, want 2 errors, 2 cancelled, 2 partial", s)
+	}
+}
+
+func TestSummary_CountedUpstream(t *testing.T) {
+	tr := New()
+	now := time.Now()
+	tr.Load([]Request{
+		{Timestamp: now, Model: "a", Cost: 1, InputTokens: 10},
+		{Timestamp: now, Model: "a", Cost: 2, InputTokens: 20, CountedBy: "gateway:8080-1a2b3c4d"},
+		{Timestamp: now, Model: "b", Cost: 4, CountedBy: "gateway:8080-1a2b3c4d"},
+	})
+	s := tr.GetSummary()
+	if s.TotalRequests != 1 || s.TotalCost != 1 || s.TotalInput != 10 {
+		t.Errorf("summary = %+v; want only the request counted here", s)
+	}
+	if s.UpstreamRequests != 2 || s.UpstreamCost != 6 {
+		t.Errorf("upstream = %d, $%v; want 2, $6", s.UpstreamRequests, s.UpstreamCost)
+	}
+	if ms := tr.GetModelStats(); len(ms) != 1 || ms[0].Requests != 1 || ms[0].TotalCost != 1 {
+		t.Errorf("model stats = %+v; want a's own request only", ms)
+	}
+	if d := tr.GetDailySince(now.Add(-time.Hour)); len(d) == 0 || d[len(d)-1].TotalCost != 1 {
+		t.Errorf("daily = %+v; want $1 today", d)
+	}
+}
+
+func TestClear_StartsNewSession(t *testing.T) {
+	tr := New()
+	tr.Record(Request{Timestamp: time.Now().Add(-time.Second), Cost: 1})
+	tr.Clear()
+	tr.Record(Request{Timestamp: time.Now(), Cost: 2})
+
+	if s := tr.GetSummarySince(tr.SessionStart()); s.TotalRequests != 1 || s.TotalCost != 2 {
+		t.Errorf("session should only contain the post-clear request: %+v", s)
+	}
+	if s := tr.GetSummary(); s.TotalRequests != 2 {
+		t.Errorf("clear should keep history, got %d requests", s.TotalRequests)
+	}
+}
+
+func TestGetTimeline(t *testing.T) {
+	tr := New()
+	now := time.Date(2025, 1, 1, 12, 30, 30, 0, time.UTC)
+	tr.Load([]Request{
+		{Timestamp: now.Add(-10 * time.Minute), Cost: 5}, // outside window
+		{Timestamp: now.Add(-2 * time.Minute), Cost: 1},
+		{Timestamp: now.Add(-2 * time.Minute), Cost: 1},
+		{Timestamp: now, Cost: 3},
+	})
+
+	b := tr.GetTimeline(now, time.Minute, 3)
+	if len(b) != 3 {
+		t.Fatalf("got %d buckets, want 3", len(b))
+	}
+	if b[0].Requests != 2 || b[0].Cost != 2 {
+		t.Errorf("first bucket: %+v", b[0])
+	}
+	if b[1].Requests != 0 {
+		t.Errorf("middle bucket should be empty: %+v", b[1])
+	}
+	if b[2].Requests != 1 || b[2].Cost != 3 {
+		t.Errorf("current bucket: %+v", b[2])
+	}
+}
+
+func TestModelStats_Latency(t *testing.T) {
+	tr := New()
+	now := time.Now()
+	var history []Request
+	for i := 1; i <= 20; i++ {
+		history = append(history, Request{Timestamp: now, Model: "m", Latency: time.Duration(i) * time.Second})
+	}
+	history = append(history, Request{Timestamp: now, Model: "m", Error: "dial"}) // no latency sample
+	tr.Load(history)
+
+	ms := tr.GetModelStats()[0]
+	if ms.AvgLatency != 10500*time.Millisecond {
+		t.Errorf("avg = %v, want 10.5s", ms.AvgLatency)
+	}
+	if ms.P95Latency != 19*time.Second {
+		t.Errorf("p95 = %v, want 19s", ms.P95Latency)
+	}
+	if ms.MaxLatency != 20*time.Second {
+		t.Errorf("max = %v, want 20s", ms.MaxLatency)
+	}
+}
+
+func TestCacheSavings(t *testing.T) {
+	// claude-sonnet-4-6: input $3, cache read $0.30, cache write $3.75 per MTok
+	got := CacheSavings("claude-sonnet-4-6", 1_000_000, 100_000)
+	want := 2.70 - 0.075
+	if diff := got - want; diff > 1e-9 || diff < -1e-9 {
+		t.Errorf("savings = %f, want %f", got, want)
+	}
+
+	ms := ModelStats{InputTokens: 100, CacheRead: 300, CacheWrite: 100}
+	if rate := ms.CacheHitRate(); rate != 60 {
+		t.Errorf("hit rate = %f, want 60", rate)
+	}
+}
+
+func TestContextWindow(t *testing.T) {
+	if w := ContextWindow("claude-sonnet-4-6"); w != DefaultContextWindow {
+		t.Errorf("built-in window = %d", w)
+	}
+	models, aliases, windows := pricingStore.models, pricingStore.aliases, pricingStore.windows
+	defer func() {
+		pricingStore.models, pricingStore.aliases, pricingStore.windows = models, aliases, windows
+	}()
+	ApplyPricing(map[string]ModelPricingEntry{
+		"claude-sonnet-4-6": {Aliases: []string{"sonnet"}, InputPerMTok: 3, ContextWindow: 1_000_000},
+	}, nil)
+	if w := ContextWindow("sonnet"); w != 1_000_000 {
+		t.Errorf("configured window via alias = %d", w)
+	}
+	if got := ContextUsed("claude-sonnet-4-6", 250_000); got != 0.25 {
+		t.Errorf("context used = %v", got)
+	}
+	if w := ContextWindow("some-other-model"); w != DefaultContextWindow {
+		t.Errorf("unknown model window = %d", w)
+	}
+}
+
+func TestGetDailySince(t *testing.T) {
+	tr := New()
+	day := time.Date(2026, 3, 10, 0, 0, 0, 0, time.Local)
+	tr.Load([]Request{
+		{Timestamp: day.Add(-2 * time.Hour), Cost: 5}, // previous day
+		{Timestamp: day.Add(1 * time.Hour), Cost: 1},
+		{Timestamp: day.Add(23 * time.Hour), Cost: 2, StatusCode: 500},
+		{Timestamp: day.Add(25 * time.Hour), Cost: 4},
+	})
+
+	days := tr.GetDailySince(day)
+	if len(days) != 2 {
+		t.Fatalf("got %d days, want 2", len(days))
+	}
+	if !days[0].Date.Equal(day) || days[0].TotalRequests != 2 || days[0].TotalCost != 3 || days[0].TotalErrors != 1 {
+		t.Errorf("day 0 = %+v", days[0])
+	}
+	if !days[1].Date.Equal(day.AddDate(0, 0, 1)) || days[1].TotalCost != 4 {
+		t.Errorf("day 1 = %+v", days[1])
+	}
+}
+
+func TestGetHeatmapSince(t *testing.T) {
+	tr := New()
+	tue := time.Date(2026, 3, 10, 14, 30, 0, 0, time.Local) // a Tuesday
+	tr.Load([]Request{
+		{Timestamp: tue, Cost: 1},
+		{Timestamp: tue.Add(10 * time.Minute), Cost: 2},
+		{Timestamp: tue.AddDate(0, 0, 5).Add(-14 * time.Hour), Cost: 0.5}, // Sunday 00:30
+	})
+
+	h := tr.GetHeatmapSince(time.Time{})
+	if s := h[1][14]; s.TotalRequests != 2 || s.TotalCost != 3 {
+		t.Errorf("Tuesday 14:00 = %+v", s)
+	}
+	if s := h[6][0]; s.TotalRequests != 1 {
+		t.Errorf("Sunday 00:00 = %+v", s)
+	}
+	if got := h.Peak(); got != 3 {
+		t.Errorf("peak = %v, want 3", got)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	tr := New()
+	var a, b int
+	unsubA := tr.Subscribe(func(Request) { a++ })
+	tr.Subscribe(func(Request) { b++ })
+
+	tr.Record(Request{})
+	unsubA()
+	tr.Record(Request{})
+	if a != 1 || b != 2 {
+		t.Errorf("a = %d, b = %d; want 1, 2", a, b)
+	}
+}
+
+func TestGetProjectStatsSince(t *testing.T) {
+	tr := New()
+	now := time.Now()
+	tr.Load([]Request{
+		{Timestamp: now.Add(-2 * time.Hour), Project: "old", Cost: 9},
+		{Timestamp: now, Project: "web", Cost: 1},
+		{Timestamp: now, Cost: 2, Error: "boom"},
+		{Timestamp: now, Project: "web", Cost: 3},
+	})
+
+	got := tr.GetProjectStatsSince(now.Add(-time.Hour))
+	if len(got) != 2 || got[0].Project != "web" || got[0].TotalRequests != 2 || got[0].TotalCost != 4 {
+		t.Fatalf("unexpected stats: %+v", got)
+	}
+	if got[1].Project != "" || got[1].TotalErrors != 1 {
+		t.Errorf("unattributed requests: %+v", got[1])
+	}
+}
+
+func TestGetConversationStatsSince(t *testing.T) {
+	tr := New()
+	now := time.Now()
+	m := "claude-sonnet-4-6"
+	tr.Load([]Request{
+		// Cached: each turn reads the one before.
+		{Timestamp: now, Model: m, Conversation: "a", Messages: 1, InputTokens: 10, CacheWrite: 2000, Cost: 1},
+		{Timestamp: now.Add(time.Minute), Model: m, Conversation: "a", Messages: 3, InputTokens: 10, CacheRead: 2000, CacheWrite: 500, Cost: 1},
+		// Never cached, with a pause that outlasts the cache.
+		{Timestamp: now, Model: m, Conversation: "b", Messages: 1, InputTokens: 3000, Cost: 2},
+		{Timestamp: now.Add(time.Minute), Model: m, Conversation: "b", Messages: 3, InputTokens: 4000, Cost: 2},
+		{Timestamp: now.Add(20 * time.Minute), Model: m, Conversation: "b", Messages: 5, InputTokens: 5000, Cost: 2},
+		{Timestamp: now, Model: m, Cost: 5}, // no conversation
+	})
+
+	got := tr.GetConversationStatsSince(time.Time{})
+	if len(got) != 2 || got[0].ID != "b" || got[1].ID != "a" {
+		t.Fatalf("unexpected conversations: %+v", got)
+	}
+	b, a := got[0], got[1]
+	if b.TotalRequests != 3 || b.Messages != 5 || b.FirstPrompt != 3000 || b.LastPrompt != 5000 || b.MaxGap != 19*time.Minute {
+		t.Errorf("uncached conversation: %+v", b)
+	}
+	// Only the second request could have read the first's 3,000 tokens.
+	if b.Saved != 0 || math.Abs(b.Potential-CacheSavings(m, 3000, 3000+1000+5000)) > 1e-9 {
+		t.Errorf("uncached savings: saved %g, potential %g", b.Saved, b.Potential)
+	}
+	if a.CacheHitRate() < 44 || a.CacheHitRate() > 45 || math.Abs(a.Saved-CacheSavings(m, 2000, 2500)) > 1e-9 {
+		t.Errorf("cached conversation: hit rate %.1f%%, saved %g", a.CacheHitRate(), a.Saved)
+	}
+}
+
+func TestGetRaceStatsSince(t *testing.T) {
+	tr := New()
+	now := time.Now()
+	opus, haiku := "claude-opus-4-6", "claude-haiku-4-5"
+	tr.Load([]Request{
+		{Timestamp: now, Model: haiku, Cost: 1, Latency: time.Second, Race: &Race{Requested: opus, Rival: opus}},
+		{Timestamp: now, Model: opus, Cost: 4, Race: &Race{Requested: opus, Rival: haiku, Lost: true}},
+		{Timestamp: now, Model: opus, Cost: 5, Latency: 3 * time.Second, Race: &Race{Requested: opus, Rival: haiku}},
+		{Timestamp: now, Model: haiku, Cost: 0.5, Race: &Race{Requested: opus, Rival: opus, Lost: true}},
+		{Timestamp: now, Model: opus, Cost: 9},
+	})
+
+	got := tr.GetRaceStatsSince(time.Time{})
+	want := RaceStats{Model: opus, Challenger: haiku, Races: 2, Wins: 1, Cost: 6, Wasted: 4.5, Latency: 2 * time.Second}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -1,51 +1,128 @@
 package tui
 
 import (
-	"encoding/csv"
+	"cmp"
 	"fmt"
-	"os"
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 
+	"miser/internal/audit"
+	"miser/internal/budget"
+	"miser/internal/format"
+	"miser/internal/slo"
 	"miser/internal/tracker"
 )
 
-const refreshInterval = 500 * time.Millisecond
-
 type App struct {
 	app     *tview.Application
+	screen  tcell.Screen // captured on first draw, for clipboard access
 	tracker *tracker.Tracker
+	budget  *budget.Budget
+	audit   *audit.Log
+	slo     []slo.Objective
+
+	skipConfirm bool
+	attached    bool
 
 	proxyAddr  string
 	targetAddr string
+	upstreams  Upstreams // nil with a single upstream
 	startTime  time.Time
-	statusMsg  string
-	statusAt   time.Time
+	scope      scope
+	latency    latencyMode
+	filter     statusFilter
+	threads    bool // group the request log by conversation
+	follow     bool
+	rendering  bool  // suppresses selection callbacks during rebuilds
+	requestIDs []int // request ID per request-log row (row 1 = index 0)
+	selectedID int
+
+	exportFormat int // index into export.Formats, remembered between dialogs
+	exportDir    string
+	statusMsg    string
+	statusAt     time.Time
+
+	dirty         chan struct{} // see invalidate
+	lastRender    time.Time
+	width, height int
 
 	header       *tview.TextView
 	statsBar     *tview.TextView
+	chart        *tview.TextView
 	modelTable   *tview.Table
+	cacheBoard   *tview.Flex
+	cacheTrend   *tview.TextView
+	cacheTable   *tview.Table
+	projectTable *tview.Table
+	clientTable  *tview.Table
+	nodeTable    *tview.Table
+	heatTable    *tview.Table
+	boards       *tview.Pages
+	board        int
 	requestTable *tview.Table
 	footer       *tview.TextView
 	layout       *tview.Flex
+	pages        *tview.Pages // layout plus any open dialog
+}
+
+// Options configures the dashboard.
+type Options struct {
+	ProxyAddr  string
+	TargetAddr string
+	Budget     *budget.Budget // nil or a zero limit hides the budget
+	Audit      *audit.Log     // records clears, budget resets and exports; may be nil
+	ExportDir  string         // the export dialog's initial directory; "" means "."
+
+	// SkipConfirm runs Clear and budget reset without asking first.
+	SkipConfirm bool
+
+	// Attached marks a dashboard mirroring another miser instance
+	// (miser top); ProxyAddr is then that instance's address.
+	Attached bool
+
+	// Upstreams, if set, names the active upstream in place of TargetAddr
+	// and lets <u> switch it.
+	Upstreams Upstreams
+
+	// SLO, if set, adds an SLO column to the Models pane showing each
+	// model against its latency objective.
+	SLO []slo.Objective
+}
+
+// Upstreams is the choice among several upstream endpoints; see
+// proxy.Upstreams.
+type Upstreams interface {
+	Status() string
+	Next() (url string, ok bool)
 }
 
-func New(t *tracker.Tracker, proxyAddr, targetAddr string) *App {
+func New(t *tracker.Tracker, opts Options) *App {
 	a := &App{
-		app:        tview.NewApplication(),
-		tracker:    t,
-		proxyAddr:  proxyAddr,
-		targetAddr: targetAddr,
-		startTime:  time.Now(),
+		app:         tview.NewApplication(),
+		tracker:     t,
+		budget:      opts.Budget,
+		audit:       opts.Audit,
+		slo:         opts.SLO,
+		skipConfirm: opts.SkipConfirm,
+		attached:    opts.Attached,
+		proxyAddr:   opts.ProxyAddr,
+		targetAddr:  opts.TargetAddr,
+		upstreams:   opts.Upstreams,
+		startTime:   time.Now(),
+		follow:      true,
+		exportDir:   cmp.Or(opts.ExportDir, "."),
+		dirty:       make(chan struct{}, 1),
 	}
 	a.buildUI()
+	t.Subscribe(func(tracker.Request) { a.invalidate() })
 	return a
 }
 
 func (a *App) Run() error {
+	a.invalidate()
 	go a.refreshLoop()
 	return a.app.Run()
 }
@@ -66,28 +143,49 @@ func (a *App) buildUI() {
 		SetTextAlign(tview.AlignCenter)
 	a.statsBar.SetBackgroundColor(tcell.ColorDarkSlateGray)
 
+	a.chart = tview.NewTextView().
+		SetDynamicColors(true).
+		SetWrap(false)
+	a.chart.
+		SetBorder(true).
+		SetTitle(" Activity (per minute) ").
+		SetTitleAlign(tview.AlignLeft).
+		SetBorderColor(tcell.ColorDarkCyan).
+		SetTitleColor(tcell.ColorYellow)
+
 	a.modelTable = tview.NewTable().
 		SetBorders(false).
 		SetSelectable(true, false).
-		SetFixed(1, 0)
+		SetFixed(1, 1)
 	a.modelTable.
 		SetBorder(true).
-		SetTitle(" Models ").
+		SetTitle(" Models — session ").
 		SetTitleAlign(tview.AlignLeft).
 		SetBorderColor(tcell.ColorDarkCyan).
 		SetTitleColor(tcell.ColorYellow)
 
+	a.boards = tview.NewPages().
+		AddPage(boardNames[boardModels], a.modelTable, true, true).
+		AddPage(boardNames[boardCache], a.buildCacheBoard(), true, false).
+		AddPage(boardNames[boardProjects], a.buildProjectsBoard(), true, false).
+		AddPage(boardNames[boardClients], a.buildClientsBoard(), true, false).
+		AddPage(boardNames[boardNodes], a.buildNodesBoard(), true, false).
+		AddPage(boardNames[boardHeatmap], a.buildHeatmapBoard(), true, false)
+
 	a.requestTable = tview.NewTable().
 		SetBorders(false).
 		SetSelectable(true, false).
-		SetFixed(1, 0)
+		SetFixed(1, 1)
 	a.requestTable.
 		SetBorder(true).
-		SetTitle(" Request Log ").
+		SetTitle(" Request Log — session [green]● live[-] ").
 		SetTitleAlign(tview.AlignLeft).
 		SetBorderColor(tcell.ColorDarkCyan).
 		SetTitleColor(tcell.ColorYellow)
 
+	a.requestTable.SetSelectionChangedFunc(a.onRequestSelected)
+	a.requestTable.SetSelectedFunc(func(int, int) { a.showDetail() })
+
 	a.footer = tview.NewTextView().
 		SetDynamicColors(true).
 		SetTextAlign(tview.AlignCenter)
@@ -96,11 +194,17 @@ func (a *App) buildUI() {
 	a.layout = tview.NewFlex().SetDirection(tview.FlexRow).
 		AddItem(a.header, 4, 0, false).
 		AddItem(a.statsBar, 1, 0, false).
-		AddItem(a.modelTable, 0, 1, false).
+		AddItem(a.chart, 4, 0, false).
+		AddItem(a.boards, 0, 1, false).
 		AddItem(a.requestTable, 0, 3, true).
 		AddItem(a.footer, 1, 0, false)
 
+	a.pages = tview.NewPages().AddPage("main", a.layout, true, true)
+
 	a.app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if a.dialogOpen() {
+			return event
+		}
 		switch event.Key() {
 		case tcell.KeyTab:
 			a.toggleFocus()
@@ -111,62 +215,211 @@ func (a *App) buildUI() {
 				a.app.Stop()
 				return nil
 			case 'c':
-				a.tracker.Clear()
-				a.setStatus("Session cleared")
+				a.confirm("Clear the session?\n\nHistory is kept; the dashboard starts a new session.", func() {
+					a.tracker.Clear()
+					a.setStatus("Session cleared")
+					a.record(audit.Clear, "", nil)
+				})
 				return nil
 			case 'e':
-				a.export()
+				a.showExportDialog()
+				return nil
+			case 's':
+				a.cycleScope()
+				return nil
+			case 'v':
+				a.cycleBoard()
+				return nil
+			case 'f':
+				a.jumpToLive()
+				return nil
+			case 'B':
+				if a.hasBudget() {
+					a.confirm("Reset the budget?\n\nSpend starts again from zero.", func() {
+						a.budget.Reset()
+						a.setStatus("Budget reset")
+						a.record(audit.Budget, "spend reset", nil)
+					})
+				}
+				return nil
+			case 'y':
+				a.copySelected(false)
+				return nil
+			case 'Y':
+				a.copySelected(true)
+				return nil
+			case 'x':
+				a.filter = a.filter.next()
+				a.updateRequestTitle()
+				a.setStatus("Showing " + a.filter.String() + " requests")
+				return nil
+			case 'n':
+				a.toggleTokenUnit()
+				return nil
+			case 'g':
+				a.toggleThreads()
+				return nil
+			case 'u':
+				a.nextUpstream()
+				return nil
+			case 'l':
+				a.latency = a.latency.next()
+				a.setStatus("Model latency: " + a.latency.String())
 				return nil
 			}
 		}
 		return event
 	})
 
-	a.app.SetRoot(a.layout, true).EnableMouse(true)
+	a.app.SetBeforeDrawFunc(func(screen tcell.Screen) bool {
+		a.screen = screen
+		a.watchResize(screen.Size())
+		return false
+	})
+	a.app.SetRoot(a.pages, true).EnableMouse(true)
 }
 
 func (a *App) toggleFocus() {
 	if a.app.GetFocus() == a.requestTable {
-		a.app.SetFocus(a.modelTable)
+		a.app.SetFocus(a.boardTable())
 	} else {
 		a.app.SetFocus(a.requestTable)
 	}
 }
 
+// Boards share the pane above the request log; <v> cycles through them.
+const (
+	boardModels = iota
+	boardCache
+	boardProjects
+	boardClients
+	boardNodes
+	boardHeatmap
+	numBoards
+)
+
+var boardNames = []string{"Models", "Cache", "Projects", "Clients", "Nodes", "Heatmap"}
+
+func (a *App) cycleBoard() {
+	refocus := a.app.GetFocus() != a.requestTable
+	a.board = (a.board + 1) % numBoards
+	a.boards.SwitchToPage(boardNames[a.board])
+	if refocus {
+		a.app.SetFocus(a.boardTable())
+	}
+	a.setStatus("Board: " + boardNames[a.board])
+}
+
+func (a *App) boardTable() *tview.Table {
+	switch a.board {
+	case boardCache:
+		return a.cacheTable
+	case boardProjects:
+		return a.projectTable
+	case boardClients:
+		return a.clientTable
+	case boardNodes:
+		return a.nodeTable
+	case boardHeatmap:
+		return a.heatTable
+	}
+	return a.modelTable
+}
+
+// setStatus shows msg in the footer for a few seconds. Every view change
+// reports itself here, so it also schedules a redraw.
 func (a *App) setStatus(msg string) {
 	a.statusMsg = msg
 	a.statusAt = time.Now()
+	a.invalidate()
 }
 
-func (a *App) refreshLoop() {
-	tick := time.NewTicker(refreshInterval)
-	defer tick.Stop()
+// record adds a dashboard action to the audit log. An attached dashboard
+// has none: the proxy's data is not its to change.
+func (a *App) record(action, detail string, err error) {
+	e := audit.Entry{Action: action, Source: "dashboard", Detail: detail}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if err := a.audit.Record(e); err != nil {
+		a.setStatus(fmt.Sprintf("Audit log: %v", err))
+	}
+}
 
-	for range tick.C {
-		a.app.QueueUpdateDraw(func() {
-			a.renderHeader()
-			a.renderStats()
-			a.renderModels()
-			a.renderRequests()
-			a.renderFooter()
-		})
+// applyFilter drops requests hidden by the request-log filters.
+func (a *App) applyFilter(reqs []tracker.Request) []tracker.Request {
+	if a.filter == filterAll {
+		return reqs
+	}
+	kept := reqs[:0]
+	for _, r := range reqs {
+		if a.filter.keeps(r) {
+			kept = append(kept, r)
+		}
 	}
+	return kept
 }
 
 func (a *App) renderHeader() {
 	uptime := time.Since(a.startTime).Truncate(time.Second)
+	label := "Proxy"
+	if a.attached {
+		label = "Attached to"
+	}
+	target := a.targetAddr
+	if a.upstreams != nil {
+		target = tview.Escape(a.upstreams.Status())
+	}
 	text := fmt.Sprintf(
-		" [green]●[white] Proxy: [::b]%s[-::-]    [blue]↗[white] Target: [::b]%s[-::-]    [yellow]⏱[white] Uptime: [::b]%s[-::-]",
-		a.proxyAddr, a.targetAddr, formatDuration(uptime),
+		" [green]●[white] %s: [::b]%s[-::-]    [blue]↗[white] Target: [::b]%s[-::-]    [yellow]⏱[white] Uptime: [::b]%s[-::-]",
+		label, a.proxyAddr, target, formatDuration(uptime),
 	)
+	if rl, ok := a.tracker.RateLimit(); ok {
+		text += "    " + rateLimitBar(rl, time.Now())
+	}
 	a.header.SetText(text)
 }
 
+// rateLimitBar shows what is left of upstream's request and token limits
+// until they reset, colored by the tightest.
+func rateLimitBar(rl tracker.RateLimit, now time.Time) string {
+	_, tight := rl.Tightest(now)
+	color := "green"
+	switch h := tight.Headroom(now); {
+	case h < 0.1:
+		color = "red"
+	case h < 0.25:
+		color = "yellow"
+	}
+	left := func(l tracker.Limit) string {
+		if !l.Reset.IsZero() && !now.Before(l.Reset) {
+			return formatTokens(l.Limit) // reset since
+		}
+		return formatTokens(l.Remaining)
+	}
+	text := fmt.Sprintf("[%s]▮[white] Rate limit:", color)
+	if rl.Requests.Limit > 0 {
+		text += fmt.Sprintf(" [::b]%s[-::-]/%s req", left(rl.Requests), formatTokens(rl.Requests.Limit))
+	}
+	if tok := rl.Tokens; tok.Limit > 0 {
+		text += fmt.Sprintf(" [::b]%s[-::-]/%s tok", left(tok), formatTokens(tok.Limit))
+	} else if in := rl.InputTokens; in.Limit > 0 {
+		text += fmt.Sprintf(" [::b]%s[-::-]/%s in", left(in), formatTokens(in.Limit))
+		if out := rl.OutputTokens; out.Limit > 0 {
+			text += fmt.Sprintf(" [::b]%s[-::-]/%s out", left(out), formatTokens(out.Limit))
+		}
+	}
+	if tight.Headroom(now) < 1 && !tight.Reset.IsZero() {
+		text += " · resets in " + formatDuration(tight.Reset.Sub(now).Round(time.Second))
+	}
+	return text
+}
+
 func (a *App) renderStats() {
-	s := a.tracker.GetSummary()
+	s := a.tracker.GetSummarySince(a.scopeSince())
 	text := fmt.Sprintf(
-		" [green::b]%s[-::-] cost    [white::b]%d[-::-] requests    [cyan::b]%s[-::-] input    [cyan::b]%s[-::-] output    [blue::b]%s[-::-] cache read    [blue::b]%s[-::-] cache write",
-		formatCost(s.TotalCost), s.TotalRequests,
+		" [yellow]%s[-]    [green::b]%s[-::-] cost    [white::b]%d[-::-] requests    [cyan::b]%s[-::-] input    [cyan::b]%s[-::-] output    [blue::b]%s[-::-] cache read    [blue::b]%s[-::-] cache write",
+		a.scope, formatCost(s.TotalCost), s.TotalRequests,
 		formatTokens(s.TotalInput), formatTokens(s.TotalOutput),
 		formatTokens(s.TotalCacheR), formatTokens(s.TotalCacheW),
 	)
@@ -174,29 +427,58 @@ func (a *App) renderStats() {
 		pct := 100 - 100*s.CompressedSize/s.OriginalSize
 		text += fmt.Sprintf("    [magenta::b]%d%%[-::-] compressed", pct)
 	}
+	errColor := "white"
+	if s.TotalErrors > 0 {
+		errColor = "red"
+	}
+	text += fmt.Sprintf("    [%s::b]%.1f%%[-::-] errors", errColor, s.ErrorRate())
+	if s.TotalCancelled > 0 {
+		text += fmt.Sprintf("    [yellow::b]%d[-::-] cancelled", s.TotalCancelled)
+	}
+	if s.TotalPartial > 0 {
+		text += fmt.Sprintf("    [yellow::b]%d[-::-] partial", s.TotalPartial)
+	}
+	if s.TotalRetries > 0 {
+		text += fmt.Sprintf("    [yellow::b]%d[-::-] retries (%.1f%% of cost)", s.TotalRetries, s.RetryOverhead())
+	}
+	if s.TotalFallbacks > 0 {
+		text += fmt.Sprintf("    [yellow::b]%d[-::-] fallbacks", s.TotalFallbacks)
+	}
+	if s.UpstreamRequests > 0 {
+		text += fmt.Sprintf("    [gray::b]%s[-::-] counted upstream", formatCost(s.UpstreamCost))
+	}
+	if a.hasBudget() {
+		text += "    " + budgetBar(a.budget.Status(), time.Now())
+	}
 	a.statsBar.SetText(text)
 }
 
 func (a *App) renderModels() {
 	a.modelTable.Clear()
 
-	headers := []string{"MODEL", "REQS", "INPUT", "OUTPUT", "CACHE R", "CACHE W", "COST", "%"}
-	for i, h := range headers {
-		align := tview.AlignRight
-		if i == 0 {
-			align = tview.AlignLeft
-		}
-		a.modelTable.SetCell(0, i,
-			tview.NewTableCell(" "+h+" ").
-				SetTextColor(tcell.ColorYellow).
-				SetAttributes(tcell.AttrBold).
-				SetSelectable(false).
-				SetAlign(align),
-		)
+	lat := strings.ToUpper(a.latency.String())
+	compact := isCompact(a.modelTable)
+	cols := []column{
+		leftCol("MODEL", ""),
+		rightCol("REQS", "#"),
+		rightCol("INPUT", "IN"),
+		rightCol("OUTPUT", "OUT"),
+		rightCol("TOOL", "TL"),
+		rightCol("CACHE R", "CR"),
+		rightCol("CACHE W", "CW"),
+		rightCol("LAT "+lat, lat),
+		rightCol("COST", ""),
+		rightCol("%", ""),
+	}
+	if len(a.slo) > 0 {
+		cols = append(cols, rightCol("SLO", ""))
 	}
+	setHeaders(a.modelTable, cols, compact)
 
-	stats := a.tracker.GetModelStats()
-	summary := a.tracker.GetSummary()
+	since := a.scopeSince()
+	stats := a.tracker.GetModelStatsSince(since)
+	summary := a.tracker.GetSummarySince(since)
+	slos := slo.Evaluate(a.tracker, a.slo, time.Now()) // over each objective's own window, not the scope
 
 	for i, ms := range stats {
 		row := i + 1
@@ -204,22 +486,29 @@ func (a *App) renderModels() {
 		if summary.TotalCost > 0 {
 			pct = ms.TotalCost / summary.TotalCost * 100
 		}
-		a.setModelRow(row, ms, pct)
+		a.setModelRow(row, ms, pct, compact)
+		if len(a.slo) > 0 {
+			text, color := sloText(slos[ms.Model], compact)
+			a.modelTable.SetCell(row, len(cols)-1,
+				tview.NewTableCell(" "+text+" ").SetTextColor(color).SetAlign(tview.AlignRight))
+		}
 	}
 }
 
-func (a *App) setModelRow(row int, ms tracker.ModelStats, pct float64) {
+func (a *App) setModelRow(row int, ms tracker.ModelStats, pct float64, compact bool) {
 	cells := []struct {
 		text  string
 		color tcell.Color
 		align int
 	}{
-		{" " + shortModel(ms.Model) + " ", tcell.ColorWhite, tview.AlignLeft},
+		{" " + modelLabel(ms.Model, compact) + " ", tcell.ColorWhite, tview.AlignLeft},
 		{fmt.Sprintf(" %d ", ms.Requests), tcell.ColorWhite, tview.AlignRight},
 		{" " + formatTokens(ms.InputTokens) + " ", tcell.ColorWhite, tview.AlignRight},
 		{" " + formatTokens(ms.OutputTokens) + " ", tcell.ColorWhite, tview.AlignRight},
+		{" " + toolText(ms, compact) + " ", tcell.ColorWhite, tview.AlignRight},
 		{" " + formatTokens(ms.CacheRead) + " ", tcell.ColorSteelBlue, tview.AlignRight},
 		{" " + formatTokens(ms.CacheWrite) + " ", tcell.ColorSteelBlue, tview.AlignRight},
+		{" " + formatLatency(a.latency.pick(ms)) + " ", tcell.ColorWhite, tview.AlignRight},
 		{" " + formatCost(ms.TotalCost) + " ", costColor(ms.TotalCost), tview.AlignRight},
 		{fmt.Sprintf(" %.1f%% ", pct), tcell.ColorWhite, tview.AlignRight},
 	}
@@ -232,68 +521,130 @@ func (a *App) setModelRow(row int, ms tracker.ModelStats, pct float64) {
 	}
 }
 
+// toolText shows a model's output tokens spent on tool calls, with their
+// share of its output unless compact.
+func toolText(ms tracker.ModelStats, compact bool) string {
+	if ms.ToolTokens == 0 {
+		return "-"
+	}
+	if compact {
+		return formatTokens(ms.ToolTokens)
+	}
+	return fmt.Sprintf("%s (%.0f%%)", formatTokens(ms.ToolTokens), ms.ToolShare())
+}
+
 func (a *App) renderRequests() {
+	prevRow, _ := a.requestTable.GetSelection()
+	a.rendering = true
 	a.requestTable.Clear()
+	a.rendering = false
+
+	compact := isCompact(a.requestTable)
+	setHeaders(a.requestTable, []column{
+		leftCol("TIME", ""),
+		leftCol("MODEL", ""),
+		leftCol("CLIENT", ""),
+		rightCol("INPUT", "IN"),
+		rightCol("OUTPUT", "OUT"),
+		rightCol("UTIL", ""),
+		rightCol("COST", ""),
+		rightCol("SAVED", "SAV"),
+		rightCol("LATENCY", "LAT"),
+		rightCol("STATUS", "ST"),
+	}, compact)
+
+	recent := a.tracker.GetRecentRequestsSince(a.scopeSince(), 500)
+	recent = a.applyFilter(recent)
+	defer a.restoreSelection(prevRow)
+	a.requestIDs = a.requestIDs[:0]
+	if a.threads {
+		a.renderThreads(recent, compact)
+		return
+	}
+	for i, req := range recent {
+		a.requestIDs = append(a.requestIDs, req.ID)
+		a.setRequestRow(i+1, req, compact, false)
+	}
+}
 
-	headers := []string{"TIME", "MODEL", "INPUT", "OUTPUT", "COST", "SAVED", "LATENCY", "STATUS"}
-	for i, h := range headers {
-		align := tview.AlignRight
-		if i <= 1 {
-			align = tview.AlignLeft
-		}
-		a.requestTable.SetCell(0, i,
-			tview.NewTableCell(" "+h+" ").
-				SetTextColor(tcell.ColorYellow).
-				SetAttributes(tcell.AttrBold).
-				SetSelectable(false).
-				SetAlign(align),
-		)
+// setRequestRow fills a request-log row; nested rows sit under their
+// conversation's subtotal.
+func (a *App) setRequestRow(row int, req tracker.Request, compact, nested bool) {
+	statusText := fmt.Sprintf("%d", req.StatusCode)
+	statusColor := tcell.ColorGreen
+	if req.Cancelled() {
+		statusText = "CANCEL"
+		statusColor = tcell.ColorYellow
+	} else if req.Partial() {
+		statusText = "PART"
+		statusColor = tcell.ColorYellow
+	} else if req.Retried() {
+		statusText = "RETRY"
+		statusColor = tcell.ColorYellow
+	} else if req.Error != "" {
+		statusText = "ERR"
+		statusColor = tcell.ColorRed
+	} else if req.StatusCode >= 400 {
+		statusColor = tcell.ColorRed
+	} else if req.Race != nil && req.Race.Lost {
+		statusText = "LOST"
+		statusColor = tcell.ColorGray
 	}
 
-	recent := a.tracker.GetRecentRequests(500)
-	for i, req := range recent {
-		row := i + 1
-		statusText := fmt.Sprintf("%d", req.StatusCode)
-		statusColor := tcell.ColorGreen
-		if req.Error != "" {
-			statusText = "ERR"
-			statusColor = tcell.ColorRed
-		} else if req.StatusCode >= 400 {
-			statusColor = tcell.ColorRed
-		}
+	savedText := "-"
+	if req.OriginalSize > 0 && req.CompressedSize < req.OriginalSize {
+		pct := 100 - 100*req.CompressedSize/req.OriginalSize
+		savedText = fmt.Sprintf("%d%%", pct)
+	}
 
-		savedText := "-"
-		if req.OriginalSize > 0 && req.CompressedSize < req.OriginalSize {
-			pct := 100 - 100*req.CompressedSize/req.OriginalSize
-			savedText = fmt.Sprintf("%d%%", pct)
-		}
+	// Estimated counts are marked, as they are not what was billed.
+	approx := ""
+	if req.EstimatedUsage {
+		approx = "~"
+	}
 
-		cells := []struct {
-			text  string
-			color tcell.Color
-			align int
-		}{
-			{" " + req.Timestamp.Format("15:04:05") + " ", tcell.ColorGray, tview.AlignLeft},
-			{" " + shortModel(req.Model) + " ", tcell.ColorWhite, tview.AlignLeft},
-			{" " + formatTokens(req.InputTokens) + " ", tcell.ColorWhite, tview.AlignRight},
-			{" " + formatTokens(req.OutputTokens) + " ", tcell.ColorWhite, tview.AlignRight},
-			{" " + formatCost(req.Cost) + " ", costColor(req.Cost), tview.AlignRight},
-			{" " + savedText + " ", tcell.ColorPurple, tview.AlignRight},
-			{" " + formatLatency(req.Latency) + " ", tcell.ColorWhite, tview.AlignRight},
-			{" " + statusText + " ", statusColor, tview.AlignRight},
-		}
-		for j, c := range cells {
-			a.requestTable.SetCell(row, j,
-				tview.NewTableCell(c.text).
-					SetTextColor(c.color).
-					SetAlign(c.align),
-			)
-		}
+	utilText, utilColor := formatUtil(req.ContextUsed)
+
+	// A fallback model stood in for the one asked for.
+	modelColor := tcell.ColorWhite
+	if req.FallbackFrom != "" {
+		modelColor = tcell.ColorYellow
+	}
+
+	timeText := " " + req.Timestamp.Format("15:04:05") + " "
+	if nested {
+		timeText = " ·" + timeText
+	}
+	cells := []struct {
+		text  string
+		color tcell.Color
+		align int
+	}{
+		{timeText, tcell.ColorGray, tview.AlignLeft},
+		{" " + modelLabel(req.Model, compact) + " ", modelColor, tview.AlignLeft},
+		{" " + clientLabel(req.Client, compact) + " ", tcell.ColorAqua, tview.AlignLeft},
+		{" " + approx + formatTokens(req.InputTokens) + " ", tcell.ColorWhite, tview.AlignRight},
+		{" " + approx + formatTokens(req.OutputTokens) + " ", tcell.ColorWhite, tview.AlignRight},
+		{" " + utilText + " ", utilColor, tview.AlignRight},
+		{" " + approx + formatCost(req.Cost) + " ", costColor(req.Cost), tview.AlignRight},
+		{" " + savedText + " ", tcell.ColorPurple, tview.AlignRight},
+		{" " + formatLatency(req.Latency) + " ", tcell.ColorWhite, tview.AlignRight},
+		{" " + statusText + " ", statusColor, tview.AlignRight},
+	}
+	for j, c := range cells {
+		a.requestTable.SetCell(row, j,
+			tview.NewTableCell(c.text).
+				SetTextColor(c.color).
+				SetAlign(c.align),
+		)
 	}
 }
 
 func (a *App) renderFooter() {
-	base := " [yellow]<q>[white] Quit  [yellow]<c>[white] Clear  [yellow]<e>[white] Export  [yellow]<Tab>[white] Switch Focus"
+	base := " [yellow]<q>[white] Quit  [yellow]<c>[white] Clear  [yellow]<e>[white] Export  [yellow]<s>[white] Scope  [yellow]<v>[white] Board  [yellow]<l>[white] Latency  [yellow]<n>[white] Numbers  [yellow]<x>[white] Filter  [yellow]<g>[white] Threads  [yellow]<f>[white] Follow  [yellow]<Enter>[white] Details  [yellow]<y/Y>[white] Copy  [yellow]<B>[white] Reset Budget  [yellow]<←/→>[white] Scroll  [yellow]<Tab>[white] Switch Focus"
+	if a.upstreams != nil {
+		base += "  [yellow]<u>[white] Upstream"
+	}
 	if a.statusMsg != "" && time.Since(a.statusAt) < 3*time.Second {
 		base += fmt.Sprintf("  [green]│ %s[-]", a.statusMsg)
 	} else {
@@ -302,70 +653,116 @@ func (a *App) renderFooter() {
 	a.footer.SetText(base)
 }
 
-func (a *App) export() {
-	requests := a.tracker.GetRequests()
-	if len(requests) == 0 {
-		a.setStatus("Nothing to export")
+// nextUpstream switches to the next upstream endpoint, which only the
+// manual policy allows.
+func (a *App) nextUpstream() {
+	if a.upstreams == nil {
 		return
 	}
-
-	filename := fmt.Sprintf("miser-export-%s.csv", time.Now().Format("2006-01-02-150405"))
-	f, err := os.Create(filename)
-	if err != nil {
-		a.setStatus(fmt.Sprintf("Export failed: %v", err))
+	url, ok := a.upstreams.Next()
+	if !ok {
+		a.setStatus(`Upstream is chosen automatically; set [upstreams] policy = "manual" to switch`)
 		return
 	}
-	defer f.Close()
-
-	w := csv.NewWriter(f)
-	w.Write([]string{"Time", "Model", "Input Tokens", "Output Tokens", "Cache Read", "Cache Write", "Cost", "Latency (s)", "Status", "Original Bytes", "Compressed Bytes"})
-	for _, r := range requests {
-		w.Write([]string{
-			r.Timestamp.Format(time.RFC3339),
-			r.Model,
-			strconv.Itoa(r.InputTokens),
-			strconv.Itoa(r.OutputTokens),
-			strconv.Itoa(r.CacheRead),
-			strconv.Itoa(r.CacheWrite),
-			fmt.Sprintf("%.6f", r.Cost),
-			fmt.Sprintf("%.3f", r.Latency.Seconds()),
-			strconv.Itoa(r.StatusCode),
-			strconv.Itoa(r.OriginalSize),
-			strconv.Itoa(r.CompressedSize),
-		})
-	}
-	w.Flush()
-	a.setStatus(fmt.Sprintf("Exported %d rows → %s", len(requests), filename))
+	a.setStatus("Upstream: " + url)
+	a.record(audit.Upstream, url, nil)
+	a.renderHeader()
 }
 
-// --- formatting helpers ---
+// toggleTokenUnit switches token counts between abbreviated and exact.
+func (a *App) toggleTokenUnit() {
+	if format.Current().Tokens == format.Raw {
+		format.SetTokenUnit(format.Abbrev)
+		a.setStatus("Tokens: abbreviated")
+	} else {
+		format.SetTokenUnit(format.Raw)
+		a.setStatus("Tokens: exact counts")
+	}
+}
 
-func formatTokens(n int) string {
-	switch {
-	case n >= 1_000_000:
-		return fmt.Sprintf("%.1fM", float64(n)/1_000_000)
-	case n >= 1_000:
-		return fmt.Sprintf("%.1fK", float64(n)/1_000)
+// latencyMode selects which latency statistic the Models pane shows.
+type latencyMode int
+
+const (
+	latencyAvg latencyMode = iota
+	latencyP95
+	latencyMax
+)
+
+func (m latencyMode) String() string {
+	switch m {
+	case latencyP95:
+		return "p95"
+	case latencyMax:
+		return "max"
 	default:
-		return fmt.Sprintf("%d", n)
+		return "avg"
 	}
 }
 
-func formatCost(c float64) string {
-	switch {
-	case c >= 10:
-		return fmt.Sprintf("$%.2f", c)
-	case c >= 1:
-		return fmt.Sprintf("$%.3f", c)
-	case c >= 0.01:
-		return fmt.Sprintf("$%.4f", c)
-	case c == 0:
-		return "$0.00"
+func (m latencyMode) next() latencyMode {
+	return (m + 1) % 3
+}
+
+// statusFilter selects the requests the request log shows by outcome;
+// <x> cycles through them.
+type statusFilter int
+
+const (
+	filterAll statusFilter = iota
+	filterFailed
+	filterCancelled
+	filterPartial
+	numFilters
+)
+
+func (f statusFilter) String() string {
+	switch f {
+	case filterFailed:
+		return "failed"
+	case filterCancelled:
+		return "cancelled"
+	case filterPartial:
+		return "partial"
+	default:
+		return "all"
+	}
+}
+
+func (f statusFilter) next() statusFilter {
+	return (f + 1) % numFilters
+}
+
+func (f statusFilter) keeps(r tracker.Request) bool {
+	switch f {
+	case filterFailed:
+		return r.Failed()
+	case filterCancelled:
+		return r.Cancelled()
+	case filterPartial:
+		return r.Partial()
+	default:
+		return true
+	}
+}
+
+func (m latencyMode) pick(ms tracker.ModelStats) time.Duration {
+	switch m {
+	case latencyP95:
+		return ms.P95Latency
+	case latencyMax:
+		return ms.MaxLatency
 	default:
-		return fmt.Sprintf("$%.5f", c)
+		return ms.AvgLatency
 	}
 }
 
+// --- formatting helpers ---
+
+func formatTokens(n int) string { return format.Tokens(n) }
+
+func formatCost(c float64) string { return format.Cost(c) }
+
 func formatLatency(d time.Duration) string {
 	switch {
 	case d >= time.Minute:
@@ -401,6 +798,21 @@ func costColor(c float64) tcell.Color {
 	}
 }
 
+// formatUtil shows how full a prompt left the context window, in yellow
+// from three quarters and in red from nine tenths, where the next turns
+// of a conversation risk truncation or a costly compaction.
+func formatUtil(f float64) (string, tcell.Color) {
+	switch {
+	case f <= 0:
+		return "-", tcell.ColorGray
+	case f >= 0.9:
+		return fmt.Sprintf("%.0f%%", 100*f), tcell.ColorRed
+	case f >= 0.75:
+		return fmt.Sprintf("%.0f%%", 100*f), tcell.ColorYellow
+	}
+	return fmt.Sprintf("%.0f%%", 100*f), tcell.ColorWhite
+}
+
 func shortModel(m string) string {
 	parts := map[string]string{
 		"claude-opus-4-6":             "opus-4.6",
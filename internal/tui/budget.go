@@ -0,0 +1,66 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"miser/internal/budget"
+)
+
+const budgetBarWidth = 16
+
+// budgetBar renders spend against the budget as a coloured gauge:
+// green below 50%, yellow below 80%, red beyond. Calendar and rolling
+// budgets name their window and say when spend next drops, and a budget
+// that is refusing requests says so.
+func budgetBar(s budget.Status, now time.Time) string {
+	frac := s.Fraction()
+	filled := int(min(frac, 1) * budgetBarWidth)
+
+	color := "green"
+	switch {
+	case frac >= 0.8:
+		color = "red"
+	case frac >= 0.5:
+		color = "yellow"
+	}
+
+	window := ""
+	if s.Period != budget.Session && s.Period != "" {
+		window = " " + s.Period.Label()
+	}
+	text := fmt.Sprintf("[%s]%s[gray]%s[-] [%s::b]%s[-::-] / %s%s (%.0f%%)",
+		color, strings.Repeat("█", filled), strings.Repeat("░", budgetBarWidth-filled),
+		color, formatCost(s.Spent), formatCost(s.Limit), window, frac*100)
+	if !s.Until.IsZero() {
+		if s.Period == budget.Rolling {
+			text += " · oldest spend ages out in " + untilText(s.Until.Sub(now))
+		} else {
+			text += " · resets in " + untilText(s.Until.Sub(now))
+		}
+	}
+	if s.Action == budget.Block && s.Exceeded() {
+		text += " [white:red:b] BLOCKING [-:-:-]"
+	}
+	return text
+}
+
+// hasBudget reports whether a budget limit is set; it can be changed at
+// runtime with "miser ctl budget".
+func (a *App) hasBudget() bool {
+	return a.budget != nil && a.budget.Status().Limit > 0
+}
+
+// untilText is a countdown to the minute, e.g. "2d 5h" or "3h 12m".
+func untilText(d time.Duration) string {
+	d = max(d, 0).Round(time.Minute)
+	h, m := int(d.Hours()), int(d.Minutes())%60
+	switch {
+	case h >= 24:
+		return fmt.Sprintf("%dd %dh", h/24, h%24)
+	case h > 0:
+		return fmt.Sprintf("%dh %dm", h, m)
+	}
+	return fmt.Sprintf("%dm", m)
+}
@@ -0,0 +1,129 @@
+package tui
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"miser/internal/tracker"
+)
+
+func (a *App) buildCacheBoard() tview.Primitive {
+	a.cacheTrend = tview.NewTextView().
+		SetDynamicColors(true).
+		SetWrap(false)
+
+	a.cacheTable = tview.NewTable().
+		SetBorders(false).
+		SetSelectable(true, false).
+		SetFixed(1, 1)
+
+	flex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(a.cacheTrend, 2, 0, false).
+		AddItem(a.cacheTable, 0, 1, true)
+	flex.
+		SetBorder(true).
+		SetTitle(" Cache — session ").
+		SetTitleAlign(tview.AlignLeft).
+		SetBorderColor(tcell.ColorDarkCyan).
+		SetTitleColor(tcell.ColorYellow)
+	a.cacheBoard = flex
+	return flex
+}
+
+func (a *App) renderCache() {
+	_, _, width, _ := a.cacheTrend.GetInnerRect()
+	n := width - chartLabelWidth - chartValueWidth
+	if n < 10 {
+		n = 10
+	}
+
+	sessionStart := a.tracker.SessionStart()
+	buckets := a.tracker.GetTimeline(time.Now(), chartBucket, n)
+	reads := make([]float64, len(buckets))
+	writes := make([]float64, len(buckets))
+	var peakRead, peakWrite int
+	for i, b := range buckets {
+		if b.Start.Add(chartBucket).Before(sessionStart) {
+			continue
+		}
+		reads[i] = float64(b.CacheRead)
+		writes[i] = float64(b.CacheWrite)
+		peakRead = max(peakRead, b.CacheRead)
+		peakWrite = max(peakWrite, b.CacheWrite)
+	}
+	a.cacheTrend.SetText(fmt.Sprintf(
+		" [blue::b]read[-::-]   [blue]%s[-]  peak [::b]%s[-::-]/min\n [purple::b]write[-::-]  [purple]%s[-]  peak [::b]%s[-::-]/min",
+		sparkline(reads), formatTokens(peakRead),
+		sparkline(writes), formatTokens(peakWrite),
+	))
+
+	a.cacheTable.Clear()
+	compact := isCompact(a.cacheTable)
+	setHeaders(a.cacheTable, []column{
+		leftCol("MODEL", ""),
+		rightCol("HIT RATE", "HIT"),
+		rightCol("INPUT", "IN"),
+		rightCol("CACHE R", "CR"),
+		rightCol("CACHE W", "CW"),
+		rightCol("SAVED", "SAV"),
+	}, compact)
+
+	var total tracker.ModelStats
+	total.Model = "total"
+	for i, ms := range a.tracker.GetModelStatsSince(a.scopeSince()) {
+		a.setCacheRow(i+1, ms, compact)
+		total.InputTokens += ms.InputTokens
+		total.CacheRead += ms.CacheRead
+		total.CacheWrite += ms.CacheWrite
+		total.CacheSavings += ms.CacheSavings
+	}
+	if rows := a.cacheTable.GetRowCount(); rows > 2 {
+		a.setCacheRow(rows, total, compact)
+	}
+}
+
+func (a *App) setCacheRow(row int, ms tracker.ModelStats, compact bool) {
+	savedColor := tcell.ColorGreen
+	if ms.CacheSavings < 0 {
+		savedColor = tcell.ColorRed
+	}
+	name := modelLabel(ms.Model, compact)
+	nameColor := tcell.ColorWhite
+	if ms.Model == "total" {
+		nameColor = tcell.ColorYellow
+	}
+
+	cells := []struct {
+		text  string
+		color tcell.Color
+		align int
+	}{
+		{" " + name + " ", nameColor, tview.AlignLeft},
+		{fmt.Sprintf(" %.1f%% ", ms.CacheHitRate()), hitRateColor(ms.CacheHitRate()), tview.AlignRight},
+		{" " + formatTokens(ms.InputTokens) + " ", tcell.ColorWhite, tview.AlignRight},
+		{" " + formatTokens(ms.CacheRead) + " ", tcell.ColorSteelBlue, tview.AlignRight},
+		{" " + formatTokens(ms.CacheWrite) + " ", tcell.ColorSteelBlue, tview.AlignRight},
+		{" " + formatCost(ms.CacheSavings) + " ", savedColor, tview.AlignRight},
+	}
+	for i, c := range cells {
+		a.cacheTable.SetCell(row, i,
+			tview.NewTableCell(c.text).
+				SetTextColor(c.color).
+				SetAlign(c.align),
+		)
+	}
+}
+
+func hitRateColor(pct float64) tcell.Color {
+	switch {
+	case pct >= 50:
+		return tcell.ColorGreen
+	case pct >= 10:
+		return tcell.ColorYellow
+	default:
+		return tcell.ColorWhite
+	}
+}
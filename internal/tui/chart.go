@@ -0,0 +1,66 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	chartBucket     = time.Minute
+	chartLabelWidth = 7  // " cost  "
+	chartValueWidth = 24 // "  peak $0.1234/min"
+)
+
+var sparkLevels = []rune("▁▂▃▄▅▆▇█")
+
+func (a *App) renderChart() {
+	_, _, width, _ := a.chart.GetInnerRect()
+	n := width - chartLabelWidth - chartValueWidth
+	if n < 10 {
+		n = 10
+	}
+
+	buckets := a.tracker.GetTimeline(time.Now(), chartBucket, n)
+	costs := make([]float64, len(buckets))
+	reqs := make([]float64, len(buckets))
+	sessionStart := a.tracker.SessionStart()
+	var peakCost float64
+	var peakReqs int
+	for i, b := range buckets {
+		if b.Start.Add(chartBucket).Before(sessionStart) {
+			continue // before this session
+		}
+		costs[i] = b.Cost
+		reqs[i] = float64(b.Requests)
+		peakCost = max(peakCost, b.Cost)
+		peakReqs = max(peakReqs, b.Requests)
+	}
+
+	text := fmt.Sprintf(
+		" [green::b]cost[-::-]   [green]%s[-]  peak [::b]%s[-::-]/min\n [cyan::b]reqs[-::-]   [cyan]%s[-]  peak [::b]%d[-::-]/min",
+		sparkline(costs), formatCost(peakCost),
+		sparkline(reqs), peakReqs,
+	)
+	a.chart.SetText(text)
+}
+
+// sparkline renders one block character per value, scaled so the largest
+// value fills the full cell height. Zero values render as blanks.
+func sparkline(values []float64) string {
+	var peak float64
+	for _, v := range values {
+		peak = max(peak, v)
+	}
+
+	var b strings.Builder
+	for _, v := range values {
+		if v <= 0 || peak == 0 {
+			b.WriteRune(' ')
+			continue
+		}
+		level := int(v / peak * float64(len(sparkLevels)-1))
+		b.WriteRune(sparkLevels[level])
+	}
+	return b.String()
+}
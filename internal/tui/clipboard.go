@@ -0,0 +1,45 @@
+package tui
+
+import (
+	"fmt"
+
+	"miser/internal/export"
+	"miser/internal/tracker"
+)
+
+// copySelected puts the selected request on the system clipboard using an
+// OSC 52 escape sequence, which works over SSH and in most modern terminals
+// (tmux needs `set -g set-clipboard on`).
+func (a *App) copySelected(asJSON bool) {
+	req, ok := a.selectedRequest()
+	if !ok {
+		a.setStatus("No request selected")
+		return
+	}
+	if a.screen == nil {
+		a.setStatus("Clipboard unavailable")
+		return
+	}
+
+	text := export.Summary(req)
+	kind := "summary"
+	if asJSON {
+		data, err := export.RequestJSON(req)
+		if err != nil {
+			a.setStatus(fmt.Sprintf("Copy failed: %v", err))
+			return
+		}
+		text, kind = string(data), "JSON"
+	}
+	a.screen.SetClipboard([]byte(text))
+	a.setStatus(fmt.Sprintf("Copied request #%d as %s", req.ID, kind))
+}
+
+// selectedRequest returns the request under the request-log cursor.
+func (a *App) selectedRequest() (tracker.Request, bool) {
+	row, _ := a.requestTable.GetSelection()
+	if row < 1 || row > len(a.requestIDs) {
+		return tracker.Request{}, false
+	}
+	return a.tracker.GetRequest(a.requestIDs[row-1])
+}
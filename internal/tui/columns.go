@@ -0,0 +1,66 @@
+package tui
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// compactWidth is the inner table width below which headers and model
+// names are abbreviated. Columns that still don't fit can be scrolled into
+// view with ←/→; the first column stays pinned.
+const compactWidth = 100
+
+// column is a table header with the short form used in compact mode.
+type column struct {
+	name  string
+	short string
+	align int
+}
+
+func leftCol(name, short string) column  { return column{name, short, tview.AlignLeft} }
+func rightCol(name, short string) column { return column{name, short, tview.AlignRight} }
+
+func isCompact(t *tview.Table) bool {
+	_, _, w, _ := t.GetInnerRect()
+	return w > 0 && w < compactWidth
+}
+
+func setHeaders(t *tview.Table, cols []column, compact bool) {
+	for i, c := range cols {
+		name := c.name
+		if compact && c.short != "" {
+			name = c.short
+		}
+		t.SetCell(0, i,
+			tview.NewTableCell(" "+name+" ").
+				SetTextColor(tcell.ColorYellow).
+				SetAttributes(tcell.AttrBold).
+				SetSelectable(false).
+				SetAlign(c.align),
+		)
+	}
+}
+
+// clientLabel shows "-" for an unknown client and truncates long names.
+func clientLabel(c string, compact bool) string {
+	limit := 16
+	if compact {
+		limit = 10
+	}
+	if c == "" {
+		return "-"
+	}
+	if r := []rune(c); len(r) > limit {
+		c = string(r[:limit-1]) + "…"
+	}
+	return tview.Escape(c)
+}
+
+// modelLabel is shortModel, further truncated in compact mode.
+func modelLabel(m string, compact bool) string {
+	s := shortModel(m)
+	if r := []rune(s); compact && len(r) > 14 {
+		return string(r[:13]) + "…"
+	}
+	return s
+}
@@ -0,0 +1,41 @@
+package tui
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// confirm asks a yes/no question before running a destructive action.
+// y / Enter on "Yes" runs it; n / Esc or "No" dismisses the dialog. When
+// confirmations are disabled the action runs immediately.
+func (a *App) confirm(question string, action func()) {
+	if a.skipConfirm {
+		action()
+		return
+	}
+
+	modal := tview.NewModal().
+		SetText(question).
+		AddButtons([]string{"Yes", "No"}).
+		SetDoneFunc(func(_ int, label string) {
+			a.closeDialog()
+			if label == "Yes" {
+				action()
+			}
+		})
+	modal.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Rune() {
+		case 'y', 'Y':
+			a.closeDialog()
+			action()
+			return nil
+		case 'n', 'N':
+			a.closeDialog()
+			return nil
+		}
+		return event
+	})
+	// Modal centres itself, so it goes straight onto the page stack.
+	a.pages.AddPage(dialogPage, modal, true, true)
+	a.app.SetFocus(modal)
+}
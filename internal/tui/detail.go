@@ -0,0 +1,207 @@
+package tui
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"miser/internal/tracker"
+)
+
+// showDetail opens a scrollable view of the selected request, including
+// the captured prompt and response when body capture is enabled.
+func (a *App) showDetail() {
+	req, ok := a.selectedRequest()
+	if !ok {
+		return
+	}
+
+	view := tview.NewTextView().
+		SetDynamicColors(true).
+		SetScrollable(true).
+		SetWrap(true).
+		SetText(detailText(req))
+	view.SetDoneFunc(func(tcell.Key) { a.closeDialog() })
+	view.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyRune && event.Rune() == 'q' {
+			a.closeDialog()
+			return nil
+		}
+		return event
+	})
+	view.
+		SetBorder(true).
+		SetTitle(fmt.Sprintf(" Request #%d — <Esc> to close ", req.ID)).
+		SetTitleAlign(tview.AlignLeft).
+		SetBorderColor(tcell.ColorDarkCyan).
+		SetTitleColor(tcell.ColorYellow)
+
+	a.showDialog(view, 0, 0)
+}
+
+func detailText(r tracker.Request) string {
+	var b strings.Builder
+	field := func(name, value string) {
+		fmt.Fprintf(&b, " [yellow]%-14s[-] %s\n", name, value)
+	}
+
+	status := fmt.Sprintf("%d", r.StatusCode)
+	if r.Cancelled() {
+		status = fmt.Sprintf("[yellow]cancelled by the client (upstream answered %d)[-]", r.StatusCode)
+		if r.StatusCode == 0 {
+			status = "[yellow]cancelled by the client before upstream answered[-]"
+		}
+	} else if r.Error == tracker.ClientStalled {
+		status = fmt.Sprintf("[yellow]%d, cut short: the client stopped reading[-]", r.StatusCode)
+	} else if r.Partial() {
+		status = fmt.Sprintf("[yellow]%d, cut short: the stream ended early[-]", r.StatusCode)
+	} else if r.Error != "" {
+		status = "[red]ERR " + tview.Escape(r.Error) + "[-]"
+	}
+	field("Time", r.Timestamp.Format(time.RFC3339))
+	field("Model", r.Model)
+	if r.FallbackFrom != "" {
+		field("Fallback", "[yellow]from "+tview.Escape(r.FallbackFrom)+", which was overloaded[-]")
+	}
+	if r.Project != "" {
+		field("Project", tview.Escape(r.Project))
+	}
+	if r.Client != "" {
+		field("Client", tview.Escape(r.Client))
+	}
+	if r.Key != "" {
+		field("Key", tview.Escape(r.Key))
+	}
+	if r.Node != "" {
+		field("Node", tview.Escape(r.Node))
+	}
+	if r.Conversation != "" {
+		field("Conversation", fmt.Sprintf("%s (%d messages)", tview.Escape(r.Conversation), r.Messages))
+	}
+	if r.From != "" {
+		field("From", "miser at "+tview.Escape(r.From))
+	}
+	if r.CountedBy != "" {
+		field("Counted by", "upstream miser at "+tview.Escape(r.CountedBy))
+	}
+	if len(r.Tags) > 0 {
+		field("Tags", tview.Escape(strings.Join(r.Tags, ", ")))
+	}
+	if len(r.Injected) > 0 {
+		field("Injected", tview.Escape(strings.Join(r.Injected, ", ")))
+	}
+	field("Status", status)
+	if r.RequestID != "" {
+		field("Request ID", tview.Escape(r.RequestID))
+	}
+	if len(r.Degraded) > 0 {
+		field("Converted", "[yellow]"+tview.Escape(strings.Join(r.Degraded, "; "))+"[-]")
+	}
+	if rc := r.Race; rc != nil {
+		field("Race", raceText(r.Model, rc))
+	}
+	field("Latency", formatLatency(r.Latency))
+	if r.Network != (tracker.Network{}) {
+		field("Network", networkText(r.Network))
+	}
+	field("Cost", formatCost(r.Cost))
+	if rt := r.Retry; rt != nil {
+		if rt.Final {
+			field("Retry", fmt.Sprintf("[yellow]attempt %d, after %d failed (retry %s)[-]", rt.Attempt, rt.Attempt-1, rt.ID))
+		} else {
+			field("Retry", fmt.Sprintf("[yellow]attempt %d, failed and resent (retry %s)[-]", rt.Attempt, rt.ID))
+		}
+	}
+	if r.Projected > 0 {
+		color := "yellow"
+		if r.StatusCode == http.StatusPaymentRequired {
+			color = "red"
+		}
+		field("Projected", fmt.Sprintf("[%s]up to %s, over the per-request limit[-]", color, formatCost(r.Projected)))
+	}
+	field("Input", formatTokens(r.InputTokens))
+	if r.ToolTokens > 0 {
+		field("Output", fmt.Sprintf("%s (%s in tool calls)", formatTokens(r.OutputTokens), formatTokens(r.ToolTokens)))
+	} else {
+		field("Output", formatTokens(r.OutputTokens))
+	}
+	if r.CappedFrom > 0 {
+		what := "lowered to"
+		if r.StatusCode == http.StatusBadRequest {
+			what = "refused for"
+		}
+		field("Capped", fmt.Sprintf("[yellow]max_tokens %s asked for, %s the configured cap[-]", formatTokens(r.CappedFrom), what))
+	}
+	field("Cache read", formatTokens(r.CacheRead))
+	field("Cache write", formatTokens(r.CacheWrite))
+	if r.ContextUsed > 0 {
+		util, color := formatUtil(r.ContextUsed)
+		field("Context", fmt.Sprintf("[%s]%s[-] of a %s-token window", color.Name(), util,
+			formatTokens(tracker.ContextWindow(r.Model))))
+	}
+	if r.EstimatedUsage {
+		field("Estimated", "[yellow]upstream reported no usage; the counts and cost are local estimates[-]")
+	}
+	if r.EstimatedInput > 0 {
+		field("Estimated", fmt.Sprintf("[red]%s input locally, against %s counted upstream with cache[-]",
+			formatTokens(r.EstimatedInput), formatTokens(r.InputTokens+r.CacheRead+r.CacheWrite)))
+	}
+	if r.OriginalSize > 0 {
+		field("Compression", fmt.Sprintf("%d → %d bytes", r.OriginalSize, r.CompressedSize))
+	}
+
+	if r.Prompt == "" && r.Response == "" {
+		b.WriteString("\n [gray]No prompt or response captured. Enable [capture] in miser.toml to record previews.[-]\n")
+		return b.String()
+	}
+	b.WriteString("\n [aqua::b]── Prompt ──[-::-]\n")
+	b.WriteString(tview.Escape(r.Prompt))
+	b.WriteString("\n\n [aqua::b]── Response ──[-::-]\n")
+	b.WriteString(tview.Escape(r.Response))
+	b.WriteString("\n")
+	return b.String()
+}
+
+// networkText breaks down the upstream connection, e.g. "new connection:
+// DNS 4ms, connect 21ms, TLS 63ms; first byte 1.4s". Setting up a
+// connection is the network's time; waiting for the first byte is mostly
+// the model's.
+func networkText(n tracker.Network) string {
+	var setup []string
+	for _, p := range []struct {
+		name string
+		d    time.Duration
+	}{{"DNS", n.DNS}, {"connect", n.Connect}, {"TLS", n.TLS}} {
+		if p.d > 0 {
+			setup = append(setup, p.name+" "+formatLatency(p.d))
+		}
+	}
+	s := "new connection"
+	switch {
+	case n.Reused:
+		s = "reused connection"
+	case len(setup) > 0:
+		s += ": " + strings.Join(setup, ", ")
+	}
+	if n.FirstByte > 0 {
+		s += "; first byte " + formatLatency(n.FirstByte)
+	}
+	return s
+}
+
+// raceText says how a raced request did: whether it was relayed, against
+// which model, and what the client asked for.
+func raceText(model string, rc *tracker.Race) string {
+	if rc.Lost {
+		return fmt.Sprintf("[gray]lost to %s and cancelled; its cost is the price of racing[-]", tview.Escape(rc.Rival))
+	}
+	text := "relayed, raced against " + tview.Escape(rc.Rival)
+	if model != rc.Requested {
+		text += "; the client asked for " + tview.Escape(rc.Requested)
+	}
+	return text
+}
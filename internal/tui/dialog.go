@@ -0,0 +1,32 @@
+package tui
+
+import "github.com/rivo/tview"
+
+const dialogPage = "dialog"
+
+// showDialog centres p over the dashboard and gives it focus. A zero width
+// or height leaves a margin around the screen instead. Global shortcuts are
+// suspended until closeDialog is called.
+func (a *App) showDialog(p tview.Primitive, width, height int) {
+	if width == 0 {
+		width = -8
+	}
+	if height == 0 {
+		height = -6
+	}
+	grid := tview.NewGrid().
+		SetColumns(0, width, 0).
+		SetRows(0, height, 0).
+		AddItem(p, 1, 1, 1, 1, 0, 0, true)
+	a.pages.AddPage(dialogPage, grid, true, true)
+	a.app.SetFocus(p)
+}
+
+func (a *App) closeDialog() {
+	a.pages.RemovePage(dialogPage)
+	a.app.SetFocus(a.requestTable)
+}
+
+func (a *App) dialogOpen() bool {
+	return a.pages.HasPage(dialogPage)
+}
@@ -0,0 +1,77 @@
+package tui
+
+import (
+	"fmt"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"miser/internal/audit"
+	"miser/internal/export"
+	"miser/internal/tracker"
+)
+
+func (a *App) showExportDialog() {
+	formats := make([]string, len(export.Formats))
+	for i, f := range export.Formats {
+		formats[i] = f.String()
+	}
+	rowOptions := []string{"Current view (" + a.viewLabel() + ")", "All requests"}
+
+	form := tview.NewForm().
+		AddDropDown("Format", formats, a.exportFormat, nil).
+		AddInputField("Directory", a.exportDir, 40, nil, nil).
+		AddDropDown("Rows", rowOptions, 0, nil)
+	form.
+		AddButton("Export", func() {
+			fi, _ := form.GetFormItemByLabel("Format").(*tview.DropDown).GetCurrentOption()
+			dir := form.GetFormItemByLabel("Directory").(*tview.InputField).GetText()
+			rows, _ := form.GetFormItemByLabel("Rows").(*tview.DropDown).GetCurrentOption()
+			a.exportFormat, a.exportDir = fi, dir
+			a.closeDialog()
+			a.export(export.Formats[fi], dir, rows == 1)
+		}).
+		AddButton("Cancel", a.closeDialog).
+		SetCancelFunc(a.closeDialog)
+	form.
+		SetBorder(true).
+		SetTitle(" Export ").
+		SetTitleAlign(tview.AlignLeft).
+		SetBorderColor(tcell.ColorDarkCyan).
+		SetTitleColor(tcell.ColorYellow)
+
+	a.showDialog(form, 60, 11)
+}
+
+// viewLabel describes what the request log is currently showing.
+func (a *App) viewLabel() string {
+	if a.filter != filterAll {
+		return a.scope.String() + ", " + a.filter.String() + " only"
+	}
+	return a.scope.String()
+}
+
+func (a *App) export(f export.Format, dir string, all bool) {
+	var requests []tracker.Request
+	if all {
+		requests = a.tracker.GetRequests()
+	} else {
+		requests = a.applyFilter(a.tracker.GetRequestsSince(a.scopeSince()))
+	}
+	if len(requests) == 0 {
+		a.setStatus("Nothing to export")
+		return
+	}
+
+	path, err := export.WriteFile(dir, f, requests)
+	detail := fmt.Sprintf("%s, %d requests", f, len(requests))
+	if path != "" {
+		detail += " → " + path
+	}
+	a.record(audit.Export, detail, err)
+	if err != nil {
+		a.setStatus(fmt.Sprintf("Export failed: %v", err))
+		return
+	}
+	a.setStatus(fmt.Sprintf("Exported %d rows → %s", len(requests), path))
+}
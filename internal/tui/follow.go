@@ -0,0 +1,78 @@
+package tui
+
+// The request log is newest-first, so "tailing" means keeping the selection
+// pinned to the top row as requests arrive. Moving the selection anywhere
+// else pauses follow mode and keeps the selected request in place until
+// <f> jumps back to the live tail.
+
+func (a *App) onRequestSelected(row, _ int) {
+	if a.rendering {
+		return
+	}
+	if row <= 1 {
+		if len(a.requestIDs) > 0 {
+			a.selectedID = a.requestIDs[0]
+		}
+		return
+	}
+	if a.follow {
+		a.follow = false
+		a.updateRequestTitle()
+	}
+	if row-1 < len(a.requestIDs) {
+		a.selectedID = a.requestIDs[row-1]
+	}
+}
+
+// jumpToLive re-engages follow mode and scrolls back to the newest request.
+func (a *App) jumpToLive() {
+	a.follow = true
+	a.updateRequestTitle()
+	a.rendering = true
+	a.requestTable.Select(1, 0)
+	a.requestTable.SetOffset(0, 0)
+	a.rendering = false
+	a.setStatus("Following live requests")
+}
+
+// restoreSelection re-applies the selection after the request table has
+// been rebuilt: the top row when following, otherwise the row that now
+// holds the previously selected request, shifting the scroll offset by the
+// same amount so the view doesn't jump.
+func (a *App) restoreSelection(prevRow int) {
+	if len(a.requestIDs) == 0 {
+		return
+	}
+
+	a.rendering = true
+	defer func() { a.rendering = false }()
+
+	if rowOff, _ := a.requestTable.GetOffset(); a.follow && rowOff > 0 {
+		// Scrolled away with the mouse wheel.
+		a.follow = false
+		a.updateRequestTitle()
+	}
+
+	if a.follow {
+		a.requestTable.Select(1, 0)
+		_, col := a.requestTable.GetOffset()
+		a.requestTable.SetOffset(0, col)
+		a.selectedID = a.requestIDs[0]
+		return
+	}
+
+	for i, id := range a.requestIDs {
+		if id != a.selectedID {
+			continue
+		}
+		row := i + 1
+		rowOff, colOff := a.requestTable.GetOffset()
+		a.requestTable.Select(row, 0)
+		if shift := row - prevRow; shift > 0 {
+			a.requestTable.SetOffset(rowOff+shift, colOff)
+		}
+		return
+	}
+	// The selected request scrolled out of the window; stay on the same row.
+	a.requestTable.Select(min(prevRow, len(a.requestIDs)), 0)
+}
@@ -0,0 +1,82 @@
+package tui
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+func (a *App) buildHeatmapBoard() tview.Primitive {
+	a.heatTable = tview.NewTable().
+		SetBorders(false).
+		SetSelectable(true, false).
+		SetFixed(1, 1)
+	a.heatTable.
+		SetBorder(true).
+		SetTitle(" Heatmap — session ").
+		SetTitleAlign(tview.AlignLeft).
+		SetBorderColor(tcell.ColorDarkCyan).
+		SetTitleColor(tcell.ColorYellow)
+	return a.heatTable
+}
+
+// heatColors shade an hour by its cost against the busiest hour: up to a
+// quarter, half, three quarters and more.
+var heatColors = []tcell.Color{tcell.ColorDarkGreen, tcell.ColorGreen, tcell.ColorYellow, tcell.ColorRed}
+
+// renderHeatmap shows a weekday per row and an hour of the day per column,
+// local time: each cell the requests that started in it, shaded by what
+// they cost.
+func (a *App) renderHeatmap() {
+	a.heatTable.Clear()
+	h := a.tracker.GetHeatmapSince(a.scopeSince())
+	peak := h.Peak()
+	compact := isCompact(a.heatTable)
+
+	header := func(col int, text string) {
+		a.heatTable.SetCell(0, col, tview.NewTableCell(text).
+			SetTextColor(tcell.ColorYellow).
+			SetAttributes(tcell.AttrBold).
+			SetAlign(tview.AlignRight).
+			SetSelectable(false))
+	}
+	header(0, "")
+	for hour := range 24 {
+		label := fmt.Sprintf("%02d", hour)
+		if compact && hour%3 != 0 {
+			label = ""
+		}
+		header(hour+1, label)
+	}
+	header(25, " COST ")
+	header(26, " REQS ")
+
+	for d, hours := range h {
+		day := time.Weekday((d + 1) % 7).String()[:3]
+		a.heatTable.SetCell(d+1, 0, tview.NewTableCell(" "+day+" ").SetTextColor(tcell.ColorWhite))
+		var cost float64
+		var reqs int
+		for hour, s := range hours {
+			cost += s.TotalCost
+			reqs += s.TotalRequests
+			cell := tview.NewTableCell("·").SetTextColor(tcell.ColorGray).SetAlign(tview.AlignRight)
+			if s.TotalRequests > 0 {
+				text := formatTokens(s.TotalRequests) // abbreviated, or not, as tokens are
+				if compact {
+					text = "■"
+				}
+				cell.SetText(" " + text).SetTextColor(tcell.ColorBlack)
+				if s.TotalCost > 0 && peak > 0 {
+					cell.SetBackgroundColor(heatColors[min(int(s.TotalCost/peak*4-1e-9), 3)])
+				} else {
+					cell.SetTextColor(tcell.ColorWhite)
+				}
+			}
+			a.heatTable.SetCell(d+1, hour+1, cell)
+		}
+		a.heatTable.SetCell(d+1, 25, tview.NewTableCell(" "+formatCost(cost)+" ").SetTextColor(costColor(cost)).SetAlign(tview.AlignRight))
+		a.heatTable.SetCell(d+1, 26, tview.NewTableCell(fmt.Sprintf(" %d ", reqs)).SetTextColor(tcell.ColorWhite).SetAlign(tview.AlignRight))
+	}
+}
@@ -0,0 +1,34 @@
+package tui
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"miser/internal/tracker"
+)
+
+func (a *App) buildNodesBoard() tview.Primitive {
+	a.nodeTable = tview.NewTable().
+		SetBorders(false).
+		SetSelectable(true, false).
+		SetFixed(1, 1)
+	a.nodeTable.
+		SetBorder(true).
+		SetTitle(" Nodes — session ").
+		SetTitleAlign(tview.AlignLeft).
+		SetBorderColor(tcell.ColorDarkCyan).
+		SetTitleColor(tcell.ColorYellow)
+	return a.nodeTable
+}
+
+// renderNodes shows cost per cluster node pushing to this instance, with
+// the instance's own requests under "(this proxy)".
+func (a *App) renderNodes() {
+	var names []string
+	var sums []tracker.Summary
+	for _, ns := range a.tracker.GetNodeStatsSince(a.scopeSince()) {
+		names = append(names, ns.Node)
+		sums = append(sums, ns.Summary)
+	}
+	a.renderGroups(a.nodeTable, "NODE", "(this proxy)", names, sums)
+}
@@ -0,0 +1,114 @@
+package tui
+
+import (
+	"fmt"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"miser/internal/tracker"
+)
+
+func (a *App) buildProjectsBoard() tview.Primitive {
+	a.projectTable = tview.NewTable().
+		SetBorders(false).
+		SetSelectable(true, false).
+		SetFixed(1, 1)
+	a.projectTable.
+		SetBorder(true).
+		SetTitle(" Projects — session ").
+		SetTitleAlign(tview.AlignLeft).
+		SetBorderColor(tcell.ColorDarkCyan).
+		SetTitleColor(tcell.ColorYellow)
+	return a.projectTable
+}
+
+// renderProjects shows cost per X-Miser-Project, with requests that named
+// none gathered under "(none)".
+func (a *App) renderProjects() {
+	var names []string
+	var sums []tracker.Summary
+	for _, ps := range a.tracker.GetProjectStatsSince(a.scopeSince()) {
+		names = append(names, ps.Project)
+		sums = append(sums, ps.Summary)
+	}
+	a.renderGroups(a.projectTable, "PROJECT", "(none)", names, sums)
+}
+
+func (a *App) buildClientsBoard() tview.Primitive {
+	a.clientTable = tview.NewTable().
+		SetBorders(false).
+		SetSelectable(true, false).
+		SetFixed(1, 1)
+	a.clientTable.
+		SetBorder(true).
+		SetTitle(" Clients — session ").
+		SetTitleAlign(tview.AlignLeft).
+		SetBorderColor(tcell.ColorDarkCyan).
+		SetTitleColor(tcell.ColorYellow)
+	return a.clientTable
+}
+
+// renderClients shows cost per client tool, with requests from unknown
+// clients gathered under "(unknown)".
+func (a *App) renderClients() {
+	var names []string
+	var sums []tracker.Summary
+	for _, cs := range a.tracker.GetClientStatsSince(a.scopeSince()) {
+		names = append(names, cs.Client)
+		sums = append(sums, cs.Summary)
+	}
+	a.renderGroups(a.clientTable, "CLIENT", "(unknown)", names, sums)
+}
+
+// renderGroups fills a board of cost per group; the group "" is shown as
+// none.
+func (a *App) renderGroups(table *tview.Table, header, none string, names []string, sums []tracker.Summary) {
+	table.Clear()
+	compact := isCompact(table)
+	setHeaders(table, []column{
+		leftCol(header, ""),
+		rightCol("REQS", "#"),
+		rightCol("ERRORS", "ERR"),
+		rightCol("INPUT", "IN"),
+		rightCol("OUTPUT", "OUT"),
+		rightCol("COST", ""),
+		rightCol("%", ""),
+	}, compact)
+
+	total := a.tracker.GetSummarySince(a.scopeSince()).TotalCost
+	for i, ps := range sums {
+		name, nameColor := names[i], tcell.ColorWhite
+		if name == "" {
+			name, nameColor = none, tcell.ColorGray
+		}
+		pct := 0.0
+		if total > 0 {
+			pct = ps.TotalCost / total * 100
+		}
+		errColor := tcell.ColorWhite
+		if ps.TotalErrors > 0 {
+			errColor = tcell.ColorRed
+		}
+		cells := []struct {
+			text  string
+			color tcell.Color
+			align int
+		}{
+			{" " + tview.Escape(name) + " ", nameColor, tview.AlignLeft},
+			{fmt.Sprintf(" %d ", ps.TotalRequests), tcell.ColorWhite, tview.AlignRight},
+			{fmt.Sprintf(" %d ", ps.TotalErrors), errColor, tview.AlignRight},
+			{" " + formatTokens(ps.TotalInput) + " ", tcell.ColorWhite, tview.AlignRight},
+			{" " + formatTokens(ps.TotalOutput) + " ", tcell.ColorWhite, tview.AlignRight},
+			{" " + formatCost(ps.TotalCost) + " ", costColor(ps.TotalCost), tview.AlignRight},
+			{fmt.Sprintf(" %.1f%% ", pct), tcell.ColorWhite, tview.AlignRight},
+		}
+		for j, c := range cells {
+			table.SetCell(i+1, j,
+				tview.NewTableCell(c.text).
+					SetTextColor(c.color).
+					SetAlign(c.align),
+			)
+		}
+	}
+}
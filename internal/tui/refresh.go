@@ -0,0 +1,79 @@
+package tui
+
+import "time"
+
+// The dashboard redraws when something changes rather than on a fixed
+// interval: a recorded request, a key that changes the view, or a terminal
+// resize marks it dirty. A slow clock keeps the uptime, status line and
+// per-minute charts moving while traffic is idle.
+const (
+	minRedrawInterval = 100 * time.Millisecond // coalesces bursts of requests
+	clockInterval     = time.Second
+)
+
+// invalidate schedules a full redraw. It never blocks and may be called
+// from any goroutine; multiple calls before the next redraw coalesce.
+func (a *App) invalidate() {
+	select {
+	case a.dirty <- struct{}{}:
+	default:
+	}
+}
+
+func (a *App) refreshLoop() {
+	clock := time.NewTicker(clockInterval)
+	defer clock.Stop()
+
+	for {
+		select {
+		case <-a.dirty:
+			a.app.QueueUpdateDraw(a.render)
+			time.Sleep(minRedrawInterval)
+		case now := <-clock.C:
+			a.app.QueueUpdateDraw(func() { a.renderClock(now) })
+		}
+	}
+}
+
+// render rebuilds every pane.
+func (a *App) render() {
+	a.lastRender = time.Now()
+	a.renderHeader()
+	a.renderStats()
+	a.renderChart()
+	a.renderModels()
+	a.renderCache()
+	a.renderProjects()
+	a.renderClients()
+	a.renderNodes()
+	a.renderHeatmap()
+	a.renderRequests()
+	a.renderFooter()
+}
+
+// renderClock refreshes the time-dependent panes only. Windowed scopes
+// (last hour, today) and latency objectives drift as time passes even
+// without traffic, so they get a full rebuild once per chart bucket.
+func (a *App) renderClock(now time.Time) {
+	drifts := a.scope == scopeHour || a.scope == scopeToday || len(a.slo) > 0
+	if drifts && now.Sub(a.lastRender) >= chartBucket {
+		a.render()
+		return
+	}
+	a.renderHeader()
+	if a.hasBudget() {
+		a.renderStats() // the countdown to the budget's next drop
+	}
+	a.renderChart()
+	a.renderCache()
+	a.renderFooter()
+}
+
+// watchResize marks the dashboard dirty when the terminal size changes, so
+// chart widths and compact table headers follow the new size.
+func (a *App) watchResize(w, h int) {
+	if w != a.width || h != a.height {
+		a.width, a.height = w, h
+		a.invalidate()
+	}
+}
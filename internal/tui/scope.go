@@ -0,0 +1,79 @@
+package tui
+
+import "time"
+
+// scope is the time window the stats bar, model table and request log
+// aggregate over.
+type scope int
+
+const (
+	scopeSession scope = iota
+	scopeHour
+	scopeToday
+	scopeAll
+	numScopes
+)
+
+func (s scope) String() string {
+	switch s {
+	case scopeHour:
+		return "last hour"
+	case scopeToday:
+		return "today"
+	case scopeAll:
+		return "all time"
+	default:
+		return "session"
+	}
+}
+
+func (s scope) next() scope {
+	return (s + 1) % numScopes
+}
+
+// since returns the earliest request timestamp included in the scope.
+func (s scope) since(sessionStart, now time.Time) time.Time {
+	switch s {
+	case scopeHour:
+		return now.Add(-time.Hour)
+	case scopeToday:
+		y, m, d := now.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	case scopeAll:
+		return time.Time{}
+	default:
+		return sessionStart
+	}
+}
+
+func (a *App) scopeSince() time.Time {
+	return a.scope.since(a.tracker.SessionStart(), time.Now())
+}
+
+func (a *App) cycleScope() {
+	a.scope = a.scope.next()
+	a.modelTable.SetTitle(" Models — " + a.scope.String() + " ")
+	a.cacheBoard.SetTitle(" Cache — " + a.scope.String() + " ")
+	a.projectTable.SetTitle(" Projects — " + a.scope.String() + " ")
+	a.clientTable.SetTitle(" Clients — " + a.scope.String() + " ")
+	a.nodeTable.SetTitle(" Nodes — " + a.scope.String() + " ")
+	a.heatTable.SetTitle(" Heatmap — " + a.scope.String() + " ")
+	a.updateRequestTitle()
+	a.setStatus("Scope: " + a.scope.String())
+}
+
+func (a *App) updateRequestTitle() {
+	title := " Request Log — " + a.scope.String()
+	if a.filter != filterAll {
+		title += " [red](" + a.filter.String() + " only)[-]"
+	}
+	if a.threads {
+		title += " [teal](by conversation)[-]"
+	}
+	if a.follow {
+		title += " [green]● live[-]"
+	} else {
+		title += " [gray]‖ paused — <f> to follow[-]"
+	}
+	a.requestTable.SetTitle(title + " ")
+}
@@ -0,0 +1,32 @@
+package tui
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gdamore/tcell/v2"
+
+	"miser/internal/slo"
+)
+
+// sloText shows how a model is doing against its latency objective: a red
+// ✗ when breached, a green ✓ when within it, with the share of requests
+// that met it and, unless compact, the latency at its percentile. A window
+// with too few requests to judge is grey, and a model with no objective,
+// or no requests in its window, is "-".
+func sloText(s slo.Status, compact bool) (string, tcell.Color) {
+	if s.Requests == 0 {
+		return "-", tcell.ColorGray
+	}
+	text := fmt.Sprintf("%.0f%%", s.Compliance()*100)
+	if !compact {
+		text += " · p" + strconv.FormatFloat(s.Objective.Percentile, 'f', -1, 64) + " " + formatLatency(s.Observed)
+	}
+	switch {
+	case !s.Judged():
+		return text, tcell.ColorGray
+	case s.Breached():
+		return "✗ " + text, tcell.ColorRed
+	}
+	return "✓ " + text, tcell.ColorGreen
+}
@@ -0,0 +1,115 @@
+package tui
+
+import (
+	"fmt"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"miser/internal/tracker"
+)
+
+// With <g> the request log is threaded: each conversation of two or more
+// requests gets a subtotal row, newest conversation first, with its
+// requests beneath it. A subtotal row is not a request; its entry in
+// requestIDs is the negated ID of its oldest request, so it keeps its
+// place as the log refreshes.
+
+type thread struct {
+	id   string
+	reqs []tracker.Request // newest first
+}
+
+// threadsOf groups newest-first requests by conversation, ordered by each
+// conversation's newest request. Requests without one stand alone.
+func threadsOf(reqs []tracker.Request) []thread {
+	var out []thread
+	index := make(map[string]int)
+	for _, r := range reqs {
+		if r.Conversation != "" {
+			if i, ok := index[r.Conversation]; ok {
+				out[i].reqs = append(out[i].reqs, r)
+				continue
+			}
+			index[r.Conversation] = len(out)
+		}
+		out = append(out, thread{id: r.Conversation, reqs: []tracker.Request{r}})
+	}
+	return out
+}
+
+func (a *App) toggleThreads() {
+	a.threads = !a.threads
+	a.updateRequestTitle()
+	if a.threads {
+		a.setStatus("Request log: grouped by conversation")
+	} else {
+		a.setStatus("Request log: chronological")
+	}
+}
+
+func (a *App) renderThreads(reqs []tracker.Request, compact bool) {
+	row := 1
+	for _, th := range threadsOf(reqs) {
+		nested := len(th.reqs) > 1
+		if nested {
+			a.requestIDs = append(a.requestIDs, -th.reqs[len(th.reqs)-1].ID)
+			a.setThreadRow(row, th, compact)
+			row++
+		}
+		for _, r := range th.reqs {
+			a.requestIDs = append(a.requestIDs, r.ID)
+			a.setRequestRow(row, r, compact, nested)
+			row++
+		}
+	}
+}
+
+// setThreadRow fills a conversation's subtotal row: its requests, tokens
+// and cost, and how long it has run.
+func (a *App) setThreadRow(row int, th thread, compact bool) {
+	var input, output, errors int
+	var cost float64
+	for _, r := range th.reqs {
+		input += r.InputTokens
+		output += r.OutputTokens
+		cost += r.Cost
+		if r.Failed() {
+			errors++
+		}
+	}
+	id := []rune(th.id)
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	status, statusColor := "", tcell.ColorTeal
+	if errors > 0 {
+		status, statusColor = fmt.Sprintf("%d ERR", errors), tcell.ColorRed
+	}
+	span := th.reqs[0].Timestamp.Sub(th.reqs[len(th.reqs)-1].Timestamp)
+	util, utilColor := formatUtil(th.reqs[0].ContextUsed) // where its latest turn stands
+	cells := []struct {
+		text  string
+		color tcell.Color
+		align int
+	}{
+		{" ▾ " + tview.Escape(string(id)) + " ", tcell.ColorTeal, tview.AlignLeft},
+		{fmt.Sprintf(" %d requests ", len(th.reqs)), tcell.ColorTeal, tview.AlignLeft},
+		{" " + clientLabel(th.reqs[0].Client, compact) + " ", tcell.ColorTeal, tview.AlignLeft},
+		{" " + formatTokens(input) + " ", tcell.ColorTeal, tview.AlignRight},
+		{" " + formatTokens(output) + " ", tcell.ColorTeal, tview.AlignRight},
+		{" " + util + " ", utilColor, tview.AlignRight},
+		{" " + formatCost(cost) + " ", costColor(cost), tview.AlignRight},
+		{"", tcell.ColorTeal, tview.AlignRight},
+		{" " + formatLatency(span) + " ", tcell.ColorTeal, tview.AlignRight},
+		{" " + status + " ", statusColor, tview.AlignRight},
+	}
+	for j, c := range cells {
+		a.requestTable.SetCell(row, j,
+			tview.NewTableCell(c.text).
+				SetTextColor(c.color).
+				SetAttributes(tcell.AttrBold).
+				SetAlign(c.align),
+		)
+	}
+}
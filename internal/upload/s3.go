@@ -0,0 +1,126 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// S3 puts objects into a bucket on AWS S3 or an S3-compatible service
+// (MinIO, R2, B2, …), signing requests with AWS Signature Version 4.
+type S3 struct {
+	Bucket       string
+	Endpoint     string // e.g. "https://minio.example.com"; "" means AWS
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string // for temporary credentials
+	Client       *http.Client
+
+	now func() time.Time // for tests
+}
+
+func (s *S3) Upload(ctx context.Context, key, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "miser")
+	sum := sha256.Sum256(body)
+	s.sign(req, hex.EncodeToString(sum[:]))
+	return do(s.Client, req)
+}
+
+// objectURL addresses AWS buckets by virtual host, except names with dots
+// (which break TLS), and other services by path, which all of them accept.
+func (s *S3) objectURL(key string) string {
+	path := "/" + escapePath(key)
+	switch {
+	case s.Endpoint != "":
+		return strings.TrimSuffix(s.Endpoint, "/") + "/" + escapePath(s.Bucket) + path
+	case strings.Contains(s.Bucket, "."):
+		return "https://s3." + s.Region + ".amazonaws.com/" + s.Bucket + path
+	}
+	return "https://" + s.Bucket + ".s3." + s.Region + ".amazonaws.com" + path
+}
+
+// sign adds the x-amz-* headers and an Authorization header signing the
+// method, path, query and every header already set.
+func (s *S3) sign(req *http.Request, payloadHash string) {
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	t := now().UTC()
+	stamp, day := t.Format("20060102T150405Z"), t.Format("20060102")
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, vv := range req.Header {
+		if k == "User-Agent" {
+			continue // proxies may rewrite it
+		}
+		headers[strings.ToLower(k)] = strings.Join(vv, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+	var canonical strings.Builder
+	for _, k := range names {
+		canonical.WriteString(k + ":" + strings.TrimSpace(headers[k]) + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	request := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonical.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + s.Region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(request))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), day)
+	for _, part := range []string{s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+hex.EncodeToString(hmacSHA256(key, toSign)))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// escapePath percent-encodes everything but unreserved characters and
+// slashes, as SigV4 expects of S3 keys.
+func escapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,158 @@
+// Package upload exports requests on a schedule and stores each file in
+// S3-compatible object storage or at any endpoint that accepts HTTP PUT,
+// so a proxy on a short-lived machine keeps its usage data elsewhere.
+package upload
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"miser/internal/export"
+	"miser/internal/schedule"
+	"miser/internal/tracker"
+)
+
+// Uploader stores one object under key.
+type Uploader interface {
+	Upload(ctx context.Context, key, contentType string, body []byte) error
+}
+
+// HTTP PUTs each object to Base + "/" + key.
+type HTTP struct {
+	Base   string      // scheme and host, e.g. "https://example.com"
+	Query  string      // appended to every URL if set, e.g. "token=…"
+	Header http.Header // extra request headers, e.g. Authorization
+	Client *http.Client
+}
+
+func (h *HTTP) Upload(ctx context.Context, key, contentType string, body []byte) error {
+	u := h.Base + "/" + key
+	if h.Query != "" {
+		u += "?" + h.Query
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, vv := range h.Header {
+		for _, v := range vv {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "miser")
+	return do(h.Client, req)
+}
+
+// do sends req and turns a non-2xx response into an error quoting the
+// start of its body.
+func do(hc *http.Client, req *http.Request) error {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if m := strings.TrimSpace(string(msg)); m != "" {
+		return fmt.Errorf("PUT %s: %s: %s", req.URL.Redacted(), resp.Status, m)
+	}
+	return fmt.Errorf("PUT %s: %s", req.URL.Redacted(), resp.Status)
+}
+
+// FlushTimeout bounds the last upload a Job makes when it is stopped.
+const FlushTimeout = 15 * time.Second
+
+// Job exports requests when its schedule comes due and uploads the file.
+type Job struct {
+	When   schedule.Schedule
+	Format export.Format
+	// All uploads every loaded request each time; otherwise only those
+	// recorded since the last successful upload are, so each object holds
+	// new requests and a failed upload is retried with the next one.
+	All  bool
+	Key  string // object key; see Expand
+	Host string // for {host}
+	To   Uploader
+	// Done, if set, is told about each upload attempt: how many requests
+	// went to key, or why it failed.
+	Done func(n int, key string, err error)
+
+	lastID int // highest request ID uploaded
+}
+
+// Run uploads on schedule until ctx is done, then once more so requests
+// since the last upload are not lost with the machine.
+func (j *Job) Run(ctx context.Context, t *tracker.Tracker) {
+	for {
+		var due <-chan time.Time
+		var timer *time.Timer
+		if next := j.When.Next(time.Now()); !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			due = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			fctx, cancel := context.WithTimeout(context.Background(), FlushTimeout)
+			j.upload(fctx, t, time.Now())
+			cancel()
+			return
+		case now := <-due:
+			j.upload(ctx, t, now)
+		}
+	}
+}
+
+// upload sends what there is to send, if anything.
+func (j *Job) upload(ctx context.Context, t *tracker.Tracker, now time.Time) {
+	fresh := t.GetRequestsAfter(j.lastID)
+	if len(fresh) == 0 {
+		return
+	}
+	reqs := fresh
+	if j.All {
+		reqs = t.GetRequests()
+	}
+	key := Expand(j.Key, now, j.Host, j.Format)
+	var buf bytes.Buffer
+	err := export.Write(&buf, j.Format, reqs)
+	if err == nil {
+		err = j.To.Upload(ctx, key, j.Format.ContentType(), buf.Bytes())
+	}
+	if err == nil {
+		j.lastID = fresh[len(fresh)-1].ID
+	}
+	if j.Done != nil {
+		j.Done(len(reqs), key, err)
+	}
+}
+
+// Expand fills in an object key: {time} is the UTC time as
+// 20060102T150405Z, {date} the local date, {host} the host name and {ext}
+// the format's extension. A key ending in "/" (or empty) gets the name
+// "miser-{host}-{time}.{ext}".
+func Expand(key string, now time.Time, host string, f export.Format) string {
+	if key == "" || strings.HasSuffix(key, "/") {
+		key += "miser-{host}-{time}.{ext}"
+	}
+	return strings.NewReplacer(
+		"{time}", now.UTC().Format("20060102T150405Z"),
+		"{date}", now.Format("2006-01-02"),
+		"{host}", host,
+		"{ext}", string(f),
+	).Replace(key)
+}